@@ -271,6 +271,7 @@ func (h *DomainHandler) GetDomainSecurity(w http.ResponseWriter, r *http.Request
 			"reject_score":      dom.SpamRejectScore,
 			"quarantine_score":  dom.SpamQuarantineScore,
 			"learning_enabled":  dom.SpamLearningEnabled,
+			"backend":           dom.SpamBackend,
 		},
 		"greylist": map[string]interface{}{
 			"enabled":           dom.GreylistEnabled,
@@ -297,6 +298,9 @@ func (h *DomainHandler) GetDomainSecurity(w http.ResponseWriter, r *http.Request
 			"ip_blacklist_enabled":       dom.AuthIPBlacklistEnabled,
 			"cleanup_interval":           dom.AuthCleanupInterval,
 		},
+		"forwarding": map[string]interface{}{
+			"external_enabled": dom.ForwardExternalEnabled,
+		},
 	}
 
 	h.writeJSON(w, security)
@@ -466,6 +470,15 @@ func (h *DomainHandler) UpdateDomainSecurity(w http.ResponseWriter, r *http.Requ
 				updated.SpamLearningEnabled = b
 			}
 		}
+		if v, exists := spam["backend"]; exists {
+			if s, ok := v.(string); ok {
+				if s != domain.SpamBackendSpamAssassin && s != domain.SpamBackendRspamd {
+					h.writeError(w, "spam backend must be spamassassin or rspamd", http.StatusBadRequest)
+					return
+				}
+				updated.SpamBackend = s
+			}
+		}
 	}
 
 	// Update Greylist settings
@@ -575,6 +588,15 @@ func (h *DomainHandler) UpdateDomainSecurity(w http.ResponseWriter, r *http.Requ
 		}
 	}
 
+	// Update Forwarding settings
+	if forwarding, ok := securityUpdates["forwarding"].(map[string]interface{}); ok {
+		if v, exists := forwarding["external_enabled"]; exists {
+			if b, ok := v.(bool); ok {
+				updated.ForwardExternalEnabled = b
+			}
+		}
+	}
+
 	// Update domain in database
 	if err := h.domainRepo.Update(&updated); err != nil {
 		h.logger.Error("failed to update domain security",
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/btafoya/gomailserver/internal/api/middleware"
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/service"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// AppPasswordHandler handles app-specific password endpoints
+type AppPasswordHandler struct {
+	service *service.AppPasswordService
+	logger  *zap.Logger
+}
+
+// NewAppPasswordHandler creates a new app password handler
+func NewAppPasswordHandler(service *service.AppPasswordService, logger *zap.Logger) *AppPasswordHandler {
+	return &AppPasswordHandler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+// AppPasswordRequest represents an app password creation request
+type AppPasswordRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes,omitempty"` // imap, smtp, dav; all when empty
+}
+
+// AppPasswordResponse returns a new app password together with its secret
+// The secret is only ever returned here.
+type AppPasswordResponse struct {
+	*domain.AppPassword
+	Password string `json:"password"`
+}
+
+// List retrieves the app passwords of a user
+func (h *AppPasswordHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		middleware.RespondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	appPasswords, err := h.service.ListByUser(r.Context(), userID)
+	if err != nil {
+		h.logger.Error("Failed to list app passwords", zap.Int64("user_id", userID), zap.Error(err))
+		middleware.RespondError(w, http.StatusInternalServerError, "Failed to retrieve app passwords")
+		return
+	}
+
+	middleware.RespondSuccess(w, appPasswords, "App passwords retrieved successfully")
+}
+
+// Create generates an app password for a user
+func (h *AppPasswordHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		middleware.RespondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var req AppPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	appPassword, secret, err := h.service.Create(r.Context(), userID, req.Name, req.Scopes)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidAppPassword) {
+			middleware.RespondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.logger.Error("Failed to create app password",
+			zap.Int64("user_id", userID),
+			zap.String("name", req.Name),
+			zap.Error(err),
+		)
+		middleware.RespondError(w, http.StatusInternalServerError, "Failed to create app password")
+		return
+	}
+
+	h.logger.Info("App password created",
+		zap.Int64("user_id", userID),
+		zap.String("name", appPassword.Name),
+		zap.String("scopes", appPassword.Scopes),
+	)
+
+	middleware.RespondCreated(w, AppPasswordResponse{AppPassword: appPassword, Password: secret}, "App password created successfully")
+}
+
+// Delete revokes an app password
+func (h *AppPasswordHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		middleware.RespondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	appPasswordID, err := strconv.ParseInt(chi.URLParam(r, "appPasswordID"), 10, 64)
+	if err != nil {
+		middleware.RespondError(w, http.StatusBadRequest, "Invalid app password ID")
+		return
+	}
+
+	if err := h.service.Delete(r.Context(), userID, appPasswordID); err != nil {
+		h.logger.Error("Failed to delete app password", zap.Int64("id", appPasswordID), zap.Error(err))
+		middleware.RespondError(w, http.StatusNotFound, "App password not found")
+		return
+	}
+
+	h.logger.Info("App password revoked", zap.Int64("user_id", userID), zap.Int64("id", appPasswordID))
+
+	middleware.RespondNoContent(w)
+}
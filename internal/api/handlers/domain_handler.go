@@ -3,6 +3,7 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"net/mail"
 	"strconv"
 
 	"github.com/btafoya/gomailserver/internal/api/middleware"
@@ -41,6 +42,10 @@ type DomainRequest struct {
 	DMARCReportEmail  string `json:"dmarc_report_email,omitempty"`
 	DKIMSigningEnabled bool  `json:"dkim_signing_enabled"`
 	DKIMVerifyEnabled  bool  `json:"dkim_verify_enabled"`
+	OutboundPool       string `json:"outbound_pool,omitempty"`
+	SubaddressSeparator     *string `json:"subaddress_separator,omitempty"`
+	SubaddressFolders       *bool   `json:"subaddress_folders,omitempty"`
+	SubaddressCreateFolders *bool   `json:"subaddress_create_folders,omitempty"`
 }
 
 // DomainResponse represents a domain in API responses
@@ -58,6 +63,10 @@ type DomainResponse struct {
 	DMARCReportEmail   string `json:"dmarc_report_email,omitempty"`
 	DKIMSigningEnabled bool   `json:"dkim_signing_enabled"`
 	DKIMVerifyEnabled  bool   `json:"dkim_verify_enabled"`
+	OutboundPool       string `json:"outbound_pool,omitempty"`
+	SubaddressSeparator     string `json:"subaddress_separator"`
+	SubaddressFolders       bool   `json:"subaddress_folders"`
+	SubaddressCreateFolders bool   `json:"subaddress_create_folders"`
 	CreatedAt          string `json:"created_at"`
 	UpdatedAt          string `json:"updated_at"`
 }
@@ -108,12 +117,29 @@ func (h *DomainHandler) Create(w http.ResponseWriter, r *http.Request) {
 		DMARCReportEmail:   req.DMARCReportEmail,
 		DKIMSigningEnabled: req.DKIMSigningEnabled,
 		DKIMVerifyEnabled:  req.DKIMVerifyEnabled,
+		OutboundPool:       req.OutboundPool,
+
+		ForwardExternalEnabled: true,
+		SubaddressSeparator:    service.DefaultSubaddressSeparator,
 	}
 
 	// Convert CatchallEmail string to *string
 	if req.CatchallEmail != "" {
 		newDomain.CatchallEmail = &req.CatchallEmail
 	}
+	if req.SubaddressSeparator != nil {
+		newDomain.SubaddressSeparator = *req.SubaddressSeparator
+	}
+	if req.SubaddressFolders != nil {
+		newDomain.SubaddressFolders = *req.SubaddressFolders
+	}
+	if req.SubaddressCreateFolders != nil {
+		newDomain.SubaddressCreateFolders = *req.SubaddressCreateFolders
+	}
+	if msg := validateRouting(newDomain); msg != "" {
+		middleware.RespondError(w, http.StatusBadRequest, msg)
+		return
+	}
 
 	// Set defaults
 	if newDomain.Status == "" {
@@ -194,6 +220,19 @@ func (h *DomainHandler) Update(w http.ResponseWriter, r *http.Request) {
 	} else {
 		existingDomain.CatchallEmail = nil
 	}
+	if req.SubaddressSeparator != nil {
+		existingDomain.SubaddressSeparator = *req.SubaddressSeparator
+	}
+	if req.SubaddressFolders != nil {
+		existingDomain.SubaddressFolders = *req.SubaddressFolders
+	}
+	if req.SubaddressCreateFolders != nil {
+		existingDomain.SubaddressCreateFolders = *req.SubaddressCreateFolders
+	}
+	if msg := validateRouting(existingDomain); msg != "" {
+		middleware.RespondError(w, http.StatusBadRequest, msg)
+		return
+	}
 
 	if req.MaxUsers > 0 {
 		existingDomain.MaxUsers = req.MaxUsers
@@ -221,6 +260,7 @@ func (h *DomainHandler) Update(w http.ResponseWriter, r *http.Request) {
 	}
 	existingDomain.DKIMSigningEnabled = req.DKIMSigningEnabled
 	existingDomain.DKIMVerifyEnabled = req.DKIMVerifyEnabled
+	existingDomain.OutboundPool = req.OutboundPool
 
 	// Update domain
 	err = h.service.Update(r.Context(), existingDomain)
@@ -288,6 +328,23 @@ func (h *DomainHandler) GenerateDKIM(w http.ResponseWriter, r *http.Request) {
 	}, "DKIM generation endpoint ready")
 }
 
+// validateRouting checks a domain's catch-all and subaddress settings,
+// returning a message for the client if they are invalid
+func validateRouting(d *domain.Domain) string {
+	if d.CatchallEmail != nil {
+		addr, err := mail.ParseAddress(*d.CatchallEmail)
+		if err != nil {
+			return "Invalid catch-all address"
+		}
+		normalized := service.NormalizeAddress(addr.Address)
+		d.CatchallEmail = &normalized
+	}
+	if err := service.ValidateSubaddressSeparator(d.SubaddressSeparator); err != nil {
+		return "Subaddress separator may only contain the characters + - _ = # ~"
+	}
+	return ""
+}
+
 // domainToResponse converts a domain model to API response format
 func domainToResponse(d *domain.Domain) *DomainResponse {
 	// Convert *string to string for CatchallEmail
@@ -310,6 +367,10 @@ func domainToResponse(d *domain.Domain) *DomainResponse {
 		DMARCReportEmail:   d.DMARCReportEmail,
 		DKIMSigningEnabled: d.DKIMSigningEnabled,
 		DKIMVerifyEnabled:  d.DKIMVerifyEnabled,
+		OutboundPool:       d.OutboundPool,
+		SubaddressSeparator:     d.SubaddressSeparator,
+		SubaddressFolders:       d.SubaddressFolders,
+		SubaddressCreateFolders: d.SubaddressCreateFolders,
 		CreatedAt:          d.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
 		UpdatedAt:          d.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
 	}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/btafoya/gomailserver/internal/api/middleware"
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/service"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// MailboxACLHandler handles shared mailbox grant endpoints
+type MailboxACLHandler struct {
+	service        *service.ACLService
+	mailboxService *service.MailboxService
+	logger         *zap.Logger
+}
+
+// NewMailboxACLHandler creates a new mailbox ACL handler
+func NewMailboxACLHandler(service *service.ACLService, mailboxService *service.MailboxService, logger *zap.Logger) *MailboxACLHandler {
+	return &MailboxACLHandler{
+		service:        service,
+		mailboxService: mailboxService,
+		logger:         logger,
+	}
+}
+
+// MailboxACLRequest represents a grant on one of a user's mailboxes
+type MailboxACLRequest struct {
+	Identifier string `json:"identifier"` // user address or "anyone"
+	Rights     string `json:"rights"`     // RFC 4314 rights, optionally prefixed with + or -
+}
+
+// List retrieves the grants on a user's mailbox
+func (h *MailboxACLHandler) List(w http.ResponseWriter, r *http.Request) {
+	mailbox, ok := h.mailbox(w, r)
+	if !ok {
+		return
+	}
+
+	acls, err := h.service.List(mailbox.ID)
+	if err != nil {
+		h.logger.Error("Failed to list mailbox ACLs", zap.Int64("mailbox_id", mailbox.ID), zap.Error(err))
+		middleware.RespondError(w, http.StatusInternalServerError, "Failed to retrieve mailbox ACLs")
+		return
+	}
+
+	middleware.RespondSuccess(w, acls, "Mailbox ACLs retrieved successfully")
+}
+
+// Set grants, changes or revokes an identifier's rights on a user's mailbox
+func (h *MailboxACLHandler) Set(w http.ResponseWriter, r *http.Request) {
+	mailbox, ok := h.mailbox(w, r)
+	if !ok {
+		return
+	}
+
+	var req MailboxACLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.service.Set(mailbox.ID, req.Identifier, req.Rights); err != nil {
+		if !h.respondACLError(w, err) {
+			h.logger.Error("Failed to set mailbox ACL",
+				zap.Int64("mailbox_id", mailbox.ID),
+				zap.String("identifier", req.Identifier),
+				zap.Error(err),
+			)
+			middleware.RespondError(w, http.StatusInternalServerError, "Failed to set mailbox ACL")
+		}
+		return
+	}
+
+	acls, err := h.service.List(mailbox.ID)
+	if err != nil {
+		middleware.RespondError(w, http.StatusInternalServerError, "Failed to retrieve mailbox ACLs")
+		return
+	}
+
+	middleware.RespondSuccess(w, acls, "Mailbox ACL updated successfully")
+}
+
+// Delete revokes all of an identifier's rights on a user's mailbox
+func (h *MailboxACLHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	mailbox, ok := h.mailbox(w, r)
+	if !ok {
+		return
+	}
+
+	identifier := chi.URLParam(r, "identifier")
+	if err := h.service.Delete(mailbox.ID, identifier); err != nil {
+		if !h.respondACLError(w, err) {
+			h.logger.Error("Failed to delete mailbox ACL", zap.Int64("mailbox_id", mailbox.ID), zap.Error(err))
+			middleware.RespondError(w, http.StatusInternalServerError, "Failed to delete mailbox ACL")
+		}
+		return
+	}
+
+	h.logger.Info("Mailbox ACL deleted", zap.Int64("mailbox_id", mailbox.ID), zap.String("identifier", identifier))
+
+	middleware.RespondNoContent(w)
+}
+
+// mailbox loads the mailbox in the URL, which must belong to the user in the URL
+func (h *MailboxACLHandler) mailbox(w http.ResponseWriter, r *http.Request) (*domain.Mailbox, bool) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		middleware.RespondError(w, http.StatusBadRequest, "Invalid user ID")
+		return nil, false
+	}
+	mailboxID, err := strconv.ParseInt(chi.URLParam(r, "mailboxID"), 10, 64)
+	if err != nil {
+		middleware.RespondError(w, http.StatusBadRequest, "Invalid mailbox ID")
+		return nil, false
+	}
+
+	mailbox, err := h.mailboxService.GetByID(mailboxID)
+	if err != nil || mailbox.UserID != userID {
+		middleware.RespondError(w, http.StatusNotFound, "Mailbox not found")
+		return nil, false
+	}
+	return mailbox, true
+}
+
+// respondACLError writes a 400 for invalid grants and reports whether it did
+func (h *MailboxACLHandler) respondACLError(w http.ResponseWriter, err error) bool {
+	if errors.Is(err, service.ErrInvalidRights) || errors.Is(err, service.ErrInvalidACLIdentifier) ||
+		errors.Is(err, service.ErrOwnerRights) {
+		middleware.RespondError(w, http.StatusBadRequest, err.Error())
+		return true
+	}
+	return false
+}
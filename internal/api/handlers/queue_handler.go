@@ -28,18 +28,20 @@ func NewQueueHandler(service *service.QueueService, logger *zap.Logger) *QueueHa
 
 // QueueItemResponse represents a queued message in API responses
 type QueueItemResponse struct {
-	ID           int64    `json:"id"`
-	Sender       string   `json:"sender"`
-	Recipients   []string `json:"recipients"`
-	MessageID    string   `json:"message_id"`
-	MessagePath  string   `json:"message_path"`
-	Status       string   `json:"status"`
-	RetryCount   int      `json:"retry_count"`
-	MaxRetries   int      `json:"max_retries"`
-	NextRetry    string   `json:"next_retry,omitempty"`
-	ErrorMessage string   `json:"error_message,omitempty"`
-	CreatedAt    string   `json:"created_at"`
-	UpdatedAt    string   `json:"updated_at"`
+	ID           int64                    `json:"id"`
+	Sender       string                   `json:"sender"`
+	Recipients   []string                 `json:"recipients"`
+	MessageID    string                   `json:"message_id"`
+	MessagePath  string                   `json:"message_path"`
+	Status       string                   `json:"status"`
+	RetryCount   int                      `json:"retry_count"`
+	MaxRetries   int                      `json:"max_retries"`
+	NextRetry    string                   `json:"next_retry,omitempty"`
+	ErrorMessage string                   `json:"error_message,omitempty"`
+	Spam         *domain.SpamVerdict      `json:"spam,omitempty"`
+	Deliveries   []domain.RecipientStatus `json:"deliveries,omitempty"`
+	CreatedAt    string                   `json:"created_at"`
+	UpdatedAt    string                   `json:"updated_at"`
 }
 
 // List retrieves all queued messages
@@ -52,8 +54,8 @@ func (h *QueueHandler) List(w http.ResponseWriter, r *http.Request) {
 	var err error
 
 	if status != "" {
-		// TODO: Filter by status
-		items, err = h.service.GetPendingItems(r.Context())
+		// status=held lists quarantined messages
+		items, err = h.service.GetItemsByStatus(r.Context(), status)
 	} else {
 		// Get all queue items
 		items, err = h.service.GetPendingItems(r.Context())
@@ -171,6 +173,15 @@ func queueItemToResponse(item *domain.QueueItem) *QueueItemResponse {
 	if item.NextRetry != nil {
 		response.NextRetry = item.NextRetry.Format("2006-01-02T15:04:05Z07:00")
 	}
+	if deliveries, err := service.DecodeRecipientStatus(item.RcptStatus); err == nil {
+		response.Deliveries = deliveries
+	}
+	if item.Spam != "" {
+		var spam domain.SpamVerdict
+		if err := json.Unmarshal([]byte(item.Spam), &spam); err == nil {
+			response.Spam = &spam
+		}
+	}
 
 	return response
 }
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/btafoya/gomailserver/internal/api/middleware"
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/service"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// SenderGrantHandler handles send-as and send-on-behalf grant endpoints
+type SenderGrantHandler struct {
+	service *service.SenderGrantService
+	logger  *zap.Logger
+}
+
+// NewSenderGrantHandler creates a new sender grant handler
+func NewSenderGrantHandler(service *service.SenderGrantService, logger *zap.Logger) *SenderGrantHandler {
+	return &SenderGrantHandler{
+		service: service,
+		logger:  logger,
+	}
+}
+
+// SenderGrantRequest represents a sender grant creation request
+type SenderGrantRequest struct {
+	Address   string `json:"address"`
+	GrantType string `json:"grant_type,omitempty"`
+}
+
+// List retrieves the sender grants held by a user
+func (h *SenderGrantHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		middleware.RespondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	grants, err := h.service.ListByUser(r.Context(), userID)
+	if err != nil {
+		h.logger.Error("Failed to list sender grants", zap.Int64("user_id", userID), zap.Error(err))
+		middleware.RespondError(w, http.StatusInternalServerError, "Failed to retrieve sender grants")
+		return
+	}
+
+	middleware.RespondSuccess(w, grants, "Sender grants retrieved successfully")
+}
+
+// Create grants a user send-as or send-on-behalf rights for an address
+func (h *SenderGrantHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		middleware.RespondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var req SenderGrantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	grant := &domain.SenderGrant{
+		UserID:    userID,
+		Address:   req.Address,
+		GrantType: req.GrantType,
+	}
+
+	if err := h.service.Create(r.Context(), grant); err != nil {
+		if errors.Is(err, service.ErrInvalidSenderGrant) {
+			middleware.RespondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.logger.Error("Failed to create sender grant",
+			zap.Int64("user_id", userID),
+			zap.String("address", req.Address),
+			zap.Error(err),
+		)
+		middleware.RespondError(w, http.StatusInternalServerError, "Failed to create sender grant")
+		return
+	}
+
+	h.logger.Info("Sender grant created",
+		zap.Int64("user_id", userID),
+		zap.String("address", grant.Address),
+		zap.String("grant_type", grant.GrantType),
+	)
+
+	middleware.RespondCreated(w, grant, "Sender grant created successfully")
+}
+
+// Delete revokes a sender grant
+func (h *SenderGrantHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		middleware.RespondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	grantID, err := strconv.ParseInt(chi.URLParam(r, "grantID"), 10, 64)
+	if err != nil {
+		middleware.RespondError(w, http.StatusBadRequest, "Invalid grant ID")
+		return
+	}
+
+	if err := h.service.Delete(r.Context(), userID, grantID); err != nil {
+		h.logger.Error("Failed to delete sender grant", zap.Int64("id", grantID), zap.Error(err))
+		middleware.RespondError(w, http.StatusNotFound, "Sender grant not found")
+		return
+	}
+
+	h.logger.Info("Sender grant deleted", zap.Int64("user_id", userID), zap.Int64("id", grantID))
+
+	middleware.RespondNoContent(w)
+}
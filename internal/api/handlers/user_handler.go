@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/btafoya/gomailserver/internal/api/middleware"
 	"github.com/btafoya/gomailserver/internal/domain"
@@ -34,11 +36,15 @@ type UserRequest struct {
 	DisplayName     string `json:"display_name,omitempty"`
 	DomainID        int64  `json:"domain_id"`
 	Quota           int64  `json:"quota,omitempty"`
+	MessageQuota    *int64 `json:"message_quota,omitempty"` // 0 is unlimited
 	Status          string `json:"status,omitempty"`
-	ForwardTo        string `json:"forward_to,omitempty"`
-	AutoReplyEnabled bool   `json:"auto_reply_enabled"`
+	ForwardTo        *string `json:"forward_to,omitempty"`
+	ForwardKeepCopy  *bool   `json:"forward_keep_copy,omitempty"`
+	AutoReplyEnabled *bool  `json:"auto_reply_enabled,omitempty"`
 	AutoReplySubject string `json:"auto_reply_subject,omitempty"`
 	AutoReplyBody    string `json:"auto_reply_body,omitempty"`
+	AutoReplyStart   *time.Time `json:"auto_reply_start,omitempty"`
+	AutoReplyEnd     *time.Time `json:"auto_reply_end,omitempty"`
 	SpamThreshold    float64 `json:"spam_threshold,omitempty"`
 }
 
@@ -52,11 +58,15 @@ type UserResponse struct {
 	DomainName       string  `json:"domain_name,omitempty"`
 	Quota            int64   `json:"quota"`
 	UsedQuota        int64   `json:"used_quota"`
+	MessageQuota     int64   `json:"message_quota"`
 	Status           string  `json:"status"`
 	ForwardTo        string  `json:"forward_to,omitempty"`
+	ForwardKeepCopy  bool    `json:"forward_keep_copy"`
 	AutoReplyEnabled bool    `json:"auto_reply_enabled"`
 	AutoReplySubject string  `json:"auto_reply_subject,omitempty"`
 	AutoReplyBody    string  `json:"auto_reply_body,omitempty"`
+	AutoReplyStart   string  `json:"auto_reply_start,omitempty"`
+	AutoReplyEnd     string  `json:"auto_reply_end,omitempty"`
 	SpamThreshold    float64 `json:"spam_threshold"`
 	TOTPEnabled      bool    `json:"totp_enabled"`
 	CreatedAt        string  `json:"created_at"`
@@ -118,13 +128,21 @@ func (h *UserHandler) Create(w http.ResponseWriter, r *http.Request) {
 		DomainID:         req.DomainID,
 		Quota:            req.Quota,
 		Status:           req.Status,
-		ForwardTo:        req.ForwardTo,
-		AutoReplyEnabled: req.AutoReplyEnabled,
+		ForwardKeepCopy:  true,
 		AutoReplySubject: req.AutoReplySubject,
 		AutoReplyBody:    req.AutoReplyBody,
+		AutoReplyStart:   req.AutoReplyStart,
+		AutoReplyEnd:     req.AutoReplyEnd,
 		SpamThreshold:    req.SpamThreshold,
 	}
 
+	if req.MessageQuota != nil {
+		newUser.MessageQuota = *req.MessageQuota
+	}
+	if req.AutoReplyEnabled != nil {
+		newUser.AutoReplyEnabled = *req.AutoReplyEnabled
+	}
+
 	// Set defaults
 	if newUser.Status == "" {
 		newUser.Status = "active"
@@ -132,6 +150,20 @@ func (h *UserHandler) Create(w http.ResponseWriter, r *http.Request) {
 	if newUser.SpamThreshold == 0 {
 		newUser.SpamThreshold = 5.0 // Default spam threshold
 	}
+	if req.ForwardTo != nil {
+		newUser.ForwardTo = *req.ForwardTo
+	}
+	if req.ForwardKeepCopy != nil {
+		newUser.ForwardKeepCopy = *req.ForwardKeepCopy
+	}
+	if err := h.service.ValidateForwarding(newUser); err != nil {
+		h.respondForwardingError(w, err)
+		return
+	}
+	if !validAutoReplyWindow(newUser) {
+		middleware.RespondError(w, http.StatusBadRequest, "Auto reply start must be before its end")
+		return
+	}
 
 	// Create user (password will be hashed by service)
 	err := h.service.CreateWithPassword(r.Context(), newUser, req.Password)
@@ -149,6 +181,11 @@ func (h *UserHandler) Create(w http.ResponseWriter, r *http.Request) {
 		zap.Int64("id", newUser.ID),
 	)
 
+	if newUser.ForwardTo != "" {
+		actorID, actor := auditActor(r)
+		h.service.AuditForwarding(r.Context(), actorID, actor, newUser, "", true)
+	}
+
 	middleware.RespondCreated(w, h.userToResponse(newUser), "User created successfully")
 }
 
@@ -203,22 +240,45 @@ func (h *UserHandler) Update(w http.ResponseWriter, r *http.Request) {
 	if req.Quota > 0 {
 		existingUser.Quota = req.Quota
 	}
+	if req.MessageQuota != nil {
+		existingUser.MessageQuota = *req.MessageQuota
+	}
 	if req.Status != "" {
 		existingUser.Status = req.Status
 	}
-	if req.ForwardTo != "" {
-		existingUser.ForwardTo = req.ForwardTo
+	previousForwardTo, previousKeepCopy := existingUser.ForwardTo, existingUser.ForwardKeepCopy
+	if req.ForwardTo != nil {
+		existingUser.ForwardTo = *req.ForwardTo
+	}
+	if req.ForwardKeepCopy != nil {
+		existingUser.ForwardKeepCopy = *req.ForwardKeepCopy
+	}
+	if req.AutoReplyEnabled != nil {
+		existingUser.AutoReplyEnabled = *req.AutoReplyEnabled
 	}
-	existingUser.AutoReplyEnabled = req.AutoReplyEnabled
 	if req.AutoReplySubject != "" {
 		existingUser.AutoReplySubject = req.AutoReplySubject
 	}
 	if req.AutoReplyBody != "" {
 		existingUser.AutoReplyBody = req.AutoReplyBody
 	}
+	if req.AutoReplyStart != nil {
+		existingUser.AutoReplyStart = req.AutoReplyStart
+	}
+	if req.AutoReplyEnd != nil {
+		existingUser.AutoReplyEnd = req.AutoReplyEnd
+	}
 	if req.SpamThreshold > 0 {
 		existingUser.SpamThreshold = req.SpamThreshold
 	}
+	if err := h.service.ValidateForwarding(existingUser); err != nil {
+		h.respondForwardingError(w, err)
+		return
+	}
+	if !validAutoReplyWindow(existingUser) {
+		middleware.RespondError(w, http.StatusBadRequest, "Auto reply start must be before its end")
+		return
+	}
 
 	// Update user
 	err = h.service.Update(existingUser)
@@ -233,6 +293,9 @@ func (h *UserHandler) Update(w http.ResponseWriter, r *http.Request) {
 		zap.String("email", existingUser.Email),
 	)
 
+	actorID, actor := auditActor(r)
+	h.service.AuditForwarding(r.Context(), actorID, actor, existingUser, previousForwardTo, previousKeepCopy)
+
 	middleware.RespondSuccess(w, h.userToResponse(existingUser), "User updated successfully")
 }
 
@@ -290,6 +353,31 @@ func (h *UserHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
 	middleware.RespondSuccess(w, nil, "Password reset successfully")
 }
 
+// respondForwardingError reports a rejected forwarding configuration
+func (h *UserHandler) respondForwardingError(w http.ResponseWriter, err error) {
+	if errors.Is(err, service.ErrInvalidForwardAddress) || errors.Is(err, service.ErrExternalForwardingDisabled) {
+		middleware.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	h.logger.Error("Failed to validate forwarding", zap.Error(err))
+	middleware.RespondError(w, http.StatusInternalServerError, "Failed to validate forwarding")
+}
+
+// validAutoReplyWindow reports whether a user's auto reply period, when both
+// ends are set, starts before it ends
+func validAutoReplyWindow(u *domain.User) bool {
+	return u.AutoReplyStart == nil || u.AutoReplyEnd == nil || u.AutoReplyStart.Before(*u.AutoReplyEnd)
+}
+
+// auditActor returns the authenticated administrator for audit entries
+func auditActor(r *http.Request) (*int64, string) {
+	email, _ := middleware.GetEmail(r)
+	if id, ok := middleware.GetUserID(r); ok {
+		return &id, email
+	}
+	return nil, email
+}
+
 // userToResponse converts a user model to API response format
 func (h *UserHandler) userToResponse(u *domain.User) *UserResponse {
 	response := &UserResponse{
@@ -300,8 +388,10 @@ func (h *UserHandler) userToResponse(u *domain.User) *UserResponse {
 		DomainID:         u.DomainID,
 		Quota:            u.Quota,
 		UsedQuota:        u.UsedQuota,
+		MessageQuota:     u.MessageQuota,
 		Status:           u.Status,
 		ForwardTo:        u.ForwardTo,
+		ForwardKeepCopy:  u.ForwardKeepCopy,
 		AutoReplyEnabled: u.AutoReplyEnabled,
 		AutoReplySubject: u.AutoReplySubject,
 		AutoReplyBody:    u.AutoReplyBody,
@@ -313,6 +403,12 @@ func (h *UserHandler) userToResponse(u *domain.User) *UserResponse {
 	if u.LastLogin != nil {
 		response.LastLogin = u.LastLogin.Format("2006-01-02T15:04:05Z07:00")
 	}
+	if u.AutoReplyStart != nil {
+		response.AutoReplyStart = u.AutoReplyStart.Format("2006-01-02T15:04:05Z07:00")
+	}
+	if u.AutoReplyEnd != nil {
+		response.AutoReplyEnd = u.AutoReplyEnd.Format("2006-01-02T15:04:05Z07:00")
+	}
 
 	// Get domain name if possible
 	// Note: This would require access to domain service, skipping for now
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -297,6 +298,10 @@ func (h *WebmailHandler) SearchMessages(w http.ResponseWriter, r *http.Request)
 	}
 
 	messages, err := h.messageService.SearchMessages(ctx, int(userID), query)
+	if errors.Is(err, service.ErrInvalidSearchQuery) {
+		middleware.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err != nil {
 		h.logger.Error("failed to search messages", zap.Error(err), zap.String("query", query))
 		middleware.RespondError(w, http.StatusInternalServerError, "failed to search messages")
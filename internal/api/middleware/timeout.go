@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+)
+
+// Timeout cancels the request context after timeout, like chi's Timeout
+// middleware, except for the streaming paths listed, which stay open until
+// the client disconnects
+func Timeout(timeout time.Duration, streams ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		limited := chimiddleware.Timeout(timeout)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, path := range streams {
+				if r.URL.Path == path {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			limited.ServeHTTP(w, r)
+		})
+	}
+}
@@ -10,6 +10,7 @@ import (
 	calendarService "github.com/btafoya/gomailserver/internal/calendar/service"
 	"github.com/btafoya/gomailserver/internal/config"
 	contactService "github.com/btafoya/gomailserver/internal/contact/service"
+	"github.com/btafoya/gomailserver/internal/jmap"
 	"github.com/btafoya/gomailserver/internal/postmark"
 	"github.com/btafoya/gomailserver/internal/repository"
 	repRepository "github.com/btafoya/gomailserver/internal/reputation/repository"
@@ -35,6 +36,7 @@ type Router struct {
 	apiKeyRepo      repository.APIKeyRepository
 	jwtSecret       string
 	webUIConfig     *config.WebUIConfig
+	jmap            *jmap.Handler
 }
 
 // RouterConfig contains dependencies for the API router
@@ -43,9 +45,15 @@ type RouterConfig struct {
 	DomainService      *service.DomainService
 	UserService        *service.UserService
 	AliasService       *service.AliasService
+	SenderGrantService *service.SenderGrantService
+	AppPasswordService *service.AppPasswordService
+	ACLService         *service.ACLService
 	MailboxService     *service.MailboxService
 	MessageService     *service.MessageService
 	QueueService       *service.QueueService
+	SubmissionService  *service.SubmissionService
+	QuotaService       *service.QuotaService
+	ChangeService      *service.ChangeService
 	SetupService       *service.SetupService
 	SettingsService    *service.SettingsService
 	PGPService         *service.PGPService
@@ -79,10 +87,13 @@ type RouterConfig struct {
 	Alerts          *repService.AlertsService
 	APIKeyRepo      repository.APIKeyRepository
 	RateLimitRepo   repository.RateLimitRepository
-	DB              *sql.DB
-	JWTSecret       string
-	CORSOrigins     []string
-	WebUIConfig     *config.WebUIConfig
+	// JMAP repositories
+	BlobRepo            repository.BlobRepository
+	EmailSubmissionRepo repository.EmailSubmissionRepository
+	DB                  *sql.DB
+	JWTSecret           string
+	CORSOrigins         []string
+	WebUIConfig         *config.WebUIConfig
 }
 
 // NewRouter creates a new API router with all routes configured
@@ -106,7 +117,7 @@ func NewRouter(config RouterConfig) *Router {
 	r.Use(chimiddleware.RealIP)
 	r.Use(middleware.Logger(config.Logger))
 	r.Use(chimiddleware.Recoverer)
-	r.Use(chimiddleware.Timeout(60 * time.Second))
+	r.Use(middleware.Timeout(60*time.Second, "/jmap/eventsource"))
 
 	// CORS configuration
 	r.Use(cors.Handler(cors.Options{
@@ -182,6 +193,24 @@ func NewRouter(config RouterConfig) *Router {
 				r.Put("/{id}", userHandler.Update)
 				r.Delete("/{id}", userHandler.Delete)
 				r.Post("/{id}/password", userHandler.ResetPassword)
+
+				// Send-as / send-on-behalf grants
+				senderGrantHandler := handlers.NewSenderGrantHandler(config.SenderGrantService, config.Logger)
+				r.Get("/{id}/sender-grants", senderGrantHandler.List)
+				r.Post("/{id}/sender-grants", senderGrantHandler.Create)
+				r.Delete("/{id}/sender-grants/{grantID}", senderGrantHandler.Delete)
+
+				// App passwords for IMAP, SMTP and DAV clients
+				appPasswordHandler := handlers.NewAppPasswordHandler(config.AppPasswordService, config.Logger)
+				r.Get("/{id}/app-passwords", appPasswordHandler.List)
+				r.Post("/{id}/app-passwords", appPasswordHandler.Create)
+				r.Delete("/{id}/app-passwords/{appPasswordID}", appPasswordHandler.Delete)
+
+				// Shared mailbox grants (IMAP ACLs)
+				mailboxACLHandler := handlers.NewMailboxACLHandler(config.ACLService, config.MailboxService, config.Logger)
+				r.Get("/{id}/mailboxes/{mailboxID}/acl", mailboxACLHandler.List)
+				r.Put("/{id}/mailboxes/{mailboxID}/acl", mailboxACLHandler.Set)
+				r.Delete("/{id}/mailboxes/{mailboxID}/acl/{identifier}", mailboxACLHandler.Delete)
 			})
 
 			// Alias management
@@ -418,9 +447,36 @@ func NewRouter(config RouterConfig) *Router {
 		})
 	})
 
+	// JMAP (RFC 8620, RFC 8621) with the same authentication as /api/v1
+	r.jmap = jmap.NewHandler(
+		config.UserService,
+		config.MailboxService,
+		config.MessageService,
+		config.ChangeService,
+		config.SubmissionService,
+		config.BlobRepo,
+		config.EmailSubmissionRepo,
+		config.Logger,
+	)
+	if config.QuotaService != nil {
+		r.jmap.SetQuotaService(config.QuotaService)
+	}
+	r.Get("/.well-known/jmap", r.jmap.WellKnown)
+	r.Route("/jmap", func(jr chi.Router) {
+		jr.Use(middleware.Auth(config.JWTSecret, config.APIKeyRepo, config.Logger))
+		jr.Use(middleware.RateLimit(config.RateLimitRepo, config.Logger))
+
+		jr.Get("/session", r.jmap.Session)
+		jr.Post("/api", r.jmap.API)
+		jr.Post("/upload/{accountId}", r.jmap.Upload)
+		jr.Post("/upload/{accountId}/", r.jmap.Upload)
+		jr.Get("/download/{accountId}/{blobId}/{name}", r.jmap.Download)
+		jr.Get("/eventsource", r.jmap.EventSource)
+	})
+
 	// PostmarkApp API compatibility endpoints
 	// Mount at root level for PostmarkApp client compatibility
-	r.Mount("/", postmark.NewRouter(config.DB, config.QueueService, config.Logger))
+	r.Mount("/", postmark.NewRouter(config.DB, config.SubmissionService, config.Logger))
 
 	return r
 }
@@ -12,6 +12,7 @@ import (
 	"github.com/btafoya/gomailserver/internal/config"
 	contactService "github.com/btafoya/gomailserver/internal/contact/service"
 	"github.com/btafoya/gomailserver/internal/database"
+	"github.com/btafoya/gomailserver/internal/jmap"
 	"github.com/btafoya/gomailserver/internal/repository"
 	repRepository "github.com/btafoya/gomailserver/internal/reputation/repository"
 	repService "github.com/btafoya/gomailserver/internal/reputation/service"
@@ -37,12 +38,19 @@ func NewServer(
 	domainRepo repository.DomainRepository,
 	userRepo repository.UserRepository,
 	aliasRepo repository.AliasRepository,
+	senderGrantRepo repository.SenderGrantRepository,
+	appPasswordRepo repository.AppPasswordRepository,
+	mailboxACLRepo repository.MailboxACLRepository,
 	mailboxRepo repository.MailboxRepository,
 	messageRepo repository.MessageRepository,
 	queueRepo repository.QueueRepository,
 	apiKeyRepo repository.APIKeyRepository,
 	rateLimitRepo repository.RateLimitRepository,
 	webhookRepo repository.WebhookRepository,
+	changeService *service.ChangeService,
+	blobRepo repository.BlobRepository,
+	emailSubmissionRepo repository.EmailSubmissionRepository,
+	submissionService *service.SubmissionService,
 	contactService *contactService.ContactService,
 	addressbookService *contactService.AddressbookService,
 	calendarService *calendarService.CalendarService,
@@ -60,7 +68,10 @@ func NewServer(
 	domainService := service.NewDomainService(domainRepo)
 	userService := service.NewUserService(userRepo, domainRepo, logger)
 	aliasService := service.NewAliasService(aliasRepo)
+	senderGrantService := service.NewSenderGrantService(senderGrantRepo, userRepo)
+	appPasswordService := service.NewAppPasswordService(appPasswordRepo, userRepo, domainRepo, logger)
 	mailboxService := service.NewMailboxService(mailboxRepo, logger)
+	aclService := service.NewACLService(mailboxACLRepo, mailboxRepo, userRepo, logger)
 	messageService := service.NewMessageService(messageRepo, "./data/mail", logger)
 	queueService := service.NewQueueService(queueRepo, telemetryService, logger)
 	setupService := service.NewSetupService(db, userRepo, domainRepo, logger)
@@ -68,39 +79,56 @@ func NewServer(
 	pgpService := service.NewPGPService(db, logger)
 	auditService := service.NewAuditService(db, logger)
 	webhookService := service.NewWebhookService(webhookRepo, logger)
+	quotaService := service.NewQuotaService(userRepo, messageRepo, logger)
 
 	// Wire up cross-service dependencies for webmail
 	messageService.SetQueueService(queueService)
+	messageService.SetSubmissionService(submissionService)
 	messageService.SetMailboxService(mailboxService)
 
+	// Changes made through webmail and JMAP reach the shared change log
+	messageService.SetChangeService(changeService)
+	mailboxService.SetChangeService(changeService)
+
+	// Forwarding changes made through the API are audited
+	userService.SetAuditService(auditService)
+
 	// Create router with all dependencies
 	router := NewRouter(RouterConfig{
-		Logger:             logger,
-		DomainService:      domainService,
-		UserService:        userService,
-		AliasService:       aliasService,
-		MailboxService:     mailboxService,
-		MessageService:     messageService,
-		QueueService:       queueService,
-		SetupService:       setupService,
-		SettingsService:    settingsService,
-		PGPService:         pgpService,
-		AuditService:       auditService,
-		WebhookService:     webhookService,
-		ContactService:     contactService,
-		AddressbookService: addressbookService,
-		CalendarService:    calendarService,
-		EventService:       eventService,
-		AuditorService:     auditorService,
-		ScoresRepo:         reputationDB.GetScoresRepo(),
-		EventsRepo:         reputationDB.GetEventRepo(),
-		CircuitRepo:        reputationDB.GetCircuitBreakerRepo(),
-		APIKeyRepo:         apiKeyRepo,
-		RateLimitRepo:      rateLimitRepo,
-		DB:                 db.DB,
-		JWTSecret:          cfg.JWTSecret,
-		CORSOrigins:        cfg.CORSOrigins,
-		WebUIConfig:        &fullConfig.WebUI,
+		Logger:              logger,
+		DomainService:       domainService,
+		UserService:         userService,
+		AliasService:        aliasService,
+		SenderGrantService:  senderGrantService,
+		AppPasswordService:  appPasswordService,
+		ACLService:          aclService,
+		MailboxService:      mailboxService,
+		MessageService:      messageService,
+		QueueService:        queueService,
+		SubmissionService:   submissionService,
+		QuotaService:        quotaService,
+		ChangeService:       changeService,
+		SetupService:        setupService,
+		SettingsService:     settingsService,
+		PGPService:          pgpService,
+		AuditService:        auditService,
+		WebhookService:      webhookService,
+		ContactService:      contactService,
+		AddressbookService:  addressbookService,
+		CalendarService:     calendarService,
+		EventService:        eventService,
+		AuditorService:      auditorService,
+		ScoresRepo:          reputationDB.GetScoresRepo(),
+		EventsRepo:          reputationDB.GetEventRepo(),
+		CircuitRepo:         reputationDB.GetCircuitBreakerRepo(),
+		APIKeyRepo:          apiKeyRepo,
+		RateLimitRepo:       rateLimitRepo,
+		BlobRepo:            blobRepo,
+		EmailSubmissionRepo: emailSubmissionRepo,
+		DB:                  db.DB,
+		JWTSecret:           cfg.JWTSecret,
+		CORSOrigins:         cfg.CORSOrigins,
+		WebUIConfig:         &fullConfig.WebUI,
 	})
 
 	httpServer := &http.Server{
@@ -126,6 +154,9 @@ func (s *Server) Start(ctx context.Context) error {
 		zap.Int("port", s.config.Port),
 	)
 
+	// Remove JMAP uploads that were never used
+	go s.router.jmap.RunJanitor(ctx, jmap.DefaultBlobExpiryInterval)
+
 	// Start server in goroutine
 	go func() {
 		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
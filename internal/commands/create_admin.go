@@ -174,18 +174,19 @@ func createAdmin(cmd *cobra.Command, args []string) error {
 
 	// Create admin user
 	user := &domain.User{
-		Email:        email,
-		DomainID:     domainID,
-		PasswordHash: string(passwordHash),
-		FullName:     fullName,
-		DisplayName:  fullName,
-		Role:         "admin",
-		Quota:        1073741824, // 1GB default
-		UsedQuota:    0,
-		Status:       "active",
-		AuthMethod:   "password",
-		TOTPEnabled:  false,
-		Language:     "en",
+		Email:           email,
+		DomainID:        domainID,
+		PasswordHash:    string(passwordHash),
+		FullName:        fullName,
+		DisplayName:     fullName,
+		Role:            "admin",
+		Quota:           1073741824, // 1GB default
+		UsedQuota:       0,
+		Status:          "active",
+		AuthMethod:      "password",
+		TOTPEnabled:     false,
+		Language:        "en",
+		ForwardKeepCopy: true,
 	}
 
 	if err := userSvc.Create(user, password); err != nil {
@@ -0,0 +1,77 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/config"
+	"github.com/btafoya/gomailserver/internal/database"
+	"github.com/btafoya/gomailserver/internal/repository/sqlite"
+	"github.com/btafoya/gomailserver/internal/service"
+)
+
+var reindexCmd = &cobra.Command{
+	Use:   "reindex",
+	Short: "Rebuild the full-text search index",
+	Long:  "Index every stored message again, replacing the existing search entries in place. The server can keep running; searches use the existing entries until each message is reindexed.",
+	RunE:  reindex,
+}
+
+func init() {
+	rootCmd.AddCommand(reindexCmd)
+}
+
+func reindex(cmd *cobra.Command, args []string) error {
+	// Load configuration
+	cfg, err := config.Load(cfgFile)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	// Initialize logger
+	logger, err := config.NewLogger(cfg.Logger)
+	if err != nil {
+		return fmt.Errorf("failed to initialize logger: %w", err)
+	}
+	defer func() {
+		_ = logger.Sync()
+	}()
+
+	// Initialize database
+	dbConfig := database.Config{
+		Path:       cfg.Database.Path,
+		WALEnabled: cfg.Database.WALEnabled,
+	}
+
+	db, err := database.New(dbConfig, logger)
+	if err != nil {
+		return fmt.Errorf("failed to create database connection: %w", err)
+	}
+	defer db.Close()
+
+	// Run migrations to ensure database is up to date
+	if err := db.Migrate(); err != nil {
+		return fmt.Errorf("failed to run database migrations: %w", err)
+	}
+
+	messageSvc := service.NewMessageService(sqlite.NewMessageRepository(db), "./data/mail", logger)
+	searchSvc := service.NewSearchService(sqlite.NewSearchRepository(db), messageSvc, logger)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	indexed, err := searchSvc.Rebuild(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to rebuild search index after %d messages: %w", indexed, err)
+	}
+
+	logger.Info("search index rebuilt", zap.Int("messages", indexed))
+	fmt.Printf("Indexed %d messages\n", indexed)
+	return nil
+}
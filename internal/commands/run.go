@@ -19,9 +19,13 @@ import (
 	contactrepo "github.com/btafoya/gomailserver/internal/contact/repository/sqlite"
 	contactsvc "github.com/btafoya/gomailserver/internal/contact/service"
 	"github.com/btafoya/gomailserver/internal/database"
+	"github.com/btafoya/gomailserver/internal/delivery"
 	"github.com/btafoya/gomailserver/internal/imap"
+	"github.com/btafoya/gomailserver/internal/pop3"
+	"github.com/btafoya/gomailserver/internal/proxyproto"
 	"github.com/btafoya/gomailserver/internal/repository/sqlite"
 	"github.com/btafoya/gomailserver/internal/reputation"
+	repSQLite "github.com/btafoya/gomailserver/internal/reputation/repository/sqlite"
 	repService "github.com/btafoya/gomailserver/internal/reputation/service"
 	"github.com/btafoya/gomailserver/internal/security/antispam"
 	"github.com/btafoya/gomailserver/internal/security/antivirus"
@@ -29,9 +33,10 @@ import (
 	"github.com/btafoya/gomailserver/internal/security/dkim"
 	"github.com/btafoya/gomailserver/internal/security/dmarc"
 	"github.com/btafoya/gomailserver/internal/security/greylist"
+	"github.com/btafoya/gomailserver/internal/security/milter"
 	"github.com/btafoya/gomailserver/internal/security/ratelimit"
+	"github.com/btafoya/gomailserver/internal/security/saslauth"
 	"github.com/btafoya/gomailserver/internal/security/spf"
-	"github.com/btafoya/gomailserver/internal/security/totp"
 	"github.com/btafoya/gomailserver/internal/service"
 	"github.com/btafoya/gomailserver/internal/smtp"
 	tlspkg "github.com/btafoya/gomailserver/internal/tls"
@@ -107,6 +112,15 @@ func run(cmd *cobra.Command, args []string) error {
 		)
 	}
 
+	// PROXY protocol policy shared by listeners behind a load balancer
+	var proxyPolicy *proxyproto.Policy
+	if cfg.ProxyProtocolEnabled() {
+		proxyPolicy, err = proxyproto.NewPolicy(cfg.ProxyProtocol.TrustedProxies, time.Duration(cfg.ProxyProtocol.HeaderTimeout)*time.Second)
+		if err != nil {
+			return fmt.Errorf("failed to initialize PROXY protocol: %w", err)
+		}
+	}
+
 	// Check certificate expiry
 	if err := tlsMgr.ValidateExpiry(30); err != nil {
 		logger.Warn("TLS certificate validation warning", zap.Error(err))
@@ -119,8 +133,16 @@ func run(cmd *cobra.Command, args []string) error {
 	queueRepo := sqlite.NewQueueRepository(db)
 	domainRepo := sqlite.NewDomainRepository(db)
 	aliasRepo := sqlite.NewAliasRepository(db)
+	senderGrantRepo := sqlite.NewSenderGrantRepository(db)
+	appPasswordRepo := sqlite.NewAppPasswordRepository(db)
 	apiKeyRepo := sqlite.NewAPIKeyRepository(db)
 	webhookRepo := sqlite.NewWebhookRepository(db)
+	mailboxACLRepo := sqlite.NewMailboxACLRepository(db)
+	metadataRepo := sqlite.NewMetadataRepository(db)
+	changeRepo := sqlite.NewChangeRepository(db)
+	searchRepo := sqlite.NewSearchRepository(db)
+	blobRepo := sqlite.NewBlobRepository(db)
+	emailSubmissionRepo := sqlite.NewEmailSubmissionRepository(db)
 
 	// Create calendar/contact repositories
 	calendarRepo := calendarrepo.NewCalendarRepository(db.DB)
@@ -132,15 +154,30 @@ func run(cmd *cobra.Command, args []string) error {
 
 	// Create services
 	userSvc := service.NewUserService(userRepo, domainRepo, logger)
+	appPasswordSvc := service.NewAppPasswordService(appPasswordRepo, userRepo, domainRepo, logger)
+	userSvc.SetAppPasswordService(appPasswordSvc)
 	mailboxSvc := service.NewMailboxService(mailboxRepo, logger)
+	aclSvc := service.NewACLService(mailboxACLRepo, mailboxRepo, userRepo, logger)
+	quotaSvc := service.NewQuotaService(userRepo, messageRepo, logger)
+	metadataSvc := service.NewMetadataService(metadataRepo, logger)
 	messageSvc := service.NewMessageService(messageRepo, "./data/mail", logger)
 	queueSvc := service.NewQueueService(queueRepo, reputationDB.TelemetryService, logger)
+	queueSvc.SetHostname(cfg.Server.Hostname)
 	domainSvc := service.NewDomainService(domainRepo)
+	changeSvc := service.NewChangeService(changeRepo, messageRepo, logger)
+	searchSvc := service.NewSearchService(searchRepo, messageSvc, logger)
 
 	// Wire up cross-service dependencies for webmail
 	messageSvc.SetQueueService(queueSvc)
 	messageSvc.SetMailboxService(mailboxSvc)
 
+	// Record message and mailbox changes for JMAP delta sync and push
+	messageSvc.SetChangeService(changeSvc)
+	mailboxSvc.SetChangeService(changeSvc)
+
+	// Index message text for IMAP and webmail search
+	messageSvc.SetSearchService(searchSvc)
+
 	// Create calendar/contact services
 	calendarSvc := calendarsvc.NewCalendarService(calendarRepo, eventRepo)
 	eventSvc := calendarsvc.NewEventService(eventRepo, calendarRepo)
@@ -195,8 +232,31 @@ func run(cmd *cobra.Command, args []string) error {
 		cfg.Security.SpamAssassin.Port,
 	)
 
-	// TOTP
-	totpService := totp.NewTOTPService(cfg.Server.Hostname)
+	// Antispam (rspamd), used by domains that select the rspamd backend
+	rspamd := antispam.NewRspamd(
+		cfg.Security.Rspamd.URL,
+		cfg.Security.Rspamd.ControllerURL,
+		cfg.Security.Rspamd.Password,
+		time.Duration(cfg.Security.Rspamd.Timeout)*time.Second,
+	)
+
+	// Moving mail into or out of Junk trains rspamd for domains with learning enabled
+	messageSvc.SetSpamLearnService(service.NewSpamLearnService(rspamd, userRepo, domainRepo, logger))
+
+	// Milters (external content filters attached per SMTP listener)
+	milters := make([]*milter.Client, 0, len(cfg.Security.Milters))
+	for _, mc := range cfg.Security.Milters {
+		client, err := milter.NewClient(milter.Config{
+			Name:     mc.Name,
+			Address:  mc.Address,
+			Timeout:  time.Duration(mc.Timeout) * time.Second,
+			FailOpen: mc.FailAction == "accept",
+		})
+		if err != nil {
+			return fmt.Errorf("failed to configure milter %s: %w", mc.Name, err)
+		}
+		milters = append(milters, client)
+	}
 
 	logger.Debug("security services initialized")
 
@@ -236,28 +296,90 @@ func run(cmd *cobra.Command, args []string) error {
 
 	logger.Debug("reputation management services initialized")
 
+	// Create submission pipeline shared by SMTP AUTH, webmail and the HTTP API
+	submissionSvc := service.NewSubmissionService(
+		userRepo,
+		aliasRepo,
+		senderGrantRepo,
+		domainRepo,
+		queueSvc,
+		dkimSigner,
+		rateLimiter,
+		adaptiveLimiter,
+		logger,
+	)
+	submissionSvc.SetWebhookService(service.NewWebhookService(webhookRepo, logger))
+	messageSvc.SetSubmissionService(submissionSvc)
+
+	// SASL mechanisms shared by SMTP AUTH, IMAP AUTHENTICATE and POP3 AUTH
+	// Bearer tokens are the JWTs issued by the admin API.
+	saslAuth := saslauth.NewAuthenticator(userSvc, cfg.API.JWTSecret)
+
+	// Local delivery is shared by the queue's delivery worker and LMTP listeners
+	localDeliverySvc := service.NewLocalDeliveryService(
+		userRepo,
+		aliasRepo,
+		domainRepo,
+		mailboxSvc,
+		messageSvc,
+		queueSvc,
+		logger,
+	)
+	localDeliverySvc.SetAutoReplyService(service.NewAutoReplyService(
+		sqlite.NewAutoReplyRepository(db),
+		domainRepo,
+		queueSvc,
+		dkimSigner,
+		logger,
+	))
+	localDeliverySvc.SetQuotaService(quotaSvc)
+
 	// Create SMTP backend with all security services
 	smtpBackend := smtp.NewBackend(
 		userSvc,
 		messageSvc,
 		queueSvc,
+		submissionSvc,
+		saslAuth,
 		domainRepo,
 		reputationDB.TelemetryService,
-		dkimSigner,
 		dkimVerifier,
 		spfValidator,
 		dmarcEnforcer,
 		greylister,
 		rateLimiter,
-		adaptiveLimiter,
 		bruteForce,
 		clamav,
 		spamAssassin,
 		logger,
 	)
+	smtpBackend.SetMilters(milters)
+	smtpBackend.SetRspamd(rspamd)
+	smtpBackend.SetLocalDelivery(localDeliverySvc)
 
 	// Create SMTP server
-	smtpServer := smtp.NewServer(&cfg.SMTP, tlsCfg, smtpBackend, logger)
+	smtpServer := smtp.NewServer(&cfg.SMTP, tlsCfg, proxyPolicy, smtpBackend, logger)
+
+	// Create outbound delivery worker
+	var deliveryWorker *delivery.Worker
+	if cfg.Delivery.Enabled {
+		poolManager := delivery.NewPoolManager(
+			&cfg.Delivery,
+			cfg.Server.Hostname,
+			domainRepo,
+			reputationDB.ScoresRepo,
+			logger,
+		)
+		deliveryWorker = delivery.NewWorker(&cfg.Delivery, queueSvc, localDeliverySvc, poolManager, logger)
+
+		// Provider caps live in the main database (provider_rate_limits, migration v8)
+		providerLimitsSvc := repService.NewProviderRateLimitsService(
+			repSQLite.NewProviderRateLimitsRepository(db.DB),
+			repSQLite.NewAlertsRepository(db.DB),
+			logger,
+		)
+		deliveryWorker.SetProviderLimits(providerLimitsSvc)
+	}
 
 	// Create IMAP backend with security services
 	imapBackend := imap.NewBackend(
@@ -265,14 +387,34 @@ func run(cmd *cobra.Command, args []string) error {
 		mailboxSvc,
 		messageSvc,
 		domainRepo,
+		saslAuth,
 		rateLimiter,
 		bruteForce,
-		totpService,
 		logger,
 	)
+	imapBackend.SetACLService(aclSvc)
+	imapBackend.SetQuotaService(quotaSvc)
+	imapBackend.SetMetadataService(metadataSvc)
+	imapBackend.SetSearchService(searchSvc)
 
 	// Create IMAP server
-	imapServer := imap.NewServer(&cfg.IMAP, tlsCfg, imapBackend, logger)
+	imapServer := imap.NewServer(&cfg.IMAP, tlsCfg, proxyPolicy, imapBackend, logger)
+
+	// Create POP3 server for clients that only download the INBOX
+	var pop3Server *pop3.Server
+	if cfg.POP3.Enabled {
+		pop3Backend := pop3.NewBackend(
+			userSvc,
+			mailboxSvc,
+			messageSvc,
+			domainRepo,
+			saslAuth,
+			rateLimiter,
+			bruteForce,
+			logger,
+		)
+		pop3Server = pop3.NewServer(&cfg.POP3, tlsCfg, proxyPolicy, pop3Backend, logger)
+	}
 
 	// Create Admin API server
 	// API always runs on api.port (8980) - separate from WebUI
@@ -284,12 +426,19 @@ func run(cmd *cobra.Command, args []string) error {
 		domainRepo,
 		userRepo,
 		aliasRepo,
+		senderGrantRepo,
+		appPasswordRepo,
+		mailboxACLRepo,
 		mailboxRepo,
 		messageRepo,
 		queueRepo,
 		apiKeyRepo,
 		rateLimitRepo,
 		webhookRepo,
+		changeSvc,
+		blobRepo,
+		emailSubmissionRepo,
+		submissionSvc,
 		contactSvc,
 		addressbookSvc,
 		calendarSvc,
@@ -315,7 +464,10 @@ func run(cmd *cobra.Command, args []string) error {
 			ReadTimeout:  cfg.WebDAV.ReadTimeout,
 			WriteTimeout: cfg.WebDAV.WriteTimeout,
 		}
-		webdavServer = webdav.NewServer(webdavCfg, caldavHandler, carddavHandler, userRepo, logger)
+		if cfg.WebDAV.ProxyProtocol {
+			webdavCfg.Proxy = proxyPolicy
+		}
+		webdavServer = webdav.NewServer(webdavCfg, caldavHandler, carddavHandler, userRepo, appPasswordSvc, logger)
 	}
 
 	// Create context with cancellation
@@ -327,16 +479,42 @@ func run(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to start reputation scheduler: %w", err)
 	}
 
+	// Recompute stored usage periodically to repair any quota drift
+	go quotaSvc.RunReconciler(ctx, service.DefaultQuotaReconcileInterval)
+
+	// Drop JMAP change log entries older than the retention window
+	go changeSvc.RunPruner(ctx, service.DefaultChangePruneInterval)
+
+	// Enable the full-text index and index messages stored before it existed
+	go searchSvc.RunIndexer(ctx)
+
+	// Move older messages to shared bodies and remove bodies no message refers to
+	go messageSvc.RunBodyCollector(ctx, service.DefaultBodyCollectInterval)
+
 	// Start SMTP server
 	if err := smtpServer.Start(ctx); err != nil {
 		return fmt.Errorf("failed to start SMTP server: %w", err)
 	}
 
+	// Start outbound delivery worker
+	if deliveryWorker != nil {
+		if err := deliveryWorker.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start delivery worker: %w", err)
+		}
+	}
+
 	// Start IMAP server
 	if err := imapServer.Start(ctx); err != nil {
 		return fmt.Errorf("failed to start IMAP server: %w", err)
 	}
 
+	// Start POP3 server
+	if pop3Server != nil {
+		if err := pop3Server.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start POP3 server: %w", err)
+		}
+	}
+
 	// Start Admin API server
 	if err := apiServer.Start(ctx); err != nil {
 		return fmt.Errorf("failed to start admin API server: %w", err)
@@ -361,6 +539,9 @@ func run(cmd *cobra.Command, args []string) error {
 		zap.Int("imaps_port", cfg.IMAP.IMAPSPort),
 		zap.Int("api_port", cfg.API.Port),
 	}
+	if cfg.POP3.Enabled {
+		logFields = append(logFields, zap.Int("pop3_port", cfg.POP3.Port), zap.Int("pop3s_port", cfg.POP3.POP3SPort))
+	}
 	if cfg.WebUI.Enabled {
 		logFields = append(logFields, zap.Int("webui_port", cfg.WebUI.Port))
 	}
@@ -387,11 +568,25 @@ func run(cmd *cobra.Command, args []string) error {
 		logger.Error("SMTP server shutdown error", zap.Error(err))
 	}
 
+	// Stop outbound delivery worker
+	if deliveryWorker != nil {
+		if err := deliveryWorker.Stop(); err != nil {
+			logger.Error("delivery worker shutdown error", zap.Error(err))
+		}
+	}
+
 	// Shutdown IMAP server
 	if err := imapServer.Shutdown(shutdownCtx); err != nil {
 		logger.Error("IMAP server shutdown error", zap.Error(err))
 	}
 
+	// Shutdown POP3 server
+	if pop3Server != nil {
+		if err := pop3Server.Shutdown(shutdownCtx); err != nil {
+			logger.Error("POP3 server shutdown error", zap.Error(err))
+		}
+	}
+
 	// Shutdown Admin API server
 	if err := apiServer.Shutdown(shutdownCtx); err != nil {
 		logger.Error("admin API server shutdown error", zap.Error(err))
@@ -2,6 +2,10 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/spf13/viper"
 )
@@ -14,10 +18,14 @@ type Config struct {
 	TLS      TLSConfig      `mapstructure:"tls" yaml:"tls"`
 	SMTP     SMTPConfig     `mapstructure:"smtp" yaml:"smtp"`
 	IMAP     IMAPConfig     `mapstructure:"imap" yaml:"imap"`
+	POP3     POP3Config     `mapstructure:"pop3" yaml:"pop3"`
 	API      APIConfig      `mapstructure:"api" yaml:"api"`
 	WebUI    WebUIConfig    `mapstructure:"webui" yaml:"webui"`
 	WebDAV   WebDAVConfig   `mapstructure:"webdav" yaml:"webdav"`
 	Security SecurityConfig `mapstructure:"security" yaml:"security"`
+	Delivery DeliveryConfig `mapstructure:"delivery" yaml:"delivery"`
+
+	ProxyProtocol ProxyProtocolConfig `mapstructure:"proxy_protocol" yaml:"proxy_protocol"`
 }
 
 // ServerConfig holds general server configuration
@@ -50,11 +58,32 @@ type ACMEConfig struct {
 
 // SMTPConfig holds SMTP server configuration
 type SMTPConfig struct {
-	SubmissionPort int    `mapstructure:"submission_port" yaml:"submission_port" env:"SMTP_SUBMISSION_PORT" default:"587"`
-	RelayPort      int    `mapstructure:"relay_port" yaml:"relay_port" env:"SMTP_RELAY_PORT" default:"25"`
-	SMTPSPort      int    `mapstructure:"smtps_port" yaml:"smtps_port" env:"SMTPS_PORT" default:"465"`
-	MaxMessageSize int64  `mapstructure:"max_message_size" yaml:"max_message_size" env:"SMTP_MAX_MESSAGE_SIZE" default:"52428800"` // 50MB
-	Hostname       string `mapstructure:"hostname" yaml:"hostname" env:"SMTP_HOSTNAME"`
+	SubmissionPort int      `mapstructure:"submission_port" yaml:"submission_port" env:"SMTP_SUBMISSION_PORT" default:"587"`
+	RelayPort      int      `mapstructure:"relay_port" yaml:"relay_port" env:"SMTP_RELAY_PORT" default:"25"`
+	SMTPSPort      int      `mapstructure:"smtps_port" yaml:"smtps_port" env:"SMTPS_PORT" default:"465"`
+	MaxMessageSize int64    `mapstructure:"max_message_size" yaml:"max_message_size" env:"SMTP_MAX_MESSAGE_SIZE" default:"52428800"` // 50MB
+	Hostname       string   `mapstructure:"hostname" yaml:"hostname" env:"SMTP_HOSTNAME"`
+	ProxyProtocol  bool     `mapstructure:"proxy_protocol" yaml:"proxy_protocol" env:"SMTP_PROXY_PROTOCOL"` // applies to the port settings above
+	Milters        []string `mapstructure:"milters" yaml:"milters"`                                         // security.milters names; applies to the port settings above
+
+	// Listeners replaces the port settings above when set; each listener has an explicit role
+	Listeners []SMTPListenerConfig `mapstructure:"listeners" yaml:"listeners"`
+}
+
+// SMTPListenerConfig configures one SMTP listener and the policy applied to its sessions
+// Unset policy fields take the defaults of the listener's role.
+type SMTPListenerConfig struct {
+	Name           string   `mapstructure:"name" yaml:"name"`
+	Role           string   `mapstructure:"role" yaml:"role"`                         // mx, submission, submissions or lmtp
+	Addresses      []string `mapstructure:"addresses" yaml:"addresses"`               // host:port, e.g. "0.0.0.0:25" or "[::]:25"; lmtp also takes "unix:/path"
+	SocketMode     string   `mapstructure:"socket_mode" yaml:"socket_mode"`           // octal permissions of unix: sockets, default "0660"
+	RequireTLS     *bool    `mapstructure:"require_tls" yaml:"require_tls"`           // refuse AUTH and MAIL before STARTTLS
+	RequireAuth    *bool    `mapstructure:"require_auth" yaml:"require_auth"`         // refuse MAIL from unauthenticated clients
+	SecurityChecks *bool    `mapstructure:"security_checks" yaml:"security_checks"`   // greylisting, SPF, DKIM, antivirus and spam checks on unauthenticated mail
+	MaxMessageSize int64    `mapstructure:"max_message_size" yaml:"max_message_size"` // 0 uses smtp.max_message_size
+	MaxRecipients  int      `mapstructure:"max_recipients" yaml:"max_recipients"`     // 0 uses 100
+	ProxyProtocol  bool     `mapstructure:"proxy_protocol" yaml:"proxy_protocol"`     // expect PROXY headers from proxy_protocol.trusted_proxies
+	Milters        []string `mapstructure:"milters" yaml:"milters"`                   // security.milters names, applied in order
 }
 
 // IMAPConfig holds IMAP server configuration
@@ -62,6 +91,18 @@ type IMAPConfig struct {
 	Port        int `mapstructure:"port" yaml:"port" env:"IMAP_PORT" default:"143"`
 	IMAPSPort   int `mapstructure:"imaps_port" yaml:"imaps_port" env:"IMAPS_PORT" default:"993"`
 	IdleTimeout int `mapstructure:"idle_timeout" yaml:"idle_timeout" env:"IMAP_IDLE_TIMEOUT" default:"1800"` // 30 minutes
+
+	ProxyProtocol bool `mapstructure:"proxy_protocol" yaml:"proxy_protocol" env:"IMAP_PROXY_PROTOCOL"` // expect PROXY headers from proxy_protocol.trusted_proxies
+}
+
+// POP3Config holds POP3 server configuration
+type POP3Config struct {
+	Enabled     bool `mapstructure:"enabled" yaml:"enabled" env:"POP3_ENABLED" default:"true"`
+	Port        int  `mapstructure:"port" yaml:"port" env:"POP3_PORT" default:"110"`
+	POP3SPort   int  `mapstructure:"pop3s_port" yaml:"pop3s_port" env:"POP3S_PORT" default:"995"`
+	IdleTimeout int  `mapstructure:"idle_timeout" yaml:"idle_timeout" env:"POP3_IDLE_TIMEOUT" default:"600"` // 10 minutes, the RFC 1939 minimum
+
+	ProxyProtocol bool `mapstructure:"proxy_protocol" yaml:"proxy_protocol" env:"POP3_PROXY_PROTOCOL"` // expect PROXY headers from proxy_protocol.trusted_proxies
 }
 
 // APIConfig holds admin API server configuration
@@ -88,6 +129,51 @@ type WebDAVConfig struct {
 	Port         int  `mapstructure:"port" yaml:"port" env:"WEBDAV_PORT" default:"8800"`
 	ReadTimeout  int  `mapstructure:"read_timeout" yaml:"read_timeout" env:"WEBDAV_READ_TIMEOUT" default:"30"`
 	WriteTimeout int  `mapstructure:"write_timeout" yaml:"write_timeout" env:"WEBDAV_WRITE_TIMEOUT" default:"30"`
+
+	ProxyProtocol bool `mapstructure:"proxy_protocol" yaml:"proxy_protocol" env:"WEBDAV_PROXY_PROTOCOL"` // expect PROXY headers from proxy_protocol.trusted_proxies
+}
+
+// ProxyProtocolConfig holds HAProxy PROXY protocol settings shared by all listeners
+// Listeners opt in individually; only trusted_proxies may send PROXY headers.
+type ProxyProtocolConfig struct {
+	TrustedProxies []string `mapstructure:"trusted_proxies" yaml:"trusted_proxies" env:"PROXY_TRUSTED_PROXIES"`          // CIDRs or addresses of load balancers
+	HeaderTimeout  int      `mapstructure:"header_timeout" yaml:"header_timeout" env:"PROXY_HEADER_TIMEOUT" default:"5"` // seconds
+}
+
+// ProxyProtocolEnabled reports whether any listener expects PROXY headers
+func (c *Config) ProxyProtocolEnabled() bool {
+	if c.SMTP.ProxyProtocol || c.IMAP.ProxyProtocol || c.POP3.ProxyProtocol || c.WebDAV.ProxyProtocol {
+		return true
+	}
+	for _, l := range c.SMTP.Listeners {
+		if l.ProxyProtocol {
+			return true
+		}
+	}
+	return false
+}
+
+// DeliveryConfig holds outbound delivery worker configuration
+// Sender domains are bound to pools per-domain in SQLite (domains.outbound_pool)
+type DeliveryConfig struct {
+	Enabled        bool           `mapstructure:"enabled" yaml:"enabled" env:"DELIVERY_ENABLED" default:"true"`
+	Workers        int            `mapstructure:"workers" yaml:"workers" env:"DELIVERY_WORKERS" default:"4"`
+	PollInterval   int            `mapstructure:"poll_interval" yaml:"poll_interval" env:"DELIVERY_POLL_INTERVAL" default:"30"`       // seconds
+	ConnectTimeout int            `mapstructure:"connect_timeout" yaml:"connect_timeout" env:"DELIVERY_CONNECT_TIMEOUT" default:"30"` // seconds
+	DefaultPool    string         `mapstructure:"default_pool" yaml:"default_pool" env:"DELIVERY_DEFAULT_POOL"`
+	QuarantinePool string         `mapstructure:"quarantine_pool" yaml:"quarantine_pool" env:"DELIVERY_QUARANTINE_POOL"` // Used while a domain's circuit breaker is active
+	IPPools        []IPPoolConfig `mapstructure:"ip_pools" yaml:"ip_pools"`
+
+	// Maximum simultaneous connections per destination provider (gmail, outlook, yahoo, generic)
+	ProviderConcurrency map[string]int `mapstructure:"provider_concurrency" yaml:"provider_concurrency"`
+}
+
+// IPPoolConfig defines a named set of outbound source addresses
+// The HELO hostname must match the reverse DNS of every address in the pool
+type IPPoolConfig struct {
+	Name         string   `mapstructure:"name" yaml:"name"`
+	IPs          []string `mapstructure:"ips" yaml:"ips"`
+	HeloHostname string   `mapstructure:"helo_hostname" yaml:"helo_hostname"`
 }
 
 // SecurityConfig holds external security service connection configuration
@@ -95,6 +181,8 @@ type WebDAVConfig struct {
 type SecurityConfig struct {
 	ClamAV       ClamAVConfig       `mapstructure:"clamav" yaml:"clamav"`
 	SpamAssassin SpamAssassinConfig `mapstructure:"spamassassin" yaml:"spamassassin"`
+	Rspamd       RspamdConfig       `mapstructure:"rspamd" yaml:"rspamd"`
+	Milters      []MilterConfig     `mapstructure:"milters" yaml:"milters"`
 }
 
 // ClamAVConfig holds ClamAV connection configuration
@@ -112,6 +200,24 @@ type SpamAssassinConfig struct {
 	Timeout int    `mapstructure:"timeout" yaml:"timeout" env:"SPAMASSASSIN_TIMEOUT" default:"30"`
 }
 
+// RspamdConfig holds rspamd HTTP protocol connection configuration
+// Domains opt in by selecting the rspamd spam backend.
+type RspamdConfig struct {
+	URL           string `mapstructure:"url" yaml:"url" env:"RSPAMD_URL" default:"http://localhost:11333"`
+	ControllerURL string `mapstructure:"controller_url" yaml:"controller_url" env:"RSPAMD_CONTROLLER_URL" default:"http://localhost:11334"`
+	Password      string `mapstructure:"password" yaml:"password" env:"RSPAMD_PASSWORD"` // controller password for learning
+	Timeout       int    `mapstructure:"timeout" yaml:"timeout" env:"RSPAMD_TIMEOUT" default:"30"`
+}
+
+// MilterConfig holds one Sendmail milter content filter
+// SMTP listeners attach milters by name.
+type MilterConfig struct {
+	Name       string `mapstructure:"name" yaml:"name"`
+	Address    string `mapstructure:"address" yaml:"address"`         // "unix:/path", "inet:port@host", "inet6:port@host" or "host:port"
+	Timeout    int    `mapstructure:"timeout" yaml:"timeout"`         // seconds per command; 0 uses 10
+	FailAction string `mapstructure:"fail_action" yaml:"fail_action"` // "tempfail" (default) or "accept" when the milter is unavailable
+}
+
 // Load loads configuration from file and environment variables
 func Load(configPath string) (*Config, error) {
 	v := viper.New()
@@ -151,6 +257,26 @@ func Load(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("invalid security configuration: %w", err)
 	}
 
+	// Validate SMTP listener configuration
+	if err := cfg.ValidateSMTPConfig(); err != nil {
+		return nil, fmt.Errorf("invalid SMTP configuration: %w", err)
+	}
+
+	// Validate milter configuration
+	if err := cfg.ValidateMilterConfig(); err != nil {
+		return nil, fmt.Errorf("invalid milter configuration: %w", err)
+	}
+
+	// Validate PROXY protocol configuration
+	if err := cfg.ValidateProxyProtocolConfig(); err != nil {
+		return nil, fmt.Errorf("invalid proxy protocol configuration: %w", err)
+	}
+
+	// Validate outbound delivery configuration
+	if err := cfg.ValidateDeliveryConfig(); err != nil {
+		return nil, fmt.Errorf("invalid delivery configuration: %w", err)
+	}
+
 	// Database directory creation will be handled by database package
 	return &cfg, nil
 }
@@ -177,6 +303,12 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("imap.imaps_port", 993)
 	v.SetDefault("imap.idle_timeout", 1800) // 30 minutes
 
+	// POP3
+	v.SetDefault("pop3.enabled", true)
+	v.SetDefault("pop3.port", 110)
+	v.SetDefault("pop3.pop3s_port", 995)
+	v.SetDefault("pop3.idle_timeout", 600) // 10 minutes
+
 	// Admin API
 	v.SetDefault("api.port", 8980)
 	v.SetDefault("api.read_timeout", 15)
@@ -193,6 +325,9 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("webdav.read_timeout", 30)
 	v.SetDefault("webdav.write_timeout", 30)
 
+	// PROXY protocol defaults
+	v.SetDefault("proxy_protocol.header_timeout", 5)
+
 	// Security - External service connections only
 	// All security policies are stored in SQLite per-domain
 	v.SetDefault("security.clamav.socket_path", "/var/run/clamav/clamd.ctl")
@@ -200,6 +335,21 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("security.spamassassin.host", "localhost")
 	v.SetDefault("security.spamassassin.port", 783)
 	v.SetDefault("security.spamassassin.timeout", 30)
+	v.SetDefault("security.rspamd.url", "http://localhost:11333")
+	v.SetDefault("security.rspamd.controller_url", "http://localhost:11334")
+	v.SetDefault("security.rspamd.timeout", 30)
+
+	// Outbound delivery
+	v.SetDefault("delivery.enabled", true)
+	v.SetDefault("delivery.workers", 4)
+	v.SetDefault("delivery.poll_interval", 30)
+	v.SetDefault("delivery.connect_timeout", 30)
+	v.SetDefault("delivery.provider_concurrency", map[string]int{
+		"gmail":   5,
+		"outlook": 5,
+		"yahoo":   3,
+		"generic": 10,
+	})
 
 	// TLS/ACME
 	v.SetDefault("tls.acme.enabled", false)
@@ -229,5 +379,157 @@ func (c *Config) ValidateSecurityConfig() error {
 		return fmt.Errorf("spamassassin.timeout must be positive, got %d", c.Security.SpamAssassin.Timeout)
 	}
 
+	// Rspamd connection validation
+	if c.Security.Rspamd.URL == "" {
+		return fmt.Errorf("rspamd.url cannot be empty")
+	}
+	if c.Security.Rspamd.Timeout <= 0 {
+		return fmt.Errorf("rspamd.timeout must be positive, got %d", c.Security.Rspamd.Timeout)
+	}
+
+	return nil
+}
+
+// ValidateSMTPConfig validates SMTP listener definitions
+func (c *Config) ValidateSMTPConfig() error {
+	validRoles := map[string]bool{"mx": true, "submission": true, "submissions": true, "lmtp": true}
+
+	names := make(map[string]bool, len(c.SMTP.Listeners))
+	for _, l := range c.SMTP.Listeners {
+		if l.Name == "" {
+			return fmt.Errorf("smtp.listeners: listener name cannot be empty")
+		}
+		if names[l.Name] {
+			return fmt.Errorf("smtp.listeners: duplicate listener name %q", l.Name)
+		}
+		names[l.Name] = true
+
+		if !validRoles[l.Role] {
+			return fmt.Errorf("smtp.listeners[%s]: invalid role %q (must be mx, submission, submissions or lmtp)", l.Name, l.Role)
+		}
+		if len(l.Addresses) == 0 {
+			return fmt.Errorf("smtp.listeners[%s]: at least one address is required", l.Name)
+		}
+		for _, addr := range l.Addresses {
+			if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+				if l.Role != "lmtp" {
+					return fmt.Errorf("smtp.listeners[%s]: unix socket addresses are only supported for lmtp", l.Name)
+				}
+				if !filepath.IsAbs(path) {
+					return fmt.Errorf("smtp.listeners[%s]: unix socket path %q must be absolute", l.Name, path)
+				}
+				if l.ProxyProtocol {
+					return fmt.Errorf("smtp.listeners[%s]: proxy_protocol is not supported on unix sockets", l.Name)
+				}
+				continue
+			}
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				return fmt.Errorf("smtp.listeners[%s]: invalid address %q: %w", l.Name, addr, err)
+			}
+		}
+		if l.SocketMode != "" {
+			if _, err := strconv.ParseUint(l.SocketMode, 8, 32); err != nil {
+				return fmt.Errorf("smtp.listeners[%s]: invalid socket_mode %q: must be octal, e.g. \"0660\"", l.Name, l.SocketMode)
+			}
+		}
+		if l.MaxMessageSize < 0 {
+			return fmt.Errorf("smtp.listeners[%s]: max_message_size cannot be negative", l.Name)
+		}
+		if l.MaxRecipients < 0 {
+			return fmt.Errorf("smtp.listeners[%s]: max_recipients cannot be negative", l.Name)
+		}
+	}
+
+	return nil
+}
+
+// ValidateMilterConfig validates milter definitions and the listeners that use them
+func (c *Config) ValidateMilterConfig() error {
+	names := make(map[string]bool, len(c.Security.Milters))
+	for _, m := range c.Security.Milters {
+		if m.Name == "" {
+			return fmt.Errorf("security.milters: milter name cannot be empty")
+		}
+		if names[m.Name] {
+			return fmt.Errorf("security.milters: duplicate milter name %q", m.Name)
+		}
+		names[m.Name] = true
+
+		if m.Address == "" {
+			return fmt.Errorf("security.milters[%s]: address is required", m.Name)
+		}
+		if m.Timeout < 0 {
+			return fmt.Errorf("security.milters[%s]: timeout cannot be negative", m.Name)
+		}
+		switch m.FailAction {
+		case "", "tempfail", "accept":
+		default:
+			return fmt.Errorf("security.milters[%s]: invalid fail_action %q (must be tempfail or accept)", m.Name, m.FailAction)
+		}
+	}
+
+	for _, name := range c.SMTP.Milters {
+		if !names[name] {
+			return fmt.Errorf("smtp.milters: unknown milter %q", name)
+		}
+	}
+	for _, l := range c.SMTP.Listeners {
+		for _, name := range l.Milters {
+			if !names[name] {
+				return fmt.Errorf("smtp.listeners[%s]: unknown milter %q", l.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateProxyProtocolConfig validates trusted proxy addresses
+func (c *Config) ValidateProxyProtocolConfig() error {
+	if c.ProxyProtocolEnabled() && len(c.ProxyProtocol.TrustedProxies) == 0 {
+		return fmt.Errorf("proxy_protocol.trusted_proxies is required when a listener enables proxy_protocol")
+	}
+	for _, s := range c.ProxyProtocol.TrustedProxies {
+		if _, _, err := net.ParseCIDR(s); err != nil && net.ParseIP(s) == nil {
+			return fmt.Errorf("proxy_protocol.trusted_proxies: invalid address or CIDR %q", s)
+		}
+	}
+	if c.ProxyProtocol.HeaderTimeout < 0 {
+		return fmt.Errorf("proxy_protocol.header_timeout cannot be negative")
+	}
+	return nil
+}
+
+// ValidateDeliveryConfig validates outbound IP pool definitions
+func (c *Config) ValidateDeliveryConfig() error {
+	names := make(map[string]bool, len(c.Delivery.IPPools))
+	for _, pool := range c.Delivery.IPPools {
+		if pool.Name == "" {
+			return fmt.Errorf("delivery.ip_pools: pool name cannot be empty")
+		}
+		if names[pool.Name] {
+			return fmt.Errorf("delivery.ip_pools: duplicate pool name %q", pool.Name)
+		}
+		names[pool.Name] = true
+
+		if len(pool.IPs) == 0 {
+			return fmt.Errorf("delivery.ip_pools[%s]: at least one IP is required", pool.Name)
+		}
+		for _, ip := range pool.IPs {
+			if net.ParseIP(ip) == nil {
+				return fmt.Errorf("delivery.ip_pools[%s]: invalid IP address %q", pool.Name, ip)
+			}
+		}
+		if pool.HeloHostname == "" {
+			return fmt.Errorf("delivery.ip_pools[%s]: helo_hostname cannot be empty", pool.Name)
+		}
+	}
+
+	if c.Delivery.DefaultPool != "" && !names[c.Delivery.DefaultPool] {
+		return fmt.Errorf("delivery.default_pool %q is not a configured pool", c.Delivery.DefaultPool)
+	}
+	if c.Delivery.QuarantinePool != "" && !names[c.Delivery.QuarantinePool] {
+		return fmt.Errorf("delivery.quarantine_pool %q is not a configured pool", c.Delivery.QuarantinePool)
+	}
+
 	return nil
 }
@@ -0,0 +1,13 @@
+package database
+
+// Migration v10: Queue body type
+// Records the MAIL FROM BODY parameter so BINARYMIME messages are relayed with BDAT.
+
+const migrationV10Up = `
+-- BODY parameter from MAIL FROM: '' (7BIT), '8BITMIME' or 'BINARYMIME'
+ALTER TABLE smtp_queue ADD COLUMN body_type TEXT DEFAULT '';
+`
+
+const migrationV10Down = `
+ALTER TABLE smtp_queue DROP COLUMN body_type;
+`
@@ -0,0 +1,27 @@
+package database
+
+// Migration v11: Queue ESMTP envelope parameters
+// Persists SMTPUTF8, REQUIRETLS and DSN (RFC 3461) parameters so they survive until delivery.
+
+const migrationV11Up = `
+-- Envelope uses internationalized addresses (RFC 6531)
+ALTER TABLE smtp_queue ADD COLUMN smtputf8 BOOLEAN DEFAULT 0;
+
+-- Message must only be relayed over verified TLS (RFC 8689)
+ALTER TABLE smtp_queue ADD COLUMN require_tls BOOLEAN DEFAULT 0;
+
+-- DSN RET and ENVID parameters from MAIL FROM
+ALTER TABLE smtp_queue ADD COLUMN dsn_ret TEXT DEFAULT '';
+ALTER TABLE smtp_queue ADD COLUMN dsn_envid TEXT DEFAULT '';
+
+-- DSN NOTIFY and ORCPT parameters per recipient (JSON array)
+ALTER TABLE smtp_queue ADD COLUMN dsn_recipients TEXT DEFAULT '';
+`
+
+const migrationV11Down = `
+ALTER TABLE smtp_queue DROP COLUMN dsn_recipients;
+ALTER TABLE smtp_queue DROP COLUMN dsn_envid;
+ALTER TABLE smtp_queue DROP COLUMN dsn_ret;
+ALTER TABLE smtp_queue DROP COLUMN require_tls;
+ALTER TABLE smtp_queue DROP COLUMN smtputf8;
+`
@@ -0,0 +1,24 @@
+package database
+
+// Migration v12: Sender grants
+// Lets a user send as (or on behalf of) addresses that are not their own mailbox or alias.
+
+const migrationV12Up = `
+-- Send-as / send-on-behalf grants for authenticated submission
+-- address is a full address or "@domain" for every address in a domain
+CREATE TABLE IF NOT EXISTS sender_grants (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	address TEXT NOT NULL,
+	grant_type TEXT NOT NULL DEFAULT 'send_as' CHECK(grant_type IN ('send_as', 'send_on_behalf')),
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	UNIQUE(user_id, address)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sender_grants_user_id ON sender_grants(user_id);
+`
+
+const migrationV12Down = `
+DROP TABLE IF EXISTS sender_grants;
+`
@@ -0,0 +1,13 @@
+package database
+
+// Migration v13: SCRAM credentials
+// Stores SCRAM-SHA-256 salted keys next to the bcrypt hash so SMTP and IMAP can offer SCRAM.
+
+const migrationV13Up = `
+-- SCRAM-SHA-256 StoredKey/ServerKey (RFC 5802), filled on password change or next password login
+ALTER TABLE users ADD COLUMN scram_sha256 TEXT DEFAULT '';
+`
+
+const migrationV13Down = `
+ALTER TABLE users DROP COLUMN scram_sha256;
+`
@@ -0,0 +1,25 @@
+package database
+
+// Migration v14: App passwords
+// Per-client passwords for IMAP, SMTP and WebDAV so TOTP users can use mail clients.
+
+const migrationV14Up = `
+-- App-specific passwords; password_hash is the SHA-256 of a generated high-entropy secret
+CREATE TABLE IF NOT EXISTS app_passwords (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	password_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL DEFAULT '["imap","smtp","dav"]',
+	last_used_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	UNIQUE(user_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_app_passwords_user_id ON app_passwords(user_id);
+`
+
+const migrationV14Down = `
+DROP TABLE IF EXISTS app_passwords;
+`
@@ -0,0 +1,82 @@
+package database
+
+// Migration v15: Held queue items
+// Adds the 'held' queue status for messages quarantined by content filters.
+// SQLite cannot alter a CHECK constraint, so smtp_queue is rebuilt.
+
+const migrationV15Up = `
+CREATE TABLE smtp_queue_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    sender TEXT NOT NULL,
+    recipients TEXT NOT NULL,  -- JSON array
+    message_id TEXT,
+    message_path TEXT NOT NULL,
+    retry_count INTEGER DEFAULT 0,
+    max_retries INTEGER DEFAULT 5,
+    next_retry TIMESTAMP,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK(status IN ('pending', 'processing', 'failed', 'delivered', 'held')),
+    error_message TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    body_type TEXT DEFAULT '',
+    smtputf8 BOOLEAN DEFAULT 0,
+    require_tls BOOLEAN DEFAULT 0,
+    dsn_ret TEXT DEFAULT '',
+    dsn_envid TEXT DEFAULT '',
+    dsn_recipients TEXT DEFAULT ''
+);
+
+INSERT INTO smtp_queue_new (
+    id, sender, recipients, message_id, message_path, retry_count, max_retries,
+    next_retry, status, error_message, created_at, updated_at, body_type,
+    smtputf8, require_tls, dsn_ret, dsn_envid, dsn_recipients
+)
+SELECT
+    id, sender, recipients, message_id, message_path, retry_count, max_retries,
+    next_retry, status, error_message, created_at, updated_at, body_type,
+    smtputf8, require_tls, dsn_ret, dsn_envid, dsn_recipients
+FROM smtp_queue;
+
+DROP TABLE smtp_queue;
+ALTER TABLE smtp_queue_new RENAME TO smtp_queue;
+
+CREATE INDEX idx_smtp_queue_status ON smtp_queue(status);
+CREATE INDEX idx_smtp_queue_next_retry ON smtp_queue(next_retry);
+`
+
+const migrationV15Down = `
+DELETE FROM smtp_queue WHERE status = 'held';
+
+CREATE TABLE smtp_queue_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    sender TEXT NOT NULL,
+    recipients TEXT NOT NULL,
+    message_id TEXT,
+    message_path TEXT NOT NULL,
+    retry_count INTEGER DEFAULT 0,
+    max_retries INTEGER DEFAULT 5,
+    next_retry TIMESTAMP,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK(status IN ('pending', 'processing', 'failed', 'delivered')),
+    error_message TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    body_type TEXT DEFAULT '',
+    smtputf8 BOOLEAN DEFAULT 0,
+    require_tls BOOLEAN DEFAULT 0,
+    dsn_ret TEXT DEFAULT '',
+    dsn_envid TEXT DEFAULT '',
+    dsn_recipients TEXT DEFAULT ''
+);
+
+INSERT INTO smtp_queue_old SELECT
+    id, sender, recipients, message_id, message_path, retry_count, max_retries,
+    next_retry, status, error_message, created_at, updated_at, body_type,
+    smtputf8, require_tls, dsn_ret, dsn_envid, dsn_recipients
+FROM smtp_queue;
+
+DROP TABLE smtp_queue;
+ALTER TABLE smtp_queue_old RENAME TO smtp_queue;
+
+CREATE INDEX idx_smtp_queue_status ON smtp_queue(status);
+CREATE INDEX idx_smtp_queue_next_retry ON smtp_queue(next_retry);
+`
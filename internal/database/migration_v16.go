@@ -0,0 +1,12 @@
+package database
+
+// Migration v16: Per-domain spam backend
+// Selects which scanner (spamassassin or rspamd) scores a domain's inbound mail.
+
+const migrationV16Up = `
+ALTER TABLE domains ADD COLUMN spam_backend TEXT DEFAULT 'spamassassin';
+`
+
+const migrationV16Down = `
+ALTER TABLE domains DROP COLUMN spam_backend;
+`
@@ -0,0 +1,27 @@
+package database
+
+// Migration v17: Vacation autoresponder
+// Adds an optional active period to auto replies and remembers which senders
+// were answered so each sender gets at most one reply per window (RFC 3834).
+
+const migrationV17Up = `
+ALTER TABLE users ADD COLUMN auto_reply_start TIMESTAMP;
+ALTER TABLE users ADD COLUMN auto_reply_end TIMESTAMP;
+
+-- Last auto reply sent to each sender, per user
+CREATE TABLE IF NOT EXISTS auto_replies (
+	user_id INTEGER NOT NULL,
+	sender TEXT NOT NULL,
+	sent_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (user_id, sender),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_auto_replies_sent_at ON auto_replies(sent_at);
+`
+
+const migrationV17Down = `
+DROP TABLE IF EXISTS auto_replies;
+ALTER TABLE users DROP COLUMN auto_reply_end;
+ALTER TABLE users DROP COLUMN auto_reply_start;
+`
@@ -0,0 +1,15 @@
+package database
+
+// Migration v18: Forwarding
+// Lets users keep a local copy of forwarded mail and lets domains forbid
+// forwarding outside the server.
+
+const migrationV18Up = `
+ALTER TABLE users ADD COLUMN forward_keep_copy INTEGER DEFAULT 1;
+ALTER TABLE domains ADD COLUMN forward_external_enabled INTEGER DEFAULT 1;
+`
+
+const migrationV18Down = `
+ALTER TABLE domains DROP COLUMN forward_external_enabled;
+ALTER TABLE users DROP COLUMN forward_keep_copy;
+`
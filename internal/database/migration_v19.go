@@ -0,0 +1,15 @@
+package database
+
+// Migration v19: Subaddressing
+// Per-domain subaddress separator (user+tag@domain) and optional filing of
+// tagged mail into a folder named after the tag.
+
+const migrationV19Up = `
+ALTER TABLE domains ADD COLUMN subaddress_separator TEXT DEFAULT '+';
+ALTER TABLE domains ADD COLUMN subaddress_folders INTEGER DEFAULT 0;
+`
+
+const migrationV19Down = `
+ALTER TABLE domains DROP COLUMN subaddress_folders;
+ALTER TABLE domains DROP COLUMN subaddress_separator;
+`
@@ -0,0 +1,23 @@
+package database
+
+// Migration v20: Mailbox ACLs
+// Per-mailbox access rights (RFC 4314) for sharing folders with other users.
+
+const migrationV20Up = `
+-- identifier is a user's email address or 'anyone'; the owner's rights are implicit
+CREATE TABLE IF NOT EXISTS mailbox_acl (
+	mailbox_id INTEGER NOT NULL,
+	identifier TEXT NOT NULL,
+	rights TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (mailbox_id, identifier),
+	FOREIGN KEY (mailbox_id) REFERENCES mailboxes(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_mailbox_acl_identifier ON mailbox_acl(identifier);
+`
+
+const migrationV20Down = `
+DROP TABLE IF EXISTS mailbox_acl;
+`
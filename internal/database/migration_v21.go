@@ -0,0 +1,13 @@
+package database
+
+// Migration v21: Message quota
+// Per-user limit on the number of stored messages (RFC 9208 MESSAGE resource);
+// 0 means unlimited, like users.quota.
+
+const migrationV21Up = `
+ALTER TABLE users ADD COLUMN message_quota INTEGER DEFAULT 0;
+`
+
+const migrationV21Down = `
+ALTER TABLE users DROP COLUMN message_quota;
+`
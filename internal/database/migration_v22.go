@@ -0,0 +1,19 @@
+package database
+
+// Migration v22: Message sort indexes
+// IMAP SORT and THREAD order a mailbox's messages by these columns, so they are
+// indexed per mailbox rather than across all messages.
+
+const migrationV22Up = `
+CREATE INDEX IF NOT EXISTS idx_messages_mailbox_internal_date ON messages(mailbox_id, internal_date);
+CREATE INDEX IF NOT EXISTS idx_messages_mailbox_subject ON messages(mailbox_id, subject);
+CREATE INDEX IF NOT EXISTS idx_messages_mailbox_from_addr ON messages(mailbox_id, from_addr);
+CREATE INDEX IF NOT EXISTS idx_messages_mailbox_thread_id ON messages(mailbox_id, thread_id);
+`
+
+const migrationV22Down = `
+DROP INDEX IF EXISTS idx_messages_mailbox_internal_date;
+DROP INDEX IF EXISTS idx_messages_mailbox_subject;
+DROP INDEX IF EXISTS idx_messages_mailbox_from_addr;
+DROP INDEX IF EXISTS idx_messages_mailbox_thread_id;
+`
@@ -0,0 +1,25 @@
+package database
+
+// Migration v23: IMAP METADATA
+// Server and mailbox annotations (RFC 5464). user_id is NULL for /shared
+// entries and mailbox_id is NULL for server entries, so both cascade on delete;
+// the unique index treats the NULLs as one value.
+
+const migrationV23Up = `
+CREATE TABLE IF NOT EXISTS metadata (
+	user_id INTEGER,
+	mailbox_id INTEGER,
+	name TEXT NOT NULL,
+	value BLOB NOT NULL,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (mailbox_id) REFERENCES mailboxes(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_metadata_entry ON metadata(IFNULL(mailbox_id, 0), IFNULL(user_id, 0), name);
+`
+
+const migrationV23Down = `
+DROP INDEX IF EXISTS idx_metadata_entry;
+DROP TABLE IF EXISTS metadata;
+`
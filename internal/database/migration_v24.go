@@ -0,0 +1,70 @@
+package database
+
+// Migration v24: JMAP
+// changes is the log behind JMAP state strings; change_horizons keeps the
+// newest change pruned from it per user and type, so states older than that
+// cannot be synchronized. blobs holds uploads until they expire; their IDs
+// are content hashes, so uploading the same data again refreshes the blob.
+
+const migrationV24Up = `
+CREATE TABLE IF NOT EXISTS changes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	type TEXT NOT NULL,
+	object_id TEXT NOT NULL,
+	kind TEXT NOT NULL CHECK(kind IN ('created', 'updated', 'destroyed')),
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_changes_user_type ON changes(user_id, type, id);
+CREATE INDEX IF NOT EXISTS idx_changes_created ON changes(created_at);
+
+CREATE TABLE IF NOT EXISTS change_horizons (
+	user_id INTEGER NOT NULL,
+	type TEXT NOT NULL,
+	change_id INTEGER NOT NULL,
+	PRIMARY KEY (user_id, type),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS blobs (
+	id TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	type TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	data BLOB NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, id),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_blobs_created ON blobs(created_at);
+
+CREATE TABLE IF NOT EXISTS email_submissions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	identity_id TEXT NOT NULL,
+	message_id INTEGER NOT NULL,
+	thread_id TEXT NOT NULL DEFAULT '',
+	mail_from TEXT NOT NULL,
+	rcpt_to TEXT NOT NULL,
+	queue_id TEXT NOT NULL DEFAULT '',
+	undo_status TEXT NOT NULL DEFAULT 'final' CHECK(undo_status IN ('pending', 'final', 'canceled')),
+	send_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_submissions_user ON email_submissions(user_id, send_at);
+`
+
+const migrationV24Down = `
+DROP INDEX IF EXISTS idx_email_submissions_user;
+DROP TABLE IF EXISTS email_submissions;
+DROP INDEX IF EXISTS idx_blobs_created;
+DROP TABLE IF EXISTS blobs;
+DROP TABLE IF EXISTS change_horizons;
+DROP INDEX IF EXISTS idx_changes_created;
+DROP INDEX IF EXISTS idx_changes_user_type;
+DROP TABLE IF EXISTS changes;
+`
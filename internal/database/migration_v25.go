@@ -0,0 +1,28 @@
+package database
+
+// Migration v25: full-text search
+// message_search holds the text extracted from each message. When SQLite is
+// built with FTS5 (the sqlite_fts5 build tag), the search repository creates
+// the message_fts index over it at startup, kept current by triggers;
+// without FTS5, searches scan message_search instead.
+
+const migrationV25Up = `
+CREATE TABLE IF NOT EXISTS message_search (
+	message_id INTEGER PRIMARY KEY,
+	from_text TEXT NOT NULL DEFAULT '',
+	to_text TEXT NOT NULL DEFAULT '',
+	subject TEXT NOT NULL DEFAULT '',
+	body TEXT NOT NULL DEFAULT '',
+	attachments TEXT NOT NULL DEFAULT '',
+	has_attachment INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+`
+
+const migrationV25Down = `
+DROP TRIGGER IF EXISTS message_search_ai;
+DROP TRIGGER IF EXISTS message_search_ad;
+DROP TRIGGER IF EXISTS message_search_au;
+DROP TABLE IF EXISTS message_fts;
+DROP TABLE IF EXISTS message_search;
+`
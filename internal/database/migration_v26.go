@@ -0,0 +1,50 @@
+package database
+
+// Migration v26: content-addressed message storage
+// message_bodies holds each distinct message content once, keyed by its
+// SHA-256, in the same blob or file form messages used. messages.body_hash
+// refers to it and triggers keep ref_count equal to the number of messages
+// referring to each body, including rows removed by cascading deletes; the
+// message service removes bodies whose count drops to zero. Rows stored
+// before this migration keep their own content until the message service
+// moves them into message_bodies.
+
+const migrationV26Up = `
+CREATE TABLE IF NOT EXISTS message_bodies (
+	hash TEXT PRIMARY KEY,
+	size INTEGER NOT NULL,
+	storage_type TEXT NOT NULL CHECK(storage_type IN ('blob', 'file')),
+	content BLOB,
+	content_path TEXT NOT NULL DEFAULT '',
+	ref_count INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_bodies_unreferenced ON message_bodies(ref_count) WHERE ref_count <= 0;
+
+ALTER TABLE messages ADD COLUMN body_hash TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_messages_body_hash ON messages(body_hash);
+
+CREATE TRIGGER IF NOT EXISTS messages_body_ref_ai AFTER INSERT ON messages WHEN new.body_hash IS NOT NULL BEGIN UPDATE message_bodies SET ref_count = ref_count + 1 WHERE hash = new.body_hash; END;
+
+CREATE TRIGGER IF NOT EXISTS messages_body_ref_ad AFTER DELETE ON messages WHEN old.body_hash IS NOT NULL BEGIN UPDATE message_bodies SET ref_count = ref_count - 1 WHERE hash = old.body_hash; END;
+
+CREATE TRIGGER IF NOT EXISTS messages_body_ref_au AFTER UPDATE OF body_hash ON messages WHEN old.body_hash IS NOT new.body_hash BEGIN UPDATE message_bodies SET ref_count = ref_count - 1 WHERE hash = old.body_hash; UPDATE message_bodies SET ref_count = ref_count + 1 WHERE hash = new.body_hash; END;
+`
+
+const migrationV26Down = `
+DROP TRIGGER IF EXISTS messages_body_ref_ai;
+DROP TRIGGER IF EXISTS messages_body_ref_ad;
+DROP TRIGGER IF EXISTS messages_body_ref_au;
+
+UPDATE messages SET
+	storage_type = (SELECT storage_type FROM message_bodies WHERE hash = messages.body_hash),
+	content = (SELECT content FROM message_bodies WHERE hash = messages.body_hash),
+	content_path = (SELECT content_path FROM message_bodies WHERE hash = messages.body_hash)
+WHERE body_hash IN (SELECT hash FROM message_bodies);
+
+DROP INDEX IF EXISTS idx_messages_body_hash;
+ALTER TABLE messages DROP COLUMN body_hash;
+DROP TABLE IF EXISTS message_bodies;
+`
@@ -0,0 +1,14 @@
+package database
+
+// Migration v27: Queue spam verdicts
+// Keeps the spam filter's score and matched symbols with a queued message so
+// administrators can see why a message was quarantined.
+
+const migrationV27Up = `
+-- Spam verdict (JSON SpamVerdict), empty when the message was not scored
+ALTER TABLE smtp_queue ADD COLUMN spam TEXT DEFAULT '';
+`
+
+const migrationV27Down = `
+ALTER TABLE smtp_queue DROP COLUMN spam;
+`
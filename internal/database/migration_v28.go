@@ -0,0 +1,15 @@
+package database
+
+// Migration v28: Local-only queue items
+// Mail received from other servers may only be delivered to hosted domains;
+// the delivery worker refuses to relay it, including recipients a milter
+// added after RCPT.
+
+const migrationV28Up = `
+-- 1 for mail received on inbound listeners, which is never relayed
+ALTER TABLE smtp_queue ADD COLUMN local_only INTEGER DEFAULT 0;
+`
+
+const migrationV28Down = `
+ALTER TABLE smtp_queue DROP COLUMN local_only;
+`
@@ -0,0 +1,89 @@
+package database
+
+// Migration v29: Per-recipient queue status
+// Records the outcome of each recipient so an item delivered to some
+// recipients and bounced for others ends as 'partial' rather than
+// 'delivered'. SQLite cannot alter a CHECK constraint, so smtp_queue is
+// rebuilt as in v15.
+
+const migrationV29Up = `
+CREATE TABLE smtp_queue_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    sender TEXT NOT NULL,
+    recipients TEXT NOT NULL,  -- JSON array
+    message_id TEXT,
+    message_path TEXT NOT NULL,
+    retry_count INTEGER DEFAULT 0,
+    max_retries INTEGER DEFAULT 5,
+    next_retry TIMESTAMP,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK(status IN ('pending', 'processing', 'failed', 'delivered', 'held', 'partial')),
+    error_message TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    body_type TEXT DEFAULT '',
+    smtputf8 BOOLEAN DEFAULT 0,
+    require_tls BOOLEAN DEFAULT 0,
+    dsn_ret TEXT DEFAULT '',
+    dsn_envid TEXT DEFAULT '',
+    dsn_recipients TEXT DEFAULT '',
+    spam TEXT DEFAULT '',
+    local_only INTEGER DEFAULT 0,
+    recipient_status TEXT DEFAULT ''  -- JSON array of RecipientStatus
+);
+
+INSERT INTO smtp_queue_new (
+    id, sender, recipients, message_id, message_path, retry_count, max_retries,
+    next_retry, status, error_message, created_at, updated_at, body_type,
+    smtputf8, require_tls, dsn_ret, dsn_envid, dsn_recipients, spam, local_only
+)
+SELECT
+    id, sender, recipients, message_id, message_path, retry_count, max_retries,
+    next_retry, status, error_message, created_at, updated_at, body_type,
+    smtputf8, require_tls, dsn_ret, dsn_envid, dsn_recipients, spam, local_only
+FROM smtp_queue;
+
+DROP TABLE smtp_queue;
+ALTER TABLE smtp_queue_new RENAME TO smtp_queue;
+
+CREATE INDEX idx_smtp_queue_status ON smtp_queue(status);
+CREATE INDEX idx_smtp_queue_next_retry ON smtp_queue(next_retry);
+`
+
+const migrationV29Down = `
+UPDATE smtp_queue SET status = 'failed' WHERE status = 'partial';
+
+CREATE TABLE smtp_queue_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    sender TEXT NOT NULL,
+    recipients TEXT NOT NULL,
+    message_id TEXT,
+    message_path TEXT NOT NULL,
+    retry_count INTEGER DEFAULT 0,
+    max_retries INTEGER DEFAULT 5,
+    next_retry TIMESTAMP,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK(status IN ('pending', 'processing', 'failed', 'delivered', 'held')),
+    error_message TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    body_type TEXT DEFAULT '',
+    smtputf8 BOOLEAN DEFAULT 0,
+    require_tls BOOLEAN DEFAULT 0,
+    dsn_ret TEXT DEFAULT '',
+    dsn_envid TEXT DEFAULT '',
+    dsn_recipients TEXT DEFAULT '',
+    spam TEXT DEFAULT '',
+    local_only INTEGER DEFAULT 0
+);
+
+INSERT INTO smtp_queue_old SELECT
+    id, sender, recipients, message_id, message_path, retry_count, max_retries,
+    next_retry, status, error_message, created_at, updated_at, body_type,
+    smtputf8, require_tls, dsn_ret, dsn_envid, dsn_recipients, spam, local_only
+FROM smtp_queue;
+
+DROP TABLE smtp_queue;
+ALTER TABLE smtp_queue_old RENAME TO smtp_queue;
+
+CREATE INDEX idx_smtp_queue_status ON smtp_queue(status);
+CREATE INDEX idx_smtp_queue_next_retry ON smtp_queue(next_retry);
+`
@@ -0,0 +1,15 @@
+package database
+
+// Migration v30: Provider circuit breaker trip time
+// The delivery worker trips a provider's circuit breaker after repeated
+// failed transactions; the trip time lets the breaker close again after a
+// cool-down. Breakers set without a trip time stay open until cleared.
+
+const migrationV30Up = `
+-- Unix time the breaker was tripped by the delivery worker, 0 when not tripped
+ALTER TABLE provider_rate_limits ADD COLUMN circuit_breaker_at INTEGER DEFAULT 0;
+`
+
+const migrationV30Down = `
+ALTER TABLE provider_rate_limits DROP COLUMN circuit_breaker_at;
+`
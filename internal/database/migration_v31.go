@@ -0,0 +1,13 @@
+package database
+
+// Migration v31: Subaddress folder creation
+// Tagged mail is filed only into folders the user already has unless the
+// domain lets delivery create them.
+
+const migrationV31Up = `
+ALTER TABLE domains ADD COLUMN subaddress_create_folders INTEGER DEFAULT 0;
+`
+
+const migrationV31Down = `
+ALTER TABLE domains DROP COLUMN subaddress_create_folders;
+`
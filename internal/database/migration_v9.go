@@ -0,0 +1,13 @@
+package database
+
+// Migration v9: Outbound IP pools
+// Binds each sender domain to a named outbound IP pool defined in the delivery config.
+
+const migrationV9Up = `
+-- Outbound IP pool assignment (empty = delivery.default_pool)
+ALTER TABLE domains ADD COLUMN outbound_pool TEXT DEFAULT '';
+`
+
+const migrationV9Down = `
+ALTER TABLE domains DROP COLUMN outbound_pool;
+`
@@ -68,6 +68,144 @@ func GetMigrations() []Migration {
 			Up:          migrationV8Up,
 			Down:        migrationV8Down,
 		},
+		{
+			Version:     9,
+			Description: "Add per-domain outbound IP pool assignment",
+			Up:          migrationV9Up,
+			Down:        migrationV9Down,
+		},
+		{
+			Version:     10,
+			Description: "Add body type to SMTP queue for BINARYMIME relay",
+			Up:          migrationV10Up,
+			Down:        migrationV10Down,
+		},
+		{
+			Version:     11,
+			Description: "Add SMTPUTF8, REQUIRETLS and DSN parameters to SMTP queue",
+			Up:          migrationV11Up,
+			Down:        migrationV11Down,
+		},
+		{
+			Version:     12,
+			Description: "Add sender grants for send-as authorization",
+			Up:          migrationV12Up,
+			Down:        migrationV12Down,
+		},
+		{
+			Version:     13,
+			Description: "Add SCRAM-SHA-256 credentials to users",
+			Up:          migrationV13Up,
+			Down:        migrationV13Down,
+		},
+		{
+			Version:     14,
+			Description: "Add app passwords for mail and DAV clients",
+			Up:          migrationV14Up,
+			Down:        migrationV14Down,
+		},
+		{
+			Version:     15,
+			Description: "Allow held queue items for quarantined messages",
+			Up:          migrationV15Up,
+			Down:        migrationV15Down,
+		},
+		{
+			Version:     16,
+			Description: "Add per-domain spam backend",
+			Up:          migrationV16Up,
+			Down:        migrationV16Down,
+		},
+		{
+			Version:     17,
+			Description: "Add auto reply period and per-sender reply tracking",
+			Up:          migrationV17Up,
+			Down:        migrationV17Down,
+		},
+		{
+			Version:     18,
+			Description: "Add forwarding keep-copy and external forwarding policy",
+			Up:          migrationV18Up,
+			Down:        migrationV18Down,
+		},
+		{
+			Version:     19,
+			Description: "Add subaddress separator and folder delivery to domains",
+			Up:          migrationV19Up,
+			Down:        migrationV19Down,
+		},
+		{
+			Version:     20,
+			Description: "Add mailbox ACLs",
+			Up:          migrationV20Up,
+			Down:        migrationV20Down,
+		},
+		{
+			Version:     21,
+			Description: "Add per-user message quota",
+			Up:          migrationV21Up,
+			Down:        migrationV21Down,
+		},
+		{
+			Version:     22,
+			Description: "Add per-mailbox message sort indexes",
+			Up:          migrationV22Up,
+			Down:        migrationV22Down,
+		},
+		{
+			Version:     23,
+			Description: "Add IMAP METADATA entries",
+			Up:          migrationV23Up,
+			Down:        migrationV23Down,
+		},
+		{
+			Version:     24,
+			Description: "Add JMAP change log, blobs and email submissions",
+			Up:          migrationV24Up,
+			Down:        migrationV24Down,
+		},
+		{
+			Version:     25,
+			Description: "Add full-text search index of message text",
+			Up:          migrationV25Up,
+			Down:        migrationV25Down,
+		},
+		{
+			Version:     26,
+			Description: "Add content-addressed message bodies",
+			Up:          migrationV26Up,
+			Down:        migrationV26Down,
+		},
+		{
+			Version:     27,
+			Description: "Add spam verdicts to SMTP queue",
+			Up:          migrationV27Up,
+			Down:        migrationV27Down,
+		},
+		{
+			Version:     28,
+			Description: "Add local-only flag to SMTP queue",
+			Up:          migrationV28Up,
+			Down:        migrationV28Down,
+		},
+		{
+			Version:     29,
+			Description: "Add per-recipient status and partial delivery to SMTP queue",
+			Up:          migrationV29Up,
+			Down:        migrationV29Down,
+		},
+		{
+			Version:     30,
+			Description: "Add circuit breaker trip time to provider rate limits",
+			Up:          migrationV30Up,
+			Down:        migrationV30Down,
+		},
+		{
+			Version:     31,
+			Description: "Add subaddress folder creation to domains",
+			Up:          migrationV31Up,
+			Down:        migrationV31Down,
+		},
 	}
 }
 
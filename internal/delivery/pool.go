@@ -0,0 +1,178 @@
+package delivery
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/config"
+	"github.com/btafoya/gomailserver/internal/repository"
+	repRepository "github.com/btafoya/gomailserver/internal/reputation/repository"
+)
+
+// SystemPoolName identifies the implicit pool that uses the default route
+const SystemPoolName = "system"
+
+// Pool is a named set of outbound source addresses sharing a HELO hostname
+type Pool struct {
+	Name         string
+	HeloHostname string
+	IPs          []net.IP
+	next         uint32
+}
+
+// NextIP returns the next source address in round-robin order
+// A nil result means the connection should leave from the default route.
+func (p *Pool) NextIP() net.IP {
+	if len(p.IPs) == 0 {
+		return nil
+	}
+	n := atomic.AddUint32(&p.next, 1) - 1
+	return p.IPs[int(n)%len(p.IPs)]
+}
+
+// PoolManager maps sender domains to outbound IP pools
+type PoolManager struct {
+	pools          map[string]*Pool
+	defaultPool    string
+	quarantinePool string
+	systemPool     *Pool
+	domainRepo     repository.DomainRepository
+	scoresRepo     repRepository.ScoresRepository
+	logger         *zap.Logger
+}
+
+// NewPoolManager creates a pool manager from the delivery configuration
+func NewPoolManager(
+	cfg *config.DeliveryConfig,
+	hostname string,
+	domainRepo repository.DomainRepository,
+	scoresRepo repRepository.ScoresRepository,
+	logger *zap.Logger,
+) *PoolManager {
+	pools := make(map[string]*Pool, len(cfg.IPPools))
+	for _, pc := range cfg.IPPools {
+		pool := &Pool{
+			Name:         pc.Name,
+			HeloHostname: pc.HeloHostname,
+		}
+		for _, ip := range pc.IPs {
+			if parsed := net.ParseIP(ip); parsed != nil {
+				pool.IPs = append(pool.IPs, parsed)
+			}
+		}
+		pools[pc.Name] = pool
+	}
+
+	return &PoolManager{
+		pools:          pools,
+		defaultPool:    cfg.DefaultPool,
+		quarantinePool: cfg.QuarantinePool,
+		systemPool:     &Pool{Name: SystemPoolName, HeloHostname: hostname},
+		domainRepo:     domainRepo,
+		scoresRepo:     scoresRepo,
+		logger:         logger,
+	}
+}
+
+// Select returns the pool a sender domain should deliver from
+// Domains with an active circuit breaker are moved to the quarantine pool.
+func (m *PoolManager) Select(ctx context.Context, senderDomain string) *Pool {
+	senderDomain = strings.ToLower(senderDomain)
+
+	if m.quarantinePool != "" && m.scoresRepo != nil && senderDomain != "" {
+		score, err := m.scoresRepo.GetReputationScore(ctx, senderDomain)
+		if err == nil && score != nil && score.CircuitBreakerActive {
+			if pool, ok := m.pools[m.quarantinePool]; ok {
+				m.logger.Debug("circuit breaker active, using quarantine pool",
+					zap.String("domain", senderDomain),
+					zap.String("pool", pool.Name),
+				)
+				return pool
+			}
+		}
+	}
+
+	if senderDomain != "" {
+		if dom, err := m.domainRepo.GetByName(senderDomain); err == nil && dom.OutboundPool != "" {
+			if pool, ok := m.pools[dom.OutboundPool]; ok {
+				return pool
+			}
+			m.logger.Warn("domain assigned to unknown outbound pool, using default",
+				zap.String("domain", senderDomain),
+				zap.String("pool", dom.OutboundPool),
+			)
+		}
+	}
+
+	if pool, ok := m.pools[m.defaultPool]; ok {
+		return pool
+	}
+
+	return m.systemPool
+}
+
+// VerifyRDNS checks that every pool address has a PTR record matching the pool's
+// HELO hostname, and that the hostname resolves back to the address
+// Mismatches are logged; they do not prevent startup.
+func (m *PoolManager) VerifyRDNS(ctx context.Context, resolver *net.Resolver) {
+	for _, pool := range m.pools {
+		helo := strings.TrimSuffix(strings.ToLower(pool.HeloHostname), ".")
+
+		forward, err := resolver.LookupIPAddr(ctx, helo)
+		if err != nil {
+			m.logger.Warn("outbound pool HELO hostname does not resolve",
+				zap.String("pool", pool.Name),
+				zap.String("helo", helo),
+				zap.Error(err),
+			)
+		}
+
+		for _, ip := range pool.IPs {
+			names, err := resolver.LookupAddr(ctx, ip.String())
+			if err != nil {
+				m.logger.Warn("outbound pool IP has no reverse DNS",
+					zap.String("pool", pool.Name),
+					zap.String("ip", ip.String()),
+					zap.Error(err),
+				)
+				continue
+			}
+
+			matched := false
+			for _, name := range names {
+				if strings.TrimSuffix(strings.ToLower(name), ".") == helo {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				m.logger.Warn("outbound pool reverse DNS does not match HELO hostname",
+					zap.String("pool", pool.Name),
+					zap.String("ip", ip.String()),
+					zap.String("helo", helo),
+					zap.Strings("ptr", names),
+				)
+				continue
+			}
+
+			confirmed := false
+			for _, addr := range forward {
+				if addr.IP.Equal(ip) {
+					confirmed = true
+					break
+				}
+			}
+			if !confirmed {
+				m.logger.Warn("outbound pool HELO hostname does not resolve back to IP",
+					zap.String("pool", pool.Name),
+					zap.String("ip", ip.String()),
+					zap.String("helo", helo),
+				)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/config"
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+// mockDomainRepository is a test double for DomainRepository
+type mockDomainRepository struct {
+	domains map[string]*domain.Domain
+}
+
+func (m *mockDomainRepository) Create(d *domain.Domain) error { return nil }
+func (m *mockDomainRepository) GetByID(id int64) (*domain.Domain, error) {
+	return nil, errors.New("not found")
+}
+func (m *mockDomainRepository) Update(d *domain.Domain) error { return nil }
+func (m *mockDomainRepository) Delete(id int64) error         { return nil }
+func (m *mockDomainRepository) List(offset, limit int) ([]*domain.Domain, error) {
+	return nil, nil
+}
+
+func (m *mockDomainRepository) GetByName(name string) (*domain.Domain, error) {
+	if d, ok := m.domains[name]; ok {
+		return d, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func TestPool_NextIP(t *testing.T) {
+	cfg := &config.DeliveryConfig{
+		IPPools: []config.IPPoolConfig{
+			{Name: "primary", IPs: []string{"192.0.2.1", "192.0.2.2"}, HeloHostname: "mail.example.com"},
+		},
+	}
+	m := NewPoolManager(cfg, "host.example.com", &mockDomainRepository{}, nil, zap.NewNop())
+	pool := m.pools["primary"]
+
+	first, second, third := pool.NextIP(), pool.NextIP(), pool.NextIP()
+	if first.String() != "192.0.2.1" || second.String() != "192.0.2.2" || third.String() != "192.0.2.1" {
+		t.Errorf("expected round-robin order, got %s %s %s", first, second, third)
+	}
+
+	if ip := m.systemPool.NextIP(); ip != nil {
+		t.Errorf("expected system pool to use default route, got %s", ip)
+	}
+}
+
+func TestPoolManager_Select(t *testing.T) {
+	cfg := &config.DeliveryConfig{
+		DefaultPool: "primary",
+		IPPools: []config.IPPoolConfig{
+			{Name: "primary", IPs: []string{"192.0.2.1"}, HeloHostname: "mail.example.com"},
+			{Name: "bulk", IPs: []string{"192.0.2.10"}, HeloHostname: "bulk.example.com"},
+		},
+	}
+	repo := &mockDomainRepository{domains: map[string]*domain.Domain{
+		"news.example.com":  {Name: "news.example.com", OutboundPool: "bulk"},
+		"stale.example.com": {Name: "stale.example.com", OutboundPool: "removed"},
+	}}
+	m := NewPoolManager(cfg, "host.example.com", repo, nil, zap.NewNop())
+	ctx := context.Background()
+
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{"news.example.com", "bulk"},
+		{"NEWS.example.com", "bulk"},
+		{"example.com", "primary"},
+		{"stale.example.com", "primary"},
+		{"", "primary"},
+	}
+	for _, tt := range tests {
+		if got := m.Select(ctx, tt.domain).Name; got != tt.want {
+			t.Errorf("Select(%q) = %s, want %s", tt.domain, got, tt.want)
+		}
+	}
+
+	noDefault := NewPoolManager(&config.DeliveryConfig{}, "host.example.com", repo, nil, zap.NewNop())
+	if got := noDefault.Select(ctx, "example.com"); got.Name != SystemPoolName || got.HeloHostname != "host.example.com" {
+		t.Errorf("expected system pool fallback, got %s (%s)", got.Name, got.HeloHostname)
+	}
+}
@@ -0,0 +1,336 @@
+package delivery
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/idna"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+// ErrNullMX is returned when a destination domain publishes a null MX (RFC 7505)
+var ErrNullMX = errors.New("domain does not accept mail (null MX)")
+
+// ErrBinaryMIMEUnsupported is returned when a BINARYMIME message cannot be relayed
+// because the destination lacks CHUNKING or BINARYMIME (RFC 3030 requires a 5.6.3 bounce)
+var ErrBinaryMIMEUnsupported = errors.New("5.6.3 destination does not support BINARYMIME")
+
+// ErrSMTPUTF8Unsupported is returned when an internationalized message cannot be relayed
+// because the destination lacks SMTPUTF8 (RFC 6531)
+var ErrSMTPUTF8Unsupported = errors.New("5.6.7 destination does not support SMTPUTF8")
+
+// ErrRequireTLS is returned when an MX host cannot satisfy a REQUIRETLS message (RFC 8689)
+var ErrRequireTLS = errors.New("5.7.30 REQUIRETLS not supported by destination")
+
+// RecipientResult holds the outcome of delivering to a single recipient
+type RecipientResult struct {
+	Recipient string
+	Code      int
+	Message   string
+	Permanent bool
+	Err       error
+
+	// RemoteDSN results were accepted by a server that supports DSN and sends
+	// any further notifications itself
+	RemoteDSN bool
+
+	// Throttled results were not attempted because a provider cap was reached;
+	// they are retried at RetryAt without consuming a retry.
+	Throttled bool
+	RetryAt   time.Time
+}
+
+// remoteDelivery describes a single SMTP transaction to one destination domain
+type remoteDelivery struct {
+	pool       *Pool
+	domain     string
+	from       string
+	recipients []string
+	data       []byte
+	bodyType   string
+	smtputf8   bool
+	requireTLS bool
+
+	// DSN parameters relayed when the destination supports DSN
+	dsnReturn     string
+	dsnEnvelopeID string
+	dsn           map[string]domain.RecipientDSN
+}
+
+// lookupMX returns the destination hosts for a domain ordered by preference
+// Falls back to the domain itself (implicit MX) when no MX records exist.
+func lookupMX(ctx context.Context, resolver *net.Resolver, domain string) ([]string, error) {
+	// Internationalized domains are resolved by their A-label
+	if ascii, err := idna.Lookup.ToASCII(domain); err == nil {
+		domain = ascii
+	}
+
+	records, err := resolver.LookupMX(ctx, domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return []string{domain}, nil
+		}
+		return nil, fmt.Errorf("MX lookup failed: %w", err)
+	}
+
+	if len(records) == 1 && (records[0].Host == "." || records[0].Host == "") {
+		return nil, ErrNullMX
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Pref < records[j].Pref
+	})
+
+	hosts := make([]string, 0, len(records))
+	for _, mx := range records {
+		hosts = append(hosts, strings.TrimSuffix(mx.Host, "."))
+	}
+	return hosts, nil
+}
+
+// dial opens a TCP connection to an MX host from the given source address
+func dial(ctx context.Context, localIP net.IP, host string, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	if localIP != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: localIP}
+	}
+	return dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, "25"))
+}
+
+// sendToHost performs one SMTP transaction against an already connected MX host
+// Returns per-recipient results; a non-nil error means the whole transaction failed.
+func sendToHost(conn net.Conn, host string, d *remoteDelivery) ([]RecipientResult, error) {
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	defer client.Close()
+
+	if err := client.Hello(d.pool.HeloHostname); err != nil {
+		return nil, err
+	}
+
+	ext := func(name string) bool {
+		ok, _ := client.Extension(name)
+		return ok
+	}
+
+	if d.requireTLS {
+		// REQUIRETLS: verified TLS to a server that will carry the requirement onwards
+		if !ext("STARTTLS") {
+			client.Quit()
+			return nil, fmt.Errorf("%w: STARTTLS not offered", ErrRequireTLS)
+		}
+		tlsConfig := &tls.Config{
+			ServerName: host,
+			MinVersion: tls.VersionTLS12,
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrRequireTLS, err)
+		}
+		if !ext("REQUIRETLS") {
+			client.Quit()
+			return nil, fmt.Errorf("%w: REQUIRETLS not offered", ErrRequireTLS)
+		}
+	} else if ext("STARTTLS") {
+		// Opportunistic STARTTLS: encrypt when offered, never fail delivery over certificate issues
+		tlsConfig := &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: true,
+			MinVersion:         tls.VersionTLS12,
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return nil, fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+
+	if d.smtputf8 && !ext("SMTPUTF8") {
+		client.Quit()
+		return failAll(d.recipients, ErrSMTPUTF8Unsupported, true), nil
+	}
+
+	binary := strings.EqualFold(d.bodyType, "BINARYMIME")
+	if binary && (!ext("CHUNKING") || !ext("BINARYMIME")) {
+		client.Quit()
+		return failAll(d.recipients, ErrBinaryMIMEUnsupported, true), nil
+	}
+
+	dsn := ext("DSN")
+	if err := cmd(client, 250, "MAIL FROM:<%s>%s", d.from, mailParams(d, binary, ext("8BITMIME"), dsn)); err != nil {
+		return nil, err
+	}
+
+	results := make([]RecipientResult, 0, len(d.recipients))
+	accepted := 0
+	for _, rcpt := range d.recipients {
+		var params string
+		if dsn {
+			params = rcptParams(d.dsn[rcpt])
+		}
+		if err := cmd(client, 25, "RCPT TO:<%s>%s", rcpt, params); err != nil {
+			results = append(results, resultFromError(rcpt, err))
+			continue
+		}
+		accepted++
+		results = append(results, RecipientResult{Recipient: rcpt, Code: 250, RemoteDSN: dsn})
+	}
+
+	if accepted == 0 {
+		client.Quit()
+		return results, nil
+	}
+
+	if err := sendBody(client, d.data, binary); err != nil {
+		var protoErr *textproto.Error
+		if !errors.As(err, &protoErr) {
+			return nil, err
+		}
+		// DATA rejected: applies to every recipient accepted at RCPT time
+		for i := range results {
+			if results[i].Err == nil {
+				results[i] = resultFromError(results[i].Recipient, err)
+			}
+		}
+		return results, nil
+	}
+
+	client.Quit()
+	return results, nil
+}
+
+// sendBody transmits the message with DATA, or with a single BDAT LAST chunk for binary content
+// A *textproto.Error return means the server rejected the message after it was sent.
+func sendBody(client *smtp.Client, data []byte, binary bool) error {
+	if !binary {
+		w, err := client.Data()
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		return w.Close()
+	}
+
+	id, err := client.Text.Cmd("BDAT %d LAST", len(data))
+	if err != nil {
+		return err
+	}
+	client.Text.StartResponse(id)
+	defer client.Text.EndResponse(id)
+
+	if _, err := client.Text.W.Write(data); err != nil {
+		return err
+	}
+	if err := client.Text.W.Flush(); err != nil {
+		return err
+	}
+	_, _, err = client.Text.ReadResponse(250)
+	return err
+}
+
+// cmd sends a raw command and checks its reply code
+// Used for MAIL parameters net/smtp cannot express.
+func cmd(client *smtp.Client, expectCode int, format string, args ...any) error {
+	id, err := client.Text.Cmd(format, args...)
+	if err != nil {
+		return err
+	}
+	client.Text.StartResponse(id)
+	defer client.Text.EndResponse(id)
+	_, _, err = client.Text.ReadResponse(expectCode)
+	return err
+}
+
+// mailParams builds the ESMTP parameters for MAIL FROM
+func mailParams(d *remoteDelivery, binary, eightBit, dsn bool) string {
+	var sb strings.Builder
+	switch {
+	case binary:
+		sb.WriteString(" BODY=BINARYMIME")
+	case eightBit:
+		sb.WriteString(" BODY=8BITMIME")
+	}
+	if d.smtputf8 {
+		sb.WriteString(" SMTPUTF8")
+	}
+	if d.requireTLS {
+		sb.WriteString(" REQUIRETLS")
+	}
+	if dsn {
+		if d.dsnReturn != "" {
+			sb.WriteString(" RET=" + d.dsnReturn)
+		}
+		if d.dsnEnvelopeID != "" {
+			sb.WriteString(" ENVID=" + encodeXtext(d.dsnEnvelopeID))
+		}
+	}
+	return sb.String()
+}
+
+// rcptParams builds the DSN parameters for RCPT TO
+func rcptParams(p domain.RecipientDSN) string {
+	var sb strings.Builder
+	if len(p.Notify) > 0 {
+		sb.WriteString(" NOTIFY=" + strings.Join(p.Notify, ","))
+	}
+	if addrType, addr, ok := strings.Cut(p.ORCPT, ";"); ok {
+		if strings.EqualFold(addrType, "UTF-8") {
+			sb.WriteString(" ORCPT=utf-8;" + encodeUTF8AddrXtext(addr))
+		} else {
+			sb.WriteString(" ORCPT=" + addrType + ";" + encodeXtext(addr))
+		}
+	}
+	return sb.String()
+}
+
+// encodeXtext encodes a DSN parameter value as xtext (RFC 3461 section 4)
+func encodeXtext(raw string) string {
+	var sb strings.Builder
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		if c < '!' || c > '~' || c == '+' || c == '=' {
+			fmt.Fprintf(&sb, "+%02X", c)
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// encodeUTF8AddrXtext encodes an internationalized address as utf-8-addr-xtext (RFC 6533)
+func encodeUTF8AddrXtext(raw string) string {
+	var sb strings.Builder
+	for _, r := range raw {
+		if r < '!' || r > '~' || r == '+' || r == '=' || r == '\\' {
+			fmt.Fprintf(&sb, "\\x{%X}", r)
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// resultFromError classifies an SMTP error as temporary or permanent
+func resultFromError(rcpt string, err error) RecipientResult {
+	result := RecipientResult{Recipient: rcpt, Err: err, Message: err.Error()}
+
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		result.Code = protoErr.Code
+		result.Message = protoErr.Msg
+		result.Permanent = protoErr.Code >= 500
+	}
+	return result
+}
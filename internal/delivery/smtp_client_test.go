@@ -0,0 +1,43 @@
+package delivery
+
+import (
+	"testing"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+func TestMailParams(t *testing.T) {
+	d := &remoteDelivery{
+		smtputf8:      true,
+		requireTLS:    true,
+		dsnReturn:     "HDRS",
+		dsnEnvelopeID: "id+1=2",
+	}
+
+	if got, want := mailParams(d, false, true, true), " BODY=8BITMIME SMTPUTF8 REQUIRETLS RET=HDRS ENVID=id+2B1+3D2"; got != want {
+		t.Errorf("mailParams() = %q, want %q", got, want)
+	}
+
+	// DSN parameters are dropped when the destination does not support DSN
+	if got, want := mailParams(d, true, true, false), " BODY=BINARYMIME SMTPUTF8 REQUIRETLS"; got != want {
+		t.Errorf("mailParams() = %q, want %q", got, want)
+	}
+}
+
+func TestRcptParams(t *testing.T) {
+	tests := []struct {
+		dsn  domain.RecipientDSN
+		want string
+	}{
+		{domain.RecipientDSN{}, ""},
+		{domain.RecipientDSN{Notify: []string{"FAILURE", "DELAY"}}, " NOTIFY=FAILURE,DELAY"},
+		{domain.RecipientDSN{ORCPT: "rfc822;a+b@example.com"}, " ORCPT=rfc822;a+2Bb@example.com"},
+		{domain.RecipientDSN{ORCPT: "UTF-8;jösé@example.com"}, ` ORCPT=utf-8;j\x{F6}s\x{E9}@example.com`},
+	}
+
+	for _, tt := range tests {
+		if got := rcptParams(tt.dsn); got != tt.want {
+			t.Errorf("rcptParams(%+v) = %q, want %q", tt.dsn, got, tt.want)
+		}
+	}
+}
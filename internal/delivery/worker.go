@@ -0,0 +1,662 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/config"
+	"github.com/btafoya/gomailserver/internal/domain"
+	repDomain "github.com/btafoya/gomailserver/internal/reputation/domain"
+	repService "github.com/btafoya/gomailserver/internal/reputation/service"
+	"github.com/btafoya/gomailserver/internal/service"
+)
+
+var (
+	// ErrProviderThrottled is returned for recipients deferred by a provider rate limit
+	ErrProviderThrottled = errors.New("provider rate limit reached")
+	// ErrRelayDenied is returned for remote recipients of mail received from other servers
+	ErrRelayDenied = errors.New("relay access denied")
+)
+
+// providerFailureThreshold is how many consecutive transactions to a provider
+// may fail before its circuit breaker pauses delivery from the sender domain
+const providerFailureThreshold = 5
+
+// delayWarningRetry is the attempt after which recipients that asked for
+// NOTIFY=DELAY are reported as delayed, about four hours after queueing
+const delayWarningRetry = 5
+
+// Worker delivers queued messages to local mailboxes and remote MX hosts
+type Worker struct {
+	cfg           *config.DeliveryConfig
+	queueService  *service.QueueService
+	localDelivery *service.LocalDeliveryService
+	pools         *PoolManager
+	resolver      *net.Resolver
+	logger        *zap.Logger
+	stopChan      chan struct{}
+	wg            sync.WaitGroup
+
+	// Provider-aware throttling (optional)
+	providerLimits   *repService.ProviderRateLimitsService
+	providerSlots    map[repDomain.MailProvider]chan struct{}
+	providerMu       sync.Mutex
+	providerFailures map[string]int // consecutive failed transactions by "domain/provider"
+}
+
+// NewWorker creates a new outbound delivery worker
+func NewWorker(
+	cfg *config.DeliveryConfig,
+	queueService *service.QueueService,
+	localDelivery *service.LocalDeliveryService,
+	pools *PoolManager,
+	logger *zap.Logger,
+) *Worker {
+	providerSlots := make(map[repDomain.MailProvider]chan struct{}, len(cfg.ProviderConcurrency))
+	for provider, n := range cfg.ProviderConcurrency {
+		if n > 0 {
+			providerSlots[repDomain.MailProvider(provider)] = make(chan struct{}, n)
+		}
+	}
+
+	return &Worker{
+		cfg:           cfg,
+		queueService:  queueService,
+		localDelivery: localDelivery,
+		pools:         pools,
+		resolver:      net.DefaultResolver,
+		logger:        logger,
+		stopChan:      make(chan struct{}),
+		providerSlots: providerSlots,
+
+		providerFailures: make(map[string]int),
+	}
+}
+
+// SetProviderLimits enables per-provider hourly/daily caps and circuit breakers
+func (w *Worker) SetProviderLimits(providerLimits *repService.ProviderRateLimitsService) {
+	w.providerLimits = providerLimits
+}
+
+// Start begins polling the queue
+func (w *Worker) Start(ctx context.Context) error {
+	w.logger.Info("starting delivery worker",
+		zap.Int("workers", w.cfg.Workers),
+		zap.Int("poll_interval", w.cfg.PollInterval),
+	)
+
+	if n, err := w.queueService.RequeueStale(); err != nil {
+		w.logger.Warn("failed to requeue stale queue items", zap.Error(err))
+	} else if n > 0 {
+		w.logger.Info("requeued interrupted deliveries", zap.Int64("count", n))
+	}
+
+	go w.pools.VerifyRDNS(ctx, w.resolver)
+
+	w.wg.Add(1)
+	go w.run(ctx)
+
+	return nil
+}
+
+// Stop stops the worker and waits for in-flight deliveries to finish
+func (w *Worker) Stop() error {
+	w.logger.Info("stopping delivery worker")
+	close(w.stopChan)
+	w.wg.Wait()
+	return nil
+}
+
+// run is the queue polling loop
+func (w *Worker) run(ctx context.Context) {
+	defer w.wg.Done()
+
+	interval := time.Duration(w.cfg.PollInterval) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	workers := w.cfg.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+
+	for {
+		w.poll(ctx, sem)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll claims pending queue items and dispatches them to delivery goroutines
+func (w *Worker) poll(ctx context.Context, sem chan struct{}) {
+	items, err := w.queueService.GetPending()
+	if err != nil {
+		w.logger.Error("failed to get pending queue items", zap.Error(err))
+		return
+	}
+
+	for _, item := range items {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.stopChan:
+			return
+		case sem <- struct{}{}:
+		}
+
+		if err := w.queueService.MarkProcessing(item.ID); err != nil {
+			w.logger.Error("failed to claim queue item", zap.Int64("id", item.ID), zap.Error(err))
+			<-sem
+			continue
+		}
+
+		w.wg.Add(1)
+		go func(item *domain.QueueItem) {
+			defer w.wg.Done()
+			defer func() { <-sem }()
+			w.process(ctx, item)
+		}(item)
+	}
+}
+
+// process attempts delivery of a single queue item to all of its recipients
+func (w *Worker) process(ctx context.Context, item *domain.QueueItem) {
+	logger := w.logger.With(
+		zap.Int64("queue_id", item.ID),
+		zap.String("message_id", item.MessageID),
+	)
+
+	data, err := os.ReadFile(item.MessagePath)
+	if err != nil {
+		logger.Error("failed to read queued message", zap.Error(err))
+		w.queueService.MarkFailed(item.ID, fmt.Sprintf("failed to read message: %v", err))
+		return
+	}
+
+	recipients, err := service.DecodeRecipients(item.Recipients)
+	if err != nil {
+		logger.Error("invalid queue recipients", zap.Error(err))
+		w.queueService.MarkFailed(item.ID, err.Error())
+		return
+	}
+
+	senderDomain := domainOf(item.Sender)
+
+	var deferred, throttled []string
+	var outcomes, bounced, succeeded []domain.RecipientStatus
+	var lastError string
+	var resumeAt time.Time
+	now := time.Now()
+
+	for rcptDomain, rcpts := range groupByDomain(recipients) {
+		var results []RecipientResult
+		remote := false
+		switch {
+		case w.localDelivery.IsLocalDomain(rcptDomain):
+			results = w.deliverLocal(ctx, item.Sender, rcpts, data)
+		case item.LocalOnly:
+			// Mail received from other servers is never relayed
+			results = failAll(rcpts, ErrRelayDenied, true)
+		default:
+			remote = true
+			results = w.deliverRemote(ctx, senderDomain, rcptDomain, item, rcpts, data, logger)
+		}
+
+		for _, r := range results {
+			switch {
+			case r.Err == nil:
+				outcome := recipientStatus(r, domain.RecipientDelivered, now)
+				outcome.Relayed = remote && !r.RemoteDSN
+				outcomes = append(outcomes, outcome)
+				// A destination with DSN support reports success itself
+				if !r.RemoteDSN {
+					succeeded = append(succeeded, outcome)
+				}
+			case r.Throttled:
+				throttled = append(throttled, r.Recipient)
+				if resumeAt.IsZero() || r.RetryAt.Before(resumeAt) {
+					resumeAt = r.RetryAt
+				}
+			case r.Permanent:
+				outcome := recipientStatus(r, domain.RecipientFailed, now)
+				outcomes = append(outcomes, outcome)
+				bounced = append(bounced, outcome)
+				lastError = fmt.Sprintf("%s: %s", r.Recipient, r.Message)
+				logger.Warn("permanent delivery failure",
+					zap.String("recipient", r.Recipient),
+					zap.Int("code", r.Code),
+					zap.String("response", r.Message),
+				)
+			default:
+				deferred = append(deferred, r.Recipient)
+				outcomes = append(outcomes, recipientStatus(r, domain.RecipientDeferred, now))
+				lastError = fmt.Sprintf("%s: %s", r.Recipient, r.Message)
+			}
+		}
+	}
+
+	// Recipients still failing after the last retry bounce like permanent failures
+	if len(deferred) > 0 && item.RetryCount+1 >= item.MaxRetries {
+		logger.Warn("queue item exceeded max retries", zap.Strings("recipients", deferred))
+		for i := range outcomes {
+			if outcomes[i].Status == domain.RecipientDeferred {
+				outcomes[i].Status = domain.RecipientFailed
+				bounced = append(bounced, outcomes[i])
+			}
+		}
+		deferred = nil
+	}
+
+	statuses, err := w.queueService.RecordRecipientStatus(item, outcomes)
+	if err != nil {
+		logger.Error("failed to record recipient status", zap.Error(err))
+	}
+	if err := w.queueService.Bounce(item, data, bounced); err != nil {
+		logger.Error("failed to send non-delivery report", zap.Error(err))
+	}
+	if err := w.queueService.ReportSuccess(item, data, succeeded); err != nil {
+		logger.Error("failed to send success report", zap.Error(err))
+	}
+	if len(deferred) > 0 && item.RetryCount == delayWarningRetry {
+		var delayed []domain.RecipientStatus
+		for _, outcome := range outcomes {
+			if outcome.Status == domain.RecipientDeferred {
+				delayed = append(delayed, outcome)
+			}
+		}
+		if err := w.queueService.ReportDelay(item, data, delayed); err != nil {
+			logger.Error("failed to send delay report", zap.Error(err))
+		}
+	}
+
+	if len(deferred) == 0 && len(throttled) == 0 {
+		w.finish(item, statuses, lastError, logger)
+		return
+	}
+
+	remaining := append(deferred, throttled...)
+	if len(remaining) < len(recipients) {
+		if err := w.queueService.UpdateRecipients(item.ID, remaining); err != nil {
+			logger.Error("failed to update queue recipients", zap.Error(err))
+		}
+	}
+
+	// Throttling alone never consumes a retry; the item waits for the provider window
+	if len(deferred) == 0 {
+		if err := w.queueService.Reschedule(item.ID, item.RetryCount, resumeAt, ErrProviderThrottled.Error()); err != nil {
+			logger.Error("failed to reschedule throttled queue item", zap.Error(err))
+			return
+		}
+		logger.Info("queue item throttled",
+			zap.Strings("recipients", throttled),
+			zap.Time("resume_at", resumeAt),
+		)
+		return
+	}
+
+	if err := w.queueService.Defer(item.ID, item.RetryCount, lastError, time.Now()); err != nil {
+		logger.Error("failed to defer queue item", zap.Error(err))
+		return
+	}
+	logger.Info("queue item deferred",
+		zap.Strings("recipients", deferred),
+		zap.String("error", lastError),
+	)
+}
+
+// finish records the final status of an item once no recipient awaits delivery
+// An item is delivered only if every recipient was; one with both delivered
+// and failed recipients is partial and keeps just the failed ones for a retry.
+func (w *Worker) finish(item *domain.QueueItem, statuses []domain.RecipientStatus, lastError string, logger *zap.Logger) {
+	var delivered, failed []string
+	for _, st := range statuses {
+		switch st.Status {
+		case domain.RecipientDelivered:
+			delivered = append(delivered, st.Recipient)
+		case domain.RecipientFailed:
+			failed = append(failed, st.Recipient)
+		}
+	}
+
+	if len(failed) == 0 {
+		if err := w.queueService.MarkDelivered(item.ID); err != nil {
+			logger.Error("failed to mark queue item delivered", zap.Error(err))
+			return
+		}
+		os.Remove(item.MessagePath)
+		logger.Info("queue item delivered", zap.Int("delivered", len(delivered)))
+		return
+	}
+
+	if err := w.queueService.UpdateRecipients(item.ID, failed); err != nil {
+		logger.Error("failed to update queue recipients", zap.Error(err))
+	}
+	if len(delivered) == 0 {
+		w.queueService.MarkFailed(item.ID, lastError)
+		return
+	}
+	if err := w.queueService.MarkPartial(item.ID, lastError); err != nil {
+		logger.Error("failed to mark queue item partially delivered", zap.Error(err))
+		return
+	}
+	logger.Info("queue item partially delivered",
+		zap.Int("delivered", len(delivered)),
+		zap.Int("failed", len(failed)),
+	)
+}
+
+// deliverLocal stores the message for recipients in hosted domains
+func (w *Worker) deliverLocal(ctx context.Context, sender string, rcpts []string, data []byte) []RecipientResult {
+	results := make([]RecipientResult, 0, len(rcpts))
+	for _, rcpt := range rcpts {
+		err := w.localDelivery.Deliver(ctx, sender, rcpt, data)
+		if err == nil {
+			results = append(results, RecipientResult{Recipient: rcpt, Code: 250})
+			continue
+		}
+
+		permanent := errors.Is(err, service.ErrRecipientNotFound) || errors.Is(err, service.ErrUserDisabled)
+		code := 451
+		if permanent {
+			code = 550
+		}
+		results = append(results, RecipientResult{
+			Recipient: rcpt,
+			Code:      code,
+			Err:       err,
+			Message:   err.Error(),
+			Permanent: permanent,
+		})
+	}
+	return results
+}
+
+// deliverRemote relays the message to a destination domain's MX hosts from the sender's IP pool
+func (w *Worker) deliverRemote(ctx context.Context, senderDomain, rcptDomain string, item *domain.QueueItem, rcpts []string, data []byte, logger *zap.Logger) []RecipientResult {
+	pool := w.pools.Select(ctx, senderDomain)
+
+	hosts, err := lookupMX(ctx, w.resolver, rcptDomain)
+	if err != nil {
+		return failAll(rcpts, err, errors.Is(err, ErrNullMX))
+	}
+
+	provider := repService.ProviderForMX(hosts[0])
+	if resumeAt := w.reserveProviderSends(ctx, senderDomain, provider, len(rcpts)); !resumeAt.IsZero() {
+		logger.Debug("provider throttled",
+			zap.String("domain", senderDomain),
+			zap.String("provider", string(provider)),
+			zap.Time("resume_at", resumeAt),
+		)
+		return throttleAll(rcpts, resumeAt)
+	}
+
+	release, err := w.acquireProviderSlot(ctx, provider)
+	if err != nil {
+		w.releaseProviderSends(ctx, senderDomain, provider, len(rcpts))
+		return failAll(rcpts, err, false)
+	}
+	defer release()
+
+	dsn, err := service.DecodeRecipientDSN(item.DSNRecipients)
+	if err != nil {
+		logger.Warn("ignoring invalid DSN parameters", zap.Error(err))
+	}
+
+	timeout := time.Duration(w.cfg.ConnectTimeout) * time.Second
+	d := &remoteDelivery{
+		pool:          pool,
+		domain:        rcptDomain,
+		from:          item.Sender,
+		recipients:    rcpts,
+		data:          data,
+		bodyType:      item.BodyType,
+		smtputf8:      item.SMTPUTF8,
+		requireTLS:    item.RequireTLS,
+		dsnReturn:     item.DSNReturn,
+		dsnEnvelopeID: item.DSNEnvelopeID,
+		dsn:           dsn,
+	}
+
+	var lastErr error
+	for _, host := range hosts {
+		localIP := pool.NextIP()
+		conn, err := dial(ctx, localIP, host, timeout)
+		if err != nil {
+			lastErr = err
+			logger.Debug("MX connection failed",
+				zap.String("mx", host),
+				zap.String("pool", pool.Name),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		// Record the address actually used so per-IP reputation reflects the pool
+		sourceIP := conn.LocalAddr().(*net.TCPAddr).IP.String()
+
+		results, err := sendToHost(conn, host, d)
+		if err != nil {
+			lastErr = err
+			r := resultFromError("", err)
+			if r.Permanent {
+				w.recordBounce(ctx, senderDomain, rcptDomain, sourceIP, r)
+				w.releaseProviderSends(ctx, senderDomain, provider, len(rcpts))
+				w.recordProviderResult(ctx, senderDomain, provider, false)
+				return failAll(rcpts, err, true)
+			}
+			logger.Debug("MX transaction failed", zap.String("mx", host), zap.Error(err))
+			continue
+		}
+
+		accepted, deferred := 0, 0
+		for _, r := range results {
+			if r.Err == nil {
+				accepted++
+				w.queueService.RecordDeliveryTelemetry(ctx, senderDomain, rcptDomain, sourceIP)
+			} else {
+				w.recordBounce(ctx, senderDomain, rcptDomain, sourceIP, r)
+				if !r.Permanent {
+					deferred++
+				}
+			}
+		}
+		w.releaseProviderSends(ctx, senderDomain, provider, len(rcpts)-accepted)
+		// Recipients rejected one by one say nothing about the provider, but a
+		// transaction deferred for every recipient looks like throttling or a block
+		switch {
+		case accepted > 0:
+			w.recordProviderResult(ctx, senderDomain, provider, true)
+		case deferred == len(results):
+			w.recordProviderResult(ctx, senderDomain, provider, false)
+		}
+
+		logger.Info("remote delivery attempted",
+			zap.String("mx", host),
+			zap.String("pool", pool.Name),
+			zap.String("source_ip", sourceIP),
+			zap.String("helo", pool.HeloHostname),
+		)
+		return results
+	}
+
+	w.releaseProviderSends(ctx, senderDomain, provider, len(rcpts))
+	w.recordProviderResult(ctx, senderDomain, provider, false)
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no MX hosts reachable for %s", rcptDomain)
+	}
+	// No MX could meet REQUIRETLS; RFC 8689 forbids relaying without it
+	return failAll(rcpts, lastErr, errors.Is(lastErr, ErrRequireTLS))
+}
+
+// reserveProviderSends claims count sends against a provider's caps for a sender domain
+// It returns when delivery may resume, or the zero time once the sends are reserved.
+func (w *Worker) reserveProviderSends(ctx context.Context, senderDomain string, provider repDomain.MailProvider, count int) time.Time {
+	if w.providerLimits == nil || senderDomain == "" {
+		return time.Time{}
+	}
+
+	resumeAt, err := w.providerLimits.Reserve(ctx, senderDomain, provider, count)
+	if err != nil {
+		// Fail open: a limits lookup error should not stall the queue
+		w.logger.Warn("failed to check provider rate limit",
+			zap.String("domain", senderDomain),
+			zap.String("provider", string(provider)),
+			zap.Error(err),
+		)
+		return time.Time{}
+	}
+	return resumeAt
+}
+
+// releaseProviderSends returns reserved sends that were not accepted to the provider caps
+func (w *Worker) releaseProviderSends(ctx context.Context, senderDomain string, provider repDomain.MailProvider, count int) {
+	if w.providerLimits == nil || senderDomain == "" || count == 0 {
+		return
+	}
+	if err := w.providerLimits.Release(ctx, senderDomain, provider, count); err != nil {
+		w.logger.Warn("failed to release provider sends",
+			zap.String("domain", senderDomain),
+			zap.String("provider", string(provider)),
+			zap.Error(err),
+		)
+	}
+}
+
+// recordProviderResult tracks consecutive failed transactions from a sender
+// domain to a provider and trips the provider's circuit breaker once they
+// reach providerFailureThreshold. Generic destinations share no
+// infrastructure, so their failures never pause delivery.
+func (w *Worker) recordProviderResult(ctx context.Context, senderDomain string, provider repDomain.MailProvider, ok bool) {
+	if w.providerLimits == nil || senderDomain == "" || provider == repDomain.ProviderGeneric {
+		return
+	}
+
+	key := senderDomain + "/" + string(provider)
+	w.providerMu.Lock()
+	if ok {
+		delete(w.providerFailures, key)
+		w.providerMu.Unlock()
+		return
+	}
+	w.providerFailures[key]++
+	trip := w.providerFailures[key] >= providerFailureThreshold
+	if trip {
+		delete(w.providerFailures, key)
+	}
+	w.providerMu.Unlock()
+
+	if !trip {
+		return
+	}
+	if err := w.providerLimits.SetCircuitBreaker(ctx, senderDomain, provider, true); err != nil {
+		w.logger.Error("failed to trip provider circuit breaker",
+			zap.String("domain", senderDomain),
+			zap.String("provider", string(provider)),
+			zap.Error(err),
+		)
+	}
+}
+
+// acquireProviderSlot blocks until a connection slot to the provider is free
+func (w *Worker) acquireProviderSlot(ctx context.Context, provider repDomain.MailProvider) (func(), error) {
+	slots, ok := w.providerSlots[provider]
+	if !ok {
+		return func() {}, nil
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-w.stopChan:
+		return nil, errors.New("delivery worker stopping")
+	}
+}
+
+// recordBounce records bounce telemetry for a failed recipient
+func (w *Worker) recordBounce(ctx context.Context, senderDomain, rcptDomain, ip string, r RecipientResult) {
+	bounceType := "soft"
+	if r.Permanent {
+		bounceType = "hard"
+	}
+	w.queueService.RecordBounceTelemetry(ctx, senderDomain, rcptDomain, ip, bounceType, fmt.Sprintf("%d", r.Code), r.Message)
+}
+
+// recipientStatus converts a delivery result into the outcome recorded on the queue item
+func recipientStatus(r RecipientResult, status string, at time.Time) domain.RecipientStatus {
+	return domain.RecipientStatus{
+		Recipient: r.Recipient,
+		Status:    status,
+		Code:      r.Code,
+		Message:   r.Message,
+		UpdatedAt: at,
+	}
+}
+
+// failAll returns the same failure for every recipient
+func failAll(rcpts []string, err error, permanent bool) []RecipientResult {
+	results := make([]RecipientResult, 0, len(rcpts))
+	for _, rcpt := range rcpts {
+		results = append(results, RecipientResult{
+			Recipient: rcpt,
+			Err:       err,
+			Message:   err.Error(),
+			Permanent: permanent,
+		})
+	}
+	return results
+}
+
+// throttleAll defers every recipient until the provider window reopens
+func throttleAll(rcpts []string, retryAt time.Time) []RecipientResult {
+	results := make([]RecipientResult, 0, len(rcpts))
+	for _, rcpt := range rcpts {
+		results = append(results, RecipientResult{
+			Recipient: rcpt,
+			Err:       ErrProviderThrottled,
+			Message:   ErrProviderThrottled.Error(),
+			Throttled: true,
+			RetryAt:   retryAt,
+		})
+	}
+	return results
+}
+
+// groupByDomain groups recipients by their lowercased domain
+func groupByDomain(recipients []string) map[string][]string {
+	groups := make(map[string][]string)
+	for _, rcpt := range recipients {
+		d := domainOf(rcpt)
+		groups[d] = append(groups[d], rcpt)
+	}
+	return groups
+}
+
+// domainOf extracts the lowercased domain part of an address
+func domainOf(addr string) string {
+	at := strings.LastIndex(addr, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(addr[at+1:])
+}
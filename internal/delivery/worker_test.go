@@ -0,0 +1,149 @@
+package delivery
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	repDomain "github.com/btafoya/gomailserver/internal/reputation/domain"
+	repSQLite "github.com/btafoya/gomailserver/internal/reputation/repository/sqlite"
+	repService "github.com/btafoya/gomailserver/internal/reputation/service"
+	"github.com/btafoya/gomailserver/internal/service"
+)
+
+// statusQueueRepository records the status and recipients a worker leaves on an item
+type statusQueueRepository struct {
+	status     string
+	recipients string
+}
+
+func (r *statusQueueRepository) Enqueue(item *domain.QueueItem) error     { return nil }
+func (r *statusQueueRepository) GetPending() ([]*domain.QueueItem, error) { return nil, nil }
+func (r *statusQueueRepository) GetByStatus(status string) ([]*domain.QueueItem, error) {
+	return nil, nil
+}
+func (r *statusQueueRepository) GetByID(id int64) (*domain.QueueItem, error) { return nil, nil }
+func (r *statusQueueRepository) UpdateStatus(id int64, status string, errorMsg string) error {
+	r.status = status
+	return nil
+}
+func (r *statusQueueRepository) UpdateRetry(id int64, retryCount int, nextRetry time.Time) error {
+	return nil
+}
+func (r *statusQueueRepository) UpdateRecipients(id int64, recipients string) error {
+	r.recipients = recipients
+	return nil
+}
+func (r *statusQueueRepository) UpdateRecipientStatus(id int64, recipientStatus string) error {
+	return nil
+}
+func (r *statusQueueRepository) RequeueProcessing() (int64, error) { return 0, nil }
+func (r *statusQueueRepository) Delete(id int64) error             { return nil }
+
+func TestWorkerFinish(t *testing.T) {
+	delivered := domain.RecipientStatus{Recipient: "a@remote.test", Status: domain.RecipientDelivered}
+	failed := domain.RecipientStatus{Recipient: "b@remote.test", Status: domain.RecipientFailed}
+
+	tests := []struct {
+		name       string
+		statuses   []domain.RecipientStatus
+		status     string
+		recipients string
+	}{
+		{"all delivered", []domain.RecipientStatus{delivered}, "delivered", ""},
+		{"all failed", []domain.RecipientStatus{failed}, "failed", `["b@remote.test"]`},
+		{"some failed", []domain.RecipientStatus{delivered, failed}, "partial", `["b@remote.test"]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &statusQueueRepository{}
+			w := &Worker{queueService: service.NewQueueService(repo, nil, zap.NewNop()), logger: zap.NewNop()}
+
+			w.finish(&domain.QueueItem{ID: 1, MessagePath: t.TempDir() + "/missing.eml"}, tt.statuses, "b@remote.test: user unknown", w.logger)
+
+			if repo.status != tt.status {
+				t.Errorf("status = %q, want %q", repo.status, tt.status)
+			}
+			// Only failed recipients stay on the item, so a retry does not deliver twice
+			if repo.recipients != tt.recipients {
+				t.Errorf("recipients = %q, want %q", repo.recipients, tt.recipients)
+			}
+		})
+	}
+}
+
+func TestWorkerRecordProviderResult(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`
+		CREATE TABLE provider_rate_limits (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			domain TEXT NOT NULL,
+			provider TEXT NOT NULL,
+			max_hourly_rate INTEGER NOT NULL,
+			max_daily_rate INTEGER,
+			current_hour_count INTEGER DEFAULT 0,
+			current_day_count INTEGER DEFAULT 0,
+			hour_reset_at INTEGER NOT NULL,
+			day_reset_at INTEGER NOT NULL,
+			circuit_breaker_active BOOLEAN DEFAULT 0,
+			circuit_breaker_at INTEGER DEFAULT 0,
+			last_updated INTEGER NOT NULL,
+			UNIQUE(domain, provider)
+		)`); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+
+	limits := repService.NewProviderRateLimitsService(repSQLite.NewProviderRateLimitsRepository(db), repSQLite.NewAlertsRepository(db), zap.NewNop())
+	ctx := context.Background()
+	if err := limits.InitializeDefaultLimits(ctx, "example.com"); err != nil {
+		t.Fatalf("failed to initialize limits: %v", err)
+	}
+
+	w := &Worker{logger: zap.NewNop(), providerLimits: limits, providerFailures: make(map[string]int)}
+	breakerActive := func(provider repDomain.MailProvider) bool {
+		limit, err := limits.GetLimit(ctx, "example.com", provider)
+		if err != nil {
+			t.Fatalf("failed to get limit: %v", err)
+		}
+		return limit.CircuitBreakerActive
+	}
+
+	// A success in between restarts the count
+	for i := 0; i < providerFailureThreshold-1; i++ {
+		w.recordProviderResult(ctx, "example.com", repDomain.ProviderGmail, false)
+	}
+	w.recordProviderResult(ctx, "example.com", repDomain.ProviderGmail, true)
+	w.recordProviderResult(ctx, "example.com", repDomain.ProviderGmail, false)
+	if breakerActive(repDomain.ProviderGmail) {
+		t.Fatal("expected the breaker closed below the threshold")
+	}
+
+	for i := 1; i < providerFailureThreshold; i++ {
+		w.recordProviderResult(ctx, "example.com", repDomain.ProviderGmail, false)
+	}
+	if !breakerActive(repDomain.ProviderGmail) {
+		t.Error("expected the gmail breaker tripped at the threshold")
+	}
+	if breakerActive(repDomain.ProviderYahoo) {
+		t.Error("expected other providers unaffected")
+	}
+
+	// Generic destinations never trip a breaker
+	for i := 0; i < providerFailureThreshold; i++ {
+		w.recordProviderResult(ctx, "example.com", repDomain.ProviderGeneric, false)
+	}
+	if breakerActive(repDomain.ProviderGeneric) {
+		t.Error("expected no breaker for generic destinations")
+	}
+}
@@ -35,6 +35,7 @@ const (
 	ActionUserLogin          = "user.login"
 	ActionUserLoginFailed    = "user.login_failed"
 	ActionUserLogout         = "user.logout"
+	ActionUserForwarding     = "user.forwarding_changed"
 
 	ActionDomainCreated = "domain.created"
 	ActionDomainUpdated = "domain.updated"
@@ -0,0 +1,61 @@
+package domain
+
+// MessageRange is an inclusive range of UIDs or sequence numbers; 0 stands
+// for the last one in use, like "*" in IMAP
+type MessageRange struct {
+	Start uint32
+	Stop  uint32
+}
+
+// Message sort keys, each ordering by an indexed message column
+const (
+	MessageOrderArrival = "arrival" // internal date
+	MessageOrderSubject = "subject"
+	MessageOrderFrom    = "from"
+	MessageOrderTo      = "to"
+	MessageOrderCc      = "cc"
+	MessageOrderSize    = "size"
+)
+
+// Message thread groupings
+const (
+	MessageThreadID      = "thread_id" // the thread ID derived from the references
+	MessageThreadSubject = "subject"
+)
+
+// MessageOrder is one sort key of a MessageQuery
+type MessageOrder struct {
+	Key     string
+	Reverse bool
+}
+
+// MessageQuery selects and orders the messages of a mailbox
+// Every condition must hold; a nil range list matches every message.
+// Messages are ordered by Order, then by UID. With Thread set, messages are
+// grouped into threads instead: threads by their earliest internal date, and
+// the messages of a thread by internal date.
+type MessageQuery struct {
+	MailboxID    int64
+	UIDs         []MessageRange
+	SeqNums      []MessageRange
+	WithFlags    []string
+	WithoutFlags []string
+	Larger       int64 // 0 is no limit
+	Smaller      int64 // 0 is no limit
+	Order        []MessageOrder
+	Thread       string
+}
+
+// MessageMatch is a message found by a MessageQuery, without content
+type MessageMatch struct {
+	SeqNum  uint32
+	Message *Message
+}
+
+// MessageQueryResult holds the messages matching a MessageQuery
+// Count and LastUID describe the whole mailbox.
+type MessageQueryResult struct {
+	Matches []MessageMatch
+	Count   uint32
+	LastUID uint32
+}
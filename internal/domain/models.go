@@ -46,11 +46,12 @@ type Domain struct {
 	ClamAVVirusAction  string `json:"clamav_virus_action"`
 	ClamAVFailAction   string `json:"clamav_fail_action"`
 
-	// SpamAssassin configuration
+	// Spam filtering configuration
 	SpamEnabled           bool    `json:"spam_enabled"`
 	SpamRejectScore       float64 `json:"spam_reject_score"`
 	SpamQuarantineScore   float64 `json:"spam_quarantine_score"`
 	SpamLearningEnabled   bool    `json:"spam_learning_enabled"`
+	SpamBackend           string  `json:"spam_backend"` // "spamassassin" or "rspamd"
 
 	// Greylisting configuration
 	GreylistEnabled         bool `json:"greylist_enabled"`
@@ -77,29 +78,51 @@ type Domain struct {
 	AuthIPBlacklistEnabled       bool `json:"auth_ip_blacklist_enabled"`
 	AuthCleanupInterval          int  `json:"auth_cleanup_interval"`
 
+	// Forwarding policy
+	ForwardExternalEnabled bool `json:"forward_external_enabled"` // users may forward to domains not hosted here
+
+	// Recipient routing
+	SubaddressSeparator     string `json:"subaddress_separator"`      // characters ending the local part in user+tag addresses; empty disables
+	SubaddressFolders       bool   `json:"subaddress_folders"`        // file tagged mail into a folder named after the tag
+	SubaddressCreateFolders bool   `json:"subaddress_create_folders"` // create a missing tag folder instead of delivering to INBOX
+
+	// Outbound delivery
+	OutboundPool string `json:"outbound_pool,omitempty"` // Named IP pool from delivery config; empty uses the default pool
+
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// Spam backends a domain can score inbound mail with
+const (
+	SpamBackendSpamAssassin = "spamassassin"
+	SpamBackendRspamd       = "rspamd"
+)
+
 // User represents a mail user
 type User struct {
 	ID               int64      `json:"id"`
 	Email            string     `json:"email"`
 	DomainID         int64      `json:"domain_id"`
 	PasswordHash     string     `json:"-"`
+	SCRAMSHA256      string     `json:"-"` // SCRAM-SHA-256 salted keys derived from the password
 	FullName         string     `json:"full_name,omitempty"`
 	DisplayName      string     `json:"display_name,omitempty"`
 	Role             string     `json:"role"`                     // admin or user
-	Quota            int64      `json:"quota"`
-	UsedQuota        int64      `json:"used_quota"`
+	Quota            int64      `json:"quota"`                    // storage limit in bytes; 0 is unlimited
+	UsedQuota        int64      `json:"used_quota"`               // maintained with every stored and deleted message
+	MessageQuota     int64      `json:"message_quota"`            // limit on the number of messages; 0 is unlimited
 	Status           string     `json:"status"`
 	AuthMethod       string     `json:"auth_method"`
 	TOTPSecret       string     `json:"-"`
 	TOTPEnabled      bool       `json:"totp_enabled"`
-	ForwardTo        string     `json:"forward_to,omitempty"`
+	ForwardTo        string     `json:"forward_to,omitempty"` // comma-separated forwarding addresses
+	ForwardKeepCopy  bool       `json:"forward_keep_copy"`    // also deliver forwarded mail to the INBOX
 	AutoReplyEnabled bool       `json:"auto_reply_enabled"`
 	AutoReplySubject string     `json:"auto_reply_subject,omitempty"`
 	AutoReplyBody    string     `json:"auto_reply_body,omitempty"`
+	AutoReplyStart   *time.Time `json:"auto_reply_start,omitempty"` // replies are sent from this time; nil starts immediately
+	AutoReplyEnd     *time.Time `json:"auto_reply_end,omitempty"`   // and until this time; nil has no end
 	SpamThreshold    float64    `json:"spam_threshold"`
 	Language         string     `json:"language"`
 	LastLogin        *time.Time `json:"last_login,omitempty"`
@@ -117,6 +140,40 @@ type Alias struct {
 	CreatedAt         time.Time `json:"created_at"`
 }
 
+// Sender grant types
+const (
+	SenderGrantSendAs       = "send_as"        // envelope and header From may use the address
+	SenderGrantSendOnBehalf = "send_on_behalf" // header From only; Sender identifies the user
+)
+
+// SenderGrant authorizes a user to submit mail from an address they do not own
+type SenderGrant struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Address   string    `json:"address"` // full address, or "@domain" for the whole domain
+	GrantType string    `json:"grant_type"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// App password scopes
+const (
+	AppPasswordScopeIMAP = "imap"
+	AppPasswordScopeSMTP = "smtp"
+	AppPasswordScopeDAV  = "dav"
+)
+
+// AppPassword is a generated password for one mail or DAV client
+// Accounts that require TOTP can only sign in to IMAP, SMTP and WebDAV with these.
+type AppPassword struct {
+	ID           int64      `json:"id"`
+	UserID       int64      `json:"user_id"`
+	Name         string     `json:"name"`
+	PasswordHash string     `json:"-"`
+	Scopes       string     `json:"scopes"` // JSON array ["imap","smtp","dav"]
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
 // Mailbox represents a mail folder
 type Mailbox struct {
 	ID          int64     `json:"id"`
@@ -130,6 +187,111 @@ type Mailbox struct {
 	CreatedAt   time.Time `json:"created_at"`
 }
 
+// ACLAnyone is the ACL identifier granting rights to every authenticated user
+const ACLAnyone = "anyone"
+
+// MailboxACL grants an identifier rights on another user's mailbox (RFC 4314)
+type MailboxACL struct {
+	MailboxID  int64     `json:"mailbox_id"`
+	Identifier string    `json:"identifier"` // email address or "anyone"
+	Rights     string    `json:"rights"`     // e.g. "lrs"
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// MetadataEntry is an IMAP METADATA annotation (RFC 5464)
+// Entries named /private/... belong to one user; /shared/... entries are
+// seen by everyone with access to the mailbox, or the server for MailboxID 0.
+type MetadataEntry struct {
+	UserID    int64     `json:"user_id"`    // 0 for /shared entries
+	MailboxID int64     `json:"mailbox_id"` // 0 for server entries
+	Name      string    `json:"name"`       // e.g. "/private/comment"
+	Value     []byte    `json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Object types tracked in the change log
+const (
+	ChangeTypeMailbox         = "Mailbox"
+	ChangeTypeEmail           = "Email"
+	ChangeTypeThread          = "Thread"
+	ChangeTypeEmailSubmission = "EmailSubmission"
+)
+
+// Kinds of change
+const (
+	ChangeCreated   = "created"
+	ChangeUpdated   = "updated"
+	ChangeDestroyed = "destroyed"
+)
+
+// Change records that a user's mailbox, message, thread or submission was
+// created, updated or destroyed. IDs increase, so the latest ID of a type is
+// the state of that type for JMAP synchronization (RFC 8620 section 5.2).
+type Change struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Type      string    `json:"type"`
+	ObjectID  string    `json:"object_id"`
+	Kind      string    `json:"kind"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Blob is binary data uploaded by a JMAP client, kept until it expires
+type Blob struct {
+	ID        string    `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Type      string    `json:"type"`
+	Size      int64     `json:"size"`
+	Data      []byte    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// EmailSubmission records a message sent through JMAP (RFC 8621 section 7)
+type EmailSubmission struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"user_id"`
+	IdentityID string    `json:"identity_id"`
+	MessageID  int64     `json:"message_id"`
+	ThreadID   string    `json:"thread_id"`
+	MailFrom   string    `json:"mail_from"`
+	RcptTo     []string  `json:"rcpt_to"`
+	QueueID    string    `json:"queue_id"`
+	UndoStatus string    `json:"undo_status"` // pending, final or canceled
+	SendAt     time.Time `json:"send_at"`
+}
+
+// SearchDocument is the text of a message indexed for full-text search
+// Body holds the decoded text parts, with HTML reduced to text; Attachments
+// holds attachment file names and the text extracted from them.
+type SearchDocument struct {
+	MessageID     int64  `json:"message_id"`
+	From          string `json:"from"`
+	To            string `json:"to"` // To, Cc and Bcc
+	Subject       string `json:"subject"`
+	Body          string `json:"body"`
+	Attachments   string `json:"attachments"`
+	HasAttachment bool   `json:"has_attachment"`
+}
+
+// SearchQuery selects a user's messages from the full-text index
+// Every term must match. Text terms match any field; Body terms match the
+// body and attachments. Dates compare with the internal date: After is
+// inclusive and Before exclusive. A zero MailboxID searches every mailbox.
+type SearchQuery struct {
+	UserID        int64     `json:"user_id"`
+	MailboxID     int64     `json:"mailbox_id,omitempty"`
+	Text          []string  `json:"text,omitempty"`
+	Body          []string  `json:"body,omitempty"`
+	From          []string  `json:"from,omitempty"`
+	To            []string  `json:"to,omitempty"`
+	Subject       []string  `json:"subject,omitempty"`
+	HasAttachment bool      `json:"has_attachment,omitempty"`
+	After         time.Time `json:"after,omitempty"`
+	Before        time.Time `json:"before,omitempty"`
+	Limit         int       `json:"limit,omitempty"` // 0 is unlimited
+}
+
 // Message represents an email message
 type Message struct {
 	ID            int64     `json:"id"`
@@ -156,23 +318,84 @@ type Message struct {
 	StorageType   string    `json:"storage_type"`
 	Content       []byte    `json:"-"`
 	ContentPath   string    `json:"content_path,omitempty"`
+	BodyHash      string    `json:"body_hash,omitempty"` // empty for content stored before content addressing
 	CreatedAt     time.Time `json:"created_at"`
 }
 
+// MessageBody is message content shared by every message with the same SHA-256
+// Content holds blob bodies and ContentPath locates file bodies.
+type MessageBody struct {
+	Hash        string    `json:"hash"`
+	Size        int64     `json:"size"`
+	StorageType string    `json:"storage_type"`
+	Content     []byte    `json:"-"`
+	ContentPath string    `json:"content_path,omitempty"`
+	RefCount    int64     `json:"ref_count"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
 // QueueItem represents a queued message for delivery
 type QueueItem struct {
-	ID           int64      `json:"id"`
-	Sender       string     `json:"sender"`
-	Recipients   string     `json:"recipients"` // JSON array
-	MessageID    string     `json:"message_id,omitempty"`
-	MessagePath  string     `json:"message_path"`
-	RetryCount   int        `json:"retry_count"`
-	MaxRetries   int        `json:"max_retries"`
-	NextRetry    *time.Time `json:"next_retry,omitempty"`
-	Status       string     `json:"status"`
-	ErrorMessage string     `json:"error_message,omitempty"`
-	CreatedAt    time.Time  `json:"created_at"`
-	UpdatedAt    time.Time  `json:"updated_at"`
+	ID            int64      `json:"id"`
+	Sender        string     `json:"sender"`
+	Recipients    string     `json:"recipients"` // JSON array
+	MessageID     string     `json:"message_id,omitempty"`
+	MessagePath   string     `json:"message_path"`
+	BodyType      string     `json:"body_type,omitempty"` // MAIL FROM BODY parameter (8BITMIME, BINARYMIME)
+	SMTPUTF8      bool       `json:"smtputf8,omitempty"`
+	RequireTLS    bool       `json:"require_tls,omitempty"`
+	DSNReturn     string     `json:"dsn_ret,omitempty"` // FULL or HDRS
+	DSNEnvelopeID string     `json:"dsn_envid,omitempty"`
+	DSNRecipients string     `json:"dsn_recipients,omitempty"` // JSON array of RecipientDSN
+	Spam          string     `json:"spam,omitempty"`           // JSON SpamVerdict
+	LocalOnly     bool       `json:"local_only,omitempty"`     // received from another server, never relayed
+	RcptStatus    string     `json:"recipient_status,omitempty"` // JSON array of RecipientStatus
+	RetryCount    int        `json:"retry_count"`
+	MaxRetries    int        `json:"max_retries"`
+	NextRetry     *time.Time `json:"next_retry,omitempty"`
+	Status        string     `json:"status"`
+	ErrorMessage  string     `json:"error_message,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// RecipientDSN holds the DSN parameters given with RCPT TO (RFC 3461)
+type RecipientDSN struct {
+	Recipient string   `json:"recipient"`
+	Notify    []string `json:"notify,omitempty"` // NEVER, or any of SUCCESS, FAILURE, DELAY
+	ORCPT     string   `json:"orcpt,omitempty"`  // original recipient as "addr-type;address"
+}
+
+// Recipient delivery outcomes recorded on a queue item
+const (
+	RecipientDelivered = "delivered"
+	RecipientDeferred  = "deferred"
+	RecipientFailed    = "failed"
+)
+
+// RecipientStatus is the latest delivery outcome for one recipient of a queue item
+type RecipientStatus struct {
+	Recipient string    `json:"recipient"`
+	Status    string    `json:"status"` // delivered, deferred or failed
+	Code      int       `json:"code,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	Relayed   bool      `json:"relayed,omitempty"` // accepted by a server without DSN support
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SpamVerdict is a spam filter's score and matched symbols for a message
+type SpamVerdict struct {
+	Score     float64      `json:"score"`
+	Threshold float64      `json:"threshold"`
+	Action    string       `json:"action,omitempty"`
+	Symbols   []SpamSymbol `json:"symbols,omitempty"`
+}
+
+// SpamSymbol is one rule a spam filter matched
+type SpamSymbol struct {
+	Name        string  `json:"name"`
+	Score       float64 `json:"score"`
+	Description string  `json:"description,omitempty"`
 }
 
 // DKIMConfig represents DKIM signing configuration
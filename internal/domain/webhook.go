@@ -55,6 +55,7 @@ const (
 	WebhookEventSecurityLoginSuccess     WebhookEvent = "security.login_success"
 	WebhookEventSecurityBruteForce       WebhookEvent = "security.brute_force"
 	WebhookEventSecurityIPBlacklisted    WebhookEvent = "security.ip_blacklisted"
+	WebhookEventSecuritySenderRejected   WebhookEvent = "security.sender_rejected"
 
 	// DKIM/SPF/DMARC events
 	WebhookEventDKIMFailed         WebhookEvent = "dkim.failed"
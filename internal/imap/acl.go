@@ -0,0 +1,324 @@
+package imap
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/server"
+	"github.com/emersion/go-imap/utf7"
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/service"
+)
+
+// OtherUsersNamespace holds the mailboxes other users share through ACLs,
+// named "Other Users/owner@example.com/Folder" (RFC 2342)
+const OtherUsersNamespace = "Other Users/"
+
+// sharedName returns the name a shared mailbox is listed under
+func sharedName(ownerEmail, name string) string {
+	return OtherUsersNamespace + ownerEmail + "/" + name
+}
+
+// splitSharedName splits a name in OtherUsersNamespace into the owner's
+// address and the owner's name for the mailbox
+func splitSharedName(name string) (ownerEmail, ownerName string, ok bool) {
+	ownerEmail, ownerName, ok = strings.Cut(strings.TrimPrefix(name, OtherUsersNamespace), "/")
+	return ownerEmail, ownerName, ok && ownerEmail != "" && ownerName != ""
+}
+
+// createSharedMailbox creates a mailbox below a shared mailbox, which needs
+// the k right on the parent
+func (u *User) createSharedMailbox(name string) error {
+	owner, ownerName, ok := splitSharedName(name)
+	if !ok {
+		return errors.New("cannot create mailboxes in another user's namespace")
+	}
+	parent, err := u.parentMailbox(name)
+	if err != nil {
+		return err
+	}
+	if err := parent.require("k"); err != nil {
+		return err
+	}
+
+	u.logger.Info("creating shared mailbox",
+		zap.Int64("user_id", u.user.ID),
+		zap.String("owner", owner),
+		zap.String("mailbox", ownerName),
+	)
+	return u.mailboxService.Create(parent.mailbox.UserID, ownerName, "")
+}
+
+// requireParent checks that the user holds rights on the parent of a shared mailbox name
+func (u *User) requireParent(name, rights string) error {
+	parent, err := u.parentMailbox(name)
+	if err != nil {
+		return err
+	}
+	return parent.require(rights)
+}
+
+// parentMailbox returns the shared mailbox a new shared mailbox name would be created under
+func (u *User) parentMailbox(name string) (*Mailbox, error) {
+	i := strings.LastIndex(name, "/")
+	if _, _, ok := splitSharedName(name[:max(i, 0)]); i < 0 || !ok {
+		return nil, errors.New("cannot create mailboxes in another user's namespace")
+	}
+	parent, err := u.lookupMailbox(name[:i])
+	if err != nil {
+		return nil, backend.ErrNoSuchMailbox
+	}
+	return parent, nil
+}
+
+// ACLExtension adds the ACL (RFC 4314) and NAMESPACE (RFC 2342) commands
+type ACLExtension struct{}
+
+// Capabilities returns the ACL and NAMESPACE capabilities
+// RIGHTS=texk announces the RFC 4314 rights that replace RFC 2086's c and d.
+func (e *ACLExtension) Capabilities(c server.Conn) []string {
+	return []string{"ACL", "RIGHTS=texk", "NAMESPACE"}
+}
+
+// Command returns the handler for an ACL or NAMESPACE command
+func (e *ACLExtension) Command(name string) server.HandlerFactory {
+	switch name {
+	case "SETACL":
+		return func() server.Handler { return &setACL{} }
+	case "DELETEACL":
+		return func() server.Handler { return &deleteACL{} }
+	case "GETACL":
+		return func() server.Handler { return &getACL{} }
+	case "LISTRIGHTS":
+		return func() server.Handler { return &listRights{} }
+	case "MYRIGHTS":
+		return func() server.Handler { return &myRights{} }
+	case "NAMESPACE":
+		return func() server.Handler { return &namespace{} }
+	}
+	return nil
+}
+
+// aclMailbox resolves a mailbox argument for an ACL command
+// rights are the rights the command requires on the mailbox.
+func aclMailbox(conn server.Conn, name, rights string) (*User, *Mailbox, error) {
+	u, ok := conn.Context().User.(*User)
+	if !ok {
+		return nil, nil, server.ErrNotAuthenticated
+	}
+	if u.acl == nil {
+		return nil, nil, errors.New("ACLs are not enabled")
+	}
+
+	mb, err := u.lookupMailbox(name)
+	if err != nil {
+		return nil, nil, backend.ErrNoSuchMailbox
+	}
+	if err := mb.require(rights); err != nil {
+		return nil, nil, err
+	}
+	return u, mb, nil
+}
+
+// aclError turns ACL validation errors into NO responses
+func aclError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidRights), errors.Is(err, service.ErrInvalidACLIdentifier),
+		errors.Is(err, service.ErrOwnerRights):
+		return &imap.ErrStatusResp{Resp: &imap.StatusResp{Type: imap.StatusRespNo, Info: err.Error()}}
+	}
+	return err
+}
+
+// parseMailboxName decodes a modified UTF-7 mailbox name argument
+func parseMailboxName(f interface{}) (string, error) {
+	name, err := imap.ParseString(f)
+	if err != nil {
+		return "", err
+	}
+	if name, err = utf7.Encoding.NewDecoder().String(name); err != nil {
+		return "", err
+	}
+	return imap.CanonicalMailboxName(name), nil
+}
+
+// formatMailboxName encodes a mailbox name for a response
+func formatMailboxName(name string) interface{} {
+	encoded, _ := utf7.Encoding.NewEncoder().String(name)
+	return imap.FormatMailboxName(encoded)
+}
+
+// parseArgs parses a mailbox name followed by string arguments
+func parseArgs(fields []interface{}, n int) (string, []string, error) {
+	if len(fields) != n+1 {
+		return "", nil, errors.New("wrong number of arguments")
+	}
+	name, err := parseMailboxName(fields[0])
+	if err != nil {
+		return "", nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if args[i], err = imap.ParseString(fields[i+1]); err != nil {
+			return "", nil, err
+		}
+	}
+	return name, args, nil
+}
+
+// setACL handles SETACL mailbox identifier rights (RFC 4314 section 3.1)
+type setACL struct {
+	mailbox, identifier, rights string
+}
+
+func (cmd *setACL) Parse(fields []interface{}) error {
+	name, args, err := parseArgs(fields, 2)
+	if err != nil {
+		return err
+	}
+	cmd.mailbox, cmd.identifier, cmd.rights = name, args[0], args[1]
+	return nil
+}
+
+func (cmd *setACL) Handle(conn server.Conn) error {
+	u, mb, err := aclMailbox(conn, cmd.mailbox, "a")
+	if err != nil {
+		return err
+	}
+	return aclError(u.acl.Set(mb.mailbox.ID, cmd.identifier, cmd.rights))
+}
+
+// deleteACL handles DELETEACL mailbox identifier (RFC 4314 section 3.2)
+type deleteACL struct {
+	mailbox, identifier string
+}
+
+func (cmd *deleteACL) Parse(fields []interface{}) error {
+	name, args, err := parseArgs(fields, 1)
+	if err != nil {
+		return err
+	}
+	cmd.mailbox, cmd.identifier = name, args[0]
+	return nil
+}
+
+func (cmd *deleteACL) Handle(conn server.Conn) error {
+	u, mb, err := aclMailbox(conn, cmd.mailbox, "a")
+	if err != nil {
+		return err
+	}
+	return aclError(u.acl.Delete(mb.mailbox.ID, cmd.identifier))
+}
+
+// getACL handles GETACL mailbox (RFC 4314 section 3.3)
+type getACL struct {
+	mailbox string
+}
+
+func (cmd *getACL) Parse(fields []interface{}) error {
+	name, _, err := parseArgs(fields, 0)
+	cmd.mailbox = name
+	return err
+}
+
+func (cmd *getACL) Handle(conn server.Conn) error {
+	u, mb, err := aclMailbox(conn, cmd.mailbox, "a")
+	if err != nil {
+		return err
+	}
+	acls, err := u.acl.List(mb.mailbox.ID)
+	if err != nil {
+		return err
+	}
+
+	fields := []interface{}{imap.RawString("ACL"), formatMailboxName(cmd.mailbox), mb.ownerEmail, service.AllRights}
+	for _, acl := range acls {
+		fields = append(fields, acl.Identifier, acl.Rights)
+	}
+	return conn.WriteResp(imap.NewUntaggedResp(fields))
+}
+
+// listRights handles LISTRIGHTS mailbox identifier (RFC 4314 section 3.4)
+// The owner always holds every right; anyone else may be granted each right
+// independently.
+type listRights struct {
+	mailbox, identifier string
+}
+
+func (cmd *listRights) Parse(fields []interface{}) error {
+	name, args, err := parseArgs(fields, 1)
+	if err != nil {
+		return err
+	}
+	cmd.mailbox, cmd.identifier = name, args[0]
+	return nil
+}
+
+func (cmd *listRights) Handle(conn server.Conn) error {
+	_, mb, err := aclMailbox(conn, cmd.mailbox, "a")
+	if err != nil {
+		return err
+	}
+
+	fields := []interface{}{imap.RawString("LISTRIGHTS"), formatMailboxName(cmd.mailbox), cmd.identifier}
+	if service.NormalizeAddress(cmd.identifier) == mb.ownerEmail {
+		fields = append(fields, service.AllRights)
+	} else {
+		fields = append(fields, "")
+		for _, r := range service.AllRights {
+			fields = append(fields, imap.RawString(r))
+		}
+	}
+	return conn.WriteResp(imap.NewUntaggedResp(fields))
+}
+
+// myRights handles MYRIGHTS mailbox (RFC 4314 section 3.5)
+type myRights struct {
+	mailbox string
+}
+
+func (cmd *myRights) Parse(fields []interface{}) error {
+	name, _, err := parseArgs(fields, 0)
+	cmd.mailbox = name
+	return err
+}
+
+func (cmd *myRights) Handle(conn server.Conn) error {
+	_, mb, err := aclMailbox(conn, cmd.mailbox, "")
+	if err != nil {
+		return err
+	}
+	if mb.rights == "" {
+		return backend.ErrNoSuchMailbox
+	}
+	return conn.WriteResp(imap.NewUntaggedResp([]interface{}{
+		imap.RawString("MYRIGHTS"), formatMailboxName(cmd.mailbox), mb.rights,
+	}))
+}
+
+// namespace handles NAMESPACE (RFC 2342)
+// Personal mailboxes have no prefix; shared ones are in OtherUsersNamespace.
+type namespace struct{}
+
+func (cmd *namespace) Parse(fields []interface{}) error {
+	return nil
+}
+
+func (cmd *namespace) Handle(conn server.Conn) error {
+	u, ok := conn.Context().User.(*User)
+	if !ok {
+		return server.ErrNotAuthenticated
+	}
+
+	personal := []interface{}{[]interface{}{"", "/"}}
+	var other interface{}
+	if u.acl != nil {
+		other = []interface{}{[]interface{}{OtherUsersNamespace, "/"}}
+	}
+	return conn.WriteResp(imap.NewUntaggedResp([]interface{}{
+		imap.RawString("NAMESPACE"), personal, other, nil,
+	}))
+}
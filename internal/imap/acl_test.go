@@ -0,0 +1,179 @@
+package imap
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/service"
+)
+
+// aclStore backs the ACL service with fixed users and mailboxes and in-memory grants
+type aclStore struct {
+	users     []*domain.User
+	mailboxes []*domain.Mailbox
+	acls      []*domain.MailboxACL
+}
+
+type aclStoreUsers struct{ *aclStore }
+
+func (s aclStoreUsers) Create(user *domain.User) error { return nil }
+func (s aclStoreUsers) GetByID(id int64) (*domain.User, error) {
+	for _, u := range s.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+func (s aclStoreUsers) GetByEmail(email string) (*domain.User, error) {
+	for _, u := range s.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+func (s aclStoreUsers) Update(user *domain.User) error                         { return nil }
+func (s aclStoreUsers) UpdateLastLogin(id int64) error                         { return nil }
+func (s aclStoreUsers) ReconcileQuota() (map[int64]int64, error)               { return nil, nil }
+func (s aclStoreUsers) UpdatePassword(userID int64, passwordHash string) error { return nil }
+func (s aclStoreUsers) UpdateSCRAMCredentials(userID int64, c string) error    { return nil }
+func (s aclStoreUsers) Delete(id int64) error                                  { return nil }
+func (s aclStoreUsers) List(domainID int64, o, l int) ([]*domain.User, error)  { return s.users, nil }
+func (s aclStoreUsers) ListAll() ([]*domain.User, error)                       { return s.users, nil }
+
+type aclStoreMailboxes struct{ *aclStore }
+
+func (s aclStoreMailboxes) Create(mailbox *domain.Mailbox) error { return nil }
+func (s aclStoreMailboxes) GetByID(id int64) (*domain.Mailbox, error) {
+	for _, mb := range s.mailboxes {
+		if mb.ID == id {
+			return mb, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+func (s aclStoreMailboxes) GetByUser(userID int64) ([]*domain.Mailbox, error) { return nil, nil }
+func (s aclStoreMailboxes) GetByName(userID int64, name string) (*domain.Mailbox, error) {
+	for _, mb := range s.mailboxes {
+		if mb.UserID == userID && mb.Name == name {
+			return mb, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+func (s aclStoreMailboxes) Update(mailbox *domain.Mailbox) error { return nil }
+func (s aclStoreMailboxes) Delete(id int64) error                { return nil }
+func (s aclStoreMailboxes) AllocateUID(id int64) (int64, error)  { return 1, nil }
+
+type aclStoreGrants struct{ *aclStore }
+
+func (s aclStoreGrants) Set(acl *domain.MailboxACL) error {
+	s.acls = append(s.acls, acl)
+	return nil
+}
+func (s aclStoreGrants) Get(mailboxID int64, identifier string) (*domain.MailboxACL, error) {
+	for _, acl := range s.acls {
+		if acl.MailboxID == mailboxID && acl.Identifier == identifier {
+			return acl, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+func (s aclStoreGrants) ListByMailbox(mailboxID int64) ([]*domain.MailboxACL, error) {
+	return nil, nil
+}
+func (s aclStoreGrants) ListByIdentifier(identifier string) ([]*domain.MailboxACL, error) {
+	var acls []*domain.MailboxACL
+	for _, acl := range s.acls {
+		if acl.Identifier == identifier {
+			acls = append(acls, acl)
+		}
+	}
+	return acls, nil
+}
+func (s aclStoreGrants) Delete(mailboxID int64, identifier string) error { return nil }
+
+func TestSplitSharedName(t *testing.T) {
+	owner, name, ok := splitSharedName(sharedName("owner@example.com", "Team/Projects"))
+	if !ok || owner != "owner@example.com" || name != "Team/Projects" {
+		t.Errorf("unexpected split %q, %q, %v", owner, name, ok)
+	}
+	if _, _, ok := splitSharedName(OtherUsersNamespace + "owner@example.com"); ok {
+		t.Error("expected owner level to have no mailbox name")
+	}
+}
+
+func TestUser_SharedMailboxes(t *testing.T) {
+	store := &aclStore{
+		users: []*domain.User{
+			{ID: 1, Email: "owner@example.com"},
+			{ID: 2, Email: "reader@example.com"},
+		},
+		mailboxes: []*domain.Mailbox{
+			{ID: 10, UserID: 1, Name: "INBOX"},
+			{ID: 11, UserID: 1, Name: "Team"},
+		},
+		acls: []*domain.MailboxACL{
+			{MailboxID: 11, Identifier: "reader@example.com", Rights: "lr"},
+		},
+	}
+	acl := service.NewACLService(aclStoreGrants{store}, aclStoreMailboxes{store}, aclStoreUsers{store}, zap.NewNop())
+
+	user := &User{
+		user: store.users[1],
+		mailboxService: &mockMailboxService{
+			listFunc: func(userID int64, subscribedOnly bool) ([]*domain.Mailbox, error) {
+				return []*domain.Mailbox{{ID: 20, UserID: 2, Name: "INBOX"}}, nil
+			},
+		},
+		messageService: &mockMessageService{},
+		acl:            acl,
+		logger:         zap.NewNop(),
+	}
+
+	mailboxes, err := user.ListMailboxes(false)
+	if err != nil {
+		t.Fatalf("ListMailboxes failed: %v", err)
+	}
+	var names []string
+	for _, mb := range mailboxes {
+		names = append(names, mb.Name())
+	}
+	want := []string{"INBOX", "Other Users", "Other Users/owner@example.com", "Other Users/owner@example.com/Team"}
+	if len(names) != len(want) {
+		t.Fatalf("expected %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, names)
+		}
+	}
+
+	if _, err := user.GetMailbox(sharedName("owner@example.com", "INBOX")); err == nil {
+		t.Error("expected unshared mailbox to be hidden")
+	}
+
+	mb, err := user.GetMailbox(sharedName("owner@example.com", "Team"))
+	if err != nil {
+		t.Fatalf("GetMailbox failed: %v", err)
+	}
+	status, err := mb.Status([]imap.StatusItem{imap.StatusMessages})
+	if err != nil || !status.ReadOnly {
+		t.Errorf("expected read-only status, got %+v, %v", status, err)
+	}
+
+	err = mb.CreateMessage(nil, time.Now(), nil)
+	var statusErr *imap.ErrStatusResp
+	if !errors.As(err, &statusErr) || statusErr.Resp.Code != "NOPERM" {
+		t.Errorf("expected NOPERM without the i right, got %v", err)
+	}
+	if err := mb.Expunge(); !errors.As(err, &statusErr) || statusErr.Resp.Code != "NOPERM" {
+		t.Errorf("expected NOPERM without the e right, got %v", err)
+	}
+}
@@ -2,7 +2,6 @@ package imap
 
 import (
 	"errors"
-	"net"
 	"strings"
 
 	"github.com/emersion/go-imap"
@@ -13,7 +12,7 @@ import (
 	"github.com/btafoya/gomailserver/internal/repository"
 	"github.com/btafoya/gomailserver/internal/security/bruteforce"
 	"github.com/btafoya/gomailserver/internal/security/ratelimit"
-	"github.com/btafoya/gomailserver/internal/security/totp"
+	"github.com/btafoya/gomailserver/internal/security/saslauth"
 	"github.com/btafoya/gomailserver/internal/service"
 )
 
@@ -23,12 +22,17 @@ type Backend struct {
 	mailboxService service.MailboxServiceInterface
 	messageService service.MessageServiceInterface
 	domainRepo     repository.DomainRepository
+	auth           *saslauth.Authenticator
+	acl            *service.ACLService
+	quota          *service.QuotaService
+	metadata       *service.MetadataService
+	search         *service.SearchService
+	sessions       *sessionRegistry
 	logger         *zap.Logger
 
 	// Security services
 	rateLimiter *ratelimit.Limiter
 	bruteForce  *bruteforce.Protection
-	totpService *totp.TOTPService
 }
 
 // NewBackend creates a new IMAP backend with all dependencies
@@ -37,9 +41,9 @@ func NewBackend(
 	mailboxService service.MailboxServiceInterface,
 	messageService service.MessageServiceInterface,
 	domainRepo repository.DomainRepository,
+	auth *saslauth.Authenticator,
 	rateLimiter *ratelimit.Limiter,
 	bruteForce *bruteforce.Protection,
-	totpService *totp.TOTPService,
 	logger *zap.Logger,
 ) *Backend {
 	return &Backend{
@@ -47,123 +51,70 @@ func NewBackend(
 		mailboxService: mailboxService,
 		messageService: messageService,
 		domainRepo:     domainRepo,
+		auth:           auth,
+		sessions:       newSessionRegistry(),
 		logger:         logger,
 		rateLimiter:    rateLimiter,
 		bruteForce:     bruteForce,
-		totpService:    totpService,
 	}
 }
 
-// Login authenticates a user
-func (b *Backend) Login(connInfo *imap.ConnInfo, username, password string) (backend.User, error) {
-	b.logger.Info("IMAP authentication attempt",
-		zap.String("username", username),
-		zap.String("remote_addr", connInfo.RemoteAddr.String()),
-	)
+// SetACLService enables mailbox sharing through ACLs (optional)
+func (b *Backend) SetACLService(acl *service.ACLService) {
+	b.acl = acl
+}
 
-	// Extract domain from username
-	domain := extractDomain(username)
-	if domain == "" {
-		return nil, backend.ErrInvalidCredentials
-	}
+// SetQuotaService enables the QUOTA extension and APPEND/COPY limits (optional)
+func (b *Backend) SetQuotaService(quota *service.QuotaService) {
+	b.quota = quota
+}
 
-	// Load domain configuration
-	domainConfig, err := b.domainRepo.GetByName(domain)
-	if err != nil {
-		b.logger.Error("failed to load domain config",
-			zap.String("domain", domain),
-			zap.Error(err),
-		)
-		// Continue even if domain config fails
-		domainConfig = nil
-	}
+// SetMetadataService enables METADATA server and mailbox entries (optional)
+func (b *Backend) SetMetadataService(metadata *service.MetadataService) {
+	b.metadata = metadata
+}
 
-	// Extract IP address
-	remoteIP := extractIP(connInfo.RemoteAddr.String())
+// SetSearchService answers SEARCH BODY and TEXT from the full-text index (optional)
+func (b *Backend) SetSearchService(search *service.SearchService) {
+	b.search = search
+}
 
-	// Check brute force protection if enabled
-	if domainConfig != nil && b.bruteForce != nil && domainConfig.AuthBruteForceEnabled {
-		blocked, err := b.bruteForce.IsBlocked(remoteIP)
-		if err != nil {
-			b.logger.Error("brute force check failed", zap.Error(err))
-		} else if blocked {
-			b.logger.Warn("IMAP authentication blocked - brute force protection",
-				zap.String("username", username),
-				zap.String("remote_ip", remoteIP),
-			)
-			return nil, backend.ErrInvalidCredentials
-		}
+// Login authenticates a user
+func (b *Backend) Login(connInfo *imap.ConnInfo, username, password string) (backend.User, error) {
+	user, err := b.login(connInfo, saslauth.Plain, username, func() (*domain.User, error) {
+		return b.userService.AuthenticateClient(username, password, domain.AppPasswordScopeIMAP)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return user, nil
+}
 
-	// Check IMAP rate limiting if enabled
-	if domainConfig != nil && b.rateLimiter != nil && domainConfig.RateLimitEnabled {
-		allowed, err := b.rateLimiter.Check("imap_per_user", username)
-		if err != nil {
-			b.logger.Error("rate limit check failed", zap.Error(err))
-		} else if !allowed {
-			b.logger.Warn("IMAP rate limited",
-				zap.String("username", username),
-				zap.String("remote_ip", remoteIP),
-				zap.String("domain", domain),
-			)
-			return nil, backend.ErrInvalidCredentials
-		}
+// login delegates a credential check to saslauth.LoginPolicy
+func (b *Backend) login(connInfo *imap.ConnInfo, mech, username string, verify func() (*domain.User, error)) (*User, error) {
+	policy := saslauth.LoginPolicy{
+		Protocol:   "IMAP",
+		Domains:    b.domainRepo,
+		BruteForce: b.bruteForce,
+		RateLimit:  saslauth.UserRateLimit(b.rateLimiter, "imap_per_user"),
+		Logger:     b.logger,
 	}
-
-	user, err := b.userService.Authenticate(username, password)
+	user, err := policy.Login(connInfo.RemoteAddr.String(), mech, username, verify)
 	if err != nil {
-		// Record failed login attempt for brute force protection
-		if domainConfig != nil && b.bruteForce != nil && domainConfig.AuthBruteForceEnabled {
-			if err := b.bruteForce.RecordFailure(remoteIP, username); err != nil {
-				b.logger.Error("failed to record login failure", zap.Error(err))
-			}
-		}
-
-		b.logger.Warn("IMAP authentication failed",
-			zap.String("username", username),
-			zap.String("remote_addr", connInfo.RemoteAddr.String()),
-			zap.Error(err),
-		)
-		return nil, backend.ErrInvalidCredentials
-	}
-
-	if user.Status != "active" {
-		b.logger.Warn("IMAP authentication failed - user disabled",
-			zap.String("username", username),
-			zap.String("status", user.Status),
-		)
 		return nil, backend.ErrInvalidCredentials
 	}
 
-	// Check TOTP if enforced
-	if domainConfig != nil && b.totpService != nil && domainConfig.AuthTOTPEnforced {
-		if !user.TOTPEnabled {
-			b.logger.Warn("IMAP authentication failed - TOTP required but not enabled",
-				zap.String("username", username),
-			)
-			return nil, errors.New("TOTP required but not configured")
-		}
-		// Note: TOTP validation would require a separate authentication step
-		// For now, we just check if TOTP is enabled
-	}
-
-	// Record successful login for brute force protection
-	if domainConfig != nil && b.bruteForce != nil && domainConfig.AuthBruteForceEnabled {
-		if err := b.bruteForce.RecordSuccess(remoteIP, username); err != nil {
-			b.logger.Error("failed to record successful login", zap.Error(err))
-		}
-	}
-
-	b.logger.Info("IMAP authentication successful",
-		zap.String("username", username),
-		zap.Int64("user_id", user.ID),
-	)
-
 	return &User{
 		user:           user,
 		backend:        b,
 		mailboxService: b.mailboxService,
 		messageService: b.messageService,
+		acl:            b.acl,
+		quota:          b.quota,
+		metadata:       b.metadata,
+		search:         b.search,
+		stats:          b.sessions.get(connInfo.LocalAddr, connInfo.RemoteAddr),
+		remoteAddr:     connInfo.RemoteAddr.String(),
 		logger:         b.logger,
 	}, nil
 }
@@ -174,7 +125,16 @@ type User struct {
 	backend        *Backend
 	mailboxService service.MailboxServiceInterface
 	messageService service.MessageServiceInterface
+	acl            *service.ACLService
+	quota          *service.QuotaService
+	metadata       *service.MetadataService
+	search         *service.SearchService
 	logger         *zap.Logger
+
+	// Connection state; stats is nil for connections not accepted by Server
+	remoteAddr string
+	stats      *sessionStats
+	compressed bool
 }
 
 // Username returns the user's email
@@ -200,15 +160,43 @@ func (u *User) ListMailboxes(subscribed bool) ([]backend.Mailbox, error) {
 
 	result := make([]backend.Mailbox, len(mailboxes))
 	for i, mb := range mailboxes {
-		result[i] = &Mailbox{
-			mailbox:        mb,
-			user:           u.user,
-			messageService: u.messageService,
-			mailboxService: u.mailboxService,
-			logger:         u.logger,
-		}
+		result[i] = u.newMailbox(mb, mb.Name, u.user.Email, service.AllRights)
+	}
+
+	shared, err := u.sharedMailboxes()
+	if err != nil {
+		u.logger.Error("failed to list shared mailboxes",
+			zap.Error(err),
+			zap.Int64("user_id", u.user.ID),
+		)
+		return nil, err
+	}
+
+	return append(result, shared...), nil
+}
+
+// sharedMailboxes lists the mailboxes other users share with this user
+// They appear under OtherUsersNamespace as "Other Users/owner/name", below
+// \Noselect entries for the namespace and each owner. Shared mailboxes are
+// always listed as subscribed.
+func (u *User) sharedMailboxes() ([]backend.Mailbox, error) {
+	if u.acl == nil {
+		return nil, nil
+	}
+	shared, err := u.acl.Shared(u.user)
+	if err != nil || len(shared) == 0 {
+		return nil, err
 	}
 
+	result := []backend.Mailbox{u.placeholder(strings.TrimSuffix(OtherUsersNamespace, "/"))}
+	var owner string
+	for _, sm := range shared {
+		if sm.Owner.Email != owner {
+			owner = sm.Owner.Email
+			result = append(result, u.placeholder(OtherUsersNamespace+owner))
+		}
+		result = append(result, u.newMailbox(sm.Mailbox, sharedName(sm.Owner.Email, sm.Mailbox.Name), sm.Owner.Email, sm.Rights))
+	}
 	return result, nil
 }
 
@@ -219,7 +207,7 @@ func (u *User) GetMailbox(name string) (backend.Mailbox, error) {
 		zap.String("mailbox", name),
 	)
 
-	mb, err := u.mailboxService.GetByName(u.user.ID, name)
+	mb, err := u.lookupMailbox(name)
 	if err != nil {
 		u.logger.Error("failed to get mailbox",
 			zap.Error(err),
@@ -229,23 +217,75 @@ func (u *User) GetMailbox(name string) (backend.Mailbox, error) {
 		return nil, backend.ErrNoSuchMailbox
 	}
 
+	return mb, nil
+}
+
+// lookupMailbox finds one of the user's mailboxes, or a mailbox shared with
+// them when name is in OtherUsersNamespace
+func (u *User) lookupMailbox(name string) (*Mailbox, error) {
+	if !strings.HasPrefix(name, OtherUsersNamespace) {
+		mb, err := u.mailboxService.GetByName(u.user.ID, name)
+		if err != nil {
+			return nil, err
+		}
+		return u.newMailbox(mb, name, u.user.Email, service.AllRights), nil
+	}
+
+	owner, ownerName, ok := splitSharedName(name)
+	if !ok || u.acl == nil {
+		return nil, backend.ErrNoSuchMailbox
+	}
+	sm, err := u.acl.SharedMailbox(u.user, owner, ownerName)
+	if err != nil {
+		return nil, err
+	}
+	return u.newMailbox(sm.Mailbox, name, sm.Owner.Email, sm.Rights), nil
+}
+
+// newMailbox wraps a stored mailbox as seen by this user
+func (u *User) newMailbox(mb *domain.Mailbox, name, ownerEmail, rights string) *Mailbox {
 	return &Mailbox{
 		mailbox:        mb,
 		user:           u.user,
+		session:        u,
+		name:           name,
+		ownerEmail:     ownerEmail,
+		rights:         rights,
+		shared:         ownerEmail != u.user.Email,
 		messageService: u.messageService,
 		mailboxService: u.mailboxService,
 		logger:         u.logger,
-	}, nil
+	}
+}
+
+// placeholder returns a \Noselect level of the shared mailbox hierarchy
+func (u *User) placeholder(name string) *Mailbox {
+	mb := u.newMailbox(&domain.Mailbox{Name: name}, name, "", "")
+	mb.noselect = true
+	return mb
 }
 
 // CreateMailbox creates a new mailbox
 func (u *User) CreateMailbox(name string) error {
+	return u.createMailbox(name, "")
+}
+
+// createMailbox creates a mailbox with a special-use attribute, or none if empty
+func (u *User) createMailbox(name, specialUse string) error {
 	u.logger.Info("creating mailbox",
 		zap.Int64("user_id", u.user.ID),
 		zap.String("mailbox", name),
+		zap.String("special_use", specialUse),
 	)
 
-	err := u.mailboxService.Create(u.user.ID, name, "")
+	if strings.HasPrefix(name, OtherUsersNamespace) {
+		if specialUse != "" {
+			return service.ErrInvalidSpecialUse
+		}
+		return u.createSharedMailbox(name)
+	}
+
+	err := u.mailboxService.Create(u.user.ID, name, specialUse)
 	if err != nil {
 		u.logger.Error("failed to create mailbox",
 			zap.Error(err),
@@ -270,12 +310,18 @@ func (u *User) DeleteMailbox(name string) error {
 		return errors.New("cannot delete INBOX")
 	}
 
-	mb, err := u.mailboxService.GetByName(u.user.ID, name)
+	mb, err := u.lookupMailbox(name)
 	if err != nil {
 		return backend.ErrNoSuchMailbox
 	}
+	if mb.shared && mb.mailbox.Name == "INBOX" {
+		return errors.New("cannot delete INBOX")
+	}
+	if err := mb.require("x"); err != nil {
+		return err
+	}
 
-	err = u.mailboxService.Delete(mb.ID)
+	err = u.mailboxService.Delete(mb.mailbox.ID)
 	if err != nil {
 		u.logger.Error("failed to delete mailbox",
 			zap.Error(err),
@@ -301,12 +347,34 @@ func (u *User) RenameMailbox(oldName, newName string) error {
 		return errors.New("cannot rename INBOX")
 	}
 
-	mb, err := u.mailboxService.GetByName(u.user.ID, oldName)
+	mb, err := u.lookupMailbox(oldName)
 	if err != nil {
 		return backend.ErrNoSuchMailbox
 	}
+	if mb.shared && mb.mailbox.Name == "INBOX" {
+		return errors.New("cannot rename INBOX")
+	}
+
+	// Shared mailboxes can only be renamed within their owner's hierarchy
+	if mb.shared != strings.HasPrefix(newName, OtherUsersNamespace) {
+		return errors.New("cannot move mailboxes between users")
+	}
+	target := newName
+	if mb.shared {
+		owner, ownerName, ok := splitSharedName(newName)
+		if !ok || owner != mb.ownerEmail {
+			return errors.New("cannot move mailboxes between users")
+		}
+		if err := mb.require("x"); err != nil {
+			return err
+		}
+		if err := u.requireParent(newName, "k"); err != nil {
+			return err
+		}
+		target = ownerName
+	}
 
-	err = u.mailboxService.Rename(mb.ID, newName)
+	err = u.mailboxService.Rename(mb.mailbox.ID, target)
 	if err != nil {
 		u.logger.Error("failed to rename mailbox",
 			zap.Error(err),
@@ -322,27 +390,15 @@ func (u *User) RenameMailbox(oldName, newName string) error {
 
 // Logout ends the user session
 func (u *User) Logout() error {
-	u.logger.Debug("IMAP session ended",
+	fields := []zap.Field{
 		zap.String("username", u.user.Email),
 		zap.Int64("user_id", u.user.ID),
-	)
-	return nil
-}
-
-// extractDomain extracts domain from email address
-func extractDomain(email string) string {
-	parts := strings.Split(email, "@")
-	if len(parts) != 2 {
-		return ""
+		zap.String("remote_addr", u.remoteAddr),
 	}
-	return parts[1]
-}
-
-// extractIP extracts IP address from remote address string
-func extractIP(remoteAddr string) string {
-	host, _, err := net.SplitHostPort(remoteAddr)
-	if err != nil {
-		return remoteAddr
+	if u.stats == nil {
+		u.logger.Debug("IMAP session ended", fields...)
+		return nil
 	}
-	return host
+	u.logger.Info("IMAP session ended", append(fields, u.stats.fields()...)...)
+	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/backend"
@@ -28,6 +29,10 @@ func (m *mockUserService) Authenticate(email, password string) (*domain.User, er
 	return nil, errors.New("authentication failed")
 }
 
+func (m *mockUserService) AuthenticateClient(email, password, scope string) (*domain.User, error) {
+	return m.Authenticate(email, password)
+}
+
 func (m *mockUserService) GetByEmail(email string) (*domain.User, error) {
 	return nil, nil
 }
@@ -108,6 +113,14 @@ func (m *mockMailboxService) UpdateSubscription(id int64, subscribed bool) error
 	return nil
 }
 
+func (m *mockMailboxService) SetSpecialUse(id int64, specialUse string) error {
+	return nil
+}
+
+func (m *mockMailboxService) AllocateUID(mailboxID int64) (int64, error) {
+	return 1, nil
+}
+
 // mockMessageService for IMAP backend tests
 type mockMessageService struct{}
 
@@ -115,6 +128,10 @@ func (m *mockMessageService) Store(userID, mailboxID, uid int64, messageData []b
 	return &domain.Message{ID: 1}, nil
 }
 
+func (m *mockMessageService) Append(userID, mailboxID, uid int64, messageData []byte, flags []string, date time.Time) (*domain.Message, error) {
+	return &domain.Message{ID: 1}, nil
+}
+
 func (m *mockMessageService) GetByID(id int64) (*domain.Message, error) {
 	return nil, nil
 }
@@ -123,6 +140,26 @@ func (m *mockMessageService) GetByMailbox(mailboxID int64, offset, limit int) ([
 	return nil, nil
 }
 
+func (m *mockMessageService) List(mailboxID int64) ([]*domain.Message, error) {
+	return nil, nil
+}
+
+func (m *mockMessageService) Query(q *domain.MessageQuery) (*domain.MessageQueryResult, error) {
+	return &domain.MessageQueryResult{}, nil
+}
+
+func (m *mockMessageService) SetFlags(id int64, flags []string) error {
+	return nil
+}
+
+func (m *mockMessageService) Copy(id int64, target *domain.Mailbox, uid int64) (*domain.Message, error) {
+	return nil, nil
+}
+
+func (m *mockMessageService) Move(id int64, target *domain.Mailbox, uid int64) error {
+	return nil
+}
+
 func (m *mockMessageService) Delete(id int64) error {
 	return nil
 }
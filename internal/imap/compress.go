@@ -0,0 +1,138 @@
+package imap
+
+import (
+	"compress/flate"
+	"errors"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/server"
+)
+
+// CompressExtension adds COMPRESS=DEFLATE (RFC 4978)
+// Compression is offered once the client has authenticated, so credentials
+// are never exchanged over a compressed stream without TLS beneath it.
+type CompressExtension struct{}
+
+// Capabilities returns COMPRESS=DEFLATE to authenticated clients not yet compressing
+func (e *CompressExtension) Capabilities(c server.Conn) []string {
+	if u, ok := c.Context().User.(*User); !ok || u.compressed {
+		return nil
+	}
+	return []string{"COMPRESS=DEFLATE"}
+}
+
+// Command returns the COMPRESS handler
+func (e *CompressExtension) Command(name string) server.HandlerFactory {
+	if name == "COMPRESS" {
+		return func() server.Handler { return &compress{} }
+	}
+	return nil
+}
+
+// compress handles COMPRESS DEFLATE; the connection is switched to raw
+// DEFLATE (RFC 1951) in both directions after the tagged OK
+type compress struct {
+	mechanism string
+	user      *User
+}
+
+func (cmd *compress) Parse(fields []interface{}) error {
+	if len(fields) != 1 {
+		return errors.New("COMPRESS takes one argument")
+	}
+	mechanism, ok := fields[0].(string)
+	if !ok {
+		return errors.New("compression mechanism must be an atom")
+	}
+	cmd.mechanism = strings.ToUpper(mechanism)
+	return nil
+}
+
+func (cmd *compress) Handle(conn server.Conn) error {
+	u, ok := conn.Context().User.(*User)
+	if !ok {
+		return server.ErrNotAuthenticated
+	}
+	if u.compressed {
+		return &imap.ErrStatusResp{Resp: &imap.StatusResp{
+			Type: imap.StatusRespNo,
+			Code: "COMPRESSIONACTIVE",
+			Info: "DEFLATE active via COMPRESS",
+		}}
+	}
+	if cmd.mechanism != "DEFLATE" {
+		return &imap.ErrStatusResp{Resp: &imap.StatusResp{
+			Type: imap.StatusRespBad,
+			Info: "Unsupported compression mechanism",
+		}}
+	}
+
+	cmd.user = u
+	return &imap.ErrStatusResp{Resp: &imap.StatusResp{
+		Type: imap.StatusRespOk,
+		Info: "DEFLATE active",
+	}}
+}
+
+func (cmd *compress) Upgrade(conn server.Conn) error {
+	err := conn.Upgrade(func(sock net.Conn) (net.Conn, error) {
+		conn.WaitReady()
+		return newDeflateConn(sock, cmd.user.stats)
+	})
+	if err != nil {
+		return err
+	}
+
+	cmd.user.compressed = true
+	if cmd.user.stats != nil {
+		cmd.user.stats.compressed.Store(true)
+	}
+	return nil
+}
+
+// deflateConn compresses a connection with raw DEFLATE
+// go-imap calls Flush after each batch of responses, which ends the pending
+// output with a sync flush so the client can decode it at once.
+type deflateConn struct {
+	net.Conn
+	r     io.ReadCloser
+	w     *flate.Writer
+	stats *sessionStats
+}
+
+func newDeflateConn(c net.Conn, stats *sessionStats) (*deflateConn, error) {
+	w, err := flate.NewWriter(c, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	return &deflateConn{Conn: c, r: flate.NewReader(c), w: w, stats: stats}, nil
+}
+
+func (c *deflateConn) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if c.stats != nil {
+		c.stats.plainIn.Add(int64(n))
+	}
+	return n, err
+}
+
+func (c *deflateConn) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if c.stats != nil {
+		c.stats.plainOut.Add(int64(n))
+	}
+	return n, err
+}
+
+func (c *deflateConn) Flush() error {
+	return c.w.Flush()
+}
+
+func (c *deflateConn) Close() error {
+	c.w.Close()
+	c.r.Close()
+	return c.Conn.Close()
+}
@@ -0,0 +1,90 @@
+package imap
+
+import (
+	"bufio"
+	"compress/flate"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestDeflateConn(t *testing.T) {
+	server, client := net.Pipe()
+	stats := &sessionStats{}
+	conn, err := newDeflateConn(server, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Closing conn writes the final block, which a closed pipe discards
+	defer conn.Close()
+	defer client.Close()
+
+	// Server to client: a response is readable once flushed
+	go func() {
+		conn.Write([]byte("* OK " + strings.Repeat("x", 1000) + "\r\n"))
+		conn.Flush()
+	}()
+	line, err := bufio.NewReader(flate.NewReader(client)).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(line) != 1007 {
+		t.Errorf("read %d bytes, want 1007", len(line))
+	}
+
+	// Client to server
+	go func() {
+		w, _ := flate.NewWriter(client, flate.BestSpeed)
+		w.Write([]byte("a1 NOOP\r\n"))
+		w.Flush()
+	}()
+	buf := make([]byte, 9)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "a1 NOOP\r\n" {
+		t.Errorf("read %q", buf)
+	}
+
+	if stats.plainOut.Load() != 1007 || stats.plainIn.Load() != 9 {
+		t.Errorf("uncompressed counters = %d out, %d in", stats.plainOut.Load(), stats.plainIn.Load())
+	}
+}
+
+func TestCountingListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := newSessionRegistry()
+	ln = &countingListener{Listener: ln, sessions: sessions}
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := sessions.get(conn.LocalAddr(), conn.RemoteAddr())
+	if stats == nil {
+		t.Fatal("accepted connection not registered")
+	}
+
+	conn.Write([]byte("* OK ready\r\n"))
+	client.Write([]byte("a1 LOGOUT\r\n"))
+	io.ReadFull(conn, make([]byte, 11))
+	if stats.out.Load() != 12 || stats.in.Load() != 11 {
+		t.Errorf("counters = %d out, %d in", stats.out.Load(), stats.in.Load())
+	}
+
+	conn.Close()
+	if sessions.get(conn.LocalAddr(), conn.RemoteAddr()) != nil {
+		t.Error("closed connection still registered")
+	}
+}
@@ -0,0 +1,342 @@
+package imap
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-imap/server"
+
+	"github.com/btafoya/gomailserver/internal/service"
+)
+
+// ListExtension adds LIST-EXTENDED (RFC 5258), LIST-STATUS (RFC 5819),
+// SPECIAL-USE and CREATE-SPECIAL-USE (RFC 6154)
+// LIST always reports \HasChildren or \HasNoChildren, as CHILDREN is
+// advertised by go-imap, and the special-use attributes of the user's own
+// mailboxes.
+type ListExtension struct{}
+
+// Capabilities returns the LIST and special-use capabilities
+func (e *ListExtension) Capabilities(c server.Conn) []string {
+	return []string{"LIST-EXTENDED", "LIST-STATUS", "SPECIAL-USE", "CREATE-SPECIAL-USE"}
+}
+
+// Command returns the handler for LIST or CREATE
+func (e *ListExtension) Command(name string) server.HandlerFactory {
+	switch name {
+	case "LIST":
+		return func() server.Handler { return &listCmd{} }
+	case "CREATE":
+		return func() server.Handler { return &createCmd{} }
+	}
+	return nil
+}
+
+// listCmd handles LIST with selection and return options
+type listCmd struct {
+	reference string
+	patterns  []string
+
+	// Selection options
+	subscribed     bool
+	specialUse     bool
+	recursiveMatch bool
+
+	// Return options
+	returnSubscribed bool
+	status           []imap.StatusItem
+}
+
+func (cmd *listCmd) Parse(fields []interface{}) error {
+	if len(fields) > 0 {
+		if options, ok := fields[0].([]interface{}); ok {
+			if err := cmd.parseSelection(options); err != nil {
+				return err
+			}
+			fields = fields[1:]
+		}
+	}
+	if len(fields) < 2 {
+		return errors.New("not enough arguments")
+	}
+
+	reference, err := parseMailboxName(fields[0])
+	if err != nil {
+		return err
+	}
+	cmd.reference = reference
+
+	patterns, ok := fields[1].([]interface{})
+	if !ok {
+		patterns = fields[1:2]
+	}
+	for _, f := range patterns {
+		pattern, err := parseMailboxName(f)
+		if err != nil {
+			return err
+		}
+		cmd.patterns = append(cmd.patterns, pattern)
+	}
+	if len(cmd.patterns) == 0 {
+		return errors.New("missing mailbox pattern")
+	}
+
+	if fields = fields[2:]; len(fields) == 0 {
+		return nil
+	}
+	if name, ok := fields[0].(string); !ok || !strings.EqualFold(name, "RETURN") || len(fields) != 2 {
+		return errors.New("unexpected LIST arguments")
+	}
+	options, ok := fields[1].([]interface{})
+	if !ok {
+		return errors.New("RETURN options must be a list")
+	}
+	return cmd.parseReturn(options)
+}
+
+// parseSelection parses LIST selection options (RFC 5258 section 3.1)
+func (cmd *listCmd) parseSelection(options []interface{}) error {
+	for _, f := range options {
+		option, ok := f.(string)
+		if !ok {
+			return errors.New("selection option must be an atom")
+		}
+		switch strings.ToUpper(option) {
+		case "SUBSCRIBED":
+			cmd.subscribed = true
+			cmd.returnSubscribed = true
+		case "SPECIAL-USE":
+			cmd.specialUse = true
+		case "RECURSIVEMATCH":
+			cmd.recursiveMatch = true
+		case "REMOTE":
+			// All mailboxes are local
+		default:
+			return errors.New("unsupported selection option " + option)
+		}
+	}
+	// CHILDINFO is only defined for SUBSCRIBED
+	if cmd.recursiveMatch && !cmd.subscribed {
+		return errors.New("RECURSIVEMATCH requires the SUBSCRIBED selection option")
+	}
+	return nil
+}
+
+// parseReturn parses LIST return options (RFC 5258 section 3.2, RFC 5819)
+func (cmd *listCmd) parseReturn(options []interface{}) error {
+	for i := 0; i < len(options); i++ {
+		option, ok := options[i].(string)
+		if !ok {
+			return errors.New("return option must be an atom")
+		}
+		switch strings.ToUpper(option) {
+		case "SUBSCRIBED":
+			cmd.returnSubscribed = true
+		case "CHILDREN", "SPECIAL-USE":
+			// Always returned
+		case "STATUS":
+			if i+1 >= len(options) {
+				return errors.New("missing STATUS items")
+			}
+			items, ok := options[i+1].([]interface{})
+			if !ok || len(items) == 0 {
+				return errors.New("STATUS items must be a non-empty list")
+			}
+			for _, item := range items {
+				name, ok := item.(string)
+				if !ok {
+					return errors.New("STATUS item must be an atom")
+				}
+				cmd.status = append(cmd.status, imap.StatusItem(strings.ToUpper(name)))
+			}
+			i++
+		default:
+			return errors.New("unsupported return option " + option)
+		}
+	}
+	return nil
+}
+
+func (cmd *listCmd) Handle(conn server.Conn) error {
+	u, ok := conn.Context().User.(*User)
+	if !ok {
+		return server.ErrNotAuthenticated
+	}
+
+	// An empty pattern asks for the hierarchy delimiter (RFC 3501 section 6.3.8)
+	if len(cmd.patterns) == 1 && cmd.patterns[0] == "" {
+		info := &imap.MailboxInfo{Attributes: []string{imap.NoSelectAttr}, Delimiter: "/", Name: ""}
+		return conn.WriteResp(imap.NewUntaggedResp(append([]interface{}{imap.RawString("LIST")}, info.Format()...)))
+	}
+
+	list, err := u.ListMailboxes(false)
+	if err != nil {
+		return err
+	}
+	mailboxes := make([]*Mailbox, 0, len(list))
+	for _, mbox := range list {
+		if mb, ok := mbox.(*Mailbox); ok {
+			mailboxes = append(mailboxes, mb)
+		}
+	}
+
+	for _, mb := range mailboxes {
+		info, err := mb.Info()
+		if err != nil {
+			return err
+		}
+		if !cmd.match(info) {
+			continue
+		}
+
+		selected := cmd.selects(mb)
+		childInfo := cmd.recursiveMatch && cmd.selectsDescendant(mb, mailboxes)
+		if !selected && !childInfo {
+			continue
+		}
+
+		if hasDescendant(mb, mailboxes) {
+			info.Attributes = append(info.Attributes, imap.HasChildrenAttr)
+		} else {
+			info.Attributes = append(info.Attributes, imap.HasNoChildrenAttr)
+		}
+		if cmd.returnSubscribed && isSubscribed(mb) {
+			info.Attributes = append(info.Attributes, "\\Subscribed")
+		}
+
+		fields := append([]interface{}{imap.RawString("LIST")}, info.Format()...)
+		if childInfo {
+			fields = append(fields, []interface{}{"CHILDINFO", []interface{}{"SUBSCRIBED"}})
+		}
+		if err := conn.WriteResp(imap.NewUntaggedResp(fields)); err != nil {
+			return err
+		}
+
+		if len(cmd.status) > 0 && selected && !mb.noselect {
+			// Mailboxes whose status cannot be read are listed without it (RFC 5819 section 2)
+			status, err := mb.Status(cmd.status)
+			if err != nil {
+				continue
+			}
+			if err := conn.WriteResp(&responses.Status{Mailbox: status}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// match reports whether a mailbox matches any of the patterns
+func (cmd *listCmd) match(info *imap.MailboxInfo) bool {
+	for _, pattern := range cmd.patterns {
+		if info.Match(cmd.reference, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// selects reports whether a mailbox meets the selection options
+func (cmd *listCmd) selects(mb *Mailbox) bool {
+	if cmd.subscribed && !isSubscribed(mb) {
+		return false
+	}
+	if cmd.specialUse && (mb.shared || mb.mailbox.SpecialUse == "") {
+		return false
+	}
+	return true
+}
+
+// selectsDescendant reports whether a mailbox below mb meets the selection options
+func (cmd *listCmd) selectsDescendant(mb *Mailbox, mailboxes []*Mailbox) bool {
+	for _, other := range mailboxes {
+		if strings.HasPrefix(other.name, mb.name+"/") && cmd.selects(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDescendant reports whether any mailbox is below mb
+func hasDescendant(mb *Mailbox, mailboxes []*Mailbox) bool {
+	for _, other := range mailboxes {
+		if strings.HasPrefix(other.name, mb.name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isSubscribed reports whether a mailbox is subscribed; shared mailboxes always are
+func isSubscribed(mb *Mailbox) bool {
+	return !mb.noselect && (mb.shared || mb.mailbox.Subscribed)
+}
+
+// createCmd handles CREATE with the USE parameter (RFC 6154 section 3)
+type createCmd struct {
+	commands.Create
+	uses []string
+}
+
+func (cmd *createCmd) Parse(fields []interface{}) error {
+	if err := cmd.Create.Parse(fields); err != nil {
+		return err
+	}
+	if len(fields) < 2 {
+		return nil
+	}
+
+	params, ok := fields[1].([]interface{})
+	if !ok || len(params)%2 != 0 {
+		return errors.New("CREATE parameters must be a list of name and value pairs")
+	}
+	for i := 0; i < len(params); i += 2 {
+		name, ok := params[i].(string)
+		if !ok || !strings.EqualFold(name, "USE") {
+			return errors.New("unsupported CREATE parameter")
+		}
+		uses, err := imap.ParseStringList(params[i+1])
+		if err != nil {
+			return err
+		}
+		cmd.uses = append(cmd.uses, uses...)
+	}
+	return nil
+}
+
+func (cmd *createCmd) Handle(conn server.Conn) error {
+	u, ok := conn.Context().User.(*User)
+	if !ok {
+		return server.ErrNotAuthenticated
+	}
+
+	// A mailbox has a single special use
+	if len(cmd.uses) > 1 {
+		return useAttrError("a mailbox can have only one special use")
+	}
+	var use string
+	if len(cmd.uses) == 1 {
+		var err error
+		if use, err = service.NormalizeSpecialUse(cmd.uses[0]); err != nil {
+			return useAttrError(err.Error())
+		}
+	}
+
+	err := u.createMailbox(cmd.Mailbox, use)
+	if errors.Is(err, service.ErrInvalidSpecialUse) {
+		return useAttrError(err.Error())
+	}
+	return err
+}
+
+// useAttrError returns NO [USEATTR] for a special use the server cannot set
+func useAttrError(info string) error {
+	return &imap.ErrStatusResp{Resp: &imap.StatusResp{
+		Type: imap.StatusRespNo,
+		Code: "USEATTR",
+		Info: info,
+	}}
+}
@@ -0,0 +1,98 @@
+package imap
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+func TestListCmd_Parse(t *testing.T) {
+	var cmd listCmd
+	err := cmd.Parse([]interface{}{
+		[]interface{}{"SUBSCRIBED", "RECURSIVEMATCH"},
+		"",
+		[]interface{}{"INBOX", "Work/%"},
+		"RETURN",
+		[]interface{}{"CHILDREN", "STATUS", []interface{}{"MESSAGES", "unseen"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmd.subscribed || !cmd.recursiveMatch || !cmd.returnSubscribed {
+		t.Errorf("selection options not parsed: %+v", cmd)
+	}
+	if len(cmd.patterns) != 2 || cmd.patterns[1] != "Work/%" {
+		t.Errorf("patterns = %v", cmd.patterns)
+	}
+	if len(cmd.status) != 2 || cmd.status[1] != imap.StatusUnseen {
+		t.Errorf("status items = %v", cmd.status)
+	}
+
+	if err := (&listCmd{}).Parse([]interface{}{[]interface{}{"RECURSIVEMATCH"}, "", "*"}); err == nil {
+		t.Error("expected RECURSIVEMATCH without SUBSCRIBED to be rejected")
+	}
+	if err := (&listCmd{}).Parse([]interface{}{"", "*", "RETURN", []interface{}{"BOGUS"}}); err == nil {
+		t.Error("expected unknown return option to be rejected")
+	}
+}
+
+func TestListCmd_Selects(t *testing.T) {
+	u := &User{user: &domain.User{ID: 1, Email: "owner@example.com"}}
+	work := u.newMailbox(&domain.Mailbox{Name: "Work"}, "Work", "owner@example.com", "lr")
+	sub := u.newMailbox(&domain.Mailbox{Name: "Work/Sub", Subscribed: true}, "Work/Sub", "owner@example.com", "lr")
+	sent := u.newMailbox(&domain.Mailbox{Name: "Sent", Subscribed: true, SpecialUse: "\\Sent"}, "Sent", "owner@example.com", "lr")
+	shared := u.newMailbox(&domain.Mailbox{Name: "Sent", SpecialUse: "\\Sent"}, sharedName("other@example.com", "Sent"), "other@example.com", "lr")
+	mailboxes := []*Mailbox{work, sub, sent, shared}
+
+	cmd := &listCmd{subscribed: true, recursiveMatch: true}
+	if cmd.selects(work) || !cmd.selects(sub) || !cmd.selects(shared) {
+		t.Error("unexpected SUBSCRIBED selection")
+	}
+	if !cmd.selectsDescendant(work, mailboxes) || cmd.selectsDescendant(sent, mailboxes) {
+		t.Error("unexpected CHILDINFO selection")
+	}
+	if !hasDescendant(work, mailboxes) || hasDescendant(sub, mailboxes) {
+		t.Error("unexpected children")
+	}
+
+	cmd = &listCmd{specialUse: true}
+	if !cmd.selects(sent) || cmd.selects(shared) || cmd.selects(work) {
+		t.Error("unexpected SPECIAL-USE selection")
+	}
+}
+
+func TestCreateCmd_Parse(t *testing.T) {
+	var cmd createCmd
+	if err := cmd.Parse([]interface{}{"Outgoing", []interface{}{"USE", []interface{}{"\\Sent"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.Mailbox != "Outgoing" || len(cmd.uses) != 1 || cmd.uses[0] != "\\Sent" {
+		t.Errorf("unexpected parse result: %+v", cmd)
+	}
+	if err := (&createCmd{}).Parse([]interface{}{"Outgoing", []interface{}{"SIZE", "10"}}); err == nil {
+		t.Error("expected unknown CREATE parameter to be rejected")
+	}
+}
+
+func TestGetMetadata_Matches(t *testing.T) {
+	tests := []struct {
+		depth int
+		entry string
+		want  bool
+	}{
+		{0, "/shared/comment", false},
+		{0, "/shared", true},
+		{1, "/shared/comment", true},
+		{1, "/shared/vendor/x", false},
+		{-1, "/shared/vendor/x", true},
+		{-1, "/sharedx", false},
+	}
+	for _, tt := range tests {
+		cmd := &getMetadata{depth: tt.depth}
+		if got := cmd.matches("/shared", tt.entry); got != tt.want {
+			t.Errorf("depth %d: matches(/shared, %q) = %v, want %v", tt.depth, tt.entry, got, tt.want)
+		}
+	}
+}
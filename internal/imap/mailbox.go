@@ -1,9 +1,12 @@
 package imap
 
 import (
+	"io"
+	"strings"
 	"time"
 
 	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/backendutil"
 	"go.uber.org/zap"
 
 	"github.com/btafoya/gomailserver/internal/domain"
@@ -14,6 +17,12 @@ import (
 type Mailbox struct {
 	mailbox        *domain.Mailbox
 	user           *domain.User
+	session        *User
+	name           string // name as seen by the session user
+	ownerEmail     string
+	rights         string // the session user's ACL rights
+	shared         bool   // owned by another user
+	noselect       bool   // a level of the shared hierarchy, not a mailbox
 	messageService service.MessageServiceInterface
 	mailboxService service.MailboxServiceInterface
 	logger         *zap.Logger
@@ -21,7 +30,7 @@ type Mailbox struct {
 
 // Name returns the mailbox name
 func (m *Mailbox) Name() string {
-	return m.mailbox.Name
+	return m.name
 }
 
 // Info returns mailbox information
@@ -29,10 +38,17 @@ func (m *Mailbox) Info() (*imap.MailboxInfo, error) {
 	info := &imap.MailboxInfo{
 		Attributes: []string{},
 		Delimiter:  "/",
-		Name:       m.mailbox.Name,
+		Name:       m.name,
+	}
+	if m.noselect {
+		info.Attributes = append(info.Attributes, imap.NoSelectAttr)
 	}
 
-	// Add special-use attributes
+	// Add special-use attributes; another user's folders are not this user's
+	// Sent or Trash, so shared mailboxes are listed without them
+	if m.shared {
+		return info, nil
+	}
 	switch m.mailbox.SpecialUse {
 	case "\\Drafts":
 		info.Attributes = append(info.Attributes, imap.DraftsAttr)
@@ -51,15 +67,36 @@ func (m *Mailbox) Info() (*imap.MailboxInfo, error) {
 
 // Status returns mailbox status
 func (m *Mailbox) Status(items []imap.StatusItem) (*imap.MailboxStatus, error) {
-	status := imap.NewMailboxStatus(m.mailbox.Name, items)
+	if err := m.require("r"); err != nil {
+		return nil, err
+	}
+
+	messages, err := m.messages()
+	if err != nil {
+		return nil, err
+	}
+
+	status := imap.NewMailboxStatus(m.name, items)
+	// Without any right to change the mailbox it is selected read-only (RFC 4314 section 4)
+	status.ReadOnly = !strings.ContainsAny(m.rights, "swite")
+	status.Flags = []string{imap.SeenFlag, imap.AnsweredFlag, imap.FlaggedFlag, imap.DeletedFlag, imap.DraftFlag}
+	status.PermanentFlags = append(append([]string{}, status.Flags...), imap.TryCreateFlag)
 	status.UidValidity = uint32(m.mailbox.UIDValidity)
 	status.UidNext = uint32(m.mailbox.UIDNext)
-
-	// TODO: Get actual message counts from database
-	status.Messages = 0      // Total messages
-	status.Recent = 0        // Recent messages
-	status.Unseen = 0        // First unseen message sequence number
-	status.UnseenSeqNum = 0  // Sequence number of first unseen
+	status.Messages = uint32(len(messages))
+
+	for i, msg := range messages {
+		if hasFlag(messageFlags(msg), imap.SeenFlag) {
+			continue
+		}
+		status.Unseen++
+		if status.UnseenSeqNum == 0 {
+			status.UnseenSeqNum = uint32(i + 1)
+		}
+	}
+	if len(messages) > 0 && messages[len(messages)-1].UID >= status.UidNext {
+		status.UidNext = messages[len(messages)-1].UID + 1
+	}
 
 	return status, nil
 }
@@ -72,6 +109,11 @@ func (m *Mailbox) SetSubscribed(subscribed bool) error {
 		zap.Bool("subscribed", subscribed),
 	)
 
+	// Subscriptions belong to the owner; shared mailboxes are always listed
+	if m.shared {
+		return nil
+	}
+
 	m.mailbox.Subscribed = subscribed
 	return m.mailboxService.UpdateSubscription(m.mailbox.ID, subscribed)
 }
@@ -96,10 +138,42 @@ func (m *Mailbox) ListMessages(uid bool, seqSet *imap.SeqSet, items []imap.Fetch
 		zap.Bool("uid", uid),
 	)
 
-	// TODO: Fetch messages from database
-	// TODO: Apply sequence set filter
-	// TODO: Fetch requested items
-	// For now, return empty result
+	if err := m.require("r"); err != nil {
+		return err
+	}
+
+	messages, err := m.messages()
+	if err != nil {
+		return err
+	}
+
+	needsContent := fetchNeedsContent(items)
+	marksSeen := fetchMarksSeen(items) && service.HasRights(m.rights, "s")
+	for _, sm := range selectMessages(messages, uid, seqSet) {
+		msg := sm.Message
+		if needsContent {
+			if msg, err = m.loadContent(msg); err != nil {
+				return err
+			}
+		}
+
+		fetched, err := fetchMessage(msg, sm.seqNum, items)
+		if err != nil {
+			return err
+		}
+
+		// Reading the body sets \Seen, which is reported with the fetch
+		if flags := messageFlags(msg); marksSeen && !hasFlag(flags, imap.SeenFlag) {
+			flags = append(flags, imap.SeenFlag)
+			if err := m.messageService.SetFlags(msg.ID, flags); err != nil {
+				return err
+			}
+			fetched.Items[imap.FetchFlags] = nil
+			fetched.Flags = flags
+		}
+
+		ch <- fetched
+	}
 
 	return nil
 }
@@ -112,25 +186,114 @@ func (m *Mailbox) SearchMessages(uid bool, criteria *imap.SearchCriteria) ([]uin
 		zap.Bool("uid", uid),
 	)
 
-	// TODO: Implement search
-	// TODO: Support various search criteria (FROM, TO, SUBJECT, etc.)
-	return []uint32{}, nil
+	if err := m.require("r"); err != nil {
+		return nil, err
+	}
+
+	matches, err := m.search(criteria, &domain.MessageQuery{})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint32, 0, len(matches))
+	for _, sm := range matches {
+		if uid {
+			ids = append(ids, sm.UID)
+		} else {
+			ids = append(ids, sm.seqNum)
+		}
+	}
+	return ids, nil
+}
+
+// search returns the messages matching criteria in the order q asks for
+// The criteria that message columns answer (UIDs, sequence numbers, flags
+// and size) are added to q and matched by the repository; the rest are
+// matched here. Message content is only read when those look at the body,
+// and with the full-text index ready, BODY and TEXT are answered from the
+// index instead.
+func (m *Mailbox) search(criteria *imap.SearchCriteria, q *domain.MessageQuery) ([]seqMessage, error) {
+	q.MailboxID = m.mailbox.ID
+	rest := queryCriteria(criteria, q)
+	result, err := m.messageService.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Matches) == 0 {
+		return nil, nil
+	}
+	resolveCriteria(rest, result.Count, result.LastUID)
+
+	var hits textHits
+	if m.session != nil && m.session.search != nil && m.session.search.Ready() {
+		hits = textHits{}
+		if err := m.lookupTextTerms(rest, hits); err != nil {
+			return nil, err
+		}
+	}
+
+	needsContent := searchNeedsContent(rest, hits != nil)
+	matches := make([]seqMessage, 0, len(result.Matches))
+	for _, match := range result.Matches {
+		msg := match.Message
+		if needsContent {
+			if msg, err = m.loadContent(msg); err != nil {
+				return nil, err
+			}
+		}
+
+		ok, err := matchIndexed(msg, match.SeqNum, rest, hits)
+		if err != nil {
+			// A message that cannot be parsed does not match
+			m.logger.Debug("failed to match message",
+				zap.Int64("message_id", msg.ID),
+				zap.Error(err),
+			)
+			continue
+		}
+		if ok {
+			matches = append(matches, seqMessage{seqNum: match.SeqNum, Message: match.Message})
+		}
+	}
+	return matches, nil
 }
 
 // CreateMessage appends a new message to the mailbox
 func (m *Mailbox) CreateMessage(flags []string, date time.Time, body imap.Literal) error {
+	_, err := m.appendMessage(flags, date, body)
+	return err
+}
+
+// appendMessage stores an appended message and returns its UID
+func (m *Mailbox) appendMessage(flags []string, date time.Time, body imap.Literal) (uint32, error) {
 	m.logger.Debug("creating message",
 		zap.Int64("mailbox_id", m.mailbox.ID),
 		zap.String("mailbox", m.mailbox.Name),
 		zap.Strings("flags", flags),
 	)
 
-	// TODO: Read message from body
-	// TODO: Store message using messageService
-	// TODO: Set flags
-	// TODO: Increment UIDNext
+	if err := m.require("i"); err != nil {
+		return 0, err
+	}
 
-	return nil
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return 0, err
+	}
+	// Appending to a shared mailbox counts against the owner's quota
+	if err := m.session.checkQuota(m.mailbox.UserID, int64(len(data)), 1); err != nil {
+		return 0, err
+	}
+
+	uid, err := m.mailboxService.AllocateUID(m.mailbox.ID)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := m.messageService.Append(m.mailbox.UserID, m.mailbox.ID, uid, data, storedFlags(flags), date); err != nil {
+		return 0, err
+	}
+
+	return uint32(uid), nil
 }
 
 // UpdateMessagesFlags updates message flags
@@ -143,15 +306,43 @@ func (m *Mailbox) UpdateMessagesFlags(uid bool, seqSet *imap.SeqSet, operation i
 		zap.Strings("flags", flags),
 	)
 
-	// TODO: Fetch messages by sequence set
-	// TODO: Update flags based on operation (SET, ADD, REMOVE)
-	// TODO: Handle \Seen, \Deleted, \Flagged, \Answered, \Draft
+	if err := m.require(flagRights(flags)); err != nil {
+		return err
+	}
+
+	messages, err := m.messages()
+	if err != nil {
+		return err
+	}
+
+	for _, sm := range selectMessages(messages, uid, seqSet) {
+		updated := storedFlags(backendutil.UpdateFlags(messageFlags(sm.Message), operation, flags))
+		if err := m.messageService.SetFlags(sm.ID, updated); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
+// copyResult describes the messages of a COPY or MOVE for UIDPLUS (RFC 4315)
+// srcUIDs[i] was copied to dstUIDs[i]; seqNums lists the moved messages'
+// sequence numbers in ascending order.
+type copyResult struct {
+	uidValidity uint32
+	srcUIDs     []uint32
+	dstUIDs     []uint32
+	seqNums     []uint32
+}
+
 // CopyMessages copies messages to another mailbox
 func (m *Mailbox) CopyMessages(uid bool, seqSet *imap.SeqSet, dest string) error {
+	_, err := m.copyMessages(uid, seqSet, dest)
+	return err
+}
+
+// copyMessages copies messages to another mailbox, preserving flags and internal dates
+func (m *Mailbox) copyMessages(uid bool, seqSet *imap.SeqSet, dest string) (*copyResult, error) {
 	m.logger.Debug("copying messages",
 		zap.Int64("mailbox_id", m.mailbox.ID),
 		zap.String("mailbox", m.mailbox.Name),
@@ -159,24 +350,205 @@ func (m *Mailbox) CopyMessages(uid bool, seqSet *imap.SeqSet, dest string) error
 		zap.Bool("uid", uid),
 	)
 
-	// TODO: Get destination mailbox
-	// TODO: Fetch messages by sequence set
-	// TODO: Copy message data to destination mailbox
-	// TODO: Preserve flags and date
+	if err := m.require("r"); err != nil {
+		return nil, err
+	}
+	target, err := m.target(dest)
+	if err != nil {
+		return nil, err
+	}
 
-	return nil
+	messages, err := m.messages()
+	if err != nil {
+		return nil, err
+	}
+	selected := selectMessages(messages, uid, seqSet)
+
+	var size int64
+	for _, sm := range selected {
+		size += sm.Size
+	}
+	if err := m.session.checkQuota(target.mailbox.UserID, size, int64(len(selected))); err != nil {
+		return nil, err
+	}
+
+	result := &copyResult{uidValidity: uint32(target.mailbox.UIDValidity)}
+	for _, sm := range selected {
+		dstUID, err := m.mailboxService.AllocateUID(target.mailbox.ID)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := m.messageService.Copy(sm.ID, target.mailbox, dstUID); err != nil {
+			return nil, err
+		}
+		result.srcUIDs = append(result.srcUIDs, sm.UID)
+		result.dstUIDs = append(result.dstUIDs, uint32(dstUID))
+	}
+
+	return result, nil
+}
+
+// MoveMessages moves messages to another mailbox (RFC 6851)
+func (m *Mailbox) MoveMessages(uid bool, seqSet *imap.SeqSet, dest string) error {
+	_, err := m.moveMessages(uid, seqSet, dest)
+	return err
+}
+
+// moveMessages moves messages to another mailbox, keeping flags and internal dates
+func (m *Mailbox) moveMessages(uid bool, seqSet *imap.SeqSet, dest string) (*copyResult, error) {
+	m.logger.Debug("moving messages",
+		zap.Int64("mailbox_id", m.mailbox.ID),
+		zap.String("mailbox", m.mailbox.Name),
+		zap.String("destination", dest),
+		zap.Bool("uid", uid),
+	)
+
+	// Moving out of a mailbox deletes and expunges there (RFC 4314 section 4)
+	if err := m.require("rte"); err != nil {
+		return nil, err
+	}
+	target, err := m.target(dest)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := m.messages()
+	if err != nil {
+		return nil, err
+	}
+	selected := selectMessages(messages, uid, seqSet)
+
+	// Within one owner a move changes no usage
+	if target.mailbox.UserID != m.mailbox.UserID {
+		var size int64
+		for _, sm := range selected {
+			size += sm.Size
+		}
+		if err := m.session.checkQuota(target.mailbox.UserID, size, int64(len(selected))); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &copyResult{uidValidity: uint32(target.mailbox.UIDValidity)}
+	for _, sm := range selected {
+		srcUID := sm.UID
+		dstUID, err := m.mailboxService.AllocateUID(target.mailbox.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.messageService.Move(sm.ID, target.mailbox, dstUID); err != nil {
+			return nil, err
+		}
+		result.srcUIDs = append(result.srcUIDs, srcUID)
+		result.dstUIDs = append(result.dstUIDs, uint32(dstUID))
+		result.seqNums = append(result.seqNums, sm.seqNum)
+	}
+
+	return result, nil
+}
+
+// target resolves the destination mailbox of a COPY or MOVE
+func (m *Mailbox) target(dest string) (*Mailbox, error) {
+	target, err := m.session.lookupMailbox(dest)
+	if err != nil {
+		return nil, &imap.ErrStatusResp{Resp: &imap.StatusResp{
+			Type: imap.StatusRespNo,
+			Code: imap.CodeTryCreate,
+			Info: "No such mailbox",
+		}}
+	}
+	if err := target.require("i"); err != nil {
+		return nil, err
+	}
+	return target, nil
 }
 
 // Expunge permanently removes messages marked for deletion
 func (m *Mailbox) Expunge() error {
+	_, err := m.expunge(nil)
+	return err
+}
+
+// expunge removes messages marked for deletion, limited to uids when given
+// (RFC 4315 UID EXPUNGE), and returns their sequence numbers in ascending order
+func (m *Mailbox) expunge(uids *imap.SeqSet) ([]uint32, error) {
 	m.logger.Debug("expunging messages",
 		zap.Int64("mailbox_id", m.mailbox.ID),
 		zap.String("mailbox", m.mailbox.Name),
 	)
 
-	// TODO: Find messages with \Deleted flag
-	// TODO: Permanently delete them from storage
-	// TODO: Update sequence numbers
+	if err := m.require("e"); err != nil {
+		return nil, err
+	}
 
-	return nil
+	messages, err := m.messages()
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make([]seqMessage, 0, len(messages))
+	if uids != nil {
+		selected = selectMessages(messages, true, uids)
+	} else {
+		for i, msg := range messages {
+			selected = append(selected, seqMessage{seqNum: uint32(i + 1), Message: msg})
+		}
+	}
+
+	var seqNums []uint32
+	for _, sm := range selected {
+		if !hasFlag(messageFlags(sm.Message), imap.DeletedFlag) {
+			continue
+		}
+		if err := m.messageService.Delete(sm.ID); err != nil {
+			return nil, err
+		}
+		seqNums = append(seqNums, sm.seqNum)
+	}
+
+	return seqNums, nil
+}
+
+// require checks that the session user holds every right in rights
+func (m *Mailbox) require(rights string) error {
+	if service.HasRights(m.rights, rights) {
+		return nil
+	}
+	m.logger.Debug("mailbox access denied",
+		zap.String("mailbox", m.name),
+		zap.String("rights", m.rights),
+		zap.String("required", rights),
+	)
+	return &imap.ErrStatusResp{Resp: &imap.StatusResp{
+		Type: imap.StatusRespNo,
+		Code: "NOPERM",
+		Info: "Permission denied",
+	}}
+}
+
+// storedFlags drops the session-only \Recent flag from flags
+func storedFlags(flags []string) []string {
+	stored := make([]string, 0, len(flags))
+	for _, flag := range flags {
+		if flag != imap.RecentFlag {
+			stored = append(stored, flag)
+		}
+	}
+	return stored
+}
+
+// flagRights returns the rights needed to change flags (RFC 4314 section 4)
+func flagRights(flags []string) string {
+	var rights string
+	for _, flag := range flags {
+		switch flag {
+		case imap.SeenFlag:
+			rights += "s"
+		case imap.DeletedFlag:
+			rights += "t"
+		default:
+			rights += "w"
+		}
+	}
+	return rights
 }
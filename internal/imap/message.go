@@ -0,0 +1,365 @@
+package imap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/backendutil"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/textproto"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+// seqMessage is a message with its sequence number in the selected mailbox
+type seqMessage struct {
+	seqNum uint32
+	*domain.Message
+}
+
+// messages returns the mailbox's messages in UID order, without content
+// A message's sequence number is its position in this list.
+func (m *Mailbox) messages() ([]*domain.Message, error) {
+	return m.messageService.List(m.mailbox.ID)
+}
+
+// loadContent returns msg with its content, reading it from storage if needed
+func (m *Mailbox) loadContent(msg *domain.Message) (*domain.Message, error) {
+	if msg.Content != nil {
+		return msg, nil
+	}
+	return m.messageService.GetByID(msg.ID)
+}
+
+// selectMessages returns the messages in seqSet, given as UIDs or sequence numbers
+func selectMessages(messages []*domain.Message, uid bool, seqSet *imap.SeqSet) []seqMessage {
+	if len(messages) == 0 {
+		return nil
+	}
+	last := uint32(len(messages))
+	if uid {
+		last = messages[len(messages)-1].UID
+	}
+	set := resolveSeqSet(seqSet, last)
+
+	var selected []seqMessage
+	for i, msg := range messages {
+		id := uint32(i + 1)
+		if uid {
+			id = msg.UID
+		}
+		if set.Contains(id) {
+			selected = append(selected, seqMessage{seqNum: uint32(i + 1), Message: msg})
+		}
+	}
+	return selected
+}
+
+// resolveSeqSet replaces "*" in set with last, the largest number in use (RFC 3501 section 9)
+func resolveSeqSet(set *imap.SeqSet, last uint32) *imap.SeqSet {
+	resolved := new(imap.SeqSet)
+	if set == nil || last == 0 {
+		return resolved
+	}
+	for _, seq := range set.Set {
+		start, stop := seq.Start, seq.Stop
+		if start == 0 {
+			start = last
+		}
+		if stop == 0 {
+			stop = last
+		}
+		if start > stop {
+			start, stop = stop, start
+		}
+		resolved.AddRange(start, stop)
+	}
+	return resolved
+}
+
+// resolveCriteria resolves "*" in the sequence sets of c and its sub-criteria
+func resolveCriteria(c *imap.SearchCriteria, lastSeq, lastUID uint32) {
+	if c.SeqNum != nil {
+		c.SeqNum = resolveSeqSet(c.SeqNum, lastSeq)
+	}
+	if c.Uid != nil {
+		c.Uid = resolveSeqSet(c.Uid, lastUID)
+	}
+	for _, not := range c.Not {
+		resolveCriteria(not, lastSeq, lastUID)
+	}
+	for _, or := range c.Or {
+		resolveCriteria(or[0], lastSeq, lastUID)
+		resolveCriteria(or[1], lastSeq, lastUID)
+	}
+}
+
+// queryCriteria moves the criteria of c that message columns answer into q
+// and returns the criteria left to match; sub-criteria always stay in c
+func queryCriteria(c *imap.SearchCriteria, q *domain.MessageQuery) *imap.SearchCriteria {
+	rest := *c
+	if c.Uid != nil {
+		q.UIDs = messageRanges(c.Uid)
+	}
+	if c.SeqNum != nil {
+		q.SeqNums = messageRanges(c.SeqNum)
+	}
+	q.WithFlags, q.WithoutFlags = c.WithFlags, c.WithoutFlags
+	q.Larger, q.Smaller = int64(c.Larger), int64(c.Smaller)
+
+	rest.Uid, rest.SeqNum = nil, nil
+	rest.WithFlags, rest.WithoutFlags = nil, nil
+	rest.Larger, rest.Smaller = 0, 0
+	return &rest
+}
+
+// messageRanges converts a sequence set, keeping "*" as 0
+func messageRanges(set *imap.SeqSet) []domain.MessageRange {
+	ranges := make([]domain.MessageRange, 0, len(set.Set))
+	for _, seq := range set.Set {
+		ranges = append(ranges, domain.MessageRange{Start: seq.Start, Stop: seq.Stop})
+	}
+	return ranges
+}
+
+// messageFlags returns a message's stored flags
+func messageFlags(msg *domain.Message) []string {
+	return strings.Fields(msg.Flags)
+}
+
+// hasFlag reports whether flags contains flag
+func hasFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// storedHeader rebuilds a message's header from the fields stored alongside it
+// Only the last value of repeated fields is kept, which is enough for
+// envelopes and header searches.
+func storedHeader(msg *domain.Message) textproto.Header {
+	var h textproto.Header
+	fields := make(map[string]string)
+	if msg.Headers != "" {
+		if err := json.Unmarshal([]byte(msg.Headers), &fields); err != nil {
+			return h
+		}
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		h.Add(k, fields[k])
+	}
+	return h
+}
+
+// headerAndBody splits a message's content into its header and body
+func headerAndBody(msg *domain.Message) (textproto.Header, io.Reader, error) {
+	body := bufio.NewReader(bytes.NewReader(msg.Content))
+	hdr, err := textproto.ReadHeader(body)
+	return hdr, body, err
+}
+
+// fetchNeedsContent reports whether any item must be read from the message content
+func fetchNeedsContent(items []imap.FetchItem) bool {
+	for _, item := range items {
+		switch item {
+		case imap.FetchEnvelope, imap.FetchFlags, imap.FetchInternalDate, imap.FetchRFC822Size, imap.FetchUid:
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// fetchMarksSeen reports whether fetching items sets \Seen (RFC 3501 section 6.4.5)
+func fetchMarksSeen(items []imap.FetchItem) bool {
+	for _, item := range items {
+		switch item {
+		case imap.FetchRFC822, imap.FetchRFC822Text:
+			return true
+		}
+		if section, err := imap.ParseBodySectionName(item); err == nil && !section.Peek {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchMessage builds the FETCH data for a message
+// Without content only the metadata items and ENVELOPE are available.
+func fetchMessage(msg *domain.Message, seqNum uint32, items []imap.FetchItem) (*imap.Message, error) {
+	fetched := imap.NewMessage(seqNum, items)
+	for _, item := range items {
+		switch item {
+		case imap.FetchEnvelope:
+			hdr := storedHeader(msg)
+			if msg.Content != nil {
+				hdr, _, _ = headerAndBody(msg)
+			}
+			fetched.Envelope, _ = backendutil.FetchEnvelope(hdr)
+		case imap.FetchBody, imap.FetchBodyStructure:
+			hdr, body, _ := headerAndBody(msg)
+			fetched.BodyStructure, _ = backendutil.FetchBodyStructure(hdr, body, item == imap.FetchBodyStructure)
+		case imap.FetchFlags:
+			fetched.Flags = messageFlags(msg)
+		case imap.FetchInternalDate:
+			fetched.InternalDate = msg.InternalDate
+		case imap.FetchRFC822Size:
+			fetched.Size = uint32(msg.Size)
+		case imap.FetchUid:
+			fetched.Uid = msg.UID
+		default:
+			section, err := imap.ParseBodySectionName(item)
+			if err != nil {
+				break
+			}
+			hdr, body, err := headerAndBody(msg)
+			if err != nil {
+				return nil, err
+			}
+			l, _ := backendutil.FetchBodySection(hdr, body, section)
+			fetched.Body[section] = l
+		}
+	}
+	return fetched, nil
+}
+
+// searchNeedsContent reports whether c matches against the message body or size
+func searchNeedsContent(c *imap.SearchCriteria, indexed bool) bool {
+	if (!indexed && (len(c.Body) > 0 || len(c.Text) > 0)) || c.Larger > 0 || c.Smaller > 0 {
+		return true
+	}
+	for _, not := range c.Not {
+		if searchNeedsContent(not, indexed) {
+			return true
+		}
+	}
+	for _, or := range c.Or {
+		if searchNeedsContent(or[0], indexed) || searchNeedsContent(or[1], indexed) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchMessage reports whether a message matches c
+// Without content the message is matched on its stored header fields.
+func matchMessage(msg *domain.Message, seqNum uint32, c *imap.SearchCriteria) (bool, error) {
+	var e *message.Entity
+	var err error
+	if msg.Content != nil {
+		e, err = message.Read(bytes.NewReader(msg.Content))
+	} else {
+		e, err = message.New(message.Header{Header: storedHeader(msg)}, strings.NewReader(""))
+	}
+	if err != nil && !message.IsUnknownCharset(err) {
+		return false, err
+	}
+	return backendutil.Match(e, seqNum, msg.UID, msg.InternalDate, messageFlags(msg), c)
+}
+
+// textHits holds the IDs of the messages matching each BODY and TEXT term of
+// a search, keyed by the search key and term
+type textHits map[string]map[int64]bool
+
+// lookupTextTerms queries the full-text index for every BODY and TEXT term in c
+func (m *Mailbox) lookupTextTerms(c *imap.SearchCriteria, hits textHits) error {
+	for _, term := range c.Body {
+		if err := m.lookupTextTerm(hits, "BODY "+term, &domain.SearchQuery{Body: []string{term}}); err != nil {
+			return err
+		}
+	}
+	for _, term := range c.Text {
+		if err := m.lookupTextTerm(hits, "TEXT "+term, &domain.SearchQuery{Text: []string{term}}); err != nil {
+			return err
+		}
+	}
+	for _, not := range c.Not {
+		if err := m.lookupTextTerms(not, hits); err != nil {
+			return err
+		}
+	}
+	for _, or := range c.Or {
+		if err := m.lookupTextTerms(or[0], hits); err != nil {
+			return err
+		}
+		if err := m.lookupTextTerms(or[1], hits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupTextTerm records the messages of the mailbox matching q under key
+func (m *Mailbox) lookupTextTerm(hits textHits, key string, q *domain.SearchQuery) error {
+	if _, ok := hits[key]; ok {
+		return nil
+	}
+	q.UserID = m.mailbox.UserID
+	q.MailboxID = m.mailbox.ID
+	ids, err := m.session.search.Search(q)
+	if err != nil {
+		return err
+	}
+	set := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	hits[key] = set
+	return nil
+}
+
+// matchIndexed reports whether a message matches c, answering BODY and TEXT
+// from hits when the index was searched
+func matchIndexed(msg *domain.Message, seqNum uint32, c *imap.SearchCriteria, hits textHits) (bool, error) {
+	if hits == nil {
+		return matchMessage(msg, seqNum, c)
+	}
+
+	rest := *c
+	rest.Body, rest.Text, rest.Not, rest.Or = nil, nil, nil, nil
+	if ok, err := matchMessage(msg, seqNum, &rest); err != nil || !ok {
+		return false, err
+	}
+	for _, term := range c.Body {
+		if !hits["BODY "+term][msg.ID] {
+			return false, nil
+		}
+	}
+	for _, term := range c.Text {
+		if !hits["TEXT "+term][msg.ID] {
+			return false, nil
+		}
+	}
+	for _, not := range c.Not {
+		ok, err := matchIndexed(msg, seqNum, not, hits)
+		if err != nil || ok {
+			return false, err
+		}
+	}
+	for _, or := range c.Or {
+		ok, err := matchIndexed(msg, seqNum, or[0], hits)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			if ok, err = matchIndexed(msg, seqNum, or[1], hits); err != nil || !ok {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,312 @@
+package imap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/repository"
+	"github.com/btafoya/gomailserver/internal/service"
+)
+
+// memoryMessageService keeps messages in memory for mailbox tests
+type memoryMessageService struct {
+	mockMessageService
+	messages []*domain.Message
+}
+
+func (s *memoryMessageService) Append(userID, mailboxID, uid int64, data []byte, flags []string, date time.Time) (*domain.Message, error) {
+	msg := &domain.Message{
+		ID:           int64(len(s.messages) + 1),
+		UserID:       userID,
+		MailboxID:    mailboxID,
+		UID:          uint32(uid),
+		Size:         int64(len(data)),
+		Flags:        strings.Join(flags, " "),
+		InternalDate: date,
+		Content:      data,
+	}
+	s.messages = append(s.messages, msg)
+	return msg, nil
+}
+
+func (s *memoryMessageService) GetByID(id int64) (*domain.Message, error) {
+	for _, msg := range s.messages {
+		if msg.ID == id {
+			return msg, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+func (s *memoryMessageService) List(mailboxID int64) ([]*domain.Message, error) {
+	var list []*domain.Message
+	for _, msg := range s.messages {
+		if msg.MailboxID == mailboxID {
+			list = append(list, msg)
+		}
+	}
+	return list, nil
+}
+
+// Query numbers the mailbox's messages and applies the UID and sequence
+// number ranges; the other conditions and the order are left to the tests
+func (s *memoryMessageService) Query(q *domain.MessageQuery) (*domain.MessageQueryResult, error) {
+	list, _ := s.List(q.MailboxID)
+	result := &domain.MessageQueryResult{Count: uint32(len(list))}
+	if len(list) > 0 {
+		result.LastUID = list[len(list)-1].UID
+	}
+	for i, msg := range list {
+		seqNum := uint32(i + 1)
+		if inRanges(q.UIDs, msg.UID, result.LastUID) && inRanges(q.SeqNums, seqNum, result.Count) {
+			result.Matches = append(result.Matches, domain.MessageMatch{SeqNum: seqNum, Message: msg})
+		}
+	}
+	return result, nil
+}
+
+// inRanges reports whether n is in ranges, where 0 stands for last
+func inRanges(ranges []domain.MessageRange, n, last uint32) bool {
+	if ranges == nil {
+		return true
+	}
+	for _, r := range ranges {
+		start, stop := r.Start, r.Stop
+		if start == 0 {
+			start = last
+		}
+		if stop == 0 {
+			stop = last
+		}
+		if start > stop {
+			start, stop = stop, start
+		}
+		if n >= start && n <= stop {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryMessageService) SetFlags(id int64, flags []string) error {
+	msg, err := s.GetByID(id)
+	if err != nil {
+		return err
+	}
+	msg.Flags = strings.Join(flags, " ")
+	return nil
+}
+
+func (s *memoryMessageService) Copy(id int64, target *domain.Mailbox, uid int64) (*domain.Message, error) {
+	msg, err := s.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	copied := *msg
+	copied.ID = int64(len(s.messages) + 1)
+	copied.MailboxID = target.ID
+	copied.UID = uint32(uid)
+	s.messages = append(s.messages, &copied)
+	return &copied, nil
+}
+
+func (s *memoryMessageService) Move(id int64, target *domain.Mailbox, uid int64) error {
+	msg, err := s.GetByID(id)
+	if err != nil {
+		return err
+	}
+	msg.MailboxID = target.ID
+	msg.UID = uint32(uid)
+	return nil
+}
+
+func (s *memoryMessageService) Delete(id int64) error {
+	for i, msg := range s.messages {
+		if msg.ID == id {
+			s.messages = append(s.messages[:i], s.messages[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// counterMailboxService allocates increasing UIDs per mailbox
+type counterMailboxService struct {
+	mockMailboxService
+	mailboxes map[string]*domain.Mailbox
+}
+
+func (s *counterMailboxService) GetByName(userID int64, name string) (*domain.Mailbox, error) {
+	if mb, ok := s.mailboxes[name]; ok {
+		return mb, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (s *counterMailboxService) AllocateUID(mailboxID int64) (int64, error) {
+	for _, mb := range s.mailboxes {
+		if mb.ID == mailboxID {
+			uid := mb.UIDNext
+			mb.UIDNext++
+			return uid, nil
+		}
+	}
+	return 0, errors.New("not found")
+}
+
+func TestSelectMessages(t *testing.T) {
+	messages := []*domain.Message{{UID: 3}, {UID: 7}, {UID: 9}}
+
+	set, _ := imap.ParseSeqSet("2:*")
+	if got := selectMessages(messages, false, set); len(got) != 2 || got[0].seqNum != 2 || got[1].UID != 9 {
+		t.Errorf("unexpected sequence selection %+v", got)
+	}
+
+	// "*" is the largest UID in use, so 20:* still selects the last message
+	set, _ = imap.ParseSeqSet("20:*")
+	if got := selectMessages(messages, true, set); len(got) != 1 || got[0].UID != 9 || got[0].seqNum != 3 {
+		t.Errorf("unexpected UID selection %+v", got)
+	}
+}
+
+// stubSearchRepository answers full-text queries from a fixed term index
+type stubSearchRepository struct {
+	repository.SearchRepository
+	terms   map[string][]int64
+	queries []*domain.SearchQuery
+}
+
+func (r *stubSearchRepository) EnableFTS() (bool, error) { return true, nil }
+
+func (r *stubSearchRepository) Unindexed(afterID int64, limit int) ([]int64, error) {
+	return nil, nil
+}
+
+func (r *stubSearchRepository) Search(q *domain.SearchQuery) ([]int64, error) {
+	r.queries = append(r.queries, q)
+	return r.terms[strings.Join(append(q.Body, q.Text...), " ")], nil
+}
+
+func TestMailbox_SearchIndex(t *testing.T) {
+	messages := &memoryMessageService{}
+	mailboxes := &counterMailboxService{mailboxes: map[string]*domain.Mailbox{
+		"INBOX": {ID: 1, UserID: 1, Name: "INBOX", UIDValidity: 100, UIDNext: 1},
+	}}
+	repo := &stubSearchRepository{terms: map[string][]int64{
+		"budget": {2, 3},
+		"draft":  {3},
+	}}
+	search := service.NewSearchService(repo, nil, zap.NewNop())
+	user := &User{
+		user:           &domain.User{ID: 1, Email: "user@example.com"},
+		mailboxService: mailboxes,
+		messageService: messages,
+		search:         search,
+		logger:         zap.NewNop(),
+	}
+	inbox := user.newMailbox(mailboxes.mailboxes["INBOX"], "INBOX", "user@example.com", service.AllRights)
+
+	for _, subject := range []string{"one", "two", "three"} {
+		body := bytes.NewBufferString("Subject: " + subject + "\r\n\r\nHello " + subject + "\r\n")
+		if _, err := inbox.appendMessage(nil, time.Now(), body); err != nil {
+			t.Fatalf("appendMessage failed: %v", err)
+		}
+	}
+
+	// Until the backfill finishes, the message content is searched
+	ids, err := inbox.SearchMessages(true, &imap.SearchCriteria{Body: []string{"Hello two"}})
+	if err != nil || len(ids) != 1 || ids[0] != 2 || len(repo.queries) != 0 {
+		t.Errorf("expected content search to find UID 2, got %v, %v", ids, err)
+	}
+
+	search.RunIndexer(context.Background())
+	criteria := &imap.SearchCriteria{
+		Body: []string{"budget"},
+		Not:  []*imap.SearchCriteria{{Text: []string{"draft"}}},
+	}
+	ids, err = inbox.SearchMessages(true, criteria)
+	if err != nil || len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("expected indexed search to find UID 2, got %v, %v", ids, err)
+	}
+	for _, q := range repo.queries {
+		if q.UserID != 1 || q.MailboxID != 1 {
+			t.Errorf("expected queries limited to the mailbox, got %+v", q)
+		}
+	}
+}
+
+func TestMailbox_Messages(t *testing.T) {
+	messages := &memoryMessageService{}
+	mailboxes := &counterMailboxService{mailboxes: map[string]*domain.Mailbox{
+		"INBOX":   {ID: 1, UserID: 1, Name: "INBOX", UIDValidity: 100, UIDNext: 1},
+		"Archive": {ID: 2, UserID: 1, Name: "Archive", UIDValidity: 200, UIDNext: 50},
+	}}
+	user := &User{
+		user:           &domain.User{ID: 1, Email: "user@example.com"},
+		mailboxService: mailboxes,
+		messageService: messages,
+		logger:         zap.NewNop(),
+	}
+	inbox := user.newMailbox(mailboxes.mailboxes["INBOX"], "INBOX", "user@example.com", service.AllRights)
+
+	for _, subject := range []string{"one", "two", "three"} {
+		body := bytes.NewBufferString("Subject: " + subject + "\r\n\r\nHello " + subject + "\r\n")
+		if _, err := inbox.appendMessage([]string{imap.RecentFlag}, time.Now(), body); err != nil {
+			t.Fatalf("appendMessage failed: %v", err)
+		}
+	}
+
+	all, _ := imap.ParseSeqSet("1:*")
+	if err := inbox.UpdateMessagesFlags(false, all, imap.AddFlags, []string{imap.FlaggedFlag}); err != nil {
+		t.Fatalf("UpdateMessagesFlags failed: %v", err)
+	}
+
+	ch := make(chan *imap.Message, 3)
+	section := &imap.BodySectionName{}
+	if err := inbox.ListMessages(true, all, []imap.FetchItem{imap.FetchUid, section.FetchItem()}, ch); err != nil {
+		t.Fatalf("ListMessages failed: %v", err)
+	}
+	for fetched := range ch {
+		if fetched.GetBody(section) == nil || !hasFlag(fetched.Flags, imap.SeenFlag) || !hasFlag(fetched.Flags, imap.FlaggedFlag) {
+			t.Errorf("expected body and \\Seen flag for message %d, got %v", fetched.Uid, fetched.Flags)
+		}
+	}
+
+	ids, err := inbox.SearchMessages(true, &imap.SearchCriteria{Body: []string{"Hello two"}})
+	if err != nil || len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("expected body search to find UID 2, got %v, %v", ids, err)
+	}
+
+	two, _ := imap.ParseSeqSet("2")
+	copied, err := inbox.copyMessages(false, two, "Archive")
+	if err != nil || copied.uidValidity != 200 || copied.srcUIDs[0] != 2 || copied.dstUIDs[0] != 50 {
+		t.Errorf("unexpected copy result %+v, %v", copied, err)
+	}
+
+	moved, err := inbox.moveMessages(true, two, "Archive")
+	if err != nil || len(moved.seqNums) != 1 || moved.seqNums[0] != 2 || moved.srcUIDs[0] != 2 || moved.dstUIDs[0] != 51 {
+		t.Errorf("unexpected move result %+v, %v", moved, err)
+	}
+
+	last, _ := imap.ParseSeqSet("*")
+	if err := inbox.UpdateMessagesFlags(false, last, imap.AddFlags, []string{imap.DeletedFlag}); err != nil {
+		t.Fatalf("UpdateMessagesFlags failed: %v", err)
+	}
+	expunged, err := inbox.expunge(nil)
+	if err != nil || len(expunged) != 1 || expunged[0] != 2 {
+		t.Errorf("expected sequence number 2 to be expunged, got %v, %v", expunged, err)
+	}
+
+	status, err := inbox.Status([]imap.StatusItem{imap.StatusMessages, imap.StatusUnseen})
+	if err != nil || status.Messages != 1 || status.Unseen != 0 {
+		t.Errorf("unexpected status %+v, %v", status, err)
+	}
+}
@@ -0,0 +1,407 @@
+package imap
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/server"
+	"github.com/emersion/go-imap/utf7"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/service"
+)
+
+// specialUseEntry mirrors a mailbox's special-use attribute (RFC 6154 section 4)
+const specialUseEntry = "/private/specialuse"
+
+// MetadataExtension adds METADATA (RFC 5464) for server and mailbox entries
+// On another user's mailbox, private entries need the l right, reading shared
+// entries the r right and changing them the w right.
+type MetadataExtension struct{}
+
+// Capabilities returns the METADATA capability, which includes METADATA-SERVER
+func (e *MetadataExtension) Capabilities(c server.Conn) []string {
+	return []string{"METADATA"}
+}
+
+// Command returns the handler for a METADATA command
+func (e *MetadataExtension) Command(name string) server.HandlerFactory {
+	switch name {
+	case "GETMETADATA":
+		return func() server.Handler { return &getMetadata{} }
+	case "SETMETADATA":
+		return func() server.Handler { return &setMetadata{} }
+	}
+	return nil
+}
+
+// metadataTarget resolves the mailbox of a METADATA command; "" is the server
+// and returns a nil mailbox
+func metadataTarget(conn server.Conn, name string) (*User, *Mailbox, error) {
+	u, ok := conn.Context().User.(*User)
+	if !ok {
+		return nil, nil, server.ErrNotAuthenticated
+	}
+	if u.metadata == nil {
+		return nil, nil, errors.New("metadata is not enabled")
+	}
+	if name == "" {
+		return u, nil, nil
+	}
+
+	mb, err := u.lookupMailbox(name)
+	if err != nil || mb.noselect {
+		return nil, nil, backend.ErrNoSuchMailbox
+	}
+	if err := mb.require("l"); err != nil {
+		return nil, nil, err
+	}
+	return u, mb, nil
+}
+
+// metadataRights returns the mailbox rights needed to read or change an entry
+func metadataRights(name string, write bool) string {
+	switch {
+	case service.IsPrivateMetadata(name):
+		return "l"
+	case write:
+		return "w"
+	}
+	return "r"
+}
+
+// metadataEntries returns the entries of a mailbox, or of the server for a
+// nil mailbox, that the user may read
+func (u *User) metadataEntries(mb *Mailbox) ([]*domain.MetadataEntry, error) {
+	var mailboxID int64
+	if mb != nil {
+		mailboxID = mb.mailbox.ID
+	}
+	stored, err := u.metadata.List(u.user.ID, mailboxID)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*domain.MetadataEntry
+	if mb != nil && !mb.shared && mb.mailbox.SpecialUse != "" {
+		entries = append(entries, &domain.MetadataEntry{
+			UserID:    u.user.ID,
+			MailboxID: mailboxID,
+			Name:      specialUseEntry,
+			Value:     []byte(mb.mailbox.SpecialUse),
+		})
+	}
+	for _, entry := range stored {
+		if entry.Name == specialUseEntry {
+			continue
+		}
+		if mb != nil && mb.require(metadataRights(entry.Name, false)) != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// metadataMailboxName formats the mailbox argument of a METADATA response
+func metadataMailboxName(name string) interface{} {
+	encoded, _ := utf7.Encoding.NewEncoder().String(name)
+	return imap.FormatMailboxName(encoded)
+}
+
+// metadataValue formats a value as a quoted string when it is printable
+// ASCII, and as a literal otherwise
+func metadataValue(value []byte) interface{} {
+	for _, c := range value {
+		if c < 0x20 || c > 0x7e {
+			return bytes.NewBuffer(value)
+		}
+	}
+	return string(value)
+}
+
+// getMetadata handles GETMETADATA (RFC 5464 section 4.2)
+type getMetadata struct {
+	mailbox string
+	names   []string
+	maxSize int // 0 is unlimited
+	depth   int // -1 is infinity
+}
+
+func (cmd *getMetadata) Parse(fields []interface{}) error {
+	if len(fields) > 0 {
+		if options, ok := fields[0].([]interface{}); ok {
+			if err := cmd.parseOptions(options); err != nil {
+				return err
+			}
+			fields = fields[1:]
+		}
+	}
+	if len(fields) != 2 {
+		return errors.New("wrong number of arguments")
+	}
+
+	mailbox, err := parseMailboxName(fields[0])
+	if err != nil {
+		return err
+	}
+	cmd.mailbox = mailbox
+
+	names, ok := fields[1].([]interface{})
+	if !ok {
+		names = fields[1:]
+	}
+	for _, f := range names {
+		name, err := imap.ParseString(f)
+		if err != nil {
+			return err
+		}
+		// The /private and /shared roots can be read with DEPTH, not set
+		if root := strings.ToLower(name); root == "/private" || root == "/shared" {
+			cmd.names = append(cmd.names, root)
+			continue
+		}
+		if name, err = service.NormalizeMetadataName(name); err != nil {
+			return err
+		}
+		cmd.names = append(cmd.names, name)
+	}
+	if len(cmd.names) == 0 {
+		return errors.New("missing entry names")
+	}
+	return nil
+}
+
+// parseOptions parses the MAXSIZE and DEPTH options (RFC 5464 sections 4.2.1 and 4.2.2)
+func (cmd *getMetadata) parseOptions(options []interface{}) error {
+	if len(options)%2 != 0 {
+		return errors.New("GETMETADATA options must be name and value pairs")
+	}
+	for i := 0; i < len(options); i += 2 {
+		name, ok := options[i].(string)
+		if !ok {
+			return errors.New("GETMETADATA option must be an atom")
+		}
+		switch strings.ToUpper(name) {
+		case "MAXSIZE":
+			size, err := imap.ParseNumber(options[i+1])
+			if err != nil {
+				return err
+			}
+			cmd.maxSize = int(size)
+		case "DEPTH":
+			depth, _ := options[i+1].(string)
+			switch strings.ToLower(depth) {
+			case "0":
+				cmd.depth = 0
+			case "1":
+				cmd.depth = 1
+			case "infinity":
+				cmd.depth = -1
+			default:
+				return errors.New("DEPTH must be 0, 1 or infinity")
+			}
+		default:
+			return errors.New("unsupported GETMETADATA option " + name)
+		}
+	}
+	return nil
+}
+
+// matches reports whether entry is requested name or, within depth, below it
+func (cmd *getMetadata) matches(name, entry string) bool {
+	if entry == name {
+		return true
+	}
+	rest, ok := strings.CutPrefix(entry, name+"/")
+	if !ok {
+		return false
+	}
+	return cmd.depth == -1 || (cmd.depth == 1 && !strings.Contains(rest, "/"))
+}
+
+func (cmd *getMetadata) Handle(conn server.Conn) error {
+	u, mb, err := metadataTarget(conn, cmd.mailbox)
+	if err != nil {
+		return err
+	}
+
+	entries, err := u.metadataEntries(mb)
+	if err != nil {
+		return err
+	}
+
+	var values []interface{}
+	longest := 0
+	for _, name := range cmd.names {
+		found := false
+		for _, entry := range entries {
+			if !cmd.matches(name, entry.Name) {
+				continue
+			}
+			if entry.Name == name {
+				found = true
+			}
+			if cmd.maxSize > 0 && len(entry.Value) > cmd.maxSize {
+				longest = max(longest, len(entry.Value))
+				continue
+			}
+			values = append(values, entry.Name, metadataValue(entry.Value))
+		}
+		// A requested entry without a value is returned as NIL
+		if !found && cmd.depth == 0 {
+			values = append(values, name, nil)
+		}
+	}
+
+	if len(values) > 0 {
+		if err := conn.WriteResp(imap.NewUntaggedResp([]interface{}{
+			imap.RawString("METADATA"), metadataMailboxName(cmd.mailbox), values,
+		})); err != nil {
+			return err
+		}
+	}
+
+	if longest > 0 {
+		return &imap.ErrStatusResp{Resp: &imap.StatusResp{
+			Type:      imap.StatusRespOk,
+			Code:      "METADATA",
+			Arguments: []interface{}{imap.RawString("LONGENTRIES"), uint32(longest)},
+			Info:      "GETMETADATA completed",
+		}}
+	}
+	return nil
+}
+
+// metadataValueArg is an entry and the value SETMETADATA gives it; nil removes it
+type metadataValueArg struct {
+	name  string
+	value []byte
+}
+
+// setMetadata handles SETMETADATA (RFC 5464 section 4.3)
+type setMetadata struct {
+	mailbox string
+	entries []metadataValueArg
+}
+
+func (cmd *setMetadata) Parse(fields []interface{}) error {
+	if len(fields) != 2 {
+		return errors.New("wrong number of arguments")
+	}
+
+	mailbox, err := parseMailboxName(fields[0])
+	if err != nil {
+		return err
+	}
+	cmd.mailbox = mailbox
+
+	list, ok := fields[1].([]interface{})
+	if !ok || len(list) == 0 || len(list)%2 != 0 {
+		return errors.New("entries must be a list of name and value pairs")
+	}
+	for i := 0; i < len(list); i += 2 {
+		name, err := imap.ParseString(list[i])
+		if err != nil {
+			return err
+		}
+		if name, err = service.NormalizeMetadataName(name); err != nil {
+			return err
+		}
+
+		arg := metadataValueArg{name: name}
+		switch value := list[i+1].(type) {
+		case nil:
+		case string:
+			arg.value = []byte(value)
+		case imap.Literal:
+			if arg.value, err = io.ReadAll(value); err != nil {
+				return err
+			}
+		default:
+			return errors.New("entry value must be a string, a literal or NIL")
+		}
+		cmd.entries = append(cmd.entries, arg)
+	}
+	return nil
+}
+
+func (cmd *setMetadata) Handle(conn server.Conn) error {
+	u, mb, err := metadataTarget(conn, cmd.mailbox)
+	if err != nil {
+		return err
+	}
+
+	// Check every entry before changing any
+	for _, entry := range cmd.entries {
+		if entry.name == specialUseEntry {
+			if mb == nil || mb.shared {
+				return useAttrError("special use can only be set on your own mailboxes")
+			}
+			continue
+		}
+		if mb != nil {
+			if err := mb.require(metadataRights(entry.name, true)); err != nil {
+				return err
+			}
+		}
+		if len(entry.value) > service.MaxMetadataSize {
+			return metadataError(service.ErrMetadataTooLarge)
+		}
+	}
+
+	var mailboxID int64
+	if mb != nil {
+		mailboxID = mb.mailbox.ID
+	}
+	for _, entry := range cmd.entries {
+		if entry.name == specialUseEntry {
+			if err := u.setSpecialUse(mb, entry.value); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := u.metadata.Set(u.user, mailboxID, entry.name, entry.value); err != nil {
+			return metadataError(err)
+		}
+	}
+	return nil
+}
+
+// setSpecialUse changes the special use of one of the user's mailboxes
+func (u *User) setSpecialUse(mb *Mailbox, value []byte) error {
+	use, err := service.NormalizeSpecialUse(strings.TrimSpace(string(value)))
+	if err != nil {
+		return useAttrError(err.Error())
+	}
+	if err := u.mailboxService.SetSpecialUse(mb.mailbox.ID, use); err != nil {
+		return err
+	}
+	mb.mailbox.SpecialUse = use
+	return nil
+}
+
+// metadataError turns metadata limit errors into NO [METADATA ...] responses
+func metadataError(err error) error {
+	var args []interface{}
+	switch {
+	case errors.Is(err, service.ErrMetadataTooLarge):
+		args = []interface{}{imap.RawString("MAXSIZE"), uint32(service.MaxMetadataSize)}
+	case errors.Is(err, service.ErrMetadataTooMany):
+		args = []interface{}{imap.RawString("TOOMANY")}
+	case errors.Is(err, service.ErrMetadataPermission):
+		return &imap.ErrStatusResp{Resp: &imap.StatusResp{Type: imap.StatusRespNo, Info: err.Error()}}
+	default:
+		return err
+	}
+	return &imap.ErrStatusResp{Resp: &imap.StatusResp{
+		Type:      imap.StatusRespNo,
+		Code:      "METADATA",
+		Arguments: args,
+		Info:      err.Error(),
+	}}
+}
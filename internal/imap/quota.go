@@ -0,0 +1,151 @@
+package imap
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/server"
+
+	"github.com/btafoya/gomailserver/internal/service"
+)
+
+// quotaRoot is the single quota root covering all of a user's own mailboxes
+const quotaRoot = ""
+
+// checkQuota refuses to store messages beyond the owner's quota with OVERQUOTA (RFC 9208 section 4.3)
+func (u *User) checkQuota(ownerID, size, messages int64) error {
+	if u.quota == nil {
+		return nil
+	}
+	err := u.quota.Check(ownerID, size, messages)
+	if errors.Is(err, service.ErrOverQuota) {
+		return &imap.ErrStatusResp{Resp: &imap.StatusResp{
+			Type: imap.StatusRespNo,
+			Code: "OVERQUOTA",
+			Info: "Quota exceeded",
+		}}
+	}
+	return err
+}
+
+// QuotaExtension adds the QUOTA commands (RFC 9208)
+// Every user has one quota root, "", with STORAGE and MESSAGE resources taken
+// from User.Quota and User.MessageQuota. Limits are set through the admin API,
+// so SETQUOTA is not offered.
+type QuotaExtension struct{}
+
+// Capabilities returns the QUOTA capability and its resources
+func (e *QuotaExtension) Capabilities(c server.Conn) []string {
+	return []string{"QUOTA", "QUOTA=RES-STORAGE", "QUOTA=RES-MESSAGE"}
+}
+
+// Command returns the handler for a QUOTA command
+func (e *QuotaExtension) Command(name string) server.HandlerFactory {
+	switch name {
+	case "GETQUOTA":
+		return func() server.Handler { return &getQuota{} }
+	case "GETQUOTAROOT":
+		return func() server.Handler { return &getQuotaRoot{} }
+	}
+	return nil
+}
+
+// quotaUser returns the session user of a QUOTA command
+func quotaUser(conn server.Conn) (*User, error) {
+	u, ok := conn.Context().User.(*User)
+	if !ok {
+		return nil, server.ErrNotAuthenticated
+	}
+	if u.quota == nil {
+		return nil, errors.New("quotas are not enabled")
+	}
+	return u, nil
+}
+
+// writeQuota writes the QUOTA response for the user's quota root
+// STORAGE is counted in units of 1024 octets; resources without a limit are omitted.
+func (u *User) writeQuota(conn server.Conn) error {
+	quota, err := u.quota.Get(u.user.ID)
+	if err != nil {
+		return err
+	}
+
+	var resources []interface{}
+	if quota.StorageLimit > 0 {
+		resources = append(resources, imap.RawString("STORAGE"), number64((quota.Storage+1023)/1024), number64(quota.StorageLimit/1024))
+	}
+	if quota.MessageLimit > 0 {
+		resources = append(resources, imap.RawString("MESSAGE"), number64(quota.Messages), number64(quota.MessageLimit))
+	}
+	return conn.WriteResp(imap.NewUntaggedResp([]interface{}{
+		imap.RawString("QUOTA"), quotaRoot, resources,
+	}))
+}
+
+// number64 formats a 63-bit number, which go-imap cannot write itself
+func number64(n int64) imap.RawString {
+	return imap.RawString(strconv.FormatInt(n, 10))
+}
+
+// getQuota handles GETQUOTA root (RFC 9208 section 4.2.1)
+type getQuota struct {
+	root string
+}
+
+func (cmd *getQuota) Parse(fields []interface{}) error {
+	if len(fields) != 1 {
+		return errors.New("wrong number of arguments")
+	}
+	root, err := imap.ParseString(fields[0])
+	cmd.root = root
+	return err
+}
+
+func (cmd *getQuota) Handle(conn server.Conn) error {
+	u, err := quotaUser(conn)
+	if err != nil {
+		return err
+	}
+	if cmd.root != quotaRoot {
+		return errors.New("no such quota root")
+	}
+	return u.writeQuota(conn)
+}
+
+// getQuotaRoot handles GETQUOTAROOT mailbox (RFC 9208 section 4.2.2)
+// A shared mailbox counts against its owner's quota, which is not reported
+// to other users, so it is listed without a quota root.
+type getQuotaRoot struct {
+	mailbox string
+}
+
+func (cmd *getQuotaRoot) Parse(fields []interface{}) error {
+	if len(fields) != 1 {
+		return errors.New("wrong number of arguments")
+	}
+	name, err := parseMailboxName(fields[0])
+	cmd.mailbox = name
+	return err
+}
+
+func (cmd *getQuotaRoot) Handle(conn server.Conn) error {
+	u, err := quotaUser(conn)
+	if err != nil {
+		return err
+	}
+	mb, err := u.lookupMailbox(cmd.mailbox)
+	if err != nil {
+		return backend.ErrNoSuchMailbox
+	}
+
+	fields := []interface{}{imap.RawString("QUOTAROOT"), formatMailboxName(cmd.mailbox)}
+	if mb.shared {
+		return conn.WriteResp(imap.NewUntaggedResp(fields))
+	}
+	if err := conn.WriteResp(imap.NewUntaggedResp(append(fields, quotaRoot))); err != nil {
+		return err
+	}
+	return u.writeQuota(conn)
+}
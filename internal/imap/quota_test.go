@@ -0,0 +1,79 @@
+package imap
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/service"
+)
+
+// quotaMessageRepository reports a fixed message count
+type quotaMessageRepository struct {
+	messages int64
+}
+
+func (m *quotaMessageRepository) Create(message *domain.Message) error { return nil }
+func (m *quotaMessageRepository) GetByID(id int64) (*domain.Message, error) {
+	return nil, errors.New("not found")
+}
+func (m *quotaMessageRepository) GetByMailbox(mailboxID int64, offset, limit int) ([]*domain.Message, error) {
+	return nil, nil
+}
+func (m *quotaMessageRepository) ListByMailbox(mailboxID int64) ([]*domain.Message, error) {
+	return nil, nil
+}
+func (m *quotaMessageRepository) Query(q *domain.MessageQuery) (*domain.MessageQueryResult, error) {
+	return &domain.MessageQueryResult{}, nil
+}
+func (m *quotaMessageRepository) Update(message *domain.Message) error       { return nil }
+func (m *quotaMessageRepository) UpdateFlags(id int64, flags string) error   { return nil }
+func (m *quotaMessageRepository) Move(id, mailboxID int64, uid uint32) error { return nil }
+func (m *quotaMessageRepository) Delete(id int64) error                      { return nil }
+func (m *quotaMessageRepository) Usage(userID int64) (int64, int64, error) {
+	return m.messages, 0, nil
+}
+func (m *quotaMessageRepository) SetBody(id int64, body *domain.MessageBody) error { return nil }
+func (m *quotaMessageRepository) ListWithoutBody(afterID int64, limit int) ([]int64, error) {
+	return nil, nil
+}
+func (m *quotaMessageRepository) UnreferencedBodies(limit int) ([]string, error) { return nil, nil }
+func (m *quotaMessageRepository) DeleteBody(hash string) (*domain.MessageBody, error) {
+	return nil, nil
+}
+
+func TestMailbox_CreateMessageQuota(t *testing.T) {
+	store := &aclStore{users: []*domain.User{
+		{ID: 1, Email: "user@example.com", Quota: 110, UsedQuota: 90, MessageQuota: 5},
+	}}
+	messages := &quotaMessageRepository{messages: 3}
+	user := &User{
+		user:           store.users[0],
+		mailboxService: &mockMailboxService{},
+		messageService: &mockMessageService{},
+		quota:          service.NewQuotaService(aclStoreUsers{store}, messages, zap.NewNop()),
+		logger:         zap.NewNop(),
+	}
+	mb := user.newMailbox(&domain.Mailbox{ID: 1, UserID: 1, Name: "INBOX"}, "INBOX", "user@example.com", service.AllRights)
+
+	if err := mb.CreateMessage(nil, time.Now(), bytes.NewBufferString("Subject: a\r\n\r\n")); err != nil {
+		t.Errorf("expected message within quota to be stored, got %v", err)
+	}
+
+	var statusErr *imap.ErrStatusResp
+	err := mb.CreateMessage(nil, time.Now(), bytes.NewBufferString("Subject: a larger message\r\n\r\n"))
+	if !errors.As(err, &statusErr) || statusErr.Resp.Code != "OVERQUOTA" {
+		t.Errorf("expected OVERQUOTA over the storage limit, got %v", err)
+	}
+
+	messages.messages = 5
+	err = mb.CreateMessage(nil, time.Now(), bytes.NewBufferString("Subject: a\r\n\r\n"))
+	if !errors.As(err, &statusErr) || statusErr.Resp.Code != "OVERQUOTA" {
+		t.Errorf("expected OVERQUOTA over the message limit, got %v", err)
+	}
+}
@@ -0,0 +1,240 @@
+package imap
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-imap/server"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+// SearchExtension adds ESEARCH (RFC 4731), SORT and THREAD (RFC 5256)
+// SORT and THREAD are ordered by the repository on the indexed message
+// columns: the stored subject and sender rather than the RFC 5256 base
+// subject and address mailbox, and the internal date for DATE as well as
+// ARRIVAL. Threads are grouped by the stored thread ID or subject.
+type SearchExtension struct{}
+
+// Capabilities returns the search capabilities and the supported thread algorithms
+func (e *SearchExtension) Capabilities(c server.Conn) []string {
+	return []string{"ESEARCH", "SORT", "THREAD=ORDEREDSUBJECT", "THREAD=REFERENCES"}
+}
+
+// Command returns the handler for a search command
+func (e *SearchExtension) Command(name string) server.HandlerFactory {
+	switch name {
+	case "SEARCH":
+		return func() server.Handler { return &esearch{} }
+	case "SORT":
+		return func() server.Handler { return &sortCmd{} }
+	case "THREAD":
+		return func() server.Handler { return &threadCmd{} }
+	}
+	return nil
+}
+
+// parseCharsetCriteria parses the mandatory charset and search criteria of SORT and THREAD
+func parseCharsetCriteria(fields []interface{}) (*imap.SearchCriteria, error) {
+	if len(fields) < 2 {
+		return nil, errors.New("missing charset or search criteria")
+	}
+	var search commands.Search
+	args := append([]interface{}{"CHARSET"}, fields...)
+	if err := search.Parse(args); err != nil {
+		return nil, err
+	}
+	return search.Criteria, nil
+}
+
+// searchIDs returns the UIDs or sequence numbers of messages
+func searchIDs(messages []seqMessage, uid bool) []uint32 {
+	ids := make([]uint32, 0, len(messages))
+	for _, sm := range messages {
+		if uid {
+			ids = append(ids, sm.UID)
+		} else {
+			ids = append(ids, sm.seqNum)
+		}
+	}
+	return ids
+}
+
+// esearch handles SEARCH, with RETURN options answered by ESEARCH (RFC 4731)
+// go-imap does not pass the command tag to handlers, so ESEARCH responses
+// are sent without a search correlator.
+type esearch struct {
+	commands.Search
+	extended bool
+	returns  map[string]bool
+}
+
+func (cmd *esearch) Parse(fields []interface{}) error {
+	if len(fields) > 0 {
+		if name, ok := fields[0].(string); ok && strings.EqualFold(name, "RETURN") {
+			if len(fields) < 2 {
+				return errors.New("missing RETURN options")
+			}
+			options, ok := fields[1].([]interface{})
+			if !ok {
+				return errors.New("RETURN options must be a list")
+			}
+
+			cmd.extended = true
+			cmd.returns = make(map[string]bool)
+			for _, option := range options {
+				name, ok := option.(string)
+				if !ok {
+					return errors.New("RETURN option must be an atom")
+				}
+				switch name = strings.ToUpper(name); name {
+				case "MIN", "MAX", "ALL", "COUNT":
+					cmd.returns[name] = true
+				default:
+					return errors.New("unsupported RETURN option " + name)
+				}
+			}
+			// RETURN () is the same as RETURN (ALL)
+			if len(cmd.returns) == 0 {
+				cmd.returns["ALL"] = true
+			}
+			fields = fields[2:]
+		}
+	}
+	return cmd.Search.Parse(fields)
+}
+
+func (cmd *esearch) handle(uid bool, conn server.Conn) error {
+	mb, err := selectedMailbox(conn, false)
+	if err != nil {
+		return err
+	}
+
+	ids, err := mb.SearchMessages(uid, cmd.Criteria)
+	if err != nil {
+		return err
+	}
+	if !cmd.extended {
+		return conn.WriteResp(&responses.Search{Ids: ids})
+	}
+	return conn.WriteResp(imap.NewUntaggedResp(esearchFields(uid, ids, cmd.returns)))
+}
+
+func (cmd *esearch) Handle(conn server.Conn) error {
+	return cmd.handle(false, conn)
+}
+
+func (cmd *esearch) UidHandle(conn server.Conn) error {
+	return cmd.handle(true, conn)
+}
+
+// esearchFields builds an ESEARCH response for ids, which are in ascending order
+// MIN, MAX and ALL are omitted when nothing matched (RFC 4731 section 3.1).
+func esearchFields(uid bool, ids []uint32, returns map[string]bool) []interface{} {
+	fields := []interface{}{imap.RawString("ESEARCH")}
+	if uid {
+		fields = append(fields, imap.RawString("UID"))
+	}
+	if len(ids) > 0 {
+		if returns["MIN"] {
+			fields = append(fields, imap.RawString("MIN"), ids[0])
+		}
+		if returns["MAX"] {
+			fields = append(fields, imap.RawString("MAX"), ids[len(ids)-1])
+		}
+		if returns["ALL"] {
+			set := new(imap.SeqSet)
+			set.AddNum(ids...)
+			fields = append(fields, imap.RawString("ALL"), set)
+		}
+	}
+	if returns["COUNT"] {
+		fields = append(fields, imap.RawString("COUNT"), uint32(len(ids)))
+	}
+	return fields
+}
+
+// sortKeys maps SORT criteria to the message sort keys (RFC 5256 section 3)
+var sortKeys = map[string]string{
+	"ARRIVAL": domain.MessageOrderArrival,
+	"CC":      domain.MessageOrderCc,
+	"DATE":    domain.MessageOrderArrival,
+	"FROM":    domain.MessageOrderFrom,
+	"SIZE":    domain.MessageOrderSize,
+	"SUBJECT": domain.MessageOrderSubject,
+	"TO":      domain.MessageOrderTo,
+}
+
+// sortCmd handles SORT and UID SORT
+type sortCmd struct {
+	order    []domain.MessageOrder
+	criteria *imap.SearchCriteria
+}
+
+func (cmd *sortCmd) Parse(fields []interface{}) error {
+	if len(fields) < 3 {
+		return errors.New("not enough arguments")
+	}
+	list, ok := fields[0].([]interface{})
+	if !ok || len(list) == 0 {
+		return errors.New("sort criteria must be a non-empty list")
+	}
+
+	reverse := false
+	for _, f := range list {
+		name, ok := f.(string)
+		if !ok {
+			return errors.New("sort criterion must be an atom")
+		}
+		name = strings.ToUpper(name)
+		if name == "REVERSE" {
+			reverse = true
+			continue
+		}
+		key, ok := sortKeys[name]
+		if !ok {
+			return errors.New("unsupported sort criterion " + name)
+		}
+		cmd.order = append(cmd.order, domain.MessageOrder{Key: key, Reverse: reverse})
+		reverse = false
+	}
+	if reverse {
+		return errors.New("REVERSE must precede a sort criterion")
+	}
+
+	criteria, err := parseCharsetCriteria(fields[1:])
+	cmd.criteria = criteria
+	return err
+}
+
+func (cmd *sortCmd) handle(uid bool, conn server.Conn) error {
+	mb, err := selectedMailbox(conn, false)
+	if err != nil {
+		return err
+	}
+	if err := mb.require("r"); err != nil {
+		return err
+	}
+
+	matches, err := mb.search(cmd.criteria, &domain.MessageQuery{Order: cmd.order})
+	if err != nil {
+		return err
+	}
+
+	fields := []interface{}{imap.RawString("SORT")}
+	for _, id := range searchIDs(matches, uid) {
+		fields = append(fields, id)
+	}
+	return conn.WriteResp(imap.NewUntaggedResp(fields))
+}
+
+func (cmd *sortCmd) Handle(conn server.Conn) error {
+	return cmd.handle(false, conn)
+}
+
+func (cmd *sortCmd) UidHandle(conn server.Conn) error {
+	return cmd.handle(true, conn)
+}
@@ -0,0 +1,94 @@
+package imap
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+func TestQueryCriteria(t *testing.T) {
+	uids, _ := imap.ParseSeqSet("2:*")
+	criteria := &imap.SearchCriteria{
+		Uid:       uids,
+		WithFlags: []string{imap.FlaggedFlag},
+		Larger:    100,
+		Header:    map[string][]string{"Subject": {"plans"}},
+		Not:       []*imap.SearchCriteria{{Smaller: 10}},
+	}
+
+	q := &domain.MessageQuery{}
+	rest := queryCriteria(criteria, q)
+	if len(q.UIDs) != 1 || q.UIDs[0] != (domain.MessageRange{Start: 2, Stop: 0}) {
+		t.Errorf("unexpected UID ranges %+v", q.UIDs)
+	}
+	if len(q.WithFlags) != 1 || q.Larger != 100 || q.Smaller != 0 {
+		t.Errorf("unexpected query %+v", q)
+	}
+	if rest.Uid != nil || rest.WithFlags != nil || rest.Larger != 0 || len(rest.Header) != 1 || len(rest.Not) != 1 {
+		t.Errorf("unexpected remaining criteria %+v", rest)
+	}
+	if criteria.Uid == nil || criteria.Larger != 100 {
+		t.Error("criteria were modified")
+	}
+}
+
+func TestSortCmd_Parse(t *testing.T) {
+	var cmd sortCmd
+	fields := []interface{}{[]interface{}{"REVERSE", "DATE", "subject"}, "UTF-8", "ALL"}
+	if err := cmd.Parse(fields); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	want := []domain.MessageOrder{
+		{Key: domain.MessageOrderArrival, Reverse: true},
+		{Key: domain.MessageOrderSubject},
+	}
+	if len(cmd.order) != len(want) || cmd.order[0] != want[0] || cmd.order[1] != want[1] {
+		t.Errorf("unexpected order %+v", cmd.order)
+	}
+
+	cmd = sortCmd{}
+	if err := cmd.Parse([]interface{}{[]interface{}{"SUBJECT", "REVERSE"}, "UTF-8", "ALL"}); err == nil {
+		t.Error("expected a trailing REVERSE to be rejected")
+	}
+}
+
+func TestGroupThreads(t *testing.T) {
+	message := func(seq uint32, thread, subject string) seqMessage {
+		return seqMessage{seqNum: seq, Message: &domain.Message{
+			ID:       int64(seq),
+			UID:      seq + 100,
+			ThreadID: thread,
+			Subject:  subject,
+		}}
+	}
+	// Ordered thread by thread, as the repository returns them
+	messages := []seqMessage{
+		message(1, "a", "Plans"),
+		message(2, "a", "Re: Plans"),
+		message(4, "a", "Re: Plans"),
+		message(3, "", "Lunch"),
+		message(5, "", "Lunch"),
+		message(6, "b", "Re: Lunch"),
+	}
+
+	if got := formatThreads(groupThreads(messages, domain.MessageThreadID), false); got != "(1 (2)(4))(3)(5)(6)" {
+		t.Errorf("unexpected thread ID threads %s", got)
+	}
+	if got := formatThreads(groupThreads(messages, domain.MessageThreadSubject), true); got != "(101)(102 104)(103 105)(106)" {
+		t.Errorf("unexpected subject threads %s", got)
+	}
+}
+
+func TestESearchFields(t *testing.T) {
+	fields := esearchFields(true, []uint32{2, 3, 4, 9}, map[string]bool{"MIN": true, "ALL": true, "COUNT": true})
+	if len(fields) != 8 || fields[3] != uint32(2) || fields[5].(interface{ String() string }).String() != "2:4,9" {
+		t.Errorf("unexpected ESEARCH fields %v", fields)
+	}
+
+	fields = esearchFields(false, nil, map[string]bool{"MIN": true, "COUNT": true})
+	if len(fields) != 3 || fields[2] != uint32(0) {
+		t.Errorf("expected only COUNT 0 without matches, got %v", fields)
+	}
+}
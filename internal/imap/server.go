@@ -8,10 +8,14 @@ import (
 	"sync"
 	"time"
 
+	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/server"
+	"github.com/emersion/go-sasl"
 	"go.uber.org/zap"
 
 	"github.com/btafoya/gomailserver/internal/config"
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/proxyproto"
 )
 
 // Server manages IMAP server instances
@@ -21,17 +25,20 @@ type Server struct {
 	backend  *Backend
 	cfg      *config.IMAPConfig
 	tlsCfg   *tls.Config
+	proxy    *proxyproto.Policy
 	logger   *zap.Logger
 	wg       sync.WaitGroup
 	cancel   context.CancelFunc
 }
 
 // NewServer creates a new IMAP server manager
-func NewServer(cfg *config.IMAPConfig, tlsCfg *tls.Config, backend *Backend, logger *zap.Logger) *Server {
+// proxy is used when proxy_protocol is enabled and may be nil otherwise.
+func NewServer(cfg *config.IMAPConfig, tlsCfg *tls.Config, proxy *proxyproto.Policy, backend *Backend, logger *zap.Logger) *Server {
 	s := &Server{
 		backend: backend,
 		cfg:     cfg,
 		tlsCfg:  tlsCfg,
+		proxy:   proxy,
 		logger:  logger,
 	}
 
@@ -47,6 +54,8 @@ func (s *Server) createIMAPServer() *server.Server {
 	srv.Addr = fmt.Sprintf(":%d", s.cfg.Port)
 	srv.AllowInsecureAuth = true // Allow LOGIN/PLAIN without TLS for testing
 	srv.AutoLogout = time.Duration(s.cfg.IdleTimeout) * time.Second
+	s.enableAuth(srv)
+	s.enableExtensions(srv)
 
 	// STARTTLS configuration
 	if s.tlsCfg != nil {
@@ -56,6 +65,60 @@ func (s *Server) createIMAPServer() *server.Server {
 	return srv
 }
 
+// listen binds a TCP address, reading PROXY headers first if enabled
+func (s *Server) listen(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg.ProxyProtocol && s.proxy != nil {
+		ln = s.proxy.Listen(ln)
+	}
+	if s.backend.sessions != nil {
+		ln = &countingListener{Listener: ln, sessions: s.backend.sessions}
+	}
+	return ln, nil
+}
+
+// enableAuth registers the shared SASL mechanisms for AUTHENTICATE
+func (s *Server) enableAuth(srv *server.Server) {
+	if s.backend.auth == nil {
+		return
+	}
+	for _, mech := range s.backend.auth.Mechanisms() {
+		srv.EnableAuth(mech, func(conn server.Conn) sasl.Server {
+			saslServer, _ := s.backend.auth.NewServer(mech, domain.AppPasswordScopeIMAP, func(username string, verify func() (*domain.User, error)) error {
+				user, err := s.backend.login(conn.Info(), mech, username, verify)
+				if err != nil {
+					return err
+				}
+
+				ctx := conn.Context()
+				ctx.State = imap.AuthenticatedState
+				ctx.User = user
+				return nil
+			})
+			return saslServer
+		})
+	}
+}
+
+// enableExtensions registers the IMAP extensions beyond go-imap's built-in set
+// LITERAL+ (RFC 7888) is built in, so APPEND needs no continuation round
+// trip; it is a superset of LITERAL- and clients limited to LITERAL- use it as is.
+func (s *Server) enableExtensions(srv *server.Server) {
+	srv.Enable(&ACLExtension{})
+	srv.Enable(&QuotaExtension{})
+	srv.Enable(&SearchExtension{})
+	srv.Enable(&CompressExtension{})
+	srv.Enable(&ListExtension{})
+	srv.Enable(&MetadataExtension{})
+
+	uidplus := &UIDPlusExtension{}
+	srv.Enable(uidplus)
+	uidplus.EnableMove()
+}
+
 // Start starts all IMAP servers
 func (s *Server) Start(ctx context.Context) error {
 	ctx, s.cancel = context.WithCancel(ctx)
@@ -67,8 +130,14 @@ func (s *Server) Start(ctx context.Context) error {
 		s.logger.Info("starting IMAP server",
 			zap.Int("port", s.cfg.Port),
 			zap.String("tls_mode", "STARTTLS"),
+			zap.Bool("proxy_protocol", s.cfg.ProxyProtocol),
 		)
-		if err := s.imap.ListenAndServe(); err != nil && ctx.Err() == nil {
+		ln, err := s.listen(s.imap.Addr)
+		if err != nil {
+			s.logger.Error("failed to start IMAP listener", zap.Error(err))
+			return
+		}
+		if err := s.imap.Serve(ln); err != nil && ctx.Err() == nil {
 			s.logger.Error("IMAP server error", zap.Error(err))
 		}
 	}()
@@ -83,7 +152,7 @@ func (s *Server) Start(ctx context.Context) error {
 				zap.String("tls_mode", "implicit"),
 			)
 
-			ln, err := net.Listen("tcp", fmt.Sprintf(":%d", s.cfg.IMAPSPort))
+			ln, err := s.listen(fmt.Sprintf(":%d", s.cfg.IMAPSPort))
 			if err != nil {
 				s.logger.Error("failed to start IMAPS listener", zap.Error(err))
 				return
@@ -94,6 +163,8 @@ func (s *Server) Start(ctx context.Context) error {
 			imapsServer := server.New(s.backend)
 			imapsServer.AllowInsecureAuth = true // Allow LOGIN/PLAIN for testing
 			imapsServer.AutoLogout = time.Duration(s.cfg.IdleTimeout) * time.Second
+			s.enableAuth(imapsServer)
+			s.enableExtensions(imapsServer)
 
 			if err := imapsServer.Serve(s.imaps); err != nil && ctx.Err() == nil {
 				s.logger.Error("IMAPS server error", zap.Error(err))
@@ -0,0 +1,111 @@
+package imap
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// sessionStats counts the bytes of one IMAP connection
+// in and out are counted on the wire, below TLS and compression; plainIn and
+// plainOut are the IMAP protocol bytes once COMPRESS is active.
+type sessionStats struct {
+	in, out           atomic.Int64
+	plainIn, plainOut atomic.Int64
+	compressed        atomic.Bool
+}
+
+// fields returns the counters as log fields
+func (s *sessionStats) fields() []zap.Field {
+	fields := []zap.Field{
+		zap.Int64("bytes_in", s.in.Load()),
+		zap.Int64("bytes_out", s.out.Load()),
+		zap.Bool("compressed", s.compressed.Load()),
+	}
+	if s.compressed.Load() {
+		fields = append(fields,
+			zap.Int64("uncompressed_bytes_in", s.plainIn.Load()),
+			zap.Int64("uncompressed_bytes_out", s.plainOut.Load()),
+		)
+	}
+	return fields
+}
+
+// sessionRegistry finds the counters of a live connection from its addresses,
+// which is all go-imap passes to the backend at login
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*sessionStats
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{sessions: make(map[string]*sessionStats)}
+}
+
+func sessionKey(local, remote net.Addr) string {
+	if local == nil || remote == nil {
+		return ""
+	}
+	return local.String() + "|" + remote.String()
+}
+
+// get returns the counters of the connection between local and remote, or nil
+func (r *sessionRegistry) get(local, remote net.Addr) *sessionStats {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sessions[sessionKey(local, remote)]
+}
+
+// countingListener counts the bytes of every accepted connection
+type countingListener struct {
+	net.Listener
+	sessions *sessionRegistry
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &countingConn{Conn: c, stats: &sessionStats{}, sessions: l.sessions, key: sessionKey(c.LocalAddr(), c.RemoteAddr())}
+	l.sessions.mu.Lock()
+	l.sessions.sessions[conn.key] = conn.stats
+	l.sessions.mu.Unlock()
+	return conn, nil
+}
+
+// countingConn counts the bytes read and written on a connection
+type countingConn struct {
+	net.Conn
+	stats    *sessionStats
+	sessions *sessionRegistry
+	key      string
+	once     sync.Once
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.stats.in.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.stats.out.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Close() error {
+	c.once.Do(func() {
+		c.sessions.mu.Lock()
+		delete(c.sessions.sessions, c.key)
+		c.sessions.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
@@ -0,0 +1,158 @@
+package imap
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/server"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+// threadAlgorithms maps THREAD algorithms to the message thread groupings
+// REFERENCES uses the thread ID stored from each message's references.
+var threadAlgorithms = map[string]string{
+	"ORDEREDSUBJECT": domain.MessageThreadSubject,
+	"REFERENCES":     domain.MessageThreadID,
+}
+
+// threadCmd handles THREAD and UID THREAD (RFC 5256)
+type threadCmd struct {
+	thread   string
+	criteria *imap.SearchCriteria
+}
+
+func (cmd *threadCmd) Parse(fields []interface{}) error {
+	if len(fields) < 3 {
+		return errors.New("not enough arguments")
+	}
+	algorithm, ok := fields[0].(string)
+	if !ok {
+		return errors.New("thread algorithm must be an atom")
+	}
+	if cmd.thread, ok = threadAlgorithms[strings.ToUpper(algorithm)]; !ok {
+		return errors.New("unsupported thread algorithm " + algorithm)
+	}
+
+	criteria, err := parseCharsetCriteria(fields[1:])
+	cmd.criteria = criteria
+	return err
+}
+
+func (cmd *threadCmd) handle(uid bool, conn server.Conn) error {
+	mb, err := selectedMailbox(conn, false)
+	if err != nil {
+		return err
+	}
+	if err := mb.require("r"); err != nil {
+		return err
+	}
+
+	matches, err := mb.search(cmd.criteria, &domain.MessageQuery{Thread: cmd.thread})
+	if err != nil {
+		return err
+	}
+	threads := groupThreads(matches, cmd.thread)
+
+	fields := []interface{}{imap.RawString("THREAD")}
+	if len(threads) > 0 {
+		fields = append(fields, imap.RawString(formatThreads(threads, uid)))
+	}
+	return conn.WriteResp(imap.NewUntaggedResp(fields))
+}
+
+func (cmd *threadCmd) Handle(conn server.Conn) error {
+	return cmd.handle(false, conn)
+}
+
+func (cmd *threadCmd) UidHandle(conn server.Conn) error {
+	return cmd.handle(true, conn)
+}
+
+// threadNode is a message in a thread tree
+type threadNode struct {
+	msg      *seqMessage
+	children []*threadNode
+}
+
+// groupThreads builds threads from messages ordered thread by thread, as the
+// repository returns them; a thread's first message is the parent of the rest
+func groupThreads(messages []seqMessage, thread string) []*threadNode {
+	var threads []*threadNode
+	var root *threadNode
+	var rootKey string
+	for i := range messages {
+		key := threadKey(messages[i].Message, thread)
+		node := &threadNode{msg: &messages[i]}
+		if root != nil && key == rootKey {
+			root.children = append(root.children, node)
+			continue
+		}
+		root, rootKey = node, key
+		threads = append(threads, node)
+	}
+	return threads
+}
+
+// threadKey returns the value grouping a message into a thread, matching
+// the repository's grouping
+func threadKey(msg *domain.Message, thread string) string {
+	if thread == domain.MessageThreadSubject {
+		return msg.Subject
+	}
+	if msg.ThreadID == "" {
+		// Messages without a thread ID each start their own thread
+		return "#" + strconv.FormatInt(msg.ID, 10)
+	}
+	return msg.ThreadID
+}
+
+// formatThreads formats threads as the THREAD response data (RFC 5256 section 4)
+func formatThreads(threads []*threadNode, uid bool) string {
+	var b strings.Builder
+	for _, n := range threads {
+		b.WriteByte('(')
+		writeThreadMembers(&b, n, uid)
+		b.WriteByte(')')
+	}
+	return b.String()
+}
+
+// writeThreadMembers writes a node and its descendants; a single child
+// continues the list, several children are each nested in parentheses
+func writeThreadMembers(b *strings.Builder, n *threadNode, uid bool) {
+	first := true
+	for {
+		if n.msg != nil {
+			if !first {
+				b.WriteByte(' ')
+			}
+			id := n.msg.seqNum
+			if uid {
+				id = n.msg.UID
+			}
+			b.WriteString(strconv.FormatUint(uint64(id), 10))
+			first = false
+		}
+
+		switch len(n.children) {
+		case 0:
+			return
+		case 1:
+			n = n.children[0]
+			continue
+		}
+
+		if !first {
+			b.WriteByte(' ')
+		}
+		for _, c := range n.children {
+			b.WriteByte('(')
+			writeThreadMembers(b, c, uid)
+			b.WriteByte(')')
+		}
+		return
+	}
+}
@@ -0,0 +1,258 @@
+package imap
+
+import (
+	"errors"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-imap/server"
+)
+
+// UIDPlusExtension adds UIDPLUS (RFC 4315) and the UIDPLUS responses to MOVE (RFC 6851)
+// APPEND, COPY and MOVE report the UIDs they assign, and UID EXPUNGE removes
+// only the given messages. UID variants are dispatched to these handlers by
+// go-imap's builtin UID command.
+type UIDPlusExtension struct {
+	move bool
+}
+
+// EnableMove offers the MOVE handler once the extension has been enabled
+// go-imap's Server.Enable skips extensions that provide MOVE, as MOVE is
+// built in, so the handler may only be returned after registration.
+func (e *UIDPlusExtension) EnableMove() {
+	e.move = true
+}
+
+// Capabilities returns the UIDPLUS capability; MOVE is advertised by go-imap
+func (e *UIDPlusExtension) Capabilities(c server.Conn) []string {
+	return []string{"UIDPLUS"}
+}
+
+// Command returns the handler for a command with UIDPLUS responses
+func (e *UIDPlusExtension) Command(name string) server.HandlerFactory {
+	switch name {
+	case "APPEND":
+		return func() server.Handler { return &appendUID{} }
+	case "COPY":
+		return func() server.Handler { return &copyUID{} }
+	case "EXPUNGE":
+		return func() server.Handler { return &expungeUID{} }
+	case "MOVE":
+		if e.move {
+			return func() server.Handler { return &moveUID{} }
+		}
+	}
+	return nil
+}
+
+// selectedMailbox returns the selected mailbox of a connection
+func selectedMailbox(conn server.Conn, write bool) (*Mailbox, error) {
+	ctx := conn.Context()
+	if ctx.Mailbox == nil {
+		return nil, server.ErrNoMailboxSelected
+	}
+	if write && ctx.MailboxReadOnly {
+		return nil, server.ErrMailboxReadOnly
+	}
+	mb, ok := ctx.Mailbox.(*Mailbox)
+	if !ok {
+		return nil, errors.New("unsupported mailbox")
+	}
+	return mb, nil
+}
+
+// copyUIDCode returns the COPYUID response code arguments (RFC 4315 section 3)
+func copyUIDCode(result *copyResult) []interface{} {
+	src, dst := new(imap.SeqSet), new(imap.SeqSet)
+	src.AddNum(result.srcUIDs...)
+	dst.AddNum(result.dstUIDs...)
+	return []interface{}{result.uidValidity, src, dst}
+}
+
+// writeExpunges sends EXPUNGE responses for seqNums, given in ascending order
+// They are sent from the last to the first so each number is still valid.
+func writeExpunges(conn server.Conn, seqNums []uint32) error {
+	for i := len(seqNums) - 1; i >= 0; i-- {
+		res := imap.NewUntaggedResp([]interface{}{seqNums[i], imap.RawString("EXPUNGE")})
+		if err := conn.WriteResp(res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendUID handles APPEND with an APPENDUID response code
+type appendUID struct {
+	commands.Append
+}
+
+func (cmd *appendUID) Handle(conn server.Conn) error {
+	ctx := conn.Context()
+	u, ok := ctx.User.(*User)
+	if !ok {
+		return server.ErrNotAuthenticated
+	}
+
+	mbox, err := u.GetMailbox(cmd.Mailbox)
+	if err == backend.ErrNoSuchMailbox {
+		return &imap.ErrStatusResp{Resp: &imap.StatusResp{
+			Type: imap.StatusRespNo,
+			Code: imap.CodeTryCreate,
+			Info: err.Error(),
+		}}
+	} else if err != nil {
+		return err
+	}
+	mb, ok := mbox.(*Mailbox)
+	if !ok {
+		return errors.New("unsupported mailbox")
+	}
+
+	uid, err := mb.appendMessage(cmd.Flags, cmd.Date, cmd.Message)
+	if err != nil {
+		return err
+	}
+
+	// Appending to the selected mailbox announces the new message
+	if ctx.Mailbox != nil && ctx.Mailbox.Name() == mb.Name() {
+		status, err := mb.Status([]imap.StatusItem{imap.StatusMessages})
+		if err != nil {
+			return err
+		}
+		status.Flags = nil
+		status.PermanentFlags = nil
+		status.UnseenSeqNum = 0
+		if err := conn.WriteResp(&responses.Select{Mailbox: status}); err != nil {
+			return err
+		}
+	}
+
+	return &imap.ErrStatusResp{Resp: &imap.StatusResp{
+		Type:      imap.StatusRespOk,
+		Code:      "APPENDUID",
+		Arguments: []interface{}{uint32(mb.mailbox.UIDValidity), uid},
+		Info:      "APPEND completed",
+	}}
+}
+
+// copyUID handles COPY and UID COPY with a COPYUID response code
+type copyUID struct {
+	commands.Copy
+}
+
+func (cmd *copyUID) handle(uid bool, conn server.Conn) error {
+	mb, err := selectedMailbox(conn, false)
+	if err != nil {
+		return err
+	}
+
+	result, err := mb.copyMessages(uid, cmd.SeqSet, cmd.Mailbox)
+	if err != nil {
+		return err
+	}
+	if len(result.srcUIDs) == 0 {
+		return nil
+	}
+
+	return &imap.ErrStatusResp{Resp: &imap.StatusResp{
+		Type:      imap.StatusRespOk,
+		Code:      "COPYUID",
+		Arguments: copyUIDCode(result),
+		Info:      "COPY completed",
+	}}
+}
+
+func (cmd *copyUID) Handle(conn server.Conn) error {
+	return cmd.handle(false, conn)
+}
+
+func (cmd *copyUID) UidHandle(conn server.Conn) error {
+	return cmd.handle(true, conn)
+}
+
+// moveUID handles MOVE and UID MOVE
+// The COPYUID code is sent in an untagged OK before the EXPUNGE responses
+// (RFC 6851 section 4.3).
+type moveUID struct {
+	commands.Move
+}
+
+func (cmd *moveUID) handle(uid bool, conn server.Conn) error {
+	mb, err := selectedMailbox(conn, true)
+	if err != nil {
+		return err
+	}
+
+	result, err := mb.moveMessages(uid, cmd.SeqSet, imap.CanonicalMailboxName(cmd.Mailbox))
+	if err != nil {
+		return err
+	}
+	if len(result.srcUIDs) == 0 {
+		return nil
+	}
+
+	if err := conn.WriteResp(&imap.StatusResp{
+		Type:      imap.StatusRespOk,
+		Code:      "COPYUID",
+		Arguments: copyUIDCode(result),
+		Info:      "Moved",
+	}); err != nil {
+		return err
+	}
+	return writeExpunges(conn, result.seqNums)
+}
+
+func (cmd *moveUID) Handle(conn server.Conn) error {
+	return cmd.handle(false, conn)
+}
+
+func (cmd *moveUID) UidHandle(conn server.Conn) error {
+	return cmd.handle(true, conn)
+}
+
+// expungeUID handles EXPUNGE and UID EXPUNGE (RFC 4315 section 2.1)
+type expungeUID struct {
+	uids *imap.SeqSet
+}
+
+func (cmd *expungeUID) Parse(fields []interface{}) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	set, ok := fields[0].(string)
+	if !ok {
+		return errors.New("invalid sequence set")
+	}
+	uids, err := imap.ParseSeqSet(set)
+	cmd.uids = uids
+	return err
+}
+
+func (cmd *expungeUID) handle(uids *imap.SeqSet, conn server.Conn) error {
+	mb, err := selectedMailbox(conn, true)
+	if err != nil {
+		return err
+	}
+
+	seqNums, err := mb.expunge(uids)
+	if err != nil {
+		return err
+	}
+	return writeExpunges(conn, seqNums)
+}
+
+func (cmd *expungeUID) Handle(conn server.Conn) error {
+	if cmd.uids != nil {
+		return errors.New("EXPUNGE takes no arguments")
+	}
+	return cmd.handle(nil, conn)
+}
+
+func (cmd *expungeUID) UidHandle(conn server.Conn) error {
+	if cmd.uids == nil {
+		return errors.New("UID EXPUNGE requires a sequence set")
+	}
+	return cmd.handle(cmd.uids, conn)
+}
@@ -0,0 +1,431 @@
+package jmap
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/emersion/go-message"
+	_ "github.com/emersion/go-message/charset"
+	"github.com/emersion/go-message/mail"
+)
+
+// bodyPart is a parsed MIME part, the EmailBodyPart of RFC 8621 section 4.1.4
+// Leaf parts are numbered in order; multipart containers have no part ID.
+type bodyPart struct {
+	partID      string
+	header      message.Header
+	contentType string
+	charset     string
+	disposition string
+	name        string
+	cid         string
+	language    []string
+	location    string
+	data        []byte // decoded content of a leaf part
+	badEncoding bool
+	subParts    []*bodyPart
+}
+
+// isMultipart reports whether the part is a multipart container
+func (p *bodyPart) isMultipart() bool {
+	return strings.HasPrefix(p.contentType, "multipart/")
+}
+
+var wordDecoder = &mime.WordDecoder{CharsetReader: message.CharsetReader}
+
+// decodeWords decodes RFC 2047 encoded words, keeping the input when it is invalid
+func decodeWords(s string) string {
+	if decoded, err := wordDecoder.DecodeHeader(s); err == nil {
+		return decoded
+	}
+	return s
+}
+
+// parseBody parses the MIME structure of a message
+func parseBody(content []byte) (*bodyPart, error) {
+	e, err := message.Read(bytes.NewReader(content))
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return nil, err
+	}
+	counter := 0
+	return parseEntity(e, &counter), nil
+}
+
+// parseEntity parses one entity and, for multiparts, its children
+func parseEntity(e *message.Entity, counter *int) *bodyPart {
+	p := &bodyPart{header: e.Header}
+
+	mediaType, params, err := e.Header.ContentType()
+	if err != nil || mediaType == "" {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	p.contentType = strings.ToLower(mediaType)
+	if strings.HasPrefix(p.contentType, "text/") {
+		p.charset = params["charset"]
+		if p.charset == "" {
+			p.charset = "us-ascii"
+		}
+	}
+
+	if disposition, dparams, err := e.Header.ContentDisposition(); err == nil {
+		p.disposition = strings.ToLower(disposition)
+		p.name = dparams["filename"]
+	}
+	if p.name == "" {
+		p.name = params["name"]
+	}
+	p.name = decodeWords(p.name)
+	p.cid = strings.Trim(strings.TrimSpace(e.Header.Get("Content-Id")), "<>")
+	if language := e.Header.Get("Content-Language"); language != "" {
+		for _, tag := range strings.Split(language, ",") {
+			p.language = append(p.language, strings.TrimSpace(tag))
+		}
+	}
+	p.location = e.Header.Get("Content-Location")
+
+	if mr := e.MultipartReader(); mr != nil {
+		for {
+			child, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+				break
+			}
+			p.subParts = append(p.subParts, parseEntity(child, counter))
+		}
+		return p
+	}
+
+	*counter++
+	p.partID = strconv.Itoa(*counter)
+	p.data, err = io.ReadAll(e.Body)
+	if err != nil {
+		p.badEncoding = true
+	}
+	return p
+}
+
+// walk calls fn for the part and every part below it
+func (p *bodyPart) walk(fn func(*bodyPart)) {
+	fn(p)
+	for _, sub := range p.subParts {
+		sub.walk(fn)
+	}
+}
+
+// findPart returns the leaf part of a message with a part ID
+func findPart(content []byte, partID string) (*bodyPart, error) {
+	root, err := parseBody(content)
+	if err != nil {
+		return nil, err
+	}
+	var found *bodyPart
+	root.walk(func(p *bodyPart) {
+		if p.partID == partID {
+			found = p
+		}
+	})
+	if found == nil {
+		return nil, errors.New("part not found")
+	}
+	return found, nil
+}
+
+// isInlineMediaType reports whether a part can be displayed inline in a body
+func isInlineMediaType(t string) bool {
+	return strings.HasPrefix(t, "image/") || strings.HasPrefix(t, "audio/") || strings.HasPrefix(t, "video/")
+}
+
+// bodyLists are the textBody, htmlBody and attachments of an email
+type bodyLists struct {
+	text, html, attachments []*bodyPart
+}
+
+// classify splits the parts of a message into text and HTML bodies and attachments
+func classify(root *bodyPart) *bodyLists {
+	lists := &bodyLists{}
+	text, html := []*bodyPart{}, []*bodyPart{}
+	parseStructure([]*bodyPart{root}, "mixed", false, &html, &text, &lists.attachments)
+	lists.text, lists.html = text, html
+	return lists
+}
+
+// parseStructure is the algorithm of RFC 8621 section 4.1.4; a nil htmlBody
+// or textBody pointer stands for null
+func parseStructure(parts []*bodyPart, multipartType string, inAlternative bool, htmlBody, textBody *[]*bodyPart, attachments *[]*bodyPart) {
+	textLength, htmlLength := -1, -1
+	if textBody != nil {
+		textLength = len(*textBody)
+	}
+	if htmlBody != nil {
+		htmlLength = len(*htmlBody)
+	}
+
+	for i, part := range parts {
+		isInline := part.disposition != "attachment" &&
+			(part.contentType == "text/plain" || part.contentType == "text/html" || isInlineMediaType(part.contentType)) &&
+			(i == 0 || (multipartType != "related" && (isInlineMediaType(part.contentType) || part.name == "")))
+
+		switch {
+		case part.isMultipart():
+			subType := strings.TrimPrefix(part.contentType, "multipart/")
+			parseStructure(part.subParts, subType, inAlternative || subType == "alternative", htmlBody, textBody, attachments)
+		case isInline:
+			if multipartType == "alternative" {
+				switch part.contentType {
+				case "text/plain":
+					if textBody != nil {
+						*textBody = append(*textBody, part)
+					}
+				case "text/html":
+					if htmlBody != nil {
+						*htmlBody = append(*htmlBody, part)
+					}
+				default:
+					*attachments = append(*attachments, part)
+				}
+				continue
+			}
+			text, html := textBody, htmlBody
+			if inAlternative {
+				if part.contentType == "text/plain" {
+					html = nil
+				}
+				if part.contentType == "text/html" {
+					text = nil
+				}
+			}
+			if text != nil {
+				*text = append(*text, part)
+			}
+			if html != nil {
+				*html = append(*html, part)
+			}
+			if (text == nil || html == nil) && isInlineMediaType(part.contentType) {
+				*attachments = append(*attachments, part)
+			}
+		default:
+			*attachments = append(*attachments, part)
+		}
+	}
+
+	if multipartType == "alternative" && textBody != nil && htmlBody != nil {
+		// Only an HTML part was found: it is also the text body
+		if textLength == len(*textBody) && htmlLength != len(*htmlBody) {
+			*textBody = append(*textBody, (*htmlBody)[htmlLength:]...)
+		}
+		// Only a plain text part was found: it is also the HTML body
+		if htmlLength == len(*htmlBody) && textLength != len(*textBody) {
+			*htmlBody = append(*htmlBody, (*textBody)[textLength:]...)
+		}
+	}
+}
+
+// defaultBodyProperties are the EmailBodyPart properties returned by default
+var defaultBodyProperties = []string{"partId", "blobId", "size", "name", "type", "charset", "disposition", "cid", "language", "location"}
+
+// object builds the JMAP representation of a part of message msgID
+func (p *bodyPart) object(msgID int64, properties []string) map[string]interface{} {
+	object := make(map[string]interface{}, len(properties))
+	for _, property := range properties {
+		switch property {
+		case "partId":
+			object[property] = nullString(p.partID)
+		case "blobId":
+			if p.partID == "" {
+				object[property] = nil
+			} else {
+				object[property] = messageBlobID(msgID, p.partID)
+			}
+		case "size":
+			object[property] = len(p.data)
+		case "name":
+			object[property] = nullString(p.name)
+		case "type":
+			object[property] = p.contentType
+		case "charset":
+			object[property] = nullString(p.charset)
+		case "disposition":
+			object[property] = nullString(p.disposition)
+		case "cid":
+			object[property] = nullString(p.cid)
+		case "language":
+			if p.language == nil {
+				object[property] = nil
+			} else {
+				object[property] = p.language
+			}
+		case "location":
+			object[property] = nullString(p.location)
+		case "headers":
+			object[property] = rawHeaders(p.header)
+		case "subParts":
+			if p.isMultipart() {
+				subParts := make([]map[string]interface{}, len(p.subParts))
+				for i, sub := range p.subParts {
+					subParts[i] = sub.object(msgID, properties)
+				}
+				object[property] = subParts
+			} else {
+				object[property] = nil
+			}
+		}
+	}
+	return object
+}
+
+// partObjects builds the JMAP representation of a list of parts
+func partObjects(parts []*bodyPart, msgID int64, properties []string) []map[string]interface{} {
+	objects := make([]map[string]interface{}, len(parts))
+	for i, p := range parts {
+		objects[i] = p.object(msgID, properties)
+	}
+	return objects
+}
+
+// nullString returns nil for an empty string
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// rawHeaders lists the header fields of a part in order (EmailHeader, RFC 8621 section 4.1.2)
+func rawHeaders(h message.Header) []map[string]string {
+	headers := make([]map[string]string, 0)
+	fields := h.Fields()
+	for fields.Next() {
+		raw, err := fields.Raw()
+		value := fields.Value()
+		if err == nil {
+			if _, v, ok := bytes.Cut(raw, []byte(":")); ok {
+				value = strings.TrimRight(string(v), "\r\n")
+			}
+		}
+		headers = append(headers, map[string]string{"name": fields.Key(), "value": value})
+	}
+	return headers
+}
+
+// messageBlobID returns the blob ID of a message, or of one of its parts
+func messageBlobID(msgID int64, partID string) string {
+	id := "M" + formatID(msgID)
+	if partID != "" {
+		id += "-" + partID
+	}
+	return id
+}
+
+// parseMessageBlobID splits a message blob ID into message and part IDs
+func parseMessageBlobID(blobID string) (int64, string, bool) {
+	if !strings.HasPrefix(blobID, "M") {
+		return 0, "", false
+	}
+	msg, part, _ := strings.Cut(blobID[1:], "-")
+	id, ok := parseID(msg)
+	return id, part, ok
+}
+
+// bodyValue is the decoded text of a part (EmailBodyValue, RFC 8621 section 4.1.4)
+type bodyValue struct {
+	Value             string `json:"value"`
+	IsEncodingProblem bool   `json:"isEncodingProblem"`
+	IsTruncated       bool   `json:"isTruncated"`
+}
+
+// textValue returns the text of a part, truncated to maxBytes when positive
+func (p *bodyPart) textValue(maxBytes int) *bodyValue {
+	value := &bodyValue{IsEncodingProblem: p.badEncoding}
+	data := p.data
+	if !utf8.Valid(data) {
+		value.IsEncodingProblem = true
+		data = bytes.ToValidUTF8(data, []byte("�"))
+	}
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	if maxBytes > 0 && len(text) > maxBytes {
+		cut := maxBytes
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = text[:cut]
+		value.IsTruncated = true
+	}
+	value.Value = text
+	return value
+}
+
+// previewLength is the length of an email preview in characters
+const previewLength = 256
+
+// preview returns the start of the body text with whitespace collapsed
+func preview(lists *bodyLists) string {
+	for _, p := range lists.text {
+		if !strings.HasPrefix(p.contentType, "text/") {
+			continue
+		}
+		text := p.textValue(0).Value
+		if p.contentType == "text/html" {
+			text = stripHTML(text)
+		}
+		return truncateRunes(strings.Join(strings.Fields(text), " "), previewLength)
+	}
+	return ""
+}
+
+// truncateRunes shortens s to at most n characters
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:n])
+}
+
+// stripHTML reduces HTML to its text, dropping tags, scripts and styles
+func stripHTML(s string) string {
+	var b strings.Builder
+	lower := strings.ToLower(s)
+	for i := 0; i < len(s); {
+		switch {
+		case strings.HasPrefix(lower[i:], "<script"), strings.HasPrefix(lower[i:], "<style"):
+			tag := "</script>"
+			if strings.HasPrefix(lower[i:], "<style") {
+				tag = "</style>"
+			}
+			end := strings.Index(lower[i:], tag)
+			if end < 0 {
+				return b.String()
+			}
+			i += end + len(tag)
+		case s[i] == '<':
+			end := strings.IndexByte(s[i:], '>')
+			if end < 0 {
+				return b.String()
+			}
+			b.WriteByte(' ')
+			i += end + 1
+		default:
+			b.WriteByte(s[i])
+			i++
+		}
+	}
+	return htmlUnescaper.Replace(b.String())
+}
+
+var htmlUnescaper = strings.NewReplacer("&nbsp;", " ", "&lt;", "<", "&gt;", ">", "&quot;", `"`, "&#39;", "'", "&amp;", "&")
+
+// emailAddresses converts parsed addresses to EmailAddress objects
+func emailAddresses(addresses []*mail.Address) []map[string]interface{} {
+	result := make([]map[string]interface{}, len(addresses))
+	for i, a := range addresses {
+		result[i] = map[string]interface{}{"name": nullString(a.Name), "email": a.Address}
+	}
+	return result
+}
@@ -0,0 +1,222 @@
+package jmap
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+const alternativeMessage = "From: Alice <alice@example.com>\r\n" +
+	"To: bob@example.com\r\n" +
+	"Subject: =?UTF-8?Q?Gr=C3=BC=C3=9Fe?=\r\n" +
+	"Message-ID: <abc@example.com>\r\n" +
+	"Date: Mon, 02 Jan 2006 15:04:05 -0700\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Hello   Bob,\r\nsee attached.\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>Hello <b>Bob</b>, see attached.</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf; name=report.pdf\r\n" +
+	"Content-Disposition: attachment; filename=report.pdf\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0=\r\n" +
+	"--outer--\r\n"
+
+func testMessage(content string) *domain.Message {
+	return &domain.Message{ID: 7, UserID: 1, MailboxID: 2, ThreadID: "abc@example.com", Content: []byte(content), Size: int64(len(content))}
+}
+
+func partIDs(parts []*bodyPart) []string {
+	ids := make([]string, len(parts))
+	for i, p := range parts {
+		ids[i] = p.partID
+	}
+	return ids
+}
+
+func TestClassify(t *testing.T) {
+	root, err := parseBody([]byte(alternativeMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lists := classify(root)
+
+	if got := partIDs(lists.text); !reflect.DeepEqual(got, []string{"1"}) {
+		t.Errorf("textBody = %v", got)
+	}
+	if got := partIDs(lists.html); !reflect.DeepEqual(got, []string{"2"}) {
+		t.Errorf("htmlBody = %v", got)
+	}
+	if got := partIDs(lists.attachments); !reflect.DeepEqual(got, []string{"3"}) {
+		t.Errorf("attachments = %v", got)
+	}
+	if lists.attachments[0].name != "report.pdf" || string(lists.attachments[0].data) != "%PDF-" {
+		t.Errorf("unexpected attachment: %q %q", lists.attachments[0].name, lists.attachments[0].data)
+	}
+	if got := preview(lists); got != "Hello Bob, see attached." {
+		t.Errorf("preview = %q", got)
+	}
+
+	// A plain text message is both the text and the HTML body
+	root, _ = parseBody([]byte("Subject: hi\r\n\r\nJust text\r\n"))
+	lists = classify(root)
+	if len(lists.text) != 1 || len(lists.html) != 1 || len(lists.attachments) != 0 {
+		t.Errorf("unexpected lists for a single part: %+v", lists)
+	}
+}
+
+func TestFindPart(t *testing.T) {
+	part, err := findPart([]byte(alternativeMessage), "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if part.contentType != "text/html" {
+		t.Errorf("part 2 is %s", part.contentType)
+	}
+	if _, err := findPart([]byte(alternativeMessage), "9"); err == nil {
+		t.Error("expected a missing part to fail")
+	}
+
+	id, partID, ok := parseMessageBlobID(messageBlobID(42, "3"))
+	if !ok || id != 42 || partID != "3" {
+		t.Errorf("blob ID round trip: %d %q %v", id, partID, ok)
+	}
+	if _, _, ok := parseMessageBlobID("U123"); ok {
+		t.Error("expected an upload blob ID to be rejected")
+	}
+}
+
+func TestTextValue(t *testing.T) {
+	p := &bodyPart{data: []byte("h\xc3\xa9llo\r\nworld")}
+	value := p.textValue(2)
+	if value.Value != "h" || !value.IsTruncated {
+		t.Errorf("truncation should not split a character: %+v", value)
+	}
+	if value := p.textValue(0); value.Value != "héllo\nworld" || value.IsTruncated {
+		t.Errorf("unexpected value: %+v", value)
+	}
+
+	bad := &bodyPart{data: []byte("bad \xff byte")}
+	if value := bad.textValue(0); !value.IsEncodingProblem {
+		t.Error("expected an encoding problem")
+	}
+}
+
+func TestEmailObjectHeaders(t *testing.T) {
+	properties := []string{"subject", "from", "messageId", "sentAt", "header:Subject", "header:To:asAddresses:all"}
+	object, err := emailObject(testMessage(alternativeMessage), &emailOptions{properties: properties})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object["subject"] != "Grüße" {
+		t.Errorf("subject = %v", object["subject"])
+	}
+	from := object["from"].([]map[string]interface{})
+	if len(from) != 1 || from[0]["name"] != "Alice" || from[0]["email"] != "alice@example.com" {
+		t.Errorf("from = %v", from)
+	}
+	if !reflect.DeepEqual(object["messageId"], []string{"abc@example.com"}) {
+		t.Errorf("messageId = %v", object["messageId"])
+	}
+	if object["sentAt"] != "2006-01-02T15:04:05-07:00" {
+		t.Errorf("sentAt = %v", object["sentAt"])
+	}
+	if object["header:Subject"] != " =?UTF-8?Q?Gr=C3=BC=C3=9Fe?=" {
+		t.Errorf("raw subject = %q", object["header:Subject"])
+	}
+	if all := object["header:To:asAddresses:all"].([]interface{}); len(all) != 1 {
+		t.Errorf("To fields = %v", all)
+	}
+}
+
+func TestKeywords(t *testing.T) {
+	keywords := keywordsOf(`\Seen \Deleted $Forwarded \Flagged`)
+	want := map[string]bool{"$seen": true, "$forwarded": true, "$flagged": true}
+	if !reflect.DeepEqual(keywords, want) {
+		t.Errorf("keywordsOf = %v", keywords)
+	}
+
+	flags := flagsOf(map[string]bool{"$seen": true, "work": true}, `\Seen \Deleted \Flagged`)
+	if got := strings.Join(flags, " "); got != `\Deleted \Seen work` {
+		t.Errorf("flagsOf = %q", got)
+	}
+
+	if validKeyword("a b") || validKeyword(`a\b`) || validKeyword("") || !validKeyword("$junk") {
+		t.Error("unexpected keyword validation")
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	got := highlight("Tom & Jerry meet TOM", []string{"tom"})
+	if got != "<mark>Tom</mark> &amp; Jerry meet <mark>TOM</mark>" {
+		t.Errorf("highlight = %v", got)
+	}
+	if highlight("nothing here", []string{"tom"}) != nil {
+		t.Error("expected no snippet without a match")
+	}
+}
+
+func TestBuildEmail(t *testing.T) {
+	c := &call{user: &domain.User{ID: 1, Email: "alice@example.com"}}
+	name := "Alice"
+	subject := "Grüße"
+	text, html := "t", "h"
+	e := &emailCreate{
+		From:       []emailAddress{{Name: &name, Email: "alice@example.com"}},
+		To:         []emailAddress{{Email: "bob@example.com"}},
+		Subject:    &subject,
+		InReplyTo:  []string{"parent@example.com"},
+		TextBody:   []bodyPartCreate{{PartID: &text}},
+		HTMLBody:   []bodyPartCreate{{PartID: &html, Type: "text/html"}},
+		BodyValues: map[string]*bodyValue{"t": {Value: "Hi Bob\n"}, "h": {Value: "<p>Hi Bob</p>"}},
+	}
+	data, setErr, err := c.buildEmail(e)
+	if err != nil || setErr != nil {
+		t.Fatalf("buildEmail: %v %v", setErr, err)
+	}
+
+	properties := []string{"subject", "to", "inReplyTo", "messageId", "textBody", "htmlBody", "bodyValues"}
+	object, err := emailObject(testMessage(string(data)), &emailOptions{
+		properties:     properties,
+		bodyProperties: []string{"type"},
+		fetchAll:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object["subject"] != subject {
+		t.Errorf("subject = %v", object["subject"])
+	}
+	if !reflect.DeepEqual(object["inReplyTo"], []string{"parent@example.com"}) {
+		t.Errorf("inReplyTo = %v", object["inReplyTo"])
+	}
+	if ids, ok := object["messageId"].([]string); !ok || !strings.HasSuffix(ids[0], "@example.com") {
+		t.Errorf("messageId = %v", object["messageId"])
+	}
+	values := object["bodyValues"].(map[string]*bodyValue)
+	if values["1"].Value != "Hi Bob\n" || values["2"].Value != "<p>Hi Bob</p>" {
+		t.Errorf("bodyValues = %+v %+v", values["1"], values["2"])
+	}
+
+	e.TextBody = []bodyPartCreate{{PartID: strp("missing")}}
+	if _, setErr, _ := c.buildEmail(e); setErr == nil || setErr.Type != "invalidProperties" {
+		t.Errorf("expected a missing body value to be rejected, got %v", setErr)
+	}
+}
+
+func strp(s string) *string {
+	return &s
+}
@@ -0,0 +1,314 @@
+package jmap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/service"
+)
+
+// call is the context of one method call
+type call struct {
+	ctx       context.Context
+	h         *Handler
+	user      *domain.User
+	accountID string
+	created   map[string]string // creation IDs of the request mapped to object IDs
+	callID    string
+	extra     []Invocation // implicit responses following the method's own
+}
+
+// decode decodes method arguments, rejecting arguments for another account
+func (c *call) decode(args json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(args, v); err != nil {
+		return errInvalidArguments("%v", err)
+	}
+	var account struct {
+		AccountID *string `json:"accountId"`
+	}
+	json.Unmarshal(args, &account)
+	if account.AccountID != nil && *account.AccountID != c.accountID {
+		return errAccountNotFound
+	}
+	return nil
+}
+
+// resolveID returns the object ID for an ID or a "#"-prefixed creation ID
+func (c *call) resolveID(id string) (string, bool) {
+	if !strings.HasPrefix(id, "#") {
+		return id, true
+	}
+	resolved, ok := c.created[id[1:]]
+	return resolved, ok
+}
+
+// state returns the current state of a data type
+func (c *call) state(changeType string) (string, error) {
+	return c.h.changes.State(c.user.ID, changeType)
+}
+
+// getArgs are the arguments of every /get method (RFC 8620 section 5.1)
+type getArgs struct {
+	AccountID  string    `json:"accountId"`
+	IDs        *[]string `json:"ids"`
+	Properties *[]string `json:"properties"`
+}
+
+// getResponse is the response of every /get method
+type getResponse struct {
+	AccountID string                   `json:"accountId"`
+	State     string                   `json:"state"`
+	List      []map[string]interface{} `json:"list"`
+	NotFound  []string                 `json:"notFound"`
+}
+
+// ids returns the requested IDs, or nil for all objects
+func (a *getArgs) ids() []string {
+	if a.IDs == nil {
+		return nil
+	}
+	return *a.IDs
+}
+
+// tooMany reports whether more objects are requested than maxObjectsInGet
+func (a *getArgs) tooMany() bool {
+	return a.IDs != nil && len(*a.IDs) > maxObjectsInGet
+}
+
+// project keeps the requested properties of an object; id is always kept
+func project(object map[string]interface{}, properties *[]string) map[string]interface{} {
+	if properties == nil {
+		return object
+	}
+	result := map[string]interface{}{"id": object["id"]}
+	for _, p := range *properties {
+		if v, ok := object[p]; ok {
+			result[p] = v
+		}
+	}
+	return result
+}
+
+// changesArgs are the arguments of every /changes method (RFC 8620 section 5.2)
+type changesArgs struct {
+	AccountID  string `json:"accountId"`
+	SinceState string `json:"sinceState"`
+	MaxChanges *int   `json:"maxChanges"`
+}
+
+// changesResponse is the response of every /changes method
+type changesResponse struct {
+	AccountID      string   `json:"accountId"`
+	OldState       string   `json:"oldState"`
+	NewState       string   `json:"newState"`
+	HasMoreChanges bool     `json:"hasMoreChanges"`
+	Created        []string `json:"created"`
+	Updated        []string `json:"updated"`
+	Destroyed      []string `json:"destroyed"`
+}
+
+// changes answers a /changes call from the change log
+// objectID maps change log object IDs to JMAP IDs.
+func (c *call) changes(args json.RawMessage, changeType string, objectID func(string) string) (*changesResponse, error) {
+	var a changesArgs
+	if err := c.decode(args, &a); err != nil {
+		return nil, err
+	}
+	maxChanges := maxObjectsInGet
+	if a.MaxChanges != nil {
+		if *a.MaxChanges <= 0 {
+			return nil, errInvalidArguments("maxChanges must be positive")
+		}
+		if *a.MaxChanges < maxChanges {
+			maxChanges = *a.MaxChanges
+		}
+	}
+
+	set, err := c.h.changes.Changes(c.user.ID, changeType, a.SinceState, maxChanges)
+	if errors.Is(err, service.ErrCannotCalculateChanges) {
+		return nil, errCannotCalculateChanges
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mapIDs := func(ids []string) []string {
+		result := make([]string, len(ids))
+		for i, id := range ids {
+			result[i] = objectID(id)
+		}
+		return result
+	}
+	return &changesResponse{
+		AccountID:      c.accountID,
+		OldState:       set.OldState,
+		NewState:       set.NewState,
+		HasMoreChanges: set.HasMore,
+		Created:        mapIDs(set.Created),
+		Updated:        mapIDs(set.Updated),
+		Destroyed:      mapIDs(set.Destroyed),
+	}, nil
+}
+
+// sameID keeps change log object IDs as they are
+func sameID(id string) string {
+	return id
+}
+
+// setArgs are the arguments of every /set method (RFC 8620 section 5.3)
+type setArgs struct {
+	AccountID string                                `json:"accountId"`
+	IfInState *string                               `json:"ifInState"`
+	Create    map[string]json.RawMessage            `json:"create"`
+	Update    map[string]map[string]json.RawMessage `json:"update"`
+	Destroy   []string                              `json:"destroy"`
+}
+
+// tooMany reports whether more objects are changed than maxObjectsInSet
+func (a *setArgs) tooMany() bool {
+	return len(a.Create)+len(a.Update)+len(a.Destroy) > maxObjectsInSet
+}
+
+// setResponse is the response of every /set method
+type setResponse struct {
+	AccountID    string                            `json:"accountId"`
+	OldState     string                            `json:"oldState"`
+	NewState     string                            `json:"newState"`
+	Created      map[string]map[string]interface{} `json:"created"`
+	Updated      map[string]interface{}            `json:"updated"`
+	Destroyed    []string                          `json:"destroyed"`
+	NotCreated   map[string]*SetError              `json:"notCreated"`
+	NotUpdated   map[string]*SetError              `json:"notUpdated"`
+	NotDestroyed map[string]*SetError              `json:"notDestroyed"`
+}
+
+// newSetResponse checks ifInState and starts the response of a /set call
+func (c *call) newSetResponse(a *setArgs, changeType string) (*setResponse, error) {
+	if a.tooMany() {
+		return nil, errRequestTooLarge
+	}
+	state, err := c.state(changeType)
+	if err != nil {
+		return nil, err
+	}
+	if a.IfInState != nil && *a.IfInState != state {
+		return nil, errStateMismatch
+	}
+	return &setResponse{
+		AccountID:    c.accountID,
+		OldState:     state,
+		Created:      make(map[string]map[string]interface{}),
+		Updated:      make(map[string]interface{}),
+		Destroyed:    make([]string, 0),
+		NotCreated:   make(map[string]*SetError),
+		NotUpdated:   make(map[string]*SetError),
+		NotDestroyed: make(map[string]*SetError),
+	}, nil
+}
+
+// finish sets the new state of a /set response
+func (c *call) finish(resp *setResponse, changeType string) (*setResponse, error) {
+	state, err := c.state(changeType)
+	if err != nil {
+		return nil, err
+	}
+	resp.NewState = state
+	return resp, nil
+}
+
+// queryArgs holds the paging arguments of every /query method (RFC 8620 section 5.5)
+type queryArgs struct {
+	AccountID      string  `json:"accountId"`
+	Position       int     `json:"position"`
+	Anchor         *string `json:"anchor"`
+	AnchorOffset   int     `json:"anchorOffset"`
+	Limit          *int    `json:"limit"`
+	CalculateTotal bool    `json:"calculateTotal"`
+}
+
+// queryResponse is the response of every /query method
+type queryResponse struct {
+	AccountID           string   `json:"accountId"`
+	QueryState          string   `json:"queryState"`
+	CanCalculateChanges bool     `json:"canCalculateChanges"`
+	Position            int      `json:"position"`
+	IDs                 []string `json:"ids"`
+	Total               *int     `json:"total,omitempty"`
+	Limit               *int     `json:"limit,omitempty"`
+}
+
+// page selects the window of ids the query arguments ask for
+func (a *queryArgs) page(ids []string, queryState, account string) (*queryResponse, error) {
+	if a.Limit != nil && *a.Limit < 0 {
+		return nil, errInvalidArguments("limit must not be negative")
+	}
+
+	position := a.Position
+	if a.Anchor != nil {
+		index := -1
+		for i, id := range ids {
+			if id == *a.Anchor {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil, errAnchorNotFound
+		}
+		position = index + a.AnchorOffset
+		if position < 0 {
+			position = 0
+		}
+	} else if position < 0 {
+		position += len(ids)
+		if position < 0 {
+			position = 0
+		}
+	}
+	if position > len(ids) {
+		position = len(ids)
+	}
+
+	end := len(ids)
+	var limit *int
+	if a.Limit != nil && position+*a.Limit < end {
+		end = position + *a.Limit
+	}
+	if end-position > maxObjectsInGet {
+		end = position + maxObjectsInGet
+		capped := maxObjectsInGet
+		limit = &capped
+	}
+
+	resp := &queryResponse{
+		AccountID:  account,
+		QueryState: queryState,
+		Position:   position,
+		IDs:        append([]string{}, ids[position:end]...),
+		Limit:      limit,
+	}
+	if a.CalculateTotal {
+		total := len(ids)
+		resp.Total = &total
+	}
+	return resp, nil
+}
+
+// queryChanges answers every /queryChanges call; query results are not tracked
+func queryChanges(c *call, args json.RawMessage) (interface{}, error) {
+	var a struct {
+		AccountID string `json:"accountId"`
+	}
+	if err := c.decode(args, &a); err != nil {
+		return nil, err
+	}
+	return nil, errCannotCalculateChanges
+}
+
+// coreEcho returns its arguments (RFC 8620 section 4)
+func coreEcho(c *call, args json.RawMessage) (interface{}, error) {
+	return args, nil
+}
@@ -0,0 +1,259 @@
+// Package jmap implements the JSON Meta Application Protocol for mail
+// (RFC 8620, RFC 8621) on top of the message, mailbox and submission services.
+package jmap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Capabilities supported by the server
+const (
+	CapabilityCore       = "urn:ietf:params:jmap:core"
+	CapabilityMail       = "urn:ietf:params:jmap:mail"
+	CapabilitySubmission = "urn:ietf:params:jmap:submission"
+)
+
+// Limits advertised in the core capability (RFC 8620 section 2)
+const (
+	maxSizeUpload         = 50 * 1024 * 1024
+	maxConcurrentUpload   = 4
+	maxSizeRequest        = 10 * 1024 * 1024
+	maxConcurrentRequests = 4
+	maxCallsInRequest     = 32
+	maxObjectsInGet       = 500
+	maxObjectsInSet       = 500
+)
+
+// Invocation is a method call or response: [name, arguments, method call id]
+type Invocation struct {
+	Name   string
+	Args   json.RawMessage
+	CallID string
+}
+
+// MarshalJSON encodes an invocation as a three-element array
+func (inv Invocation) MarshalJSON() ([]byte, error) {
+	args := inv.Args
+	if args == nil {
+		args = json.RawMessage("{}")
+	}
+	return json.Marshal([]interface{}{inv.Name, args, inv.CallID})
+}
+
+// UnmarshalJSON decodes an invocation from a three-element array
+func (inv *Invocation) UnmarshalJSON(data []byte) error {
+	var parts []json.RawMessage
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return err
+	}
+	if len(parts) != 3 {
+		return errors.New("invocation must have 3 elements")
+	}
+	if err := json.Unmarshal(parts[0], &inv.Name); err != nil {
+		return fmt.Errorf("invalid method name: %w", err)
+	}
+	if len(parts[1]) == 0 || parts[1][0] != '{' {
+		return errors.New("method arguments must be an object")
+	}
+	inv.Args = parts[1]
+	if err := json.Unmarshal(parts[2], &inv.CallID); err != nil {
+		return fmt.Errorf("invalid method call id: %w", err)
+	}
+	return nil
+}
+
+// Request is the body of an API request (RFC 8620 section 3.3)
+type Request struct {
+	Using       []string          `json:"using"`
+	MethodCalls []Invocation      `json:"methodCalls"`
+	CreatedIDs  map[string]string `json:"createdIds,omitempty"`
+}
+
+// Response is the body of an API response (RFC 8620 section 3.4)
+type Response struct {
+	MethodResponses []Invocation      `json:"methodResponses"`
+	CreatedIDs      map[string]string `json:"createdIds,omitempty"`
+	SessionState    string            `json:"sessionState"`
+}
+
+// MethodError is an error response to a single method call (RFC 8620 section 3.6.2)
+type MethodError struct {
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+}
+
+// Error implements the error interface
+func (e *MethodError) Error() string {
+	if e.Description == "" {
+		return e.Type
+	}
+	return e.Type + ": " + e.Description
+}
+
+func errInvalidArguments(format string, args ...interface{}) *MethodError {
+	return &MethodError{Type: "invalidArguments", Description: fmt.Sprintf(format, args...)}
+}
+
+var (
+	errUnknownMethod          = &MethodError{Type: "unknownMethod"}
+	errInvalidResultReference = &MethodError{Type: "invalidResultReference"}
+	errAccountNotFound        = &MethodError{Type: "accountNotFound"}
+	errCannotCalculateChanges = &MethodError{Type: "cannotCalculateChanges"}
+	errStateMismatch          = &MethodError{Type: "stateMismatch"}
+	errUnsupportedFilter      = &MethodError{Type: "unsupportedFilter"}
+	errUnsupportedSort        = &MethodError{Type: "unsupportedSort"}
+	errAnchorNotFound         = &MethodError{Type: "anchorNotFound"}
+	errRequestTooLarge        = &MethodError{Type: "requestTooLarge"}
+	errServerFail             = &MethodError{Type: "serverFail"}
+)
+
+// SetError explains why one object could not be created, updated or destroyed (RFC 8620 section 5.3)
+type SetError struct {
+	Type        string   `json:"type"`
+	Description string   `json:"description,omitempty"`
+	Properties  []string `json:"properties,omitempty"`
+	NotFound    []string `json:"notFound,omitempty"`
+}
+
+func setError(errType, format string, args ...interface{}) *SetError {
+	return &SetError{Type: errType, Description: fmt.Sprintf(format, args...)}
+}
+
+func invalidProperties(description string, properties ...string) *SetError {
+	return &SetError{Type: "invalidProperties", Description: description, Properties: properties}
+}
+
+// ResultReference points at a value in an earlier method response (RFC 8620 section 3.7)
+type ResultReference struct {
+	ResultOf string `json:"resultOf"`
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+}
+
+// resolveReferences replaces "#"-prefixed arguments with the values they reference
+func resolveReferences(args json.RawMessage, responses []Invocation) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(args, &fields); err != nil {
+		return nil, errInvalidArguments("arguments must be an object")
+	}
+
+	resolved := false
+	for key, raw := range fields {
+		if !strings.HasPrefix(key, "#") {
+			continue
+		}
+		name := key[1:]
+		if _, ok := fields[name]; ok {
+			return nil, errInvalidArguments("both %s and %s given", name, key)
+		}
+
+		var ref ResultReference
+		if err := json.Unmarshal(raw, &ref); err != nil {
+			return nil, errInvalidResultReference
+		}
+		value, err := ref.resolve(responses)
+		if err != nil {
+			return nil, err
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, errInvalidResultReference
+		}
+		delete(fields, key)
+		fields[name] = encoded
+		resolved = true
+	}
+
+	if !resolved {
+		return args, nil
+	}
+	return json.Marshal(fields)
+}
+
+// resolve evaluates the reference against the responses so far
+func (ref *ResultReference) resolve(responses []Invocation) (interface{}, error) {
+	for _, resp := range responses {
+		if resp.CallID != ref.ResultOf {
+			continue
+		}
+		if resp.Name != ref.Name {
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal(resp.Args, &value); err != nil {
+			return nil, errInvalidResultReference
+		}
+		result, ok := evalPointer(value, ref.Path)
+		if !ok {
+			return nil, errInvalidResultReference
+		}
+		return result, nil
+	}
+	return nil, errInvalidResultReference
+}
+
+// evalPointer evaluates a JSON pointer (RFC 6901) with the JMAP "*" extension,
+// which maps the rest of the path over an array and flattens the results
+func evalPointer(value interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return value, true
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, false
+	}
+	tokens := strings.Split(path[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return evalTokens(value, tokens)
+}
+
+func evalTokens(value interface{}, tokens []string) (interface{}, bool) {
+	if len(tokens) == 0 {
+		return value, true
+	}
+	token, rest := tokens[0], tokens[1:]
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		child, ok := v[token]
+		if !ok {
+			return nil, false
+		}
+		return evalTokens(child, rest)
+	case []interface{}:
+		if token == "*" {
+			result := make([]interface{}, 0, len(v))
+			for _, item := range v {
+				r, ok := evalTokens(item, rest)
+				if !ok {
+					return nil, false
+				}
+				if items, isArray := r.([]interface{}); isArray {
+					result = append(result, items...)
+				} else {
+					result = append(result, r)
+				}
+			}
+			return result, true
+		}
+		index, err := strconv.Atoi(token)
+		if err != nil || index < 0 || index >= len(v) {
+			return nil, false
+		}
+		return evalTokens(v[index], rest)
+	}
+	return nil, false
+}
+
+// problem is a request-level error (RFC 8620 section 3.6.1, RFC 7807)
+type problem struct {
+	Type   string `json:"type"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Limit  string `json:"limit,omitempty"`
+}
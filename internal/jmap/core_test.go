@@ -0,0 +1,115 @@
+package jmap
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestInvocationJSON(t *testing.T) {
+	var inv Invocation
+	if err := json.Unmarshal([]byte(`["Mailbox/get", {"accountId": "1"}, "c1"]`), &inv); err != nil {
+		t.Fatal(err)
+	}
+	if inv.Name != "Mailbox/get" || inv.CallID != "c1" || string(inv.Args) != `{"accountId": "1"}` {
+		t.Errorf("unexpected invocation: %+v", inv)
+	}
+
+	data, err := json.Marshal(Invocation{Name: "error", CallID: "c2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `["error",{},"c2"]` {
+		t.Errorf("unexpected encoding: %s", data)
+	}
+
+	for _, bad := range []string{`["a", {}]`, `["a", [], "c"]`, `{"name": "a"}`} {
+		if err := json.Unmarshal([]byte(bad), &inv); err == nil {
+			t.Errorf("expected %s to be rejected", bad)
+		}
+	}
+}
+
+func TestResolveReferences(t *testing.T) {
+	responses := []Invocation{
+		{Name: "Email/query", CallID: "q", Args: json.RawMessage(`{"ids": ["1", "2"]}`)},
+		{Name: "Email/get", CallID: "g", Args: json.RawMessage(`{"list": [{"threadId": "Ta"}, {"threadId": "Tb"}]}`)},
+		{Name: "Thread/get", CallID: "t", Args: json.RawMessage(`{"list": [{"emailIds": ["1", "3"]}, {"emailIds": ["2"]}]}`)},
+	}
+
+	tests := []struct {
+		args string
+		want []string
+	}{
+		{`{"#ids": {"resultOf": "q", "name": "Email/query", "path": "/ids"}}`, []string{"1", "2"}},
+		{`{"#ids": {"resultOf": "g", "name": "Email/get", "path": "/list/*/threadId"}}`, []string{"Ta", "Tb"}},
+		{`{"#ids": {"resultOf": "t", "name": "Thread/get", "path": "/list/*/emailIds"}}`, []string{"1", "3", "2"}},
+	}
+	for _, tt := range tests {
+		resolved, err := resolveReferences(json.RawMessage(tt.args), responses)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.args, err)
+		}
+		var got struct {
+			IDs []string `json:"ids"`
+		}
+		if err := json.Unmarshal(resolved, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.IDs, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.args, got.IDs, tt.want)
+		}
+	}
+
+	invalid := []string{
+		`{"#ids": {"resultOf": "x", "name": "Email/query", "path": "/ids"}}`,
+		`{"#ids": {"resultOf": "q", "name": "Email/get", "path": "/ids"}}`,
+		`{"#ids": {"resultOf": "q", "name": "Email/query", "path": "/missing"}}`,
+		`{"#ids": {"resultOf": "q", "name": "Email/query", "path": "/ids"}, "ids": []}`,
+	}
+	for _, args := range invalid {
+		if _, err := resolveReferences(json.RawMessage(args), responses); err == nil {
+			t.Errorf("expected %s to fail", args)
+		}
+	}
+}
+
+func TestQueryPage(t *testing.T) {
+	ids := []string{"a", "b", "c", "d", "e"}
+	intp := func(n int) *int { return &n }
+
+	tests := []struct {
+		name     string
+		args     queryArgs
+		want     []string
+		position int
+		err      error
+	}{
+		{"all", queryArgs{}, ids, 0, nil},
+		{"limit", queryArgs{Position: 1, Limit: intp(2)}, []string{"b", "c"}, 1, nil},
+		{"negative position", queryArgs{Position: -2}, []string{"d", "e"}, 3, nil},
+		{"past the end", queryArgs{Position: 9}, []string{}, 5, nil},
+		{"anchor", queryArgs{Anchor: strp("c"), AnchorOffset: -1, Limit: intp(2)}, []string{"b", "c"}, 1, nil},
+		{"anchor before start", queryArgs{Anchor: strp("a"), AnchorOffset: -3}, ids, 0, nil},
+		{"missing anchor", queryArgs{Anchor: strp("z")}, nil, 0, errAnchorNotFound},
+	}
+	for _, tt := range tests {
+		resp, err := tt.args.page(ids, "s", "1")
+		if err != tt.err {
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(resp.IDs, tt.want) || resp.Position != tt.position {
+			t.Errorf("%s: got %v at %d, want %v at %d", tt.name, resp.IDs, resp.Position, tt.want, tt.position)
+		}
+	}
+
+	total := queryArgs{CalculateTotal: true, Limit: intp(1)}
+	resp, _ := total.page(ids, "s", "1")
+	if resp.Total == nil || *resp.Total != 5 {
+		t.Errorf("expected a total of 5, got %v", resp.Total)
+	}
+}
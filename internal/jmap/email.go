@@ -0,0 +1,1479 @@
+package jmap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"mime"
+	netmail "net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/service"
+)
+
+// systemKeywords maps the keywords of RFC 8621 section 4.1.1 to IMAP system flags
+var systemKeywords = map[string]string{
+	"$seen":     "\\Seen",
+	"$flagged":  "\\Flagged",
+	"$answered": "\\Answered",
+	"$draft":    "\\Draft",
+}
+
+// keywordsOf returns the keywords of a space-separated flag list
+// System flags without a keyword, such as \Deleted, are not exposed.
+func keywordsOf(flags string) map[string]bool {
+	keywords := make(map[string]bool)
+	for _, f := range strings.Fields(flags) {
+		if !strings.HasPrefix(f, "\\") {
+			keywords[strings.ToLower(f)] = true
+			continue
+		}
+		for keyword, flag := range systemKeywords {
+			if strings.EqualFold(f, flag) {
+				keywords[keyword] = true
+			}
+		}
+	}
+	return keywords
+}
+
+// flagsOf returns the flags for a set of keywords, keeping the system flags
+// of current that have no keyword
+func flagsOf(keywords map[string]bool, current string) []string {
+	flags := make([]string, 0, len(keywords))
+	for _, f := range strings.Fields(current) {
+		if !strings.HasPrefix(f, "\\") {
+			continue
+		}
+		mapped := false
+		for _, flag := range systemKeywords {
+			mapped = mapped || strings.EqualFold(f, flag)
+		}
+		if !mapped {
+			flags = append(flags, f)
+		}
+	}
+
+	names := make([]string, 0, len(keywords))
+	for keyword, set := range keywords {
+		if set {
+			names = append(names, keyword)
+		}
+	}
+	sort.Strings(names)
+	for _, keyword := range names {
+		if flag, ok := systemKeywords[keyword]; ok {
+			flags = append(flags, flag)
+		} else {
+			flags = append(flags, keyword)
+		}
+	}
+	return flags
+}
+
+// validKeyword reports whether a keyword can be stored as an IMAP flag
+func validKeyword(keyword string) bool {
+	if keyword == "" || len(keyword) > 255 {
+		return false
+	}
+	for i := 0; i < len(keyword); i++ {
+		b := keyword[i]
+		if b < 0x21 || b > 0x7e || strings.IndexByte(`()]{%*"\`, b) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// parseKeywords validates a keywords property and lowercases its keys
+func parseKeywords(keywords map[string]bool) (map[string]bool, bool) {
+	result := make(map[string]bool, len(keywords))
+	for keyword, set := range keywords {
+		if !set || !validKeyword(keyword) {
+			return nil, false
+		}
+		result[strings.ToLower(keyword)] = true
+	}
+	return result, true
+}
+
+// threadID returns the JMAP ID of a thread
+// Thread IDs are derived from Message-IDs, which may hold characters JMAP IDs cannot.
+func threadID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return "T" + hex.EncodeToString(sum[:12])
+}
+
+// utcDate formats a UTCDate (RFC 8620 section 1.4)
+func utcDate(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// emailProperties are the Email properties returned by default
+var emailProperties = []string{
+	"id", "blobId", "threadId", "mailboxIds", "keywords", "size", "receivedAt",
+	"messageId", "inReplyTo", "references", "sender", "from", "to", "cc", "bcc",
+	"replyTo", "subject", "sentAt", "hasAttachment", "preview", "bodyValues",
+	"textBody", "htmlBody", "attachments",
+}
+
+// emailHeaderProperties maps convenience properties to their header form
+var emailHeaderProperties = map[string]string{
+	"messageId":  "header:Message-ID:asMessageIds",
+	"inReplyTo":  "header:In-Reply-To:asMessageIds",
+	"references": "header:References:asMessageIds",
+	"sender":     "header:Sender:asAddresses",
+	"from":       "header:From:asAddresses",
+	"to":         "header:To:asAddresses",
+	"cc":         "header:Cc:asAddresses",
+	"bcc":        "header:Bcc:asAddresses",
+	"replyTo":    "header:Reply-To:asAddresses",
+	"subject":    "header:Subject:asText",
+	"sentAt":     "header:Date:asDate",
+}
+
+// emailSortProperties are the properties Email/query can sort by
+var emailSortProperties = []string{"receivedAt", "size", "from", "to", "subject", "sentAt", "hasKeyword"}
+
+// headerProperty is a parsed "header:{name}[:as{form}][:all]" property
+type headerProperty struct {
+	name string
+	form string
+	all  bool
+}
+
+// parseHeaderProperty parses a header property (RFC 8621 section 4.1.3)
+func parseHeaderProperty(property string) (*headerProperty, bool) {
+	parts := strings.Split(property, ":")
+	if len(parts) < 2 || len(parts) > 4 || parts[0] != "header" || parts[1] == "" {
+		return nil, false
+	}
+	hp := &headerProperty{name: parts[1], form: "asRaw"}
+	rest := parts[2:]
+	if len(rest) > 0 && rest[len(rest)-1] == "all" {
+		hp.all = true
+		rest = rest[:len(rest)-1]
+	}
+	if len(rest) == 1 {
+		hp.form = rest[0]
+	} else if len(rest) > 1 {
+		return nil, false
+	}
+	switch hp.form {
+	case "asRaw", "asText", "asAddresses", "asGroupedAddresses", "asMessageIds", "asDate", "asURLs":
+		return hp, true
+	}
+	return nil, false
+}
+
+// value returns the property's value from a header
+func (hp *headerProperty) value(h message.Header) interface{} {
+	var values []interface{}
+	fields := h.FieldsByKey(hp.name)
+	for fields.Next() {
+		raw := fields.Value()
+		if b, err := fields.Raw(); err == nil {
+			if _, v, ok := bytes.Cut(b, []byte(":")); ok {
+				raw = strings.TrimRight(string(v), "\r\n")
+			}
+		}
+		values = append(values, parseHeaderValue(raw, hp.form))
+	}
+	if hp.all {
+		if values == nil {
+			return []interface{}{}
+		}
+		return values
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return values[len(values)-1]
+}
+
+// unfold removes the line breaks of a folded header value
+func unfold(raw string) string {
+	return strings.NewReplacer("\r\n", "", "\n", "").Replace(raw)
+}
+
+// parseHeaderValue converts a raw header value to a parsed form
+func parseHeaderValue(raw, form string) interface{} {
+	switch form {
+	case "asText":
+		return decodeWords(strings.TrimSpace(unfold(raw)))
+	case "asAddresses":
+		addresses, err := mail.ParseAddressList(strings.TrimSpace(unfold(raw)))
+		if err != nil {
+			return []map[string]interface{}{}
+		}
+		return emailAddresses(addresses)
+	case "asGroupedAddresses":
+		addresses, err := mail.ParseAddressList(strings.TrimSpace(unfold(raw)))
+		if err != nil {
+			addresses = nil
+		}
+		return []map[string]interface{}{{"name": nil, "addresses": emailAddresses(addresses)}}
+	case "asMessageIds":
+		if ids := angleList(raw); len(ids) > 0 {
+			return ids
+		}
+		return nil
+	case "asDate":
+		t, err := netmail.ParseDate(strings.TrimSpace(unfold(raw)))
+		if err != nil {
+			return nil
+		}
+		return t.Format(time.RFC3339)
+	case "asURLs":
+		if urls := angleList(raw); len(urls) > 0 {
+			return urls
+		}
+		return nil
+	}
+	return raw
+}
+
+// angleList returns the values between angle brackets in a header value
+func angleList(raw string) []string {
+	var values []string
+	for {
+		start := strings.IndexByte(raw, '<')
+		if start < 0 {
+			return values
+		}
+		end := strings.IndexByte(raw[start:], '>')
+		if end < 0 {
+			return values
+		}
+		if v := strings.TrimSpace(unfold(raw[start+1 : start+end])); v != "" {
+			values = append(values, v)
+		}
+		raw = raw[start+end+1:]
+	}
+}
+
+// emailOptions are the Email/get arguments that shape each Email object
+type emailOptions struct {
+	properties     []string
+	bodyProperties []string
+	fetchText      bool
+	fetchHTML      bool
+	fetchAll       bool
+	maxValueBytes  int
+}
+
+// needsBody reports whether any requested property needs the parsed body
+func (o *emailOptions) needsBody() bool {
+	for _, p := range o.properties {
+		switch p {
+		case "bodyStructure", "textBody", "htmlBody", "attachments", "hasAttachment", "preview", "bodyValues":
+			return true
+		}
+	}
+	return false
+}
+
+// emailObject builds the JMAP representation of a message
+func emailObject(msg *domain.Message, o *emailOptions) (map[string]interface{}, error) {
+	e, err := message.Read(bytes.NewReader(msg.Content))
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return nil, err
+	}
+	var root *bodyPart
+	var lists *bodyLists
+	if o.needsBody() {
+		if root, err = parseBody(msg.Content); err != nil {
+			return nil, err
+		}
+		lists = classify(root)
+	}
+
+	object := make(map[string]interface{}, len(o.properties))
+	for _, property := range o.properties {
+		switch property {
+		case "id":
+			object[property] = formatID(msg.ID)
+		case "blobId":
+			object[property] = messageBlobID(msg.ID, "")
+		case "threadId":
+			object[property] = threadID(msg.ThreadID)
+		case "mailboxIds":
+			object[property] = map[string]bool{formatID(msg.MailboxID): true}
+		case "keywords":
+			object[property] = keywordsOf(msg.Flags)
+		case "size":
+			object[property] = msg.Size
+		case "receivedAt":
+			object[property] = utcDate(msg.InternalDate)
+		case "headers":
+			object[property] = rawHeaders(e.Header)
+		case "bodyStructure":
+			properties := o.bodyProperties
+			if !contains(properties, "subParts") {
+				properties = append(append([]string{}, properties...), "subParts")
+			}
+			object[property] = root.object(msg.ID, properties)
+		case "textBody":
+			object[property] = partObjects(lists.text, msg.ID, o.bodyProperties)
+		case "htmlBody":
+			object[property] = partObjects(lists.html, msg.ID, o.bodyProperties)
+		case "attachments":
+			object[property] = partObjects(lists.attachments, msg.ID, o.bodyProperties)
+		case "hasAttachment":
+			object[property] = len(lists.attachments) > 0
+		case "preview":
+			object[property] = preview(lists)
+		case "bodyValues":
+			object[property] = bodyValues(root, lists, o)
+		default:
+			name := property
+			if form, ok := emailHeaderProperties[property]; ok {
+				name = form
+			}
+			hp, ok := parseHeaderProperty(name)
+			if !ok {
+				continue
+			}
+			object[property] = hp.value(e.Header)
+		}
+	}
+	return object, nil
+}
+
+// bodyValues returns the decoded text parts the options ask for
+func bodyValues(root *bodyPart, lists *bodyLists, o *emailOptions) map[string]*bodyValue {
+	values := make(map[string]*bodyValue)
+	add := func(p *bodyPart) {
+		if p.partID != "" && strings.HasPrefix(p.contentType, "text/") {
+			values[p.partID] = p.textValue(o.maxValueBytes)
+		}
+	}
+	if o.fetchAll {
+		root.walk(add)
+		return values
+	}
+	if o.fetchText {
+		for _, p := range lists.text {
+			add(p)
+		}
+	}
+	if o.fetchHTML {
+		for _, p := range lists.html {
+			add(p)
+		}
+	}
+	return values
+}
+
+// contains reports whether a list holds a string
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// userMessages lists the user's messages in every mailbox, without content
+func (c *call) userMessages() ([]*domain.Message, error) {
+	t, err := c.mailboxes()
+	if err != nil {
+		return nil, err
+	}
+	var messages []*domain.Message
+	for _, mb := range t.list {
+		list, err := c.h.messageService.List(mb.ID)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, list...)
+	}
+	return messages, nil
+}
+
+func emailGet(c *call, args json.RawMessage) (interface{}, error) {
+	var a struct {
+		getArgs
+		BodyProperties      *[]string `json:"bodyProperties"`
+		FetchTextBodyValues bool      `json:"fetchTextBodyValues"`
+		FetchHTMLBodyValues bool      `json:"fetchHTMLBodyValues"`
+		FetchAllBodyValues  bool      `json:"fetchAllBodyValues"`
+		MaxBodyValueBytes   int       `json:"maxBodyValueBytes"`
+	}
+	if err := c.decode(args, &a); err != nil {
+		return nil, err
+	}
+	if a.tooMany() {
+		return nil, errRequestTooLarge
+	}
+	if a.MaxBodyValueBytes < 0 {
+		return nil, errInvalidArguments("maxBodyValueBytes must not be negative")
+	}
+
+	o := &emailOptions{
+		properties:     emailProperties,
+		bodyProperties: defaultBodyProperties,
+		fetchText:      a.FetchTextBodyValues,
+		fetchHTML:      a.FetchHTMLBodyValues,
+		fetchAll:       a.FetchAllBodyValues,
+		maxValueBytes:  a.MaxBodyValueBytes,
+	}
+	if a.Properties != nil {
+		o.properties = append([]string{"id"}, *a.Properties...)
+		for _, p := range *a.Properties {
+			_, header := parseHeaderProperty(p)
+			if !header && !contains(emailProperties, p) && p != "headers" && p != "bodyStructure" {
+				return nil, errInvalidArguments("unknown property %s", p)
+			}
+		}
+	}
+	if a.BodyProperties != nil {
+		o.bodyProperties = *a.BodyProperties
+		for _, p := range o.bodyProperties {
+			if !contains(defaultBodyProperties, p) && p != "headers" && p != "subParts" {
+				return nil, errInvalidArguments("unknown body property %s", p)
+			}
+		}
+	}
+
+	state, err := c.state(domain.ChangeTypeEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := a.ids()
+	if ids == nil {
+		messages, err := c.userMessages()
+		if err != nil {
+			return nil, err
+		}
+		if len(messages) > maxObjectsInGet {
+			return nil, errRequestTooLarge
+		}
+		for _, msg := range messages {
+			ids = append(ids, formatID(msg.ID))
+		}
+	}
+
+	resp := &getResponse{AccountID: c.accountID, State: state, List: make([]map[string]interface{}, 0), NotFound: make([]string, 0)}
+	for _, id := range ids {
+		resolved, _ := c.resolveID(id)
+		n, ok := parseID(resolved)
+		if !ok {
+			resp.NotFound = append(resp.NotFound, id)
+			continue
+		}
+		msg, err := c.h.message(c.user.ID, n)
+		if err != nil {
+			resp.NotFound = append(resp.NotFound, id)
+			continue
+		}
+		object, err := emailObject(msg, o)
+		if err != nil {
+			return nil, err
+		}
+		resp.List = append(resp.List, object)
+	}
+	return resp, nil
+}
+
+func emailChanges(c *call, args json.RawMessage) (interface{}, error) {
+	return c.changes(args, domain.ChangeTypeEmail, sameID)
+}
+
+// emailFilter is an Email/query FilterOperator or FilterCondition (RFC 8621 section 4.4.1)
+type emailFilter struct {
+	Operator                string         `json:"operator"`
+	Conditions              []*emailFilter `json:"conditions"`
+	InMailbox               *string        `json:"inMailbox"`
+	InMailboxOtherThan      []string       `json:"inMailboxOtherThan"`
+	Before                  *time.Time     `json:"before"`
+	After                   *time.Time     `json:"after"`
+	MinSize                 *int64         `json:"minSize"`
+	MaxSize                 *int64         `json:"maxSize"`
+	AllInThreadHaveKeyword  *string        `json:"allInThreadHaveKeyword"`
+	SomeInThreadHaveKeyword *string        `json:"someInThreadHaveKeyword"`
+	NoneInThreadHaveKeyword *string        `json:"noneInThreadHaveKeyword"`
+	HasKeyword              *string        `json:"hasKeyword"`
+	NotKeyword              *string        `json:"notKeyword"`
+	HasAttachment           *bool          `json:"hasAttachment"`
+	Text                    *string        `json:"text"`
+	From                    *string        `json:"from"`
+	To                      *string        `json:"to"`
+	Cc                      *string        `json:"cc"`
+	Bcc                     *string        `json:"bcc"`
+	Subject                 *string        `json:"subject"`
+	Body                    *string        `json:"body"`
+	Header                  []string       `json:"header"`
+}
+
+// parseEmailFilter decodes a filter, rejecting conditions it does not know
+func parseEmailFilter(raw json.RawMessage) (*emailFilter, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	var f emailFilter
+	if err := dec.Decode(&f); err != nil {
+		return nil, errUnsupportedFilter
+	}
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// validate checks operators and header conditions throughout the filter
+func (f *emailFilter) validate() error {
+	switch f.Operator {
+	case "":
+		if len(f.Conditions) > 0 {
+			return errUnsupportedFilter
+		}
+	case "AND", "OR", "NOT":
+		for _, cond := range f.Conditions {
+			if cond == nil {
+				return errUnsupportedFilter
+			}
+			if err := cond.validate(); err != nil {
+				return err
+			}
+		}
+	default:
+		return errUnsupportedFilter
+	}
+	if f.Header != nil && (len(f.Header) < 1 || len(f.Header) > 2) {
+		return errUnsupportedFilter
+	}
+	return nil
+}
+
+// queryMessage is a message being matched, with its content loaded on demand
+type queryMessage struct {
+	*domain.Message
+	c      *call
+	loaded bool
+	header message.Header
+	lists  *bodyLists
+}
+
+// load reads and parses the message content
+func (m *queryMessage) load() error {
+	if m.loaded {
+		return nil
+	}
+	msg, err := m.c.h.messageService.GetByID(m.ID)
+	if err != nil {
+		return err
+	}
+	e, err := message.Read(bytes.NewReader(msg.Content))
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return err
+	}
+	root, err := parseBody(msg.Content)
+	if err != nil {
+		return err
+	}
+	m.header = e.Header
+	m.lists = classify(root)
+	m.loaded = true
+	return nil
+}
+
+// bodyText returns the text of the message body
+func (m *queryMessage) bodyText() (string, error) {
+	if err := m.load(); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, p := range m.lists.text {
+		if !strings.HasPrefix(p.contentType, "text/") {
+			continue
+		}
+		text := p.textValue(0).Value
+		if p.contentType == "text/html" {
+			text = stripHTML(text)
+		}
+		b.WriteString(text)
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// sentAt returns the Date header of the message, or its internal date
+func (m *queryMessage) sentAt() time.Time {
+	var headers map[string]string
+	if err := json.Unmarshal([]byte(m.Headers), &headers); err == nil {
+		for key, value := range headers {
+			if strings.EqualFold(key, "Date") {
+				if t, err := netmail.ParseDate(value); err == nil {
+					return t
+				}
+			}
+		}
+	}
+	return m.InternalDate
+}
+
+// containsFold reports whether s contains substr, ignoring case
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// emailIndex gives filters access to a message's thread and mailboxes
+type emailIndex struct {
+	t       *mailboxTree
+	threads map[string][]*queryMessage
+}
+
+// match reports whether a message matches the filter
+func (f *emailFilter) match(m *queryMessage, idx *emailIndex) (bool, error) {
+	switch f.Operator {
+	case "AND":
+		for _, cond := range f.Conditions {
+			if ok, err := cond.match(m, idx); err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	case "OR", "NOT":
+		for _, cond := range f.Conditions {
+			ok, err := cond.match(m, idx)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				return f.Operator == "OR", nil
+			}
+		}
+		return f.Operator == "NOT", nil
+	}
+
+	if f.InMailbox != nil {
+		if mb := idx.t.lookup(*f.InMailbox); mb == nil || mb.ID != m.MailboxID {
+			return false, nil
+		}
+	}
+	for _, id := range f.InMailboxOtherThan {
+		if mb := idx.t.lookup(id); mb != nil && mb.ID == m.MailboxID {
+			return false, nil
+		}
+	}
+	if f.Before != nil && !m.InternalDate.Before(*f.Before) {
+		return false, nil
+	}
+	if f.After != nil && m.InternalDate.Before(*f.After) {
+		return false, nil
+	}
+	if f.MinSize != nil && m.Size < *f.MinSize {
+		return false, nil
+	}
+	if f.MaxSize != nil && m.Size >= *f.MaxSize {
+		return false, nil
+	}
+	if f.HasKeyword != nil && !keywordsOf(m.Flags)[strings.ToLower(*f.HasKeyword)] {
+		return false, nil
+	}
+	if f.NotKeyword != nil && keywordsOf(m.Flags)[strings.ToLower(*f.NotKeyword)] {
+		return false, nil
+	}
+	if !f.matchThread(m, idx) {
+		return false, nil
+	}
+
+	fields := []struct {
+		value *string
+		text  string
+	}{
+		{f.From, m.From},
+		{f.To, m.To},
+		{f.Cc, m.CC},
+		{f.Bcc, m.BCC},
+		{f.Subject, m.Subject},
+	}
+	for _, field := range fields {
+		if field.value != nil && !containsFold(decodeWords(field.text), *field.value) {
+			return false, nil
+		}
+	}
+
+	if f.HasAttachment != nil {
+		if err := m.load(); err != nil {
+			return false, err
+		}
+		if (len(m.lists.attachments) > 0) != *f.HasAttachment {
+			return false, nil
+		}
+	}
+	if f.Body != nil || f.Text != nil {
+		body, err := m.bodyText()
+		if err != nil {
+			return false, err
+		}
+		if f.Body != nil && !containsFold(body, *f.Body) {
+			return false, nil
+		}
+		if f.Text != nil {
+			text := strings.Join([]string{m.From, m.To, m.CC, m.BCC, m.Subject}, "\n")
+			if !containsFold(decodeWords(text), *f.Text) && !containsFold(body, *f.Text) {
+				return false, nil
+			}
+		}
+	}
+	if f.Header != nil {
+		if err := m.load(); err != nil {
+			return false, err
+		}
+		if !m.header.Has(f.Header[0]) {
+			return false, nil
+		}
+		if len(f.Header) == 2 && !containsFold(decodeWords(m.header.Get(f.Header[0])), f.Header[1]) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// matchThread applies the thread keyword conditions
+func (f *emailFilter) matchThread(m *queryMessage, idx *emailIndex) bool {
+	count := func(keyword string) (int, int) {
+		n := 0
+		thread := idx.threads[m.ThreadID]
+		for _, other := range thread {
+			if keywordsOf(other.Flags)[strings.ToLower(keyword)] {
+				n++
+			}
+		}
+		return n, len(thread)
+	}
+	if f.AllInThreadHaveKeyword != nil {
+		if n, total := count(*f.AllInThreadHaveKeyword); n != total {
+			return false
+		}
+	}
+	if f.SomeInThreadHaveKeyword != nil {
+		if n, _ := count(*f.SomeInThreadHaveKeyword); n == 0 {
+			return false
+		}
+	}
+	if f.NoneInThreadHaveKeyword != nil {
+		if n, _ := count(*f.NoneInThreadHaveKeyword); n > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// compare orders two messages by one comparator, returning -1, 0 or 1
+func (s comparator) compare(x, y *queryMessage) int {
+	result := 0
+	switch s.Property {
+	case "receivedAt":
+		result = x.InternalDate.Compare(y.InternalDate)
+	case "sentAt":
+		result = x.sentAt().Compare(y.sentAt())
+	case "size":
+		switch {
+		case x.Size < y.Size:
+			result = -1
+		case x.Size > y.Size:
+			result = 1
+		}
+	case "from", "to", "subject":
+		text := func(m *queryMessage) string {
+			switch s.Property {
+			case "from":
+				return m.From
+			case "to":
+				return m.To
+			}
+			return m.Subject
+		}
+		result = strings.Compare(strings.ToLower(decodeWords(text(x))), strings.ToLower(decodeWords(text(y))))
+	case "hasKeyword":
+		keyword := strings.ToLower(s.Keyword)
+		xs, ys := keywordsOf(x.Flags)[keyword], keywordsOf(y.Flags)[keyword]
+		switch {
+		case !xs && ys:
+			result = -1
+		case xs && !ys:
+			result = 1
+		}
+	}
+	if !s.ascending() {
+		result = -result
+	}
+	return result
+}
+
+func emailQuery(c *call, args json.RawMessage) (interface{}, error) {
+	var a struct {
+		queryArgs
+		Filter          json.RawMessage `json:"filter"`
+		Sort            []comparator    `json:"sort"`
+		CollapseThreads bool            `json:"collapseThreads"`
+	}
+	if err := c.decode(args, &a); err != nil {
+		return nil, err
+	}
+	filter, err := parseEmailFilter(a.Filter)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range a.Sort {
+		if !contains(emailSortProperties, s.Property) || (s.Property == "hasKeyword" && s.Keyword == "") {
+			return nil, errUnsupportedSort
+		}
+	}
+	if len(a.Sort) == 0 {
+		descending := false
+		a.Sort = []comparator{{Property: "receivedAt", IsAscending: &descending}}
+	}
+
+	state, err := c.state(domain.ChangeTypeEmail)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := c.queryEmails(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		for _, s := range a.Sort {
+			if r := s.compare(matches[i], matches[j]); r != 0 {
+				return r < 0
+			}
+		}
+		return matches[i].ID < matches[j].ID
+	})
+
+	ids := make([]string, 0, len(matches))
+	seen := make(map[string]bool)
+	for _, m := range matches {
+		if a.CollapseThreads {
+			if seen[m.ThreadID] {
+				continue
+			}
+			seen[m.ThreadID] = true
+		}
+		ids = append(ids, formatID(m.ID))
+	}
+	return a.page(ids, state, c.accountID)
+}
+
+// queryEmails returns the user's messages matching a filter
+func (c *call) queryEmails(filter *emailFilter) ([]*queryMessage, error) {
+	t, err := c.mailboxes()
+	if err != nil {
+		return nil, err
+	}
+	messages, err := c.userMessages()
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &emailIndex{t: t, threads: make(map[string][]*queryMessage)}
+	all := make([]*queryMessage, len(messages))
+	for i, msg := range messages {
+		all[i] = &queryMessage{Message: msg, c: c}
+		idx.threads[msg.ThreadID] = append(idx.threads[msg.ThreadID], all[i])
+	}
+	if filter == nil {
+		return all, nil
+	}
+
+	matches := make([]*queryMessage, 0, len(all))
+	for _, m := range all {
+		ok, err := filter.match(m, idx)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matches = append(matches, m)
+		}
+	}
+	return matches, nil
+}
+
+// singleMailbox returns the only mailbox of a mailboxIds property
+func singleMailbox(t *mailboxTree, c *call, ids map[string]bool) (*domain.Mailbox, *SetError) {
+	var selected []*domain.Mailbox
+	for id, set := range ids {
+		if !set {
+			return nil, invalidProperties("mailboxIds values must be true", "mailboxIds")
+		}
+		resolved, _ := c.resolveID(id)
+		mb := t.lookup(resolved)
+		if mb == nil {
+			return nil, invalidProperties("mailbox not found", "mailboxIds")
+		}
+		selected = append(selected, mb)
+	}
+	if len(selected) != 1 {
+		return nil, invalidProperties("an email must be in exactly one mailbox", "mailboxIds")
+	}
+	return selected[0], nil
+}
+
+// emailAddress is an EmailAddress of an Email/set create
+type emailAddress struct {
+	Name  *string `json:"name"`
+	Email string  `json:"email"`
+}
+
+// bodyPartCreate is an EmailBodyPart of an Email/set create
+type bodyPartCreate struct {
+	PartID      *string  `json:"partId"`
+	BlobID      *string  `json:"blobId"`
+	Type        string   `json:"type"`
+	Charset     *string  `json:"charset"`
+	Name        *string  `json:"name"`
+	Disposition *string  `json:"disposition"`
+	Cid         *string  `json:"cid"`
+	Language    []string `json:"language"`
+	Location    *string  `json:"location"`
+	Size        *int     `json:"size"`
+}
+
+// emailCreate holds the properties of an email to create
+type emailCreate struct {
+	MailboxIDs  map[string]bool       `json:"mailboxIds"`
+	Keywords    map[string]bool       `json:"keywords"`
+	ReceivedAt  *time.Time            `json:"receivedAt"`
+	MessageID   []string              `json:"messageId"`
+	InReplyTo   []string              `json:"inReplyTo"`
+	References  []string              `json:"references"`
+	Sender      []emailAddress        `json:"sender"`
+	From        []emailAddress        `json:"from"`
+	To          []emailAddress        `json:"to"`
+	Cc          []emailAddress        `json:"cc"`
+	Bcc         []emailAddress        `json:"bcc"`
+	ReplyTo     []emailAddress        `json:"replyTo"`
+	Subject     *string               `json:"subject"`
+	SentAt      *time.Time            `json:"sentAt"`
+	TextBody    []bodyPartCreate      `json:"textBody"`
+	HTMLBody    []bodyPartCreate      `json:"htmlBody"`
+	Attachments []bodyPartCreate      `json:"attachments"`
+	BodyValues  map[string]*bodyValue `json:"bodyValues"`
+}
+
+// outPart is a MIME part of a message being composed
+type outPart struct {
+	header   message.Header
+	body     []byte
+	children []*outPart
+}
+
+// fill writes the part's content, or its children, and closes the writer
+func (p *outPart) fill(w *message.Writer) error {
+	if p.children == nil {
+		if _, err := w.Write(p.body); err != nil {
+			return err
+		}
+		return w.Close()
+	}
+	for _, child := range p.children {
+		cw, err := w.CreatePart(child.header)
+		if err != nil {
+			return err
+		}
+		if err := child.fill(cw); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// multipart wraps parts in a multipart container
+func multipart(subtype string, children ...*outPart) *outPart {
+	p := &outPart{children: children}
+	p.header.SetContentType("multipart/"+subtype, nil)
+	return p
+}
+
+// mailAddresses converts EmailAddress objects for a header
+func mailAddresses(list []emailAddress) []*mail.Address {
+	addresses := make([]*mail.Address, len(list))
+	for i, a := range list {
+		addresses[i] = &mail.Address{Address: a.Email}
+		if a.Name != nil {
+			addresses[i].Name = *a.Name
+		}
+	}
+	return addresses
+}
+
+// buildEmail composes the MIME message of an Email/set create
+func (c *call) buildEmail(e *emailCreate) ([]byte, *SetError, error) {
+	var h mail.Header
+	sentAt := time.Now()
+	if e.SentAt != nil {
+		sentAt = *e.SentAt
+	}
+	h.SetDate(sentAt)
+
+	addressFields := []struct {
+		key  string
+		list []emailAddress
+	}{
+		{"From", e.From},
+		{"Sender", e.Sender},
+		{"To", e.To},
+		{"Cc", e.Cc},
+		{"Bcc", e.Bcc},
+		{"Reply-To", e.ReplyTo},
+	}
+	for _, field := range addressFields {
+		if len(field.list) > 0 {
+			h.SetAddressList(field.key, mailAddresses(field.list))
+		}
+	}
+	if e.Subject != nil {
+		h.SetSubject(*e.Subject)
+	}
+
+	if len(e.MessageID) > 1 {
+		return nil, invalidProperties("only one Message-ID is allowed", "messageId"), nil
+	}
+	if len(e.MessageID) == 1 {
+		h.SetMessageID(e.MessageID[0])
+	} else {
+		sender := c.user.Email
+		if len(e.From) > 0 {
+			sender = e.From[0].Email
+		}
+		h.Set("Message-Id", service.NewMessageID(sender[strings.LastIndex(sender, "@")+1:]))
+	}
+	h.SetMsgIDList("In-Reply-To", e.InReplyTo)
+	h.SetMsgIDList("References", e.References)
+
+	if len(e.TextBody) > 1 || len(e.HTMLBody) > 1 {
+		return nil, invalidProperties("only one text and one HTML body part are supported", "textBody", "htmlBody"), nil
+	}
+	var body *outPart
+	var text, html *outPart
+	if len(e.TextBody) == 1 {
+		p, setErr, err := c.textPart(e, e.TextBody[0], "text/plain", "textBody")
+		if setErr != nil || err != nil {
+			return nil, setErr, err
+		}
+		text = p
+	}
+	if len(e.HTMLBody) == 1 {
+		p, setErr, err := c.textPart(e, e.HTMLBody[0], "text/html", "htmlBody")
+		if setErr != nil || err != nil {
+			return nil, setErr, err
+		}
+		html = p
+	}
+	switch {
+	case text != nil && html != nil:
+		body = multipart("alternative", text, html)
+	case text != nil:
+		body = text
+	case html != nil:
+		body = html
+	default:
+		body = &outPart{}
+		body.header.SetContentType("text/plain", map[string]string{"charset": "utf-8"})
+	}
+
+	if len(e.Attachments) > 0 {
+		parts := []*outPart{body}
+		for _, a := range e.Attachments {
+			p, setErr, err := c.attachmentPart(a)
+			if setErr != nil || err != nil {
+				return nil, setErr, err
+			}
+			parts = append(parts, p)
+		}
+		body = multipart("mixed", parts...)
+	}
+
+	// The top-level part's fields belong to the message header
+	fields := body.header.Fields()
+	for fields.Next() {
+		h.Add(fields.Key(), fields.Value())
+	}
+
+	var buf bytes.Buffer
+	w, err := message.CreateWriter(&buf, h.Header)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := body.fill(w); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), nil, nil
+}
+
+// textPart builds a text body part from its body value or blob
+func (c *call) textPart(e *emailCreate, spec bodyPartCreate, mediaType, property string) (*outPart, *SetError, error) {
+	if spec.Type != "" && !strings.EqualFold(spec.Type, mediaType) {
+		return nil, invalidProperties("body part must be "+mediaType, property), nil
+	}
+	if spec.Charset != nil || spec.Size != nil {
+		return nil, invalidProperties("charset and size cannot be given for a body value", property), nil
+	}
+
+	var content []byte
+	switch {
+	case spec.PartID != nil && spec.BlobID == nil:
+		value, ok := e.BodyValues[*spec.PartID]
+		if !ok {
+			return nil, invalidProperties("body value not found", property), nil
+		}
+		if value.IsTruncated || value.IsEncodingProblem {
+			return nil, invalidProperties("body value must not be truncated", "bodyValues"), nil
+		}
+		content = []byte(strings.ReplaceAll(strings.ReplaceAll(value.Value, "\r\n", "\n"), "\n", "\r\n"))
+	case spec.BlobID != nil && spec.PartID == nil:
+		data, _, err := c.h.blob(c.user.ID, *spec.BlobID)
+		if err != nil {
+			return nil, setError("blobNotFound", "blob %s not found", *spec.BlobID), nil
+		}
+		content = data
+	default:
+		return nil, invalidProperties("either partId or blobId is required", property), nil
+	}
+
+	p := &outPart{body: content}
+	p.header.SetContentType(mediaType, map[string]string{"charset": "utf-8"})
+	p.header.Set("Content-Transfer-Encoding", "quoted-printable")
+	return p, nil, nil
+}
+
+// attachmentPart builds an attachment from an uploaded blob
+func (c *call) attachmentPart(spec bodyPartCreate) (*outPart, *SetError, error) {
+	if spec.BlobID == nil || spec.PartID != nil {
+		return nil, invalidProperties("attachments need a blobId", "attachments"), nil
+	}
+	data, blobType, err := c.h.blob(c.user.ID, *spec.BlobID)
+	if err != nil {
+		return nil, setError("blobNotFound", "blob %s not found", *spec.BlobID), nil
+	}
+
+	mediaType := spec.Type
+	if mediaType == "" {
+		mediaType = blobType
+	}
+	if parsed, _, err := mime.ParseMediaType(mediaType); err == nil {
+		mediaType = parsed
+	} else {
+		mediaType = "application/octet-stream"
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		return nil, invalidProperties("attachments cannot be multipart", "attachments"), nil
+	}
+	params := map[string]string{}
+	if spec.Charset != nil && (strings.EqualFold(*spec.Charset, "utf-8") || strings.EqualFold(*spec.Charset, "us-ascii")) {
+		params["charset"] = *spec.Charset
+	}
+
+	p := &outPart{body: data}
+	p.header.SetContentType(mediaType, params)
+	p.header.Set("Content-Transfer-Encoding", "base64")
+	disposition := "attachment"
+	if spec.Disposition != nil {
+		disposition = *spec.Disposition
+	}
+	dparams := map[string]string{}
+	if spec.Name != nil {
+		dparams["filename"] = *spec.Name
+	}
+	p.header.SetContentDisposition(disposition, dparams)
+	if spec.Cid != nil {
+		p.header.Set("Content-Id", "<"+*spec.Cid+">")
+	}
+	if len(spec.Language) > 0 {
+		p.header.Set("Content-Language", strings.Join(spec.Language, ", "))
+	}
+	if spec.Location != nil {
+		p.header.Set("Content-Location", *spec.Location)
+	}
+	return p, nil, nil
+}
+
+// storeEmail checks the quota and stores a message in a mailbox
+func (c *call) storeEmail(mb *domain.Mailbox, data []byte, keywords map[string]bool, receivedAt time.Time) (*domain.Message, *SetError, error) {
+	if c.h.quota != nil {
+		if err := c.h.quota.Check(c.user.ID, int64(len(data)), 1); err != nil {
+			if errors.Is(err, service.ErrOverQuota) {
+				return nil, setError("overQuota", "%v", err), nil
+			}
+			return nil, nil, err
+		}
+	}
+	uid, err := c.h.mailboxService.AllocateUID(mb.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg, err := c.h.messageService.Append(c.user.ID, mb.ID, uid, data, flagsOf(keywords, ""), receivedAt)
+	if err != nil {
+		return nil, setError("invalidEmail", "%v", err), nil
+	}
+	return msg, nil, nil
+}
+
+// createdEmail is the server-set properties of a created email
+func createdEmail(msg *domain.Message) map[string]interface{} {
+	return map[string]interface{}{
+		"id":       formatID(msg.ID),
+		"blobId":   messageBlobID(msg.ID, ""),
+		"threadId": threadID(msg.ThreadID),
+		"size":     msg.Size,
+	}
+}
+
+func emailSet(c *call, args json.RawMessage) (interface{}, error) {
+	var a setArgs
+	if err := c.decode(args, &a); err != nil {
+		return nil, err
+	}
+	resp, err := c.newSetResponse(&a, domain.ChangeTypeEmail)
+	if err != nil {
+		return nil, err
+	}
+	t, err := c.mailboxes()
+	if err != nil {
+		return nil, err
+	}
+
+	for cid, raw := range a.Create {
+		msg, setErr, err := c.createEmail(t, raw)
+		if err != nil {
+			return nil, err
+		}
+		if setErr != nil {
+			resp.NotCreated[cid] = setErr
+			continue
+		}
+		c.created[cid] = formatID(msg.ID)
+		resp.Created[cid] = createdEmail(msg)
+	}
+
+	for id, patch := range a.Update {
+		resolved, _ := c.resolveID(id)
+		setErr, err := c.updateEmail(t, resolved, patch)
+		if err != nil {
+			return nil, err
+		}
+		if setErr != nil {
+			resp.NotUpdated[id] = setErr
+			continue
+		}
+		resp.Updated[id] = nil
+	}
+
+	for _, id := range a.Destroy {
+		resolved, _ := c.resolveID(id)
+		n, ok := parseID(resolved)
+		if ok {
+			_, err := c.h.message(c.user.ID, n)
+			ok = err == nil
+		}
+		if !ok {
+			resp.NotDestroyed[id] = setError("notFound", "email not found")
+			continue
+		}
+		if err := c.h.messageService.Delete(n); err != nil {
+			return nil, err
+		}
+		resp.Destroyed = append(resp.Destroyed, id)
+	}
+
+	return c.finish(resp, domain.ChangeTypeEmail)
+}
+
+// createEmail composes and stores one email of an Email/set call
+func (c *call) createEmail(t *mailboxTree, raw json.RawMessage) (*domain.Message, *SetError, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	var e emailCreate
+	if err := dec.Decode(&e); err != nil {
+		return nil, invalidProperties(err.Error()), nil
+	}
+	mb, setErr := singleMailbox(t, c, e.MailboxIDs)
+	if setErr != nil {
+		return nil, setErr, nil
+	}
+	keywords, ok := parseKeywords(e.Keywords)
+	if !ok {
+		return nil, invalidProperties("invalid keyword", "keywords"), nil
+	}
+
+	data, setErr, err := c.buildEmail(&e)
+	if setErr != nil || err != nil {
+		return nil, setErr, err
+	}
+	receivedAt := time.Now()
+	if e.ReceivedAt != nil {
+		receivedAt = *e.ReceivedAt
+	}
+	return c.storeEmail(mb, data, keywords, receivedAt)
+}
+
+// updateEmail applies one update of an Email/set call
+func (c *call) updateEmail(t *mailboxTree, id string, patch map[string]json.RawMessage) (*SetError, error) {
+	n, ok := parseID(id)
+	if !ok {
+		return setError("notFound", "email not found"), nil
+	}
+	msg, err := c.h.message(c.user.ID, n)
+	if err != nil {
+		return setError("notFound", "email not found"), nil
+	}
+
+	keywords := keywordsOf(msg.Flags)
+	mailboxIDs := map[string]bool{formatID(msg.MailboxID): true}
+	for property, raw := range patch {
+		switch {
+		case property == "keywords":
+			var full map[string]bool
+			if err := json.Unmarshal(raw, &full); err != nil {
+				return invalidProperties("invalid keywords", "keywords"), nil
+			}
+			if keywords, ok = parseKeywords(full); !ok {
+				return invalidProperties("invalid keyword", "keywords"), nil
+			}
+		case strings.HasPrefix(property, "keywords/"):
+			keyword := strings.ToLower(strings.TrimPrefix(property, "keywords/"))
+			var set *bool
+			if json.Unmarshal(raw, &set) != nil || !validKeyword(keyword) || (set != nil && !*set) {
+				return invalidProperties("invalid keyword", property), nil
+			}
+			if set == nil {
+				delete(keywords, keyword)
+			} else {
+				keywords[keyword] = true
+			}
+		case property == "mailboxIds":
+			mailboxIDs = nil
+			if err := json.Unmarshal(raw, &mailboxIDs); err != nil {
+				return invalidProperties("invalid mailboxIds", "mailboxIds"), nil
+			}
+		case strings.HasPrefix(property, "mailboxIds/"):
+			mailbox, _ := c.resolveID(strings.TrimPrefix(property, "mailboxIds/"))
+			var set *bool
+			if json.Unmarshal(raw, &set) != nil || (set != nil && !*set) {
+				return invalidProperties("invalid mailboxIds patch", property), nil
+			}
+			if set == nil {
+				delete(mailboxIDs, mailbox)
+			} else {
+				mailboxIDs[mailbox] = true
+			}
+		default:
+			return invalidProperties("property cannot be changed", property), nil
+		}
+	}
+
+	target, setErr := singleMailbox(t, c, mailboxIDs)
+	if setErr != nil {
+		return setErr, nil
+	}
+
+	flags := flagsOf(keywords, msg.Flags)
+	if strings.Join(flags, " ") != msg.Flags {
+		if err := c.h.messageService.SetFlags(msg.ID, flags); err != nil {
+			return nil, err
+		}
+	}
+	if target.ID != msg.MailboxID {
+		uid, err := c.h.mailboxService.AllocateUID(target.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.h.messageService.Move(msg.ID, target, uid); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// emailImportArgs is one email of an Email/import call
+type emailImportArgs struct {
+	BlobID     string          `json:"blobId"`
+	MailboxIDs map[string]bool `json:"mailboxIds"`
+	Keywords   map[string]bool `json:"keywords"`
+	ReceivedAt *time.Time      `json:"receivedAt"`
+}
+
+func emailImport(c *call, args json.RawMessage) (interface{}, error) {
+	var a struct {
+		AccountID string                     `json:"accountId"`
+		IfInState *string                    `json:"ifInState"`
+		Emails    map[string]emailImportArgs `json:"emails"`
+	}
+	if err := c.decode(args, &a); err != nil {
+		return nil, err
+	}
+	set := setArgs{IfInState: a.IfInState}
+	if len(a.Emails) > maxObjectsInSet {
+		return nil, errRequestTooLarge
+	}
+	resp, err := c.newSetResponse(&set, domain.ChangeTypeEmail)
+	if err != nil {
+		return nil, err
+	}
+	t, err := c.mailboxes()
+	if err != nil {
+		return nil, err
+	}
+
+	for cid, e := range a.Emails {
+		mb, setErr := singleMailbox(t, c, e.MailboxIDs)
+		if setErr != nil {
+			resp.NotCreated[cid] = setErr
+			continue
+		}
+		keywords, ok := parseKeywords(e.Keywords)
+		if !ok {
+			resp.NotCreated[cid] = invalidProperties("invalid keyword", "keywords")
+			continue
+		}
+		data, _, err := c.h.blob(c.user.ID, e.BlobID)
+		if err != nil {
+			resp.NotCreated[cid] = &SetError{Type: "blobNotFound", Description: "blob not found", NotFound: []string{e.BlobID}}
+			continue
+		}
+		receivedAt := time.Now()
+		if e.ReceivedAt != nil {
+			receivedAt = *e.ReceivedAt
+		}
+
+		msg, setErr, err := c.storeEmail(mb, data, keywords, receivedAt)
+		if err != nil {
+			return nil, err
+		}
+		if setErr != nil {
+			resp.NotCreated[cid] = setErr
+			continue
+		}
+		c.created[cid] = formatID(msg.ID)
+		resp.Created[cid] = createdEmail(msg)
+	}
+
+	state, err := c.state(domain.ChangeTypeEmail)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"accountId":  c.accountID,
+		"oldState":   resp.OldState,
+		"newState":   state,
+		"created":    resp.Created,
+		"notCreated": resp.NotCreated,
+	}, nil
+}
@@ -0,0 +1,592 @@
+package jmap
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/api/middleware"
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/repository"
+	"github.com/btafoya/gomailserver/internal/service"
+)
+
+const (
+	// BlobRetention is how long uploads are kept before they must be used
+	BlobRetention = 24 * time.Hour
+
+	// DefaultBlobExpiryInterval is how often expired uploads are removed
+	DefaultBlobExpiryInterval = time.Hour
+)
+
+// Handler serves the JMAP session resource, API, blob transfer and push endpoints
+// Every route expects the user set by the API authentication middleware.
+type Handler struct {
+	userService       *service.UserService
+	mailboxService    *service.MailboxService
+	messageService    *service.MessageService
+	changes           *service.ChangeService
+	submissionService *service.SubmissionService
+	quota             *service.QuotaService
+	blobRepo          repository.BlobRepository
+	submissionRepo    repository.EmailSubmissionRepository
+	logger            *zap.Logger
+
+	methods map[string]methodSpec
+}
+
+// method handles one method call and returns its response arguments
+type method func(c *call, args json.RawMessage) (interface{}, error)
+
+// methodSpec is a method and the capability a request must use to call it
+type methodSpec struct {
+	capability string
+	fn         method
+}
+
+// NewHandler creates a new JMAP handler
+func NewHandler(
+	userService *service.UserService,
+	mailboxService *service.MailboxService,
+	messageService *service.MessageService,
+	changes *service.ChangeService,
+	submissionService *service.SubmissionService,
+	blobRepo repository.BlobRepository,
+	submissionRepo repository.EmailSubmissionRepository,
+	logger *zap.Logger,
+) *Handler {
+	h := &Handler{
+		userService:       userService,
+		mailboxService:    mailboxService,
+		messageService:    messageService,
+		changes:           changes,
+		submissionService: submissionService,
+		blobRepo:          blobRepo,
+		submissionRepo:    submissionRepo,
+		logger:            logger,
+	}
+
+	h.methods = map[string]methodSpec{
+		"Core/echo":                    {CapabilityCore, coreEcho},
+		"Mailbox/get":                  {CapabilityMail, mailboxGet},
+		"Mailbox/changes":              {CapabilityMail, mailboxChanges},
+		"Mailbox/query":                {CapabilityMail, mailboxQuery},
+		"Mailbox/queryChanges":         {CapabilityMail, queryChanges},
+		"Mailbox/set":                  {CapabilityMail, mailboxSet},
+		"Thread/get":                   {CapabilityMail, threadGet},
+		"Thread/changes":               {CapabilityMail, threadChanges},
+		"Email/get":                    {CapabilityMail, emailGet},
+		"Email/changes":                {CapabilityMail, emailChanges},
+		"Email/query":                  {CapabilityMail, emailQuery},
+		"Email/queryChanges":           {CapabilityMail, queryChanges},
+		"Email/set":                    {CapabilityMail, emailSet},
+		"Email/import":                 {CapabilityMail, emailImport},
+		"SearchSnippet/get":            {CapabilityMail, searchSnippetGet},
+		"Identity/get":                 {CapabilitySubmission, identityGet},
+		"Identity/changes":             {CapabilitySubmission, identityChanges},
+		"EmailSubmission/get":          {CapabilitySubmission, submissionGet},
+		"EmailSubmission/changes":      {CapabilitySubmission, submissionChanges},
+		"EmailSubmission/query":        {CapabilitySubmission, submissionQuery},
+		"EmailSubmission/queryChanges": {CapabilitySubmission, queryChanges},
+		"EmailSubmission/set":          {CapabilitySubmission, submissionSet},
+	}
+
+	return h
+}
+
+// SetQuotaService enables quota checks on created and imported emails (optional)
+func (h *Handler) SetQuotaService(quota *service.QuotaService) {
+	h.quota = quota
+}
+
+// accountID returns the ID of a user's only account
+func accountID(userID int64) string {
+	return strconv.FormatInt(userID, 10)
+}
+
+// currentUser loads the authenticated user of a request
+func (h *Handler) currentUser(w http.ResponseWriter, r *http.Request) (*domain.User, bool) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		middleware.RespondError(w, http.StatusUnauthorized, "user not authenticated")
+		return nil, false
+	}
+	user, err := h.userService.GetByID(userID)
+	if err != nil {
+		h.logger.Error("failed to load JMAP user", zap.Error(err), zap.Int64("user_id", userID))
+		middleware.RespondError(w, http.StatusUnauthorized, "user not found")
+		return nil, false
+	}
+	return user, true
+}
+
+// WellKnown redirects to the session resource (RFC 8620 section 2.2)
+func (h *Handler) WellKnown(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/jmap/session", http.StatusTemporaryRedirect)
+}
+
+// Session returns the session resource describing capabilities, accounts and URLs
+func (h *Handler) Session(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	middleware.RespondJSON(w, http.StatusOK, h.session(user, baseURL(r)))
+}
+
+// session builds the session resource for a user
+func (h *Handler) session(user *domain.User, base string) map[string]interface{} {
+	id := accountID(user.ID)
+	session := map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			CapabilityCore: map[string]interface{}{
+				"maxSizeUpload":         maxSizeUpload,
+				"maxConcurrentUpload":   maxConcurrentUpload,
+				"maxSizeRequest":        maxSizeRequest,
+				"maxConcurrentRequests": maxConcurrentRequests,
+				"maxCallsInRequest":     maxCallsInRequest,
+				"maxObjectsInGet":       maxObjectsInGet,
+				"maxObjectsInSet":       maxObjectsInSet,
+				"collationAlgorithms":   []string{"i;ascii-casemap"},
+			},
+			CapabilityMail:       map[string]interface{}{},
+			CapabilitySubmission: map[string]interface{}{},
+		},
+		"accounts": map[string]interface{}{
+			id: map[string]interface{}{
+				"name":       user.Email,
+				"isPersonal": true,
+				"isReadOnly": false,
+				"accountCapabilities": map[string]interface{}{
+					CapabilityMail: map[string]interface{}{
+						"maxMailboxesPerEmail":       1,
+						"maxMailboxDepth":            nil,
+						"maxSizeMailboxName":         255,
+						"maxSizeAttachmentsPerEmail": maxSizeUpload,
+						"emailQuerySortOptions":      emailSortProperties,
+						"mayCreateTopLevelMailbox":   true,
+					},
+					CapabilitySubmission: map[string]interface{}{
+						"maxDelayedSend":       0,
+						"submissionExtensions": map[string]interface{}{},
+					},
+				},
+			},
+		},
+		"primaryAccounts": map[string]interface{}{
+			CapabilityMail:       id,
+			CapabilitySubmission: id,
+		},
+		"username":       user.Email,
+		"apiUrl":         base + "/jmap/api",
+		"downloadUrl":    base + "/jmap/download/{accountId}/{blobId}/{name}?type={type}",
+		"uploadUrl":      base + "/jmap/upload/{accountId}/",
+		"eventSourceUrl": base + "/jmap/eventsource?types={types}&closeafter={closeafter}&ping={ping}",
+	}
+	session["state"] = sessionState(user)
+	return session
+}
+
+// sessionState changes whenever the session resource of a user would change
+func sessionState(user *domain.User) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s", user.ID, user.Email)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// baseURL returns the scheme and host the client used to reach the server
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// writeProblem responds with a request-level error
+func writeProblem(w http.ResponseWriter, status int, errType, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem{Type: errType, Status: status, Detail: detail})
+}
+
+// API processes a batch of method calls (RFC 8620 section 3)
+func (h *Handler) API(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSizeRequest+1))
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "urn:ietf:params:jmap:error:notRequest", "failed to read request")
+		return
+	}
+	if len(body) > maxSizeRequest {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(problem{Type: "urn:ietf:params:jmap:error:limit", Status: http.StatusBadRequest, Limit: "maxSizeRequest"})
+		return
+	}
+
+	var req Request
+	if !json.Valid(body) {
+		writeProblem(w, http.StatusBadRequest, "urn:ietf:params:jmap:error:notJSON", "request is not valid JSON")
+		return
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.Using == nil || req.MethodCalls == nil {
+		writeProblem(w, http.StatusBadRequest, "urn:ietf:params:jmap:error:notRequest", "request does not match the Request type")
+		return
+	}
+	if len(req.MethodCalls) > maxCallsInRequest {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(problem{Type: "urn:ietf:params:jmap:error:limit", Status: http.StatusBadRequest, Limit: "maxCallsInRequest"})
+		return
+	}
+
+	using := make(map[string]bool, len(req.Using))
+	for _, capability := range req.Using {
+		switch capability {
+		case CapabilityCore, CapabilityMail, CapabilitySubmission:
+			using[capability] = true
+		default:
+			writeProblem(w, http.StatusBadRequest, "urn:ietf:params:jmap:error:unknownCapability", "unknown capability "+capability)
+			return
+		}
+	}
+
+	resp := h.process(r.Context(), user, using, &req)
+	middleware.RespondJSON(w, http.StatusOK, resp)
+}
+
+// process runs the method calls of a request in order
+func (h *Handler) process(ctx context.Context, user *domain.User, using map[string]bool, req *Request) *Response {
+	created := req.CreatedIDs
+	if created == nil {
+		created = make(map[string]string)
+	}
+
+	responses := make([]Invocation, 0, len(req.MethodCalls))
+	for _, inv := range req.MethodCalls {
+		c := &call{
+			ctx:       ctx,
+			h:         h,
+			user:      user,
+			accountID: accountID(user.ID),
+			created:   created,
+			callID:    inv.CallID,
+		}
+
+		result, err := h.invoke(c, inv, using, responses)
+		responses = append(responses, h.response(inv, result, err))
+		responses = append(responses, c.extra...)
+	}
+
+	resp := &Response{MethodResponses: responses, SessionState: sessionState(user)}
+	if req.CreatedIDs != nil {
+		resp.CreatedIDs = created
+	}
+	return resp
+}
+
+// invoke resolves references in a method call's arguments and calls it
+func (h *Handler) invoke(c *call, inv Invocation, using map[string]bool, responses []Invocation) (interface{}, error) {
+	spec, ok := h.methods[inv.Name]
+	if !ok || !using[spec.capability] {
+		return nil, errUnknownMethod
+	}
+	args, err := resolveReferences(inv.Args, responses)
+	if err != nil {
+		return nil, err
+	}
+	return spec.fn(c, args)
+}
+
+// response turns a method result into its response invocation
+func (h *Handler) response(inv Invocation, result interface{}, err error) Invocation {
+	if err != nil {
+		var methodErr *MethodError
+		if !errors.As(err, &methodErr) {
+			h.logger.Error("JMAP method failed", zap.Error(err), zap.String("method", inv.Name))
+			methodErr = errServerFail
+		}
+		args, _ := json.Marshal(methodErr)
+		return Invocation{Name: "error", Args: args, CallID: inv.CallID}
+	}
+
+	args, err := json.Marshal(result)
+	if err != nil {
+		h.logger.Error("failed to encode JMAP response", zap.Error(err), zap.String("method", inv.Name))
+		args, _ = json.Marshal(errServerFail)
+		return Invocation{Name: "error", Args: args, CallID: inv.CallID}
+	}
+	return Invocation{Name: inv.Name, Args: args, CallID: inv.CallID}
+}
+
+// Upload stores a blob for later use in Email/import or attachments (RFC 8620 section 6.1)
+func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if chi.URLParam(r, "accountId") != accountID(user.ID) {
+		writeProblem(w, http.StatusNotFound, "about:blank", "account not found")
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxSizeUpload+1))
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "about:blank", "failed to read upload")
+		return
+	}
+	if len(data) > maxSizeUpload {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(problem{Type: "urn:ietf:params:jmap:error:limit", Status: http.StatusRequestEntityTooLarge, Limit: "maxSizeUpload"})
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	sum := sha256.Sum256(data)
+	blob := &domain.Blob{
+		ID:     "U" + hex.EncodeToString(sum[:]),
+		UserID: user.ID,
+		Type:   contentType,
+		Size:   int64(len(data)),
+		Data:   data,
+	}
+	if err := h.blobRepo.Create(blob); err != nil {
+		h.logger.Error("failed to store JMAP upload", zap.Error(err), zap.Int64("user_id", user.ID))
+		middleware.RespondError(w, http.StatusInternalServerError, "failed to store upload")
+		return
+	}
+
+	middleware.RespondJSON(w, http.StatusCreated, map[string]interface{}{
+		"accountId": accountID(user.ID),
+		"blobId":    blob.ID,
+		"type":      blob.Type,
+		"size":      blob.Size,
+	})
+}
+
+// Download returns the content of a blob (RFC 8620 section 6.2)
+func (h *Handler) Download(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if chi.URLParam(r, "accountId") != accountID(user.ID) {
+		writeProblem(w, http.StatusNotFound, "about:blank", "account not found")
+		return
+	}
+
+	data, blobType, err := h.blob(user.ID, chi.URLParam(r, "blobId"))
+	if err != nil {
+		writeProblem(w, http.StatusNotFound, "about:blank", "blob not found")
+		return
+	}
+
+	if t := r.URL.Query().Get("type"); t != "" {
+		blobType = t
+	}
+	name := strings.NewReplacer(`"`, "", "\r", "", "\n", "").Replace(chi.URLParam(r, "name"))
+	w.Header().Set("Content-Type", blobType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	w.Header().Set("Cache-Control", "private, immutable, max-age=31536000")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// blob returns the content and type of one of a user's blobs
+// Uploads are "U" blobs; "M<id>" is a stored message and "M<id>-<part>" one of its body parts.
+func (h *Handler) blob(userID int64, blobID string) ([]byte, string, error) {
+	if strings.HasPrefix(blobID, "U") {
+		blob, err := h.blobRepo.Get(userID, blobID)
+		if err != nil {
+			return nil, "", err
+		}
+		return blob.Data, blob.Type, nil
+	}
+
+	msgID, partID, ok := parseMessageBlobID(blobID)
+	if !ok {
+		return nil, "", errors.New("invalid blob ID")
+	}
+	msg, err := h.message(userID, msgID)
+	if err != nil {
+		return nil, "", err
+	}
+	if partID == "" {
+		return msg.Content, "message/rfc822", nil
+	}
+
+	part, err := findPart(msg.Content, partID)
+	if err != nil {
+		return nil, "", err
+	}
+	return part.data, part.contentType, nil
+}
+
+// message loads one of a user's messages with its content
+func (h *Handler) message(userID, id int64) (*domain.Message, error) {
+	msg, err := h.messageService.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if msg.UserID != userID {
+		return nil, errors.New("message not found")
+	}
+	return msg, nil
+}
+
+// EventSource pushes state changes to the client (RFC 8620 section 7.3)
+func (h *Handler) EventSource(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	types := pushTypes(query.Get("types"))
+	closeAfterState := query.Get("closeafter") == "state"
+	ping, _ := strconv.Atoi(query.Get("ping"))
+	if ping > 0 && ping < 30 {
+		ping = 30
+	}
+
+	// Streams outlive the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		h.logger.Warn("failed to clear event source write deadline", zap.Error(err))
+	}
+
+	notifications, unsubscribe := h.changes.Subscribe(user.ID)
+	defer unsubscribe()
+
+	states, err := h.pushStates(user.ID, types)
+	if err != nil {
+		middleware.RespondError(w, http.StatusInternalServerError, "failed to get states")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	var pings <-chan time.Time
+	if ping > 0 {
+		ticker := time.NewTicker(time.Duration(ping) * time.Second)
+		defer ticker.Stop()
+		pings = ticker.C
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-pings:
+			fmt.Fprintf(w, "event: ping\ndata: {\"interval\":%d}\n\n", ping)
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-notifications:
+			current, err := h.pushStates(user.ID, types)
+			if err != nil {
+				h.logger.Error("failed to get push states", zap.Error(err), zap.Int64("user_id", user.ID))
+				continue
+			}
+			changed := make(map[string]string)
+			for t, state := range current {
+				if states[t] != state {
+					changed[t] = state
+				}
+			}
+			states = current
+			if len(changed) == 0 {
+				continue
+			}
+
+			data, _ := json.Marshal(map[string]interface{}{
+				"@type":   "StateChange",
+				"changed": map[string]interface{}{accountID(user.ID): changed},
+			})
+			fmt.Fprintf(w, "event: state\ndata: %s\n\n", data)
+			if err := rc.Flush(); err != nil || closeAfterState {
+				return
+			}
+		}
+	}
+}
+
+// pushableTypes are the data types whose state changes are pushed
+var pushableTypes = []string{
+	domain.ChangeTypeMailbox,
+	domain.ChangeTypeEmail,
+	domain.ChangeTypeThread,
+	domain.ChangeTypeEmailSubmission,
+}
+
+// pushTypes parses the types parameter of an event source request
+func pushTypes(param string) []string {
+	if param == "" || param == "*" {
+		return pushableTypes
+	}
+	var types []string
+	for _, t := range strings.Split(param, ",") {
+		for _, known := range pushableTypes {
+			if t == known {
+				types = append(types, t)
+			}
+		}
+	}
+	return types
+}
+
+// pushStates returns the current state of each type
+func (h *Handler) pushStates(userID int64, types []string) (map[string]string, error) {
+	states := make(map[string]string, len(types))
+	for _, t := range types {
+		state, err := h.changes.State(userID, t)
+		if err != nil {
+			return nil, err
+		}
+		states[t] = state
+	}
+	return states, nil
+}
+
+// RunJanitor removes expired uploads every interval until ctx is cancelled
+func (h *Handler) RunJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if removed, err := h.blobRepo.DeleteExpired(time.Now().Add(-BlobRetention)); err != nil {
+			h.logger.Error("failed to remove expired JMAP uploads", zap.Error(err))
+		} else {
+			h.logger.Debug("expired JMAP uploads removed", zap.Int64("removed", removed))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,641 @@
+package jmap
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+// mailboxDelimiter separates the levels of a mailbox name, as in IMAP
+const mailboxDelimiter = "/"
+
+// mailboxRoles maps special-use attributes to Mailbox roles (RFC 8621 section 2)
+var mailboxRoles = map[string]string{
+	"\\Archive": "archive",
+	"\\Drafts":  "drafts",
+	"\\Junk":    "junk",
+	"\\Sent":    "sent",
+	"\\Trash":   "trash",
+}
+
+// mailboxRole returns the role of a mailbox, or nil
+func mailboxRole(mb *domain.Mailbox) interface{} {
+	if strings.EqualFold(mb.Name, "INBOX") {
+		return "inbox"
+	}
+	if role, ok := mailboxRoles[mb.SpecialUse]; ok {
+		return role
+	}
+	return nil
+}
+
+// roleSpecialUse returns the special-use attribute for a role
+func roleSpecialUse(role string) (string, bool) {
+	for use, r := range mailboxRoles {
+		if r == role {
+			return use, true
+		}
+	}
+	return "", false
+}
+
+// parseID parses a numeric object ID
+func parseID(id string) (int64, bool) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	return n, err == nil && n > 0
+}
+
+// mailboxTree is a user's mailboxes indexed by ID and full name
+type mailboxTree struct {
+	list   []*domain.Mailbox
+	byID   map[int64]*domain.Mailbox
+	byName map[string]*domain.Mailbox
+}
+
+// mailboxes loads the user's mailboxes
+func (c *call) mailboxes() (*mailboxTree, error) {
+	list, err := c.h.mailboxService.List(c.user.ID, false)
+	if err != nil {
+		return nil, err
+	}
+	t := &mailboxTree{
+		list:   list,
+		byID:   make(map[int64]*domain.Mailbox, len(list)),
+		byName: make(map[string]*domain.Mailbox, len(list)),
+	}
+	for _, mb := range list {
+		t.byID[mb.ID] = mb
+		t.byName[mb.Name] = mb
+	}
+	return t, nil
+}
+
+// lookup returns the mailbox with a JMAP ID
+func (t *mailboxTree) lookup(id string) *domain.Mailbox {
+	n, ok := parseID(id)
+	if !ok {
+		return nil
+	}
+	return t.byID[n]
+}
+
+// parent returns the parent of a mailbox, or nil for a top-level mailbox
+func (t *mailboxTree) parent(mb *domain.Mailbox) *domain.Mailbox {
+	i := strings.LastIndex(mb.Name, mailboxDelimiter)
+	if i < 0 {
+		return nil
+	}
+	return t.byName[mb.Name[:i]]
+}
+
+// hasChildren reports whether a mailbox has child mailboxes
+func (t *mailboxTree) hasChildren(mb *domain.Mailbox) bool {
+	prefix := mb.Name + mailboxDelimiter
+	for _, other := range t.list {
+		if strings.HasPrefix(other.Name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// leafName returns the last level of a mailbox name
+func leafName(name string) string {
+	return name[strings.LastIndex(name, mailboxDelimiter)+1:]
+}
+
+// mailboxCounts are the message and thread counts of a mailbox
+type mailboxCounts struct {
+	totalEmails, unreadEmails, totalThreads, unreadThreads int
+}
+
+// counts computes the counts of a mailbox
+func (c *call) counts(mb *domain.Mailbox) (*mailboxCounts, error) {
+	messages, err := c.h.messageService.List(mb.ID)
+	if err != nil {
+		return nil, err
+	}
+	counts := &mailboxCounts{totalEmails: len(messages)}
+	threads := make(map[string]bool)
+	unreadThreads := make(map[string]bool)
+	for _, msg := range messages {
+		threads[msg.ThreadID] = true
+		if !hasFlag(msg.Flags, "\\Seen") {
+			counts.unreadEmails++
+			unreadThreads[msg.ThreadID] = true
+		}
+	}
+	counts.totalThreads = len(threads)
+	counts.unreadThreads = len(unreadThreads)
+	return counts, nil
+}
+
+// mailboxObject builds the JMAP representation of a mailbox
+func (c *call) mailboxObject(t *mailboxTree, mb *domain.Mailbox) (map[string]interface{}, error) {
+	counts, err := c.counts(mb)
+	if err != nil {
+		return nil, err
+	}
+
+	var parentID interface{}
+	if parent := t.parent(mb); parent != nil {
+		parentID = formatID(parent.ID)
+	}
+	isInbox := strings.EqualFold(mb.Name, "INBOX")
+
+	return map[string]interface{}{
+		"id":            formatID(mb.ID),
+		"name":          leafName(mb.Name),
+		"parentId":      parentID,
+		"role":          mailboxRole(mb),
+		"sortOrder":     0,
+		"totalEmails":   counts.totalEmails,
+		"unreadEmails":  counts.unreadEmails,
+		"totalThreads":  counts.totalThreads,
+		"unreadThreads": counts.unreadThreads,
+		"myRights": map[string]bool{
+			"mayReadItems":   true,
+			"mayAddItems":    true,
+			"mayRemoveItems": true,
+			"maySetSeen":     true,
+			"maySetKeywords": true,
+			"mayCreateChild": true,
+			"mayRename":      !isInbox,
+			"mayDelete":      !isInbox,
+			"maySubmit":      true,
+		},
+		"isSubscribed": mb.Subscribed,
+	}, nil
+}
+
+func mailboxGet(c *call, args json.RawMessage) (interface{}, error) {
+	var a getArgs
+	if err := c.decode(args, &a); err != nil {
+		return nil, err
+	}
+	if a.tooMany() {
+		return nil, errRequestTooLarge
+	}
+	state, err := c.state(domain.ChangeTypeMailbox)
+	if err != nil {
+		return nil, err
+	}
+	t, err := c.mailboxes()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &getResponse{AccountID: c.accountID, State: state, List: make([]map[string]interface{}, 0), NotFound: make([]string, 0)}
+	selected := t.list
+	if ids := a.ids(); ids != nil {
+		selected = nil
+		for _, id := range ids {
+			resolved, _ := c.resolveID(id)
+			mb := t.lookup(resolved)
+			if mb == nil {
+				resp.NotFound = append(resp.NotFound, id)
+				continue
+			}
+			selected = append(selected, mb)
+		}
+	}
+
+	for _, mb := range selected {
+		object, err := c.mailboxObject(t, mb)
+		if err != nil {
+			return nil, err
+		}
+		resp.List = append(resp.List, project(object, a.Properties))
+	}
+	return resp, nil
+}
+
+func mailboxChanges(c *call, args json.RawMessage) (interface{}, error) {
+	resp, err := c.changes(args, domain.ChangeTypeMailbox, sameID)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		*changesResponse
+		UpdatedProperties []string `json:"updatedProperties"`
+	}{changesResponse: resp}, nil
+}
+
+// mailboxFilter is a Mailbox/query FilterCondition (RFC 8621 section 2.3)
+type mailboxFilter struct {
+	ParentID     nullable `json:"parentId"`
+	Name         *string  `json:"name"`
+	Role         nullable `json:"role"`
+	HasAnyRole   *bool    `json:"hasAnyRole"`
+	IsSubscribed *bool    `json:"isSubscribed"`
+	Operator     *string  `json:"operator"`
+}
+
+// nullable is a property that can be left out, null or a string
+type nullable struct {
+	set   bool
+	value *string
+}
+
+// UnmarshalJSON records that the property was given
+func (n *nullable) UnmarshalJSON(data []byte) error {
+	n.set = true
+	return json.Unmarshal(data, &n.value)
+}
+
+// comparator is one sort criterion of a /query call
+type comparator struct {
+	Property    string `json:"property"`
+	IsAscending *bool  `json:"isAscending"`
+	Collation   string `json:"collation"`
+	Keyword     string `json:"keyword"`
+}
+
+// ascending reports the sort direction, which defaults to ascending
+func (s comparator) ascending() bool {
+	return s.IsAscending == nil || *s.IsAscending
+}
+
+func mailboxQuery(c *call, args json.RawMessage) (interface{}, error) {
+	var a struct {
+		queryArgs
+		Filter *mailboxFilter `json:"filter"`
+		Sort   []comparator   `json:"sort"`
+	}
+	if err := c.decode(args, &a); err != nil {
+		return nil, err
+	}
+	if a.Filter != nil && a.Filter.Operator != nil {
+		return nil, errUnsupportedFilter
+	}
+	state, err := c.state(domain.ChangeTypeMailbox)
+	if err != nil {
+		return nil, err
+	}
+	t, err := c.mailboxes()
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]*domain.Mailbox, 0, len(t.list))
+	for _, mb := range t.list {
+		if a.Filter == nil || a.Filter.match(t, mb) {
+			matches = append(matches, mb)
+		}
+	}
+
+	for _, s := range a.Sort {
+		if s.Property != "name" && s.Property != "sortOrder" {
+			return nil, errUnsupportedSort
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		for _, s := range a.Sort {
+			if s.Property != "name" {
+				continue // every sortOrder is 0
+			}
+			x, y := strings.ToLower(leafName(matches[i].Name)), strings.ToLower(leafName(matches[j].Name))
+			if x != y {
+				return (x < y) == s.ascending()
+			}
+		}
+		return false
+	})
+
+	ids := make([]string, len(matches))
+	for i, mb := range matches {
+		ids[i] = formatID(mb.ID)
+	}
+	return a.page(ids, state, c.accountID)
+}
+
+// match reports whether a mailbox matches every condition of the filter
+func (f *mailboxFilter) match(t *mailboxTree, mb *domain.Mailbox) bool {
+	if f.ParentID.set {
+		parent := t.parent(mb)
+		if (f.ParentID.value != nil) != (parent != nil) || (parent != nil && formatID(parent.ID) != *f.ParentID.value) {
+			return false
+		}
+	}
+	if f.Name != nil && !strings.Contains(strings.ToLower(leafName(mb.Name)), strings.ToLower(*f.Name)) {
+		return false
+	}
+	role := mailboxRole(mb)
+	if f.Role.set {
+		if (f.Role.value != nil) != (role != nil) || (role != nil && role != *f.Role.value) {
+			return false
+		}
+	}
+	if f.HasAnyRole != nil && *f.HasAnyRole != (role != nil) {
+		return false
+	}
+	if f.IsSubscribed != nil && *f.IsSubscribed != mb.Subscribed {
+		return false
+	}
+	return true
+}
+
+// mailboxCreate holds the properties of a mailbox to create
+type mailboxCreate struct {
+	Name         *string `json:"name"`
+	ParentID     *string `json:"parentId"`
+	Role         *string `json:"role"`
+	IsSubscribed *bool   `json:"isSubscribed"`
+	SortOrder    *int    `json:"sortOrder"`
+}
+
+func mailboxSet(c *call, args json.RawMessage) (interface{}, error) {
+	var a struct {
+		setArgs
+		OnDestroyRemoveEmails bool `json:"onDestroyRemoveEmails"`
+	}
+	if err := c.decode(args, &a); err != nil {
+		return nil, err
+	}
+	resp, err := c.newSetResponse(&a.setArgs, domain.ChangeTypeMailbox)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parents created in the same call must exist before their children
+	pending := make(map[string]*mailboxCreate, len(a.Create))
+	for cid, raw := range a.Create {
+		var props mailboxCreate
+		if err := json.Unmarshal(raw, &props); err != nil {
+			resp.NotCreated[cid] = invalidProperties(err.Error())
+			continue
+		}
+		pending[cid] = &props
+	}
+	for len(pending) > 0 {
+		progress := false
+		for cid, props := range pending {
+			if props.ParentID != nil && strings.HasPrefix(*props.ParentID, "#") {
+				if _, ok := pending[(*props.ParentID)[1:]]; ok {
+					continue
+				}
+			}
+			delete(pending, cid)
+			progress = true
+			object, setErr, err := c.createMailbox(props)
+			if err != nil {
+				return nil, err
+			}
+			if setErr != nil {
+				resp.NotCreated[cid] = setErr
+				continue
+			}
+			c.created[cid] = object["id"].(string)
+			resp.Created[cid] = object
+		}
+		if !progress {
+			for cid := range pending {
+				resp.NotCreated[cid] = invalidProperties("parent creation cycle", "parentId")
+			}
+			break
+		}
+	}
+
+	for id, patch := range a.Update {
+		setErr, err := c.updateMailbox(id, patch)
+		if err != nil {
+			return nil, err
+		}
+		if setErr != nil {
+			resp.NotUpdated[id] = setErr
+			continue
+		}
+		resp.Updated[id] = nil
+	}
+
+	for _, id := range a.Destroy {
+		resolved, _ := c.resolveID(id)
+		setErr, err := c.destroyMailbox(resolved, a.OnDestroyRemoveEmails)
+		if err != nil {
+			return nil, err
+		}
+		if setErr != nil {
+			resp.NotDestroyed[id] = setErr
+			continue
+		}
+		resp.Destroyed = append(resp.Destroyed, id)
+	}
+
+	return c.finish(resp, domain.ChangeTypeMailbox)
+}
+
+// fullName validates a mailbox name and joins it to its parent's name
+func (c *call) fullName(t *mailboxTree, name string, parentID *string) (string, *SetError) {
+	if name == "" || strings.Contains(name, mailboxDelimiter) || len(name) > 255 {
+		return "", invalidProperties("invalid mailbox name", "name")
+	}
+	if parentID == nil {
+		return name, nil
+	}
+	resolved, ok := c.resolveID(*parentID)
+	parent := t.lookup(resolved)
+	if !ok || parent == nil {
+		return "", invalidProperties("parent mailbox not found", "parentId")
+	}
+	return parent.Name + mailboxDelimiter + name, nil
+}
+
+// createMailbox creates one mailbox of a Mailbox/set call
+func (c *call) createMailbox(props *mailboxCreate) (map[string]interface{}, *SetError, error) {
+	t, err := c.mailboxes()
+	if err != nil {
+		return nil, nil, err
+	}
+	if props.Name == nil {
+		return nil, invalidProperties("name is required", "name"), nil
+	}
+	name, setErr := c.fullName(t, *props.Name, props.ParentID)
+	if setErr != nil {
+		return nil, setErr, nil
+	}
+	if t.byName[name] != nil {
+		return nil, invalidProperties("a mailbox with this name already exists", "name"), nil
+	}
+
+	specialUse := ""
+	if props.Role != nil {
+		var ok bool
+		if specialUse, ok = roleSpecialUse(*props.Role); !ok {
+			return nil, invalidProperties("unsupported role", "role"), nil
+		}
+	}
+
+	if err := c.h.mailboxService.Create(c.user.ID, name, specialUse); err != nil {
+		return nil, nil, err
+	}
+	mb, err := c.h.mailboxService.GetByName(c.user.ID, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if props.IsSubscribed != nil && !*props.IsSubscribed {
+		if err := c.h.mailboxService.UpdateSubscription(mb.ID, false); err != nil {
+			return nil, nil, err
+		}
+		mb.Subscribed = false
+	}
+
+	t.list = append(t.list, mb)
+	t.byID[mb.ID] = mb
+	t.byName[mb.Name] = mb
+	object, err := c.mailboxObject(t, mb)
+	if err != nil {
+		return nil, nil, err
+	}
+	delete(object, "name")
+	delete(object, "parentId")
+	return object, nil, nil
+}
+
+// updateMailbox applies one update of a Mailbox/set call
+func (c *call) updateMailbox(id string, patch map[string]json.RawMessage) (*SetError, error) {
+	t, err := c.mailboxes()
+	if err != nil {
+		return nil, err
+	}
+	mb := t.lookup(id)
+	if mb == nil {
+		return setError("notFound", "mailbox not found"), nil
+	}
+
+	name := leafName(mb.Name)
+	var parentID *string
+	if parent := t.parent(mb); parent != nil {
+		p := formatID(parent.ID)
+		parentID = &p
+	}
+	rename := false
+
+	for property, raw := range patch {
+		switch property {
+		case "name":
+			if err := json.Unmarshal(raw, &name); err != nil {
+				return invalidProperties("invalid name", "name"), nil
+			}
+			rename = true
+		case "parentId":
+			parentID = nil
+			if err := json.Unmarshal(raw, &parentID); err != nil {
+				return invalidProperties("invalid parentId", "parentId"), nil
+			}
+			rename = true
+		case "role":
+			var role *string
+			if err := json.Unmarshal(raw, &role); err != nil {
+				return invalidProperties("invalid role", "role"), nil
+			}
+			specialUse := ""
+			if role != nil {
+				var ok bool
+				if specialUse, ok = roleSpecialUse(*role); !ok {
+					return invalidProperties("unsupported role", "role"), nil
+				}
+			}
+			if err := c.h.mailboxService.SetSpecialUse(mb.ID, specialUse); err != nil {
+				return nil, err
+			}
+		case "isSubscribed":
+			var subscribed bool
+			if err := json.Unmarshal(raw, &subscribed); err != nil {
+				return invalidProperties("invalid isSubscribed", "isSubscribed"), nil
+			}
+			if err := c.h.mailboxService.UpdateSubscription(mb.ID, subscribed); err != nil {
+				return nil, err
+			}
+		case "sortOrder":
+			var order int
+			if err := json.Unmarshal(raw, &order); err != nil || order != 0 {
+				return invalidProperties("sortOrder cannot be changed", "sortOrder"), nil
+			}
+		default:
+			return invalidProperties("property cannot be changed", property), nil
+		}
+	}
+
+	if !rename {
+		return nil, nil
+	}
+	newName, setErr := c.fullName(t, name, parentID)
+	if setErr != nil {
+		return setErr, nil
+	}
+	if newName == mb.Name {
+		return nil, nil
+	}
+	if strings.EqualFold(mb.Name, "INBOX") {
+		return setError("forbidden", "INBOX cannot be renamed"), nil
+	}
+	if strings.HasPrefix(newName, mb.Name+mailboxDelimiter) {
+		return invalidProperties("a mailbox cannot be moved below itself", "parentId"), nil
+	}
+	if t.byName[newName] != nil {
+		return invalidProperties("a mailbox with this name already exists", "name"), nil
+	}
+
+	// Children keep their place below the renamed mailbox
+	prefix := mb.Name + mailboxDelimiter
+	for _, child := range t.list {
+		if strings.HasPrefix(child.Name, prefix) {
+			if err := c.h.mailboxService.Rename(child.ID, newName+mailboxDelimiter+strings.TrimPrefix(child.Name, prefix)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := c.h.mailboxService.Rename(mb.ID, newName); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// destroyMailbox destroys one mailbox of a Mailbox/set call
+func (c *call) destroyMailbox(id string, removeEmails bool) (*SetError, error) {
+	t, err := c.mailboxes()
+	if err != nil {
+		return nil, err
+	}
+	mb := t.lookup(id)
+	if mb == nil {
+		return setError("notFound", "mailbox not found"), nil
+	}
+	if strings.EqualFold(mb.Name, "INBOX") {
+		return setError("forbidden", "INBOX cannot be destroyed"), nil
+	}
+	if t.hasChildren(mb) {
+		return setError("mailboxHasChild", "mailbox has child mailboxes"), nil
+	}
+	if !removeEmails {
+		messages, err := c.h.messageService.List(mb.ID)
+		if err != nil {
+			return nil, err
+		}
+		if len(messages) > 0 {
+			return setError("mailboxHasEmail", "mailbox is not empty"), nil
+		}
+	}
+	if err := c.h.mailboxService.Delete(mb.ID); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// formatID formats a database ID as a JMAP ID
+func formatID(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
+
+// hasFlag reports whether a space-separated flag list contains a flag
+func hasFlag(flags, flag string) bool {
+	for _, f := range strings.Fields(flags) {
+		if strings.EqualFold(f, flag) {
+			return true
+		}
+	}
+	return false
+}
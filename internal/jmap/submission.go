@@ -0,0 +1,569 @@
+package jmap
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/service"
+)
+
+// identity is an address the user may send as (RFC 8621 section 6)
+type identity struct {
+	id    string
+	name  string
+	email string
+}
+
+// matches reports whether an address may be used as the identity's From
+// An identity of the form "*@domain" matches every address of the domain.
+func (i *identity) matches(addr string) bool {
+	addr = service.NormalizeAddress(addr)
+	if strings.HasPrefix(i.email, "*@") {
+		return strings.HasSuffix(addr, i.email[1:])
+	}
+	return addr == i.email
+}
+
+// identities lists the user's identities and the state of that list
+func (c *call) identities() ([]*identity, string, error) {
+	addresses, err := c.h.submissionService.SenderAddresses(c.user)
+	if err != nil {
+		return nil, "", err
+	}
+	name := c.user.FullName
+	if name == "" {
+		name = c.user.DisplayName
+	}
+
+	list := make([]*identity, len(addresses))
+	for i, addr := range addresses {
+		sum := sha256.Sum256([]byte(addr))
+		list[i] = &identity{id: "I" + hex.EncodeToString(sum[:8]), name: name, email: addr}
+	}
+	sum := sha256.Sum256([]byte(name + "\n" + strings.Join(addresses, "\n")))
+	return list, hex.EncodeToString(sum[:8]), nil
+}
+
+func identityGet(c *call, args json.RawMessage) (interface{}, error) {
+	var a getArgs
+	if err := c.decode(args, &a); err != nil {
+		return nil, err
+	}
+	if a.tooMany() {
+		return nil, errRequestTooLarge
+	}
+	identities, state, err := c.identities()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &getResponse{AccountID: c.accountID, State: state, List: make([]map[string]interface{}, 0), NotFound: make([]string, 0)}
+	for _, ident := range identities {
+		if ids := a.ids(); ids != nil && !contains(ids, ident.id) {
+			continue
+		}
+		resp.List = append(resp.List, project(map[string]interface{}{
+			"id":            ident.id,
+			"name":          ident.name,
+			"email":         ident.email,
+			"replyTo":       nil,
+			"bcc":           nil,
+			"textSignature": "",
+			"htmlSignature": "",
+			"mayDelete":     false,
+		}, a.Properties))
+	}
+	for _, id := range a.ids() {
+		found := false
+		for _, ident := range identities {
+			found = found || ident.id == id
+		}
+		if !found {
+			resp.NotFound = append(resp.NotFound, id)
+		}
+	}
+	return resp, nil
+}
+
+// identityChanges can only report that nothing changed; identities follow
+// aliases and sender grants, which are not in the change log
+func identityChanges(c *call, args json.RawMessage) (interface{}, error) {
+	var a changesArgs
+	if err := c.decode(args, &a); err != nil {
+		return nil, err
+	}
+	_, state, err := c.identities()
+	if err != nil {
+		return nil, err
+	}
+	if a.SinceState != state {
+		return nil, errCannotCalculateChanges
+	}
+	return &changesResponse{
+		AccountID: c.accountID,
+		OldState:  state,
+		NewState:  state,
+		Created:   make([]string, 0),
+		Updated:   make([]string, 0),
+		Destroyed: make([]string, 0),
+	}, nil
+}
+
+// submissionObject builds the JMAP representation of a submission
+func submissionObject(s *domain.EmailSubmission) map[string]interface{} {
+	rcptTo := make([]map[string]interface{}, len(s.RcptTo))
+	for i, rcpt := range s.RcptTo {
+		rcptTo[i] = map[string]interface{}{"email": rcpt, "parameters": nil}
+	}
+	return map[string]interface{}{
+		"id":         formatID(s.ID),
+		"identityId": s.IdentityID,
+		"emailId":    formatID(s.MessageID),
+		"threadId":   threadID(s.ThreadID),
+		"envelope": map[string]interface{}{
+			"mailFrom": map[string]interface{}{"email": s.MailFrom, "parameters": nil},
+			"rcptTo":   rcptTo,
+		},
+		"sendAt":         utcDate(s.SendAt),
+		"undoStatus":     s.UndoStatus,
+		"deliveryStatus": nil,
+		"dsnBlobIds":     []string{},
+		"mdnBlobIds":     []string{},
+	}
+}
+
+func submissionGet(c *call, args json.RawMessage) (interface{}, error) {
+	var a getArgs
+	if err := c.decode(args, &a); err != nil {
+		return nil, err
+	}
+	if a.tooMany() {
+		return nil, errRequestTooLarge
+	}
+	state, err := c.state(domain.ChangeTypeEmailSubmission)
+	if err != nil {
+		return nil, err
+	}
+	submissions, err := c.h.submissionRepo.List(c.user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]*domain.EmailSubmission, len(submissions))
+	for _, s := range submissions {
+		byID[formatID(s.ID)] = s
+	}
+	ids := a.ids()
+	if ids == nil {
+		if len(submissions) > maxObjectsInGet {
+			return nil, errRequestTooLarge
+		}
+		for _, s := range submissions {
+			ids = append(ids, formatID(s.ID))
+		}
+	}
+
+	resp := &getResponse{AccountID: c.accountID, State: state, List: make([]map[string]interface{}, 0), NotFound: make([]string, 0)}
+	for _, id := range ids {
+		resolved, _ := c.resolveID(id)
+		s, ok := byID[resolved]
+		if !ok {
+			resp.NotFound = append(resp.NotFound, id)
+			continue
+		}
+		resp.List = append(resp.List, project(submissionObject(s), a.Properties))
+	}
+	return resp, nil
+}
+
+func submissionChanges(c *call, args json.RawMessage) (interface{}, error) {
+	return c.changes(args, domain.ChangeTypeEmailSubmission, sameID)
+}
+
+// submissionFilter is an EmailSubmission/query FilterCondition (RFC 8621 section 7.3)
+type submissionFilter struct {
+	IdentityIDs []string   `json:"identityIds"`
+	EmailIDs    []string   `json:"emailIds"`
+	ThreadIDs   []string   `json:"threadIds"`
+	UndoStatus  *string    `json:"undoStatus"`
+	Before      *time.Time `json:"before"`
+	After       *time.Time `json:"after"`
+}
+
+// match reports whether a submission matches every condition of the filter
+func (f *submissionFilter) match(s *domain.EmailSubmission) bool {
+	if f.IdentityIDs != nil && !contains(f.IdentityIDs, s.IdentityID) {
+		return false
+	}
+	if f.EmailIDs != nil && !contains(f.EmailIDs, formatID(s.MessageID)) {
+		return false
+	}
+	if f.ThreadIDs != nil && !contains(f.ThreadIDs, threadID(s.ThreadID)) {
+		return false
+	}
+	if f.UndoStatus != nil && *f.UndoStatus != s.UndoStatus {
+		return false
+	}
+	if f.Before != nil && !s.SendAt.Before(*f.Before) {
+		return false
+	}
+	if f.After != nil && s.SendAt.Before(*f.After) {
+		return false
+	}
+	return true
+}
+
+func submissionQuery(c *call, args json.RawMessage) (interface{}, error) {
+	var a struct {
+		queryArgs
+		Filter json.RawMessage `json:"filter"`
+		Sort   []comparator    `json:"sort"`
+	}
+	if err := c.decode(args, &a); err != nil {
+		return nil, err
+	}
+	var filter *submissionFilter
+	if len(a.Filter) > 0 && string(a.Filter) != "null" {
+		dec := json.NewDecoder(bytes.NewReader(a.Filter))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&filter); err != nil {
+			return nil, errUnsupportedFilter
+		}
+	}
+	for _, s := range a.Sort {
+		if s.Property != "emailId" && s.Property != "threadId" && s.Property != "sentAt" {
+			return nil, errUnsupportedSort
+		}
+	}
+
+	state, err := c.state(domain.ChangeTypeEmailSubmission)
+	if err != nil {
+		return nil, err
+	}
+	submissions, err := c.h.submissionRepo.List(c.user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]*domain.EmailSubmission, 0, len(submissions))
+	for _, s := range submissions {
+		if filter == nil || filter.match(s) {
+			matches = append(matches, s)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		for _, s := range a.Sort {
+			result := 0
+			switch s.Property {
+			case "emailId":
+				result = compareInt64(matches[i].MessageID, matches[j].MessageID)
+			case "threadId":
+				result = strings.Compare(threadID(matches[i].ThreadID), threadID(matches[j].ThreadID))
+			case "sentAt":
+				result = matches[i].SendAt.Compare(matches[j].SendAt)
+			}
+			if result != 0 {
+				return (result < 0) == s.ascending()
+			}
+		}
+		return false
+	})
+
+	ids := make([]string, len(matches))
+	for i, s := range matches {
+		ids[i] = formatID(s.ID)
+	}
+	return a.page(ids, state, c.accountID)
+}
+
+// compareInt64 returns -1, 0 or 1
+func compareInt64(x, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// envelopeAddress is an Address of an Envelope (RFC 8621 section 7)
+type envelopeAddress struct {
+	Email      string                 `json:"email"`
+	Parameters map[string]interface{} `json:"parameters"`
+}
+
+// submissionCreate holds the properties of a submission to create
+type submissionCreate struct {
+	IdentityID string `json:"identityId"`
+	EmailID    string `json:"emailId"`
+	Envelope   *struct {
+		MailFrom envelopeAddress   `json:"mailFrom"`
+		RcptTo   []envelopeAddress `json:"rcptTo"`
+	} `json:"envelope"`
+}
+
+func submissionSet(c *call, args json.RawMessage) (interface{}, error) {
+	var a struct {
+		setArgs
+		OnSuccessUpdateEmail  map[string]map[string]json.RawMessage `json:"onSuccessUpdateEmail"`
+		OnSuccessDestroyEmail []string                              `json:"onSuccessDestroyEmail"`
+	}
+	if err := c.decode(args, &a); err != nil {
+		return nil, err
+	}
+	resp, err := c.newSetResponse(&a.setArgs, domain.ChangeTypeEmailSubmission)
+	if err != nil {
+		return nil, err
+	}
+	identities, _, err := c.identities()
+	if err != nil {
+		return nil, err
+	}
+
+	// Creation IDs of this call map to the email each submission sent
+	sentEmails := make(map[string]string)
+	for cid, raw := range a.Create {
+		submission, setErr, err := c.createSubmission(identities, raw)
+		if err != nil {
+			return nil, err
+		}
+		if setErr != nil {
+			resp.NotCreated[cid] = setErr
+			continue
+		}
+		id := formatID(submission.ID)
+		c.created[cid] = id
+		sentEmails["#"+cid] = formatID(submission.MessageID)
+		resp.Created[cid] = map[string]interface{}{
+			"id":         id,
+			"threadId":   threadID(submission.ThreadID),
+			"sendAt":     utcDate(submission.SendAt),
+			"undoStatus": submission.UndoStatus,
+		}
+	}
+
+	if len(a.Update) > 0 || len(a.Destroy) > 0 {
+		submissions, err := c.h.submissionRepo.List(c.user.ID)
+		if err != nil {
+			return nil, err
+		}
+		exists := make(map[string]bool, len(submissions))
+		for _, s := range submissions {
+			exists[formatID(s.ID)] = true
+		}
+
+		for id, patch := range a.Update {
+			resolved, _ := c.resolveID(id)
+			switch {
+			case !exists[resolved]:
+				resp.NotUpdated[id] = setError("notFound", "submission not found")
+			case patch["undoStatus"] != nil:
+				resp.NotUpdated[id] = setError("cannotUnsend", "the message has already been sent")
+			default:
+				resp.NotUpdated[id] = invalidProperties("only undoStatus can be changed")
+			}
+		}
+
+		for _, id := range a.Destroy {
+			resolved, _ := c.resolveID(id)
+			n, ok := parseID(resolved)
+			if !ok || !exists[resolved] {
+				resp.NotDestroyed[id] = setError("notFound", "submission not found")
+				continue
+			}
+			if err := c.h.submissionRepo.Delete(c.user.ID, n); err != nil {
+				return nil, err
+			}
+			c.h.changes.SubmissionChanged(c.user.ID, n, domain.ChangeDestroyed)
+			resp.Destroyed = append(resp.Destroyed, id)
+		}
+	}
+
+	result, err := c.finish(resp, domain.ChangeTypeEmailSubmission)
+	if err != nil {
+		return nil, err
+	}
+	c.onSuccess(sentEmails, a.OnSuccessUpdateEmail, a.OnSuccessDestroyEmail)
+	return result, nil
+}
+
+// onSuccess runs the implicit Email/set call of a successful EmailSubmission/set
+// (RFC 8621 section 7.5); its response follows the EmailSubmission/set response
+func (c *call) onSuccess(sentEmails map[string]string, update map[string]map[string]json.RawMessage, destroy []string) {
+	emailID := func(ref string) (string, bool) {
+		if strings.HasPrefix(ref, "#") {
+			id, ok := sentEmails[ref]
+			return id, ok
+		}
+		return "", false
+	}
+
+	var set setArgs
+	for ref, patch := range update {
+		if id, ok := emailID(ref); ok {
+			if set.Update == nil {
+				set.Update = make(map[string]map[string]json.RawMessage)
+			}
+			set.Update[id] = patch
+		}
+	}
+	for _, ref := range destroy {
+		if id, ok := emailID(ref); ok {
+			set.Destroy = append(set.Destroy, id)
+		}
+	}
+	if set.Update == nil && set.Destroy == nil {
+		return
+	}
+
+	args, err := json.Marshal(set)
+	if err != nil {
+		c.h.logger.Error("failed to encode implicit Email/set", zap.Error(err))
+		return
+	}
+	inv := Invocation{Name: "Email/set", CallID: c.callID}
+	result, err := emailSet(c, args)
+	c.extra = append(c.extra, c.h.response(inv, result, err))
+}
+
+// createSubmission sends one email of an EmailSubmission/set call
+func (c *call) createSubmission(identities []*identity, raw json.RawMessage) (*domain.EmailSubmission, *SetError, error) {
+	var props submissionCreate
+	if err := json.Unmarshal(raw, &props); err != nil {
+		return nil, invalidProperties(err.Error()), nil
+	}
+
+	var ident *identity
+	for _, i := range identities {
+		if i.id == props.IdentityID {
+			ident = i
+		}
+	}
+	if ident == nil {
+		return nil, invalidProperties("identity not found", "identityId"), nil
+	}
+	emailID, _ := c.resolveID(props.EmailID)
+	n, ok := parseID(emailID)
+	if !ok {
+		return nil, invalidProperties("email not found", "emailId"), nil
+	}
+	msg, err := c.h.message(c.user.ID, n)
+	if err != nil {
+		return nil, invalidProperties("email not found", "emailId"), nil
+	}
+
+	e, err := mail.CreateReader(bytes.NewReader(msg.Content))
+	if err != nil {
+		return nil, setError("invalidEmail", "%v", err), nil
+	}
+	from, err := e.Header.AddressList("From")
+	if err != nil || len(from) == 0 {
+		return nil, setError("invalidEmail", "the email has no From address"), nil
+	}
+	for _, addr := range from {
+		if !ident.matches(addr.Address) {
+			return nil, setError("forbiddenFrom", "the identity may not send as %s", addr.Address), nil
+		}
+	}
+
+	var mailFrom string
+	var rcptTo []string
+	if props.Envelope != nil {
+		mailFrom = props.Envelope.MailFrom.Email
+		for _, rcpt := range props.Envelope.RcptTo {
+			rcptTo = append(rcptTo, rcpt.Email)
+		}
+	} else {
+		mailFrom = from[0].Address
+		seen := make(map[string]bool)
+		for _, key := range []string{"To", "Cc", "Bcc"} {
+			addresses, _ := e.Header.AddressList(key)
+			for _, addr := range addresses {
+				if normalized := service.NormalizeAddress(addr.Address); !seen[normalized] {
+					seen[normalized] = true
+					rcptTo = append(rcptTo, addr.Address)
+				}
+			}
+		}
+	}
+	if len(rcptTo) == 0 {
+		return nil, setError("noRecipients", "the email has no recipients"), nil
+	}
+
+	data, err := stripBcc(msg.Content)
+	if err != nil {
+		return nil, setError("invalidEmail", "%v", err), nil
+	}
+	sub, err := c.h.submissionService.UserSubmitter(service.OriginJMAP, c.user.ID, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	queueID, err := c.h.submissionService.SubmitMessage(c.ctx, sub, mailFrom, rcptTo, data, nil)
+	if err != nil {
+		var submissionErr *service.SubmissionError
+		if !errors.As(err, &submissionErr) {
+			return nil, nil, err
+		}
+		switch {
+		case submissionErr.Code == 553:
+			return nil, setError("forbiddenMailFrom", "%s", submissionErr.Message), nil
+		case submissionErr.EnhancedCode == [3]int{5, 5, 1}:
+			return nil, setError("noRecipients", "%s", submissionErr.Message), nil
+		default:
+			return nil, setError("forbiddenToSend", "%s", submissionErr.Message), nil
+		}
+	}
+
+	submission := &domain.EmailSubmission{
+		UserID:     c.user.ID,
+		IdentityID: ident.id,
+		MessageID:  msg.ID,
+		ThreadID:   msg.ThreadID,
+		MailFrom:   mailFrom,
+		RcptTo:     rcptTo,
+		QueueID:    queueID,
+		UndoStatus: "final",
+		SendAt:     time.Now().UTC(),
+	}
+	if err := c.h.submissionRepo.Create(submission); err != nil {
+		return nil, nil, err
+	}
+	c.h.changes.SubmissionChanged(c.user.ID, submission.ID, domain.ChangeCreated)
+	return submission, nil, nil
+}
+
+// stripBcc removes the Bcc field from a message before it is sent
+func stripBcc(content []byte) ([]byte, error) {
+	br := bufio.NewReader(bytes.NewReader(content))
+	h, err := textproto.ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if !h.Has("Bcc") {
+		return content, nil
+	}
+	h.Del("Bcc")
+
+	var buf bytes.Buffer
+	if err := textproto.WriteHeader(&buf, h); err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(&buf, br); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
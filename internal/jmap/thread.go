@@ -0,0 +1,222 @@
+package jmap
+
+import (
+	"encoding/json"
+	"html"
+	"sort"
+	"strings"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+func threadGet(c *call, args json.RawMessage) (interface{}, error) {
+	var a getArgs
+	if err := c.decode(args, &a); err != nil {
+		return nil, err
+	}
+	if a.tooMany() {
+		return nil, errRequestTooLarge
+	}
+	state, err := c.state(domain.ChangeTypeThread)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := c.userMessages()
+	if err != nil {
+		return nil, err
+	}
+
+	threads := make(map[string][]*domain.Message)
+	var order []string
+	for _, msg := range messages {
+		id := threadID(msg.ThreadID)
+		if threads[id] == nil {
+			order = append(order, id)
+		}
+		threads[id] = append(threads[id], msg)
+	}
+
+	ids := a.ids()
+	if ids == nil {
+		if len(order) > maxObjectsInGet {
+			return nil, errRequestTooLarge
+		}
+		ids = order
+	}
+
+	resp := &getResponse{AccountID: c.accountID, State: state, List: make([]map[string]interface{}, 0), NotFound: make([]string, 0)}
+	for _, id := range ids {
+		thread, ok := threads[id]
+		if !ok {
+			resp.NotFound = append(resp.NotFound, id)
+			continue
+		}
+		sort.SliceStable(thread, func(i, j int) bool {
+			if !thread[i].InternalDate.Equal(thread[j].InternalDate) {
+				return thread[i].InternalDate.Before(thread[j].InternalDate)
+			}
+			return thread[i].ID < thread[j].ID
+		})
+		emailIDs := make([]string, len(thread))
+		for i, msg := range thread {
+			emailIDs[i] = formatID(msg.ID)
+		}
+		resp.List = append(resp.List, project(map[string]interface{}{"id": id, "emailIds": emailIDs}, a.Properties))
+	}
+	return resp, nil
+}
+
+func threadChanges(c *call, args json.RawMessage) (interface{}, error) {
+	return c.changes(args, domain.ChangeTypeThread, threadID)
+}
+
+// searchTerms collects the text the filter searches for, outside NOT operators
+func (f *emailFilter) searchTerms() []string {
+	if f == nil || f.Operator == "NOT" {
+		return nil
+	}
+	var terms []string
+	for _, term := range []*string{f.Text, f.Subject, f.Body} {
+		if term != nil && *term != "" {
+			terms = append(terms, *term)
+		}
+	}
+	for _, cond := range f.Conditions {
+		terms = append(terms, cond.searchTerms()...)
+	}
+	return terms
+}
+
+// highlight escapes text as HTML and marks each occurrence of the terms,
+// returning nil when no term occurs
+func highlight(text string, terms []string) interface{} {
+	lower := strings.ToLower(text)
+	if len(lower) != len(text) {
+		lower = text // lowercasing changed byte offsets; match case-sensitively
+	}
+	marked := make([]bool, len(text))
+	found := false
+	for _, term := range terms {
+		term = strings.ToLower(term)
+		for start := 0; ; {
+			i := strings.Index(lower[start:], term)
+			if i < 0 {
+				break
+			}
+			for j := start + i; j < start+i+len(term); j++ {
+				marked[j] = true
+			}
+			found = true
+			start += i + len(term)
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		j := i
+		for j < len(text) && marked[j] == marked[i] {
+			j++
+		}
+		if marked[i] {
+			b.WriteString("<mark>" + html.EscapeString(text[i:j]) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(text[i:j]))
+		}
+		i = j
+	}
+	return b.String()
+}
+
+func searchSnippetGet(c *call, args json.RawMessage) (interface{}, error) {
+	var a struct {
+		AccountID string          `json:"accountId"`
+		Filter    json.RawMessage `json:"filter"`
+		EmailIDs  []string        `json:"emailIds"`
+	}
+	if err := c.decode(args, &a); err != nil {
+		return nil, err
+	}
+	if len(a.EmailIDs) > maxObjectsInGet {
+		return nil, errRequestTooLarge
+	}
+	filter, err := parseEmailFilter(a.Filter)
+	if err != nil {
+		return nil, err
+	}
+	terms := filter.searchTerms()
+
+	list := make([]map[string]interface{}, 0, len(a.EmailIDs))
+	notFound := make([]string, 0)
+	for _, id := range a.EmailIDs {
+		n, ok := parseID(id)
+		if !ok {
+			notFound = append(notFound, id)
+			continue
+		}
+		msg, err := c.h.message(c.user.ID, n)
+		if err != nil {
+			notFound = append(notFound, id)
+			continue
+		}
+
+		snippet := map[string]interface{}{"emailId": id, "subject": nil, "preview": nil}
+		if len(terms) > 0 {
+			snippet["subject"] = highlight(decodeWords(msg.Subject), terms)
+			root, err := parseBody(msg.Content)
+			if err != nil {
+				return nil, err
+			}
+			snippet["preview"] = highlight(snippetText(classify(root), terms), terms)
+		}
+		list = append(list, snippet)
+	}
+
+	return map[string]interface{}{
+		"accountId": c.accountID,
+		"list":      list,
+		"notFound":  notFound,
+	}, nil
+}
+
+// snippetText returns up to previewLength characters of body text around
+// the first occurrence of a term
+func snippetText(lists *bodyLists, terms []string) string {
+	var parts []string
+	for _, p := range lists.text {
+		if !strings.HasPrefix(p.contentType, "text/") {
+			continue
+		}
+		text := p.textValue(0).Value
+		if p.contentType == "text/html" {
+			text = stripHTML(text)
+		}
+		parts = append(parts, text)
+	}
+	text := strings.Join(strings.Fields(strings.Join(parts, " ")), " ")
+
+	lower := strings.ToLower(text)
+	first := -1
+	for _, term := range terms {
+		if i := strings.Index(lower, strings.ToLower(term)); i >= 0 && (first < 0 || i < first) {
+			first = i
+		}
+	}
+	if first < 0 {
+		return ""
+	}
+	// Start a little before the match, on a character boundary
+	start := first - previewLength/4
+	if start < 0 {
+		start = 0
+	}
+	if start > len(text) {
+		start = len(text)
+	}
+	for start > 0 && start < len(text) && (text[start]&0xC0) == 0x80 {
+		start--
+	}
+	return truncateRunes(text[start:], previewLength)
+}
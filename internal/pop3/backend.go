@@ -0,0 +1,232 @@
+package pop3
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/repository"
+	"github.com/btafoya/gomailserver/internal/security/bruteforce"
+	"github.com/btafoya/gomailserver/internal/security/ratelimit"
+	"github.com/btafoya/gomailserver/internal/security/saslauth"
+	"github.com/btafoya/gomailserver/internal/service"
+)
+
+var (
+	// ErrInvalidCredentials is returned for any failed login, whatever the cause
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrMaildropLocked is returned when another POP3 session holds the maildrop
+	ErrMaildropLocked = errors.New("maildrop already locked")
+)
+
+// Backend authenticates POP3 users and opens their maildrop, the INBOX
+type Backend struct {
+	userService    service.UserServiceInterface
+	mailboxService service.MailboxServiceInterface
+	messageService service.MessageServiceInterface
+	domainRepo     repository.DomainRepository
+	auth           *saslauth.Authenticator
+	logger         *zap.Logger
+
+	// Security services
+	rateLimiter *ratelimit.Limiter
+	bruteForce  *bruteforce.Protection
+
+	// Users with an open maildrop (RFC 1939 section 8 exclusive access)
+	mu     sync.Mutex
+	locked map[int64]bool
+}
+
+// NewBackend creates a new POP3 backend with all dependencies
+func NewBackend(
+	userService service.UserServiceInterface,
+	mailboxService service.MailboxServiceInterface,
+	messageService service.MessageServiceInterface,
+	domainRepo repository.DomainRepository,
+	auth *saslauth.Authenticator,
+	rateLimiter *ratelimit.Limiter,
+	bruteForce *bruteforce.Protection,
+	logger *zap.Logger,
+) *Backend {
+	return &Backend{
+		userService:    userService,
+		mailboxService: mailboxService,
+		messageService: messageService,
+		domainRepo:     domainRepo,
+		auth:           auth,
+		logger:         logger,
+		rateLimiter:    rateLimiter,
+		bruteForce:     bruteForce,
+		locked:         make(map[int64]bool),
+	}
+}
+
+// login delegates a credential check to saslauth.LoginPolicy
+func (b *Backend) login(remoteAddr, mech, username string, verify func() (*domain.User, error)) (*domain.User, error) {
+	// POP3 logins share the per-user mailbox access limit with IMAP
+	policy := saslauth.LoginPolicy{
+		Protocol:   "POP3",
+		Domains:    b.domainRepo,
+		BruteForce: b.bruteForce,
+		RateLimit:  saslauth.UserRateLimit(b.rateLimiter, "imap_per_user"),
+		Logger:     b.logger,
+	}
+	user, err := policy.Login(remoteAddr, mech, username, verify)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// openMaildrop locks the user's INBOX and takes a snapshot of its messages
+// Messages delivered later are seen by the next session.
+func (b *Backend) openMaildrop(user *domain.User) (*maildrop, error) {
+	b.mu.Lock()
+	if b.locked[user.ID] {
+		b.mu.Unlock()
+		return nil, ErrMaildropLocked
+	}
+	b.locked[user.ID] = true
+	b.mu.Unlock()
+
+	drop, err := b.loadMaildrop(user)
+	if err != nil {
+		b.unlock(user.ID)
+		return nil, err
+	}
+	return drop, nil
+}
+
+// loadMaildrop reads the INBOX messages in UID order
+func (b *Backend) loadMaildrop(user *domain.User) (*maildrop, error) {
+	inbox, err := b.mailboxService.GetByName(user.ID, "INBOX")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open INBOX: %w", err)
+	}
+	messages, err := b.messageService.List(inbox.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list INBOX: %w", err)
+	}
+	return &maildrop{
+		backend:   b,
+		user:      user,
+		mailbox:   inbox,
+		messages:  messages,
+		deleted:   make([]bool, len(messages)),
+		retrieved: make([]bool, len(messages)),
+	}, nil
+}
+
+// unlock releases a user's maildrop
+func (b *Backend) unlock(userID int64) {
+	b.mu.Lock()
+	delete(b.locked, userID)
+	b.mu.Unlock()
+}
+
+// maildrop is the INBOX as seen by one POP3 session
+// Messages are numbered from 1 in UID order and keep their number for the
+// whole session; DELE only marks them until the UPDATE state.
+type maildrop struct {
+	backend   *Backend
+	user      *domain.User
+	mailbox   *domain.Mailbox
+	messages  []*domain.Message
+	deleted   []bool
+	retrieved []bool
+}
+
+// message returns the message with the given number unless it is marked deleted
+func (d *maildrop) message(num int) (*domain.Message, bool) {
+	if num < 1 || num > len(d.messages) || d.deleted[num-1] {
+		return nil, false
+	}
+	return d.messages[num-1], true
+}
+
+// stat returns the count and total size of the messages not marked deleted
+func (d *maildrop) stat() (count int, size int64) {
+	for i, msg := range d.messages {
+		if !d.deleted[i] {
+			count++
+			size += msg.Size
+		}
+	}
+	return count, size
+}
+
+// uidl returns the unique-id of a message, stable across sessions as long
+// as the INBOX keeps its UIDVALIDITY
+func (d *maildrop) uidl(msg *domain.Message) string {
+	return fmt.Sprintf("%d.%d", d.mailbox.UIDValidity, msg.UID)
+}
+
+// content reads the full message
+func (d *maildrop) content(msg *domain.Message) ([]byte, error) {
+	if msg.Content != nil {
+		return msg.Content, nil
+	}
+	loaded, err := d.backend.messageService.GetByID(msg.ID)
+	if err != nil {
+		return nil, err
+	}
+	return loaded.Content, nil
+}
+
+// reset unmarks every message marked deleted (RSET)
+func (d *maildrop) reset() {
+	for i := range d.deleted {
+		d.deleted[i] = false
+	}
+}
+
+// commit enters the UPDATE state: messages marked deleted are removed and
+// retrieved messages that remain on the server are marked \Seen
+// It returns the number of messages that could not be removed.
+func (d *maildrop) commit() int {
+	failed := 0
+	for i, msg := range d.messages {
+		switch {
+		case d.deleted[i]:
+			if err := d.backend.messageService.Delete(msg.ID); err != nil {
+				d.backend.logger.Warn("failed to delete message",
+					zap.Int64("message_id", msg.ID),
+					zap.Int64("user_id", d.user.ID),
+					zap.Error(err),
+				)
+				failed++
+			}
+		case d.retrieved[i]:
+			flags := strings.Fields(msg.Flags)
+			if hasFlag(flags, "\\Seen") {
+				continue
+			}
+			if err := d.backend.messageService.SetFlags(msg.ID, append(flags, "\\Seen")); err != nil {
+				d.backend.logger.Warn("failed to mark message seen",
+					zap.Int64("message_id", msg.ID),
+					zap.Error(err),
+				)
+			}
+		}
+	}
+	return failed
+}
+
+// close releases the maildrop lock
+func (d *maildrop) close() {
+	d.backend.unlock(d.user.ID)
+}
+
+// hasFlag reports whether flags contains flag, ignoring case
+func hasFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if strings.EqualFold(f, flag) {
+			return true
+		}
+	}
+	return false
+}
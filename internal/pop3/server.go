@@ -0,0 +1,179 @@
+package pop3
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/config"
+	"github.com/btafoya/gomailserver/internal/proxyproto"
+)
+
+// Server manages POP3 server instances
+type Server struct {
+	backend *Backend
+	cfg     *config.POP3Config
+	tlsCfg  *tls.Config
+	proxy   *proxyproto.Policy
+	logger  *zap.Logger
+	wg      sync.WaitGroup
+	cancel  context.CancelFunc
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	conns     map[net.Conn]struct{}
+}
+
+// NewServer creates a new POP3 server manager
+// proxy is used when proxy_protocol is enabled and may be nil otherwise.
+func NewServer(cfg *config.POP3Config, tlsCfg *tls.Config, proxy *proxyproto.Policy, backend *Backend, logger *zap.Logger) *Server {
+	return &Server{
+		backend: backend,
+		cfg:     cfg,
+		tlsCfg:  tlsCfg,
+		proxy:   proxy,
+		logger:  logger,
+		conns:   make(map[net.Conn]struct{}),
+	}
+}
+
+// listen binds a TCP address, reading PROXY headers first if enabled
+func (s *Server) listen(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg.ProxyProtocol && s.proxy != nil {
+		ln = s.proxy.Listen(ln)
+	}
+	return ln, nil
+}
+
+// Start starts all POP3 servers
+func (s *Server) Start(ctx context.Context) error {
+	ctx, s.cancel = context.WithCancel(ctx)
+
+	// Start POP3 server (110) with STLS
+	ln, err := s.listen(fmt.Sprintf(":%d", s.cfg.Port))
+	if err != nil {
+		return fmt.Errorf("failed to start POP3 listener: %w", err)
+	}
+	s.logger.Info("starting POP3 server",
+		zap.Int("port", s.cfg.Port),
+		zap.String("tls_mode", "STLS"),
+		zap.Bool("proxy_protocol", s.cfg.ProxyProtocol),
+	)
+	s.serve(ctx, ln, false)
+
+	// Start POP3S server (995) with implicit TLS
+	if s.tlsCfg != nil {
+		ln, err := s.listen(fmt.Sprintf(":%d", s.cfg.POP3SPort))
+		if err != nil {
+			return fmt.Errorf("failed to start POP3S listener: %w", err)
+		}
+		s.logger.Info("starting POP3S server",
+			zap.Int("port", s.cfg.POP3SPort),
+			zap.String("tls_mode", "implicit"),
+		)
+		s.serve(ctx, tls.NewListener(ln, s.tlsCfg), true)
+	}
+
+	s.logger.Info("POP3 servers started",
+		zap.Int("pop3_port", s.cfg.Port),
+		zap.Int("pop3s_port", s.cfg.POP3SPort),
+		zap.Int("idle_timeout", s.cfg.IdleTimeout),
+	)
+
+	return nil
+}
+
+// serve accepts connections on ln until it is closed
+func (s *Server) serve(ctx context.Context, ln net.Listener, implicitTLS bool) {
+	s.mu.Lock()
+	s.listeners = append(s.listeners, ln)
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+					s.logger.Error("POP3 accept error", zap.Error(err))
+				}
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.handle(conn, implicitTLS)
+			}()
+		}
+	}()
+}
+
+// handle runs a session and tracks its connection for shutdown
+func (s *Server) handle(conn net.Conn, implicitTLS bool) {
+	s.mu.Lock()
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	newSession(s, conn, implicitTLS).serve()
+}
+
+// Shutdown performs graceful shutdown
+// Open sessions are closed without entering the UPDATE state, so messages
+// marked for deletion stay in the maildrop (RFC 1939 section 6).
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.logger.Info("shutting down POP3 servers")
+
+	if s.cancel != nil {
+		s.cancel()
+	}
+
+	var shutdownErr error
+	s.mu.Lock()
+	for _, ln := range s.listeners {
+		if err := ln.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			s.logger.Warn("POP3 listener close error", zap.Error(err))
+			shutdownErr = err
+		}
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	// Shutdown with timeout
+	shutdownCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.logger.Info("POP3 servers shutdown complete")
+	case <-shutdownCtx.Done():
+		s.logger.Warn("POP3 servers shutdown timeout")
+		return shutdownCtx.Err()
+	}
+
+	return shutdownErr
+}
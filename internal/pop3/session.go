@@ -0,0 +1,525 @@
+package pop3
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/security/saslauth"
+)
+
+// maxLineLength bounds a command line, including SASL responses which may
+// carry bearer tokens (RFC 5034 section 4)
+const maxLineLength = 8192
+
+var errLineTooLong = errors.New("line too long")
+
+// session is one POP3 connection (RFC 1939)
+// It is in the AUTHORIZATION state until drop is set, then in the
+// TRANSACTION state; QUIT in the TRANSACTION state enters the UPDATE state.
+type session struct {
+	server *Server
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	tls    bool
+
+	username string // given by USER
+	user     *domain.User
+	drop     *maildrop
+}
+
+func newSession(s *Server, conn net.Conn, implicitTLS bool) *session {
+	return &session{
+		server: s,
+		conn:   conn,
+		r:      bufio.NewReaderSize(conn, maxLineLength),
+		w:      bufio.NewWriter(conn),
+		tls:    implicitTLS,
+	}
+}
+
+// serve runs the session until QUIT, a timeout or a connection error
+func (c *session) serve() {
+	logger := c.server.logger
+	defer func() {
+		if c.drop != nil {
+			c.drop.close()
+		}
+	}()
+
+	c.reply(true, "POP3 server ready")
+	for {
+		if err := c.w.Flush(); err != nil {
+			return
+		}
+		if timeout := c.server.cfg.IdleTimeout; timeout > 0 {
+			_ = c.conn.SetReadDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+		}
+
+		line, err := c.readLine()
+		if errors.Is(err, errLineTooLong) {
+			c.reply(false, "line too long")
+			continue
+		}
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				c.reply(false, "autologout; idle for too long")
+				_ = c.w.Flush()
+			}
+			logger.Debug("POP3 connection closed",
+				zap.String("remote_addr", c.conn.RemoteAddr().String()),
+				zap.Error(err),
+			)
+			return
+		}
+
+		name, arg, _ := strings.Cut(line, " ")
+		if quit := c.handle(strings.ToUpper(name), arg); quit {
+			_ = c.w.Flush()
+			return
+		}
+	}
+}
+
+// readLine reads a CRLF terminated line without its terminator
+func (c *session) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		// Discard the rest of the line
+		for errors.Is(err, bufio.ErrBufferFull) {
+			_, err = c.r.ReadSlice('\n')
+		}
+		if err != nil {
+			return "", err
+		}
+		return "", errLineTooLong
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// reply writes a +OK or -ERR status line
+func (c *session) reply(ok bool, format string, args ...interface{}) {
+	status := "-ERR"
+	if ok {
+		status = "+OK"
+	}
+	if text := fmt.Sprintf(format, args...); text != "" {
+		status += " " + text
+	}
+	c.w.WriteString(status + "\r\n")
+}
+
+// handle runs one command and reports whether the session ends
+func (c *session) handle(name, arg string) bool {
+	switch name {
+	case "CAPA":
+		c.capa()
+		return false
+	case "QUIT":
+		c.quit()
+		return true
+	}
+
+	if c.drop == nil {
+		switch name {
+		case "USER":
+			c.userCmd(arg)
+		case "PASS":
+			c.pass(arg)
+		case "AUTH":
+			c.authenticate(arg)
+		case "STLS":
+			c.starttls()
+		default:
+			c.reply(false, "command not valid before authentication")
+		}
+		return false
+	}
+
+	switch name {
+	case "STAT":
+		count, size := c.drop.stat()
+		c.reply(true, "%d %d", count, size)
+	case "LIST":
+		c.list(arg)
+	case "UIDL":
+		c.uidl(arg)
+	case "RETR":
+		c.retr(arg)
+	case "TOP":
+		c.top(arg)
+	case "DELE":
+		c.dele(arg)
+	case "RSET":
+		c.drop.reset()
+		count, size := c.drop.stat()
+		c.reply(true, "maildrop has %d messages (%d octets)", count, size)
+	case "NOOP":
+		c.reply(true, "")
+	default:
+		c.reply(false, "unknown command")
+	}
+	return false
+}
+
+// capa lists the capabilities for the current state (RFC 2449)
+func (c *session) capa() {
+	c.reply(true, "Capability list follows")
+	capabilities := []string{"TOP", "UIDL", "RESP-CODES", "AUTH-RESP-CODE", "PIPELINING", "EXPIRE NEVER"}
+	if c.drop == nil {
+		capabilities = append(capabilities, "USER")
+		if mechs := c.mechanisms(); len(mechs) > 0 {
+			capabilities = append(capabilities, "SASL "+strings.Join(mechs, " "))
+		}
+		if c.canStartTLS() {
+			capabilities = append(capabilities, "STLS")
+		}
+	}
+	capabilities = append(capabilities, "IMPLEMENTATION gomailserver")
+	for _, capability := range capabilities {
+		c.w.WriteString(capability + "\r\n")
+	}
+	c.w.WriteString(".\r\n")
+}
+
+// mechanisms returns the SASL mechanisms offered by AUTH
+func (c *session) mechanisms() []string {
+	if c.server.backend.auth == nil {
+		return nil
+	}
+	return c.server.backend.auth.Mechanisms()
+}
+
+func (c *session) canStartTLS() bool {
+	return !c.tls && c.server.tlsCfg != nil
+}
+
+// quit ends the session, entering the UPDATE state after authentication
+func (c *session) quit() {
+	if c.drop == nil {
+		c.reply(true, "bye")
+		return
+	}
+
+	failed := c.drop.commit()
+	count, _ := c.drop.stat()
+	c.server.logger.Info("POP3 session ended",
+		zap.String("username", c.user.Email),
+		zap.String("remote_addr", c.conn.RemoteAddr().String()),
+		zap.Int("messages_left", count),
+		zap.Int("delete_failures", failed),
+	)
+	if failed > 0 {
+		c.reply(false, "[SYS/TEMP] %d deleted messages not removed", failed)
+		return
+	}
+	c.reply(true, "bye (%d messages left)", count)
+}
+
+// userCmd handles USER, the first half of a USER/PASS login
+func (c *session) userCmd(arg string) {
+	if arg == "" {
+		c.reply(false, "missing user name")
+		return
+	}
+	c.username = arg
+	c.reply(true, "send PASS")
+}
+
+// pass handles PASS; the password is the rest of the line and may contain spaces
+// POP3 clients use IMAP app passwords.
+func (c *session) pass(arg string) {
+	if c.username == "" {
+		c.reply(false, "send USER first")
+		return
+	}
+	username := c.username
+	c.username = ""
+
+	user, err := c.server.backend.login(c.conn.RemoteAddr().String(), saslauth.Plain, username, func() (*domain.User, error) {
+		return c.server.backend.userService.AuthenticateClient(username, arg, domain.AppPasswordScopeIMAP)
+	})
+	if err != nil {
+		c.reply(false, "[AUTH] authentication failed")
+		return
+	}
+	c.open(user)
+}
+
+// authenticate handles AUTH with the shared SASL mechanisms (RFC 5034)
+// Without arguments it lists the mechanisms, as many clients expect.
+func (c *session) authenticate(arg string) {
+	if arg == "" {
+		c.reply(true, "")
+		for _, mech := range c.mechanisms() {
+			c.w.WriteString(mech + "\r\n")
+		}
+		c.w.WriteString(".\r\n")
+		return
+	}
+	if c.server.backend.auth == nil {
+		c.reply(false, "AUTH is not available")
+		return
+	}
+
+	mech, initial, hasInitial := strings.Cut(arg, " ")
+	mech = strings.ToUpper(mech)
+
+	var user *domain.User
+	saslServer, err := c.server.backend.auth.NewServer(mech, domain.AppPasswordScopeIMAP, func(username string, verify func() (*domain.User, error)) error {
+		var err error
+		user, err = c.server.backend.login(c.conn.RemoteAddr().String(), mech, username, verify)
+		return err
+	})
+	if err != nil {
+		c.reply(false, "unsupported authentication mechanism")
+		return
+	}
+
+	var response []byte
+	if hasInitial {
+		// "=" is an empty initial response
+		if initial != "=" {
+			if response, err = base64.StdEncoding.DecodeString(initial); err != nil {
+				c.reply(false, "invalid base64 initial response")
+				return
+			}
+		} else {
+			response = []byte{}
+		}
+	}
+
+	for {
+		challenge, done, err := saslServer.Next(response)
+		if err != nil {
+			c.reply(false, "[AUTH] authentication failed")
+			return
+		}
+		if done {
+			break
+		}
+
+		fmt.Fprintf(c.w, "+ %s\r\n", base64.StdEncoding.EncodeToString(challenge))
+		if err := c.w.Flush(); err != nil {
+			return
+		}
+		line, err := c.readLine()
+		if err != nil {
+			c.reply(false, "invalid authentication response")
+			return
+		}
+		if line == "*" {
+			c.reply(false, "authentication cancelled")
+			return
+		}
+		if response, err = base64.StdEncoding.DecodeString(line); err != nil {
+			c.reply(false, "invalid base64 response")
+			return
+		}
+	}
+
+	if user == nil {
+		c.reply(false, "[AUTH] authentication failed")
+		return
+	}
+	c.open(user)
+}
+
+// open locks the maildrop of an authenticated user, entering the TRANSACTION state
+func (c *session) open(user *domain.User) {
+	drop, err := c.server.backend.openMaildrop(user)
+	if errors.Is(err, ErrMaildropLocked) {
+		c.reply(false, "[IN-USE] maildrop is already in use")
+		return
+	}
+	if err != nil {
+		c.server.logger.Error("failed to open maildrop",
+			zap.Int64("user_id", user.ID),
+			zap.Error(err),
+		)
+		c.reply(false, "[SYS/TEMP] unable to open maildrop")
+		return
+	}
+
+	c.user = user
+	c.drop = drop
+	count, size := drop.stat()
+	c.reply(true, "maildrop has %d messages (%d octets)", count, size)
+}
+
+// starttls upgrades the connection to TLS (RFC 2595 section 4)
+func (c *session) starttls() {
+	if !c.canStartTLS() {
+		c.reply(false, "STLS not available")
+		return
+	}
+	c.reply(true, "begin TLS negotiation")
+	if err := c.w.Flush(); err != nil {
+		return
+	}
+
+	tlsConn := tls.Server(c.conn, c.server.tlsCfg)
+	if err := tlsConn.Handshake(); err != nil {
+		c.server.logger.Debug("POP3 TLS handshake failed", zap.Error(err))
+		_ = c.conn.Close()
+		return
+	}
+	c.conn = tlsConn
+	c.r = bufio.NewReaderSize(tlsConn, maxLineLength)
+	c.w = bufio.NewWriter(tlsConn)
+	c.tls = true
+	c.username = ""
+}
+
+// messageArg parses a message number and returns the message it refers to
+func (c *session) messageArg(arg string) (int, *domain.Message, bool) {
+	num, err := strconv.Atoi(arg)
+	if err != nil {
+		c.reply(false, "invalid message number")
+		return 0, nil, false
+	}
+	msg, ok := c.drop.message(num)
+	if !ok {
+		c.reply(false, "no such message")
+		return 0, nil, false
+	}
+	return num, msg, true
+}
+
+// list handles LIST, for one message or the whole maildrop
+func (c *session) list(arg string) {
+	if arg != "" {
+		if num, msg, ok := c.messageArg(arg); ok {
+			c.reply(true, "%d %d", num, msg.Size)
+		}
+		return
+	}
+	count, size := c.drop.stat()
+	c.reply(true, "%d messages (%d octets)", count, size)
+	for i := range c.drop.messages {
+		if msg, ok := c.drop.message(i + 1); ok {
+			fmt.Fprintf(c.w, "%d %d\r\n", i+1, msg.Size)
+		}
+	}
+	c.w.WriteString(".\r\n")
+}
+
+// uidl handles UIDL, for one message or the whole maildrop
+func (c *session) uidl(arg string) {
+	if arg != "" {
+		if num, msg, ok := c.messageArg(arg); ok {
+			c.reply(true, "%d %s", num, c.drop.uidl(msg))
+		}
+		return
+	}
+	c.reply(true, "")
+	for i := range c.drop.messages {
+		if msg, ok := c.drop.message(i + 1); ok {
+			fmt.Fprintf(c.w, "%d %s\r\n", i+1, c.drop.uidl(msg))
+		}
+	}
+	c.w.WriteString(".\r\n")
+}
+
+// retr handles RETR; the message is marked \Seen when the session ends
+func (c *session) retr(arg string) {
+	num, msg, ok := c.messageArg(arg)
+	if !ok {
+		return
+	}
+	content, err := c.drop.content(msg)
+	if err != nil {
+		c.server.logger.Error("failed to read message",
+			zap.Int64("message_id", msg.ID),
+			zap.Error(err),
+		)
+		c.reply(false, "[SYS/TEMP] unable to read message")
+		return
+	}
+	c.drop.retrieved[num-1] = true
+	c.reply(true, "%d octets", msg.Size)
+	writeMessage(c.w, content, -1)
+}
+
+// top handles TOP, sending the header and the first lines of the body
+func (c *session) top(arg string) {
+	numArg, linesArg, _ := strings.Cut(arg, " ")
+	lines, err := strconv.Atoi(linesArg)
+	if err != nil || lines < 0 {
+		c.reply(false, "invalid number of lines")
+		return
+	}
+	_, msg, ok := c.messageArg(numArg)
+	if !ok {
+		return
+	}
+	content, err := c.drop.content(msg)
+	if err != nil {
+		c.server.logger.Error("failed to read message",
+			zap.Int64("message_id", msg.ID),
+			zap.Error(err),
+		)
+		c.reply(false, "[SYS/TEMP] unable to read message")
+		return
+	}
+	c.reply(true, "")
+	writeMessage(c.w, content, lines)
+}
+
+// dele handles DELE; the message is removed when the session ends with QUIT
+func (c *session) dele(arg string) {
+	num, _, ok := c.messageArg(arg)
+	if !ok {
+		return
+	}
+	c.drop.deleted[num-1] = true
+	c.reply(true, "message %d deleted", num)
+}
+
+// writeMessage writes a message as a multi-line response: lines end in CRLF,
+// lines starting with "." are byte-stuffed and the response ends with "."
+// A non-negative bodyLines limits the body to that many lines (TOP).
+func writeMessage(w *bufio.Writer, content []byte, bodyLines int) {
+	inBody := false
+	n := 0
+	for len(content) > 0 {
+		line := content
+		if i := bytes.IndexByte(content, '\n'); i >= 0 {
+			line, content = content[:i], content[i+1:]
+		} else {
+			content = nil
+		}
+		line = bytes.TrimSuffix(line, []byte("\r"))
+
+		if inBody {
+			if bodyLines >= 0 && n >= bodyLines {
+				break
+			}
+			n++
+		} else if len(line) == 0 {
+			inBody = true
+		}
+
+		if len(line) > 0 && line[0] == '.' {
+			w.WriteByte('.')
+		}
+		w.Write(line)
+		w.WriteString("\r\n")
+	}
+	w.WriteString(".\r\n")
+}
@@ -0,0 +1,356 @@
+package pop3
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/config"
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/security/saslauth"
+)
+
+// mockUserService accepts a single user and password
+type mockUserService struct {
+	user     *domain.User
+	password string
+}
+
+func (m *mockUserService) Create(user *domain.User, password string) error { return nil }
+func (m *mockUserService) Authenticate(email, password string) (*domain.User, error) {
+	return m.AuthenticateClient(email, password, "")
+}
+func (m *mockUserService) AuthenticateClient(email, password, scope string) (*domain.User, error) {
+	if email != m.user.Email || password != m.password {
+		return nil, errors.New("authentication failed")
+	}
+	return m.user, nil
+}
+func (m *mockUserService) GetByID(id int64) (*domain.User, error)                { return m.user, nil }
+func (m *mockUserService) GetByEmail(email string) (*domain.User, error)         { return m.user, nil }
+func (m *mockUserService) Update(user *domain.User) error                        { return nil }
+func (m *mockUserService) UpdatePassword(userID int64, newPassword string) error { return nil }
+func (m *mockUserService) Delete(id int64) error                                 { return nil }
+func (m *mockUserService) RequiresAppPassword(user *domain.User) bool            { return false }
+
+// mockMailboxService has only an INBOX
+type mockMailboxService struct {
+	inbox *domain.Mailbox
+}
+
+func (m *mockMailboxService) Create(userID int64, name, specialUse string) error { return nil }
+func (m *mockMailboxService) GetByName(userID int64, name string) (*domain.Mailbox, error) {
+	if name != "INBOX" {
+		return nil, errors.New("mailbox not found")
+	}
+	return m.inbox, nil
+}
+func (m *mockMailboxService) AllocateUID(mailboxID int64) (int64, error) { return 0, nil }
+func (m *mockMailboxService) List(userID int64, subscribedOnly bool) ([]*domain.Mailbox, error) {
+	return []*domain.Mailbox{m.inbox}, nil
+}
+func (m *mockMailboxService) Delete(mailboxID int64) error                       { return nil }
+func (m *mockMailboxService) Rename(mailboxID int64, newName string) error       { return nil }
+func (m *mockMailboxService) UpdateSubscription(id int64, subscribed bool) error { return nil }
+func (m *mockMailboxService) SetSpecialUse(id int64, specialUse string) error    { return nil }
+
+// mockMessageService keeps messages in memory
+type mockMessageService struct {
+	messages []*domain.Message
+}
+
+func (m *mockMessageService) Store(userID, mailboxID, uid int64, messageData []byte) (*domain.Message, error) {
+	return nil, nil
+}
+func (m *mockMessageService) Append(userID, mailboxID, uid int64, messageData []byte, flags []string, date time.Time) (*domain.Message, error) {
+	return nil, nil
+}
+func (m *mockMessageService) GetByID(id int64) (*domain.Message, error) {
+	for _, msg := range m.messages {
+		if msg.ID == id {
+			return msg, nil
+		}
+	}
+	return nil, errors.New("message not found")
+}
+func (m *mockMessageService) GetByMailbox(mailboxID int64, offset, limit int) ([]*domain.Message, error) {
+	return m.messages, nil
+}
+func (m *mockMessageService) List(mailboxID int64) ([]*domain.Message, error) {
+	// Listed messages are copies without content, like the repository's
+	list := make([]*domain.Message, len(m.messages))
+	for i, msg := range m.messages {
+		listed := *msg
+		listed.Content = nil
+		list[i] = &listed
+	}
+	return list, nil
+}
+func (m *mockMessageService) Query(q *domain.MessageQuery) (*domain.MessageQueryResult, error) {
+	return &domain.MessageQueryResult{}, nil
+}
+func (m *mockMessageService) SetFlags(id int64, flags []string) error {
+	msg, err := m.GetByID(id)
+	if err != nil {
+		return err
+	}
+	msg.Flags = strings.Join(flags, " ")
+	return nil
+}
+func (m *mockMessageService) Copy(id int64, target *domain.Mailbox, uid int64) (*domain.Message, error) {
+	return nil, nil
+}
+func (m *mockMessageService) Move(id int64, target *domain.Mailbox, uid int64) error { return nil }
+func (m *mockMessageService) Delete(id int64) error {
+	for i, msg := range m.messages {
+		if msg.ID == id {
+			m.messages = append(m.messages[:i], m.messages[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("message not found")
+}
+
+// mockDomainRepository has no domain configuration, disabling the security checks
+type mockDomainRepository struct{}
+
+func (m *mockDomainRepository) Create(domain *domain.Domain) error               { return nil }
+func (m *mockDomainRepository) GetByID(id int64) (*domain.Domain, error)         { return nil, nil }
+func (m *mockDomainRepository) GetByName(name string) (*domain.Domain, error)    { return nil, nil }
+func (m *mockDomainRepository) Update(domain *domain.Domain) error               { return nil }
+func (m *mockDomainRepository) Delete(id int64) error                            { return nil }
+func (m *mockDomainRepository) List(offset, limit int) ([]*domain.Domain, error) { return nil, nil }
+
+const testMessage = "Subject: one\r\n\r\nfirst line\r\n.dotted\r\nthird line\r\n"
+
+func newTestServer(messages *mockMessageService) *Server {
+	users := &mockUserService{
+		user:     &domain.User{ID: 1, Email: "user@example.com", Status: "active"},
+		password: "secret pass",
+	}
+	backend := NewBackend(
+		users,
+		&mockMailboxService{inbox: &domain.Mailbox{ID: 10, Name: "INBOX", UIDValidity: 7}},
+		messages,
+		&mockDomainRepository{},
+		saslauth.NewAuthenticator(users, ""),
+		nil,
+		nil,
+		zap.NewNop(),
+	)
+	return NewServer(&config.POP3Config{}, nil, nil, backend, zap.NewNop())
+}
+
+func newTestMessages() *mockMessageService {
+	return &mockMessageService{messages: []*domain.Message{
+		{ID: 1, UID: 3, Size: int64(len(testMessage)), Content: []byte(testMessage)},
+		{ID: 2, UID: 5, Size: 20, Content: []byte("Subject: two\n\nbody\n")},
+	}}
+}
+
+// testClient drives a session over an in-memory connection
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	done chan struct{}
+}
+
+func dial(t *testing.T, s *Server) *testClient {
+	t.Helper()
+	client, server := net.Pipe()
+	c := &testClient{t: t, conn: client, r: bufio.NewReader(client), done: make(chan struct{})}
+	go func() {
+		defer close(c.done)
+		s.handle(server, false)
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-c.done
+	})
+	c.expect("+OK")
+	return c
+}
+
+// cmd sends a command and returns the status line
+func (c *testClient) cmd(line string) string {
+	c.t.Helper()
+	if _, err := c.conn.Write([]byte(line + "\r\n")); err != nil {
+		c.t.Fatalf("write %q: %v", line, err)
+	}
+	return c.line()
+}
+
+func (c *testClient) line() string {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("read: %v", err)
+	}
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+// multiline reads the lines of a multi-line response up to the final "."
+func (c *testClient) multiline() []string {
+	c.t.Helper()
+	var lines []string
+	for {
+		line := c.line()
+		if line == "." {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+func (c *testClient) expect(prefix string) string {
+	c.t.Helper()
+	line := c.line()
+	if !strings.HasPrefix(line, prefix) {
+		c.t.Fatalf("got %q, want prefix %q", line, prefix)
+	}
+	return line
+}
+
+func (c *testClient) login() {
+	c.t.Helper()
+	if line := c.cmd("USER user@example.com"); !strings.HasPrefix(line, "+OK") {
+		c.t.Fatalf("USER: %q", line)
+	}
+	if line := c.cmd("PASS secret pass"); line != "+OK maildrop has 2 messages (69 octets)" {
+		c.t.Fatalf("PASS: %q", line)
+	}
+}
+
+func TestSession_Transaction(t *testing.T) {
+	messages := newTestMessages()
+	c := dial(t, newTestServer(messages))
+
+	if line := c.cmd("STAT"); !strings.HasPrefix(line, "-ERR") {
+		t.Errorf("STAT before login: %q", line)
+	}
+	c.login()
+
+	if line := c.cmd("STAT"); line != "+OK 2 69" {
+		t.Errorf("STAT: %q", line)
+	}
+	c.cmd("UIDL")
+	if got := strings.Join(c.multiline(), ","); got != "1 7.3,2 7.5" {
+		t.Errorf("UIDL: %q", got)
+	}
+
+	c.cmd("RETR 1")
+	want := []string{"Subject: one", "", "first line", "..dotted", "third line"}
+	if got := c.multiline(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("RETR: %q", got)
+	}
+	c.cmd("TOP 2 0")
+	if got := c.multiline(); strings.Join(got, "|") != "Subject: two|" {
+		t.Errorf("TOP: %q", got)
+	}
+
+	if line := c.cmd("DELE 2"); !strings.HasPrefix(line, "+OK") {
+		t.Errorf("DELE: %q", line)
+	}
+	if line := c.cmd("RETR 2"); !strings.HasPrefix(line, "-ERR") {
+		t.Errorf("RETR of a deleted message: %q", line)
+	}
+	c.cmd("LIST")
+	if got := strings.Join(c.multiline(), ","); got != "1 49" {
+		t.Errorf("LIST after DELE: %q", got)
+	}
+
+	if line := c.cmd("QUIT"); line != "+OK bye (1 messages left)" {
+		t.Errorf("QUIT: %q", line)
+	}
+
+	// Only the deleted message is removed; the retrieved one stays, marked \Seen
+	if len(messages.messages) != 1 || messages.messages[0].ID != 1 {
+		t.Fatalf("unexpected messages after QUIT: %+v", messages.messages)
+	}
+	if messages.messages[0].Flags != "\\Seen" {
+		t.Errorf("retrieved message flags = %q", messages.messages[0].Flags)
+	}
+}
+
+func TestSession_RsetAndLock(t *testing.T) {
+	messages := newTestMessages()
+	s := newTestServer(messages)
+	c := dial(t, s)
+	c.login()
+
+	// A second session cannot open the locked maildrop
+	other := dial(t, s)
+	other.cmd("USER user@example.com")
+	if line := other.cmd("PASS secret pass"); !strings.HasPrefix(line, "-ERR [IN-USE]") {
+		t.Errorf("second login: %q", line)
+	}
+
+	c.cmd("DELE 1")
+	if line := c.cmd("RSET"); line != "+OK maildrop has 2 messages (69 octets)" {
+		t.Errorf("RSET: %q", line)
+	}
+	c.cmd("QUIT")
+	if len(messages.messages) != 2 {
+		t.Errorf("expected RSET to keep both messages, got %d", len(messages.messages))
+	}
+}
+
+func TestSession_Auth(t *testing.T) {
+	s := newTestServer(newTestMessages())
+
+	c := dial(t, s)
+	c.cmd("CAPA")
+	capabilities := strings.Join(c.multiline(), "\n")
+	for _, want := range []string{"UIDL", "TOP", "USER", "SASL SCRAM-SHA-256 PLAIN LOGIN"} {
+		if !strings.Contains(capabilities, want) {
+			t.Errorf("CAPA is missing %q:\n%s", want, capabilities)
+		}
+	}
+	if strings.Contains(capabilities, "STLS") {
+		t.Error("STLS advertised without a TLS configuration")
+	}
+
+	bad := base64.StdEncoding.EncodeToString([]byte("\x00user@example.com\x00wrong"))
+	if line := c.cmd("AUTH PLAIN " + bad); !strings.HasPrefix(line, "-ERR [AUTH]") {
+		t.Errorf("AUTH with a wrong password: %q", line)
+	}
+
+	// Without an initial response the client answers an empty challenge
+	if line := c.cmd("AUTH PLAIN"); line != "+ " {
+		t.Fatalf("AUTH PLAIN challenge: %q", line)
+	}
+	good := base64.StdEncoding.EncodeToString([]byte("\x00user@example.com\x00secret pass"))
+	if line := c.cmd(good); !strings.HasPrefix(line, "+OK maildrop has 2 messages") {
+		t.Errorf("AUTH PLAIN: %q", line)
+	}
+}
+
+func TestWriteMessage(t *testing.T) {
+	tests := []struct {
+		content string
+		lines   int
+		want    string
+	}{
+		{"A: b\n\nbody\n", -1, "A: b\r\n\r\nbody\r\n.\r\n"},
+		{"A: b\r\n\r\n.\r\nend", -1, "A: b\r\n\r\n..\r\nend\r\n.\r\n"},
+		{"A: b\r\n\r\none\r\ntwo\r\n", 1, "A: b\r\n\r\none\r\n.\r\n"},
+		{"A: b\r\nC: d\r\n", 0, "A: b\r\nC: d\r\n.\r\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		w := bufio.NewWriter(&buf)
+		writeMessage(w, []byte(tt.content), tt.lines)
+		w.Flush()
+		if buf.String() != tt.want {
+			t.Errorf("writeMessage(%q, %d) = %q, want %q", tt.content, tt.lines, buf.String(), tt.want)
+		}
+	}
+}
@@ -26,7 +26,7 @@ func WriteError(w http.ResponseWriter, code int, message string) {
 		status = http.StatusOK
 	case 401:
 		status = http.StatusUnauthorized
-	case 300, 405, 406, 409:
+	case 300, 400, 405, 406, 409:
 		status = http.StatusUnprocessableEntity
 	case 402, 410, 411:
 		status = http.StatusBadRequest
@@ -8,20 +8,20 @@ import (
 	"github.com/btafoya/gomailserver/internal/postmark/middleware"
 	"github.com/btafoya/gomailserver/internal/postmark/repository/sqlite"
 	"github.com/btafoya/gomailserver/internal/postmark/service"
-	queueService "github.com/btafoya/gomailserver/internal/service"
+	mailService "github.com/btafoya/gomailserver/internal/service"
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 )
 
 // NewRouter creates a new PostmarkApp API router
-func NewRouter(db *sql.DB, queueSvc *queueService.QueueService, logger *zap.Logger) chi.Router {
+func NewRouter(db *sql.DB, submissionSvc *mailService.SubmissionService, logger *zap.Logger) chi.Router {
 	r := chi.NewRouter()
 
 	// Create repository
 	repo := sqlite.New(db)
 
 	// Create services
-	emailSvc := service.NewEmailService(repo, queueSvc, logger)
+	emailSvc := service.NewEmailService(repo, submissionSvc, logger)
 
 	// Create handlers
 	emailHandler := handlers.NewEmailHandler(emailSvc, logger)
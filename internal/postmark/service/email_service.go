@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/mail"
 	"strings"
@@ -18,17 +19,17 @@ import (
 
 // EmailService handles PostmarkApp email operations
 type EmailService struct {
-	repo         repository.PostmarkRepository
-	queueService *service.QueueService
-	logger       *zap.Logger
+	repo              repository.PostmarkRepository
+	submissionService *service.SubmissionService
+	logger            *zap.Logger
 }
 
 // NewEmailService creates a new email service
-func NewEmailService(repo repository.PostmarkRepository, queueService *service.QueueService, logger *zap.Logger) *EmailService {
+func NewEmailService(repo repository.PostmarkRepository, submissionService *service.SubmissionService, logger *zap.Logger) *EmailService {
 	return &EmailService{
-		repo:         repo,
-		queueService: queueService,
-		logger:       logger,
+		repo:              repo,
+		submissionService: submissionService,
+		logger:            logger,
 	}
 }
 
@@ -52,10 +53,16 @@ func (s *EmailService) SendEmail(ctx context.Context, serverID int, req *models.
 	// Get recipient list
 	recipients := s.parseRecipients(req.To, req.Cc, req.Bcc)
 
-	// Enqueue message for delivery
-	_, err = s.queueService.Enqueue(req.From, recipients, message)
+	// Submit message for delivery; the envelope sender is the bare From address
+	from, _ := mail.ParseAddress(req.From)
+	sub := &service.Submitter{Origin: service.OriginAPI}
+	_, err = s.submissionService.SubmitMessage(ctx, sub, from.Address, recipients, message, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to enqueue message: %w", err)
+		var subErr *service.SubmissionError
+		if errors.As(err, &subErr) {
+			return nil, postmarkError(subErr)
+		}
+		return nil, fmt.Errorf("failed to submit message: %w", err)
 	}
 
 	// Store message in PostmarkApp tracking
@@ -142,6 +149,13 @@ func (s *EmailService) validateEmailRequest(req *models.EmailRequest) error {
 		}
 	}
 
+	if _, err := mail.ParseAddress(req.From); err != nil {
+		return &models.PostmarkError{
+			ErrorCode: models.ErrorCodeInvalidEmail,
+			Message:   "The 'From' address is invalid",
+		}
+	}
+
 	if req.To == "" {
 		return &models.PostmarkError{
 			ErrorCode: models.ErrorCodeInvalidEmail,
@@ -177,6 +191,18 @@ func (s *EmailService) validateEmailRequest(req *models.EmailRequest) error {
 	return nil
 }
 
+// postmarkError converts a submission policy rejection to a PostmarkApp error
+func postmarkError(err *service.SubmissionError) *models.PostmarkError {
+	switch {
+	case err.Code == 553:
+		return &models.PostmarkError{ErrorCode: models.ErrorCodeSenderNotFound, Message: models.MsgSenderNotFound}
+	case err.Code == 421:
+		return &models.PostmarkError{ErrorCode: models.ErrorCodeRateLimitExceeded, Message: err.Message}
+	default:
+		return &models.PostmarkError{ErrorCode: models.ErrorCodeInvalidEmail, Message: err.Message}
+	}
+}
+
 // parseRecipients extracts recipient addresses
 func (s *EmailService) parseRecipients(to, cc, bcc string) []string {
 	var recipients []string
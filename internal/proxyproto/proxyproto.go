@@ -0,0 +1,305 @@
+// Package proxyproto implements the receiving side of the HAProxy PROXY
+// protocol (versions 1 and 2) for listeners that sit behind a TCP load balancer.
+//
+// Connections from trusted sources must start with a PROXY header, whose source
+// address then replaces the balancer's address as the connection's RemoteAddr.
+// Connections from any other source are passed through unchanged, except that
+// they are dropped if they try to send a PROXY header themselves.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// v1 headers are at most 107 bytes including CRLF
+const maxV1HeaderLen = 107
+
+// v2Signature starts every version 2 header
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var (
+	// ErrMissingHeader is returned when a trusted source does not send a header
+	ErrMissingHeader = errors.New("proxyproto: missing PROXY header from trusted source")
+	// ErrUntrustedHeader is returned when an untrusted source sends a header
+	ErrUntrustedHeader = errors.New("proxyproto: PROXY header from untrusted source")
+	// ErrInvalidHeader is returned for malformed headers
+	ErrInvalidHeader = errors.New("proxyproto: invalid PROXY header")
+)
+
+// Policy decides which peers may send PROXY headers
+type Policy struct {
+	trusted []*net.IPNet
+	timeout time.Duration
+}
+
+// NewPolicy creates a policy trusting the given CIDRs or bare IP addresses
+// timeout bounds how long a trusted peer may take to send its header.
+func NewPolicy(trustedProxies []string, timeout time.Duration) (*Policy, error) {
+	p := &Policy{timeout: timeout}
+	for _, s := range trustedProxies {
+		cidr := s
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", s, err)
+		}
+		p.trusted = append(p.trusted, ipNet)
+	}
+	return p, nil
+}
+
+// Trusted reports whether addr may send PROXY headers
+func (p *Policy) Trusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipNet := range p.trusted {
+		if ipNet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Listen wraps a listener so accepted connections report the proxied client address
+// Wrap before adding TLS so the header is read from the raw TCP stream.
+func (p *Policy) Listen(ln net.Listener) net.Listener {
+	return &listener{Listener: ln, policy: p}
+}
+
+type listener struct {
+	net.Listener
+	policy *Policy
+}
+
+// Accept returns the next connection without reading from it
+// The header is parsed on first use so a slow peer cannot stall the accept loop.
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{
+		Conn:    c,
+		trusted: l.policy.Trusted(c.RemoteAddr()),
+		timeout: l.policy.timeout,
+	}, nil
+}
+
+// Conn is a connection accepted by a PROXY protocol listener
+type Conn struct {
+	net.Conn
+	trusted bool
+	timeout time.Duration
+
+	once   sync.Once
+	reader io.Reader
+	remote net.Addr
+	err    error
+
+	// deadlineMu guards readDeadline, the read deadline last set by the
+	// caller, which is restored once the header has been read
+	deadlineMu   sync.Mutex
+	readDeadline time.Time
+}
+
+// SetDeadline sets the read and write deadlines
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+// RemoteAddr returns the client address from the PROXY header, if any
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.init)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// Read reads from the connection after the PROXY header
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.init)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// init reads the header from trusted peers
+// Untrusted peers usually wait for a server greeting, so their first bytes are
+// checked as they arrive instead of blocking here.
+func (c *Conn) init() {
+	if !c.trusted {
+		c.reader = &untrustedReader{conn: c.Conn}
+		return
+	}
+
+	if c.timeout > 0 {
+		// The header timeout applies unless the caller's deadline is sooner,
+		// and the caller's deadline is put back afterwards
+		c.deadlineMu.Lock()
+		deadline := time.Now().Add(c.timeout)
+		if !c.readDeadline.IsZero() && c.readDeadline.Before(deadline) {
+			deadline = c.readDeadline
+		}
+		c.Conn.SetReadDeadline(deadline)
+		c.deadlineMu.Unlock()
+		defer func() {
+			c.deadlineMu.Lock()
+			c.Conn.SetReadDeadline(c.readDeadline)
+			c.deadlineMu.Unlock()
+		}()
+	}
+
+	br := bufio.NewReader(c.Conn)
+	c.reader = br
+	c.remote, c.err = readHeader(br)
+	if c.err != nil {
+		c.Conn.Close()
+	}
+}
+
+// untrustedReader drops connections whose first bytes are a PROXY header
+type untrustedReader struct {
+	conn    net.Conn
+	checked bool
+}
+
+func (r *untrustedReader) Read(b []byte) (int, error) {
+	n, err := r.conn.Read(b)
+	if !r.checked && n > 0 {
+		r.checked = true
+		if looksLikeHeader(b[:n]) {
+			r.conn.Close()
+			return 0, ErrUntrustedHeader
+		}
+	}
+	return n, err
+}
+
+// looksLikeHeader reports whether data starts like a v1 or v2 header
+func looksLikeHeader(data []byte) bool {
+	if bytes.HasPrefix(data, []byte("PROXY ")) {
+		return true
+	}
+	n := min(len(data), len(v2Signature))
+	return n >= 4 && bytes.Equal(data[:n], v2Signature[:n])
+}
+
+// readHeader parses a v1 or v2 header and returns the source address
+// A nil address means the header carried no client address (LOCAL or UNKNOWN).
+func readHeader(br *bufio.Reader) (net.Addr, error) {
+	first, err := br.Peek(5)
+	if err != nil {
+		return nil, ErrMissingHeader
+	}
+	if string(first) == "PROXY" {
+		return readV1(br)
+	}
+	if sig, err := br.Peek(len(v2Signature)); err == nil && bytes.Equal(sig, v2Signature) {
+		return readV2(br)
+	}
+	return nil, ErrMissingHeader
+}
+
+// readV1 parses "PROXY TCP4|TCP6|UNKNOWN src dst sport dport\r\n"
+func readV1(br *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < maxV1HeaderLen {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, ErrInvalidHeader
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrInvalidHeader
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrInvalidHeader
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, ErrInvalidHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readV2 parses the binary version 2 header
+func readV2(br *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, ErrInvalidHeader
+	}
+	verCmd, family := hdr[12], hdr[13]
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, ErrInvalidHeader
+	}
+
+	if verCmd>>4 != 2 {
+		return nil, ErrInvalidHeader
+	}
+	switch verCmd & 0x0f {
+	case 0x0: // LOCAL: health check from the balancer itself
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, ErrInvalidHeader
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, ErrInvalidHeader
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, ErrInvalidHeader
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	case 0x00: // UNSPEC
+		return nil, nil
+	}
+	return nil, ErrInvalidHeader
+}
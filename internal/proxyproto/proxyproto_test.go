@@ -0,0 +1,144 @@
+package proxyproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// serve accepts one connection through the policy and sends client data to it
+func serve(t *testing.T, trusted []string, data []byte) (net.Conn, func()) {
+	t.Helper()
+	policy, err := NewPolicy(trusted, time.Second)
+	if err != nil {
+		t.Fatalf("NewPolicy failed: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	pln := policy.Listen(ln)
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	if _, err := client.Write(data); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	conn, err := pln.Accept()
+	if err != nil {
+		t.Fatalf("accept failed: %v", err)
+	}
+	return conn, func() {
+		conn.Close()
+		client.Close()
+		ln.Close()
+	}
+}
+
+func v2Header(cmd byte, family byte, payload []byte) []byte {
+	hdr := append([]byte{}, v2Signature...)
+	hdr = append(hdr, 0x20|cmd, family, 0, 0)
+	binary.BigEndian.PutUint16(hdr[14:16], uint16(len(payload)))
+	return append(hdr, payload...)
+}
+
+func TestTrustedSource(t *testing.T) {
+	v4 := make([]byte, 12)
+	copy(v4[0:4], net.ParseIP("203.0.113.7").To4())
+	copy(v4[4:8], net.ParseIP("192.0.2.1").To4())
+	binary.BigEndian.PutUint16(v4[8:10], 40000)
+	binary.BigEndian.PutUint16(v4[10:12], 25)
+
+	v6 := make([]byte, 36)
+	copy(v6[0:16], net.ParseIP("2001:db8::7"))
+	binary.BigEndian.PutUint16(v6[32:34], 40000)
+
+	tests := []struct {
+		name   string
+		header []byte
+		remote string // empty keeps the balancer's address
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 203.0.113.7 192.0.2.1 40000 25\r\n"), "203.0.113.7:40000"},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::7 2001:db8::1 40000 25\r\n"), "[2001:db8::7]:40000"},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), ""},
+		{"v2 ipv4", v2Header(0x1, 0x11, v4), "203.0.113.7:40000"},
+		{"v2 ipv6", v2Header(0x1, 0x21, v6), "[2001:db8::7]:40000"},
+		{"v2 local", v2Header(0x0, 0x00, nil), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, done := serve(t, []string{"127.0.0.0/8"}, append(tt.header, "EHLO"...))
+			defer done()
+
+			remote := conn.RemoteAddr().String()
+			if tt.remote != "" && remote != tt.remote {
+				t.Errorf("expected remote %s, got %s", tt.remote, remote)
+			}
+			if tt.remote == "" && conn.RemoteAddr().(*net.TCPAddr).IP.String() != "127.0.0.1" {
+				t.Errorf("expected balancer address, got %s", remote)
+			}
+
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "EHLO" {
+				t.Errorf("expected payload after header, got %q (%v)", buf, err)
+			}
+		})
+	}
+}
+
+func TestTrustedSourceRequiresHeader(t *testing.T) {
+	for _, data := range []string{"EHLO client\r\n", "PROXY TCP4 bogus\r\n"} {
+		conn, done := serve(t, []string{"127.0.0.1"}, []byte(data))
+		_, err := conn.Read(make([]byte, 16))
+		if !errors.Is(err, ErrMissingHeader) && !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("%q: expected header error, got %v", data, err)
+		}
+		done()
+	}
+}
+
+func TestUntrustedSource(t *testing.T) {
+	conn, done := serve(t, []string{"10.0.0.0/8"}, []byte("PROXY TCP4 203.0.113.7 192.0.2.1 40000 25\r\n"))
+	defer done()
+	if _, err := conn.Read(make([]byte, 64)); !errors.Is(err, ErrUntrustedHeader) {
+		t.Errorf("expected untrusted header to be rejected, got %v", err)
+	}
+
+	conn2, done2 := serve(t, []string{"10.0.0.0/8"}, []byte("EHLO client\r\n"))
+	defer done2()
+	if conn2.RemoteAddr().(*net.TCPAddr).IP.String() != "127.0.0.1" {
+		t.Errorf("expected untrusted peer address to be kept, got %s", conn2.RemoteAddr())
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn2, buf); err != nil || string(buf) != "EHLO" {
+		t.Errorf("expected plain traffic to pass, got %q (%v)", buf, err)
+	}
+}
+
+func TestNewPolicyInvalid(t *testing.T) {
+	if _, err := NewPolicy([]string{"not-an-ip"}, 0); err == nil {
+		t.Error("expected invalid trusted proxy to be rejected")
+	}
+}
+
+func TestTrustedSourceKeepsReadDeadline(t *testing.T) {
+	conn, done := serve(t, []string{"127.0.0.0/8"}, []byte("PROXY UNKNOWN\r\n"))
+	defer done()
+
+	// A deadline set before the header is read must still apply afterwards
+	if err := conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatalf("SetReadDeadline failed: %v", err)
+	}
+	_, err := conn.Read(make([]byte, 16))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("expected the caller's deadline to time out the read, got %v", err)
+	}
+}
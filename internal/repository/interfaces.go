@@ -13,7 +13,9 @@ type UserRepository interface {
 	GetByEmail(email string) (*domain.User, error)
 	Update(user *domain.User) error
 	UpdateLastLogin(id int64) error
+	ReconcileQuota() (map[int64]int64, error)
 	UpdatePassword(userID int64, passwordHash string) error
+	UpdateSCRAMCredentials(userID int64, credentials string) error
 	Delete(id int64) error
 	List(domainID int64, offset, limit int) ([]*domain.User, error)
 	ListAll() ([]*domain.User, error)
@@ -24,8 +26,17 @@ type MessageRepository interface {
 	Create(message *domain.Message) error
 	GetByID(id int64) (*domain.Message, error)
 	GetByMailbox(mailboxID int64, offset, limit int) ([]*domain.Message, error)
+	ListByMailbox(mailboxID int64) ([]*domain.Message, error)
+	Query(q *domain.MessageQuery) (*domain.MessageQueryResult, error)
 	Update(message *domain.Message) error
+	UpdateFlags(id int64, flags string) error
+	Move(id, mailboxID int64, uid uint32) error
 	Delete(id int64) error
+	Usage(userID int64) (messages, size int64, err error)
+	SetBody(id int64, body *domain.MessageBody) error
+	ListWithoutBody(afterID int64, limit int) ([]int64, error)
+	UnreferencedBodies(limit int) ([]string, error)
+	DeleteBody(hash string) (*domain.MessageBody, error)
 }
 
 // MailboxRepository defines mailbox data access interface
@@ -36,6 +47,7 @@ type MailboxRepository interface {
 	GetByName(userID int64, name string) (*domain.Mailbox, error)
 	Update(mailbox *domain.Mailbox) error
 	Delete(id int64) error
+	AllocateUID(id int64) (int64, error)
 }
 
 // DomainRepository defines domain data access interface
@@ -59,13 +71,95 @@ type AliasRepository interface {
 	ListByDomain(domainID int64) ([]*domain.Alias, error)
 }
 
+// SenderGrantRepository defines sender grant data access interface
+type SenderGrantRepository interface {
+	Create(grant *domain.SenderGrant) error
+	GetByID(id int64) (*domain.SenderGrant, error)
+	ListByUser(userID int64) ([]*domain.SenderGrant, error)
+	FindForAddress(userID int64, address string) (*domain.SenderGrant, error)
+	Delete(id int64) error
+}
+
+// AppPasswordRepository defines app password data access interface
+type AppPasswordRepository interface {
+	Create(appPassword *domain.AppPassword) error
+	GetByID(id int64) (*domain.AppPassword, error)
+	GetByHash(passwordHash string) (*domain.AppPassword, error)
+	ListByUser(userID int64) ([]*domain.AppPassword, error)
+	UpdateLastUsed(id int64) error
+	Delete(id int64) error
+}
+
+// MailboxACLRepository defines mailbox ACL data access interface
+type MailboxACLRepository interface {
+	Set(acl *domain.MailboxACL) error
+	Get(mailboxID int64, identifier string) (*domain.MailboxACL, error)
+	ListByMailbox(mailboxID int64) ([]*domain.MailboxACL, error)
+	ListByIdentifier(identifier string) ([]*domain.MailboxACL, error)
+	Delete(mailboxID int64, identifier string) error
+}
+
+// MetadataRepository defines IMAP METADATA data access interface
+// mailboxID 0 selects server entries.
+type MetadataRepository interface {
+	Set(entry *domain.MetadataEntry) error
+	Delete(userID, mailboxID int64, name string) error
+	List(userID, mailboxID int64) ([]*domain.MetadataEntry, error)
+}
+
+// ChangeRepository defines the change log data access interface
+type ChangeRepository interface {
+	Record(change *domain.Change) error
+	Since(userID int64, changeType string, sinceID int64, limit int) ([]*domain.Change, error)
+	State(userID int64, changeType string) (int64, error)
+	Horizon(userID int64, changeType string) (int64, error)
+	Prune(before time.Time) (int64, error)
+	ThreadSize(userID int64, threadID string) (int, error)
+}
+
+// BlobRepository defines JMAP upload data access interface
+type BlobRepository interface {
+	Create(blob *domain.Blob) error
+	Get(userID int64, id string) (*domain.Blob, error)
+	DeleteExpired(before time.Time) (int64, error)
+}
+
+// EmailSubmissionRepository defines JMAP email submission data access interface
+type EmailSubmissionRepository interface {
+	Create(submission *domain.EmailSubmission) error
+	List(userID int64) ([]*domain.EmailSubmission, error)
+	Delete(userID, id int64) error
+}
+
+// SearchRepository defines full-text search index data access interface
+// Index rows are removed with their message.
+type SearchRepository interface {
+	EnableFTS() (bool, error)
+	Index(doc *domain.SearchDocument) error
+	Copy(fromID, toID int64) error
+	Search(query *domain.SearchQuery) ([]int64, error)
+	Unindexed(afterID int64, limit int) ([]int64, error)
+	Indexed(afterID int64, limit int) ([]int64, error)
+}
+
+// AutoReplyRepository tracks the auto replies sent to each sender
+type AutoReplyRepository interface {
+	LastSent(userID int64, sender string) (time.Time, error)
+	RecordSent(userID int64, sender string, sentAt time.Time) error
+	DeleteOlderThan(age time.Duration) error
+}
+
 // QueueRepository defines queue data access interface
 type QueueRepository interface {
 	Enqueue(item *domain.QueueItem) error
 	GetPending() ([]*domain.QueueItem, error)
+	GetByStatus(status string) ([]*domain.QueueItem, error)
 	GetByID(id int64) (*domain.QueueItem, error)
 	UpdateStatus(id int64, status string, errorMsg string) error
 	UpdateRetry(id int64, retryCount int, nextRetry time.Time) error
+	UpdateRecipients(id int64, recipients string) error
+	UpdateRecipientStatus(id int64, recipientStatus string) error
+	RequeueProcessing() (int64, error)
 	Delete(id int64) error
 }
 
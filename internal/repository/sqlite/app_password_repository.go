@@ -0,0 +1,147 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/btafoya/gomailserver/internal/database"
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/repository"
+)
+
+type appPasswordRepository struct {
+	db *database.DB
+}
+
+// NewAppPasswordRepository creates a new SQLite app password repository
+func NewAppPasswordRepository(db *database.DB) repository.AppPasswordRepository {
+	return &appPasswordRepository{db: db}
+}
+
+// Create inserts a new app password
+func (r *appPasswordRepository) Create(appPassword *domain.AppPassword) error {
+	query := `
+		INSERT INTO app_passwords (user_id, name, password_hash, scopes, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+
+	result, err := r.db.Exec(query,
+		appPassword.UserID, appPassword.Name, appPassword.PasswordHash, appPassword.Scopes, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create app password: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get app password ID: %w", err)
+	}
+
+	appPassword.ID = id
+	appPassword.CreatedAt = time.Now()
+
+	return nil
+}
+
+// GetByID retrieves an app password by ID
+func (r *appPasswordRepository) GetByID(id int64) (*domain.AppPassword, error) {
+	query := `
+		SELECT id, user_id, name, password_hash, scopes, last_used_at, created_at
+		FROM app_passwords
+		WHERE id = ?
+	`
+
+	return r.scanOne(r.db.QueryRow(query, id))
+}
+
+// GetByHash retrieves an app password by the hash of its secret
+func (r *appPasswordRepository) GetByHash(passwordHash string) (*domain.AppPassword, error) {
+	query := `
+		SELECT id, user_id, name, password_hash, scopes, last_used_at, created_at
+		FROM app_passwords
+		WHERE password_hash = ?
+	`
+
+	return r.scanOne(r.db.QueryRow(query, passwordHash))
+}
+
+// ListByUser retrieves all app passwords for a user
+func (r *appPasswordRepository) ListByUser(userID int64) ([]*domain.AppPassword, error) {
+	query := `
+		SELECT id, user_id, name, password_hash, scopes, last_used_at, created_at
+		FROM app_passwords
+		WHERE user_id = ?
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list app passwords: %w", err)
+	}
+	defer rows.Close()
+
+	appPasswords := make([]*domain.AppPassword, 0)
+	for rows.Next() {
+		appPassword := &domain.AppPassword{}
+		var lastUsedAt sql.NullTime
+
+		err := rows.Scan(
+			&appPassword.ID, &appPassword.UserID, &appPassword.Name, &appPassword.PasswordHash,
+			&appPassword.Scopes, &lastUsedAt, &appPassword.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan app password: %w", err)
+		}
+
+		if lastUsedAt.Valid {
+			appPassword.LastUsedAt = &lastUsedAt.Time
+		}
+
+		appPasswords = append(appPasswords, appPassword)
+	}
+
+	return appPasswords, rows.Err()
+}
+
+// UpdateLastUsed records that an app password was just used
+func (r *appPasswordRepository) UpdateLastUsed(id int64) error {
+	query := `UPDATE app_passwords SET last_used_at = ? WHERE id = ?`
+	_, err := r.db.Exec(query, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update app password last used: %w", err)
+	}
+	return nil
+}
+
+// Delete deletes an app password
+func (r *appPasswordRepository) Delete(id int64) error {
+	query := `DELETE FROM app_passwords WHERE id = ?`
+	_, err := r.db.Exec(query, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete app password: %w", err)
+	}
+	return nil
+}
+
+func (r *appPasswordRepository) scanOne(row *sql.Row) (*domain.AppPassword, error) {
+	appPassword := &domain.AppPassword{}
+	var lastUsedAt sql.NullTime
+
+	err := row.Scan(
+		&appPassword.ID, &appPassword.UserID, &appPassword.Name, &appPassword.PasswordHash,
+		&appPassword.Scopes, &lastUsedAt, &appPassword.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("app password not found: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get app password: %w", err)
+	}
+
+	if lastUsedAt.Valid {
+		appPassword.LastUsedAt = &lastUsedAt.Time
+	}
+
+	return appPassword, nil
+}
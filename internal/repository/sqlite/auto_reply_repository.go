@@ -0,0 +1,55 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/btafoya/gomailserver/internal/database"
+	"github.com/btafoya/gomailserver/internal/repository"
+)
+
+type autoReplyRepository struct {
+	db *database.DB
+}
+
+// NewAutoReplyRepository creates a new SQLite auto reply repository
+func NewAutoReplyRepository(db *database.DB) repository.AutoReplyRepository {
+	return &autoReplyRepository{db: db}
+}
+
+// LastSent returns when a user last auto-replied to a sender, or the zero time
+func (r *autoReplyRepository) LastSent(userID int64, sender string) (time.Time, error) {
+	query := `SELECT sent_at FROM auto_replies WHERE user_id = ? AND sender = ?`
+
+	var sentAt time.Time
+	err := r.db.QueryRow(query, userID, sender).Scan(&sentAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get auto reply: %w", err)
+	}
+	return sentAt, nil
+}
+
+// RecordSent records an auto reply to a sender
+func (r *autoReplyRepository) RecordSent(userID int64, sender string, sentAt time.Time) error {
+	query := `
+		INSERT INTO auto_replies (user_id, sender, sent_at) VALUES (?, ?, ?)
+		ON CONFLICT(user_id, sender) DO UPDATE SET sent_at = excluded.sent_at
+	`
+	if _, err := r.db.Exec(query, userID, sender, sentAt); err != nil {
+		return fmt.Errorf("failed to record auto reply: %w", err)
+	}
+	return nil
+}
+
+// DeleteOlderThan forgets auto replies sent before the given age
+func (r *autoReplyRepository) DeleteOlderThan(age time.Duration) error {
+	query := `DELETE FROM auto_replies WHERE sent_at < ?`
+	if _, err := r.db.Exec(query, time.Now().Add(-age)); err != nil {
+		return fmt.Errorf("failed to delete auto replies: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/btafoya/gomailserver/internal/database"
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/repository"
+)
+
+type blobRepository struct {
+	db *database.DB
+}
+
+// NewBlobRepository creates a new SQLite JMAP upload repository
+func NewBlobRepository(db *database.DB) repository.BlobRepository {
+	return &blobRepository{db: db}
+}
+
+// Create stores an uploaded blob, restarting the expiry of an existing blob with the same ID
+func (r *blobRepository) Create(blob *domain.Blob) error {
+	query := `
+		INSERT INTO blobs (id, user_id, type, size, data, created_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id, id) DO UPDATE SET type = excluded.type, created_at = excluded.created_at
+	`
+
+	now := time.Now()
+	if _, err := r.db.Exec(query, blob.ID, blob.UserID, blob.Type, blob.Size, blob.Data, now); err != nil {
+		return fmt.Errorf("failed to create blob: %w", err)
+	}
+	blob.CreatedAt = now
+	return nil
+}
+
+// Get retrieves one of a user's blobs
+func (r *blobRepository) Get(userID int64, id string) (*domain.Blob, error) {
+	query := `SELECT id, user_id, type, size, data, created_at FROM blobs WHERE user_id = ? AND id = ?`
+
+	blob := &domain.Blob{}
+	err := r.db.QueryRow(query, userID, id).Scan(&blob.ID, &blob.UserID, &blob.Type, &blob.Size, &blob.Data, &blob.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("blob not found: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blob: %w", err)
+	}
+	return blob, nil
+}
+
+// DeleteExpired removes blobs uploaded before a time
+func (r *blobRepository) DeleteExpired(before time.Time) (int64, error) {
+	result, err := r.db.Exec(`DELETE FROM blobs WHERE created_at < ?`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired blobs: %w", err)
+	}
+	return result.RowsAffected()
+}
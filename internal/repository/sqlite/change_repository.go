@@ -0,0 +1,133 @@
+package sqlite
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/btafoya/gomailserver/internal/database"
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/repository"
+)
+
+type changeRepository struct {
+	db *database.DB
+}
+
+// NewChangeRepository creates a new SQLite change log repository
+func NewChangeRepository(db *database.DB) repository.ChangeRepository {
+	return &changeRepository{db: db}
+}
+
+// Record appends a change to the log and sets its ID
+func (r *changeRepository) Record(change *domain.Change) error {
+	query := `INSERT INTO changes (user_id, type, object_id, kind, created_at) VALUES (?, ?, ?, ?, ?)`
+
+	now := time.Now()
+	result, err := r.db.Exec(query, change.UserID, change.Type, change.ObjectID, change.Kind, now)
+	if err != nil {
+		return fmt.Errorf("failed to record change: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get change ID: %w", err)
+	}
+	change.ID = id
+	change.CreatedAt = now
+	return nil
+}
+
+// Since retrieves up to limit changes of a type after sinceID, oldest first
+func (r *changeRepository) Since(userID int64, changeType string, sinceID int64, limit int) ([]*domain.Change, error) {
+	query := `
+		SELECT id, user_id, type, object_id, kind, created_at
+		FROM changes
+		WHERE user_id = ? AND type = ? AND id > ?
+		ORDER BY id
+		LIMIT ?
+	`
+
+	rows, err := r.db.Query(query, userID, changeType, sinceID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changes: %w", err)
+	}
+	defer rows.Close()
+
+	changes := make([]*domain.Change, 0)
+	for rows.Next() {
+		change := &domain.Change{}
+		if err := rows.Scan(&change.ID, &change.UserID, &change.Type, &change.ObjectID, &change.Kind, &change.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan change: %w", err)
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, rows.Err()
+}
+
+// State returns the ID of the latest change of a type, including pruned changes
+func (r *changeRepository) State(userID int64, changeType string) (int64, error) {
+	query := `
+		SELECT MAX(
+			COALESCE((SELECT MAX(id) FROM changes WHERE user_id = ? AND type = ?), 0),
+			COALESCE((SELECT change_id FROM change_horizons WHERE user_id = ? AND type = ?), 0)
+		)
+	`
+
+	var state int64
+	if err := r.db.QueryRow(query, userID, changeType, userID, changeType).Scan(&state); err != nil {
+		return 0, fmt.Errorf("failed to get change state: %w", err)
+	}
+	return state, nil
+}
+
+// Horizon returns the ID of the latest pruned change of a type, 0 if none was pruned
+func (r *changeRepository) Horizon(userID int64, changeType string) (int64, error) {
+	query := `SELECT COALESCE((SELECT change_id FROM change_horizons WHERE user_id = ? AND type = ?), 0)`
+
+	var horizon int64
+	if err := r.db.QueryRow(query, userID, changeType).Scan(&horizon); err != nil {
+		return 0, fmt.Errorf("failed to get change horizon: %w", err)
+	}
+	return horizon, nil
+}
+
+// Prune removes changes recorded before a time, moving each user's horizon past them
+func (r *changeRepository) Prune(before time.Time) (int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	horizons := `
+		INSERT INTO change_horizons (user_id, type, change_id)
+		SELECT user_id, type, MAX(id) FROM changes WHERE created_at < ? GROUP BY user_id, type
+		ON CONFLICT(user_id, type) DO UPDATE SET change_id = MAX(change_id, excluded.change_id)
+	`
+	if _, err := tx.Exec(horizons, before); err != nil {
+		return 0, fmt.Errorf("failed to update change horizons: %w", err)
+	}
+
+	result, err := tx.Exec(`DELETE FROM changes WHERE created_at < ?`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune changes: %w", err)
+	}
+	pruned, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count pruned changes: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit change pruning: %w", err)
+	}
+	return pruned, nil
+}
+
+// ThreadSize counts a user's messages in a thread
+func (r *changeRepository) ThreadSize(userID int64, threadID string) (int, error) {
+	var size int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM messages WHERE user_id = ? AND thread_id = ?`, userID, threadID).Scan(&size); err != nil {
+		return 0, fmt.Errorf("failed to count thread messages: %w", err)
+	}
+	return size, nil
+}
@@ -30,12 +30,13 @@ func (r *domainRepository) Create(dom *domain.Domain) error {
 			spf_record, spf_enabled, spf_dns_server, spf_dns_timeout, spf_max_lookups, spf_fail_action, spf_softfail_action,
 			dmarc_policy, dmarc_enabled, dmarc_dns_server, dmarc_dns_timeout, dmarc_report_enabled, dmarc_report_email,
 			clamav_enabled, clamav_max_scan_size, clamav_virus_action, clamav_fail_action,
-			spam_enabled, spam_reject_score, spam_quarantine_score, spam_learning_enabled,
+			spam_enabled, spam_reject_score, spam_quarantine_score, spam_learning_enabled, spam_backend,
 			greylist_enabled, greylist_delay_minutes, greylist_expiry_days, greylist_cleanup_interval, greylist_whitelist_after,
 			ratelimit_enabled, ratelimit_smtp_per_ip, ratelimit_smtp_per_user, ratelimit_smtp_per_domain, ratelimit_auth_per_ip, ratelimit_imap_per_user, ratelimit_cleanup_interval,
 			auth_totp_enforced, auth_brute_force_enabled, auth_brute_force_threshold, auth_brute_force_window_minutes, auth_brute_force_block_minutes, auth_ip_blacklist_enabled, auth_cleanup_interval,
+			forward_external_enabled, subaddress_separator, subaddress_folders, subaddress_create_folders, outbound_pool,
 			created_at, updated_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
 	result, err := r.db.Exec(query,
@@ -46,10 +47,11 @@ func (r *domainRepository) Create(dom *domain.Domain) error {
 		dom.SPFRecord, dom.SPFEnabled, dom.SPFDNSServer, dom.SPFDNSTimeout, dom.SPFMaxLookups, dom.SPFFailAction, dom.SPFSoftFailAction,
 		dom.DMARCPolicy, dom.DMARCEnabled, dom.DMARCDNSServer, dom.DMARCDNSTimeout, dom.DMARCReportEnabled, dom.DMARCReportEmail,
 		dom.ClamAVEnabled, dom.ClamAVMaxScanSize, dom.ClamAVVirusAction, dom.ClamAVFailAction,
-		dom.SpamEnabled, dom.SpamRejectScore, dom.SpamQuarantineScore, dom.SpamLearningEnabled,
+		dom.SpamEnabled, dom.SpamRejectScore, dom.SpamQuarantineScore, dom.SpamLearningEnabled, dom.SpamBackend,
 		dom.GreylistEnabled, dom.GreylistDelayMinutes, dom.GreylistExpiryDays, dom.GreylistCleanupInterval, dom.GreylistWhitelistAfter,
 		dom.RateLimitEnabled, dom.RateLimitSMTPPerIP, dom.RateLimitSMTPPerUser, dom.RateLimitSMTPPerDomain, dom.RateLimitAuthPerIP, dom.RateLimitIMAPPerUser, dom.RateLimitCleanupInterval,
 		dom.AuthTOTPEnforced, dom.AuthBruteForceEnabled, dom.AuthBruteForceThreshold, dom.AuthBruteForceWindowMinutes, dom.AuthBruteForceBlockMinutes, dom.AuthIPBlacklistEnabled, dom.AuthCleanupInterval,
+		dom.ForwardExternalEnabled, dom.SubaddressSeparator, dom.SubaddressFolders, dom.SubaddressCreateFolders, dom.OutboundPool,
 		time.Now(), time.Now(),
 	)
 	if err != nil {
@@ -79,10 +81,11 @@ func (r *domainRepository) GetByID(id int64) (*domain.Domain, error) {
 			spf_record, spf_enabled, spf_dns_server, spf_dns_timeout, spf_max_lookups, spf_fail_action, spf_softfail_action,
 			dmarc_policy, dmarc_enabled, dmarc_dns_server, dmarc_dns_timeout, dmarc_report_enabled, dmarc_report_email,
 			clamav_enabled, clamav_max_scan_size, clamav_virus_action, clamav_fail_action,
-			spam_enabled, spam_reject_score, spam_quarantine_score, spam_learning_enabled,
+			spam_enabled, spam_reject_score, spam_quarantine_score, spam_learning_enabled, spam_backend,
 			greylist_enabled, greylist_delay_minutes, greylist_expiry_days, greylist_cleanup_interval, greylist_whitelist_after,
 			ratelimit_enabled, ratelimit_smtp_per_ip, ratelimit_smtp_per_user, ratelimit_smtp_per_domain, ratelimit_auth_per_ip, ratelimit_imap_per_user, ratelimit_cleanup_interval,
 			auth_totp_enforced, auth_brute_force_enabled, auth_brute_force_threshold, auth_brute_force_window_minutes, auth_brute_force_block_minutes, auth_ip_blacklist_enabled, auth_cleanup_interval,
+			forward_external_enabled, subaddress_separator, subaddress_folders, subaddress_create_folders, outbound_pool,
 			created_at, updated_at
 		FROM domains
 		WHERE id = ?
@@ -98,10 +101,11 @@ func (r *domainRepository) GetByID(id int64) (*domain.Domain, error) {
 		&dom.SPFRecord, &dom.SPFEnabled, &dom.SPFDNSServer, &dom.SPFDNSTimeout, &dom.SPFMaxLookups, &dom.SPFFailAction, &dom.SPFSoftFailAction,
 		&dom.DMARCPolicy, &dom.DMARCEnabled, &dom.DMARCDNSServer, &dom.DMARCDNSTimeout, &dom.DMARCReportEnabled, &dom.DMARCReportEmail,
 		&dom.ClamAVEnabled, &dom.ClamAVMaxScanSize, &dom.ClamAVVirusAction, &dom.ClamAVFailAction,
-		&dom.SpamEnabled, &dom.SpamRejectScore, &dom.SpamQuarantineScore, &dom.SpamLearningEnabled,
+		&dom.SpamEnabled, &dom.SpamRejectScore, &dom.SpamQuarantineScore, &dom.SpamLearningEnabled, &dom.SpamBackend,
 		&dom.GreylistEnabled, &dom.GreylistDelayMinutes, &dom.GreylistExpiryDays, &dom.GreylistCleanupInterval, &dom.GreylistWhitelistAfter,
 		&dom.RateLimitEnabled, &dom.RateLimitSMTPPerIP, &dom.RateLimitSMTPPerUser, &dom.RateLimitSMTPPerDomain, &dom.RateLimitAuthPerIP, &dom.RateLimitIMAPPerUser, &dom.RateLimitCleanupInterval,
 		&dom.AuthTOTPEnforced, &dom.AuthBruteForceEnabled, &dom.AuthBruteForceThreshold, &dom.AuthBruteForceWindowMinutes, &dom.AuthBruteForceBlockMinutes, &dom.AuthIPBlacklistEnabled, &dom.AuthCleanupInterval,
+		&dom.ForwardExternalEnabled, &dom.SubaddressSeparator, &dom.SubaddressFolders, &dom.SubaddressCreateFolders, &dom.OutboundPool,
 		&dom.CreatedAt, &dom.UpdatedAt,
 	)
 	if err == sql.ErrNoRows {
@@ -125,10 +129,11 @@ func (r *domainRepository) GetByName(name string) (*domain.Domain, error) {
 			spf_record, spf_enabled, spf_dns_server, spf_dns_timeout, spf_max_lookups, spf_fail_action, spf_softfail_action,
 			dmarc_policy, dmarc_enabled, dmarc_dns_server, dmarc_dns_timeout, dmarc_report_enabled, dmarc_report_email,
 			clamav_enabled, clamav_max_scan_size, clamav_virus_action, clamav_fail_action,
-			spam_enabled, spam_reject_score, spam_quarantine_score, spam_learning_enabled,
+			spam_enabled, spam_reject_score, spam_quarantine_score, spam_learning_enabled, spam_backend,
 			greylist_enabled, greylist_delay_minutes, greylist_expiry_days, greylist_cleanup_interval, greylist_whitelist_after,
 			ratelimit_enabled, ratelimit_smtp_per_ip, ratelimit_smtp_per_user, ratelimit_smtp_per_domain, ratelimit_auth_per_ip, ratelimit_imap_per_user, ratelimit_cleanup_interval,
 			auth_totp_enforced, auth_brute_force_enabled, auth_brute_force_threshold, auth_brute_force_window_minutes, auth_brute_force_block_minutes, auth_ip_blacklist_enabled, auth_cleanup_interval,
+			forward_external_enabled, subaddress_separator, subaddress_folders, subaddress_create_folders, outbound_pool,
 			created_at, updated_at
 		FROM domains
 		WHERE name = ?
@@ -144,10 +149,11 @@ func (r *domainRepository) GetByName(name string) (*domain.Domain, error) {
 		&dom.SPFRecord, &dom.SPFEnabled, &dom.SPFDNSServer, &dom.SPFDNSTimeout, &dom.SPFMaxLookups, &dom.SPFFailAction, &dom.SPFSoftFailAction,
 		&dom.DMARCPolicy, &dom.DMARCEnabled, &dom.DMARCDNSServer, &dom.DMARCDNSTimeout, &dom.DMARCReportEnabled, &dom.DMARCReportEmail,
 		&dom.ClamAVEnabled, &dom.ClamAVMaxScanSize, &dom.ClamAVVirusAction, &dom.ClamAVFailAction,
-		&dom.SpamEnabled, &dom.SpamRejectScore, &dom.SpamQuarantineScore, &dom.SpamLearningEnabled,
+		&dom.SpamEnabled, &dom.SpamRejectScore, &dom.SpamQuarantineScore, &dom.SpamLearningEnabled, &dom.SpamBackend,
 		&dom.GreylistEnabled, &dom.GreylistDelayMinutes, &dom.GreylistExpiryDays, &dom.GreylistCleanupInterval, &dom.GreylistWhitelistAfter,
 		&dom.RateLimitEnabled, &dom.RateLimitSMTPPerIP, &dom.RateLimitSMTPPerUser, &dom.RateLimitSMTPPerDomain, &dom.RateLimitAuthPerIP, &dom.RateLimitIMAPPerUser, &dom.RateLimitCleanupInterval,
 		&dom.AuthTOTPEnforced, &dom.AuthBruteForceEnabled, &dom.AuthBruteForceThreshold, &dom.AuthBruteForceWindowMinutes, &dom.AuthBruteForceBlockMinutes, &dom.AuthIPBlacklistEnabled, &dom.AuthCleanupInterval,
+		&dom.ForwardExternalEnabled, &dom.SubaddressSeparator, &dom.SubaddressFolders, &dom.SubaddressCreateFolders, &dom.OutboundPool,
 		&dom.CreatedAt, &dom.UpdatedAt,
 	)
 	if err == sql.ErrNoRows {
@@ -171,10 +177,11 @@ func (r *domainRepository) Update(dom *domain.Domain) error {
 			spf_record = ?, spf_enabled = ?, spf_dns_server = ?, spf_dns_timeout = ?, spf_max_lookups = ?, spf_fail_action = ?, spf_softfail_action = ?,
 			dmarc_policy = ?, dmarc_enabled = ?, dmarc_dns_server = ?, dmarc_dns_timeout = ?, dmarc_report_enabled = ?, dmarc_report_email = ?,
 			clamav_enabled = ?, clamav_max_scan_size = ?, clamav_virus_action = ?, clamav_fail_action = ?,
-			spam_enabled = ?, spam_reject_score = ?, spam_quarantine_score = ?, spam_learning_enabled = ?,
+			spam_enabled = ?, spam_reject_score = ?, spam_quarantine_score = ?, spam_learning_enabled = ?, spam_backend = ?,
 			greylist_enabled = ?, greylist_delay_minutes = ?, greylist_expiry_days = ?, greylist_cleanup_interval = ?, greylist_whitelist_after = ?,
 			ratelimit_enabled = ?, ratelimit_smtp_per_ip = ?, ratelimit_smtp_per_user = ?, ratelimit_smtp_per_domain = ?, ratelimit_auth_per_ip = ?, ratelimit_imap_per_user = ?, ratelimit_cleanup_interval = ?,
 			auth_totp_enforced = ?, auth_brute_force_enabled = ?, auth_brute_force_threshold = ?, auth_brute_force_window_minutes = ?, auth_brute_force_block_minutes = ?, auth_ip_blacklist_enabled = ?, auth_cleanup_interval = ?,
+			forward_external_enabled = ?, subaddress_separator = ?, subaddress_folders = ?, subaddress_create_folders = ?, outbound_pool = ?,
 			updated_at = ?
 		WHERE id = ?
 	`
@@ -187,10 +194,11 @@ func (r *domainRepository) Update(dom *domain.Domain) error {
 		dom.SPFRecord, dom.SPFEnabled, dom.SPFDNSServer, dom.SPFDNSTimeout, dom.SPFMaxLookups, dom.SPFFailAction, dom.SPFSoftFailAction,
 		dom.DMARCPolicy, dom.DMARCEnabled, dom.DMARCDNSServer, dom.DMARCDNSTimeout, dom.DMARCReportEnabled, dom.DMARCReportEmail,
 		dom.ClamAVEnabled, dom.ClamAVMaxScanSize, dom.ClamAVVirusAction, dom.ClamAVFailAction,
-		dom.SpamEnabled, dom.SpamRejectScore, dom.SpamQuarantineScore, dom.SpamLearningEnabled,
+		dom.SpamEnabled, dom.SpamRejectScore, dom.SpamQuarantineScore, dom.SpamLearningEnabled, dom.SpamBackend,
 		dom.GreylistEnabled, dom.GreylistDelayMinutes, dom.GreylistExpiryDays, dom.GreylistCleanupInterval, dom.GreylistWhitelistAfter,
 		dom.RateLimitEnabled, dom.RateLimitSMTPPerIP, dom.RateLimitSMTPPerUser, dom.RateLimitSMTPPerDomain, dom.RateLimitAuthPerIP, dom.RateLimitIMAPPerUser, dom.RateLimitCleanupInterval,
 		dom.AuthTOTPEnforced, dom.AuthBruteForceEnabled, dom.AuthBruteForceThreshold, dom.AuthBruteForceWindowMinutes, dom.AuthBruteForceBlockMinutes, dom.AuthIPBlacklistEnabled, dom.AuthCleanupInterval,
+		dom.ForwardExternalEnabled, dom.SubaddressSeparator, dom.SubaddressFolders, dom.SubaddressCreateFolders, dom.OutboundPool,
 		time.Now(), dom.ID,
 	)
 	if err != nil {
@@ -222,10 +230,11 @@ func (r *domainRepository) List(offset, limit int) ([]*domain.Domain, error) {
 			spf_record, spf_enabled, spf_dns_server, spf_dns_timeout, spf_max_lookups, spf_fail_action, spf_softfail_action,
 			dmarc_policy, dmarc_enabled, dmarc_dns_server, dmarc_dns_timeout, dmarc_report_enabled, dmarc_report_email,
 			clamav_enabled, clamav_max_scan_size, clamav_virus_action, clamav_fail_action,
-			spam_enabled, spam_reject_score, spam_quarantine_score, spam_learning_enabled,
+			spam_enabled, spam_reject_score, spam_quarantine_score, spam_learning_enabled, spam_backend,
 			greylist_enabled, greylist_delay_minutes, greylist_expiry_days, greylist_cleanup_interval, greylist_whitelist_after,
 			ratelimit_enabled, ratelimit_smtp_per_ip, ratelimit_smtp_per_user, ratelimit_smtp_per_domain, ratelimit_auth_per_ip, ratelimit_imap_per_user, ratelimit_cleanup_interval,
 			auth_totp_enforced, auth_brute_force_enabled, auth_brute_force_threshold, auth_brute_force_window_minutes, auth_brute_force_block_minutes, auth_ip_blacklist_enabled, auth_cleanup_interval,
+			forward_external_enabled, subaddress_separator, subaddress_folders, subaddress_create_folders, outbound_pool,
 			created_at, updated_at
 		FROM domains
 		ORDER BY created_at DESC
@@ -250,10 +259,11 @@ func (r *domainRepository) List(offset, limit int) ([]*domain.Domain, error) {
 			&dom.SPFRecord, &dom.SPFEnabled, &dom.SPFDNSServer, &dom.SPFDNSTimeout, &dom.SPFMaxLookups, &dom.SPFFailAction, &dom.SPFSoftFailAction,
 			&dom.DMARCPolicy, &dom.DMARCEnabled, &dom.DMARCDNSServer, &dom.DMARCDNSTimeout, &dom.DMARCReportEnabled, &dom.DMARCReportEmail,
 			&dom.ClamAVEnabled, &dom.ClamAVMaxScanSize, &dom.ClamAVVirusAction, &dom.ClamAVFailAction,
-			&dom.SpamEnabled, &dom.SpamRejectScore, &dom.SpamQuarantineScore, &dom.SpamLearningEnabled,
+			&dom.SpamEnabled, &dom.SpamRejectScore, &dom.SpamQuarantineScore, &dom.SpamLearningEnabled, &dom.SpamBackend,
 			&dom.GreylistEnabled, &dom.GreylistDelayMinutes, &dom.GreylistExpiryDays, &dom.GreylistCleanupInterval, &dom.GreylistWhitelistAfter,
 			&dom.RateLimitEnabled, &dom.RateLimitSMTPPerIP, &dom.RateLimitSMTPPerUser, &dom.RateLimitSMTPPerDomain, &dom.RateLimitAuthPerIP, &dom.RateLimitIMAPPerUser, &dom.RateLimitCleanupInterval,
 			&dom.AuthTOTPEnforced, &dom.AuthBruteForceEnabled, &dom.AuthBruteForceThreshold, &dom.AuthBruteForceWindowMinutes, &dom.AuthBruteForceBlockMinutes, &dom.AuthIPBlacklistEnabled, &dom.AuthCleanupInterval,
+			&dom.ForwardExternalEnabled, &dom.SubaddressSeparator, &dom.SubaddressFolders, &dom.SubaddressCreateFolders, &dom.OutboundPool,
 			&dom.CreatedAt, &dom.UpdatedAt,
 		)
 		if err != nil {
@@ -0,0 +1,90 @@
+package sqlite
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/btafoya/gomailserver/internal/database"
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/repository"
+)
+
+type emailSubmissionRepository struct {
+	db *database.DB
+}
+
+// NewEmailSubmissionRepository creates a new SQLite JMAP email submission repository
+func NewEmailSubmissionRepository(db *database.DB) repository.EmailSubmissionRepository {
+	return &emailSubmissionRepository{db: db}
+}
+
+// Create stores a submission and sets its ID
+func (r *emailSubmissionRepository) Create(submission *domain.EmailSubmission) error {
+	query := `
+		INSERT INTO email_submissions (
+			user_id, identity_id, message_id, thread_id, mail_from, rcpt_to, queue_id, undo_status, send_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	rcptTo, err := json.Marshal(submission.RcptTo)
+	if err != nil {
+		return fmt.Errorf("failed to marshal recipients: %w", err)
+	}
+
+	result, err := r.db.Exec(query,
+		submission.UserID, submission.IdentityID, submission.MessageID, submission.ThreadID,
+		submission.MailFrom, string(rcptTo), submission.QueueID, submission.UndoStatus, submission.SendAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create email submission: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get email submission ID: %w", err)
+	}
+	submission.ID = id
+	return nil
+}
+
+// List retrieves a user's submissions, oldest first
+func (r *emailSubmissionRepository) List(userID int64) ([]*domain.EmailSubmission, error) {
+	query := `
+		SELECT id, user_id, identity_id, message_id, thread_id, mail_from, rcpt_to, queue_id, undo_status, send_at
+		FROM email_submissions
+		WHERE user_id = ?
+		ORDER BY send_at, id
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list email submissions: %w", err)
+	}
+	defer rows.Close()
+
+	submissions := make([]*domain.EmailSubmission, 0)
+	for rows.Next() {
+		submission := &domain.EmailSubmission{}
+		var rcptTo string
+		if err := rows.Scan(
+			&submission.ID, &submission.UserID, &submission.IdentityID, &submission.MessageID, &submission.ThreadID,
+			&submission.MailFrom, &rcptTo, &submission.QueueID, &submission.UndoStatus, &submission.SendAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan email submission: %w", err)
+		}
+		if err := json.Unmarshal([]byte(rcptTo), &submission.RcptTo); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal recipients: %w", err)
+		}
+		submissions = append(submissions, submission)
+	}
+
+	return submissions, rows.Err()
+}
+
+// Delete removes one of a user's submissions
+func (r *emailSubmissionRepository) Delete(userID, id int64) error {
+	if _, err := r.db.Exec(`DELETE FROM email_submissions WHERE user_id = ? AND id = ?`, userID, id); err != nil {
+		return fmt.Errorf("failed to delete email submission: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/btafoya/gomailserver/internal/database"
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/repository"
+)
+
+type mailboxACLRepository struct {
+	db *database.DB
+}
+
+// NewMailboxACLRepository creates a new SQLite mailbox ACL repository
+func NewMailboxACLRepository(db *database.DB) repository.MailboxACLRepository {
+	return &mailboxACLRepository{db: db}
+}
+
+// Set grants an identifier rights on a mailbox, replacing any previous grant
+func (r *mailboxACLRepository) Set(acl *domain.MailboxACL) error {
+	query := `
+		INSERT INTO mailbox_acl (mailbox_id, identifier, rights, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(mailbox_id, identifier) DO UPDATE SET rights = excluded.rights, updated_at = excluded.updated_at
+	`
+
+	now := time.Now()
+	if _, err := r.db.Exec(query, acl.MailboxID, acl.Identifier, acl.Rights, now, now); err != nil {
+		return fmt.Errorf("failed to set mailbox ACL: %w", err)
+	}
+	acl.UpdatedAt = now
+	if acl.CreatedAt.IsZero() {
+		acl.CreatedAt = now
+	}
+	return nil
+}
+
+// Get retrieves the rights an identifier holds on a mailbox
+func (r *mailboxACLRepository) Get(mailboxID int64, identifier string) (*domain.MailboxACL, error) {
+	query := `
+		SELECT mailbox_id, identifier, rights, created_at, updated_at
+		FROM mailbox_acl
+		WHERE mailbox_id = ? AND identifier = ?
+	`
+
+	acl := &domain.MailboxACL{}
+	err := r.db.QueryRow(query, mailboxID, identifier).Scan(
+		&acl.MailboxID, &acl.Identifier, &acl.Rights, &acl.CreatedAt, &acl.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("mailbox ACL not found: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mailbox ACL: %w", err)
+	}
+	return acl, nil
+}
+
+// ListByMailbox retrieves all grants on a mailbox
+func (r *mailboxACLRepository) ListByMailbox(mailboxID int64) ([]*domain.MailboxACL, error) {
+	query := `
+		SELECT mailbox_id, identifier, rights, created_at, updated_at
+		FROM mailbox_acl
+		WHERE mailbox_id = ?
+		ORDER BY identifier
+	`
+	return r.list(query, mailboxID)
+}
+
+// ListByIdentifier retrieves all grants held by an identifier
+func (r *mailboxACLRepository) ListByIdentifier(identifier string) ([]*domain.MailboxACL, error) {
+	query := `
+		SELECT mailbox_id, identifier, rights, created_at, updated_at
+		FROM mailbox_acl
+		WHERE identifier = ?
+		ORDER BY mailbox_id
+	`
+	return r.list(query, identifier)
+}
+
+// Delete removes an identifier's rights on a mailbox
+func (r *mailboxACLRepository) Delete(mailboxID int64, identifier string) error {
+	query := `DELETE FROM mailbox_acl WHERE mailbox_id = ? AND identifier = ?`
+	if _, err := r.db.Exec(query, mailboxID, identifier); err != nil {
+		return fmt.Errorf("failed to delete mailbox ACL: %w", err)
+	}
+	return nil
+}
+
+func (r *mailboxACLRepository) list(query string, arg interface{}) ([]*domain.MailboxACL, error) {
+	rows, err := r.db.Query(query, arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mailbox ACLs: %w", err)
+	}
+	defer rows.Close()
+
+	acls := make([]*domain.MailboxACL, 0)
+	for rows.Next() {
+		acl := &domain.MailboxACL{}
+		if err := rows.Scan(&acl.MailboxID, &acl.Identifier, &acl.Rights, &acl.CreatedAt, &acl.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan mailbox ACL: %w", err)
+		}
+		acls = append(acls, acl)
+	}
+
+	return acls, rows.Err()
+}
@@ -169,12 +169,52 @@ func (r *mailboxRepository) Update(mailbox *domain.Mailbox) error {
 	return nil
 }
 
+// AllocateUID atomically reserves the next UID in a mailbox
+func (r *mailboxRepository) AllocateUID(id int64) (int64, error) {
+	query := `
+		UPDATE mailboxes SET uidnext = uidnext + 1
+		WHERE id = ?
+		RETURNING uidnext - 1
+	`
+
+	var uid int64
+	err := r.db.QueryRow(query, id).Scan(&uid)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("mailbox not found: %w", err)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to allocate UID: %w", err)
+	}
+
+	return uid, nil
+}
+
 // Delete deletes a mailbox
+// Its messages are removed by the foreign key cascade, so their size is
+// released from the owner's usage in the same transaction.
 func (r *mailboxRepository) Delete(id int64) error {
-	query := `DELETE FROM mailboxes WHERE id = ?`
-	_, err := r.db.Exec(query, id)
+	tx, err := r.db.Begin()
 	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	release := `
+		UPDATE users SET used_quota = MAX(used_quota - (
+			SELECT COALESCE(SUM(size), 0) FROM messages WHERE mailbox_id = ?
+		), 0)
+		WHERE id = (SELECT user_id FROM mailboxes WHERE id = ?)
+	`
+	if _, err := tx.Exec(release, id, id); err != nil {
+		return fmt.Errorf("failed to update used quota: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM mailboxes WHERE id = ?`, id); err != nil {
 		return fmt.Errorf("failed to delete mailbox: %w", err)
 	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit mailbox deletion: %w", err)
+	}
 	return nil
 }
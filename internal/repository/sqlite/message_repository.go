@@ -3,6 +3,7 @@ package sqlite
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/btafoya/gomailserver/internal/database"
@@ -20,21 +21,45 @@ func NewMessageRepository(db *database.DB) repository.MessageRepository {
 }
 
 // Create inserts a new message
+// A message with a BodyHash refers to that body, which is stored from the
+// message's content unless it already exists; the message row itself then
+// holds no content.
 func (r *messageRepository) Create(message *domain.Message) error {
 	query := `
 		INSERT INTO messages (
 			user_id, mailbox_id, uid, size, flags, categories, thread_id,
 			received_at, internal_date, subject, from_addr, to_addr, cc_addr, bcc_addr, reply_to,
 			message_id, in_reply_to, refs, headers, body_structure,
-			storage_type, content, content_path, created_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			storage_type, content, content_path, body_hash, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	result, err := r.db.Exec(query,
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	content, contentPath, bodyHash := message.Content, message.ContentPath, sql.NullString{}
+	if message.BodyHash != "" {
+		if err := insertBody(tx, &domain.MessageBody{
+			Hash:        message.BodyHash,
+			Size:        message.Size,
+			StorageType: message.StorageType,
+			Content:     message.Content,
+			ContentPath: message.ContentPath,
+		}); err != nil {
+			return err
+		}
+		content, contentPath = nil, ""
+		bodyHash = sql.NullString{String: message.BodyHash, Valid: true}
+	}
+
+	result, err := tx.Exec(query,
 		message.UserID, message.MailboxID, message.UID, message.Size, message.Flags, message.Categories, message.ThreadID,
 		message.ReceivedAt, message.InternalDate, message.Subject, message.From, message.To, message.CC, message.BCC, message.ReplyTo,
 		message.MessageID, message.InReplyTo, message.Refs, message.Headers, message.BodyStructure,
-		message.StorageType, message.Content, message.ContentPath, time.Now(),
+		message.StorageType, content, contentPath, bodyHash, time.Now(),
 	)
 	if err != nil {
 		return fmt.Errorf("failed to create message: %w", err)
@@ -45,6 +70,15 @@ func (r *messageRepository) Create(message *domain.Message) error {
 		return fmt.Errorf("failed to get message ID: %w", err)
 	}
 
+	// The owner's usage changes in the same transaction as the message
+	if _, err := tx.Exec(`UPDATE users SET used_quota = used_quota + ? WHERE id = ?`, message.Size, message.UserID); err != nil {
+		return fmt.Errorf("failed to update used quota: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit message: %w", err)
+	}
+
 	message.ID = id
 	message.CreatedAt = time.Now()
 
@@ -55,12 +89,14 @@ func (r *messageRepository) Create(message *domain.Message) error {
 func (r *messageRepository) GetByID(id int64) (*domain.Message, error) {
 	query := `
 		SELECT
-			id, user_id, mailbox_id, uid, size, flags, categories, thread_id,
-			received_at, internal_date, subject, from_addr, to_addr, cc_addr, bcc_addr, reply_to,
-			message_id, in_reply_to, refs, headers, body_structure,
-			storage_type, content, content_path, created_at
-		FROM messages
-		WHERE id = ?
+			m.id, m.user_id, m.mailbox_id, m.uid, m.size, m.flags, m.categories, m.thread_id,
+			m.received_at, m.internal_date, m.subject, m.from_addr, m.to_addr, m.cc_addr, m.bcc_addr, m.reply_to,
+			m.message_id, m.in_reply_to, m.refs, m.headers, m.body_structure,
+			COALESCE(b.storage_type, m.storage_type), COALESCE(b.content, m.content),
+			COALESCE(b.content_path, m.content_path, ''), COALESCE(m.body_hash, ''), m.created_at
+		FROM messages m
+		LEFT JOIN message_bodies b ON b.hash = m.body_hash
+		WHERE m.id = ?
 	`
 
 	message := &domain.Message{}
@@ -69,7 +105,7 @@ func (r *messageRepository) GetByID(id int64) (*domain.Message, error) {
 		&message.ID, &message.UserID, &message.MailboxID, &message.UID, &message.Size, &message.Flags, &message.Categories, &message.ThreadID,
 		&message.ReceivedAt, &message.InternalDate, &message.Subject, &message.From, &message.To, &message.CC, &message.BCC, &message.ReplyTo,
 		&message.MessageID, &message.InReplyTo, &message.Refs, &message.Headers, &message.BodyStructure,
-		&message.StorageType, &message.Content, &message.ContentPath, &message.CreatedAt,
+		&message.StorageType, &message.Content, &message.ContentPath, &message.BodyHash, &message.CreatedAt,
 	)
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("message not found: %w", err)
@@ -85,13 +121,15 @@ func (r *messageRepository) GetByID(id int64) (*domain.Message, error) {
 func (r *messageRepository) GetByMailbox(mailboxID int64, offset, limit int) ([]*domain.Message, error) {
 	query := `
 		SELECT
-			id, user_id, mailbox_id, uid, size, flags, categories, thread_id,
-			received_at, internal_date, subject, from_addr, to_addr, cc_addr, bcc_addr, reply_to,
-			message_id, in_reply_to, refs, headers, body_structure,
-			storage_type, content, content_path, created_at
-		FROM messages
-		WHERE mailbox_id = ?
-		ORDER BY received_at DESC
+			m.id, m.user_id, m.mailbox_id, m.uid, m.size, m.flags, m.categories, m.thread_id,
+			m.received_at, m.internal_date, m.subject, m.from_addr, m.to_addr, m.cc_addr, m.bcc_addr, m.reply_to,
+			m.message_id, m.in_reply_to, m.refs, m.headers, m.body_structure,
+			COALESCE(b.storage_type, m.storage_type), COALESCE(b.content, m.content),
+			COALESCE(b.content_path, m.content_path, ''), COALESCE(m.body_hash, ''), m.created_at
+		FROM messages m
+		LEFT JOIN message_bodies b ON b.hash = m.body_hash
+		WHERE m.mailbox_id = ?
+		ORDER BY m.received_at DESC
 		LIMIT ? OFFSET ?
 	`
 
@@ -109,7 +147,48 @@ func (r *messageRepository) GetByMailbox(mailboxID int64, offset, limit int) ([]
 			&message.ID, &message.UserID, &message.MailboxID, &message.UID, &message.Size, &message.Flags, &message.Categories, &message.ThreadID,
 			&message.ReceivedAt, &message.InternalDate, &message.Subject, &message.From, &message.To, &message.CC, &message.BCC, &message.ReplyTo,
 			&message.MessageID, &message.InReplyTo, &message.Refs, &message.Headers, &message.BodyStructure,
-			&message.StorageType, &message.Content, &message.ContentPath, &message.CreatedAt,
+			&message.StorageType, &message.Content, &message.ContentPath, &message.BodyHash, &message.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages, rows.Err()
+}
+
+// ListByMailbox retrieves every message in a mailbox in UID order, without content
+func (r *messageRepository) ListByMailbox(mailboxID int64) ([]*domain.Message, error) {
+	query := `
+		SELECT
+			m.id, m.user_id, m.mailbox_id, m.uid, m.size, m.flags, m.categories, m.thread_id,
+			m.received_at, m.internal_date, m.subject, m.from_addr, m.to_addr, m.cc_addr, m.bcc_addr, m.reply_to,
+			m.message_id, m.in_reply_to, m.refs, m.headers, m.body_structure,
+			COALESCE(b.storage_type, m.storage_type), COALESCE(b.content_path, m.content_path, ''),
+			COALESCE(m.body_hash, ''), m.created_at
+		FROM messages m
+		LEFT JOIN message_bodies b ON b.hash = m.body_hash
+		WHERE m.mailbox_id = ?
+		ORDER BY m.uid
+	`
+
+	rows, err := r.db.Query(query, mailboxID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := make([]*domain.Message, 0)
+	for rows.Next() {
+		message := &domain.Message{}
+
+		err := rows.Scan(
+			&message.ID, &message.UserID, &message.MailboxID, &message.UID, &message.Size, &message.Flags, &message.Categories, &message.ThreadID,
+			&message.ReceivedAt, &message.InternalDate, &message.Subject, &message.From, &message.To, &message.CC, &message.BCC, &message.ReplyTo,
+			&message.MessageID, &message.InReplyTo, &message.Refs, &message.Headers, &message.BodyStructure,
+			&message.StorageType, &message.ContentPath, &message.BodyHash, &message.CreatedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan message: %w", err)
@@ -121,6 +200,156 @@ func (r *messageRepository) GetByMailbox(mailboxID int64, offset, limit int) ([]
 	return messages, rows.Err()
 }
 
+// messageOrderColumns maps sort keys to the message columns they order by
+var messageOrderColumns = map[string]string{
+	domain.MessageOrderArrival: "m.internal_date",
+	domain.MessageOrderSubject: "m.subject",
+	domain.MessageOrderFrom:    "m.from_addr",
+	domain.MessageOrderTo:      "m.to_addr",
+	domain.MessageOrderCc:      "m.cc_addr",
+	domain.MessageOrderSize:    "m.size",
+}
+
+// messageThreadColumns maps thread groupings to the expression grouping a thread
+// Messages without a thread ID each start their own thread.
+var messageThreadColumns = map[string]string{
+	domain.MessageThreadID:      "COALESCE(NULLIF(m.thread_id, ''), m.id)",
+	domain.MessageThreadSubject: "COALESCE(m.subject, '')",
+}
+
+// messageSeqNum is a message's sequence number, its position in UID order
+const messageSeqNum = `(SELECT COUNT(*) FROM messages s WHERE s.mailbox_id = m.mailbox_id AND s.uid <= m.uid)`
+
+// Query finds, filters and orders a mailbox's messages, without content
+func (r *messageRepository) Query(q *domain.MessageQuery) (*domain.MessageQueryResult, error) {
+	result := &domain.MessageQueryResult{}
+	err := r.db.QueryRow(`SELECT COUNT(*), COALESCE(MAX(uid), 0) FROM messages WHERE mailbox_id = ?`, q.MailboxID).
+		Scan(&result.Count, &result.LastUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count messages: %w", err)
+	}
+	if result.Count == 0 {
+		return result, nil
+	}
+
+	where := []string{"m.mailbox_id = ?"}
+	args := []interface{}{q.MailboxID}
+	if q.UIDs != nil {
+		cond, rangeArgs := rangeCondition("m.uid", q.UIDs, result.LastUID)
+		where = append(where, cond)
+		args = append(args, rangeArgs...)
+	}
+	if q.SeqNums != nil {
+		cond, rangeArgs := rangeCondition(messageSeqNum, q.SeqNums, result.Count)
+		where = append(where, cond)
+		args = append(args, rangeArgs...)
+	}
+	// Flags are stored space-separated
+	for _, flag := range q.WithFlags {
+		where = append(where, "instr(' ' || COALESCE(m.flags, '') || ' ', ?) > 0")
+		args = append(args, " "+flag+" ")
+	}
+	for _, flag := range q.WithoutFlags {
+		where = append(where, "instr(' ' || COALESCE(m.flags, '') || ' ', ?) = 0")
+		args = append(args, " "+flag+" ")
+	}
+	if q.Larger > 0 {
+		where = append(where, "m.size > ?")
+		args = append(args, q.Larger)
+	}
+	if q.Smaller > 0 {
+		where = append(where, "m.size < ?")
+		args = append(args, q.Smaller)
+	}
+
+	var order []string
+	if q.Thread != "" {
+		thread, ok := messageThreadColumns[q.Thread]
+		if !ok {
+			return nil, fmt.Errorf("unknown thread grouping %q", q.Thread)
+		}
+		order = append(order,
+			"MIN(m.internal_date) OVER (PARTITION BY "+thread+")",
+			thread,
+			"m.internal_date",
+		)
+	} else {
+		for _, o := range q.Order {
+			column, ok := messageOrderColumns[o.Key]
+			if !ok {
+				return nil, fmt.Errorf("unknown sort key %q", o.Key)
+			}
+			if o.Reverse {
+				column += " DESC"
+			}
+			order = append(order, column)
+		}
+	}
+	order = append(order, "m.uid")
+
+	query := `
+		SELECT
+			m.id, m.user_id, m.mailbox_id, m.uid, m.size, m.flags, m.categories, m.thread_id,
+			m.received_at, m.internal_date, m.subject, m.from_addr, m.to_addr, m.cc_addr, m.bcc_addr, m.reply_to,
+			m.message_id, m.in_reply_to, m.refs, m.headers, m.body_structure,
+			COALESCE(b.storage_type, m.storage_type), COALESCE(b.content_path, m.content_path, ''),
+			COALESCE(m.body_hash, ''), m.created_at, ` + messageSeqNum + `
+		FROM messages m
+		LEFT JOIN message_bodies b ON b.hash = m.body_hash
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY ` + strings.Join(order, ", ")
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		message := &domain.Message{}
+		var seqNum uint32
+
+		err := rows.Scan(
+			&message.ID, &message.UserID, &message.MailboxID, &message.UID, &message.Size, &message.Flags, &message.Categories, &message.ThreadID,
+			&message.ReceivedAt, &message.InternalDate, &message.Subject, &message.From, &message.To, &message.CC, &message.BCC, &message.ReplyTo,
+			&message.MessageID, &message.InReplyTo, &message.Refs, &message.Headers, &message.BodyStructure,
+			&message.StorageType, &message.ContentPath, &message.BodyHash, &message.CreatedAt, &seqNum,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+
+		result.Matches = append(result.Matches, domain.MessageMatch{SeqNum: seqNum, Message: message})
+	}
+
+	return result, rows.Err()
+}
+
+// rangeCondition returns a condition matching column against ranges, with 0
+// standing for last
+func rangeCondition(column string, ranges []domain.MessageRange, last uint32) (string, []interface{}) {
+	if len(ranges) == 0 {
+		return "0", nil
+	}
+	conds := make([]string, 0, len(ranges))
+	args := make([]interface{}, 0, 2*len(ranges))
+	for _, rng := range ranges {
+		start, stop := rng.Start, rng.Stop
+		if start == 0 {
+			start = last
+		}
+		if stop == 0 {
+			stop = last
+		}
+		if start > stop {
+			start, stop = stop, start
+		}
+		conds = append(conds, column+" BETWEEN ? AND ?")
+		args = append(args, start, stop)
+	}
+	return "(" + strings.Join(conds, " OR ") + ")", args
+}
+
 // Update updates a message
 func (r *messageRepository) Update(message *domain.Message) error {
 	query := `
@@ -144,12 +373,156 @@ func (r *messageRepository) Update(message *domain.Message) error {
 	return nil
 }
 
-// Delete deletes a message
+// UpdateFlags replaces a message's flags
+func (r *messageRepository) UpdateFlags(id int64, flags string) error {
+	if _, err := r.db.Exec(`UPDATE messages SET flags = ? WHERE id = ?`, flags, id); err != nil {
+		return fmt.Errorf("failed to update message flags: %w", err)
+	}
+	return nil
+}
+
+// Move reassigns a message to another mailbox of the same owner under a new UID
+func (r *messageRepository) Move(id, mailboxID int64, uid uint32) error {
+	if _, err := r.db.Exec(`UPDATE messages SET mailbox_id = ?, uid = ? WHERE id = ?`, mailboxID, uid, id); err != nil {
+		return fmt.Errorf("failed to move message: %w", err)
+	}
+	return nil
+}
+
+// Delete deletes a message and releases its size from the owner's usage
 func (r *messageRepository) Delete(id int64) error {
-	query := `DELETE FROM messages WHERE id = ?`
-	_, err := r.db.Exec(query, id)
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID, size int64
+	err = tx.QueryRow(`DELETE FROM messages WHERE id = ? RETURNING user_id, size`, id).Scan(&userID, &size)
+	if err == sql.ErrNoRows {
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("failed to delete message: %w", err)
 	}
+
+	if _, err := tx.Exec(`UPDATE users SET used_quota = MAX(used_quota - ?, 0) WHERE id = ?`, size, userID); err != nil {
+		return fmt.Errorf("failed to update used quota: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit message deletion: %w", err)
+	}
+	return nil
+}
+
+// Usage counts a user's stored messages and their total size
+func (r *messageRepository) Usage(userID int64) (messages, size int64, err error) {
+	query := `SELECT COUNT(*), COALESCE(SUM(size), 0) FROM messages WHERE user_id = ?`
+	if err := r.db.QueryRow(query, userID).Scan(&messages, &size); err != nil {
+		return 0, 0, fmt.Errorf("failed to compute message usage: %w", err)
+	}
+	return messages, size, nil
+}
+
+// insertBody stores a message body unless one with its hash already exists
+func insertBody(tx *sql.Tx, body *domain.MessageBody) error {
+	query := `
+		INSERT INTO message_bodies (hash, size, storage_type, content, content_path, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(hash) DO NOTHING
+	`
+
+	_, err := tx.Exec(query, body.Hash, body.Size, body.StorageType, body.Content, body.ContentPath, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to store message body: %w", err)
+	}
+	return nil
+}
+
+// SetBody moves a message stored with its own content to a shared body
+func (r *messageRepository) SetBody(id int64, body *domain.MessageBody) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertBody(tx, body); err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE messages SET body_hash = ?, storage_type = ?, content = NULL, content_path = ''
+		WHERE id = ? AND body_hash IS NULL
+	`
+	if _, err := tx.Exec(query, body.Hash, body.StorageType, id); err != nil {
+		return fmt.Errorf("failed to set message body: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit message body: %w", err)
+	}
 	return nil
 }
+
+// ListWithoutBody returns the IDs after afterID of messages that hold their own content, in ID order
+func (r *messageRepository) ListWithoutBody(afterID int64, limit int) ([]int64, error) {
+	query := `SELECT id FROM messages WHERE id > ? AND body_hash IS NULL ORDER BY id LIMIT ?`
+
+	rows, err := r.db.Query(query, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages without body: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan message ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// UnreferencedBodies returns the hashes of bodies no message refers to
+func (r *messageRepository) UnreferencedBodies(limit int) ([]string, error) {
+	query := `SELECT hash FROM message_bodies WHERE ref_count <= 0 LIMIT ?`
+
+	rows, err := r.db.Query(query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unreferenced bodies: %w", err)
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, fmt.Errorf("failed to scan body hash: %w", err)
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+// DeleteBody deletes a body if no message refers to it and returns it
+// It returns nil when the body is still referenced or already gone.
+func (r *messageRepository) DeleteBody(hash string) (*domain.MessageBody, error) {
+	query := `
+		DELETE FROM message_bodies WHERE hash = ? AND ref_count <= 0
+		RETURNING hash, size, storage_type, content_path
+	`
+
+	body := &domain.MessageBody{}
+	err := r.db.QueryRow(query, hash).Scan(&body.Hash, &body.Size, &body.StorageType, &body.ContentPath)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete message body: %w", err)
+	}
+	return body, nil
+}
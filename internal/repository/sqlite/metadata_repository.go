@@ -0,0 +1,77 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/btafoya/gomailserver/internal/database"
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/repository"
+)
+
+type metadataRepository struct {
+	db *database.DB
+}
+
+// NewMetadataRepository creates a new SQLite IMAP METADATA repository
+func NewMetadataRepository(db *database.DB) repository.MetadataRepository {
+	return &metadataRepository{db: db}
+}
+
+// nullID stores the 0 ID of shared and server entries as NULL
+func nullID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
+
+// Set stores an entry, replacing any previous value
+func (r *metadataRepository) Set(entry *domain.MetadataEntry) error {
+	query := `
+		INSERT INTO metadata (user_id, mailbox_id, name, value, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(IFNULL(mailbox_id, 0), IFNULL(user_id, 0), name) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at
+	`
+
+	now := time.Now()
+	if _, err := r.db.Exec(query, nullID(entry.UserID), nullID(entry.MailboxID), entry.Name, entry.Value, now); err != nil {
+		return fmt.Errorf("failed to set metadata: %w", err)
+	}
+	entry.UpdatedAt = now
+	return nil
+}
+
+// Delete removes an entry
+func (r *metadataRepository) Delete(userID, mailboxID int64, name string) error {
+	query := `DELETE FROM metadata WHERE IFNULL(mailbox_id, 0) = ? AND IFNULL(user_id, 0) = ? AND name = ?`
+	if _, err := r.db.Exec(query, mailboxID, userID, name); err != nil {
+		return fmt.Errorf("failed to delete metadata: %w", err)
+	}
+	return nil
+}
+
+// List retrieves the shared entries of a mailbox and the user's private ones
+func (r *metadataRepository) List(userID, mailboxID int64) ([]*domain.MetadataEntry, error) {
+	query := `
+		SELECT IFNULL(user_id, 0), IFNULL(mailbox_id, 0), name, value, updated_at
+		FROM metadata
+		WHERE IFNULL(mailbox_id, 0) = ? AND (user_id IS NULL OR user_id = ?)
+		ORDER BY name
+	`
+
+	rows, err := r.db.Query(query, mailboxID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metadata: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make([]*domain.MetadataEntry, 0)
+	for rows.Next() {
+		entry := &domain.MetadataEntry{}
+		if err := rows.Scan(&entry.UserID, &entry.MailboxID, &entry.Name, &entry.Value, &entry.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan metadata: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
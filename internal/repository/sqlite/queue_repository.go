@@ -23,14 +23,16 @@ func NewQueueRepository(db *database.DB) repository.QueueRepository {
 func (r *queueRepository) Enqueue(item *domain.QueueItem) error {
 	query := `
 		INSERT INTO smtp_queue (
-			sender, recipients, message_id, message_path,
+			sender, recipients, message_id, message_path, body_type,
+			smtputf8, require_tls, dsn_ret, dsn_envid, dsn_recipients, spam, local_only, recipient_status,
 			retry_count, max_retries, next_retry, status,
 			error_message, created_at, updated_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
 	result, err := r.db.Exec(query,
-		item.Sender, item.Recipients, item.MessageID, item.MessagePath,
+		item.Sender, item.Recipients, item.MessageID, item.MessagePath, item.BodyType,
+		item.SMTPUTF8, item.RequireTLS, item.DSNReturn, item.DSNEnvelopeID, item.DSNRecipients, item.Spam, item.LocalOnly, item.RcptStatus,
 		item.RetryCount, item.MaxRetries, item.NextRetry, item.Status,
 		item.ErrorMessage, time.Now(), time.Now(),
 	)
@@ -54,7 +56,8 @@ func (r *queueRepository) Enqueue(item *domain.QueueItem) error {
 func (r *queueRepository) GetPending() ([]*domain.QueueItem, error) {
 	query := `
 		SELECT
-			id, sender, recipients, message_id, message_path,
+			id, sender, recipients, message_id, message_path, body_type,
+			smtputf8, require_tls, dsn_ret, dsn_envid, dsn_recipients, spam, local_only, recipient_status,
 			retry_count, max_retries, next_retry, status,
 			error_message, created_at, updated_at
 		FROM smtp_queue
@@ -63,10 +66,39 @@ func (r *queueRepository) GetPending() ([]*domain.QueueItem, error) {
 		ORDER BY created_at ASC
 	`
 
-	rows, err := r.db.Query(query, time.Now())
+	items, err := r.listItems(query, time.Now())
 	if err != nil {
 		return nil, fmt.Errorf("failed to get pending queue items: %w", err)
 	}
+	return items, nil
+}
+
+// GetByStatus retrieves all queue items with a status, oldest first
+func (r *queueRepository) GetByStatus(status string) ([]*domain.QueueItem, error) {
+	query := `
+		SELECT
+			id, sender, recipients, message_id, message_path, body_type,
+			smtputf8, require_tls, dsn_ret, dsn_envid, dsn_recipients, spam, local_only, recipient_status,
+			retry_count, max_retries, next_retry, status,
+			error_message, created_at, updated_at
+		FROM smtp_queue
+		WHERE status = ?
+		ORDER BY created_at ASC
+	`
+
+	items, err := r.listItems(query, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get queue items: %w", err)
+	}
+	return items, nil
+}
+
+// listItems runs a queue item query and scans its rows
+func (r *queueRepository) listItems(query string, args ...interface{}) ([]*domain.QueueItem, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	items := make([]*domain.QueueItem, 0)
@@ -75,7 +107,8 @@ func (r *queueRepository) GetPending() ([]*domain.QueueItem, error) {
 		var nextRetry sql.NullTime
 
 		err := rows.Scan(
-			&item.ID, &item.Sender, &item.Recipients, &item.MessageID, &item.MessagePath,
+			&item.ID, &item.Sender, &item.Recipients, &item.MessageID, &item.MessagePath, &item.BodyType,
+			&item.SMTPUTF8, &item.RequireTLS, &item.DSNReturn, &item.DSNEnvelopeID, &item.DSNRecipients, &item.Spam, &item.LocalOnly, &item.RcptStatus,
 			&item.RetryCount, &item.MaxRetries, &nextRetry, &item.Status,
 			&item.ErrorMessage, &item.CreatedAt, &item.UpdatedAt,
 		)
@@ -97,7 +130,8 @@ func (r *queueRepository) GetPending() ([]*domain.QueueItem, error) {
 func (r *queueRepository) GetByID(id int64) (*domain.QueueItem, error) {
 	query := `
 		SELECT
-			id, sender, recipients, message_id, message_path,
+			id, sender, recipients, message_id, message_path, body_type,
+			smtputf8, require_tls, dsn_ret, dsn_envid, dsn_recipients, spam, local_only, recipient_status,
 			retry_count, max_retries, next_retry, status,
 			error_message, created_at, updated_at
 		FROM smtp_queue
@@ -108,7 +142,8 @@ func (r *queueRepository) GetByID(id int64) (*domain.QueueItem, error) {
 	var nextRetry sql.NullTime
 
 	err := r.db.QueryRow(query, id).Scan(
-		&item.ID, &item.Sender, &item.Recipients, &item.MessageID, &item.MessagePath,
+		&item.ID, &item.Sender, &item.Recipients, &item.MessageID, &item.MessagePath, &item.BodyType,
+		&item.SMTPUTF8, &item.RequireTLS, &item.DSNReturn, &item.DSNEnvelopeID, &item.DSNRecipients, &item.Spam, &item.LocalOnly, &item.RcptStatus,
 		&item.RetryCount, &item.MaxRetries, &nextRetry, &item.Status,
 		&item.ErrorMessage, &item.CreatedAt, &item.UpdatedAt,
 	)
@@ -162,6 +197,57 @@ func (r *queueRepository) UpdateRetry(id int64, retryCount int, nextRetry time.T
 	return nil
 }
 
+// UpdateRecipients replaces the remaining recipient list of a queue item
+func (r *queueRepository) UpdateRecipients(id int64, recipients string) error {
+	query := `
+		UPDATE smtp_queue SET
+			recipients = ?,
+			updated_at = ?
+		WHERE id = ?
+	`
+
+	_, err := r.db.Exec(query, recipients, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update queue item recipients: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateRecipientStatus replaces the per-recipient delivery outcomes of a queue item
+func (r *queueRepository) UpdateRecipientStatus(id int64, recipientStatus string) error {
+	query := `
+		UPDATE smtp_queue SET
+			recipient_status = ?,
+			updated_at = ?
+		WHERE id = ?
+	`
+
+	_, err := r.db.Exec(query, recipientStatus, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update queue item recipient status: %w", err)
+	}
+
+	return nil
+}
+
+// RequeueProcessing returns items left in 'processing' by an interrupted worker to 'pending'
+func (r *queueRepository) RequeueProcessing() (int64, error) {
+	query := `
+		UPDATE smtp_queue SET
+			status = 'pending',
+			updated_at = ?
+		WHERE status = 'processing'
+	`
+
+	result, err := r.db.Exec(query, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue processing items: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
 // Delete deletes a queue item
 func (r *queueRepository) Delete(id int64) error {
 	query := `DELETE FROM smtp_queue WHERE id = ?`
@@ -0,0 +1,282 @@
+package sqlite
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/btafoya/gomailserver/internal/database"
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/repository"
+)
+
+// searchColumns are the indexed text columns of message_search, in FTS order
+var searchColumns = []string{"from_text", "to_text", "subject", "body", "attachments"}
+
+// searchTriggers keep message_fts in step with message_search
+var searchTriggers = map[string]string{
+	"message_search_ai": `CREATE TRIGGER IF NOT EXISTS message_search_ai AFTER INSERT ON message_search BEGIN
+		INSERT INTO message_fts (rowid, from_text, to_text, subject, body, attachments)
+		VALUES (new.message_id, new.from_text, new.to_text, new.subject, new.body, new.attachments);
+	END`,
+	"message_search_ad": `CREATE TRIGGER IF NOT EXISTS message_search_ad AFTER DELETE ON message_search BEGIN
+		INSERT INTO message_fts (message_fts, rowid, from_text, to_text, subject, body, attachments)
+		VALUES ('delete', old.message_id, old.from_text, old.to_text, old.subject, old.body, old.attachments);
+	END`,
+	"message_search_au": `CREATE TRIGGER IF NOT EXISTS message_search_au AFTER UPDATE ON message_search BEGIN
+		INSERT INTO message_fts (message_fts, rowid, from_text, to_text, subject, body, attachments)
+		VALUES ('delete', old.message_id, old.from_text, old.to_text, old.subject, old.body, old.attachments);
+		INSERT INTO message_fts (rowid, from_text, to_text, subject, body, attachments)
+		VALUES (new.message_id, new.from_text, new.to_text, new.subject, new.body, new.attachments);
+	END`,
+}
+
+type searchRepository struct {
+	db  *database.DB
+	fts atomic.Bool
+}
+
+// NewSearchRepository creates a new SQLite full-text search repository
+func NewSearchRepository(db *database.DB) repository.SearchRepository {
+	return &searchRepository{db: db}
+}
+
+// EnableFTS creates the FTS5 index when SQLite was built with FTS5 and
+// reports whether searches use it
+// An index missing its triggers, because it is new or a build without FTS5
+// ran since, is rebuilt from message_search.
+func (r *searchRepository) EnableFTS() (bool, error) {
+	var available bool
+	if err := r.db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&available); err != nil {
+		return false, fmt.Errorf("failed to check for FTS5: %w", err)
+	}
+	if !available {
+		// Without the module, triggers writing to message_fts would fail every insert
+		for name := range searchTriggers {
+			if _, err := r.db.Exec(`DROP TRIGGER IF EXISTS ` + name); err != nil {
+				return false, fmt.Errorf("failed to drop search trigger: %w", err)
+			}
+		}
+		r.fts.Store(false)
+		return false, nil
+	}
+
+	var triggers int
+	err := r.db.QueryRow(`
+		SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger'
+		AND name IN ('message_search_ai', 'message_search_ad', 'message_search_au')
+	`).Scan(&triggers)
+	if err != nil {
+		return false, fmt.Errorf("failed to check search triggers: %w", err)
+	}
+
+	if triggers < len(searchTriggers) {
+		tx, err := r.db.Begin()
+		if err != nil {
+			return false, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		statements := []string{`
+			CREATE VIRTUAL TABLE IF NOT EXISTS message_fts USING fts5(
+				from_text, to_text, subject, body, attachments,
+				content = 'message_search', content_rowid = 'message_id',
+				tokenize = 'unicode61 remove_diacritics 2'
+			)`,
+		}
+		for _, trigger := range searchTriggers {
+			statements = append(statements, trigger)
+		}
+		statements = append(statements, `INSERT INTO message_fts (message_fts) VALUES ('rebuild')`)
+
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return false, fmt.Errorf("failed to create search index: %w", err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return false, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+	}
+
+	r.fts.Store(true)
+	return true, nil
+}
+
+// Index stores a message's text, replacing any earlier entry
+func (r *searchRepository) Index(doc *domain.SearchDocument) error {
+	query := `
+		INSERT INTO message_search (message_id, from_text, to_text, subject, body, attachments, has_attachment)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(message_id) DO UPDATE SET
+			from_text = excluded.from_text, to_text = excluded.to_text, subject = excluded.subject,
+			body = excluded.body, attachments = excluded.attachments, has_attachment = excluded.has_attachment
+	`
+
+	_, err := r.db.Exec(query, doc.MessageID, doc.From, doc.To, doc.Subject, doc.Body, doc.Attachments, doc.HasAttachment)
+	if err != nil {
+		return fmt.Errorf("failed to index message: %w", err)
+	}
+	return nil
+}
+
+// Copy indexes a copied message with the text of its original
+func (r *searchRepository) Copy(fromID, toID int64) error {
+	query := `
+		INSERT INTO message_search (message_id, from_text, to_text, subject, body, attachments, has_attachment)
+		SELECT ?, from_text, to_text, subject, body, attachments, has_attachment
+		FROM message_search WHERE message_id = ?
+		ON CONFLICT(message_id) DO NOTHING
+	`
+
+	if _, err := r.db.Exec(query, toID, fromID); err != nil {
+		return fmt.Errorf("failed to copy search entry: %w", err)
+	}
+	return nil
+}
+
+// Search returns the IDs of a user's messages matching the query, newest first
+func (r *searchRepository) Search(q *domain.SearchQuery) ([]int64, error) {
+	where := []string{"m.user_id = ?"}
+	args := []interface{}{q.UserID}
+	if q.MailboxID != 0 {
+		where = append(where, "m.mailbox_id = ?")
+		args = append(args, q.MailboxID)
+	}
+	if q.HasAttachment {
+		where = append(where, "s.has_attachment = 1")
+	}
+
+	// Terms go to FTS5 when it is enabled; terms without a letter or digit
+	// have no tokens to look up, so they are matched as substrings instead
+	fts := r.fts.Load()
+	var match []string
+	addTerms := func(columns []string, terms []string) {
+		for _, term := range terms {
+			if fts && hasWordChar(term) {
+				match = append(match, ftsPhrase(columns, term))
+				continue
+			}
+			likes := make([]string, len(columns))
+			for i, column := range columns {
+				likes[i] = "s." + column + ` LIKE ? ESCAPE '\'`
+				args = append(args, likePattern(term))
+			}
+			where = append(where, "("+strings.Join(likes, " OR ")+")")
+		}
+	}
+	addTerms(searchColumns, q.Text)
+	addTerms([]string{"body", "attachments"}, q.Body)
+	addTerms([]string{"from_text"}, q.From)
+	addTerms([]string{"to_text"}, q.To)
+	addTerms([]string{"subject"}, q.Subject)
+	if len(match) > 0 {
+		where = append(where, "m.id IN (SELECT rowid FROM message_fts WHERE message_fts MATCH ?)")
+		args = append(args, strings.Join(match, " AND "))
+	}
+
+	query := `
+		SELECT m.id, m.internal_date FROM messages m
+		JOIN message_search s ON s.message_id = m.id
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY m.internal_date DESC, m.id DESC
+	`
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	defer rows.Close()
+
+	// Dates are compared here; stored timestamps carry their own time zone
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		var date time.Time
+		if err := rows.Scan(&id, &date); err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		if (!q.After.IsZero() && date.Before(q.After)) || (!q.Before.IsZero() && !date.Before(q.Before)) {
+			continue
+		}
+		ids = append(ids, id)
+		if q.Limit > 0 && len(ids) == q.Limit {
+			break
+		}
+	}
+	return ids, rows.Err()
+}
+
+// Unindexed returns the IDs after afterID of messages that have no search entry, in ID order
+func (r *searchRepository) Unindexed(afterID int64, limit int) ([]int64, error) {
+	query := `
+		SELECT m.id FROM messages m
+		LEFT JOIN message_search s ON s.message_id = m.id
+		WHERE m.id > ? AND s.message_id IS NULL
+		ORDER BY m.id LIMIT ?
+	`
+
+	rows, err := r.db.Query(query, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unindexed messages: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan message ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Indexed returns the IDs after afterID of messages that have a search entry, in ID order
+func (r *searchRepository) Indexed(afterID int64, limit int) ([]int64, error) {
+	query := `SELECT message_id FROM message_search WHERE message_id > ? ORDER BY message_id LIMIT ?`
+
+	rows, err := r.db.Query(query, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list indexed messages: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan message ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ftsPhrase builds an FTS5 prefix phrase query for a term, limited to columns
+func ftsPhrase(columns []string, term string) string {
+	phrase := `"` + strings.ReplaceAll(term, `"`, `""`) + `" *`
+	if len(columns) == len(searchColumns) {
+		return phrase
+	}
+	return "{" + strings.Join(columns, " ") + "} : " + phrase
+}
+
+// likePattern matches term anywhere, escaping LIKE wildcards
+func likePattern(term string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(term)
+	return "%" + escaped + "%"
+}
+
+// hasWordChar reports whether s contains a letter or digit
+func hasWordChar(s string) bool {
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return true
+		}
+	}
+	return false
+}
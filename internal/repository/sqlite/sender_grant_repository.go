@@ -0,0 +1,136 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/btafoya/gomailserver/internal/database"
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/repository"
+)
+
+type senderGrantRepository struct {
+	db *database.DB
+}
+
+// NewSenderGrantRepository creates a new SQLite sender grant repository
+func NewSenderGrantRepository(db *database.DB) repository.SenderGrantRepository {
+	return &senderGrantRepository{db: db}
+}
+
+// Create inserts a new sender grant
+func (r *senderGrantRepository) Create(grant *domain.SenderGrant) error {
+	query := `
+		INSERT INTO sender_grants (user_id, address, grant_type, created_at)
+		VALUES (?, ?, ?, ?)
+	`
+
+	result, err := r.db.Exec(query, grant.UserID, grant.Address, grant.GrantType, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to create sender grant: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get sender grant ID: %w", err)
+	}
+
+	grant.ID = id
+	grant.CreatedAt = time.Now()
+
+	return nil
+}
+
+// GetByID retrieves a sender grant by ID
+func (r *senderGrantRepository) GetByID(id int64) (*domain.SenderGrant, error) {
+	query := `
+		SELECT id, user_id, address, grant_type, created_at
+		FROM sender_grants
+		WHERE id = ?
+	`
+
+	grant := &domain.SenderGrant{}
+	err := r.db.QueryRow(query, id).Scan(
+		&grant.ID, &grant.UserID, &grant.Address, &grant.GrantType, &grant.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("sender grant not found: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sender grant: %w", err)
+	}
+
+	return grant, nil
+}
+
+// ListByUser retrieves all sender grants for a user
+func (r *senderGrantRepository) ListByUser(userID int64) ([]*domain.SenderGrant, error) {
+	query := `
+		SELECT id, user_id, address, grant_type, created_at
+		FROM sender_grants
+		WHERE user_id = ?
+		ORDER BY address
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sender grants: %w", err)
+	}
+	defer rows.Close()
+
+	grants := make([]*domain.SenderGrant, 0)
+	for rows.Next() {
+		grant := &domain.SenderGrant{}
+		err := rows.Scan(
+			&grant.ID, &grant.UserID, &grant.Address, &grant.GrantType, &grant.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan sender grant: %w", err)
+		}
+		grants = append(grants, grant)
+	}
+
+	return grants, rows.Err()
+}
+
+// FindForAddress returns the grant covering an address for a user
+// An exact address grant takes precedence over a domain-wide "@domain" grant.
+func (r *senderGrantRepository) FindForAddress(userID int64, address string) (*domain.SenderGrant, error) {
+	domainGrant := address
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		domainGrant = address[at:]
+	}
+
+	query := `
+		SELECT id, user_id, address, grant_type, created_at
+		FROM sender_grants
+		WHERE user_id = ? AND address IN (?, ?)
+		ORDER BY length(address) DESC
+		LIMIT 1
+	`
+
+	grant := &domain.SenderGrant{}
+	err := r.db.QueryRow(query, userID, address, domainGrant).Scan(
+		&grant.ID, &grant.UserID, &grant.Address, &grant.GrantType, &grant.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("sender grant not found: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find sender grant: %w", err)
+	}
+
+	return grant, nil
+}
+
+// Delete deletes a sender grant
+func (r *senderGrantRepository) Delete(id int64) error {
+	query := `DELETE FROM sender_grants WHERE id = ?`
+	_, err := r.db.Exec(query, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete sender grant: %w", err)
+	}
+	return nil
+}
@@ -23,17 +23,17 @@ func NewUserRepository(db *database.DB) repository.UserRepository {
 func (r *userRepository) Create(user *domain.User) error {
 	query := `
 		INSERT INTO users (
-			email, domain_id, password_hash, full_name, display_name, role,
-			quota, used_quota, status, auth_method, totp_secret, totp_enabled,
-			forward_to, auto_reply_enabled, auto_reply_subject, auto_reply_body,
+			email, domain_id, password_hash, scram_sha256, full_name, display_name, role,
+			quota, used_quota, message_quota, status, auth_method, totp_secret, totp_enabled,
+			forward_to, forward_keep_copy, auto_reply_enabled, auto_reply_subject, auto_reply_body, auto_reply_start, auto_reply_end,
 			spam_threshold, language, created_at, updated_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
 	result, err := r.db.Exec(query,
-		user.Email, user.DomainID, user.PasswordHash, user.FullName, user.DisplayName, user.Role,
-		user.Quota, user.UsedQuota, user.Status, user.AuthMethod, user.TOTPSecret, user.TOTPEnabled,
-		user.ForwardTo, user.AutoReplyEnabled, user.AutoReplySubject, user.AutoReplyBody,
+		user.Email, user.DomainID, user.PasswordHash, user.SCRAMSHA256, user.FullName, user.DisplayName, user.Role,
+		user.Quota, user.UsedQuota, user.MessageQuota, user.Status, user.AuthMethod, user.TOTPSecret, user.TOTPEnabled,
+		user.ForwardTo, user.ForwardKeepCopy, user.AutoReplyEnabled, user.AutoReplySubject, user.AutoReplyBody, user.AutoReplyStart, user.AutoReplyEnd,
 		user.SpamThreshold, user.Language, time.Now(), time.Now(),
 	)
 	if err != nil {
@@ -56,9 +56,9 @@ func (r *userRepository) Create(user *domain.User) error {
 func (r *userRepository) GetByID(id int64) (*domain.User, error) {
 	query := `
 		SELECT
-			id, email, domain_id, password_hash, full_name, display_name, role,
-			quota, used_quota, status, auth_method, totp_secret, totp_enabled,
-			forward_to, auto_reply_enabled, auto_reply_subject, auto_reply_body,
+			id, email, domain_id, password_hash, scram_sha256, full_name, display_name, role,
+			quota, used_quota, message_quota, status, auth_method, totp_secret, totp_enabled,
+			forward_to, forward_keep_copy, auto_reply_enabled, auto_reply_subject, auto_reply_body, auto_reply_start, auto_reply_end,
 			spam_threshold, language, last_login, created_at, updated_at
 		FROM users
 		WHERE id = ?
@@ -66,11 +66,12 @@ func (r *userRepository) GetByID(id int64) (*domain.User, error) {
 
 	user := &domain.User{}
 	var lastLogin sql.NullTime
+	var autoReplyStart, autoReplyEnd sql.NullTime
 
 	err := r.db.QueryRow(query, id).Scan(
-		&user.ID, &user.Email, &user.DomainID, &user.PasswordHash, &user.FullName, &user.DisplayName, &user.Role,
-		&user.Quota, &user.UsedQuota, &user.Status, &user.AuthMethod, &user.TOTPSecret, &user.TOTPEnabled,
-		&user.ForwardTo, &user.AutoReplyEnabled, &user.AutoReplySubject, &user.AutoReplyBody,
+		&user.ID, &user.Email, &user.DomainID, &user.PasswordHash, &user.SCRAMSHA256, &user.FullName, &user.DisplayName, &user.Role,
+		&user.Quota, &user.UsedQuota, &user.MessageQuota, &user.Status, &user.AuthMethod, &user.TOTPSecret, &user.TOTPEnabled,
+		&user.ForwardTo, &user.ForwardKeepCopy, &user.AutoReplyEnabled, &user.AutoReplySubject, &user.AutoReplyBody, &autoReplyStart, &autoReplyEnd,
 		&user.SpamThreshold, &user.Language, &lastLogin, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err == sql.ErrNoRows {
@@ -83,6 +84,12 @@ func (r *userRepository) GetByID(id int64) (*domain.User, error) {
 	if lastLogin.Valid {
 		user.LastLogin = &lastLogin.Time
 	}
+	if autoReplyStart.Valid {
+		user.AutoReplyStart = &autoReplyStart.Time
+	}
+	if autoReplyEnd.Valid {
+		user.AutoReplyEnd = &autoReplyEnd.Time
+	}
 
 	return user, nil
 }
@@ -91,9 +98,9 @@ func (r *userRepository) GetByID(id int64) (*domain.User, error) {
 func (r *userRepository) GetByEmail(email string) (*domain.User, error) {
 	query := `
 		SELECT
-			id, email, domain_id, password_hash, full_name, display_name, role,
-			quota, used_quota, status, auth_method, totp_secret, totp_enabled,
-			forward_to, auto_reply_enabled, auto_reply_subject, auto_reply_body,
+			id, email, domain_id, password_hash, scram_sha256, full_name, display_name, role,
+			quota, used_quota, message_quota, status, auth_method, totp_secret, totp_enabled,
+			forward_to, forward_keep_copy, auto_reply_enabled, auto_reply_subject, auto_reply_body, auto_reply_start, auto_reply_end,
 			spam_threshold, language, last_login, created_at, updated_at
 		FROM users
 		WHERE email = ?
@@ -101,11 +108,12 @@ func (r *userRepository) GetByEmail(email string) (*domain.User, error) {
 
 	user := &domain.User{}
 	var lastLogin sql.NullTime
+	var autoReplyStart, autoReplyEnd sql.NullTime
 
 	err := r.db.QueryRow(query, email).Scan(
-		&user.ID, &user.Email, &user.DomainID, &user.PasswordHash, &user.FullName, &user.DisplayName, &user.Role,
-		&user.Quota, &user.UsedQuota, &user.Status, &user.AuthMethod, &user.TOTPSecret, &user.TOTPEnabled,
-		&user.ForwardTo, &user.AutoReplyEnabled, &user.AutoReplySubject, &user.AutoReplyBody,
+		&user.ID, &user.Email, &user.DomainID, &user.PasswordHash, &user.SCRAMSHA256, &user.FullName, &user.DisplayName, &user.Role,
+		&user.Quota, &user.UsedQuota, &user.MessageQuota, &user.Status, &user.AuthMethod, &user.TOTPSecret, &user.TOTPEnabled,
+		&user.ForwardTo, &user.ForwardKeepCopy, &user.AutoReplyEnabled, &user.AutoReplySubject, &user.AutoReplyBody, &autoReplyStart, &autoReplyEnd,
 		&user.SpamThreshold, &user.Language, &lastLogin, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err == sql.ErrNoRows {
@@ -118,6 +126,12 @@ func (r *userRepository) GetByEmail(email string) (*domain.User, error) {
 	if lastLogin.Valid {
 		user.LastLogin = &lastLogin.Time
 	}
+	if autoReplyStart.Valid {
+		user.AutoReplyStart = &autoReplyStart.Time
+	}
+	if autoReplyEnd.Valid {
+		user.AutoReplyEnd = &autoReplyEnd.Time
+	}
 
 	return user, nil
 }
@@ -127,16 +141,16 @@ func (r *userRepository) Update(user *domain.User) error {
 	query := `
 		UPDATE users SET
 			email = ?, domain_id = ?, password_hash = ?, full_name = ?, display_name = ?, role = ?,
-			quota = ?, used_quota = ?, status = ?, auth_method = ?, totp_secret = ?, totp_enabled = ?,
-			forward_to = ?, auto_reply_enabled = ?, auto_reply_subject = ?, auto_reply_body = ?,
+			quota = ?, message_quota = ?, status = ?, auth_method = ?, totp_secret = ?, totp_enabled = ?,
+			forward_to = ?, forward_keep_copy = ?, auto_reply_enabled = ?, auto_reply_subject = ?, auto_reply_body = ?, auto_reply_start = ?, auto_reply_end = ?,
 			spam_threshold = ?, language = ?, updated_at = ?
 		WHERE id = ?
 	`
 
 	_, err := r.db.Exec(query,
 		user.Email, user.DomainID, user.PasswordHash, user.FullName, user.DisplayName, user.Role,
-		user.Quota, user.UsedQuota, user.Status, user.AuthMethod, user.TOTPSecret, user.TOTPEnabled,
-		user.ForwardTo, user.AutoReplyEnabled, user.AutoReplySubject, user.AutoReplyBody,
+		user.Quota, user.MessageQuota, user.Status, user.AuthMethod, user.TOTPSecret, user.TOTPEnabled,
+		user.ForwardTo, user.ForwardKeepCopy, user.AutoReplyEnabled, user.AutoReplySubject, user.AutoReplyBody, user.AutoReplyStart, user.AutoReplyEnd,
 		user.SpamThreshold, user.Language, time.Now(), user.ID,
 	)
 	if err != nil {
@@ -147,6 +161,34 @@ func (r *userRepository) Update(user *domain.User) error {
 	return nil
 }
 
+// ReconcileQuota recomputes every user's storage usage from their messages
+// in one statement, so messages stored meanwhile cannot be miscounted, and
+// returns the corrected usage of each user whose recorded usage was wrong
+func (r *userRepository) ReconcileQuota() (map[int64]int64, error) {
+	query := `
+		UPDATE users
+		SET used_quota = (SELECT COALESCE(SUM(size), 0) FROM messages WHERE user_id = users.id)
+		WHERE used_quota != (SELECT COALESCE(SUM(size), 0) FROM messages WHERE user_id = users.id)
+		RETURNING id, used_quota
+	`
+
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconcile used quota: %w", err)
+	}
+	defer rows.Close()
+
+	corrected := make(map[int64]int64)
+	for rows.Next() {
+		var id, usedQuota int64
+		if err := rows.Scan(&id, &usedQuota); err != nil {
+			return nil, fmt.Errorf("failed to scan reconciled quota: %w", err)
+		}
+		corrected[id] = usedQuota
+	}
+	return corrected, rows.Err()
+}
+
 // UpdateLastLogin updates the last login timestamp
 func (r *userRepository) UpdateLastLogin(id int64) error {
 	query := `UPDATE users SET last_login = ? WHERE id = ?`
@@ -167,6 +209,16 @@ func (r *userRepository) UpdatePassword(userID int64, passwordHash string) error
 	return nil
 }
 
+// UpdateSCRAMCredentials updates a user's stored SCRAM-SHA-256 credentials
+func (r *userRepository) UpdateSCRAMCredentials(userID int64, credentials string) error {
+	query := `UPDATE users SET scram_sha256 = ? WHERE id = ?`
+	_, err := r.db.Exec(query, credentials, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update SCRAM credentials: %w", err)
+	}
+	return nil
+}
+
 // Delete deletes a user
 func (r *userRepository) Delete(id int64) error {
 	query := `DELETE FROM users WHERE id = ?`
@@ -181,9 +233,9 @@ func (r *userRepository) Delete(id int64) error {
 func (r *userRepository) List(domainID int64, offset, limit int) ([]*domain.User, error) {
 	query := `
 		SELECT
-			id, email, domain_id, password_hash, full_name, display_name, role,
-			quota, used_quota, status, auth_method, totp_secret, totp_enabled,
-			forward_to, auto_reply_enabled, auto_reply_subject, auto_reply_body,
+			id, email, domain_id, password_hash, scram_sha256, full_name, display_name, role,
+			quota, used_quota, message_quota, status, auth_method, totp_secret, totp_enabled,
+			forward_to, forward_keep_copy, auto_reply_enabled, auto_reply_subject, auto_reply_body, auto_reply_start, auto_reply_end,
 			spam_threshold, language, last_login, created_at, updated_at
 		FROM users
 		WHERE domain_id = ?
@@ -201,11 +253,12 @@ func (r *userRepository) List(domainID int64, offset, limit int) ([]*domain.User
 	for rows.Next() {
 		user := &domain.User{}
 		var lastLogin sql.NullTime
+		var autoReplyStart, autoReplyEnd sql.NullTime
 
 		err := rows.Scan(
-			&user.ID, &user.Email, &user.DomainID, &user.PasswordHash, &user.FullName, &user.DisplayName, &user.Role,
-			&user.Quota, &user.UsedQuota, &user.Status, &user.AuthMethod, &user.TOTPSecret, &user.TOTPEnabled,
-			&user.ForwardTo, &user.AutoReplyEnabled, &user.AutoReplySubject, &user.AutoReplyBody,
+			&user.ID, &user.Email, &user.DomainID, &user.PasswordHash, &user.SCRAMSHA256, &user.FullName, &user.DisplayName, &user.Role,
+			&user.Quota, &user.UsedQuota, &user.MessageQuota, &user.Status, &user.AuthMethod, &user.TOTPSecret, &user.TOTPEnabled,
+			&user.ForwardTo, &user.ForwardKeepCopy, &user.AutoReplyEnabled, &user.AutoReplySubject, &user.AutoReplyBody, &autoReplyStart, &autoReplyEnd,
 			&user.SpamThreshold, &user.Language, &lastLogin, &user.CreatedAt, &user.UpdatedAt,
 		)
 		if err != nil {
@@ -215,6 +268,12 @@ func (r *userRepository) List(domainID int64, offset, limit int) ([]*domain.User
 		if lastLogin.Valid {
 			user.LastLogin = &lastLogin.Time
 		}
+		if autoReplyStart.Valid {
+			user.AutoReplyStart = &autoReplyStart.Time
+		}
+		if autoReplyEnd.Valid {
+			user.AutoReplyEnd = &autoReplyEnd.Time
+		}
 
 		users = append(users, user)
 	}
@@ -226,9 +285,9 @@ func (r *userRepository) List(domainID int64, offset, limit int) ([]*domain.User
 func (r *userRepository) ListAll() ([]*domain.User, error) {
 	query := `
 		SELECT
-			id, email, domain_id, password_hash, full_name, display_name, role,
-			quota, used_quota, status, auth_method, totp_secret, totp_enabled,
-			forward_to, auto_reply_enabled, auto_reply_subject, auto_reply_body,
+			id, email, domain_id, password_hash, scram_sha256, full_name, display_name, role,
+			quota, used_quota, message_quota, status, auth_method, totp_secret, totp_enabled,
+			forward_to, forward_keep_copy, auto_reply_enabled, auto_reply_subject, auto_reply_body, auto_reply_start, auto_reply_end,
 			spam_threshold, language, last_login, created_at, updated_at
 		FROM users
 		ORDER BY created_at DESC
@@ -244,11 +303,12 @@ func (r *userRepository) ListAll() ([]*domain.User, error) {
 	for rows.Next() {
 		user := &domain.User{}
 		var lastLogin sql.NullTime
+		var autoReplyStart, autoReplyEnd sql.NullTime
 
 		err := rows.Scan(
-			&user.ID, &user.Email, &user.DomainID, &user.PasswordHash, &user.FullName, &user.DisplayName, &user.Role,
-			&user.Quota, &user.UsedQuota, &user.Status, &user.AuthMethod, &user.TOTPSecret, &user.TOTPEnabled,
-			&user.ForwardTo, &user.AutoReplyEnabled, &user.AutoReplySubject, &user.AutoReplyBody,
+			&user.ID, &user.Email, &user.DomainID, &user.PasswordHash, &user.SCRAMSHA256, &user.FullName, &user.DisplayName, &user.Role,
+			&user.Quota, &user.UsedQuota, &user.MessageQuota, &user.Status, &user.AuthMethod, &user.TOTPSecret, &user.TOTPEnabled,
+			&user.ForwardTo, &user.ForwardKeepCopy, &user.AutoReplyEnabled, &user.AutoReplySubject, &user.AutoReplyBody, &autoReplyStart, &autoReplyEnd,
 			&user.SpamThreshold, &user.Language, &lastLogin, &user.CreatedAt, &user.UpdatedAt,
 		)
 		if err != nil {
@@ -258,6 +318,12 @@ func (r *userRepository) ListAll() ([]*domain.User, error) {
 		if lastLogin.Valid {
 			user.LastLogin = &lastLogin.Time
 		}
+		if autoReplyStart.Valid {
+			user.AutoReplyStart = &autoReplyStart.Time
+		}
+		if autoReplyEnd.Valid {
+			user.AutoReplyEnd = &autoReplyEnd.Time
+		}
 
 		users = append(users, user)
 	}
@@ -146,6 +146,7 @@ type ProviderRateLimit struct {
 	HourResetAt          int64
 	DayResetAt           int64
 	CircuitBreakerActive bool
+	CircuitBreakerAt     int64 // when the delivery worker tripped the breaker, 0 if set manually
 	LastUpdated          int64
 }
 
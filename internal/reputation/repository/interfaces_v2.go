@@ -123,6 +123,12 @@ type ProviderRateLimitsRepository interface {
 	// IncrementDaily increments daily counter
 	IncrementDaily(ctx context.Context, domain string, provider domain.MailProvider, count int) error
 
+	// Reserve atomically adds count to both counters if the caps and circuit breaker allow it
+	Reserve(ctx context.Context, domain string, provider domain.MailProvider, count int, trippedBefore int64) (bool, error)
+
+	// Release returns unused reserved sends to both counters
+	Release(ctx context.Context, domain string, provider domain.MailProvider, count int) error
+
 	// ResetHourly resets hourly counter
 	ResetHourly(ctx context.Context, domain string, provider domain.MailProvider, newResetTime int64) error
 
@@ -25,7 +25,7 @@ func (r *providerRateLimitsRepository) Get(ctx context.Context, domainName strin
 		SELECT
 			id, domain, provider, max_hourly_rate, max_daily_rate,
 			current_hour_count, current_day_count, hour_reset_at, day_reset_at,
-			circuit_breaker_active, last_updated
+			circuit_breaker_active, circuit_breaker_at, last_updated
 		FROM provider_rate_limits
 		WHERE domain = ? AND provider = ?
 	`
@@ -42,6 +42,7 @@ func (r *providerRateLimitsRepository) Get(ctx context.Context, domainName strin
 		&limit.HourResetAt,
 		&limit.DayResetAt,
 		&limit.CircuitBreakerActive,
+		&limit.CircuitBreakerAt,
 		&limit.LastUpdated,
 	)
 	if err == sql.ErrNoRows {
@@ -134,6 +135,56 @@ func (r *providerRateLimitsRepository) IncrementDaily(ctx context.Context, domai
 	return nil
 }
 
+// Reserve adds count to the hourly and daily counters in one statement, only
+// while both stay within their caps and the circuit breaker is closed or was
+// tripped before trippedBefore, which it then clears
+func (r *providerRateLimitsRepository) Reserve(ctx context.Context, domainName string, provider domain.MailProvider, count int, trippedBefore int64) (bool, error) {
+	query := `
+		UPDATE provider_rate_limits
+		SET current_hour_count = current_hour_count + ?,
+		    current_day_count = current_day_count + ?,
+		    circuit_breaker_active = 0,
+		    circuit_breaker_at = 0,
+		    last_updated = ?
+		WHERE domain = ? AND provider = ?
+		  AND (circuit_breaker_active = 0 OR (circuit_breaker_at > 0 AND circuit_breaker_at <= ?))
+		  AND (max_hourly_rate <= 0 OR current_hour_count = 0 OR current_hour_count + ? <= max_hourly_rate)
+		  AND (COALESCE(max_daily_rate, 0) <= 0 OR current_day_count = 0 OR current_day_count + ? <= max_daily_rate)
+	`
+
+	result, err := r.db.ExecContext(ctx, query,
+		count, count, time.Now().Unix(), domainName, string(provider),
+		trippedBefore, count, count,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to reserve provider sends: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to reserve provider sends: %w", err)
+	}
+	return n > 0, nil
+}
+
+// Release returns count unused sends to the hourly and daily counters
+func (r *providerRateLimitsRepository) Release(ctx context.Context, domainName string, provider domain.MailProvider, count int) error {
+	query := `
+		UPDATE provider_rate_limits
+		SET current_hour_count = MAX(current_hour_count - ?, 0),
+		    current_day_count = MAX(current_day_count - ?, 0),
+		    last_updated = ?
+		WHERE domain = ? AND provider = ?
+	`
+
+	_, err := r.db.ExecContext(ctx, query, count, count, time.Now().Unix(), domainName, string(provider))
+	if err != nil {
+		return fmt.Errorf("failed to release provider sends: %w", err)
+	}
+
+	return nil
+}
+
 // ResetHourly resets hourly counter
 func (r *providerRateLimitsRepository) ResetHourly(ctx context.Context, domainName string, provider domain.MailProvider, newResetTime int64) error {
 	query := `
@@ -222,11 +273,17 @@ func (r *providerRateLimitsRepository) SetCircuitBreaker(ctx context.Context, do
 	query := `
 		UPDATE provider_rate_limits
 		SET circuit_breaker_active = ?,
+		    circuit_breaker_at = ?,
 		    last_updated = ?
 		WHERE domain = ? AND provider = ?
 	`
 
-	_, err := r.db.ExecContext(ctx, query, active, time.Now().Unix(), domainName, string(provider))
+	now := time.Now().Unix()
+	var trippedAt int64
+	if active {
+		trippedAt = now
+	}
+	_, err := r.db.ExecContext(ctx, query, active, trippedAt, now, domainName, string(provider))
 	if err != nil {
 		return fmt.Errorf("failed to set circuit breaker: %w", err)
 	}
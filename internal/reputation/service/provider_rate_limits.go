@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/btafoya/gomailserver/internal/reputation/domain"
@@ -10,6 +11,26 @@ import (
 	"go.uber.org/zap"
 )
 
+// providerMXSuffixes maps MX hostname suffixes to the provider operating them
+var providerMXSuffixes = map[domain.MailProvider][]string{
+	domain.ProviderGmail:   {"google.com", "googlemail.com"},
+	domain.ProviderOutlook: {"outlook.com", "hotmail.com", "olc.protection.outlook.com"},
+	domain.ProviderYahoo:   {"yahoodns.net", "yahoo.com", "aol.com"},
+}
+
+// ProviderForMX maps a recipient MX hostname to the mail provider operating it
+func ProviderForMX(host string) domain.MailProvider {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for provider, suffixes := range providerMXSuffixes {
+		for _, suffix := range suffixes {
+			if host == suffix || strings.HasSuffix(host, "."+suffix) {
+				return provider
+			}
+		}
+	}
+	return domain.ProviderGeneric
+}
+
 // ProviderRateLimitsService manages provider-specific rate limiting
 
 type ProviderRateLimitsService struct {
@@ -42,12 +63,18 @@ func (s *ProviderRateLimitsService) GetLimit(ctx context.Context, domainName str
 	if limit.ShouldResetHour(now) {
 		if err := s.limitsRepo.ResetHourly(ctx, domainName, provider, now.Add(1*time.Hour).Unix()); err != nil {
 			s.logger.Error("Failed to reset hourly counter", zap.Error(err))
+		} else {
+			limit.CurrentHourCount = 0
+			limit.HourResetAt = now.Add(1 * time.Hour).Unix()
 		}
 	}
 
 	if limit.ShouldResetDay(now) {
 		if err := s.limitsRepo.ResetDaily(ctx, domainName, provider, now.Add(24*time.Hour).Unix()); err != nil {
 			s.logger.Error("Failed to reset daily counter", zap.Error(err))
+		} else {
+			limit.CurrentDayCount = 0
+			limit.DayResetAt = now.Add(24 * time.Hour).Unix()
 		}
 	}
 
@@ -79,6 +106,91 @@ func (s *ProviderRateLimitsService) CheckLimit(ctx context.Context, domainName s
 	return true, nil
 }
 
+// ProviderCircuitBreakerCooldown is how long a circuit breaker tripped by the
+// delivery worker pauses a provider before sending is tried again
+const ProviderCircuitBreakerCooldown = 1 * time.Hour
+
+// NextAllowed returns when sending to a provider may resume for a domain
+// The zero time means sending is currently allowed. Domains without configured
+// limits are initialized with the defaults on first use.
+func (s *ProviderRateLimitsService) NextAllowed(ctx context.Context, domainName string, provider domain.MailProvider) (time.Time, error) {
+	limit, err := s.limitOrDefault(ctx, domainName, provider)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return resumeAt(limit, time.Now(), 1), nil
+}
+
+// Reserve claims count sends to a provider for a domain in a single update,
+// so concurrent deliveries cannot overshoot the caps. It returns the zero time
+// once the sends are reserved, or when sending may resume. A breaker tripped
+// by the delivery worker closes on the first send after the cool-down.
+func (s *ProviderRateLimitsService) Reserve(ctx context.Context, domainName string, provider domain.MailProvider, count int) (time.Time, error) {
+	// Applies any due hourly and daily resets before reserving
+	if _, err := s.limitOrDefault(ctx, domainName, provider); err != nil {
+		return time.Time{}, err
+	}
+
+	now := time.Now()
+	ok, err := s.limitsRepo.Reserve(ctx, domainName, provider, count, now.Add(-ProviderCircuitBreakerCooldown).Unix())
+	if err != nil {
+		return time.Time{}, err
+	}
+	if ok {
+		return time.Time{}, nil
+	}
+
+	limit, err := s.limitsRepo.Get(ctx, domainName, provider)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if resume := resumeAt(limit, now, count); !resume.IsZero() {
+		return resume, nil
+	}
+	// Another delivery changed the counters in between; try again shortly
+	return now.Add(1 * time.Minute), nil
+}
+
+// Release returns sends reserved but not made, such as rejected recipients
+func (s *ProviderRateLimitsService) Release(ctx context.Context, domainName string, provider domain.MailProvider, count int) error {
+	return s.limitsRepo.Release(ctx, domainName, provider, count)
+}
+
+// limitOrDefault returns a domain's limit for a provider, initializing the
+// domain's default limits on first use
+func (s *ProviderRateLimitsService) limitOrDefault(ctx context.Context, domainName string, provider domain.MailProvider) (*domain.ProviderRateLimit, error) {
+	limit, err := s.GetLimit(ctx, domainName, provider)
+	if err == nil {
+		return limit, nil
+	}
+	if initErr := s.InitializeDefaultLimits(ctx, domainName); initErr != nil {
+		return nil, initErr
+	}
+	return s.GetLimit(ctx, domainName, provider)
+}
+
+// resumeAt returns when count more sends fit a limit, or the zero time if they fit now
+func resumeAt(limit *domain.ProviderRateLimit, now time.Time, count int) time.Time {
+	switch {
+	case limit.CircuitBreakerActive && limit.CircuitBreakerAt == 0:
+		// Set manually; re-evaluated hourly until cleared by SetCircuitBreaker
+		return now.Add(1 * time.Hour)
+	case limit.CircuitBreakerActive && now.Before(time.Unix(limit.CircuitBreakerAt, 0).Add(ProviderCircuitBreakerCooldown)):
+		return time.Unix(limit.CircuitBreakerAt, 0).Add(ProviderCircuitBreakerCooldown)
+	case !withinCap(limit.CurrentDayCount, limit.MaxDailyRate, count):
+		return time.Unix(limit.DayResetAt, 0)
+	case !withinCap(limit.CurrentHourCount, limit.MaxHourlyRate, count):
+		return time.Unix(limit.HourResetAt, 0)
+	}
+	return time.Time{}
+}
+
+// withinCap reports whether count more sends fit under max; a batch larger
+// than the whole cap may still start an empty window
+func withinCap(current, max, count int) bool {
+	return max <= 0 || current == 0 || current+count <= max
+}
+
 // IncrementCount increments message count for a provider
 func (s *ProviderRateLimitsService) IncrementCount(ctx context.Context, domainName string, provider domain.MailProvider, count int) error {
 	// Increment hourly
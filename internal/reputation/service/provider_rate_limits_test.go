@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/btafoya/gomailserver/internal/reputation/domain"
+	"github.com/btafoya/gomailserver/internal/reputation/repository/sqlite"
+	_ "github.com/mattn/go-sqlite3"
+	"go.uber.org/zap"
+)
+
+func setupProviderLimitsTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+
+	schema := `
+	CREATE TABLE provider_rate_limits (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		domain TEXT NOT NULL,
+		provider TEXT NOT NULL,
+		max_hourly_rate INTEGER NOT NULL,
+		max_daily_rate INTEGER,
+		current_hour_count INTEGER DEFAULT 0,
+		current_day_count INTEGER DEFAULT 0,
+		hour_reset_at INTEGER NOT NULL,
+		day_reset_at INTEGER NOT NULL,
+		circuit_breaker_active BOOLEAN DEFAULT 0,
+		circuit_breaker_at INTEGER DEFAULT 0,
+		last_updated INTEGER NOT NULL,
+		UNIQUE(domain, provider)
+	);
+	`
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+
+	return db
+}
+
+func TestProviderForMX(t *testing.T) {
+	tests := []struct {
+		host string
+		want domain.MailProvider
+	}{
+		{"gmail-smtp-in.l.google.com.", domain.ProviderGmail},
+		{"ASPMX.L.GOOGLE.COM", domain.ProviderGmail},
+		{"example-com.mail.protection.outlook.com", domain.ProviderOutlook},
+		{"mta5.am0.yahoodns.net", domain.ProviderYahoo},
+		{"mx.example.com", domain.ProviderGeneric},
+		{"notgoogle.com", domain.ProviderGeneric},
+	}
+
+	for _, tt := range tests {
+		if got := ProviderForMX(tt.host); got != tt.want {
+			t.Errorf("ProviderForMX(%q) = %s, want %s", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestProviderRateLimitsService_NextAllowed(t *testing.T) {
+	db := setupProviderLimitsTestDB(t)
+	defer db.Close()
+
+	svc := NewProviderRateLimitsService(sqlite.NewProviderRateLimitsRepository(db), sqlite.NewAlertsRepository(db), zap.NewNop())
+	ctx := context.Background()
+
+	// Unknown domain gets default limits and is allowed
+	resumeAt, err := svc.NextAllowed(ctx, "example.com", domain.ProviderGmail)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !resumeAt.IsZero() {
+		t.Errorf("expected sending allowed, got resume at %v", resumeAt)
+	}
+
+	// Hitting the hourly cap defers until the hour resets
+	if err := svc.IncrementCount(ctx, "example.com", domain.ProviderGmail, 500); err != nil {
+		t.Fatalf("failed to increment: %v", err)
+	}
+	resumeAt, err = svc.NextAllowed(ctx, "example.com", domain.ProviderGmail)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if resumeAt.Before(time.Now()) {
+		t.Errorf("expected resume time in the future, got %v", resumeAt)
+	}
+
+	// Other providers are unaffected
+	resumeAt, _ = svc.NextAllowed(ctx, "example.com", domain.ProviderYahoo)
+	if !resumeAt.IsZero() {
+		t.Errorf("expected yahoo unaffected, got resume at %v", resumeAt)
+	}
+
+	// A provider circuit breaker pauses only that provider
+	if err := svc.SetCircuitBreaker(ctx, "example.com", domain.ProviderOutlook, true); err != nil {
+		t.Fatalf("failed to set circuit breaker: %v", err)
+	}
+	resumeAt, _ = svc.NextAllowed(ctx, "example.com", domain.ProviderOutlook)
+	if resumeAt.IsZero() {
+		t.Error("expected outlook paused by circuit breaker")
+	}
+	resumeAt, _ = svc.NextAllowed(ctx, "example.com", domain.ProviderGeneric)
+	if !resumeAt.IsZero() {
+		t.Errorf("expected generic unaffected, got resume at %v", resumeAt)
+	}
+}
+
+func TestProviderRateLimitsService_Reserve(t *testing.T) {
+	db := setupProviderLimitsTestDB(t)
+	defer db.Close()
+
+	svc := NewProviderRateLimitsService(sqlite.NewProviderRateLimitsRepository(db), sqlite.NewAlertsRepository(db), zap.NewNop())
+	ctx := context.Background()
+
+	// Yahoo allows 200 an hour by default
+	resumeAt, err := svc.Reserve(ctx, "example.com", domain.ProviderYahoo, 150)
+	if err != nil || !resumeAt.IsZero() {
+		t.Fatalf("expected first batch reserved, got %v, %v", resumeAt, err)
+	}
+
+	// A batch that would cross the cap is deferred and reserves nothing
+	resumeAt, err = svc.Reserve(ctx, "example.com", domain.ProviderYahoo, 60)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !resumeAt.After(time.Now()) {
+		t.Errorf("expected resume time in the future, got %v", resumeAt)
+	}
+	limit, _ := svc.GetLimit(ctx, "example.com", domain.ProviderYahoo)
+	if limit.CurrentHourCount != 150 || limit.CurrentDayCount != 150 {
+		t.Errorf("expected 150 sends counted, got %d hourly, %d daily", limit.CurrentHourCount, limit.CurrentDayCount)
+	}
+
+	// Released sends make room again
+	if err := svc.Release(ctx, "example.com", domain.ProviderYahoo, 20); err != nil {
+		t.Fatalf("failed to release: %v", err)
+	}
+	if resumeAt, _ = svc.Reserve(ctx, "example.com", domain.ProviderYahoo, 60); !resumeAt.IsZero() {
+		t.Errorf("expected batch reserved after release, got resume at %v", resumeAt)
+	}
+
+	// A tripped breaker pauses the provider for the cool-down
+	if err := svc.SetCircuitBreaker(ctx, "example.com", domain.ProviderGmail, true); err != nil {
+		t.Fatalf("failed to set circuit breaker: %v", err)
+	}
+	resumeAt, _ = svc.Reserve(ctx, "example.com", domain.ProviderGmail, 1)
+	if resumeAt.Before(time.Now().Add(ProviderCircuitBreakerCooldown - time.Minute)) {
+		t.Errorf("expected gmail paused for the cool-down, got resume at %v", resumeAt)
+	}
+
+	// After the cool-down the next send goes out and closes the breaker
+	if _, err := db.Exec(`UPDATE provider_rate_limits SET circuit_breaker_at = ? WHERE provider = ?`,
+		time.Now().Add(-ProviderCircuitBreakerCooldown).Unix(), string(domain.ProviderGmail)); err != nil {
+		t.Fatalf("failed to age circuit breaker: %v", err)
+	}
+	if resumeAt, _ = svc.Reserve(ctx, "example.com", domain.ProviderGmail, 1); !resumeAt.IsZero() {
+		t.Errorf("expected send allowed after the cool-down, got resume at %v", resumeAt)
+	}
+	if limit, _ = svc.GetLimit(ctx, "example.com", domain.ProviderGmail); limit.CircuitBreakerActive {
+		t.Error("expected circuit breaker closed")
+	}
+}
@@ -0,0 +1,164 @@
+package antispam
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Rspamd actions returned by /checkv2
+const (
+	ActionNoAction       = "no action"
+	ActionGreylist       = "greylist"
+	ActionAddHeader      = "add header"
+	ActionRewriteSubject = "rewrite subject"
+	ActionSoftReject     = "soft reject"
+	ActionReject         = "reject"
+)
+
+// Metadata describes the SMTP transaction a message arrived in
+type Metadata struct {
+	IP         string
+	Helo       string
+	From       string
+	Recipients []string
+	User       string // authenticated user, if any
+}
+
+// Rspamd talks to rspamd over its HTTP protocol
+type Rspamd struct {
+	url           string
+	controllerURL string
+	password      string
+	client        *http.Client
+}
+
+// NewRspamd creates an rspamd client for the normal worker at url
+// Learning goes to the controller worker at controllerURL, authenticated with password.
+func NewRspamd(url, controllerURL, password string, timeout time.Duration) *Rspamd {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	return &Rspamd{
+		url:           strings.TrimRight(url, "/"),
+		controllerURL: strings.TrimRight(controllerURL, "/"),
+		password:      password,
+		client:        &http.Client{Timeout: timeout},
+	}
+}
+
+// rspamdSymbol is one symbol in a /checkv2 reply
+type rspamdSymbol struct {
+	Name        string   `json:"name"`
+	Score       float64  `json:"score"`
+	Description string   `json:"description"`
+	Options     []string `json:"options"`
+}
+
+// rspamdReply is the /checkv2 reply body
+type rspamdReply struct {
+	Score         float64                 `json:"score"`
+	RequiredScore float64                 `json:"required_score"`
+	Action        string                  `json:"action"`
+	Subject       string                  `json:"subject"`
+	Symbols       map[string]rspamdSymbol `json:"symbols"`
+	Error         string                  `json:"error"`
+}
+
+// Check streams a message to rspamd's /checkv2 endpoint and returns its verdict
+func (r *Rspamd) Check(message io.Reader, meta *Metadata) (*SpamResult, error) {
+	req, err := http.NewRequest(http.MethodPost, r.url+"/checkv2", message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rspamd request: %w", err)
+	}
+	if meta != nil {
+		setHeader(req, "IP", meta.IP)
+		setHeader(req, "Helo", meta.Helo)
+		setHeader(req, "From", meta.From)
+		setHeader(req, "User", meta.User)
+		for _, rcpt := range meta.Recipients {
+			req.Header.Add("Rcpt", rcpt)
+		}
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach rspamd: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var reply rspamdReply
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return nil, fmt.Errorf("failed to decode rspamd reply: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rspamd returned %s: %s", resp.Status, reply.Error)
+	}
+
+	result := &SpamResult{
+		Score:     reply.Score,
+		Threshold: reply.RequiredScore,
+		Action:    reply.Action,
+		Subject:   reply.Subject,
+		IsSpam:    reply.Action == ActionReject || reply.Action == ActionAddHeader || reply.Action == ActionRewriteSubject,
+	}
+	for name, sym := range reply.Symbols {
+		if sym.Name == "" {
+			sym.Name = name
+		}
+		description := sym.Description
+		if len(sym.Options) > 0 {
+			description = strings.TrimSpace(description + " [" + strings.Join(sym.Options, ", ") + "]")
+		}
+		result.Rules = append(result.Rules, SpamRule{Name: sym.Name, Score: sym.Score, Description: description})
+	}
+	// Highest scoring symbols first, for display
+	sort.Slice(result.Rules, func(i, j int) bool {
+		if result.Rules[i].Score != result.Rules[j].Score {
+			return result.Rules[i].Score > result.Rules[j].Score
+		}
+		return result.Rules[i].Name < result.Rules[j].Name
+	})
+	return result, nil
+}
+
+// Learn trains rspamd's Bayes classifier through the controller
+func (r *Rspamd) Learn(message []byte, isSpam bool) error {
+	endpoint := "/learnham"
+	if isSpam {
+		endpoint = "/learnspam"
+	}
+
+	req, err := http.NewRequest(http.MethodPost, r.controllerURL+endpoint, bytes.NewReader(message))
+	if err != nil {
+		return fmt.Errorf("failed to create rspamd request: %w", err)
+	}
+	setHeader(req, "Password", r.password)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach rspamd controller: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 208 means the message was already learned
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusAlreadyReported {
+		return nil
+	}
+	var reply struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&reply)
+	return fmt.Errorf("rspamd learn failed with %s: %s", resp.Status, reply.Error)
+}
+
+func setHeader(req *http.Request, name, value string) {
+	if value != "" {
+		req.Header.Set(name, value)
+	}
+}
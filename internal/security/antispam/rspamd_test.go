@@ -0,0 +1,115 @@
+package antispam
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRspamdCheck(t *testing.T) {
+	var got *http.Request
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		io.WriteString(w, `{
+			"score": 7.5,
+			"required_score": 15,
+			"action": "rewrite subject",
+			"subject": "[SPAM] Hello",
+			"symbols": {
+				"BAYES_SPAM": {"name": "BAYES_SPAM", "score": 5.1, "description": "Message probably spam", "options": ["97.2%"]},
+				"R_SPF_FAIL": {"name": "R_SPF_FAIL", "score": 2.4}
+			}
+		}`)
+	}))
+	defer srv.Close()
+
+	r := NewRspamd(srv.URL+"/", "", "", time.Second)
+	result, err := r.Check(strings.NewReader("Subject: Hello\r\n\r\nBody\r\n"), &Metadata{
+		IP:         "192.0.2.1",
+		Helo:       "client.example.net",
+		From:       "sender@example.net",
+		Recipients: []string{"a@example.com", "b@example.com"},
+		User:       "user@example.com",
+	})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+
+	if got.URL.Path != "/checkv2" || got.Method != http.MethodPost {
+		t.Errorf("unexpected request %s %s", got.Method, got.URL.Path)
+	}
+	if got.Header.Get("IP") != "192.0.2.1" || got.Header.Get("Helo") != "client.example.net" ||
+		got.Header.Get("From") != "sender@example.net" || got.Header.Get("User") != "user@example.com" {
+		t.Errorf("missing transaction metadata: %v", got.Header)
+	}
+	if rcpt := got.Header.Values("Rcpt"); len(rcpt) != 2 || rcpt[1] != "b@example.com" {
+		t.Errorf("expected one Rcpt header per recipient, got %v", rcpt)
+	}
+	if got.Header.Get("Hostname") != "" {
+		t.Error("expected unset metadata to be omitted")
+	}
+	if body != "Subject: Hello\r\n\r\nBody\r\n" {
+		t.Errorf("unexpected message body %q", body)
+	}
+
+	if result.Score != 7.5 || result.Threshold != 15 || result.Action != ActionRewriteSubject ||
+		result.Subject != "[SPAM] Hello" || !result.IsSpam {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if len(result.Rules) != 2 || result.Rules[0].Name != "BAYES_SPAM" || result.Rules[0].Description != "Message probably spam [97.2%]" {
+		t.Errorf("expected symbols ordered by score, got %+v", result.Rules)
+	}
+}
+
+func TestRspamdCheckError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, `{"error": "scan failed"}`)
+	}))
+	defer srv.Close()
+
+	_, err := NewRspamd(srv.URL, "", "", time.Second).Check(strings.NewReader("x"), nil)
+	if err == nil || !strings.Contains(err.Error(), "scan failed") {
+		t.Errorf("expected rspamd error, got %v", err)
+	}
+}
+
+func TestRspamdLearn(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch {
+		case r.Header.Get("Password") != "secret":
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `{"error": "Unauthorized"}`)
+		case r.URL.Path == "/learnham":
+			// Already learned
+			w.WriteHeader(http.StatusAlreadyReported)
+			io.WriteString(w, `{"error": "has been already learned"}`)
+		default:
+			io.WriteString(w, `{"success": true}`)
+		}
+	}))
+	defer srv.Close()
+
+	r := NewRspamd("", srv.URL, "secret", time.Second)
+	if err := r.Learn([]byte("spam"), true); err != nil {
+		t.Errorf("learnspam failed: %v", err)
+	}
+	if err := r.Learn([]byte("ham"), false); err != nil {
+		t.Errorf("expected already learned message to succeed, got %v", err)
+	}
+	if len(paths) != 2 || paths[0] != "/learnspam" || paths[1] != "/learnham" {
+		t.Errorf("unexpected learn endpoints %v", paths)
+	}
+
+	if err := NewRspamd("", srv.URL, "wrong", time.Second).Learn([]byte("spam"), true); err == nil {
+		t.Error("expected rejected password to fail")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"net"
 	"time"
 
@@ -21,8 +22,9 @@ func NewSpamAssassin(host string, port int) *SpamAssassin {
 	}
 }
 
-func (s *SpamAssassin) Check(message []byte) (*SpamResult, error) {
-	reply, err := s.client.Check(context.Background(), bytes.NewReader(message), nil)
+// Check streams a message to spamd and returns its score
+func (s *SpamAssassin) Check(message io.Reader) (*SpamResult, error) {
+	reply, err := s.client.Check(context.Background(), message, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -53,6 +55,8 @@ type SpamResult struct {
 	Threshold float64
 	IsSpam    bool
 	Rules     []SpamRule
+	Action    string // rspamd action; empty for SpamAssassin
+	Subject   string // rewritten subject for the rewrite subject action
 }
 
 type SpamRule struct {
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
 	"strings"
 )
@@ -16,7 +17,8 @@ func NewClamAV(socketPath string) *ClamAV {
 	return &ClamAV{socketPath: socketPath}
 }
 
-func (c *ClamAV) Scan(data []byte) (*ScanResult, error) {
+// Scan streams a message to clamd using INSTREAM
+func (c *ClamAV) Scan(r io.Reader) (*ScanResult, error) {
 	conn, err := net.Dial("unix", c.socketPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to clamd: %w", err)
@@ -30,20 +32,23 @@ func (c *ClamAV) Scan(data []byte) (*ScanResult, error) {
 	}
 
 	// Send data in chunks
-	chunkSize := 2048
-	for i := 0; i < len(data); i += chunkSize {
-		end := i + chunkSize
-		if end > len(data) {
-			end = len(data)
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			// Write chunk size (4 bytes, network byte order)
+			if err := binary.Write(conn, binary.BigEndian, uint32(n)); err != nil {
+				return nil, fmt.Errorf("failed to write chunk size: %w", err)
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return nil, fmt.Errorf("failed to write chunk data: %w", err)
+			}
 		}
-		chunk := data[i:end]
-
-		// Write chunk size (4 bytes, network byte order)
-		if err := binary.Write(conn, binary.BigEndian, uint32(len(chunk))); err != nil {
-			return nil, fmt.Errorf("failed to write chunk size: %w", err)
+		if readErr == io.EOF {
+			break
 		}
-		if _, err := conn.Write(chunk); err != nil {
-			return nil, fmt.Errorf("failed to write chunk data: %w", err)
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read message: %w", readErr)
 		}
 	}
 
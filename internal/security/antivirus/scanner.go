@@ -1,6 +1,8 @@
 package antivirus
 
 import (
+	"io"
+
 	"github.com/btafoya/gomailserver/internal/domain"
 	"go.uber.org/zap"
 )
@@ -38,7 +40,7 @@ func NewScanner(clamav *ClamAV, logger *zap.Logger) *Scanner {
 	}
 }
 
-func (s *Scanner) ScanMessage(domainName string, message []byte) (*ScanResult, ScanAction, error) {
+func (s *Scanner) ScanMessage(domainName string, message io.Reader) (*ScanResult, ScanAction, error) {
 	result, err := s.clamav.Scan(message)
 	if err != nil {
 		s.logger.Error("ClamAV scan failed", zap.Error(err))
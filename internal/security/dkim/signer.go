@@ -1,11 +1,11 @@
 package dkim
 
 import (
-	"bytes"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"io"
 
 	"github.com/emersion/go-msgauth/dkim"
 
@@ -39,16 +39,19 @@ func NewSigner() *Signer {
 	return &Signer{domainService: &placeholderDomainService{}}
 }
 
-func (s *Signer) Sign(domainName string, message []byte) ([]byte, error) {
+// Sign computes a DKIM signature over a message read from r
+// Returns the DKIM-Signature header field (with trailing CRLF) to prepend to the message,
+// or an empty string when the domain has no DKIM configuration.
+func (s *Signer) Sign(domainName string, r io.Reader) (string, error) {
 	domainCfg, err := s.domainService.GetDKIMConfig(domainName)
 	if err != nil {
-		return message, nil // Return unsigned if no DKIM config
+		return "", nil // Leave unsigned if no DKIM config
 	}
 
 	// Parse the private key from PEM format
 	privateKey, err := parsePrivateKey(domainCfg.PrivateKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse private key: %w", err)
+		return "", fmt.Errorf("failed to parse private key: %w", err)
 	}
 
 	options := &dkim.SignOptions{
@@ -61,13 +64,19 @@ func (s *Signer) Sign(domainName string, message []byte) ([]byte, error) {
 		},
 	}
 
-	r := bytes.NewReader(message)
-	var buf bytes.Buffer
-	if err := dkim.Sign(&buf, r, options); err != nil {
-		return nil, err
+	signer, err := dkim.NewSigner(options)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(signer, r); err != nil {
+		signer.Close()
+		return "", err
+	}
+	if err := signer.Close(); err != nil {
+		return "", err
 	}
 
-	return buf.Bytes(), nil
+	return signer.Signature(), nil
 }
 
 func parsePrivateKey(keyBytes []byte) (*rsa.PrivateKey, error) {
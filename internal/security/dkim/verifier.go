@@ -1,7 +1,7 @@
 package dkim
 
 import (
-	"bytes"
+	"io"
 
 	"github.com/emersion/go-msgauth/dkim"
 )
@@ -20,8 +20,8 @@ func NewVerifier() *Verifier {
 	return &Verifier{}
 }
 
-func (v *Verifier) Verify(message []byte) ([]*VerificationResult, error) {
-	r := bytes.NewReader(message)
+// Verify checks every DKIM signature on a message read from r
+func (v *Verifier) Verify(r io.Reader) ([]*VerificationResult, error) {
 	verifications, err := dkim.Verify(r)
 	if err != nil {
 		return []*VerificationResult{{Valid: false, Error: err}}, nil
@@ -0,0 +1,626 @@
+// Package milter implements the MTA side of the Sendmail milter protocol
+// (version 6) so messages can be passed through external content filters
+// such as rspamd, opendkim or clamav-milter.
+package milter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// protocolVersion is the milter protocol version offered during negotiation
+const protocolVersion = 6
+
+// maxPacketSize bounds packets read from a milter
+const maxPacketSize = 1 << 20
+
+// maxBodyChunk is the largest body chunk sent in one packet
+const maxBodyChunk = 65535
+
+// Commands sent to the milter
+const (
+	cmdOptNeg  = 'O'
+	cmdMacro   = 'D'
+	cmdConnect = 'C'
+	cmdHelo    = 'H'
+	cmdMail    = 'M'
+	cmdRcpt    = 'R'
+	cmdData    = 'T'
+	cmdHeader  = 'L'
+	cmdEOH     = 'N'
+	cmdBody    = 'B'
+	cmdEOM     = 'E'
+	cmdAbort   = 'A'
+	cmdQuit    = 'Q'
+)
+
+// Replies from the milter
+const (
+	replyAccept     = 'a'
+	replyContinue   = 'c'
+	replyDiscard    = 'd'
+	replyReject     = 'r'
+	replyTempFail   = 't'
+	replyCode       = 'y'
+	replyProgress   = 'p'
+	replySkip       = 's'
+	replyAddHeader  = 'h'
+	replyInsHeader  = 'i'
+	replyChgHeader  = 'm'
+	replyReplBody   = 'b'
+	replyAddRcpt    = '+'
+	replyDelRcpt    = '-'
+	replyChgFrom    = 'e'
+	replyQuarantine = 'q'
+)
+
+// Modifications a milter may request at end of message (SMFIF_*)
+const (
+	actAddHeaders    = 0x01
+	actChangeBody    = 0x02
+	actAddRcpt       = 0x04
+	actDelRcpt       = 0x08
+	actChangeHeaders = 0x10
+	actQuarantine    = 0x20
+	actChangeFrom    = 0x40
+
+	supportedActions = actAddHeaders | actChangeBody | actAddRcpt | actDelRcpt |
+		actChangeHeaders | actQuarantine | actChangeFrom
+)
+
+// Protocol steps a milter may skip or leave unanswered (SMFIP_*)
+const (
+	optNoConnect      = 0x01
+	optNoHelo         = 0x02
+	optNoMail         = 0x04
+	optNoRcpt         = 0x08
+	optNoBody         = 0x10
+	optNoHeaders      = 0x20
+	optNoEOH          = 0x40
+	optNoReplyHeader  = 0x80
+	optNoUnknown      = 0x100
+	optNoData         = 0x200
+	optSkip           = 0x400
+	optNoReplyConnect = 0x1000
+	optNoReplyHelo    = 0x2000
+	optNoReplyMail    = 0x4000
+	optNoReplyRcpt    = 0x8000
+	optNoReplyData    = 0x10000
+	optNoReplyEOH     = 0x40000
+	optNoReplyBody    = 0x80000
+
+	supportedProtocol = optNoConnect | optNoHelo | optNoMail | optNoRcpt | optNoBody |
+		optNoHeaders | optNoEOH | optNoReplyHeader | optNoUnknown | optNoData | optSkip |
+		optNoReplyConnect | optNoReplyHelo | optNoReplyMail | optNoReplyRcpt |
+		optNoReplyData | optNoReplyEOH | optNoReplyBody
+)
+
+var (
+	// ErrProtocol is returned when a milter sends an unexpected or malformed packet
+	ErrProtocol = errors.New("milter: protocol error")
+	// ErrInvalidAddress is returned for socket specifications that cannot be parsed
+	ErrInvalidAddress = errors.New("milter: invalid address")
+)
+
+// Action is a milter's verdict for a protocol stage
+type Action int
+
+const (
+	// ActionContinue passes the stage on to the next one
+	ActionContinue Action = iota
+	// ActionAccept accepts the connection or message without further filtering
+	ActionAccept
+	// ActionReject rejects the command permanently
+	ActionReject
+	// ActionTempFail rejects the command temporarily
+	ActionTempFail
+	// ActionDiscard accepts the message and silently drops it
+	ActionDiscard
+)
+
+// ModificationType identifies a change requested at end of message
+type ModificationType int
+
+const (
+	// ModAddHeader appends Name: Value to the header
+	ModAddHeader ModificationType = iota
+	// ModInsertHeader inserts Name: Value before header field Index (0-based)
+	ModInsertHeader
+	// ModChangeHeader replaces occurrence Index (1-based) of Name; an empty Value deletes it
+	ModChangeHeader
+	// ModReplaceBody replaces the body with Body
+	ModReplaceBody
+	// ModAddRcpt adds Value as an envelope recipient
+	ModAddRcpt
+	// ModDelRcpt removes Value from the envelope recipients
+	ModDelRcpt
+	// ModChangeFrom replaces the envelope sender with Value
+	ModChangeFrom
+	// ModQuarantine holds the message for review with Value as the reason
+	ModQuarantine
+)
+
+// Modification is one change a milter requested at end of message
+type Modification struct {
+	Type  ModificationType
+	Index int
+	Name  string
+	Value string
+	Body  []byte
+}
+
+// Response is a milter's reply to a protocol stage
+type Response struct {
+	Action Action
+
+	// Code and Text carry a custom SMTP reply (SMFIR_REPLYCODE); Code is 0 otherwise
+	Code int
+	Text string
+
+	// Modifications requested at end of message, in the order received
+	Modifications []Modification
+}
+
+// Macros are sendmail macro values sent ahead of a stage, such as "j" or "{auth_authen}"
+type Macros map[string]string
+
+var continueResponse = &Response{Action: ActionContinue}
+
+// skipResponse marks a milter asking for no more body chunks
+var skipResponse = &Response{Action: ActionContinue}
+
+// Config configures a milter client
+type Config struct {
+	Name     string
+	Address  string        // "unix:/path", "inet:port@host", "inet6:port@host" or "host:port"
+	Timeout  time.Duration // per-command timeout
+	FailOpen bool          // skip the milter instead of failing the transaction when it is unavailable
+}
+
+// Client connects to one milter
+type Client struct {
+	name     string
+	network  string
+	address  string
+	timeout  time.Duration
+	failOpen bool
+}
+
+// NewClient creates a client for a milter socket
+func NewClient(cfg Config) (*Client, error) {
+	network, address, err := ParseAddress(cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &Client{
+		name:     cfg.Name,
+		network:  network,
+		address:  address,
+		timeout:  timeout,
+		failOpen: cfg.FailOpen,
+	}, nil
+}
+
+// ParseAddress converts a sendmail-style milter socket specification to a network and address
+func ParseAddress(spec string) (string, string, error) {
+	proto, rest, found := strings.Cut(spec, ":")
+	switch {
+	case found && (proto == "unix" || proto == "local"):
+		if rest == "" {
+			return "", "", fmt.Errorf("%w: %q", ErrInvalidAddress, spec)
+		}
+		return "unix", rest, nil
+	case found && (proto == "inet" || proto == "inet6"):
+		port, host, ok := strings.Cut(rest, "@")
+		if !ok || port == "" || host == "" {
+			return "", "", fmt.Errorf("%w: %q", ErrInvalidAddress, spec)
+		}
+		network := "tcp4"
+		if proto == "inet6" {
+			network = "tcp6"
+		}
+		return network, net.JoinHostPort(host, port), nil
+	case strings.HasPrefix(spec, "/"):
+		return "unix", spec, nil
+	}
+
+	if _, _, err := net.SplitHostPort(spec); err != nil {
+		return "", "", fmt.Errorf("%w: %q", ErrInvalidAddress, spec)
+	}
+	return "tcp", spec, nil
+}
+
+// Name returns the configured milter name
+func (c *Client) Name() string {
+	return c.name
+}
+
+// FailOpen reports whether the milter is skipped when unavailable
+func (c *Client) FailOpen() bool {
+	return c.failOpen
+}
+
+// Open connects to the milter and negotiates options
+func (c *Client) Open() (*Session, error) {
+	conn, err := net.DialTimeout(c.network, c.address, c.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to milter %s: %w", c.name, err)
+	}
+
+	s := &Session{conn: conn, timeout: c.timeout}
+	if err := s.negotiate(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to negotiate with milter %s: %w", c.name, err)
+	}
+	return s, nil
+}
+
+// Session is a connection to a milter for one SMTP connection
+type Session struct {
+	conn     net.Conn
+	timeout  time.Duration
+	actions  uint32
+	protocol uint32
+}
+
+// negotiate exchanges protocol version, actions and skipped steps
+func (s *Session) negotiate() error {
+	data := make([]byte, 12)
+	binary.BigEndian.PutUint32(data[0:4], protocolVersion)
+	binary.BigEndian.PutUint32(data[4:8], supportedActions)
+	binary.BigEndian.PutUint32(data[8:12], supportedProtocol)
+	if err := s.send(cmdOptNeg, data); err != nil {
+		return err
+	}
+
+	code, data, err := s.read()
+	if err != nil {
+		return err
+	}
+	if code != cmdOptNeg || len(data) < 12 {
+		return ErrProtocol
+	}
+	if version := binary.BigEndian.Uint32(data[0:4]); version < 2 || version > protocolVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrProtocol, version)
+	}
+	s.actions = binary.BigEndian.Uint32(data[4:8]) & supportedActions
+	s.protocol = binary.BigEndian.Uint32(data[8:12])
+	if s.protocol&^supportedProtocol != 0 {
+		return fmt.Errorf("%w: unsupported protocol flags %#x", ErrProtocol, s.protocol&^supportedProtocol)
+	}
+	return nil
+}
+
+// Connect sends the client's host name and address
+func (s *Session) Connect(hostname string, addr net.Addr, macros Macros) (*Response, error) {
+	var data []byte
+	data = appendCString(data, hostname)
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		family := byte('6')
+		if a.IP.To4() != nil {
+			family = '4'
+		}
+		data = append(data, family, 0, 0)
+		binary.BigEndian.PutUint16(data[len(data)-2:], uint16(a.Port))
+		data = appendCString(data, a.IP.String())
+	case *net.UnixAddr:
+		data = append(data, 'L', 0, 0)
+		data = appendCString(data, a.Name)
+	default:
+		data = append(data, 'U')
+	}
+	return s.step(cmdConnect, data, macros, optNoConnect, optNoReplyConnect)
+}
+
+// Helo sends the HELO or EHLO argument
+func (s *Session) Helo(name string) (*Response, error) {
+	return s.step(cmdHelo, appendCString(nil, name), nil, optNoHelo, optNoReplyHelo)
+}
+
+// Mail sends the envelope sender and its ESMTP parameters
+func (s *Session) Mail(from string, args []string, macros Macros) (*Response, error) {
+	return s.step(cmdMail, envelopeData(from, args), macros, optNoMail, optNoReplyMail)
+}
+
+// Rcpt sends one envelope recipient and its ESMTP parameters
+func (s *Session) Rcpt(to string, args []string, macros Macros) (*Response, error) {
+	return s.step(cmdRcpt, envelopeData(to, args), macros, optNoRcpt, optNoReplyRcpt)
+}
+
+// Data signals the start of message content
+func (s *Session) Data() (*Response, error) {
+	return s.step(cmdData, nil, nil, optNoData, optNoReplyData)
+}
+
+// Header sends one header field; value excludes the space after the colon
+func (s *Session) Header(name, value string) (*Response, error) {
+	data := appendCString(appendCString(nil, name), value)
+	return s.step(cmdHeader, data, nil, optNoHeaders, optNoReplyHeader)
+}
+
+// EndOfHeaders signals the end of the header
+func (s *Session) EndOfHeaders() (*Response, error) {
+	return s.step(cmdEOH, nil, nil, optNoEOH, optNoReplyEOH)
+}
+
+// Body streams the message body in chunks until the milter stops asking for more
+func (s *Session) Body(r io.Reader) (*Response, error) {
+	if s.protocol&optNoBody != 0 {
+		return continueResponse, nil
+	}
+
+	buf := make([]byte, maxBodyChunk)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			resp, sendErr := s.step(cmdBody, buf[:n], nil, 0, optNoReplyBody)
+			if sendErr != nil {
+				return nil, sendErr
+			}
+			if resp == skipResponse {
+				return continueResponse, nil
+			}
+			if resp.Action != ActionContinue {
+				return resp, nil
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return continueResponse, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// End signals the end of the message and collects requested modifications
+func (s *Session) End() (*Response, error) {
+	if err := s.send(cmdEOM, nil); err != nil {
+		return nil, err
+	}
+
+	var mods []Modification
+	for {
+		code, data, err := s.read()
+		if err != nil {
+			return nil, err
+		}
+		if code == replySkip {
+			return nil, ErrProtocol
+		}
+		if resp, ok, err := parseAction(code, data); err != nil {
+			return nil, err
+		} else if ok {
+			resp.Modifications = mods
+			return resp, nil
+		}
+
+		mod, err := s.parseModification(code, data)
+		if err != nil {
+			return nil, err
+		}
+		if mod != nil {
+			mods = append(mods, *mod)
+		}
+	}
+}
+
+// Abort discards the current message; the connection stays open for the next one
+func (s *Session) Abort() error {
+	return s.send(cmdAbort, nil)
+}
+
+// Close ends the milter session
+func (s *Session) Close() error {
+	s.send(cmdQuit, nil)
+	return s.conn.Close()
+}
+
+// step sends a stage's command unless the milter skips it, and reads the reply
+// unless the milter declared it will not send one
+func (s *Session) step(cmd byte, data []byte, macros Macros, skipFlag, noReplyFlag uint32) (*Response, error) {
+	if skipFlag != 0 && s.protocol&skipFlag != 0 {
+		return continueResponse, nil
+	}
+	if len(macros) > 0 {
+		if err := s.send(cmdMacro, macroData(cmd, macros)); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.send(cmd, data); err != nil {
+		return nil, err
+	}
+	if s.protocol&noReplyFlag != 0 {
+		return continueResponse, nil
+	}
+
+	for {
+		code, data, err := s.read()
+		if err != nil {
+			return nil, err
+		}
+		if code == replyProgress {
+			continue
+		}
+		if code == replySkip && cmd == cmdBody && s.protocol&optSkip != 0 {
+			return skipResponse, nil
+		}
+		resp, ok, err := parseAction(code, data)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: unexpected reply %q", ErrProtocol, code)
+		}
+		return resp, nil
+	}
+}
+
+// parseAction converts a verdict packet; ok is false for non-verdict packets
+func parseAction(code byte, data []byte) (*Response, bool, error) {
+	switch code {
+	case replyContinue:
+		return &Response{Action: ActionContinue}, true, nil
+	case replyAccept:
+		return &Response{Action: ActionAccept}, true, nil
+	case replyReject:
+		return &Response{Action: ActionReject}, true, nil
+	case replyTempFail:
+		return &Response{Action: ActionTempFail}, true, nil
+	case replyDiscard:
+		return &Response{Action: ActionDiscard}, true, nil
+	case replyCode:
+		reply := strings.TrimRight(string(bytes.TrimRight(data, "\x00")), "\r\n")
+		if len(reply) < 3 {
+			return nil, false, ErrProtocol
+		}
+		smtpCode, err := strconv.Atoi(reply[:3])
+		if err != nil || smtpCode < 400 || smtpCode > 599 {
+			return nil, false, fmt.Errorf("%w: invalid reply code %q", ErrProtocol, reply)
+		}
+		resp := &Response{Action: ActionReject, Code: smtpCode, Text: strings.TrimSpace(reply[3:])}
+		if smtpCode < 500 {
+			resp.Action = ActionTempFail
+		}
+		return resp, true, nil
+	}
+	return nil, false, nil
+}
+
+// parseModification decodes an end-of-message modification packet
+// Progress packets return nil so the caller keeps waiting.
+func (s *Session) parseModification(code byte, data []byte) (*Modification, error) {
+	var (
+		mod    Modification
+		action uint32
+	)
+	switch code {
+	case replyProgress:
+		return nil, nil
+	case replyAddHeader:
+		mod.Type, action = ModAddHeader, actAddHeaders
+	case replyInsHeader:
+		mod.Type, action = ModInsertHeader, actAddHeaders
+	case replyChgHeader:
+		mod.Type, action = ModChangeHeader, actChangeHeaders
+	case replyReplBody:
+		if s.actions&actChangeBody == 0 {
+			return nil, fmt.Errorf("%w: body change was not negotiated", ErrProtocol)
+		}
+		return &Modification{Type: ModReplaceBody, Body: data}, nil
+	case replyAddRcpt:
+		mod.Type, action = ModAddRcpt, actAddRcpt
+	case replyDelRcpt:
+		mod.Type, action = ModDelRcpt, actDelRcpt
+	case replyChgFrom:
+		mod.Type, action = ModChangeFrom, actChangeFrom
+	case replyQuarantine:
+		mod.Type, action = ModQuarantine, actQuarantine
+	default:
+		return nil, fmt.Errorf("%w: unexpected reply %q", ErrProtocol, code)
+	}
+	if s.actions&action == 0 {
+		return nil, fmt.Errorf("%w: action %q was not negotiated", ErrProtocol, code)
+	}
+
+	switch mod.Type {
+	case ModInsertHeader, ModChangeHeader:
+		if len(data) < 4 {
+			return nil, ErrProtocol
+		}
+		mod.Index = int(binary.BigEndian.Uint32(data[0:4]))
+		data = data[4:]
+		fallthrough
+	case ModAddHeader:
+		fields := splitCStrings(data)
+		if len(fields) < 2 || fields[0] == "" {
+			return nil, ErrProtocol
+		}
+		mod.Name, mod.Value = fields[0], fields[1]
+	default:
+		fields := splitCStrings(data)
+		if len(fields) < 1 {
+			return nil, ErrProtocol
+		}
+		mod.Value = fields[0]
+	}
+	return &mod, nil
+}
+
+// send writes one packet
+func (s *Session) send(cmd byte, data []byte) error {
+	s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	packet := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(packet[0:4], uint32(len(data)+1))
+	packet[4] = cmd
+	_, err := s.conn.Write(append(packet, data...))
+	return err
+}
+
+// read reads one packet
+func (s *Session) read() (byte, []byte, error) {
+	s.conn.SetReadDeadline(time.Now().Add(s.timeout))
+	var hdr [4]byte
+	if _, err := io.ReadFull(s.conn, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(hdr[:])
+	if size == 0 || size > maxPacketSize {
+		return 0, nil, fmt.Errorf("%w: invalid packet size %d", ErrProtocol, size)
+	}
+	packet := make([]byte, size)
+	if _, err := io.ReadFull(s.conn, packet); err != nil {
+		return 0, nil, err
+	}
+	return packet[0], packet[1:], nil
+}
+
+// envelopeData encodes an address in angle brackets followed by its parameters
+func envelopeData(addr string, args []string) []byte {
+	data := appendCString(nil, "<"+addr+">")
+	for _, arg := range args {
+		data = appendCString(data, arg)
+	}
+	return data
+}
+
+// macroData encodes macros for the command they precede
+func macroData(cmd byte, macros Macros) []byte {
+	names := make([]string, 0, len(macros))
+	for name := range macros {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	data := []byte{cmd}
+	for _, name := range names {
+		data = appendCString(appendCString(data, name), macros[name])
+	}
+	return data
+}
+
+func appendCString(data []byte, s string) []byte {
+	return append(append(data, s...), 0)
+}
+
+// splitCStrings splits NUL-terminated strings
+func splitCStrings(data []byte) []string {
+	data = bytes.TrimSuffix(data, []byte{0})
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.Split(string(data), "\x00")
+}
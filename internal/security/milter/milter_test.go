@@ -0,0 +1,240 @@
+package milter
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeMilter is a scripted milter listening on a unix socket
+type fakeMilter struct {
+	protocol uint32
+	// reply returns the packets sent in response to a command; nil sends "continue"
+	reply func(cmd byte, data []byte) [][]byte
+	seen  chan string
+}
+
+func packet(code byte, data ...byte) []byte {
+	return append([]byte{code}, data...)
+}
+
+func (f *fakeMilter) serve(t *testing.T) *Client {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "milter.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f.seen = make(chan string, 64)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var hdr [4]byte
+			if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+				return
+			}
+			buf := make([]byte, binary.BigEndian.Uint32(hdr[:]))
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				return
+			}
+			cmd, data := buf[0], buf[1:]
+			f.seen <- string(cmd)
+
+			var replies [][]byte
+			switch cmd {
+			case cmdOptNeg:
+				opt := make([]byte, 12)
+				binary.BigEndian.PutUint32(opt[0:4], 6)
+				binary.BigEndian.PutUint32(opt[4:8], supportedActions)
+				binary.BigEndian.PutUint32(opt[8:12], f.protocol)
+				replies = [][]byte{packet(cmdOptNeg, opt...)}
+			case cmdMacro, cmdAbort:
+				continue
+			case cmdQuit:
+				return
+			default:
+				if f.reply != nil {
+					replies = f.reply(cmd, data)
+				}
+				if replies == nil {
+					replies = [][]byte{packet(replyContinue)}
+				}
+			}
+			for _, r := range replies {
+				var size [4]byte
+				binary.BigEndian.PutUint32(size[:], uint32(len(r)))
+				conn.Write(append(size[:], r...))
+			}
+		}
+	}()
+
+	client, err := NewClient(Config{Name: "test", Address: "unix:" + path, Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	return client
+}
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		spec    string
+		network string
+		address string
+	}{
+		{"unix:/run/rspamd/milter.sock", "unix", "/run/rspamd/milter.sock"},
+		{"/run/opendkim.sock", "unix", "/run/opendkim.sock"},
+		{"inet:11332@localhost", "tcp4", "localhost:11332"},
+		{"inet6:8891@::1", "tcp6", "[::1]:8891"},
+		{"127.0.0.1:11332", "tcp", "127.0.0.1:11332"},
+	}
+	for _, tt := range tests {
+		network, address, err := ParseAddress(tt.spec)
+		if err != nil || network != tt.network || address != tt.address {
+			t.Errorf("ParseAddress(%q) = %s %s %v", tt.spec, network, address, err)
+		}
+	}
+
+	for _, spec := range []string{"inet:11332", "unix:", "localhost"} {
+		if _, _, err := ParseAddress(spec); !errors.Is(err, ErrInvalidAddress) {
+			t.Errorf("expected %q to be rejected, got %v", spec, err)
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+	fake := &fakeMilter{reply: func(cmd byte, data []byte) [][]byte {
+		switch cmd {
+		case cmdRcpt:
+			if strings.HasPrefix(string(data), "<unknown@") {
+				return [][]byte{packet(replyCode, []byte("550 5.1.1 No such user\x00")...)}
+			}
+		case cmdEOM:
+			chg := make([]byte, 4)
+			binary.BigEndian.PutUint32(chg, 1)
+			return [][]byte{
+				packet(replyProgress),
+				packet(replyAddHeader, []byte("X-Spam\x00Yes\x00")...),
+				packet(replyChgHeader, append(chg, []byte("Subject\x00[SPAM] Hello\x00")...)...),
+				packet(replyReplBody, []byte("new body\r\n")...),
+				packet(replyQuarantine, []byte("suspicious\x00")...),
+				packet(replyAccept),
+			}
+		}
+		return nil
+	}}
+	client := fake.serve(t)
+
+	s, err := client.Open()
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer s.Close()
+
+	expectContinue := func(resp *Response, err error) {
+		t.Helper()
+		if err != nil || resp.Action != ActionContinue {
+			t.Fatalf("expected continue, got %+v %v", resp, err)
+		}
+	}
+	expectContinue(s.Connect("[192.0.2.1]", &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 4000}, Macros{"j": "mx.example.com"}))
+	expectContinue(s.Helo("client.example.net"))
+	expectContinue(s.Mail("sender@example.net", []string{"BODY=8BITMIME"}, nil))
+
+	resp, err := s.Rcpt("unknown@example.com", nil, nil)
+	if err != nil || resp.Action != ActionReject || resp.Code != 550 || resp.Text != "5.1.1 No such user" {
+		t.Fatalf("expected custom rejection, got %+v %v", resp, err)
+	}
+	expectContinue(s.Rcpt("user@example.com", nil, nil))
+
+	expectContinue(s.Data())
+	expectContinue(s.Header("Subject", "Hello"))
+	expectContinue(s.EndOfHeaders())
+	expectContinue(s.Body(strings.NewReader(strings.Repeat("x", maxBodyChunk+10))))
+
+	resp, err = s.End()
+	if err != nil || resp.Action != ActionAccept {
+		t.Fatalf("expected accept, got %+v %v", resp, err)
+	}
+	want := []Modification{
+		{Type: ModAddHeader, Name: "X-Spam", Value: "Yes"},
+		{Type: ModChangeHeader, Index: 1, Name: "Subject", Value: "[SPAM] Hello"},
+		{Type: ModReplaceBody, Body: []byte("new body\r\n")},
+		{Type: ModQuarantine, Value: "suspicious"},
+	}
+	if len(resp.Modifications) != len(want) {
+		t.Fatalf("expected %d modifications, got %+v", len(want), resp.Modifications)
+	}
+	for i, m := range resp.Modifications {
+		if m.Type != want[i].Type || m.Index != want[i].Index || m.Name != want[i].Name ||
+			m.Value != want[i].Value || string(m.Body) != string(want[i].Body) {
+			t.Errorf("modification %d: expected %+v, got %+v", i, want[i], m)
+		}
+	}
+
+	// The large body is split into two chunks
+	var body int
+	for len(fake.seen) > 0 {
+		if <-fake.seen == string(rune(cmdBody)) {
+			body++
+		}
+	}
+	if body != 2 {
+		t.Errorf("expected 2 body chunks, got %d", body)
+	}
+}
+
+func TestSessionSkippedSteps(t *testing.T) {
+	fake := &fakeMilter{
+		protocol: optNoHelo | optNoReplyHeader | optSkip,
+		reply: func(cmd byte, data []byte) [][]byte {
+			switch cmd {
+			case cmdHelo:
+				t.Error("HELO must not be sent when the milter skips it")
+			case cmdHeader:
+				return [][]byte{}
+			case cmdBody:
+				return [][]byte{packet(replySkip)}
+			}
+			return nil
+		},
+	}
+	s, err := fake.serve(t).Open()
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer s.Close()
+
+	if resp, err := s.Helo("client.example.net"); err != nil || resp.Action != ActionContinue {
+		t.Fatalf("expected skipped HELO to continue, got %+v %v", resp, err)
+	}
+	if resp, err := s.Header("Subject", "Hello"); err != nil || resp.Action != ActionContinue {
+		t.Fatalf("expected unanswered header to continue, got %+v %v", resp, err)
+	}
+	if resp, err := s.Body(strings.NewReader(strings.Repeat("x", 3*maxBodyChunk))); err != nil || resp.Action != ActionContinue {
+		t.Fatalf("expected skipped body to continue, got %+v %v", resp, err)
+	}
+	if resp, err := s.End(); err != nil || resp.Action != ActionContinue {
+		t.Fatalf("expected continue at end of message, got %+v %v", resp, err)
+	}
+
+	var body int
+	for len(fake.seen) > 0 {
+		if <-fake.seen == string(rune(cmdBody)) {
+			body++
+		}
+	}
+	if body != 1 {
+		t.Errorf("expected body to stop after the skip reply, got %d chunks", body)
+	}
+}
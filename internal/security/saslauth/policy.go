@@ -0,0 +1,174 @@
+package saslauth
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/repository"
+	"github.com/btafoya/gomailserver/internal/security/bruteforce"
+	"github.com/btafoya/gomailserver/internal/security/ratelimit"
+)
+
+var (
+	// ErrInvalidUsername is returned for usernames that are not email addresses
+	ErrInvalidUsername = errors.New("invalid username format")
+	// ErrLoginBlocked is returned while brute force protection blocks the client
+	ErrLoginBlocked = errors.New("too many failed login attempts")
+	// ErrRateLimited is returned when a login exceeds the protocol's rate limit
+	ErrRateLimited = errors.New("rate limit exceeded")
+	// ErrAccountDisabled is returned for valid credentials of an inactive user
+	ErrAccountDisabled = errors.New("account disabled")
+)
+
+// RateLimit reports whether a login attempt is within a protocol's rate limit
+type RateLimit func(username, remoteIP string) (bool, error)
+
+// UserRateLimit limits logins per user under the named limit
+func UserRateLimit(limiter *ratelimit.Limiter, name string) RateLimit {
+	if limiter == nil {
+		return nil
+	}
+	return func(username, _ string) (bool, error) {
+		return limiter.Check(name, username)
+	}
+}
+
+// IPRateLimit limits authentication attempts per client IP
+func IPRateLimit(limiter *ratelimit.Limiter) RateLimit {
+	if limiter == nil {
+		return nil
+	}
+	return func(_, remoteIP string) (bool, error) {
+		return limiter.CheckAuth(remoteIP)
+	}
+}
+
+// LoginPolicy applies brute force protection, rate limits and account checks
+// around a credential check, shared by the SMTP, IMAP and POP3 logins
+// Both protections only apply to domains that enable them.
+type LoginPolicy struct {
+	Protocol   string // names the protocol in log messages
+	Domains    repository.DomainRepository
+	BruteForce *bruteforce.Protection // optional
+	RateLimit  RateLimit              // optional
+	Logger     *zap.Logger
+}
+
+// Login runs verify for username connecting from remoteAddr with mech
+// It returns ErrInvalidCredentials when verify fails, or one of the policy
+// errors when the attempt is refused before or after the check.
+func (p LoginPolicy) Login(remoteAddr, mech, username string, verify func() (*domain.User, error)) (*domain.User, error) {
+	p.Logger.Info(p.Protocol+" authentication attempt",
+		zap.String("username", username),
+		zap.String("remote_addr", remoteAddr),
+		zap.String("method", mech),
+	)
+
+	domainName := domainPart(username)
+	if domainName == "" {
+		return nil, ErrInvalidUsername
+	}
+
+	domainConfig, err := p.Domains.GetByName(domainName)
+	if err != nil {
+		p.Logger.Error("failed to load domain config",
+			zap.String("domain", domainName),
+			zap.Error(err),
+		)
+		// Continue even if domain config fails
+		domainConfig = nil
+	}
+	bruteForce := domainConfig != nil && p.BruteForce != nil && domainConfig.AuthBruteForceEnabled
+	remoteIP := hostPart(remoteAddr)
+
+	if bruteForce {
+		blocked, err := p.BruteForce.IsBlocked(remoteIP)
+		if err != nil {
+			p.Logger.Error("brute force check failed", zap.Error(err))
+		} else if blocked {
+			p.Logger.Warn(p.Protocol+" authentication blocked - brute force protection",
+				zap.String("username", username),
+				zap.String("remote_ip", remoteIP),
+			)
+			return nil, ErrLoginBlocked
+		}
+	}
+
+	if domainConfig != nil && p.RateLimit != nil && domainConfig.RateLimitEnabled {
+		allowed, err := p.RateLimit(username, remoteIP)
+		if err != nil {
+			p.Logger.Error("rate limit check failed", zap.Error(err))
+		} else if !allowed {
+			p.Logger.Warn(p.Protocol+" authentication rate limited",
+				zap.String("username", username),
+				zap.String("remote_ip", remoteIP),
+				zap.String("domain", domainName),
+			)
+			return nil, ErrRateLimited
+		}
+	}
+
+	user, err := verify()
+	if err != nil {
+		if bruteForce {
+			if err := p.BruteForce.RecordFailure(remoteIP, username); err != nil {
+				p.Logger.Error("failed to record login failure", zap.Error(err))
+			}
+		}
+
+		p.Logger.Warn(p.Protocol+" authentication failed",
+			zap.String("username", username),
+			zap.String("remote_addr", remoteAddr),
+			zap.Error(err),
+		)
+		return nil, ErrInvalidCredentials
+	}
+
+	if user.Status != "active" {
+		p.Logger.Warn(p.Protocol+" authentication failed - user disabled",
+			zap.String("username", username),
+			zap.String("status", user.Status),
+		)
+		return nil, ErrAccountDisabled
+	}
+
+	// TOTP cannot be entered by mail clients; accounts that enforce it
+	// are limited to app passwords by the user service
+
+	if bruteForce {
+		if err := p.BruteForce.RecordSuccess(remoteIP, username); err != nil {
+			p.Logger.Error("failed to record successful login", zap.Error(err))
+		}
+	}
+
+	p.Logger.Info(p.Protocol+" authentication successful",
+		zap.String("username", username),
+		zap.Int64("user_id", user.ID),
+		zap.String("remote_addr", remoteAddr),
+		zap.String("method", mech),
+	)
+
+	return user, nil
+}
+
+// domainPart returns the domain of an email address, or "" if it has none
+func domainPart(email string) string {
+	parts := strings.Split(email, "@")
+	if len(parts) != 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+// hostPart returns the IP address of a remote address
+func hostPart(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
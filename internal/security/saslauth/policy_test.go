@@ -0,0 +1,59 @@
+package saslauth
+
+import (
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+// testDomains serves one domain configuration for every name
+type testDomains struct {
+	domain *domain.Domain
+}
+
+func (d *testDomains) Create(*domain.Domain) error              { return nil }
+func (d *testDomains) GetByID(int64) (*domain.Domain, error)    { return d.domain, nil }
+func (d *testDomains) GetByName(string) (*domain.Domain, error) { return d.domain, nil }
+func (d *testDomains) Update(*domain.Domain) error              { return nil }
+func (d *testDomains) Delete(int64) error                       { return nil }
+func (d *testDomains) List(int, int) ([]*domain.Domain, error)  { return nil, nil }
+
+func TestLoginPolicy_Login(t *testing.T) {
+	active := &domain.User{ID: 1, Email: "user@example.com", Status: "active"}
+	disabled := &domain.User{ID: 2, Email: "user@example.com", Status: "disabled"}
+	deny := func(string, string) (bool, error) { return false, nil }
+
+	tests := []struct {
+		name      string
+		username  string
+		rateLimit RateLimit
+		verify    func() (*domain.User, error)
+		want      error
+	}{
+		{"valid credentials", "user@example.com", nil, func() (*domain.User, error) { return active, nil }, nil},
+		{"username without domain", "user", nil, func() (*domain.User, error) { return active, nil }, ErrInvalidUsername},
+		{"rate limited", "user@example.com", deny, func() (*domain.User, error) { return active, nil }, ErrRateLimited},
+		{"wrong password", "user@example.com", nil, func() (*domain.User, error) { return nil, errors.New("bad password") }, ErrInvalidCredentials},
+		{"disabled account", "user@example.com", nil, func() (*domain.User, error) { return disabled, nil }, ErrAccountDisabled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := LoginPolicy{
+				Protocol:  "TEST",
+				Domains:   &testDomains{domain: &domain.Domain{Name: "example.com", RateLimitEnabled: true}},
+				RateLimit: tt.rateLimit,
+				Logger:    zap.NewNop(),
+			}
+			user, err := policy.Login("192.0.2.1:1234", Plain, tt.username, tt.verify)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Login error = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && user != active {
+				t.Errorf("Login user = %v, want %v", user, active)
+			}
+		})
+	}
+}
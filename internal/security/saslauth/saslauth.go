@@ -0,0 +1,217 @@
+package saslauth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-sasl"
+
+	"github.com/btafoya/gomailserver/internal/api/middleware"
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+// Supported SASL mechanisms
+const (
+	Plain       = sasl.Plain
+	Login       = sasl.Login
+	ScramSHA256 = "SCRAM-SHA-256"
+	OAuthBearer = sasl.OAuthBearer
+	XOAuth2     = "XOAUTH2"
+)
+
+var (
+	// ErrUnsupportedMechanism is returned for mechanisms that are not offered
+	ErrUnsupportedMechanism = errors.New("unsupported authentication mechanism")
+	// ErrInvalidCredentials is returned when a mechanism's credential check fails
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrMalformedResponse is returned when a client response cannot be parsed
+	ErrMalformedResponse = errors.New("malformed SASL response")
+)
+
+// Users is the subset of the user service the mechanisms need
+type Users interface {
+	AuthenticateClient(email, password, scope string) (*domain.User, error)
+	GetByEmail(email string) (*domain.User, error)
+	RequiresAppPassword(user *domain.User) bool
+}
+
+// LoginFunc applies protocol policy (brute force protection, rate limits,
+// account status) around a mechanism's credential check. verify performs the
+// check and returns the authenticated user.
+type LoginFunc func(username string, verify func() (*domain.User, error)) error
+
+// Authenticator creates SASL servers shared by the SMTP, IMAP and POP3 listeners
+type Authenticator struct {
+	users     Users
+	jwtSecret string
+	scramKey  []byte // derives the SCRAM salts shown for unknown users
+}
+
+// NewAuthenticator creates a new authenticator
+// Bearer token mechanisms are only offered when jwtSecret is set. The key
+// for SCRAM salts of unknown users is derived from jwtSecret so they stay the
+// same across restarts, or is random when there is no secret.
+func NewAuthenticator(users Users, jwtSecret string) *Authenticator {
+	scramKey := make([]byte, sha256.Size)
+	if jwtSecret != "" {
+		scramKey = hmacSHA256([]byte(jwtSecret), []byte("SCRAM-SHA-256 unknown user salt"))
+	} else {
+		rand.Read(scramKey)
+	}
+	return &Authenticator{
+		users:     users,
+		jwtSecret: jwtSecret,
+		scramKey:  scramKey,
+	}
+}
+
+// Mechanisms returns the mechanisms to advertise, strongest first
+func (a *Authenticator) Mechanisms() []string {
+	mechs := []string{ScramSHA256, Plain, Login}
+	if a.jwtSecret != "" {
+		mechs = append(mechs, OAuthBearer, XOAuth2)
+	}
+	return mechs
+}
+
+// NewServer creates a SASL server for a mechanism
+// scope names the protocol for app password checks (see domain.AppPasswordScopeIMAP).
+func (a *Authenticator) NewServer(mech, scope string, login LoginFunc) (sasl.Server, error) {
+	switch strings.ToUpper(mech) {
+	case Plain:
+		return sasl.NewPlainServer(func(identity, username, password string) error {
+			// Use username if provided, otherwise fall back to identity
+			if username == "" {
+				username = identity
+			}
+			return a.passwordLogin(login, scope, username, password)
+		}), nil
+	case Login:
+		return &loginServer{login: func(username, password string) error {
+			return a.passwordLogin(login, scope, username, password)
+		}}, nil
+	case ScramSHA256:
+		return newSCRAMServer(a.users, login, a.scramKey), nil
+	case OAuthBearer:
+		if a.jwtSecret == "" {
+			break
+		}
+		return sasl.NewOAuthBearerServer(func(opts sasl.OAuthBearerOptions) *sasl.OAuthBearerError {
+			if err := a.tokenLogin(login, opts.Username, opts.Token); err != nil {
+				return &sasl.OAuthBearerError{Status: "invalid_token", Schemes: "bearer"}
+			}
+			return nil
+		}), nil
+	case XOAuth2:
+		if a.jwtSecret == "" {
+			break
+		}
+		return &xoauth2Server{login: func(username, token string) error {
+			return a.tokenLogin(login, username, token)
+		}}, nil
+	}
+	return nil, ErrUnsupportedMechanism
+}
+
+// passwordLogin checks a cleartext password or app password (PLAIN, LOGIN)
+func (a *Authenticator) passwordLogin(login LoginFunc, scope, username, password string) error {
+	return login(username, func() (*domain.User, error) {
+		return a.users.AuthenticateClient(username, password, scope)
+	})
+}
+
+// tokenLogin checks a JWT issued by the admin API (OAUTHBEARER, XOAUTH2)
+// The token must belong to the user named in the exchange, if one is given.
+func (a *Authenticator) tokenLogin(login LoginFunc, username, token string) error {
+	claims, tokenErr := middleware.ValidateJWT(token, a.jwtSecret)
+	if username == "" && tokenErr == nil {
+		username = claims.Email
+	}
+
+	return login(username, func() (*domain.User, error) {
+		if tokenErr != nil {
+			return nil, ErrInvalidCredentials
+		}
+		if !strings.EqualFold(username, claims.Email) {
+			return nil, ErrInvalidCredentials
+		}
+		user, err := a.users.GetByEmail(claims.Email)
+		if err != nil || user.ID != claims.UserID {
+			return nil, ErrInvalidCredentials
+		}
+		return user, nil
+	})
+}
+
+// loginServer implements the LOGIN mechanism (draft-murchison-sasl-login)
+type loginServer struct {
+	login    func(username, password string) error
+	username string
+	step     int
+}
+
+func (s *loginServer) Next(response []byte) (challenge []byte, done bool, err error) {
+	switch s.step {
+	case 0:
+		s.step++
+		if response == nil {
+			return []byte("Username:"), false, nil
+		}
+		// Initial response carries the username
+		fallthrough
+	case 1:
+		s.username = string(response)
+		s.step = 2
+		return []byte("Password:"), false, nil
+	case 2:
+		s.step++
+		return nil, true, s.login(s.username, string(response))
+	}
+	return nil, false, ErrMalformedResponse
+}
+
+// xoauth2Server implements Google's XOAUTH2 mechanism
+// The client sends "user=<user>^Aauth=Bearer <token>^A^A" as its only response.
+type xoauth2Server struct {
+	login func(username, token string) error
+	step  int
+}
+
+func (s *xoauth2Server) Next(response []byte) (challenge []byte, done bool, err error) {
+	switch {
+	case s.step == 0 && response == nil:
+		// No initial response; ask for it with an empty challenge
+		s.step++
+		return []byte{}, false, nil
+	case s.step > 1:
+		return nil, false, ErrMalformedResponse
+	}
+	s.step = 2
+
+	var username, token string
+	for _, field := range bytes.Split(response, []byte{0x01}) {
+		k, v, ok := strings.Cut(string(field), "=")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "user":
+			username = v
+		case "auth":
+			scheme, t, ok := strings.Cut(v, " ")
+			if !ok || !strings.EqualFold(scheme, "Bearer") {
+				return nil, false, fmt.Errorf("%w: unsupported token type", ErrMalformedResponse)
+			}
+			token = t
+		}
+	}
+	if token == "" {
+		return nil, false, ErrMalformedResponse
+	}
+
+	return nil, true, s.login(username, token)
+}
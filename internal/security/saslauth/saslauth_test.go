@@ -0,0 +1,270 @@
+package saslauth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/btafoya/gomailserver/internal/api/middleware"
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+type testUsers struct {
+	user        *domain.User
+	password    string
+	appPassword string
+}
+
+func (u *testUsers) AuthenticateClient(email, password, scope string) (*domain.User, error) {
+	if email != u.user.Email {
+		return nil, ErrInvalidCredentials
+	}
+	if password == u.appPassword && scope == domain.AppPasswordScopeIMAP {
+		return u.user, nil
+	}
+	if password != u.password || u.RequiresAppPassword(u.user) {
+		return nil, ErrInvalidCredentials
+	}
+	return u.user, nil
+}
+
+func (u *testUsers) RequiresAppPassword(user *domain.User) bool {
+	return user.TOTPEnabled
+}
+
+func (u *testUsers) GetByEmail(email string) (*domain.User, error) {
+	if email != u.user.Email {
+		return nil, errors.New("not found")
+	}
+	return u.user, nil
+}
+
+func newTestUsers(t *testing.T) *testUsers {
+	creds, err := NewSCRAMCredentials("secret")
+	if err != nil {
+		t.Fatalf("NewSCRAMCredentials failed: %v", err)
+	}
+	return &testUsers{
+		user:        &domain.User{ID: 7, Email: "user@example.com", SCRAMSHA256: creds.String()},
+		password:    "secret",
+		appPassword: "abcd-efgh-jkmn-pqrs",
+	}
+}
+
+// recordLogin returns a LoginFunc that stores the verified user
+func recordLogin(got **domain.User) LoginFunc {
+	return func(username string, verify func() (*domain.User, error)) error {
+		user, err := verify()
+		if err != nil {
+			return err
+		}
+		*got = user
+		return nil
+	}
+}
+
+// scramClientFinal computes the client-final message for a server-first message
+func scramClientFinal(t *testing.T, password, clientFirstBare, serverFirst string) (string, []byte) {
+	attrs := parseSCRAMAttributes(serverFirst)
+	salt, _ := base64.StdEncoding.DecodeString(attrs["s"])
+	if attrs["i"] != "4096" {
+		t.Fatalf("unexpected iteration count %q", attrs["i"])
+	}
+
+	saltedPassword, err := pbkdf2.Key(sha256.New, password, salt, scramIterations, sha256.Size)
+	if err != nil {
+		t.Fatalf("pbkdf2 failed: %v", err)
+	}
+	clientKey := hmacSHA256(saltedPassword, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+
+	withoutProof := "c=" + base64.StdEncoding.EncodeToString([]byte("n,,")) + ",r=" + attrs["r"]
+	authMessage := []byte(clientFirstBare + "," + serverFirst + "," + withoutProof)
+	signature := hmacSHA256(storedKey[:], authMessage)
+	for i := range clientKey {
+		clientKey[i] ^= signature[i]
+	}
+
+	serverSignature := hmacSHA256(hmacSHA256(saltedPassword, []byte("Server Key")), authMessage)
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(clientKey), serverSignature
+}
+
+func TestSCRAMSHA256(t *testing.T) {
+	users := newTestUsers(t)
+	auth := NewAuthenticator(users, "")
+
+	for _, tt := range []struct {
+		name     string
+		password string
+		ok       bool
+	}{
+		{"correct password", "secret", true},
+		{"wrong password", "wrong", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *domain.User
+			server, err := auth.NewServer(ScramSHA256, domain.AppPasswordScopeIMAP, recordLogin(&got))
+			if err != nil {
+				t.Fatalf("NewServer failed: %v", err)
+			}
+
+			clientFirstBare := "n=user@example.com,r=clientnonce"
+			serverFirst, done, err := server.Next([]byte("n,," + clientFirstBare))
+			if err != nil || done {
+				t.Fatalf("unexpected client-first result: done=%v err=%v", done, err)
+			}
+			if !strings.HasPrefix(string(serverFirst), "r=clientnonce") {
+				t.Fatalf("server nonce must extend the client nonce: %q", serverFirst)
+			}
+
+			clientFinal, serverSignature := scramClientFinal(t, tt.password, clientFirstBare, string(serverFirst))
+			serverFinal, _, err := server.Next([]byte(clientFinal))
+			if !tt.ok {
+				if !errors.Is(err, ErrInvalidCredentials) || got != nil {
+					t.Fatalf("expected invalid credentials, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("client-final rejected: %v", err)
+			}
+			if string(serverFinal) != "v="+base64.StdEncoding.EncodeToString(serverSignature) {
+				t.Fatalf("unexpected server signature %q", serverFinal)
+			}
+			if _, done, err := server.Next([]byte{}); !done || err != nil {
+				t.Fatalf("expected exchange to finish, done=%v err=%v", done, err)
+			}
+			if got == nil || got.ID != 7 {
+				t.Fatal("expected user to be logged in")
+			}
+		})
+	}
+}
+
+func TestSCRAMSHA256_UnknownUser(t *testing.T) {
+	serverFirst := func(auth *Authenticator, username string) map[string]string {
+		server, err := auth.NewServer(ScramSHA256, domain.AppPasswordScopeIMAP, recordLogin(new(*domain.User)))
+		if err != nil {
+			t.Fatalf("NewServer failed: %v", err)
+		}
+		msg, _, err := server.Next([]byte("n,,n=" + username + ",r=clientnonce"))
+		if err != nil {
+			t.Fatalf("unexpected client-first error: %v", err)
+		}
+		return parseSCRAMAttributes(string(msg))
+	}
+
+	auth := NewAuthenticator(newTestUsers(t), "test-secret")
+	first := serverFirst(auth, "nobody@example.com")
+	if first["i"] != strconv.Itoa(scramIterations) {
+		t.Errorf("expected the default iteration count, got %q", first["i"])
+	}
+	if again := serverFirst(auth, "Nobody@example.com"); again["s"] != first["s"] {
+		t.Errorf("expected the same salt on every attempt, got %q and %q", first["s"], again["s"])
+	}
+	if other := serverFirst(auth, "other@example.com"); other["s"] == first["s"] {
+		t.Error("expected different users to get different salts")
+	}
+	if restarted := serverFirst(NewAuthenticator(newTestUsers(t), "test-secret"), "nobody@example.com"); restarted["s"] != first["s"] {
+		t.Error("expected the salt to survive a restart with the same secret")
+	}
+}
+
+func TestLogin(t *testing.T) {
+	var got *domain.User
+	server, err := NewAuthenticator(newTestUsers(t), "").NewServer(Login, domain.AppPasswordScopeIMAP, recordLogin(&got))
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	if challenge, _, _ := server.Next(nil); string(challenge) != "Username:" {
+		t.Fatalf("unexpected challenge %q", challenge)
+	}
+	if challenge, _, _ := server.Next([]byte("user@example.com")); string(challenge) != "Password:" {
+		t.Fatalf("unexpected challenge %q", challenge)
+	}
+	if _, done, err := server.Next([]byte("secret")); !done || err != nil {
+		t.Fatalf("expected login to succeed, done=%v err=%v", done, err)
+	}
+	if got == nil {
+		t.Fatal("expected user to be logged in")
+	}
+}
+
+func TestAppPasswordOnlyAccount(t *testing.T) {
+	users := newTestUsers(t)
+	users.user.TOTPEnabled = true
+	auth := NewAuthenticator(users, "")
+
+	plain := func(password string) error {
+		var got *domain.User
+		server, _ := auth.NewServer(Plain, domain.AppPasswordScopeIMAP, recordLogin(&got))
+		_, _, err := server.Next([]byte("\x00user@example.com\x00" + password))
+		return err
+	}
+	if err := plain("abcd-efgh-jkmn-pqrs"); err != nil {
+		t.Errorf("expected app password to be accepted, got %v", err)
+	}
+	if err := plain("secret"); err == nil {
+		t.Error("expected account password to be refused")
+	}
+
+	// SCRAM derives from the account password, so it is refused as well
+	server, _ := auth.NewServer(ScramSHA256, domain.AppPasswordScopeIMAP, recordLogin(new(*domain.User)))
+	clientFirstBare := "n=user@example.com,r=clientnonce"
+	serverFirst, _, _ := server.Next([]byte("n,," + clientFirstBare))
+	clientFinal, _ := scramClientFinal(t, "secret", clientFirstBare, string(serverFirst))
+	if _, _, err := server.Next([]byte(clientFinal)); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected SCRAM to be refused, got %v", err)
+	}
+}
+
+func TestXOAuth2(t *testing.T) {
+	const secret = "test-secret"
+	auth := NewAuthenticator(newTestUsers(t), secret)
+
+	token, err := middleware.GenerateJWT(7, "user@example.com", "user", nil, secret)
+	if err != nil {
+		t.Fatalf("GenerateJWT failed: %v", err)
+	}
+	otherToken, _ := middleware.GenerateJWT(8, "other@example.com", "user", nil, secret)
+
+	for _, tt := range []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"token for user", token, true},
+		{"token for another user", otherToken, false},
+		{"garbage token", "not-a-jwt", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *domain.User
+			server, err := auth.NewServer(XOAuth2, domain.AppPasswordScopeIMAP, recordLogin(&got))
+			if err != nil {
+				t.Fatalf("NewServer failed: %v", err)
+			}
+
+			_, done, err := server.Next([]byte("user=user@example.com\x01auth=Bearer " + tt.token + "\x01\x01"))
+			if tt.ok && (err != nil || !done || got == nil) {
+				t.Fatalf("expected login to succeed, done=%v err=%v", done, err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("expected login to fail")
+			}
+		})
+	}
+}
+
+func TestMechanisms(t *testing.T) {
+	if mechs := NewAuthenticator(newTestUsers(t), "").Mechanisms(); len(mechs) != 3 {
+		t.Errorf("expected bearer mechanisms to be hidden without a JWT secret, got %v", mechs)
+	}
+	if _, err := NewAuthenticator(newTestUsers(t), "").NewServer(OAuthBearer, domain.AppPasswordScopeIMAP, nil); !errors.Is(err, ErrUnsupportedMechanism) {
+		t.Errorf("expected OAUTHBEARER to be unsupported without a JWT secret, got %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package saslauth
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+// scramIterations is the PBKDF2 iteration count for new credentials (RFC 7677 minimum)
+const scramIterations = 4096
+
+// SCRAMCredentials are the salted keys stored for SCRAM-SHA-256 (RFC 5802 section 3)
+// The password itself cannot be recovered from them.
+type SCRAMCredentials struct {
+	Iterations int
+	Salt       []byte
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+// NewSCRAMCredentials derives SCRAM-SHA-256 credentials for a password with a random salt
+func NewSCRAMCredentials(password string) (*SCRAMCredentials, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	saltedPassword, err := pbkdf2.Key(sha256.New, password, salt, scramIterations, sha256.Size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive salted password: %w", err)
+	}
+
+	clientKey := hmacSHA256(saltedPassword, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+
+	return &SCRAMCredentials{
+		Iterations: scramIterations,
+		Salt:       salt,
+		StoredKey:  storedKey[:],
+		ServerKey:  hmacSHA256(saltedPassword, []byte("Server Key")),
+	}, nil
+}
+
+// String encodes the credentials for storage as
+// SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>
+func (c *SCRAMCredentials) String() string {
+	b64 := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("%s$%d:%s$%s:%s", ScramSHA256, c.Iterations, b64(c.Salt), b64(c.StoredKey), b64(c.ServerKey))
+}
+
+// ParseSCRAMCredentials decodes credentials produced by SCRAMCredentials.String
+func ParseSCRAMCredentials(s string) (*SCRAMCredentials, error) {
+	parts := strings.Split(s, "$")
+	if len(parts) != 3 || parts[0] != ScramSHA256 {
+		return nil, fmt.Errorf("invalid SCRAM credentials")
+	}
+	iterStr, saltStr, ok1 := strings.Cut(parts[1], ":")
+	storedStr, serverStr, ok2 := strings.Cut(parts[2], ":")
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("invalid SCRAM credentials")
+	}
+
+	c := &SCRAMCredentials{}
+	var err error
+	if c.Iterations, err = strconv.Atoi(iterStr); err != nil {
+		return nil, fmt.Errorf("invalid SCRAM iteration count: %w", err)
+	}
+	for _, f := range []struct {
+		dst *[]byte
+		src string
+	}{{&c.Salt, saltStr}, {&c.StoredKey, storedStr}, {&c.ServerKey, serverStr}} {
+		if *f.dst, err = base64.StdEncoding.DecodeString(f.src); err != nil {
+			return nil, fmt.Errorf("invalid SCRAM credentials: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// scramServer implements the server side of SCRAM-SHA-256 without channel binding
+type scramServer struct {
+	users   Users
+	login   LoginFunc
+	saltKey []byte
+
+	step            int
+	username        string
+	gs2Header       string
+	clientFirstBare string
+	serverFirst     string
+	nonce           string
+	creds           *SCRAMCredentials
+}
+
+func newSCRAMServer(users Users, login LoginFunc, saltKey []byte) *scramServer {
+	return &scramServer{users: users, login: login, saltKey: saltKey}
+}
+
+func (s *scramServer) Next(response []byte) (challenge []byte, done bool, err error) {
+	switch s.step {
+	case 0:
+		if response == nil {
+			// Client-first message was not sent as an initial response
+			return []byte{}, false, nil
+		}
+		s.step++
+		return s.handleClientFirst(string(response))
+	case 1:
+		s.step++
+		return s.handleClientFinal(string(response))
+	case 2:
+		// Client acknowledges the server signature with an empty response
+		s.step++
+		return nil, true, nil
+	}
+	return nil, false, ErrMalformedResponse
+}
+
+// handleClientFirst parses "gs2-header client-first-message-bare" and returns server-first
+func (s *scramServer) handleClientFirst(msg string) ([]byte, bool, error) {
+	// gs2-header: channel binding flag, optional authzid, then the bare message
+	fields := strings.SplitN(msg, ",", 3)
+	if len(fields) != 3 {
+		return nil, false, ErrMalformedResponse
+	}
+	switch fields[0] {
+	case "n", "y":
+	default:
+		// "p=" requests channel binding, which SCRAM-SHA-256-PLUS would provide
+		return nil, false, fmt.Errorf("%w: channel binding not supported", ErrMalformedResponse)
+	}
+	s.gs2Header = fields[0] + "," + fields[1] + ","
+	s.clientFirstBare = fields[2]
+
+	attrs := parseSCRAMAttributes(s.clientFirstBare)
+	clientNonce := attrs["r"]
+	if attrs["n"] == "" || clientNonce == "" {
+		return nil, false, ErrMalformedResponse
+	}
+	s.username = strings.NewReplacer("=2C", ",", "=3D", "=").Replace(attrs["n"])
+	if authzid := strings.TrimPrefix(fields[1], "a="); authzid != "" && authzid != s.username {
+		return nil, false, fmt.Errorf("%w: authorization identity not supported", ErrMalformedResponse)
+	}
+
+	// Unknown users get a salt keyed on their name, so repeated attempts see
+	// the same salt and iteration count just as they would for an account
+	if user, err := s.users.GetByEmail(s.username); err == nil && user.SCRAMSHA256 != "" {
+		s.creds, _ = ParseSCRAMCredentials(user.SCRAMSHA256)
+	}
+	salt := hmacSHA256(s.saltKey, []byte(strings.ToLower(s.username)))[:16]
+	iterations := scramIterations
+	if s.creds != nil {
+		salt, iterations = s.creds.Salt, s.creds.Iterations
+	}
+
+	serverNonce := make([]byte, 18)
+	rand.Read(serverNonce)
+	s.nonce = clientNonce + base64.RawStdEncoding.EncodeToString(serverNonce)
+	s.serverFirst = fmt.Sprintf("r=%s,s=%s,i=%d", s.nonce, base64.StdEncoding.EncodeToString(salt), iterations)
+
+	return []byte(s.serverFirst), false, nil
+}
+
+// handleClientFinal verifies the client proof and returns the server signature
+func (s *scramServer) handleClientFinal(msg string) ([]byte, bool, error) {
+	proofIdx := strings.LastIndex(msg, ",p=")
+	if proofIdx < 0 {
+		return nil, false, ErrMalformedResponse
+	}
+	withoutProof := msg[:proofIdx]
+	attrs := parseSCRAMAttributes(withoutProof)
+
+	if attrs["c"] != base64.StdEncoding.EncodeToString([]byte(s.gs2Header)) || attrs["r"] != s.nonce {
+		return nil, false, ErrMalformedResponse
+	}
+	proof, err := base64.StdEncoding.DecodeString(msg[proofIdx+3:])
+	if err != nil || len(proof) != sha256.Size {
+		return nil, false, ErrMalformedResponse
+	}
+
+	authMessage := []byte(s.clientFirstBare + "," + s.serverFirst + "," + withoutProof)
+
+	err = s.login(s.username, func() (*domain.User, error) {
+		if s.creds == nil {
+			return nil, ErrInvalidCredentials
+		}
+		clientSignature := hmacSHA256(s.creds.StoredKey, authMessage)
+		clientKey := make([]byte, len(proof))
+		for i := range proof {
+			clientKey[i] = proof[i] ^ clientSignature[i]
+		}
+		storedKey := sha256.Sum256(clientKey)
+		if subtle.ConstantTimeCompare(storedKey[:], s.creds.StoredKey) != 1 {
+			return nil, ErrInvalidCredentials
+		}
+		user, err := s.users.GetByEmail(s.username)
+		if err != nil {
+			return nil, err
+		}
+		// SCRAM keys derive from the account password, which app-password-only accounts may not use
+		if s.users.RequiresAppPassword(user) {
+			return nil, ErrInvalidCredentials
+		}
+		return user, nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	serverSignature := hmacSHA256(s.creds.ServerKey, authMessage)
+	return []byte("v=" + base64.StdEncoding.EncodeToString(serverSignature)), false, nil
+}
+
+// parseSCRAMAttributes splits "k=v,k=v" into a map
+func parseSCRAMAttributes(msg string) map[string]string {
+	attrs := make(map[string]string)
+	for _, field := range strings.Split(msg, ",") {
+		if k, v, ok := strings.Cut(field, "="); ok && len(k) == 1 {
+			attrs[k] = v
+		}
+	}
+	return attrs
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
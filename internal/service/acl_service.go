@@ -0,0 +1,263 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/repository"
+)
+
+// AllRights lists the mailbox rights of RFC 4314 section 2.1 in canonical order
+// l lookup, r read, s keep seen, w write flags, i insert, p post, k create
+// mailboxes, x delete mailbox, t delete messages, e expunge, a administer.
+const AllRights = "lrswipkxtea"
+
+var (
+	ErrInvalidRights        = errors.New("invalid rights")
+	ErrInvalidACLIdentifier = errors.New("invalid ACL identifier")
+	ErrOwnerRights          = errors.New("the owner's rights cannot be changed")
+	ErrMailboxNotShared     = errors.New("mailbox not shared")
+)
+
+// SharedMailbox is another user's mailbox visible to a user through an ACL
+type SharedMailbox struct {
+	Mailbox *domain.Mailbox
+	Owner   *domain.User
+	Rights  string
+}
+
+// ACLService manages per-mailbox access rights (RFC 4314)
+// A mailbox owner implicitly holds all rights; grants to other users and to
+// "anyone" are stored per mailbox.
+type ACLService struct {
+	repo        repository.MailboxACLRepository
+	mailboxRepo repository.MailboxRepository
+	userRepo    repository.UserRepository
+	logger      *zap.Logger
+}
+
+// NewACLService creates a new ACL service
+func NewACLService(
+	repo repository.MailboxACLRepository,
+	mailboxRepo repository.MailboxRepository,
+	userRepo repository.UserRepository,
+	logger *zap.Logger,
+) *ACLService {
+	return &ACLService{
+		repo:        repo,
+		mailboxRepo: mailboxRepo,
+		userRepo:    userRepo,
+		logger:      logger,
+	}
+}
+
+// ParseRights validates a rights string and returns it in canonical order
+// The obsolete RFC 2086 rights are mapped as RFC 4314 section 2.1.1 describes:
+// "c" grants k and "d" grants x, t and e.
+func ParseRights(rights string) (string, error) {
+	expanded := strings.NewReplacer("c", "k", "d", "xte").Replace(rights)
+	for _, r := range expanded {
+		if !strings.ContainsRune(AllRights, r) {
+			return "", fmt.Errorf("%w: %q", ErrInvalidRights, r)
+		}
+	}
+	return filterRights(func(r rune) bool { return strings.ContainsRune(expanded, r) }), nil
+}
+
+// HasRights reports whether have includes every right in want
+func HasRights(have, want string) bool {
+	for _, r := range want {
+		if !strings.ContainsRune(have, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterRights returns the rights for which keep is true, in canonical order
+func filterRights(keep func(rune) bool) string {
+	var b strings.Builder
+	for _, r := range AllRights {
+		if keep(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Rights returns the rights user holds on a mailbox
+func (s *ACLService) Rights(user *domain.User, mailbox *domain.Mailbox) (string, error) {
+	if mailbox.UserID == user.ID {
+		return AllRights, nil
+	}
+
+	var granted string
+	for _, identifier := range []string{NormalizeAddress(user.Email), domain.ACLAnyone} {
+		if acl, err := s.repo.Get(mailbox.ID, identifier); err == nil {
+			granted += acl.Rights
+		}
+	}
+	return filterRights(func(r rune) bool { return strings.ContainsRune(granted, r) }), nil
+}
+
+// List returns the grants on a mailbox, excluding the owner's implicit rights
+func (s *ACLService) List(mailboxID int64) ([]*domain.MailboxACL, error) {
+	return s.repo.ListByMailbox(mailboxID)
+}
+
+// Set changes an identifier's rights on a mailbox
+// rights replaces the current grant, or adds to or removes from it when
+// prefixed with "+" or "-". Granting no rights removes the entry.
+func (s *ACLService) Set(mailboxID int64, identifier, rights string) error {
+	mailbox, err := s.mailboxRepo.GetByID(mailboxID)
+	if err != nil {
+		return err
+	}
+	identifier, err = s.identifier(mailbox, identifier)
+	if err != nil {
+		return err
+	}
+
+	var mode byte
+	if rights != "" && (rights[0] == '+' || rights[0] == '-') {
+		mode, rights = rights[0], rights[1:]
+	}
+	rights, err = ParseRights(rights)
+	if err != nil {
+		return err
+	}
+
+	if mode != 0 {
+		current := ""
+		if acl, err := s.repo.Get(mailboxID, identifier); err == nil {
+			current = acl.Rights
+		}
+		change := rights
+		if mode == '+' {
+			rights = filterRights(func(r rune) bool {
+				return strings.ContainsRune(current, r) || strings.ContainsRune(change, r)
+			})
+		} else {
+			rights = filterRights(func(r rune) bool {
+				return strings.ContainsRune(current, r) && !strings.ContainsRune(change, r)
+			})
+		}
+	}
+
+	if rights == "" {
+		return s.repo.Delete(mailboxID, identifier)
+	}
+	if err := s.repo.Set(&domain.MailboxACL{MailboxID: mailboxID, Identifier: identifier, Rights: rights}); err != nil {
+		return err
+	}
+
+	s.logger.Info("mailbox ACL set",
+		zap.Int64("mailbox_id", mailboxID),
+		zap.String("identifier", identifier),
+		zap.String("rights", rights),
+	)
+	return nil
+}
+
+// Delete removes an identifier's rights on a mailbox
+func (s *ACLService) Delete(mailboxID int64, identifier string) error {
+	mailbox, err := s.mailboxRepo.GetByID(mailboxID)
+	if err != nil {
+		return err
+	}
+	identifier, err = s.identifier(mailbox, identifier)
+	if err != nil {
+		return err
+	}
+	return s.repo.Delete(mailboxID, identifier)
+}
+
+// identifier normalizes an ACL identifier for a mailbox
+// Identifiers are "anyone" or the address of an existing user other than the owner.
+func (s *ACLService) identifier(mailbox *domain.Mailbox, identifier string) (string, error) {
+	if strings.EqualFold(identifier, domain.ACLAnyone) {
+		return domain.ACLAnyone, nil
+	}
+
+	identifier = NormalizeAddress(identifier)
+	user, err := s.userRepo.GetByEmail(identifier)
+	if err != nil || user == nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidACLIdentifier, identifier)
+	}
+	if user.ID == mailbox.UserID {
+		return "", ErrOwnerRights
+	}
+	return identifier, nil
+}
+
+// Shared returns the other users' mailboxes a user may look up
+func (s *ACLService) Shared(user *domain.User) ([]*SharedMailbox, error) {
+	granted := make(map[int64]string)
+	for _, identifier := range []string{NormalizeAddress(user.Email), domain.ACLAnyone} {
+		acls, err := s.repo.ListByIdentifier(identifier)
+		if err != nil {
+			return nil, err
+		}
+		for _, acl := range acls {
+			granted[acl.MailboxID] += acl.Rights
+		}
+	}
+
+	var shared []*SharedMailbox
+	owners := make(map[int64]*domain.User)
+	for mailboxID, rights := range granted {
+		if !strings.ContainsRune(rights, 'l') {
+			continue
+		}
+		mailbox, err := s.mailboxRepo.GetByID(mailboxID)
+		if err != nil || mailbox.UserID == user.ID {
+			continue
+		}
+		owner, ok := owners[mailbox.UserID]
+		if !ok {
+			if owner, err = s.userRepo.GetByID(mailbox.UserID); err != nil {
+				continue
+			}
+			owners[mailbox.UserID] = owner
+		}
+		shared = append(shared, &SharedMailbox{
+			Mailbox: mailbox,
+			Owner:   owner,
+			Rights:  filterRights(func(r rune) bool { return strings.ContainsRune(rights, r) }),
+		})
+	}
+
+	sort.Slice(shared, func(i, j int) bool {
+		if shared[i].Owner.Email != shared[j].Owner.Email {
+			return shared[i].Owner.Email < shared[j].Owner.Email
+		}
+		return shared[i].Mailbox.Name < shared[j].Mailbox.Name
+	})
+	return shared, nil
+}
+
+// SharedMailbox returns the owner's mailbox name if user may look it up or read it
+func (s *ACLService) SharedMailbox(user *domain.User, ownerEmail, name string) (*SharedMailbox, error) {
+	owner, err := s.userRepo.GetByEmail(NormalizeAddress(ownerEmail))
+	if err != nil || owner == nil {
+		return nil, ErrMailboxNotShared
+	}
+	mailbox, err := s.mailboxRepo.GetByName(owner.ID, name)
+	if err != nil {
+		return nil, ErrMailboxNotShared
+	}
+
+	rights, err := s.Rights(user, mailbox)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.ContainsAny(rights, "lr") {
+		return nil, ErrMailboxNotShared
+	}
+	return &SharedMailbox{Mailbox: mailbox, Owner: owner, Rights: rights}, nil
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+// mockMailboxACLRepository is an in-memory MailboxACLRepository
+type mockMailboxACLRepository struct {
+	acls []*domain.MailboxACL
+}
+
+func (m *mockMailboxACLRepository) Set(acl *domain.MailboxACL) error {
+	if existing, err := m.Get(acl.MailboxID, acl.Identifier); err == nil {
+		existing.Rights = acl.Rights
+		return nil
+	}
+	m.acls = append(m.acls, acl)
+	return nil
+}
+
+func (m *mockMailboxACLRepository) Get(mailboxID int64, identifier string) (*domain.MailboxACL, error) {
+	for _, acl := range m.acls {
+		if acl.MailboxID == mailboxID && acl.Identifier == identifier {
+			return acl, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+func (m *mockMailboxACLRepository) ListByMailbox(mailboxID int64) ([]*domain.MailboxACL, error) {
+	var acls []*domain.MailboxACL
+	for _, acl := range m.acls {
+		if acl.MailboxID == mailboxID {
+			acls = append(acls, acl)
+		}
+	}
+	return acls, nil
+}
+
+func (m *mockMailboxACLRepository) ListByIdentifier(identifier string) ([]*domain.MailboxACL, error) {
+	var acls []*domain.MailboxACL
+	for _, acl := range m.acls {
+		if acl.Identifier == identifier {
+			acls = append(acls, acl)
+		}
+	}
+	return acls, nil
+}
+
+func (m *mockMailboxACLRepository) Delete(mailboxID int64, identifier string) error {
+	for i, acl := range m.acls {
+		if acl.MailboxID == mailboxID && acl.Identifier == identifier {
+			m.acls = append(m.acls[:i], m.acls[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// mockMailboxRepository serves a fixed set of mailboxes
+type mockMailboxRepository struct {
+	mailboxes []*domain.Mailbox
+}
+
+func (m *mockMailboxRepository) Create(mailbox *domain.Mailbox) error { return nil }
+
+func (m *mockMailboxRepository) GetByID(id int64) (*domain.Mailbox, error) {
+	for _, mb := range m.mailboxes {
+		if mb.ID == id {
+			return mb, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+func (m *mockMailboxRepository) GetByUser(userID int64) ([]*domain.Mailbox, error) { return nil, nil }
+
+func (m *mockMailboxRepository) GetByName(userID int64, name string) (*domain.Mailbox, error) {
+	for _, mb := range m.mailboxes {
+		if mb.UserID == userID && mb.Name == name {
+			return mb, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+func (m *mockMailboxRepository) Update(mailbox *domain.Mailbox) error { return nil }
+func (m *mockMailboxRepository) Delete(id int64) error                { return nil }
+func (m *mockMailboxRepository) AllocateUID(id int64) (int64, error)  { return 1, nil }
+
+func TestParseRights(t *testing.T) {
+	tests := []struct {
+		rights string
+		want   string
+	}{
+		{"rl", "lr"},
+		{"lrswipkxtea", "lrswipkxtea"},
+		{"lrcd", "lrkxte"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got, err := ParseRights(tt.rights)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseRights(%q) = %q, %v; want %q", tt.rights, got, err, tt.want)
+		}
+	}
+
+	if _, err := ParseRights("lrz"); !errors.Is(err, ErrInvalidRights) {
+		t.Errorf("expected unknown right to be rejected, got %v", err)
+	}
+}
+
+func TestACLService(t *testing.T) {
+	users := map[string]*domain.User{
+		"owner@example.com": {ID: 1, Email: "owner@example.com"},
+		"other@example.com": {ID: 2, Email: "other@example.com"},
+	}
+	userRepo := &mockUserRepository{
+		getByEmailFunc: func(email string) (*domain.User, error) {
+			if u, ok := users[email]; ok {
+				return u, nil
+			}
+			return nil, errors.New("not found")
+		},
+		getByIDFunc: func(id int64) (*domain.User, error) {
+			for _, u := range users {
+				if u.ID == id {
+					return u, nil
+				}
+			}
+			return nil, errors.New("not found")
+		},
+	}
+	mailboxRepo := &mockMailboxRepository{mailboxes: []*domain.Mailbox{
+		{ID: 10, UserID: 1, Name: "INBOX"},
+		{ID: 11, UserID: 1, Name: "Team"},
+	}}
+	svc := NewACLService(&mockMailboxACLRepository{}, mailboxRepo, userRepo, zap.NewNop())
+	owner, other := users["owner@example.com"], users["other@example.com"]
+	team := mailboxRepo.mailboxes[1]
+
+	if rights, _ := svc.Rights(owner, team); rights != AllRights {
+		t.Errorf("expected owner to hold all rights, got %q", rights)
+	}
+	if rights, _ := svc.Rights(other, team); rights != "" {
+		t.Errorf("expected no rights before a grant, got %q", rights)
+	}
+
+	if err := svc.Set(team.ID, "Other@Example.com", "lr"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := svc.Set(team.ID, "other@example.com", "+si"); err != nil {
+		t.Fatalf("Set + failed: %v", err)
+	}
+	if err := svc.Set(team.ID, "anyone", "l"); err != nil {
+		t.Fatalf("Set anyone failed: %v", err)
+	}
+	if err := svc.Set(team.ID, "other@example.com", "-l"); err != nil {
+		t.Fatalf("Set - failed: %v", err)
+	}
+	if rights, _ := svc.Rights(other, team); rights != "lrsi" {
+		t.Errorf("expected own and anyone rights to combine to lrsi, got %q", rights)
+	}
+
+	shared, err := svc.Shared(other)
+	if err != nil || len(shared) != 1 || shared[0].Mailbox.ID != team.ID || shared[0].Owner.ID != owner.ID {
+		t.Fatalf("expected Team to be shared with other, got %+v, %v", shared, err)
+	}
+	if _, err := svc.SharedMailbox(other, "owner@example.com", "INBOX"); !errors.Is(err, ErrMailboxNotShared) {
+		t.Errorf("expected unshared INBOX to be hidden, got %v", err)
+	}
+
+	if err := svc.Set(team.ID, "owner@example.com", "l"); !errors.Is(err, ErrOwnerRights) {
+		t.Errorf("expected owner grant to be rejected, got %v", err)
+	}
+	if err := svc.Set(team.ID, "nobody@example.com", "l"); !errors.Is(err, ErrInvalidACLIdentifier) {
+		t.Errorf("expected unknown identifier to be rejected, got %v", err)
+	}
+
+	if err := svc.Set(team.ID, "anyone", ""); err != nil {
+		t.Fatalf("Set empty failed: %v", err)
+	}
+	if acls, _ := svc.List(team.ID); len(acls) != 1 || acls[0].Identifier != "other@example.com" {
+		t.Errorf("expected granting no rights to remove the entry, got %+v", acls)
+	}
+}
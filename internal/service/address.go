@@ -0,0 +1,41 @@
+package service
+
+import (
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeAddress returns the canonical form of an email address used for storage and lookup
+// Internationalized addresses (RFC 6531) are NFC-normalized and lowercased, and ACE-encoded
+// domains (xn--) are converted to Unicode so either spelling finds the same user.
+func NormalizeAddress(addr string) string {
+	addr = strings.ToLower(norm.NFC.String(strings.TrimSpace(addr)))
+
+	at := strings.LastIndex(addr, "@")
+	if at < 0 {
+		return addr
+	}
+	return addr[:at+1] + NormalizeDomain(addr[at+1:])
+}
+
+// NormalizeDomain returns the canonical Unicode form of a domain name
+// Names that are not valid IDNA are returned lowercased and otherwise unchanged.
+func NormalizeDomain(name string) string {
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+	if u, err := idna.Lookup.ToUnicode(name); err == nil {
+		return u
+	}
+	return name
+}
+
+// IsASCIIAddress reports whether an address can be transmitted without SMTPUTF8
+func IsASCIIAddress(addr string) bool {
+	for i := 0; i < len(addr); i++ {
+		if addr[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
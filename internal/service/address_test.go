@@ -0,0 +1,23 @@
+package service
+
+import "testing"
+
+func TestNormalizeAddress(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"User@Example.COM", "user@example.com"},
+		{" user@example.com. ", "user@example.com"},
+		{"user@xn--bcher-kva.example", "user@bücher.example"},
+		{"Jöse@Bücher.example", "jöse@bücher.example"},
+		{"jöse@example.com", "jöse@example.com"}, // decomposed umlaut becomes NFC
+		{"postmaster", "postmaster"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeAddress(tt.addr); got != tt.want {
+			t.Errorf("NormalizeAddress(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
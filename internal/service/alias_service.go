@@ -22,6 +22,7 @@ func NewAliasService(repo repository.AliasRepository) *AliasService {
 
 // Create creates a new alias
 func (s *AliasService) Create(ctx context.Context, alias *domain.Alias) error {
+	alias.AliasEmail = NormalizeAddress(alias.AliasEmail)
 	return s.repo.Create(alias)
 }
 
@@ -32,7 +33,7 @@ func (s *AliasService) GetByID(ctx context.Context, id int64) (*domain.Alias, er
 
 // GetByEmail retrieves an alias by email address
 func (s *AliasService) GetByEmail(ctx context.Context, email string) (*domain.Alias, error) {
-	return s.repo.GetByEmail(email)
+	return s.repo.GetByEmail(NormalizeAddress(email))
 }
 
 // ListAll retrieves all aliases
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+// mockAppPasswordRepository is a test double for AppPasswordRepository
+type mockAppPasswordRepository struct {
+	appPasswords []*domain.AppPassword
+	lastUsed     []int64
+}
+
+func (m *mockAppPasswordRepository) Create(appPassword *domain.AppPassword) error {
+	appPassword.ID = int64(len(m.appPasswords) + 1)
+	m.appPasswords = append(m.appPasswords, appPassword)
+	return nil
+}
+
+func (m *mockAppPasswordRepository) GetByID(id int64) (*domain.AppPassword, error) {
+	for _, p := range m.appPasswords {
+		if p.ID == id {
+			return p, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+func (m *mockAppPasswordRepository) GetByHash(passwordHash string) (*domain.AppPassword, error) {
+	for _, p := range m.appPasswords {
+		if p.PasswordHash == passwordHash {
+			return p, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+func (m *mockAppPasswordRepository) ListByUser(userID int64) ([]*domain.AppPassword, error) {
+	return m.appPasswords, nil
+}
+
+func (m *mockAppPasswordRepository) UpdateLastUsed(id int64) error {
+	m.lastUsed = append(m.lastUsed, id)
+	return nil
+}
+
+func (m *mockAppPasswordRepository) Delete(id int64) error { return nil }
+
+func TestUserService_AuthenticateClient(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("account-password"), bcrypt.MinCost)
+	user := &domain.User{ID: 1, Email: "user@example.com", PasswordHash: string(hash), Status: "active", SCRAMSHA256: "set"}
+	userRepo := &mockUserRepository{
+		getByEmailFunc: func(email string) (*domain.User, error) { return user, nil },
+		getByIDFunc:    func(id int64) (*domain.User, error) { return user, nil },
+	}
+
+	appRepo := &mockAppPasswordRepository{}
+	appPasswords := NewAppPasswordService(appRepo, userRepo, &mockDomainRepository{}, zap.NewNop())
+	userSvc := NewUserService(userRepo, &mockDomainRepository{}, zap.NewNop())
+	userSvc.SetAppPasswordService(appPasswords)
+
+	_, secret, err := appPasswords.Create(context.Background(), 1, "Phone", []string{domain.AppPasswordScopeIMAP})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		totp     bool
+		password string
+		scope    string
+		ok       bool
+	}{
+		{"account password", false, "account-password", domain.AppPasswordScopeIMAP, true},
+		{"app password", false, secret, domain.AppPasswordScopeIMAP, true},
+		{"app password typed without dashes", false, "  " + secret[:4] + secret[5:9] + secret[10:], domain.AppPasswordScopeIMAP, true},
+		{"app password outside its scope", false, secret, domain.AppPasswordScopeSMTP, false},
+		{"account password with TOTP", true, "account-password", domain.AppPasswordScopeIMAP, false},
+		{"app password with TOTP", true, secret, domain.AppPasswordScopeIMAP, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user.TOTPEnabled = tt.totp
+			_, err := userSvc.AuthenticateClient("user@example.com", tt.password, tt.scope)
+			if tt.ok && err != nil {
+				t.Fatalf("expected login to succeed, got %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("expected invalid credentials, got %v", err)
+			}
+		})
+	}
+
+	if len(appRepo.lastUsed) == 0 {
+		t.Error("expected app password use to be recorded")
+	}
+
+	// The web login never accepts app passwords
+	user.TOTPEnabled = false
+	if _, err := userSvc.Authenticate("user@example.com", secret); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected app password to be refused by Authenticate, got %v", err)
+	}
+}
+
+func TestAppPasswordService_Create(t *testing.T) {
+	userRepo := &mockUserRepository{
+		getByIDFunc: func(id int64) (*domain.User, error) { return &domain.User{ID: id}, nil },
+	}
+	svc := NewAppPasswordService(&mockAppPasswordRepository{}, userRepo, &mockDomainRepository{}, zap.NewNop())
+
+	if _, _, err := svc.Create(context.Background(), 1, " ", nil); !errors.Is(err, ErrInvalidAppPassword) {
+		t.Errorf("expected missing name to be rejected, got %v", err)
+	}
+	if _, _, err := svc.Create(context.Background(), 1, "Laptop", []string{"pop3"}); !errors.Is(err, ErrInvalidAppPassword) {
+		t.Errorf("expected unknown scope to be rejected, got %v", err)
+	}
+
+	appPassword, secret, err := svc.Create(context.Background(), 1, "Laptop", nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if len(secret) != 19 || appPassword.PasswordHash == secret {
+		t.Errorf("unexpected secret %q", secret)
+	}
+	if appPassword.Scopes != `["imap","smtp","dav"]` {
+		t.Errorf("expected all scopes by default, got %s", appPassword.Scopes)
+	}
+}
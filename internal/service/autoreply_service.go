@@ -0,0 +1,252 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/repository"
+	"github.com/btafoya/gomailserver/internal/security/dkim"
+)
+
+// DefaultAutoReplyInterval is how long a sender waits before the same user
+// auto-replies to them again (RFC 3834 section 2 recommends seven days)
+const DefaultAutoReplyInterval = 7 * 24 * time.Hour
+
+// AutoReplyService sends vacation replies to mail delivered to users with an
+// active auto reply, following RFC 3834
+type AutoReplyService struct {
+	repo         repository.AutoReplyRepository
+	domainRepo   repository.DomainRepository
+	queueService QueueServiceInterface
+	dkimSigner   *dkim.Signer
+	interval     time.Duration
+	logger       *zap.Logger
+}
+
+// NewAutoReplyService creates a new auto reply service
+func NewAutoReplyService(
+	repo repository.AutoReplyRepository,
+	domainRepo repository.DomainRepository,
+	queueService QueueServiceInterface,
+	dkimSigner *dkim.Signer,
+	logger *zap.Logger,
+) *AutoReplyService {
+	return &AutoReplyService{
+		repo:         repo,
+		domainRepo:   domainRepo,
+		queueService: queueService,
+		dkimSigner:   dkimSigner,
+		interval:     DefaultAutoReplyInterval,
+		logger:       logger,
+	}
+}
+
+// Respond answers a message delivered to user if their auto reply is active
+// recipient is the envelope recipient, which may be an alias of the user.
+// Messages from lists, bulk senders and other automated sources, and senders
+// answered within the reply interval, get no reply.
+func (s *AutoReplyService) Respond(user *domain.User, sender, recipient string, data []byte) error {
+	now := time.Now()
+	if !autoReplyActive(user, now) {
+		return nil
+	}
+
+	sender = NormalizeAddress(sender)
+	if reason := suppressedSender(sender, user); reason != "" {
+		s.logger.Debug("auto reply suppressed", zap.String("user", user.Email), zap.String("reason", reason))
+		return nil
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to parse message: %w", err)
+	}
+	if reason := suppressedMessage(msg.Header, user.Email, recipient); reason != "" {
+		s.logger.Debug("auto reply suppressed", zap.String("user", user.Email), zap.String("reason", reason))
+		return nil
+	}
+
+	last, err := s.repo.LastSent(user.ID, sender)
+	if err != nil {
+		return err
+	}
+	if !last.IsZero() && now.Sub(last) < s.interval {
+		return nil
+	}
+
+	reply := buildAutoReply(user, sender, msg.Header, now)
+
+	// Sign for the user's domain; the reply has a null envelope sender, so
+	// DKIM is what aligns it with the From domain
+	userDomain := domainPart(user.Email)
+	domainConfig, err := s.domainRepo.GetByName(userDomain)
+	if err == nil && domainConfig != nil && domainConfig.DKIMSigningEnabled && s.dkimSigner != nil {
+		signature, err := s.dkimSigner.Sign(userDomain, bytes.NewReader(reply))
+		if err != nil {
+			s.logger.Error("DKIM signing failed", zap.Error(err), zap.String("from", user.Email))
+		} else if signature != "" {
+			reply = append([]byte(signature), reply...)
+		}
+	}
+
+	// Auto replies are sent with a null return path (RFC 3834 section 3.3)
+	if _, err := s.queueService.Enqueue("", []string{sender}, reply); err != nil {
+		return fmt.Errorf("failed to queue auto reply: %w", err)
+	}
+	if err := s.repo.RecordSent(user.ID, sender, now); err != nil {
+		return err
+	}
+	if err := s.repo.DeleteOlderThan(s.interval); err != nil {
+		s.logger.Warn("failed to prune auto reply history", zap.Error(err))
+	}
+
+	s.logger.Info("auto reply sent",
+		zap.String("user", user.Email),
+		zap.String("to", sender),
+	)
+	return nil
+}
+
+// autoReplyActive reports whether a user's auto reply is enabled at t
+func autoReplyActive(user *domain.User, t time.Time) bool {
+	if !user.AutoReplyEnabled {
+		return false
+	}
+	if user.AutoReplyStart != nil && t.Before(*user.AutoReplyStart) {
+		return false
+	}
+	if user.AutoReplyEnd != nil && t.After(*user.AutoReplyEnd) {
+		return false
+	}
+	return true
+}
+
+// suppressedSender returns why a sender must not be answered, if it must not
+func suppressedSender(sender string, user *domain.User) string {
+	if sender == "" {
+		return "null sender"
+	}
+	if sender == NormalizeAddress(user.Email) {
+		return "own address"
+	}
+
+	local := sender
+	if at := strings.LastIndex(sender, "@"); at >= 0 {
+		local = sender[:at]
+	}
+	switch {
+	case local == "mailer-daemon", local == "postmaster", local == "listserv", local == "majordomo",
+		local == "noreply", local == "no-reply", local == "donotreply", local == "do-not-reply":
+		return "automated sender"
+	case strings.HasPrefix(local, "owner-"), strings.HasSuffix(local, "-request"), strings.HasSuffix(local, "-bounces"):
+		return "list sender"
+	}
+	return ""
+}
+
+// suppressedMessage returns why a message must not be answered, if it must not
+// Replies go only to personal mail addressed to the user (RFC 3834 section 2).
+func suppressedMessage(h mail.Header, addresses ...string) string {
+	if v := headerToken(h.Get("Auto-Submitted")); v != "" && v != "no" {
+		return "auto-submitted"
+	}
+	switch headerToken(h.Get("Precedence")) {
+	case "bulk", "list", "junk":
+		return "bulk precedence"
+	}
+	for _, name := range []string{"List-Id", "List-Post", "List-Unsubscribe"} {
+		if h.Get(name) != "" {
+			return "mailing list"
+		}
+	}
+	suppress := strings.ToLower(h.Get("X-Auto-Response-Suppress"))
+	if strings.Contains(suppress, "all") || strings.Contains(suppress, "oof") || strings.Contains(suppress, "autoreply") {
+		return "auto responses suppressed"
+	}
+	if strings.EqualFold(h.Get("X-Spam"), "yes") || strings.EqualFold(h.Get("X-Spam-Flag"), "yes") {
+		return "spam"
+	}
+
+	for _, name := range []string{"To", "Cc", "Bcc", "Resent-To", "Resent-Cc", "Resent-Bcc"} {
+		list, err := h.AddressList(name)
+		if err != nil {
+			continue
+		}
+		for _, addr := range list {
+			for _, own := range addresses {
+				if NormalizeAddress(addr.Address) == NormalizeAddress(own) {
+					return ""
+				}
+			}
+		}
+	}
+	return "not addressed to user"
+}
+
+// headerToken returns the lowercased keyword of a field, without parameters or comments
+func headerToken(v string) string {
+	v, _, _ = strings.Cut(v, ";")
+	v, _, _ = strings.Cut(v, "(")
+	return strings.ToLower(strings.TrimSpace(v))
+}
+
+// buildAutoReply formats the reply to a message (RFC 3834 section 3)
+func buildAutoReply(user *domain.User, sender string, original mail.Header, now time.Time) []byte {
+	subject := user.AutoReplySubject
+	if subject == "" {
+		orig, err := new(mime.WordDecoder).DecodeHeader(original.Get("Subject"))
+		if err != nil {
+			orig = original.Get("Subject")
+		}
+		subject = "Auto: " + orig
+	}
+
+	name := user.DisplayName
+	if name == "" {
+		name = user.FullName
+	}
+	from := &mail.Address{Name: name, Address: user.Email}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from.String())
+	fmt.Fprintf(&b, "To: <%s>\r\n", sender)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: %s\r\n", NewMessageID(domainPart(user.Email)))
+
+	// Thread the reply under the original message (RFC 3834 section 3.1.4)
+	if msgID := strings.TrimSpace(original.Get("Message-Id")); msgID != "" {
+		references := strings.TrimSpace(original.Get("References"))
+		if references == "" {
+			references = strings.TrimSpace(original.Get("In-Reply-To"))
+		}
+		if references != "" {
+			references += " "
+		}
+		fmt.Fprintf(&b, "In-Reply-To: %s\r\n", msgID)
+		fmt.Fprintf(&b, "References: %s%s\r\n", references, msgID)
+	}
+
+	b.WriteString("Auto-Submitted: auto-replied\r\n")
+	b.WriteString("X-Auto-Response-Suppress: All\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	b.WriteString("\r\n")
+
+	body := strings.ReplaceAll(strings.ReplaceAll(user.AutoReplyBody, "\r\n", "\n"), "\n", "\r\n")
+	qp := quotedprintable.NewWriter(&b)
+	qp.Write([]byte(body))
+	qp.Close()
+	b.WriteString("\r\n")
+
+	return b.Bytes()
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+// mockAutoReplyRepository keeps reply history in memory
+type mockAutoReplyRepository struct {
+	sent map[string]time.Time
+}
+
+func (m *mockAutoReplyRepository) LastSent(userID int64, sender string) (time.Time, error) {
+	return m.sent[sender], nil
+}
+
+func (m *mockAutoReplyRepository) RecordSent(userID int64, sender string, sentAt time.Time) error {
+	m.sent[sender] = sentAt
+	return nil
+}
+
+func (m *mockAutoReplyRepository) DeleteOlderThan(age time.Duration) error { return nil }
+
+func newTestAutoReplyService(t *testing.T, queued *[]*domain.QueueItem) (*AutoReplyService, *mockAutoReplyRepository) {
+	queueRepo := &mockQueueRepository{
+		enqueueFunc: func(item *domain.QueueItem) error {
+			*queued = append(*queued, item)
+			return nil
+		},
+	}
+	queueSvc := NewQueueServiceWithPath(queueRepo, nil, zap.NewNop(), t.TempDir())
+	repo := &mockAutoReplyRepository{sent: map[string]time.Time{}}
+	return NewAutoReplyService(repo, &mockDomainRepository{}, queueSvc, nil, zap.NewNop()), repo
+}
+
+func vacationUser() *domain.User {
+	return &domain.User{
+		ID:               1,
+		Email:            "user@example.com",
+		DisplayName:      "Test User",
+		AutoReplyEnabled: true,
+		AutoReplyBody:    "I am away until Monday.\nRegards",
+	}
+}
+
+const personalMessage = "From: Friend <friend@example.net>\r\n" +
+	"To: user@example.com\r\n" +
+	"Subject: =?utf-8?q?Caf=C3=A9?=\r\n" +
+	"Message-ID: <msg2@example.net>\r\n" +
+	"References: <msg1@example.net>\r\n" +
+	"\r\n" +
+	"Hello\r\n"
+
+func TestAutoReplyService_Respond(t *testing.T) {
+	var queued []*domain.QueueItem
+	svc, repo := newTestAutoReplyService(t, &queued)
+	user := vacationUser()
+
+	if err := svc.Respond(user, "Friend@Example.net", "user@example.com", []byte(personalMessage)); err != nil {
+		t.Fatalf("Respond failed: %v", err)
+	}
+	if len(queued) != 1 {
+		t.Fatalf("expected one reply, got %d", len(queued))
+	}
+	item := queued[0]
+	if item.Sender != "" || item.Recipients != `["friend@example.net"]` {
+		t.Errorf("expected null sender reply to friend@example.net, got %q -> %s", item.Sender, item.Recipients)
+	}
+
+	data, err := os.ReadFile(item.MessagePath)
+	if err != nil {
+		t.Fatalf("failed to read reply: %v", err)
+	}
+	reply := string(data)
+	for _, want := range []string{
+		"From: \"Test User\" <user@example.com>\r\n",
+		"To: <friend@example.net>\r\n",
+		"Subject: =?utf-8?q?Auto:_Caf=C3=A9?=\r\n",
+		"In-Reply-To: <msg2@example.net>\r\n",
+		"References: <msg1@example.net> <msg2@example.net>\r\n",
+		"Auto-Submitted: auto-replied\r\n",
+		"\r\n\r\nI am away until Monday.\r\nRegards\r\n",
+	} {
+		if !strings.Contains(reply, want) {
+			t.Errorf("expected reply to contain %q, got:\n%s", want, reply)
+		}
+	}
+
+	// The same sender is not answered again within the interval
+	if err := svc.Respond(user, "friend@example.net", "user@example.com", []byte(personalMessage)); err != nil {
+		t.Fatalf("Respond failed: %v", err)
+	}
+	if len(queued) != 1 {
+		t.Errorf("expected duplicate reply to be suppressed, got %d replies", len(queued))
+	}
+
+	// but is once the interval has passed
+	repo.sent["friend@example.net"] = time.Now().Add(-DefaultAutoReplyInterval - time.Hour)
+	svc.Respond(user, "friend@example.net", "user@example.com", []byte(personalMessage))
+	if len(queued) != 2 {
+		t.Errorf("expected reply after the interval, got %d replies", len(queued))
+	}
+}
+
+func TestAutoReplyService_Suppressed(t *testing.T) {
+	future := time.Now().Add(24 * time.Hour)
+	past := time.Now().Add(-24 * time.Hour)
+
+	tests := []struct {
+		name    string
+		sender  string
+		message string
+		modify  func(*domain.User)
+	}{
+		{"disabled", "friend@example.net", personalMessage, func(u *domain.User) { u.AutoReplyEnabled = false }},
+		{"before start", "friend@example.net", personalMessage, func(u *domain.User) { u.AutoReplyStart = &future }},
+		{"after end", "friend@example.net", personalMessage, func(u *domain.User) { u.AutoReplyEnd = &past }},
+		{"null sender", "", personalMessage, nil},
+		{"mailer daemon", "MAILER-DAEMON@example.net", personalMessage, nil},
+		{"list owner", "owner-users@lists.example.net", personalMessage, nil},
+		{"own address", "user@example.com", personalMessage, nil},
+		{"auto-submitted", "friend@example.net", "Auto-Submitted: auto-generated\r\n" + personalMessage, nil},
+		{"bulk", "friend@example.net", "Precedence: bulk\r\n" + personalMessage, nil},
+		{"list", "friend@example.net", "List-Id: <users.lists.example.net>\r\n" + personalMessage, nil},
+		{"spam", "friend@example.net", "X-Spam: Yes\r\n" + personalMessage, nil},
+		{"not addressed", "friend@example.net", strings.Replace(personalMessage, "To: user@example.com", "To: other@example.com", 1), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var queued []*domain.QueueItem
+			svc, _ := newTestAutoReplyService(t, &queued)
+			user := vacationUser()
+			if tt.modify != nil {
+				tt.modify(user)
+			}
+
+			if err := svc.Respond(user, tt.sender, "user@example.com", []byte(tt.message)); err != nil {
+				t.Fatalf("Respond failed: %v", err)
+			}
+			if len(queued) != 0 {
+				t.Errorf("expected no reply, got %d", len(queued))
+			}
+		})
+	}
+
+	t.Run("explicit auto-submitted no", func(t *testing.T) {
+		var queued []*domain.QueueItem
+		svc, _ := newTestAutoReplyService(t, &queued)
+		msg := "Auto-Submitted: no\r\n" + strings.Replace(personalMessage, "To: user@example.com", "To: sales@example.com", 1)
+
+		// Mail to an alias of the user is answered
+		if err := svc.Respond(vacationUser(), "friend@example.net", "sales@example.com", []byte(msg)); err != nil {
+			t.Fatalf("Respond failed: %v", err)
+		}
+		if len(queued) != 1 {
+			t.Errorf("expected a reply, got %d", len(queued))
+		}
+	})
+}
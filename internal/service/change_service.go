@@ -0,0 +1,305 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/repository"
+)
+
+const (
+	// ChangeRetention is how long changes are kept for delta synchronization
+	ChangeRetention = 30 * 24 * time.Hour
+
+	// DefaultChangePruneInterval is how often changes older than ChangeRetention are pruned
+	DefaultChangePruneInterval = 6 * time.Hour
+)
+
+// ErrCannotCalculateChanges is returned for states that are unknown or older than the pruned log
+var ErrCannotCalculateChanges = errors.New("cannot calculate changes from this state")
+
+// ChangeSet lists the objects of one type changed since a state (RFC 8620 section 5.2)
+type ChangeSet struct {
+	OldState  string
+	NewState  string
+	HasMore   bool
+	Created   []string
+	Updated   []string
+	Destroyed []string
+}
+
+// ChangeService keeps the change log behind JMAP state strings
+// Message and mailbox services report what they change; the state of a type
+// is the ID of its latest change, and /changes replays the log from a state.
+// Recording is best effort: a failure is logged and never fails the caller.
+type ChangeService struct {
+	repo        repository.ChangeRepository
+	messageRepo repository.MessageRepository
+	logger      *zap.Logger
+
+	mu          sync.Mutex
+	subscribers map[int64]map[chan struct{}]struct{}
+}
+
+// NewChangeService creates a new change service
+func NewChangeService(repo repository.ChangeRepository, messageRepo repository.MessageRepository, logger *zap.Logger) *ChangeService {
+	return &ChangeService{
+		repo:        repo,
+		messageRepo: messageRepo,
+		logger:      logger,
+		subscribers: make(map[int64]map[chan struct{}]struct{}),
+	}
+}
+
+// record appends one change to the log
+func (s *ChangeService) record(userID int64, changeType, objectID, kind string) {
+	change := &domain.Change{UserID: userID, Type: changeType, ObjectID: objectID, Kind: kind}
+	if err := s.repo.Record(change); err != nil {
+		s.logger.Error("failed to record change",
+			zap.Error(err),
+			zap.Int64("user_id", userID),
+			zap.String("type", changeType),
+			zap.String("object_id", objectID),
+		)
+	}
+}
+
+// recordThread records a thread change after a message joined or left it
+// The thread was created when the message is its only one and destroyed when none remain.
+func (s *ChangeService) recordThread(userID int64, threadID string) {
+	if threadID == "" {
+		return
+	}
+	size, err := s.repo.ThreadSize(userID, threadID)
+	if err != nil {
+		s.logger.Error("failed to count thread messages", zap.Error(err), zap.Int64("user_id", userID))
+		size = 2
+	}
+	s.record(userID, domain.ChangeTypeThread, threadID, threadChangeKind(size))
+}
+
+// threadChangeKind returns the kind of thread change given the number of messages left in it
+func threadChangeKind(size int) string {
+	switch size {
+	case 0:
+		return domain.ChangeDestroyed
+	case 1:
+		return domain.ChangeCreated
+	default:
+		return domain.ChangeUpdated
+	}
+}
+
+// recordMailboxes records a count change for each distinct mailbox
+func (s *ChangeService) recordMailboxes(userID int64, mailboxIDs ...int64) {
+	seen := make(map[int64]bool, len(mailboxIDs))
+	for _, id := range mailboxIDs {
+		if id == 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		s.record(userID, domain.ChangeTypeMailbox, formatID(id), domain.ChangeUpdated)
+	}
+}
+
+// MessageCreated records a stored message
+func (s *ChangeService) MessageCreated(msg *domain.Message) {
+	s.record(msg.UserID, domain.ChangeTypeEmail, formatID(msg.ID), domain.ChangeCreated)
+	s.recordThread(msg.UserID, msg.ThreadID)
+	s.recordMailboxes(msg.UserID, msg.MailboxID)
+	s.notify(msg.UserID)
+}
+
+// MessageUpdated records a change to a message's flags or mailbox
+// mailboxIDs are the mailboxes whose counts changed.
+func (s *ChangeService) MessageUpdated(msg *domain.Message, mailboxIDs ...int64) {
+	s.record(msg.UserID, domain.ChangeTypeEmail, formatID(msg.ID), domain.ChangeUpdated)
+	s.recordMailboxes(msg.UserID, mailboxIDs...)
+	s.notify(msg.UserID)
+}
+
+// MessageDestroyed records a deleted message
+func (s *ChangeService) MessageDestroyed(msg *domain.Message) {
+	s.record(msg.UserID, domain.ChangeTypeEmail, formatID(msg.ID), domain.ChangeDestroyed)
+	s.recordThread(msg.UserID, msg.ThreadID)
+	s.recordMailboxes(msg.UserID, msg.MailboxID)
+	s.notify(msg.UserID)
+}
+
+// MailboxChanged records a created or updated mailbox
+func (s *ChangeService) MailboxChanged(userID, mailboxID int64, kind string) {
+	s.record(userID, domain.ChangeTypeMailbox, formatID(mailboxID), kind)
+	s.notify(userID)
+}
+
+// DestroyMailbox runs destroy and records the mailbox and the messages it took with it
+func (s *ChangeService) DestroyMailbox(mailbox *domain.Mailbox, destroy func() error) error {
+	messages, err := s.messageRepo.ListByMailbox(mailbox.ID)
+	if err != nil {
+		s.logger.Error("failed to list messages of destroyed mailbox", zap.Error(err), zap.Int64("mailbox_id", mailbox.ID))
+	}
+
+	if err := destroy(); err != nil {
+		return err
+	}
+
+	threads := make(map[string]bool)
+	for _, msg := range messages {
+		s.record(mailbox.UserID, domain.ChangeTypeEmail, formatID(msg.ID), domain.ChangeDestroyed)
+		threads[msg.ThreadID] = true
+	}
+	for threadID := range threads {
+		s.recordThread(mailbox.UserID, threadID)
+	}
+	s.record(mailbox.UserID, domain.ChangeTypeMailbox, formatID(mailbox.ID), domain.ChangeDestroyed)
+	s.notify(mailbox.UserID)
+	return nil
+}
+
+// SubmissionChanged records a change to an email submission
+func (s *ChangeService) SubmissionChanged(userID, submissionID int64, kind string) {
+	s.record(userID, domain.ChangeTypeEmailSubmission, formatID(submissionID), kind)
+	s.notify(userID)
+}
+
+// State returns the current state string of a user's objects of a type
+func (s *ChangeService) State(userID int64, changeType string) (string, error) {
+	state, err := s.repo.State(userID, changeType)
+	if err != nil {
+		return "", err
+	}
+	return formatID(state), nil
+}
+
+// Changes returns the objects of a type changed since a state, at most maxChanges of them
+// An object created and then destroyed within the range is left out, and
+// one changed several times is reported once.
+func (s *ChangeService) Changes(userID int64, changeType, sinceState string, maxChanges int) (*ChangeSet, error) {
+	since, err := strconv.ParseInt(sinceState, 10, 64)
+	if err != nil || since < 0 {
+		return nil, ErrCannotCalculateChanges
+	}
+	state, err := s.repo.State(userID, changeType)
+	if err != nil {
+		return nil, err
+	}
+	horizon, err := s.repo.Horizon(userID, changeType)
+	if err != nil {
+		return nil, err
+	}
+	if since > state || since < horizon {
+		return nil, ErrCannotCalculateChanges
+	}
+
+	changes, err := s.repo.Since(userID, changeType, since, maxChanges+1)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &ChangeSet{OldState: sinceState, NewState: formatID(state)}
+	if len(changes) > maxChanges {
+		changes = changes[:maxChanges]
+		set.HasMore = true
+		set.NewState = formatID(changes[len(changes)-1].ID)
+	} else if len(changes) > 0 && changes[len(changes)-1].ID > state {
+		// Changes recorded after the state was read
+		set.NewState = formatID(changes[len(changes)-1].ID)
+	}
+
+	// Compare whether each object existed before the range and after it
+	type span struct{ first, last string }
+	spans := make(map[string]*span)
+	var order []string
+	for _, change := range changes {
+		sp, ok := spans[change.ObjectID]
+		if !ok {
+			sp = &span{first: change.Kind}
+			spans[change.ObjectID] = sp
+			order = append(order, change.ObjectID)
+		}
+		sp.last = change.Kind
+	}
+
+	set.Created = make([]string, 0)
+	set.Updated = make([]string, 0)
+	set.Destroyed = make([]string, 0)
+	for _, id := range order {
+		sp := spans[id]
+		existed := sp.first != domain.ChangeCreated
+		exists := sp.last != domain.ChangeDestroyed
+		switch {
+		case existed && exists:
+			set.Updated = append(set.Updated, id)
+		case exists:
+			set.Created = append(set.Created, id)
+		case existed:
+			set.Destroyed = append(set.Destroyed, id)
+		}
+	}
+	return set, nil
+}
+
+// Subscribe returns a channel signalled after a user's objects change, and a
+// function that stops the subscription
+func (s *ChangeService) Subscribe(userID int64) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	s.mu.Lock()
+	if s.subscribers[userID] == nil {
+		s.subscribers[userID] = make(map[chan struct{}]struct{})
+	}
+	s.subscribers[userID][ch] = struct{}{}
+	s.mu.Unlock()
+
+	return ch, func() {
+		s.mu.Lock()
+		delete(s.subscribers[userID], ch)
+		if len(s.subscribers[userID]) == 0 {
+			delete(s.subscribers, userID)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// notify signals a user's subscribers without blocking
+func (s *ChangeService) notify(userID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subscribers[userID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// RunPruner prunes changes older than ChangeRetention every interval until ctx is cancelled
+func (s *ChangeService) RunPruner(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if pruned, err := s.repo.Prune(time.Now().Add(-ChangeRetention)); err != nil {
+			s.logger.Error("change log pruning failed", zap.Error(err))
+		} else {
+			s.logger.Debug("change log pruning complete", zap.Int64("pruned", pruned))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// formatID formats a database ID as a change log object ID or state
+func formatID(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+// mockChangeRepository is an in-memory ChangeRepository
+type mockChangeRepository struct {
+	changes  []*domain.Change
+	horizons map[string]int64
+	threads  map[string]int
+}
+
+func (m *mockChangeRepository) Record(change *domain.Change) error {
+	change.ID = int64(len(m.changes) + 1)
+	change.CreatedAt = time.Now()
+	m.changes = append(m.changes, change)
+	return nil
+}
+
+func (m *mockChangeRepository) Since(userID int64, changeType string, sinceID int64, limit int) ([]*domain.Change, error) {
+	var result []*domain.Change
+	for _, c := range m.changes {
+		if c.UserID == userID && c.Type == changeType && c.ID > sinceID && len(result) < limit {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockChangeRepository) State(userID int64, changeType string) (int64, error) {
+	state := m.horizons[changeType]
+	for _, c := range m.changes {
+		if c.UserID == userID && c.Type == changeType && c.ID > state {
+			state = c.ID
+		}
+	}
+	return state, nil
+}
+
+func (m *mockChangeRepository) Horizon(userID int64, changeType string) (int64, error) {
+	return m.horizons[changeType], nil
+}
+
+func (m *mockChangeRepository) Prune(before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (m *mockChangeRepository) ThreadSize(userID int64, threadID string) (int, error) {
+	return m.threads[threadID], nil
+}
+
+func TestChangeService_Changes(t *testing.T) {
+	repo := &mockChangeRepository{horizons: map[string]int64{}, threads: map[string]int{}}
+	svc := NewChangeService(repo, &mockMessageRepository{}, zap.NewNop())
+
+	msg := func(id int64) *domain.Message {
+		return &domain.Message{ID: id, UserID: 1, MailboxID: 5, ThreadID: "t"}
+	}
+	svc.MessageCreated(msg(1))
+	start, err := svc.State(1, domain.ChangeTypeEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc.MessageCreated(msg(2))
+	svc.MessageUpdated(msg(2), 5)
+	svc.MessageUpdated(msg(1), 5)
+	svc.MessageCreated(msg(3))
+	svc.MessageDestroyed(msg(3))
+	svc.MessageDestroyed(msg(1))
+
+	set, err := svc.Changes(1, domain.ChangeTypeEmail, start, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Created) != 1 || set.Created[0] != "2" {
+		t.Errorf("expected email 2 created, got %v", set.Created)
+	}
+	if len(set.Updated) != 0 {
+		t.Errorf("expected no updates, got %v", set.Updated)
+	}
+	if len(set.Destroyed) != 1 || set.Destroyed[0] != "1" {
+		t.Errorf("expected email 1 destroyed, got %v", set.Destroyed)
+	}
+	if set.HasMore {
+		t.Error("expected no more changes")
+	}
+	if state, _ := svc.State(1, domain.ChangeTypeEmail); set.NewState != state {
+		t.Errorf("new state %s, want %s", set.NewState, state)
+	}
+
+	// Mailbox counts changed with every message change
+	mailboxes, err := svc.Changes(1, domain.ChangeTypeMailbox, "0", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mailboxes.Updated) != 1 || mailboxes.Updated[0] != "5" {
+		t.Errorf("expected mailbox 5 updated, got %+v", mailboxes)
+	}
+}
+
+func TestChangeService_ChangesPaging(t *testing.T) {
+	repo := &mockChangeRepository{horizons: map[string]int64{}, threads: map[string]int{}}
+	svc := NewChangeService(repo, &mockMessageRepository{}, zap.NewNop())
+
+	for id := int64(1); id <= 3; id++ {
+		svc.SubmissionChanged(1, id, domain.ChangeCreated)
+	}
+
+	set, err := svc.Changes(1, domain.ChangeTypeEmailSubmission, "0", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !set.HasMore || len(set.Created) != 2 {
+		t.Fatalf("expected two changes and more to come, got %+v", set)
+	}
+
+	rest, err := svc.Changes(1, domain.ChangeTypeEmailSubmission, set.NewState, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rest.HasMore || len(rest.Created) != 1 || rest.Created[0] != "3" {
+		t.Errorf("expected the last change, got %+v", rest)
+	}
+}
+
+func TestChangeService_CannotCalculate(t *testing.T) {
+	repo := &mockChangeRepository{horizons: map[string]int64{domain.ChangeTypeMailbox: 4}, threads: map[string]int{}}
+	svc := NewChangeService(repo, &mockMessageRepository{}, zap.NewNop())
+	svc.MailboxChanged(1, 9, domain.ChangeCreated)
+
+	for _, since := range []string{"2", "99", "x", ""} {
+		if _, err := svc.Changes(1, domain.ChangeTypeMailbox, since, 10); !errors.Is(err, ErrCannotCalculateChanges) {
+			t.Errorf("since %q: expected ErrCannotCalculateChanges, got %v", since, err)
+		}
+	}
+	if _, err := svc.Changes(1, domain.ChangeTypeMailbox, "4", 10); err != nil {
+		t.Errorf("changes from the horizon: %v", err)
+	}
+}
+
+func TestChangeService_ThreadChanges(t *testing.T) {
+	repo := &mockChangeRepository{horizons: map[string]int64{}, threads: map[string]int{}}
+	svc := NewChangeService(repo, &mockMessageRepository{}, zap.NewNop())
+
+	first := &domain.Message{ID: 1, UserID: 1, MailboxID: 5, ThreadID: "t"}
+	second := &domain.Message{ID: 2, UserID: 1, MailboxID: 5, ThreadID: "t"}
+
+	repo.threads["t"] = 1
+	svc.MessageCreated(first)
+	start, _ := svc.State(1, domain.ChangeTypeThread)
+	repo.threads["t"] = 2
+	svc.MessageCreated(second)
+
+	set, err := svc.Changes(1, domain.ChangeTypeThread, start, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Updated) != 1 || set.Updated[0] != "t" {
+		t.Errorf("expected thread t updated, got %+v", set)
+	}
+
+	repo.threads["t"] = 0
+	svc.MessageDestroyed(first)
+	set, err = svc.Changes(1, domain.ChangeTypeThread, "0", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Created) != 0 || len(set.Destroyed) != 0 || len(set.Updated) != 0 {
+		t.Errorf("a thread created and destroyed since state 0 should not be reported, got %+v", set)
+	}
+}
+
+func TestChangeService_Subscribe(t *testing.T) {
+	repo := &mockChangeRepository{horizons: map[string]int64{}, threads: map[string]int{}}
+	svc := NewChangeService(repo, &mockMessageRepository{}, zap.NewNop())
+
+	notifications, unsubscribe := svc.Subscribe(1)
+	svc.MailboxChanged(2, 7, domain.ChangeCreated)
+	select {
+	case <-notifications:
+		t.Fatal("notified of another user's change")
+	default:
+	}
+
+	svc.MailboxChanged(1, 7, domain.ChangeCreated)
+	select {
+	case <-notifications:
+	case <-time.After(time.Second):
+		t.Fatal("expected a notification")
+	}
+
+	unsubscribe()
+	svc.MailboxChanged(1, 7, domain.ChangeUpdated)
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+// maxReturnedContent is the largest original message returned whole in a
+// delivery report; larger messages are returned as headers only
+const maxReturnedContent = 10 << 20
+
+// enhancedStatusCode matches an RFC 3463 status code at the start of a reply
+var enhancedStatusCode = regexp.MustCompile(`^[245]\.[0-9]{1,3}\.[0-9]{1,3}$`)
+
+// Bounce sends a queue item's sender a non-delivery report for the recipients
+// that failed permanently (RFC 3464)
+func (s *QueueService) Bounce(item *domain.QueueItem, data []byte, failed []domain.RecipientStatus) error {
+	return s.report(item, data, domain.RecipientFailed, failed)
+}
+
+// ReportDelay warns a queue item's sender that delivery to recipients is still being retried
+func (s *QueueService) ReportDelay(item *domain.QueueItem, data []byte, deferred []domain.RecipientStatus) error {
+	return s.report(item, data, domain.RecipientDeferred, deferred)
+}
+
+// ReportSuccess tells a queue item's sender that recipients were delivered or relayed
+func (s *QueueService) ReportSuccess(item *domain.QueueItem, data []byte, delivered []domain.RecipientStatus) error {
+	return s.report(item, data, domain.RecipientDelivered, delivered)
+}
+
+// report sends a delivery status notification for the recipients whose NOTIFY
+// parameter asks for the outcome kind. Mail with a null sender never gets
+// one, so reports cannot loop.
+func (s *QueueService) report(item *domain.QueueItem, data []byte, kind string, recipients []domain.RecipientStatus) error {
+	if item.Sender == "" || len(recipients) == 0 {
+		return nil
+	}
+
+	dsn, err := DecodeRecipientDSN(item.DSNRecipients)
+	if err != nil {
+		s.logger.Warn("ignoring invalid DSN parameters", zap.Int64("queue_id", item.ID), zap.Error(err))
+	}
+	var notify []domain.RecipientStatus
+	for _, r := range recipients {
+		if notifies(dsn[r.Recipient], kind) {
+			notify = append(notify, r)
+		}
+	}
+	if len(notify) == 0 {
+		return nil
+	}
+
+	report, opts := s.buildDeliveryReport(item, data, kind, notify, time.Now())
+	if _, err := s.enqueueData("", []string{item.Sender}, report, opts); err != nil {
+		return fmt.Errorf("failed to queue delivery report: %w", err)
+	}
+
+	s.logger.Info("delivery report queued",
+		zap.Int64("queue_id", item.ID),
+		zap.String("action", reportAction(kind)),
+		zap.String("to", item.Sender),
+		zap.Int("recipients", len(notify)),
+	)
+	return nil
+}
+
+// notifies reports whether a recipient's NOTIFY parameter asks for a report of
+// the outcome kind. Without NOTIFY only failures are reported (RFC 3461
+// section 4.1); NOTIFY=NEVER turns off every report.
+func notifies(p domain.RecipientDSN, kind string) bool {
+	if len(p.Notify) == 0 {
+		return kind == domain.RecipientFailed
+	}
+
+	want := "FAILURE"
+	switch kind {
+	case domain.RecipientDelivered:
+		want = "SUCCESS"
+	case domain.RecipientDeferred:
+		want = "DELAY"
+	}
+	for _, n := range p.Notify {
+		if strings.EqualFold(n, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildDeliveryReport formats a multipart/report delivery status notification
+// for recipients that all share the outcome kind (RFC 3464 section 2)
+func (s *QueueService) buildDeliveryReport(item *domain.QueueItem, data []byte, kind string, recipients []domain.RecipientStatus, now time.Time) ([]byte, *EnqueueOptions) {
+	hostname := s.hostname
+	if hostname == "" {
+		hostname = "localhost"
+	}
+
+	var subject, intro string
+	switch kind {
+	case domain.RecipientDelivered:
+		subject = "Successful Mail Delivery Report"
+		intro = "Your message was delivered to the following recipients. Recipients\r\nmarked as relayed were handed to a mail system that does not confirm\r\ndelivery."
+	case domain.RecipientDeferred:
+		subject = "Delayed Mail (still being retried)"
+		intro = "Delivery to the following recipients has been delayed. The mail system\r\nwill keep trying; you do not need to resend the message."
+	default:
+		subject = "Undelivered Mail Returned to Sender"
+		intro = "Your message could not be delivered to one or more recipients."
+	}
+
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+
+	fmt.Fprintf(&b, "From: Mail Delivery System <MAILER-DAEMON@%s>\r\n", hostname)
+	fmt.Fprintf(&b, "To: <%s>\r\n", item.Sender)
+	fmt.Fprintf(&b, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: %s\r\n", NewMessageID(hostname))
+	b.WriteString("Auto-Submitted: auto-replied\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/report; report-type=delivery-status;\r\n\tboundary=\"%s\"\r\n", mw.Boundary())
+	b.WriteString("\r\n")
+
+	// Human-readable explanation
+	part, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	fmt.Fprintf(part, "This is the mail system at host %s.\r\n\r\n%s\r\n\r\n", hostname, intro)
+	for _, r := range recipients {
+		if r.Relayed {
+			fmt.Fprintf(part, "<%s>: relayed\r\n", r.Recipient)
+			continue
+		}
+		fmt.Fprintf(part, "<%s>: %s\r\n", r.Recipient, reportDiagnostic(r))
+	}
+
+	// Machine-readable delivery status (RFC 3464 section 2.1)
+	dsn, _ := DecodeRecipientDSN(item.DSNRecipients)
+	part, _ = mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"message/delivery-status"}})
+	fmt.Fprintf(part, "Reporting-MTA: dns; %s\r\n", hostname)
+	if item.DSNEnvelopeID != "" {
+		fmt.Fprintf(part, "Original-Envelope-Id: %s\r\n", item.DSNEnvelopeID)
+	}
+	fmt.Fprintf(part, "Arrival-Date: %s\r\n", item.CreatedAt.Format(time.RFC1123Z))
+	for _, r := range recipients {
+		part.Write([]byte("\r\n"))
+		if orcpt := dsn[r.Recipient].ORCPT; orcpt != "" {
+			fmt.Fprintf(part, "Original-Recipient: %s\r\n", orcpt)
+		}
+		fmt.Fprintf(part, "Final-Recipient: rfc822; %s\r\n", r.Recipient)
+		action := reportAction(kind)
+		if r.Relayed {
+			action = "relayed"
+		}
+		fmt.Fprintf(part, "Action: %s\r\n", action)
+		fmt.Fprintf(part, "Status: %s\r\n", enhancedStatus(r))
+		if r.Code > 0 {
+			fmt.Fprintf(part, "Diagnostic-Code: smtp; %s\r\n", reportDiagnostic(r))
+		}
+		if !r.UpdatedAt.IsZero() {
+			fmt.Fprintf(part, "Last-Attempt-Date: %s\r\n", r.UpdatedAt.Format(time.RFC1123Z))
+		}
+	}
+
+	// The original message, or only its header when RET=HDRS, when it cannot
+	// be returned whole, and in delay and success reports (RFC 3461 section 4.3)
+	opts := &EnqueueOptions{}
+	full := kind == domain.RecipientFailed && !strings.EqualFold(item.DSNReturn, "HDRS")
+	if !full || item.BodyType == "BINARYMIME" || len(data) > maxReturnedContent {
+		part, _ = mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/rfc822-headers"}})
+		part.Write(messageHeader(data))
+	} else {
+		part, _ = mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"message/rfc822"}})
+		part.Write(data)
+		if item.BodyType == "8BITMIME" {
+			opts.BodyType = item.BodyType
+		}
+	}
+	opts.SMTPUTF8 = item.SMTPUTF8
+	mw.Close()
+
+	return b.Bytes(), opts
+}
+
+// reportAction returns the Action field for an outcome (RFC 3464 section 2.3.3)
+func reportAction(kind string) string {
+	switch kind {
+	case domain.RecipientDelivered:
+		return "delivered"
+	case domain.RecipientDeferred:
+		return "delayed"
+	default:
+		return "failed"
+	}
+}
+
+// enhancedStatus returns the RFC 3463 status of an outcome, taken from the
+// remote reply when it carried one
+func enhancedStatus(r domain.RecipientStatus) string {
+	if code, _, _ := strings.Cut(r.Message, " "); enhancedStatusCode.MatchString(code) {
+		return code
+	}
+	switch {
+	case r.Status == domain.RecipientDelivered:
+		return "2.0.0"
+	case r.Status == domain.RecipientDeferred, r.Code >= 400 && r.Code < 500:
+		return "4.0.0"
+	default:
+		return "5.0.0"
+	}
+}
+
+// reportDiagnostic describes an outcome for the human-readable part
+func reportDiagnostic(r domain.RecipientStatus) string {
+	switch {
+	case r.Code > 0:
+		return strings.TrimSpace(fmt.Sprintf("%d %s", r.Code, r.Message))
+	case r.Message != "":
+		return r.Message
+	default:
+		return r.Status
+	}
+}
+
+// messageHeader returns the header section of a message, including the blank line that ends it
+func messageHeader(data []byte) []byte {
+	if i := bytes.Index(data, []byte("\r\n\r\n")); i >= 0 {
+		return data[:i+4]
+	}
+	if i := bytes.Index(data, []byte("\n\n")); i >= 0 {
+		return data[:i+2]
+	}
+	return data
+}
@@ -70,6 +70,7 @@ func (s *DomainService) EnsureDefaultTemplate() error {
 		SpamRejectScore:     10.0,
 		SpamQuarantineScore: 5.0,
 		SpamLearningEnabled: true,
+		SpamBackend:         domain.SpamBackendSpamAssassin,
 
 		// Greylisting defaults
 		GreylistEnabled:         true,
@@ -95,6 +96,12 @@ func (s *DomainService) EnsureDefaultTemplate() error {
 		AuthBruteForceBlockMinutes:  60,
 		AuthIPBlacklistEnabled:      true,
 		AuthCleanupInterval:         3600,
+
+		// Forwarding defaults
+		ForwardExternalEnabled: true,
+
+		// Recipient routing defaults
+		SubaddressSeparator: DefaultSubaddressSeparator,
 	}
 
 	if err := s.repo.Create(defaultTemplate); err != nil {
@@ -123,7 +130,7 @@ func (s *DomainService) CreateDomainFromTemplate(name string) (*domain.Domain, e
 
 	// Create new domain with template's security settings
 	newDomain := &domain.Domain{
-		Name:           name,
+		Name:           NormalizeDomain(name),
 		Status:         "active",
 		MaxUsers:       template.MaxUsers,
 		MaxMailboxSize: template.MaxMailboxSize,
@@ -157,6 +164,7 @@ func (s *DomainService) CreateDomainFromTemplate(name string) (*domain.Domain, e
 		SpamRejectScore:     template.SpamRejectScore,
 		SpamQuarantineScore: template.SpamQuarantineScore,
 		SpamLearningEnabled: template.SpamLearningEnabled,
+		SpamBackend:         template.SpamBackend,
 
 		GreylistEnabled:         template.GreylistEnabled,
 		GreylistDelayMinutes:    template.GreylistDelayMinutes,
@@ -179,6 +187,12 @@ func (s *DomainService) CreateDomainFromTemplate(name string) (*domain.Domain, e
 		AuthBruteForceBlockMinutes:  template.AuthBruteForceBlockMinutes,
 		AuthIPBlacklistEnabled:      template.AuthIPBlacklistEnabled,
 		AuthCleanupInterval:         template.AuthCleanupInterval,
+
+		ForwardExternalEnabled:  template.ForwardExternalEnabled,
+		SubaddressSeparator:     template.SubaddressSeparator,
+		SubaddressFolders:       template.SubaddressFolders,
+		SubaddressCreateFolders: template.SubaddressCreateFolders,
+		OutboundPool:            template.OutboundPool,
 	}
 
 	if err := s.repo.Create(newDomain); err != nil {
@@ -222,6 +236,7 @@ func (s *DomainService) List(ctx context.Context) ([]*domain.Domain, error) {
 
 // Create creates a new domain
 func (s *DomainService) Create(ctx context.Context, newDomain *domain.Domain) error {
+	newDomain.Name = NormalizeDomain(newDomain.Name)
 	if err := s.repo.Create(newDomain); err != nil {
 		return fmt.Errorf("failed to create domain: %w", err)
 	}
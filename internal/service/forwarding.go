@@ -0,0 +1,198 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/repository"
+)
+
+var (
+	ErrInvalidForwardAddress      = errors.New("invalid forwarding address")
+	ErrExternalForwardingDisabled = errors.New("forwarding to external domains is disabled")
+)
+
+// ForwardAddresses parses a user's forwarding list into normalized addresses
+// Addresses may be separated by commas, semicolons or whitespace; duplicates are dropped.
+func ForwardAddresses(forwardTo string) ([]string, error) {
+	fields := strings.FieldsFunc(forwardTo, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\t' || r == '\r' || r == '\n'
+	})
+
+	var addrs []string
+	seen := make(map[string]bool)
+	for _, field := range fields {
+		parsed, err := mail.ParseAddress(field)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidForwardAddress, field)
+		}
+		addr := NormalizeAddress(parsed.Address)
+		if !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
+// IsHostedDomain reports whether a domain is active on this server
+func IsHostedDomain(domainRepo repository.DomainRepository, name string) bool {
+	if name == "" || name == DefaultTemplateDomainName {
+		return false
+	}
+	dom, err := domainRepo.GetByName(NormalizeDomain(name))
+	if err != nil || dom == nil {
+		return false
+	}
+	return dom.Status == "active"
+}
+
+// SetAuditService records forwarding changes in the audit log (optional)
+func (s *UserService) SetAuditService(audit *AuditService) {
+	s.audit = audit
+}
+
+// ValidateForwarding normalizes a user's forwarding list and checks it
+// against the forwarding policy of the user's domain
+func (s *UserService) ValidateForwarding(user *domain.User) error {
+	addrs, err := ForwardAddresses(user.ForwardTo)
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range addrs {
+		if addr == NormalizeAddress(user.Email) {
+			return fmt.Errorf("%w: cannot forward to itself", ErrInvalidForwardAddress)
+		}
+	}
+
+	if len(addrs) > 0 {
+		dom, err := s.domainRepo.GetByID(user.DomainID)
+		if err != nil {
+			return fmt.Errorf("failed to get domain: %w", err)
+		}
+		if dom != nil && !dom.ForwardExternalEnabled {
+			for _, addr := range addrs {
+				if !IsHostedDomain(s.domainRepo, domainPart(addr)) {
+					return fmt.Errorf("%w: %s", ErrExternalForwardingDisabled, addr)
+				}
+			}
+		}
+	}
+
+	user.ForwardTo = strings.Join(addrs, ", ")
+	return nil
+}
+
+// AuditForwarding records a change to a user's forwarding settings
+// actorID and actor identify the administrator making the change. Nothing is
+// logged when the settings are unchanged.
+func (s *UserService) AuditForwarding(ctx context.Context, actorID *int64, actor string, user *domain.User, previousForwardTo string, previousKeepCopy bool) {
+	if s.audit == nil {
+		return
+	}
+	if user.ForwardTo == previousForwardTo && user.ForwardKeepCopy == previousKeepCopy {
+		return
+	}
+
+	details := map[string]interface{}{
+		"email":               user.Email,
+		"forward_to":          user.ForwardTo,
+		"keep_copy":           user.ForwardKeepCopy,
+		"previous_forward_to": previousForwardTo,
+		"previous_keep_copy":  previousKeepCopy,
+	}
+	if err := s.audit.LogAction(ctx, actorID, actor, domain.ActionUserForwarding, "user", strconv.FormatInt(user.ID, 10), details); err != nil {
+		s.logger.Error("failed to audit forwarding change", zap.Error(err), zap.String("email", user.Email))
+	}
+}
+
+// forwardTargets returns the addresses a message delivered to user is forwarded to
+// Messages that already passed through this user (Delivered-To or X-Loop
+// naming them) are not forwarded again, and external addresses are skipped
+// when the user's domain forbids forwarding outside hosted domains.
+func (s *LocalDeliveryService) forwardTargets(user *domain.User, sender string, data []byte) []string {
+	addrs, err := ForwardAddresses(user.ForwardTo)
+	if err != nil {
+		s.logger.Warn("ignoring invalid forwarding address", zap.Error(err), zap.String("user", user.Email))
+		return nil
+	}
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	self := NormalizeAddress(user.Email)
+	if forwardingLoop(data, self) {
+		s.logger.Warn("forwarding loop detected, delivering locally",
+			zap.String("user", user.Email),
+			zap.String("sender", sender),
+		)
+		return nil
+	}
+
+	externalAllowed := true
+	if dom, err := s.domainRepo.GetByID(user.DomainID); err == nil && dom != nil {
+		externalAllowed = dom.ForwardExternalEnabled
+	}
+
+	var targets []string
+	for _, addr := range addrs {
+		if addr == self {
+			continue
+		}
+		if !externalAllowed && !s.IsLocalDomain(domainPart(addr)) {
+			s.logger.Warn("external forwarding disabled for domain",
+				zap.String("user", user.Email),
+				zap.String("destination", addr),
+			)
+			continue
+		}
+		targets = append(targets, addr)
+	}
+	return targets
+}
+
+// forward queues a copy of a message for the user's forwarding targets
+func (s *LocalDeliveryService) forward(user *domain.User, sender string, targets []string, data []byte) error {
+	// Trace headers let a later hop recognise the message if the forward
+	// leads back here
+	self := NormalizeAddress(user.Email)
+	trace := fmt.Sprintf("Delivered-To: %s\r\nX-Loop: %s\r\n", self, self)
+	forwarded := append([]byte(trace), data...)
+
+	// Forwarded mail keeps its original envelope sender, as alias expansion does
+	if _, err := s.queueService.Enqueue(sender, targets, forwarded); err != nil {
+		return fmt.Errorf("failed to queue forwarded message: %w", err)
+	}
+
+	s.logger.Info("message forwarded",
+		zap.String("user", user.Email),
+		zap.Strings("destinations", targets),
+	)
+	return nil
+}
+
+// forwardingLoop reports whether a message's Delivered-To or X-Loop header names addr
+func forwardingLoop(data []byte, addr string) bool {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return false
+	}
+	for _, name := range []string{"Delivered-To", "X-Loop"} {
+		for _, v := range msg.Header[name] {
+			v = strings.Trim(strings.TrimSpace(v), "<>")
+			if NormalizeAddress(v) == addr {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+// forwardingDomainRepository hosts example.com with a configurable forwarding policy
+type forwardingDomainRepository struct {
+	mockDomainRepository
+	externalEnabled bool
+}
+
+func (m *forwardingDomainRepository) GetByID(id int64) (*domain.Domain, error) {
+	return &domain.Domain{ID: id, Name: "example.com", Status: "active", ForwardExternalEnabled: m.externalEnabled}, nil
+}
+
+func (m *forwardingDomainRepository) GetByName(name string) (*domain.Domain, error) {
+	if name != "example.com" {
+		return nil, errors.New("not found")
+	}
+	return &domain.Domain{ID: 1, Name: name, Status: "active", ForwardExternalEnabled: m.externalEnabled}, nil
+}
+
+func TestForwardAddresses(t *testing.T) {
+	addrs, err := ForwardAddresses("One@Example.net, two@example.org;three@example.com\n one@example.net")
+	if err != nil {
+		t.Fatalf("ForwardAddresses failed: %v", err)
+	}
+	want := []string{"one@example.net", "two@example.org", "three@example.com"}
+	if strings.Join(addrs, ",") != strings.Join(want, ",") {
+		t.Errorf("expected %v, got %v", want, addrs)
+	}
+
+	if _, err := ForwardAddresses("valid@example.net, not-an-address"); !errors.Is(err, ErrInvalidForwardAddress) {
+		t.Errorf("expected invalid address error, got %v", err)
+	}
+}
+
+func TestUserService_ValidateForwarding(t *testing.T) {
+	svc := NewUserService(&mockUserRepository{}, &forwardingDomainRepository{}, zap.NewNop())
+
+	user := &domain.User{Email: "user@example.com", DomainID: 1, ForwardTo: "Other@Example.com;other@example.com"}
+	if err := svc.ValidateForwarding(user); err != nil {
+		t.Fatalf("expected hosted destination to be allowed, got %v", err)
+	}
+	if user.ForwardTo != "other@example.com" {
+		t.Errorf("expected normalized forwarding list, got %q", user.ForwardTo)
+	}
+
+	user.ForwardTo = "friend@example.net"
+	if err := svc.ValidateForwarding(user); !errors.Is(err, ErrExternalForwardingDisabled) {
+		t.Errorf("expected external forwarding to be rejected, got %v", err)
+	}
+
+	user.ForwardTo = "user@example.com"
+	if err := svc.ValidateForwarding(user); !errors.Is(err, ErrInvalidForwardAddress) {
+		t.Errorf("expected forwarding to itself to be rejected, got %v", err)
+	}
+}
+
+func TestLocalDeliveryService_Forward(t *testing.T) {
+	const message = "From: friend@example.net\r\nSubject: Hello\r\n\r\nBody\r\n"
+
+	newService := func(t *testing.T, externalEnabled bool, queued *[]*domain.QueueItem) *LocalDeliveryService {
+		queueRepo := &mockQueueRepository{
+			enqueueFunc: func(item *domain.QueueItem) error {
+				*queued = append(*queued, item)
+				return nil
+			},
+		}
+		queueSvc := NewQueueServiceWithPath(queueRepo, nil, zap.NewNop(), t.TempDir())
+		domainRepo := &forwardingDomainRepository{externalEnabled: externalEnabled}
+		return NewLocalDeliveryService(&mockUserRepository{}, &mockAliasRepository{}, domainRepo, nil, nil, queueSvc, zap.NewNop())
+	}
+	user := &domain.User{ID: 1, DomainID: 1, Email: "user@example.com", ForwardTo: "other@example.com, friend@example.org"}
+
+	t.Run("queues with trace headers", func(t *testing.T) {
+		var queued []*domain.QueueItem
+		svc := newService(t, true, &queued)
+
+		targets := svc.forwardTargets(user, "friend@example.net", []byte(message))
+		if err := svc.forward(user, "friend@example.net", targets, []byte(message)); err != nil {
+			t.Fatalf("forward failed: %v", err)
+		}
+		if len(queued) != 1 || queued[0].Sender != "friend@example.net" ||
+			queued[0].Recipients != `["other@example.com","friend@example.org"]` {
+			t.Fatalf("unexpected queue entries %+v", queued)
+		}
+
+		data, err := os.ReadFile(queued[0].MessagePath)
+		if err != nil {
+			t.Fatalf("failed to read forwarded message: %v", err)
+		}
+		if string(data) != "Delivered-To: user@example.com\r\nX-Loop: user@example.com\r\n"+message {
+			t.Errorf("unexpected forwarded message %q", data)
+		}
+
+		// The forwarded copy coming back is recognised as a loop
+		if targets := svc.forwardTargets(user, "friend@example.net", data); len(targets) != 0 {
+			t.Errorf("expected looping message not to be forwarded, got %v", targets)
+		}
+	})
+
+	t.Run("skips external destinations", func(t *testing.T) {
+		var queued []*domain.QueueItem
+		svc := newService(t, false, &queued)
+
+		targets := svc.forwardTargets(user, "friend@example.net", []byte(message))
+		if len(targets) != 1 || targets[0] != "other@example.com" {
+			t.Errorf("expected only the hosted destination, got %v", targets)
+		}
+	})
+
+	t.Run("queues nothing when the local copy fails", func(t *testing.T) {
+		var queued []*domain.QueueItem
+		svc := newService(t, true, &queued)
+		full := &domain.User{ID: 1, DomainID: 1, Email: "user@example.com", Status: "active",
+			ForwardTo: "other@example.com", ForwardKeepCopy: true, Quota: 10, UsedQuota: 10}
+		svc.SetQuotaService(NewQuotaService(&quotaUserRepository{users: []*domain.User{full}}, &mockMessageRepository{}, zap.NewNop()))
+
+		err := svc.deliverOrForward(full, "", "friend@example.net", full.Email, []byte(message))
+		if !errors.Is(err, ErrOverQuota) {
+			t.Fatalf("expected over quota error, got %v", err)
+		}
+		if len(queued) != 0 {
+			t.Errorf("expected nothing forwarded before the local copy is stored, got %+v", queued)
+		}
+	})
+}
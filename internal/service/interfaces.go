@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"time"
 
 	"github.com/btafoya/gomailserver/internal/domain"
@@ -10,6 +11,7 @@ import (
 type UserServiceInterface interface {
 	Create(user *domain.User, password string) error
 	Authenticate(email, password string) (*domain.User, error)
+	AuthenticateClient(email, password, scope string) (*domain.User, error)
 	GetByID(id int64) (*domain.User, error)
 	GetByEmail(email string) (*domain.User, error)
 	Update(user *domain.User) error
@@ -20,8 +22,14 @@ type UserServiceInterface interface {
 // MessageServiceInterface defines the message service interface
 type MessageServiceInterface interface {
 	Store(userID, mailboxID, uid int64, messageData []byte) (*domain.Message, error)
+	Append(userID, mailboxID, uid int64, messageData []byte, flags []string, date time.Time) (*domain.Message, error)
 	GetByID(id int64) (*domain.Message, error)
 	GetByMailbox(mailboxID int64, offset, limit int) ([]*domain.Message, error)
+	List(mailboxID int64) ([]*domain.Message, error)
+	Query(q *domain.MessageQuery) (*domain.MessageQueryResult, error)
+	SetFlags(id int64, flags []string) error
+	Copy(id int64, target *domain.Mailbox, uid int64) (*domain.Message, error)
+	Move(id int64, target *domain.Mailbox, uid int64) error
 	Delete(id int64) error
 }
 
@@ -29,18 +37,28 @@ type MessageServiceInterface interface {
 type MailboxServiceInterface interface {
 	Create(userID int64, name, specialUse string) error
 	GetByName(userID int64, name string) (*domain.Mailbox, error)
+	AllocateUID(mailboxID int64) (int64, error)
 	List(userID int64, subscribedOnly bool) ([]*domain.Mailbox, error)
 	Delete(mailboxID int64) error
 	Rename(mailboxID int64, newName string) error
 	UpdateSubscription(id int64, subscribed bool) error
+	SetSpecialUse(id int64, specialUse string) error
 }
 
 // QueueServiceInterface defines the queue service interface
 type QueueServiceInterface interface {
 	Enqueue(from string, to []string, message []byte) (string, error)
+	EnqueueFile(from string, to []string, spoolPath string, opts *EnqueueOptions) (string, error)
+	SpoolDir() string
 	GetPending() ([]*domain.QueueItem, error)
 	MarkDelivered(id int64) error
 	MarkFailed(id int64, errorMsg string) error
 	IncrementRetry(id int64, currentRetryCount int, failedAt time.Time) error
 	CalculateNextRetry(retryCount int, failedAt time.Time) time.Time
 }
+
+// LocalDeliveryInterface defines the local delivery service interface
+type LocalDeliveryInterface interface {
+	CheckRecipient(recipient string) error
+	Deliver(ctx context.Context, sender, recipient string, data []byte) error
+}
@@ -0,0 +1,311 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/repository"
+)
+
+// ErrRecipientNotFound is returned when a hosted domain has no user or alias for a recipient
+var ErrRecipientNotFound = errors.New("recipient not found")
+
+// LocalDeliveryService stores messages for recipients in domains hosted by this server
+type LocalDeliveryService struct {
+	userRepo       repository.UserRepository
+	aliasRepo      repository.AliasRepository
+	domainRepo     repository.DomainRepository
+	mailboxService *MailboxService
+	messageService *MessageService
+	queueService   *QueueService
+	autoReplies    *AutoReplyService
+	quotas         *QuotaService
+	logger         *zap.Logger
+}
+
+// NewLocalDeliveryService creates a new local delivery service
+func NewLocalDeliveryService(
+	userRepo repository.UserRepository,
+	aliasRepo repository.AliasRepository,
+	domainRepo repository.DomainRepository,
+	mailboxService *MailboxService,
+	messageService *MessageService,
+	queueService *QueueService,
+	logger *zap.Logger,
+) *LocalDeliveryService {
+	return &LocalDeliveryService{
+		userRepo:       userRepo,
+		aliasRepo:      aliasRepo,
+		domainRepo:     domainRepo,
+		mailboxService: mailboxService,
+		messageService: messageService,
+		queueService:   queueService,
+		logger:         logger,
+	}
+}
+
+// SetAutoReplyService sets the service answering mail for users on vacation (optional)
+func (s *LocalDeliveryService) SetAutoReplyService(autoReplies *AutoReplyService) {
+	s.autoReplies = autoReplies
+}
+
+// SetQuotaService sets the service enforcing storage quotas on delivered mail (optional)
+func (s *LocalDeliveryService) SetQuotaService(quotas *QuotaService) {
+	s.quotas = quotas
+}
+
+// IsLocalDomain reports whether a domain is hosted by this server
+func (s *LocalDeliveryService) IsLocalDomain(name string) bool {
+	return IsHostedDomain(s.domainRepo, name)
+}
+
+// Deliver stores a message for a local recipient, expanding aliases one level
+// Alias destinations outside hosted domains are queued for outbound delivery.
+func (s *LocalDeliveryService) Deliver(ctx context.Context, sender, recipient string, data []byte) error {
+	recipient = NormalizeAddress(recipient)
+
+	user, alias, folder, err := s.resolve(recipient)
+	if err != nil {
+		return err
+	}
+	if user != nil {
+		return s.deliverOrForward(user, folder, sender, recipient, data)
+	}
+
+	destinations, err := GetDestinations(alias.DestinationEmails)
+	if err != nil {
+		return fmt.Errorf("failed to parse alias destinations: %w", err)
+	}
+
+	var remote []string
+	for _, dest := range destinations {
+		dest = NormalizeAddress(dest)
+		if !s.IsLocalDomain(extractDomain(dest)) {
+			remote = append(remote, dest)
+			continue
+		}
+
+		user, _, folder, err := s.resolve(dest)
+		if err != nil || user == nil {
+			s.logger.Warn("alias destination not found",
+				zap.String("alias", recipient),
+				zap.String("destination", dest),
+			)
+			continue
+		}
+		if err := s.deliverOrForward(user, folder, sender, recipient, data); err != nil {
+			return err
+		}
+	}
+
+	if len(remote) > 0 {
+		if _, err := s.queueService.Enqueue(sender, remote, data); err != nil {
+			return fmt.Errorf("failed to queue alias destinations: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// CheckRecipient reports whether mail for a local address can be accepted
+// It returns ErrRecipientNotFound for unknown addresses and ErrUserDisabled
+// when the address belongs to an inactive user.
+func (s *LocalDeliveryService) CheckRecipient(recipient string) error {
+	user, _, _, err := s.resolve(NormalizeAddress(recipient))
+	if err != nil {
+		return err
+	}
+	if user != nil && user.Status != "active" {
+		return fmt.Errorf("%w: %s", ErrUserDisabled, user.Email)
+	}
+	return nil
+}
+
+// resolve finds the user or alias receiving mail for a local address
+// Exact matches win; otherwise the address is retried without its subaddress
+// tag, then routed to the domain's catch-all. folder names the mailbox tagged
+// mail is filed into when the domain enables subaddress folders.
+func (s *LocalDeliveryService) resolve(addr string) (user *domain.User, alias *domain.Alias, folder string, err error) {
+	if user, alias = s.lookup(addr); user != nil || alias != nil {
+		return user, alias, "", nil
+	}
+
+	dom, err := s.domainRepo.GetByName(domainPart(addr))
+	if err != nil || dom == nil {
+		return nil, nil, "", fmt.Errorf("%w: %s", ErrRecipientNotFound, addr)
+	}
+
+	if base, tag, ok := SplitSubaddress(addr, dom.SubaddressSeparator); ok {
+		if user, alias = s.lookup(base); user != nil || alias != nil {
+			if user != nil && dom.SubaddressFolders {
+				folder = subaddressFolder(tag)
+			}
+			return user, alias, folder, nil
+		}
+	}
+
+	if dom.CatchallEmail != nil && *dom.CatchallEmail != "" {
+		if user, alias = s.lookup(NormalizeAddress(*dom.CatchallEmail)); user != nil || alias != nil {
+			s.logger.Debug("recipient routed to catch-all",
+				zap.String("recipient", addr),
+				zap.String("catchall", *dom.CatchallEmail),
+			)
+			return user, alias, "", nil
+		}
+		s.logger.Warn("catch-all address not found",
+			zap.String("domain", dom.Name),
+			zap.String("catchall", *dom.CatchallEmail),
+		)
+	}
+
+	return nil, nil, "", fmt.Errorf("%w: %s", ErrRecipientNotFound, addr)
+}
+
+// lookup returns the user or active alias with exactly this address
+func (s *LocalDeliveryService) lookup(addr string) (*domain.User, *domain.Alias) {
+	if user, err := s.userRepo.GetByEmail(addr); err == nil && user != nil {
+		return user, nil
+	}
+	if alias, err := s.aliasRepo.GetByEmail(addr); err == nil && alias != nil && alias.Status == "active" {
+		return nil, alias
+	}
+	return nil, nil
+}
+
+// deliverOrForward delivers a message to a user, honoring their forwarding settings
+// A local copy is kept unless the message was forwarded and the user opted out
+// of keeping one.
+func (s *LocalDeliveryService) deliverOrForward(user *domain.User, folder, sender, recipient string, data []byte) error {
+	if user.Status != "active" {
+		return fmt.Errorf("%w: %s", ErrUserDisabled, user.Email)
+	}
+
+	// The local copy is stored before the forward is queued, so a failed
+	// delivery retried by the sender cannot forward the message twice
+	targets := s.forwardTargets(user, sender, data)
+	keepCopy := len(targets) == 0 || user.ForwardKeepCopy
+	if keepCopy {
+		if err := s.deliverToUser(user, folder, data); err != nil {
+			return err
+		}
+	}
+	if len(targets) > 0 {
+		if err := s.forward(user, sender, targets, data); err != nil {
+			if !keepCopy {
+				return err
+			}
+			// Failing now would make the sender retry and store a second copy
+			s.logger.Error("failed to forward delivered message",
+				zap.Error(err),
+				zap.String("user", user.Email),
+			)
+		}
+	}
+
+	s.autoReply(user, sender, recipient, data)
+	return nil
+}
+
+// deliverToUser appends a message to the user's INBOX, or to folder if set
+func (s *LocalDeliveryService) deliverToUser(user *domain.User, folder string, data []byte) error {
+	if user.Status != "active" {
+		return fmt.Errorf("%w: %s", ErrUserDisabled, user.Email)
+	}
+	if s.quotas != nil {
+		if err := s.quotas.Check(user.ID, int64(len(data)), 1); err != nil {
+			return err
+		}
+	}
+
+	var mailbox *domain.Mailbox
+	if folder != "" {
+		mailbox = s.subaddressMailbox(user, folder)
+	}
+	if mailbox == nil {
+		inbox, err := s.mailboxService.GetByName(user.ID, "INBOX")
+		if err != nil {
+			return fmt.Errorf("failed to get INBOX: %w", err)
+		}
+		mailbox = inbox
+	}
+
+	uid, err := s.mailboxService.AllocateUID(mailbox.ID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.messageService.Store(user.ID, mailbox.ID, uid, data); err != nil {
+		return err
+	}
+
+	s.logger.Info("message delivered locally",
+		zap.String("recipient", user.Email),
+		zap.Int64("mailbox_id", mailbox.ID),
+		zap.Int64("uid", uid),
+	)
+
+	return nil
+}
+
+// subaddressMailbox returns the folder for tagged mail. A missing folder is
+// created and subscribed only when the user's domain allows it and the user
+// has fewer than maxSubaddressMailboxes mailboxes. It returns nil, so
+// delivery falls back to INBOX, for special-use mailboxes and folders that
+// are missing and not created.
+func (s *LocalDeliveryService) subaddressMailbox(user *domain.User, folder string) *domain.Mailbox {
+	if mailbox, err := s.mailboxService.GetByName(user.ID, folder); err == nil && mailbox != nil {
+		if mailbox.SpecialUse != "" {
+			return nil
+		}
+		return mailbox
+	}
+
+	dom, err := s.domainRepo.GetByID(user.DomainID)
+	if err != nil || dom == nil || !dom.SubaddressCreateFolders {
+		return nil
+	}
+	mailboxes, err := s.mailboxService.List(user.ID, false)
+	if err != nil || len(mailboxes) >= maxSubaddressMailboxes {
+		s.logger.Warn("not creating subaddress folder",
+			zap.Error(err),
+			zap.String("recipient", user.Email),
+			zap.String("folder", folder),
+			zap.Int("mailboxes", len(mailboxes)),
+		)
+		return nil
+	}
+
+	// Create subscribes the new mailbox so clients show it straight away
+	if err := s.mailboxService.Create(user.ID, folder, ""); err != nil {
+		s.logger.Warn("failed to create subaddress folder",
+			zap.Error(err),
+			zap.String("recipient", user.Email),
+			zap.String("folder", folder),
+		)
+		return nil
+	}
+	mailbox, err := s.mailboxService.GetByName(user.ID, folder)
+	if err != nil {
+		return nil
+	}
+	return mailbox
+}
+
+// autoReply sends the user's vacation reply, if any, for a delivered message
+// Failures are logged; the message itself has already been delivered.
+func (s *LocalDeliveryService) autoReply(user *domain.User, sender, recipient string, data []byte) {
+	if s.autoReplies == nil {
+		return
+	}
+	if err := s.autoReplies.Respond(user, sender, recipient, data); err != nil {
+		s.logger.Error("failed to send auto reply",
+			zap.Error(err),
+			zap.String("recipient", user.Email),
+			zap.String("sender", sender),
+		)
+	}
+}
@@ -1,6 +1,8 @@
 package service
 
 import (
+	"errors"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
@@ -9,10 +11,32 @@ import (
 	"github.com/btafoya/gomailserver/internal/repository"
 )
 
+// SpecialUses lists the special-use attributes a mailbox can carry (RFC 6154)
+// \All and \Flagged describe virtual mailboxes, which are not supported.
+var SpecialUses = []string{"\\Archive", "\\Drafts", "\\Junk", "\\Sent", "\\Trash"}
+
+// ErrInvalidSpecialUse is returned for special-use attributes a mailbox cannot carry
+var ErrInvalidSpecialUse = errors.New("unsupported special-use attribute")
+
+// NormalizeSpecialUse returns a special-use attribute in its canonical case
+// An empty attribute clears the special use.
+func NormalizeSpecialUse(use string) (string, error) {
+	if use == "" {
+		return "", nil
+	}
+	for _, known := range SpecialUses {
+		if strings.EqualFold(use, known) {
+			return known, nil
+		}
+	}
+	return "", ErrInvalidSpecialUse
+}
+
 // MailboxService handles mailbox operations
 type MailboxService struct {
-	repo   repository.MailboxRepository
-	logger *zap.Logger
+	repo    repository.MailboxRepository
+	changes *ChangeService
+	logger  *zap.Logger
 }
 
 // NewMailboxService creates a new mailbox service
@@ -23,6 +47,11 @@ func NewMailboxService(repo repository.MailboxRepository, logger *zap.Logger) *M
 	}
 }
 
+// SetChangeService sets the change log that records mailbox changes (optional, for JMAP)
+func (s *MailboxService) SetChangeService(changes *ChangeService) {
+	s.changes = changes
+}
+
 // List lists mailboxes for a user
 func (s *MailboxService) List(userID int64, subscribed bool) ([]*domain.Mailbox, error) {
 	s.logger.Debug("listing mailboxes",
@@ -59,8 +88,18 @@ func (s *MailboxService) GetByID(id int64) (*domain.Mailbox, error) {
 	return s.repo.GetByID(id)
 }
 
+// AllocateUID reserves the next UID for a message appended to a mailbox
+func (s *MailboxService) AllocateUID(mailboxID int64) (int64, error) {
+	return s.repo.AllocateUID(mailboxID)
+}
+
 // Create creates a new mailbox
 func (s *MailboxService) Create(userID int64, name, specialUse string) error {
+	specialUse, err := NormalizeSpecialUse(specialUse)
+	if err != nil {
+		return err
+	}
+
 	now := time.Now()
 	mailbox := &domain.Mailbox{
 		UserID:      userID,
@@ -72,7 +111,7 @@ func (s *MailboxService) Create(userID int64, name, specialUse string) error {
 		CreatedAt:   now,
 	}
 
-	err := s.repo.Create(mailbox)
+	err = s.repo.Create(mailbox)
 	if err != nil {
 		s.logger.Error("failed to create mailbox",
 			zap.Error(err),
@@ -88,12 +127,24 @@ func (s *MailboxService) Create(userID int64, name, specialUse string) error {
 		zap.String("name", name),
 	)
 
+	if s.changes != nil {
+		s.changes.MailboxChanged(userID, mailbox.ID, domain.ChangeCreated)
+	}
+
 	return nil
 }
 
 // Delete deletes a mailbox
 func (s *MailboxService) Delete(id int64) error {
-	err := s.repo.Delete(id)
+	var err error
+	if s.changes != nil {
+		var mailbox *domain.Mailbox
+		if mailbox, err = s.repo.GetByID(id); err == nil {
+			err = s.changes.DestroyMailbox(mailbox, func() error { return s.repo.Delete(id) })
+		}
+	} else {
+		err = s.repo.Delete(id)
+	}
 	if err != nil {
 		s.logger.Error("failed to delete mailbox",
 			zap.Error(err),
@@ -133,6 +184,10 @@ func (s *MailboxService) Rename(id int64, newName string) error {
 		zap.String("new_name", newName),
 	)
 
+	if s.changes != nil {
+		s.changes.MailboxChanged(mailbox.UserID, id, domain.ChangeUpdated)
+	}
+
 	return nil
 }
 
@@ -145,7 +200,35 @@ func (s *MailboxService) UpdateSubscription(id int64, subscribed bool) error {
 
 	mailbox.Subscribed = subscribed
 
-	return s.repo.Update(mailbox)
+	return s.update(mailbox)
+}
+
+// SetSpecialUse changes the special-use attribute of a mailbox
+func (s *MailboxService) SetSpecialUse(id int64, specialUse string) error {
+	specialUse, err := NormalizeSpecialUse(specialUse)
+	if err != nil {
+		return err
+	}
+
+	mailbox, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+
+	mailbox.SpecialUse = specialUse
+
+	return s.update(mailbox)
+}
+
+// update stores a changed mailbox and records the change
+func (s *MailboxService) update(mailbox *domain.Mailbox) error {
+	if err := s.repo.Update(mailbox); err != nil {
+		return err
+	}
+	if s.changes != nil {
+		s.changes.MailboxChanged(mailbox.UserID, mailbox.ID, domain.ChangeUpdated)
+	}
+	return nil
 }
 
 // CreateDefaultMailboxes creates default mailboxes for a new user
@@ -0,0 +1,239 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+// DefaultBodyCollectInterval is how often bodies left unreferenced by cascading deletes are removed
+const DefaultBodyCollectInterval = time.Hour
+
+// bodyBatchSize is how many messages or bodies are handled per batch by background jobs
+const bodyBatchSize = 100
+
+// bodyPath returns the file a body with the given hash is stored in
+// Bodies are spread over 256 directories by the first byte of the hash.
+func (s *MessageService) bodyPath(hash string) string {
+	return filepath.Join(s.storagePath, "sha256", hash[:2], hash+".eml")
+}
+
+// writeBody prepares the body for content and writes its file if it is
+// large and not stored yet; written reports whether a file was created
+// The caller holds bodyMu.
+func (s *MessageService) writeBody(content []byte) (body *domain.MessageBody, written bool, err error) {
+	sum := sha256.Sum256(content)
+	body = &domain.MessageBody{
+		Hash: hex.EncodeToString(sum[:]),
+		Size: int64(len(content)),
+	}
+
+	if body.Size < StorageThreshold {
+		body.StorageType = "blob"
+		body.Content = content
+		return body, false, nil
+	}
+
+	body.StorageType = "file"
+	body.ContentPath = s.bodyPath(body.Hash)
+	if _, err := os.Stat(body.ContentPath); err == nil {
+		return body, false, nil
+	}
+
+	dir := filepath.Dir(body.ContentPath)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, false, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+
+	// Write under a temporary name so a partial file never has the body's name
+	tmp, err := os.CreateTemp(dir, body.Hash+".*.tmp")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to write message file: %w", err)
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, false, fmt.Errorf("failed to write message file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return nil, false, fmt.Errorf("failed to write message file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0640); err != nil {
+		os.Remove(tmp.Name())
+		return nil, false, fmt.Errorf("failed to write message file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), body.ContentPath); err != nil {
+		os.Remove(tmp.Name())
+		return nil, false, fmt.Errorf("failed to write message file: %w", err)
+	}
+	return body, true, nil
+}
+
+// legacyContent returns the content of a message stored before content addressing
+func (s *MessageService) legacyContent(msg *domain.Message) ([]byte, error) {
+	if msg.StorageType == "file" && msg.ContentPath != "" {
+		content, err := os.ReadFile(msg.ContentPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read message file: %w", err)
+		}
+		return content, nil
+	}
+	return msg.Content, nil
+}
+
+// collectBody removes a body and its file if no message refers to it any more
+func (s *MessageService) collectBody(hash string) bool {
+	s.bodyMu.Lock()
+	defer s.bodyMu.Unlock()
+
+	body, err := s.repo.DeleteBody(hash)
+	if err != nil {
+		s.logger.Warn("failed to delete message body", zap.String("hash", hash), zap.Error(err))
+		return false
+	}
+	if body == nil {
+		return false
+	}
+	if body.StorageType == "file" && body.ContentPath != "" {
+		if err := os.Remove(body.ContentPath); err != nil && !os.IsNotExist(err) {
+			s.logger.Warn("failed to delete message file",
+				zap.Error(err),
+				zap.String("path", body.ContentPath),
+			)
+		}
+	}
+	return true
+}
+
+// CollectBodies removes every body no message refers to
+// Deleting messages through the service collects their bodies at once; this
+// catches bodies left behind when mailboxes or users are deleted with their messages.
+func (s *MessageService) CollectBodies() (int, error) {
+	var collected int
+	for {
+		hashes, err := s.repo.UnreferencedBodies(bodyBatchSize)
+		if err != nil {
+			return collected, err
+		}
+
+		removed := 0
+		for _, hash := range hashes {
+			if s.collectBody(hash) {
+				removed++
+			}
+		}
+		collected += removed
+
+		// Stop when done, or when the remaining bodies were referenced again
+		if len(hashes) < bodyBatchSize || removed == 0 {
+			return collected, nil
+		}
+	}
+}
+
+// MigrateBodies moves messages stored with their own blob or file content to
+// shared bodies, removing their old files
+// Messages whose content cannot be read keep their old storage.
+func (s *MessageService) MigrateBodies(ctx context.Context) (int, error) {
+	var migrated int
+	var after int64
+	for {
+		ids, err := s.repo.ListWithoutBody(after, bodyBatchSize)
+		if err != nil {
+			return migrated, err
+		}
+		if len(ids) == 0 {
+			return migrated, nil
+		}
+
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return migrated, err
+			}
+			after = id
+			if err := s.migrateBody(id); err != nil {
+				s.logger.Warn("failed to move message to shared body",
+					zap.Int64("message_id", id),
+					zap.Error(err),
+				)
+				continue
+			}
+			migrated++
+		}
+	}
+}
+
+// migrateBody moves one message to a shared body
+func (s *MessageService) migrateBody(id int64) error {
+	s.bodyMu.Lock()
+	defer s.bodyMu.Unlock()
+
+	msg, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if msg.BodyHash != "" {
+		return nil
+	}
+
+	content, err := s.legacyContent(msg)
+	if err != nil {
+		return err
+	}
+	body, written, err := s.writeBody(content)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.SetBody(id, body); err != nil {
+		if written {
+			os.Remove(body.ContentPath)
+		}
+		return err
+	}
+
+	if msg.StorageType == "file" && msg.ContentPath != "" && msg.ContentPath != body.ContentPath {
+		if err := os.Remove(msg.ContentPath); err != nil && !os.IsNotExist(err) {
+			s.logger.Warn("failed to delete message file",
+				zap.Error(err),
+				zap.String("path", msg.ContentPath),
+			)
+		}
+	}
+	return nil
+}
+
+// RunBodyCollector moves older messages to shared bodies at startup and then
+// removes unreferenced bodies every interval until ctx is cancelled
+func (s *MessageService) RunBodyCollector(ctx context.Context, interval time.Duration) {
+	if migrated, err := s.MigrateBodies(ctx); err != nil {
+		s.logger.Error("failed to move messages to shared bodies", zap.Error(err))
+	} else if migrated > 0 {
+		s.logger.Info("moved messages to shared bodies", zap.Int("messages", migrated))
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if collected, err := s.CollectBodies(); err != nil {
+			s.logger.Error("message body collection failed", zap.Error(err))
+		} else {
+			s.logger.Debug("message body collection complete", zap.Int("collected", collected))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -8,8 +8,8 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/emersion/go-message/mail"
@@ -29,11 +29,19 @@ const (
 
 // MessageService handles message operations
 type MessageService struct {
-	repo           repository.MessageRepository
-	logger         *zap.Logger
-	storagePath    string
-	queueService   *QueueService
-	mailboxService *MailboxService
+	repo              repository.MessageRepository
+	logger            *zap.Logger
+	storagePath       string
+	queueService      *QueueService
+	submissionService *SubmissionService
+	mailboxService    *MailboxService
+	changes           *ChangeService
+	search            *SearchService
+	spamLearn         *SpamLearnService
+
+	// bodyMu orders storing and copying messages with collecting bodies,
+	// so a body is never removed while a new message is about to refer to it
+	bodyMu sync.Mutex
 }
 
 // NewMessageService creates a new message service
@@ -50,13 +58,47 @@ func (s *MessageService) SetQueueService(queueService *QueueService) {
 	s.queueService = queueService
 }
 
+// SetSubmissionService sets the submission service used to send webmail messages
+func (s *MessageService) SetSubmissionService(submissionService *SubmissionService) {
+	s.submissionService = submissionService
+}
+
 // SetMailboxService sets the mailbox service dependency (optional, for drafts/sent)
 func (s *MessageService) SetMailboxService(mailboxService *MailboxService) {
 	s.mailboxService = mailboxService
 }
 
+// SetChangeService sets the change log that records message changes (optional, for JMAP)
+func (s *MessageService) SetChangeService(changes *ChangeService) {
+	s.changes = changes
+}
+
+// SetSearchService sets the full-text index kept current as messages are stored (optional)
+func (s *MessageService) SetSearchService(search *SearchService) {
+	s.search = search
+}
+
+// SetSpamLearnService sets the service trained by moves into and out of Junk (optional)
+func (s *MessageService) SetSpamLearnService(spamLearn *SpamLearnService) {
+	s.spamLearn = spamLearn
+}
+
 // Store stores a message with hybrid storage strategy
 func (s *MessageService) Store(userID, mailboxID, uid int64, messageData []byte) (*domain.Message, error) {
+	// TODO: Parse RFC 2822 date from Date header
+	return s.store(userID, mailboxID, uid, messageData, nil, time.Now())
+}
+
+// Append stores a message appended by an IMAP client with its flags and internal date
+func (s *MessageService) Append(userID, mailboxID, uid int64, messageData []byte, flags []string, date time.Time) (*domain.Message, error) {
+	if date.IsZero() {
+		date = time.Now()
+	}
+	return s.store(userID, mailboxID, uid, messageData, flags, date)
+}
+
+// store parses and stores a message with the given flags and internal date
+func (s *MessageService) store(userID, mailboxID, uid int64, messageData []byte, flags []string, internalDate time.Time) (*domain.Message, error) {
 	size := int64(len(messageData))
 
 	// Parse MIME message
@@ -97,30 +139,7 @@ func (s *MessageService) Store(userID, mailboxID, uid int64, messageData []byte)
 	refs := mailReader.Header.Get("References")
 
 	// Generate thread ID from message headers
-	threadID := s.generateThreadID(messageID, inReplyTo)
-
-	// Parse internal date (use current time for now)
-	// TODO: Parse RFC 2822 date from Date header
-	internalDate := time.Now()
-
-	// Determine storage strategy
-	var storageType string
-	var content []byte
-	var contentPath string
-
-	if size < StorageThreshold {
-		// Small message: store in blob
-		storageType = "blob"
-		content = messageData
-	} else {
-		// Large message: store as file
-		storageType = "file"
-		path, err := s.saveToFile(userID, mailboxID, uid, messageData)
-		if err != nil {
-			return nil, fmt.Errorf("failed to save message to file: %w", err)
-		}
-		contentPath = path
-	}
+	threadID := s.generateThreadID(messageID, inReplyTo, refs)
 
 	// Create message record
 	msg := &domain.Message{
@@ -128,7 +147,7 @@ func (s *MessageService) Store(userID, mailboxID, uid int64, messageData []byte)
 		MailboxID:     mailboxID,
 		UID:           uint32(uid),
 		Size:          size,
-		Flags:         "",
+		Flags:         strings.Join(flags, " "),
 		Categories:    "",
 		ThreadID:      threadID,
 		ReceivedAt:    time.Now(),
@@ -144,17 +163,27 @@ func (s *MessageService) Store(userID, mailboxID, uid int64, messageData []byte)
 		Refs:          refs,
 		Headers:       string(headersJSON),
 		BodyStructure: bodyStructure,
-		StorageType:   storageType,
-		Content:       content,
-		ContentPath:   contentPath,
 	}
 
-	// Store in database
-	if err := s.repo.Create(msg); err != nil {
+	// Identical content shares one body, so the same message delivered to
+	// several users or copied between folders is stored once
+	s.bodyMu.Lock()
+	body, written, err := s.writeBody(messageData)
+	if err != nil {
+		s.bodyMu.Unlock()
+		return nil, err
+	}
+	msg.BodyHash = body.Hash
+	msg.StorageType = body.StorageType
+	msg.Content = body.Content
+	msg.ContentPath = body.ContentPath
+	err = s.repo.Create(msg)
+	if err != nil && written {
 		// If database insert fails and we saved a file, clean it up
-		if storageType == "file" && contentPath != "" {
-			os.Remove(contentPath)
-		}
+		os.Remove(body.ContentPath)
+	}
+	s.bodyMu.Unlock()
+	if err != nil {
 		return nil, fmt.Errorf("failed to create message: %w", err)
 	}
 
@@ -163,9 +192,16 @@ func (s *MessageService) Store(userID, mailboxID, uid int64, messageData []byte)
 		zap.Int64("user_id", userID),
 		zap.Int64("mailbox_id", mailboxID),
 		zap.Int64("size", size),
-		zap.String("storage_type", storageType),
+		zap.String("storage_type", msg.StorageType),
 	)
 
+	if s.search != nil {
+		s.search.Index(msg, messageData)
+	}
+	if s.changes != nil {
+		s.changes.MessageCreated(msg)
+	}
+
 	return msg, nil
 }
 
@@ -193,15 +229,130 @@ func (s *MessageService) GetByMailbox(mailboxID int64, offset, limit int) ([]*do
 	return s.repo.GetByMailbox(mailboxID, offset, limit)
 }
 
-// Delete deletes a message and its file if it exists
+// List retrieves every message in a mailbox in UID order, without content
+func (s *MessageService) List(mailboxID int64) ([]*domain.Message, error) {
+	return s.repo.ListByMailbox(mailboxID)
+}
+
+// Query finds, filters and orders a mailbox's messages, without content
+func (s *MessageService) Query(q *domain.MessageQuery) (*domain.MessageQueryResult, error) {
+	return s.repo.Query(q)
+}
+
+// SetFlags replaces a message's flags
+func (s *MessageService) SetFlags(id int64, flags []string) error {
+	if err := s.repo.UpdateFlags(id, strings.Join(flags, " ")); err != nil {
+		return err
+	}
+	if s.changes != nil {
+		if msg, err := s.repo.GetByID(id); err == nil {
+			s.changes.MessageUpdated(msg, msg.MailboxID)
+		}
+	}
+	return nil
+}
+
+// Copy copies a message into the target mailbox under uid
+// The copy is a new message of the target mailbox's owner and counts against
+// their quota, but shares the original's body.
+func (s *MessageService) Copy(id int64, target *domain.Mailbox, uid int64) (*domain.Message, error) {
+	// Holding bodyMu keeps the body from being collected if the original is
+	// deleted before the copy refers to it
+	s.bodyMu.Lock()
+	copied, source, err := s.copyMessage(id, target, uid)
+	s.bodyMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if s.search != nil {
+		s.search.Copy(id, copied.ID)
+	}
+	if s.changes != nil {
+		s.changes.MessageCreated(copied)
+	}
+	s.learnSpam(copied, source, target)
+	return copied, nil
+}
+
+// copyMessage creates the copy of a message and returns it with the original's
+// mailbox ID; the caller holds bodyMu
+func (s *MessageService) copyMessage(id int64, target *domain.Mailbox, uid int64) (*domain.Message, int64, error) {
+	msg, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	copied := *msg
+	copied.ID = 0
+	copied.UserID = target.UserID
+	copied.MailboxID = target.ID
+	copied.UID = uint32(uid)
+	copied.ReceivedAt = time.Now()
+
+	// A message stored before content addressing gets a body for its copy
+	var written bool
+	if msg.BodyHash == "" {
+		content, err := s.legacyContent(msg)
+		if err != nil {
+			return nil, 0, err
+		}
+		body, ok, err := s.writeBody(content)
+		if err != nil {
+			return nil, 0, err
+		}
+		written = ok
+		copied.BodyHash = body.Hash
+		copied.StorageType = body.StorageType
+		copied.Content = body.Content
+		copied.ContentPath = body.ContentPath
+	}
+
+	if err := s.repo.Create(&copied); err != nil {
+		if written {
+			os.Remove(copied.ContentPath)
+		}
+		return nil, 0, fmt.Errorf("failed to copy message: %w", err)
+	}
+	return &copied, msg.MailboxID, nil
+}
+
+// Move moves a message into the target mailbox under uid
+// Within one owner the message is reassigned in place; across owners it is
+// copied and the original deleted, so usage moves with it.
+func (s *MessageService) Move(id int64, target *domain.Mailbox, uid int64) error {
+	msg, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if msg.UserID == target.UserID {
+		if err := s.repo.Move(id, target.ID, uint32(uid)); err != nil {
+			return err
+		}
+		source := msg.MailboxID
+		msg.MailboxID = target.ID
+		if s.changes != nil {
+			s.changes.MessageUpdated(msg, source, target.ID)
+		}
+		s.learnSpam(msg, source, target)
+		return nil
+	}
+
+	if _, err := s.Copy(id, target, uid); err != nil {
+		return err
+	}
+	return s.Delete(id)
+}
+
+// Delete deletes a message and its body once no other message shares it
+// Its search index entry is removed with the row.
 func (s *MessageService) Delete(id int64) error {
 	msg, err := s.repo.GetByID(id)
 	if err != nil {
 		return err
 	}
 
-	// Delete file if it exists
-	if msg.StorageType == "file" && msg.ContentPath != "" {
+	// Delete the file of a message stored before content addressing
+	if msg.BodyHash == "" && msg.StorageType == "file" && msg.ContentPath != "" {
 		if err := os.Remove(msg.ContentPath); err != nil && !os.IsNotExist(err) {
 			s.logger.Warn("failed to delete message file",
 				zap.Error(err),
@@ -210,28 +361,16 @@ func (s *MessageService) Delete(id int64) error {
 		}
 	}
 
-	return s.repo.Delete(id)
-}
-
-// saveToFile saves message content to a file
-func (s *MessageService) saveToFile(userID, mailboxID, uid int64, content []byte) (string, error) {
-	// Create directory structure: storagePath/userID/mailboxID/
-	dir := filepath.Join(s.storagePath, fmt.Sprintf("%d", userID), fmt.Sprintf("%d", mailboxID))
-	if err := os.MkdirAll(dir, 0750); err != nil {
-		return "", fmt.Errorf("failed to create storage directory: %w", err)
+	if err := s.repo.Delete(id); err != nil {
+		return err
 	}
-
-	// Generate filename: uid-hash.eml
-	hash := sha256.Sum256(content)
-	filename := fmt.Sprintf("%d-%s.eml", uid, hex.EncodeToString(hash[:8]))
-	path := filepath.Join(dir, filename)
-
-	// Write file
-	if err := os.WriteFile(path, content, 0640); err != nil {
-		return "", fmt.Errorf("failed to write message file: %w", err)
+	if msg.BodyHash != "" {
+		s.collectBody(msg.BodyHash)
 	}
-
-	return path, nil
+	if s.changes != nil {
+		s.changes.MessageDestroyed(msg)
+	}
+	return nil
 }
 
 // parseBodyStructure parses the MIME body structure
@@ -269,7 +408,13 @@ func (s *MessageService) parseBodyStructure(mr *mail.Reader) (string, error) {
 }
 
 // generateThreadID generates a thread ID from message headers
-func (s *MessageService) generateThreadID(messageID, inReplyTo string) string {
+// Replies share the ID of the message that started their thread, the first
+// one they reference.
+func (s *MessageService) generateThreadID(messageID, inReplyTo, refs string) string {
+	if root := strings.Fields(refs); len(root) > 0 {
+		return s.normalizeMessageID(root[0])
+	}
+
 	// Use In-Reply-To if available for threading
 	if inReplyTo != "" {
 		return s.normalizeMessageID(inReplyTo)
@@ -18,6 +18,8 @@ type mockMessageRepository struct {
 	getByIDFunc     func(int64) (*domain.Message, error)
 	getByMailboxFunc func(int64, int, int) ([]*domain.Message, error)
 	deleteFunc      func(int64) error
+	usageFunc       func(int64) (int64, int64, error)
+	deleteBodyFunc  func(string) (*domain.MessageBody, error)
 }
 
 func (m *mockMessageRepository) Create(msg *domain.Message) error {
@@ -42,10 +44,26 @@ func (m *mockMessageRepository) GetByMailbox(mailboxID int64, offset, limit int)
 	return []*domain.Message{}, nil
 }
 
+func (m *mockMessageRepository) ListByMailbox(mailboxID int64) ([]*domain.Message, error) {
+	return []*domain.Message{}, nil
+}
+
+func (m *mockMessageRepository) Query(q *domain.MessageQuery) (*domain.MessageQueryResult, error) {
+	return &domain.MessageQueryResult{}, nil
+}
+
 func (m *mockMessageRepository) Update(message *domain.Message) error {
 	return nil
 }
 
+func (m *mockMessageRepository) UpdateFlags(id int64, flags string) error {
+	return nil
+}
+
+func (m *mockMessageRepository) Move(id, mailboxID int64, uid uint32) error {
+	return nil
+}
+
 func (m *mockMessageRepository) Delete(id int64) error {
 	if m.deleteFunc != nil {
 		return m.deleteFunc(id)
@@ -53,6 +71,32 @@ func (m *mockMessageRepository) Delete(id int64) error {
 	return nil
 }
 
+func (m *mockMessageRepository) Usage(userID int64) (int64, int64, error) {
+	if m.usageFunc != nil {
+		return m.usageFunc(userID)
+	}
+	return 0, 0, nil
+}
+
+func (m *mockMessageRepository) SetBody(id int64, body *domain.MessageBody) error {
+	return nil
+}
+
+func (m *mockMessageRepository) ListWithoutBody(afterID int64, limit int) ([]int64, error) {
+	return nil, nil
+}
+
+func (m *mockMessageRepository) UnreferencedBodies(limit int) ([]string, error) {
+	return nil, nil
+}
+
+func (m *mockMessageRepository) DeleteBody(hash string) (*domain.MessageBody, error) {
+	if m.deleteBodyFunc != nil {
+		return m.deleteBodyFunc(hash)
+	}
+	return nil, nil
+}
+
 func TestMessageService_Store_SmallMessage(t *testing.T) {
 	logger := zap.NewNop()
 	tempDir := t.TempDir()
@@ -194,6 +238,22 @@ Reply body`
 		// Thread ID should be based on In-Reply-To, not Message-ID
 		// Both messages in same thread should have same thread ID (when properly normalized)
 	})
+
+	t.Run("uses the first reference for thread ID", func(t *testing.T) {
+		root, err := svc.Store(1, 1, 102, []byte("Subject: Plans\r\nMessage-ID: <root@example.com>\r\n\r\nBody"))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		reply, err := svc.Store(1, 1, 103, []byte("Subject: Re: Plans\r\nMessage-ID: <second@example.com>\r\n"+
+			"In-Reply-To: <first@example.com>\r\nReferences: <root@example.com> <first@example.com>\r\n\r\nBody"))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if reply.ThreadID != root.ThreadID {
+			t.Errorf("expected the reply in the root's thread, got %q and %q", reply.ThreadID, root.ThreadID)
+		}
+	})
 }
 
 func TestMessageService_GetByID(t *testing.T) {
@@ -311,6 +371,86 @@ func TestMessageService_Delete(t *testing.T) {
 	})
 }
 
+func TestMessageService_Store_SharedBody(t *testing.T) {
+	logger := zap.NewNop()
+	tempDir := t.TempDir()
+
+	repo := &mockMessageRepository{}
+	svc := NewMessageService(repo, tempDir, logger)
+
+	largeEmail := []byte(createTestEmail("sender@example.com", "recipient@example.com", "Large Email", strings.Repeat("A", 1024*1024+1000)))
+
+	first, err := svc.Store(1, 1, 100, largeEmail)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	second, err := svc.Store(2, 5, 7, largeEmail)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if first.BodyHash == "" || first.BodyHash != second.BodyHash {
+		t.Errorf("expected identical messages to share a body, got %q and %q", first.BodyHash, second.BodyHash)
+	}
+	if first.ContentPath != second.ContentPath {
+		t.Errorf("expected one file for both messages, got %s and %s", first.ContentPath, second.ContentPath)
+	}
+
+	small, err := svc.Store(1, 1, 101, []byte(createTestEmail("a@example.com", "b@example.com", "Small", "body")))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if small.BodyHash == "" || small.BodyHash == first.BodyHash {
+		t.Errorf("expected a separate body for a different message, got %q", small.BodyHash)
+	}
+}
+
+func TestMessageService_Delete_CollectsBody(t *testing.T) {
+	logger := zap.NewNop()
+	tempDir := t.TempDir()
+
+	repo := &mockMessageRepository{}
+	svc := NewMessageService(repo, tempDir, logger)
+
+	largeEmail := []byte(createTestEmail("sender@example.com", "recipient@example.com", "Large Email", strings.Repeat("A", 1024*1024+1000)))
+	msg, err := svc.Store(1, 1, 100, largeEmail)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	repo.getByIDFunc = func(id int64) (*domain.Message, error) {
+		return msg, nil
+	}
+
+	t.Run("keeps body still referenced", func(t *testing.T) {
+		repo.deleteBodyFunc = func(hash string) (*domain.MessageBody, error) {
+			return nil, nil
+		}
+		if err := svc.Delete(msg.ID); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := os.Stat(msg.ContentPath); err != nil {
+			t.Errorf("expected shared file to be kept, got %v", err)
+		}
+	})
+
+	t.Run("removes body after last reference", func(t *testing.T) {
+		var collected string
+		repo.deleteBodyFunc = func(hash string) (*domain.MessageBody, error) {
+			collected = hash
+			return &domain.MessageBody{Hash: hash, StorageType: "file", ContentPath: msg.ContentPath}, nil
+		}
+		if err := svc.Delete(msg.ID); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if collected != msg.BodyHash {
+			t.Errorf("expected body %q to be collected, got %q", msg.BodyHash, collected)
+		}
+		if _, err := os.Stat(msg.ContentPath); !os.IsNotExist(err) {
+			t.Error("expected file to be deleted")
+		}
+	})
+}
+
 // Helper function to create test email
 func createTestEmail(from, to, subject, body string) string {
 	return `From: ` + from + `
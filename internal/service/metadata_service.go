@@ -0,0 +1,124 @@
+package service
+
+import (
+	"errors"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/repository"
+)
+
+// Limits on IMAP METADATA entries (RFC 5464 section 4.3)
+const (
+	MaxMetadataSize    = 64 * 1024 // bytes in one value
+	MaxMetadataEntries = 128       // entries one user sees on a mailbox or the server
+)
+
+var (
+	ErrInvalidMetadataName = errors.New("invalid metadata entry name")
+	ErrMetadataTooLarge    = errors.New("metadata value too large")
+	ErrMetadataTooMany     = errors.New("too many metadata entries")
+	ErrMetadataPermission  = errors.New("only administrators can change shared server metadata")
+)
+
+// MetadataService stores server and mailbox annotations (RFC 5464)
+// Entries below /private are kept per user, entries below /shared once for
+// everyone. Access to mailbox entries is checked by the caller against the
+// mailbox ACL; shared server entries can only be changed by administrators.
+type MetadataService struct {
+	repo   repository.MetadataRepository
+	logger *zap.Logger
+}
+
+// NewMetadataService creates a new metadata service
+func NewMetadataService(repo repository.MetadataRepository, logger *zap.Logger) *MetadataService {
+	return &MetadataService{
+		repo:   repo,
+		logger: logger,
+	}
+}
+
+// NormalizeMetadataName validates an entry name and returns it lowercased
+// Names are case-insensitive, start with /private or /shared, and consist of
+// slash-separated components without wildcards (RFC 5464 section 3.2).
+func NormalizeMetadataName(name string) (string, error) {
+	name = strings.ToLower(name)
+	if !strings.HasPrefix(name, "/private/") && !strings.HasPrefix(name, "/shared/") {
+		return "", ErrInvalidMetadataName
+	}
+	if strings.HasSuffix(name, "/") || strings.Contains(name, "//") {
+		return "", ErrInvalidMetadataName
+	}
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f || c == '*' || c == '%' {
+			return "", ErrInvalidMetadataName
+		}
+	}
+	return name, nil
+}
+
+// IsPrivateMetadata reports whether a normalized entry name is per user
+func IsPrivateMetadata(name string) bool {
+	return strings.HasPrefix(name, "/private/")
+}
+
+// List returns the entries of a mailbox visible to a user, or the server
+// entries for mailboxID 0, ordered by name
+func (s *MetadataService) List(userID, mailboxID int64) ([]*domain.MetadataEntry, error) {
+	return s.repo.List(userID, mailboxID)
+}
+
+// Set stores the value of an entry; a nil value removes it
+func (s *MetadataService) Set(user *domain.User, mailboxID int64, name string, value []byte) error {
+	name, err := NormalizeMetadataName(name)
+	if err != nil {
+		return err
+	}
+
+	var userID int64
+	if IsPrivateMetadata(name) {
+		userID = user.ID
+	} else if mailboxID == 0 && user.Role != "admin" {
+		return ErrMetadataPermission
+	}
+
+	if value == nil {
+		return s.repo.Delete(userID, mailboxID, name)
+	}
+	if len(value) > MaxMetadataSize {
+		return ErrMetadataTooLarge
+	}
+
+	entries, err := s.repo.List(user.ID, mailboxID)
+	if err != nil {
+		return err
+	}
+	exists := false
+	for _, entry := range entries {
+		if entry.Name == name && entry.UserID == userID {
+			exists = true
+			break
+		}
+	}
+	if !exists && len(entries) >= MaxMetadataEntries {
+		return ErrMetadataTooMany
+	}
+
+	if err := s.repo.Set(&domain.MetadataEntry{
+		UserID:    userID,
+		MailboxID: mailboxID,
+		Name:      name,
+		Value:     value,
+	}); err != nil {
+		return err
+	}
+
+	s.logger.Debug("metadata entry set",
+		zap.Int64("user_id", user.ID),
+		zap.Int64("mailbox_id", mailboxID),
+		zap.String("name", name),
+	)
+	return nil
+}
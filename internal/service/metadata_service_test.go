@@ -0,0 +1,137 @@
+package service
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+// mockMetadataRepository is an in-memory MetadataRepository
+type mockMetadataRepository struct {
+	entries []*domain.MetadataEntry
+}
+
+func (m *mockMetadataRepository) Set(entry *domain.MetadataEntry) error {
+	m.Delete(entry.UserID, entry.MailboxID, entry.Name)
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+func (m *mockMetadataRepository) Delete(userID, mailboxID int64, name string) error {
+	for i, e := range m.entries {
+		if e.UserID == userID && e.MailboxID == mailboxID && e.Name == name {
+			m.entries = append(m.entries[:i], m.entries[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *mockMetadataRepository) List(userID, mailboxID int64) ([]*domain.MetadataEntry, error) {
+	var result []*domain.MetadataEntry
+	for _, e := range m.entries {
+		if e.MailboxID == mailboxID && (e.UserID == 0 || e.UserID == userID) {
+			result = append(result, e)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+func TestNormalizeMetadataName(t *testing.T) {
+	valid := map[string]string{
+		"/private/comment":             "/private/comment",
+		"/Shared/Vendor/Example/Color": "/shared/vendor/example/color",
+	}
+	for name, want := range valid {
+		if got, err := NormalizeMetadataName(name); err != nil || got != want {
+			t.Errorf("NormalizeMetadataName(%q) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+
+	for _, name := range []string{"/private", "/public/comment", "/private/a//b", "/shared/comment/", "/private/*", "/shared/a%"} {
+		if _, err := NormalizeMetadataName(name); !errors.Is(err, ErrInvalidMetadataName) {
+			t.Errorf("expected %q to be rejected, got %v", name, err)
+		}
+	}
+}
+
+func TestMetadataService_Set(t *testing.T) {
+	repo := &mockMetadataRepository{}
+	svc := NewMetadataService(repo, zap.NewNop())
+	alice := &domain.User{ID: 1, Role: "user"}
+	bob := &domain.User{ID: 2, Role: "user"}
+	admin := &domain.User{ID: 3, Role: "admin"}
+
+	if err := svc.Set(alice, 10, "/private/Comment", []byte("mine")); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Set(alice, 10, "/shared/comment", []byte("ours")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bob sees the shared entry but not Alice's private one
+	entries, _ := svc.List(bob.ID, 10)
+	if len(entries) != 1 || entries[0].Name != "/shared/comment" {
+		t.Errorf("unexpected entries for another user: %+v", entries)
+	}
+	entries, _ = svc.List(alice.ID, 10)
+	if len(entries) != 2 || entries[0].Name != "/private/comment" || entries[0].UserID != alice.ID {
+		t.Errorf("unexpected entries for the owner: %+v", entries)
+	}
+
+	// Removing an entry
+	if err := svc.Set(alice, 10, "/private/comment", nil); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ = svc.List(alice.ID, 10); len(entries) != 1 {
+		t.Errorf("expected the private entry to be removed, got %+v", entries)
+	}
+
+	// Shared server entries are reserved for administrators
+	if err := svc.Set(alice, 0, "/shared/comment", []byte("x")); !errors.Is(err, ErrMetadataPermission) {
+		t.Errorf("expected permission error, got %v", err)
+	}
+	if err := svc.Set(admin, 0, "/shared/comment", []byte("x")); err != nil {
+		t.Errorf("expected administrator to set server entry, got %v", err)
+	}
+	if err := svc.Set(alice, 0, "/private/comment", []byte("x")); err != nil {
+		t.Errorf("expected private server entry to be set, got %v", err)
+	}
+
+	// Limits
+	if err := svc.Set(alice, 10, "/private/big", make([]byte, MaxMetadataSize+1)); !errors.Is(err, ErrMetadataTooLarge) {
+		t.Errorf("expected size limit, got %v", err)
+	}
+	repo.entries = nil
+	for i := 0; i < MaxMetadataEntries; i++ {
+		name := "/private/entry/" + string(rune('a'+i/26)) + string(rune('a'+i%26))
+		if err := svc.Set(alice, 11, name, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := svc.Set(alice, 11, "/private/one-more", []byte("x")); !errors.Is(err, ErrMetadataTooMany) {
+		t.Errorf("expected entry limit, got %v", err)
+	}
+	if err := svc.Set(alice, 11, "/private/entry/aa", []byte("changed")); err != nil {
+		t.Errorf("expected existing entry to be replaced at the limit, got %v", err)
+	}
+}
+
+func TestNormalizeSpecialUse(t *testing.T) {
+	if use, err := NormalizeSpecialUse("\\sent"); err != nil || use != "\\Sent" {
+		t.Errorf("NormalizeSpecialUse(\\sent) = %q, %v", use, err)
+	}
+	if use, err := NormalizeSpecialUse(""); err != nil || use != "" {
+		t.Errorf("NormalizeSpecialUse(\"\") = %q, %v", use, err)
+	}
+	for _, use := range []string{"\\All", "\\Flagged", "Sent"} {
+		if _, err := NormalizeSpecialUse(use); !errors.Is(err, ErrInvalidSpecialUse) {
+			t.Errorf("expected %q to be rejected, got %v", use, err)
+		}
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -21,6 +24,7 @@ type QueueService struct {
 	repo             repository.QueueRepository
 	logger           *zap.Logger
 	queuePath        string
+	hostname         string
 	telemetryService *repService.TelemetryService
 }
 
@@ -44,8 +48,39 @@ func NewQueueServiceWithPath(repo repository.QueueRepository, telemetryService *
 	}
 }
 
+// SetHostname sets the reporting MTA name used in delivery reports
+func (s *QueueService) SetHostname(hostname string) {
+	s.hostname = hostname
+}
+
+// EnqueueOptions carries ESMTP envelope parameters persisted with a queued message
+type EnqueueOptions struct {
+	BodyType   string // MAIL FROM BODY parameter: "", "7BIT", "8BITMIME" or "BINARYMIME"
+	SMTPUTF8   bool   // envelope contains internationalized addresses
+	RequireTLS bool   // REQUIRETLS was given on MAIL FROM
+
+	// DSN parameters (RFC 3461)
+	DSNReturn     string
+	DSNEnvelopeID string
+	DSNRecipients []domain.RecipientDSN
+
+	// HoldReason queues the message as held instead of pending; retrying the item releases it
+	HoldReason string
+
+	// Spam is the spam filter's verdict, kept for display with held messages
+	Spam *domain.SpamVerdict
+
+	// LocalOnly limits delivery to hosted domains, for mail received from other servers
+	LocalOnly bool
+}
+
 // Enqueue adds a message to the delivery queue
 func (s *QueueService) Enqueue(from string, to []string, message []byte) (string, error) {
+	return s.enqueueData(from, to, message, nil)
+}
+
+// enqueueData writes a message into the queue directory and records it
+func (s *QueueService) enqueueData(from string, to []string, message []byte, opts *EnqueueOptions) (string, error) {
 	messageID := generateMessageID()
 	messagePath := filepath.Join(s.queuePath, messageID+".eml")
 
@@ -67,46 +102,189 @@ func (s *QueueService) Enqueue(from string, to []string, message []byte) (string
 		return "", err
 	}
 
-	// Create queue entry
+	if err := s.enqueueItem(messageID, messagePath, from, to, opts); err != nil {
+		return "", err
+	}
+
+	s.logger.Info("message queued",
+		zap.String("message_id", messageID),
+		zap.String("from", from),
+		zap.Strings("to", to),
+		zap.Int("size", len(message)),
+		zap.String("path", messagePath),
+	)
+
+	return messageID, nil
+}
+
+// SpoolDir returns the directory for in-progress message spools
+// It lives inside the queue directory so EnqueueFile can rename rather than copy.
+func (s *QueueService) SpoolDir() string {
+	return filepath.Join(s.queuePath, "tmp")
+}
+
+// EnqueueFile adds an already spooled message file to the delivery queue
+// The file is moved into the queue directory; the caller must not reuse spoolPath.
+func (s *QueueService) EnqueueFile(from string, to []string, spoolPath string, opts *EnqueueOptions) (string, error) {
+	messageID := generateMessageID()
+	messagePath := filepath.Join(s.queuePath, messageID+".eml")
+
+	if err := os.MkdirAll(s.queuePath, 0755); err != nil {
+		s.logger.Error("failed to create queue directory",
+			zap.Error(err),
+			zap.String("path", s.queuePath),
+		)
+		return "", err
+	}
+
+	if err := moveFile(spoolPath, messagePath); err != nil {
+		s.logger.Error("failed to move spooled message into queue",
+			zap.Error(err),
+			zap.String("spool_path", spoolPath),
+			zap.String("path", messagePath),
+		)
+		return "", err
+	}
+
+	if err := s.enqueueItem(messageID, messagePath, from, to, opts); err != nil {
+		return "", err
+	}
+
+	s.logger.Info("message queued",
+		zap.String("message_id", messageID),
+		zap.String("from", from),
+		zap.Strings("to", to),
+		zap.String("path", messagePath),
+	)
+
+	return messageID, nil
+}
+
+// enqueueItem records a queue entry for a message already written to messagePath
+func (s *QueueService) enqueueItem(messageID, messagePath, from string, to []string, opts *EnqueueOptions) error {
 	item := &domain.QueueItem{
 		Sender:      from,
 		Recipients:  encodeRecipients(to),
+		MessageID:   messageID,
 		MessagePath: messagePath,
 		Status:      "pending",
 		RetryCount:  0,
 		MaxRetries:  9,
 		CreatedAt:   time.Now(),
 	}
+	if opts != nil {
+		item.BodyType = opts.BodyType
+		item.SMTPUTF8 = opts.SMTPUTF8
+		item.RequireTLS = opts.RequireTLS
+		item.DSNReturn = opts.DSNReturn
+		item.DSNEnvelopeID = opts.DSNEnvelopeID
+		item.LocalOnly = opts.LocalOnly
+		if opts.HoldReason != "" {
+			item.Status = "held"
+			item.ErrorMessage = opts.HoldReason
+		}
+		if len(opts.DSNRecipients) > 0 {
+			data, err := json.Marshal(opts.DSNRecipients)
+			if err != nil {
+				os.Remove(messagePath)
+				return err
+			}
+			item.DSNRecipients = string(data)
+		}
+		if opts.Spam != nil {
+			data, err := json.Marshal(opts.Spam)
+			if err != nil {
+				os.Remove(messagePath)
+				return err
+			}
+			item.Spam = string(data)
+		}
+	}
 
 	if err := s.repo.Enqueue(item); err != nil {
 		// Clean up file if database insert fails
 		os.Remove(messagePath)
-		return "", err
+		return err
 	}
 
-	s.logger.Info("message queued",
-		zap.String("message_id", messageID),
-		zap.String("from", from),
-		zap.Strings("to", to),
-		zap.Int("size", len(message)),
-		zap.String("path", messagePath),
-	)
+	return nil
+}
 
-	return messageID, nil
+// moveFile renames src to dst, falling back to copy+remove across filesystems
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	return os.Remove(src)
 }
 
 // encodeRecipients converts recipient list to JSON
 func encodeRecipients(recipients []string) string {
-	// Simple implementation for now
-	result := "["
-	for i, r := range recipients {
-		if i > 0 {
-			result += ","
-		}
-		result += `"` + r + `"`
+	if recipients == nil {
+		recipients = []string{}
+	}
+	data, _ := json.Marshal(recipients)
+	return string(data)
+}
+
+// DecodeRecipients parses the JSON recipient list stored on a queue item
+func DecodeRecipients(recipients string) ([]string, error) {
+	var result []string
+	if err := json.Unmarshal([]byte(recipients), &result); err != nil {
+		return nil, fmt.Errorf("failed to decode recipients: %w", err)
+	}
+	return result, nil
+}
+
+// DecodeRecipientDSN converts the stored per-recipient DSN parameters into a lookup by recipient
+func DecodeRecipientDSN(data string) (map[string]domain.RecipientDSN, error) {
+	result := make(map[string]domain.RecipientDSN)
+	if data == "" {
+		return result, nil
+	}
+
+	var params []domain.RecipientDSN
+	if err := json.Unmarshal([]byte(data), &params); err != nil {
+		return nil, fmt.Errorf("failed to decode recipient DSN parameters: %w", err)
+	}
+	for _, p := range params {
+		result[p.Recipient] = p
+	}
+	return result, nil
+}
+
+// DecodeRecipientStatus parses the per-recipient outcomes stored on a queue item
+func DecodeRecipientStatus(data string) ([]domain.RecipientStatus, error) {
+	if data == "" {
+		return nil, nil
 	}
-	result += "]"
-	return result
+
+	var result []domain.RecipientStatus
+	if err := json.Unmarshal([]byte(data), &result); err != nil {
+		return nil, fmt.Errorf("failed to decode recipient status: %w", err)
+	}
+	return result, nil
 }
 
 // generateMessageID generates a unique message ID
@@ -126,6 +304,11 @@ func (s *QueueService) GetPendingItems(ctx context.Context) ([]*domain.QueueItem
 	return s.repo.GetPending()
 }
 
+// GetItemsByStatus retrieves all queue items with a status, such as "held"
+func (s *QueueService) GetItemsByStatus(ctx context.Context, status string) ([]*domain.QueueItem, error) {
+	return s.repo.GetByStatus(status)
+}
+
 // GetByID retrieves a specific queue item by ID
 func (s *QueueService) GetByID(ctx context.Context, id int64) (*domain.QueueItem, error) {
 	return s.repo.GetByID(id)
@@ -168,6 +351,72 @@ func (s *QueueService) DeleteItem(ctx context.Context, id int64) error {
 	return nil
 }
 
+// MarkProcessing claims a queue item for a delivery worker
+func (s *QueueService) MarkProcessing(id int64) error {
+	return s.repo.UpdateStatus(id, "processing", "")
+}
+
+// UpdateRecipients narrows a queue item to the recipients still awaiting delivery
+func (s *QueueService) UpdateRecipients(id int64, recipients []string) error {
+	return s.repo.UpdateRecipients(id, encodeRecipients(recipients))
+}
+
+// RecordRecipientStatus merges the outcomes of a delivery attempt into the
+// item's per-recipient status and returns the outcomes of every recipient so far
+func (s *QueueService) RecordRecipientStatus(item *domain.QueueItem, outcomes []domain.RecipientStatus) ([]domain.RecipientStatus, error) {
+	statuses, err := DecodeRecipientStatus(item.RcptStatus)
+	if err != nil {
+		s.logger.Warn("discarding invalid recipient status", zap.Int64("id", item.ID), zap.Error(err))
+		statuses = nil
+	}
+
+	for _, outcome := range outcomes {
+		replaced := false
+		for i := range statuses {
+			if statuses[i].Recipient == outcome.Recipient {
+				statuses[i] = outcome
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			statuses = append(statuses, outcome)
+		}
+	}
+
+	data, err := json.Marshal(statuses)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.UpdateRecipientStatus(item.ID, string(data)); err != nil {
+		return nil, err
+	}
+	item.RcptStatus = string(data)
+	return statuses, nil
+}
+
+// Defer schedules a temporarily failed item for another attempt
+func (s *QueueService) Defer(id int64, currentRetryCount int, errorMsg string, failedAt time.Time) error {
+	if err := s.IncrementRetry(id, currentRetryCount, failedAt); err != nil {
+		return err
+	}
+	return s.repo.UpdateStatus(id, "pending", errorMsg)
+}
+
+// Reschedule returns an item to the queue at a fixed time without consuming a retry
+// Used when delivery is throttled rather than failed.
+func (s *QueueService) Reschedule(id int64, retryCount int, nextRetry time.Time, reason string) error {
+	if err := s.repo.UpdateRetry(id, retryCount, nextRetry); err != nil {
+		return err
+	}
+	return s.repo.UpdateStatus(id, "pending", reason)
+}
+
+// RequeueStale returns items orphaned in 'processing' (e.g. after a crash) to the queue
+func (s *QueueService) RequeueStale() (int64, error) {
+	return s.repo.RequeueProcessing()
+}
+
 // MarkDelivered marks a queue item as successfully delivered
 // Note: This should be called by the delivery worker with proper context including recipient domain
 func (s *QueueService) MarkDelivered(id int64) error {
@@ -198,6 +447,11 @@ func (s *QueueService) MarkFailed(id int64, errorMsg string) error {
 	return nil
 }
 
+// MarkPartial marks a queue item as delivered to some recipients and failed for the rest
+func (s *QueueService) MarkPartial(id int64, errorMsg string) error {
+	return s.repo.UpdateStatus(id, "partial", errorMsg)
+}
+
 // RecordDeliveryTelemetry records successful delivery telemetry
 // This should be called by the delivery worker after successful SMTP delivery
 func (s *QueueService) RecordDeliveryTelemetry(ctx context.Context, senderDomain, recipientDomain, ip string) error {
@@ -254,9 +508,8 @@ func (s *QueueService) CalculateNextRetry(retryCount int, failedAt time.Time) ti
 	return failedAt.Add(delays[retryCount])
 }
 
-// ProcessQueue processes pending queue items
-// This method would require an SMTP client implementation to actually send the messages.
-// The queue infrastructure is ready but needs SMTP delivery integration.
+// ProcessQueue logs the number of queue items awaiting delivery
+// Actual delivery is performed by the outbound delivery worker (internal/delivery).
 func (s *QueueService) ProcessQueue() error {
 	items, err := s.repo.GetPending()
 	if err != nil {
@@ -268,14 +521,5 @@ func (s *QueueService) ProcessQueue() error {
 		zap.Int("pending_count", len(items)),
 	)
 
-	// TODO: Implement SMTP client integration for actual message delivery
-	// For each item:
-	//   - Read message from MessagePath
-	//   - Connect to recipient MX servers
-	//   - Attempt SMTP delivery
-	//   - On success: MarkDelivered(item.ID)
-	//   - On failure: IncrementRetry or MarkFailed based on retry count
-	//   - Handle retry scheduling with exponential backoff
-
 	return nil
 }
@@ -3,6 +3,8 @@ package service
 import (
 	"context"
 	"errors"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -19,6 +21,8 @@ type mockQueueRepository struct {
 	getByIDFunc      func(int64) (*domain.QueueItem, error)
 	updateStatusFunc func(int64, string, string) error
 	updateRetryFunc  func(int64, int, time.Time) error
+	updateRcptsFunc  func(int64, string) error
+	updateStatusesFn func(int64, string) error
 	deleteFunc       func(int64) error
 }
 
@@ -48,6 +52,10 @@ func (m *mockQueueRepository) GetPending() ([]*domain.QueueItem, error) {
 	return []*domain.QueueItem{}, nil
 }
 
+func (m *mockQueueRepository) GetByStatus(status string) ([]*domain.QueueItem, error) {
+	return []*domain.QueueItem{}, nil
+}
+
 func (m *mockQueueRepository) GetByID(id int64) (*domain.QueueItem, error) {
 	if m.getByIDFunc != nil {
 		return m.getByIDFunc(id)
@@ -69,6 +77,24 @@ func (m *mockQueueRepository) UpdateRetry(id int64, retryCount int, nextRetry ti
 	return nil
 }
 
+func (m *mockQueueRepository) UpdateRecipients(id int64, recipients string) error {
+	if m.updateRcptsFunc != nil {
+		return m.updateRcptsFunc(id, recipients)
+	}
+	return nil
+}
+
+func (m *mockQueueRepository) UpdateRecipientStatus(id int64, recipientStatus string) error {
+	if m.updateStatusesFn != nil {
+		return m.updateStatusesFn(id, recipientStatus)
+	}
+	return nil
+}
+
+func (m *mockQueueRepository) RequeueProcessing() (int64, error) {
+	return 0, nil
+}
+
 func (m *mockQueueRepository) Delete(id int64) error {
 	if m.deleteFunc != nil {
 		return m.deleteFunc(id)
@@ -330,3 +356,283 @@ func TestQueueService_IncrementRetry(t *testing.T) {
 		}
 	})
 }
+
+func TestEncodeRecipients(t *testing.T) {
+	recipients := []string{`"quoted"@example.com`, `back\slash@example.com`}
+
+	decoded, err := DecodeRecipients(encodeRecipients(recipients))
+	if err != nil {
+		t.Fatalf("DecodeRecipients() error = %v", err)
+	}
+	if len(decoded) != 2 || decoded[0] != recipients[0] || decoded[1] != recipients[1] {
+		t.Errorf("round trip = %q, want %q", decoded, recipients)
+	}
+
+	if got := encodeRecipients(nil); got != "[]" {
+		t.Errorf("encodeRecipients(nil) = %q, want []", got)
+	}
+}
+
+func TestQueueService_RecordRecipientStatus(t *testing.T) {
+	var stored string
+	repo := &mockQueueRepository{
+		updateStatusesFn: func(id int64, recipientStatus string) error {
+			stored = recipientStatus
+			return nil
+		},
+	}
+	svc := NewQueueService(repo, nil, zap.NewNop())
+	item := &domain.QueueItem{ID: 1}
+
+	_, err := svc.RecordRecipientStatus(item, []domain.RecipientStatus{
+		{Recipient: "a@example.com", Status: domain.RecipientDelivered, Code: 250},
+		{Recipient: "b@example.com", Status: domain.RecipientDeferred, Code: 451},
+	})
+	if err != nil {
+		t.Fatalf("RecordRecipientStatus() error = %v", err)
+	}
+
+	// A later attempt replaces the deferred outcome and keeps the delivered one
+	statuses, err := svc.RecordRecipientStatus(item, []domain.RecipientStatus{
+		{Recipient: "b@example.com", Status: domain.RecipientFailed, Code: 550},
+	})
+	if err != nil {
+		t.Fatalf("RecordRecipientStatus() error = %v", err)
+	}
+	if len(statuses) != 2 || statuses[0].Status != domain.RecipientDelivered || statuses[1].Status != domain.RecipientFailed {
+		t.Errorf("statuses = %+v", statuses)
+	}
+	if item.RcptStatus != stored {
+		t.Errorf("item status %q not the stored %q", item.RcptStatus, stored)
+	}
+}
+
+func TestQueueService_MarkPartial(t *testing.T) {
+	var capturedStatus string
+	repo := &mockQueueRepository{
+		updateStatusFunc: func(id int64, status, errorMsg string) error {
+			capturedStatus = status
+			return nil
+		},
+	}
+	svc := NewQueueService(repo, nil, zap.NewNop())
+
+	if err := svc.MarkPartial(1, "b@example.com: user unknown"); err != nil {
+		t.Fatalf("MarkPartial() error = %v", err)
+	}
+	if capturedStatus != "partial" {
+		t.Errorf("expected status 'partial', got '%s'", capturedStatus)
+	}
+}
+
+func TestQueueService_Bounce(t *testing.T) {
+	original := []byte("From: sender@example.com\r\nSubject: hello\r\n\r\nbody text\r\n")
+	failed := []domain.RecipientStatus{
+		{Recipient: "gone@remote.test", Status: domain.RecipientFailed, Code: 550, Message: "5.1.1 User unknown"},
+		{Recipient: "far@remote.test", Status: domain.RecipientFailed, Code: 451, Message: "try again later"},
+	}
+
+	t.Run("queues a report to the sender", func(t *testing.T) {
+		var captured *domain.QueueItem
+		repo := &mockQueueRepository{
+			enqueueFunc: func(item *domain.QueueItem) error {
+				captured = item
+				return nil
+			},
+		}
+		svc := NewQueueServiceWithPath(repo, nil, zap.NewNop(), t.TempDir())
+		svc.SetHostname("mx.example.com")
+		item := &domain.QueueItem{
+			ID:            7,
+			Sender:        "sender@example.com",
+			DSNEnvelopeID: "env-1",
+			DSNRecipients: `[{"recipient":"gone@remote.test","orcpt":"rfc822;alias@remote.test"}]`,
+			CreatedAt:     time.Now(),
+		}
+
+		if err := svc.Bounce(item, original, failed); err != nil {
+			t.Fatalf("Bounce() error = %v", err)
+		}
+		if captured == nil {
+			t.Fatal("expected a report to be queued")
+		}
+		if captured.Sender != "" || captured.Recipients != `["sender@example.com"]` {
+			t.Errorf("report envelope = %q -> %s, want null sender to the original sender", captured.Sender, captured.Recipients)
+		}
+
+		data, err := os.ReadFile(captured.MessagePath)
+		if err != nil {
+			t.Fatalf("failed to read report: %v", err)
+		}
+		report := string(data)
+		for _, want := range []string{
+			"From: Mail Delivery System <MAILER-DAEMON@mx.example.com>",
+			"Auto-Submitted: auto-replied",
+			"multipart/report; report-type=delivery-status",
+			"Reporting-MTA: dns; mx.example.com",
+			"Original-Envelope-Id: env-1",
+			"Original-Recipient: rfc822;alias@remote.test",
+			"Final-Recipient: rfc822; gone@remote.test",
+			"Action: failed",
+			"Status: 5.1.1",
+			"Diagnostic-Code: smtp; 550 5.1.1 User unknown",
+			"Status: 4.0.0",
+			"Content-Type: message/rfc822",
+			"Subject: hello",
+		} {
+			if !strings.Contains(report, want) {
+				t.Errorf("report missing %q:\n%s", want, report)
+			}
+		}
+	})
+
+	t.Run("never bounces a null sender", func(t *testing.T) {
+		repo := &mockQueueRepository{
+			enqueueFunc: func(item *domain.QueueItem) error {
+				t.Error("unexpected report for a null sender")
+				return nil
+			},
+		}
+		svc := NewQueueServiceWithPath(repo, nil, zap.NewNop(), t.TempDir())
+
+		if err := svc.Bounce(&domain.QueueItem{Sender: ""}, original, failed); err != nil {
+			t.Fatalf("Bounce() error = %v", err)
+		}
+	})
+
+	t.Run("returns only the header of binary messages", func(t *testing.T) {
+		var captured *domain.QueueItem
+		repo := &mockQueueRepository{
+			enqueueFunc: func(item *domain.QueueItem) error {
+				captured = item
+				return nil
+			},
+		}
+		svc := NewQueueServiceWithPath(repo, nil, zap.NewNop(), t.TempDir())
+		item := &domain.QueueItem{Sender: "sender@example.com", BodyType: "BINARYMIME"}
+
+		if err := svc.Bounce(item, original, failed); err != nil {
+			t.Fatalf("Bounce() error = %v", err)
+		}
+		data, _ := os.ReadFile(captured.MessagePath)
+		if !strings.Contains(string(data), "text/rfc822-headers") || strings.Contains(string(data), "body text") {
+			t.Errorf("expected only the original header:\n%s", data)
+		}
+	})
+}
+
+func TestQueueService_ReportNotify(t *testing.T) {
+	original := []byte("From: sender@example.com\r\nSubject: hello\r\n\r\nbody text\r\n")
+	dsn := `[{"recipient":"never@remote.test","notify":["NEVER"]},` +
+		`{"recipient":"all@remote.test","notify":["SUCCESS","FAILURE","DELAY"]}]`
+	outcome := func(rcpt, status string) domain.RecipientStatus {
+		return domain.RecipientStatus{Recipient: rcpt, Status: status, Code: 250}
+	}
+
+	tests := []struct {
+		name   string
+		report func(*QueueService, *domain.QueueItem) error
+		want   []string // recipients in the report, none when no report is sent
+	}{
+		{
+			name: "failure reported unless NOTIFY excludes it",
+			report: func(s *QueueService, item *domain.QueueItem) error {
+				return s.Bounce(item, original, []domain.RecipientStatus{
+					outcome("never@remote.test", domain.RecipientFailed),
+					outcome("default@remote.test", domain.RecipientFailed),
+				})
+			},
+			want: []string{"default@remote.test"},
+		},
+		{
+			name: "success reported only when requested",
+			report: func(s *QueueService, item *domain.QueueItem) error {
+				return s.ReportSuccess(item, original, []domain.RecipientStatus{
+					outcome("default@remote.test", domain.RecipientDelivered),
+					outcome("all@remote.test", domain.RecipientDelivered),
+				})
+			},
+			want: []string{"all@remote.test"},
+		},
+		{
+			name: "delay reported only when requested",
+			report: func(s *QueueService, item *domain.QueueItem) error {
+				return s.ReportDelay(item, original, []domain.RecipientStatus{
+					outcome("default@remote.test", domain.RecipientDeferred),
+					outcome("never@remote.test", domain.RecipientDeferred),
+				})
+			},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var captured *domain.QueueItem
+			repo := &mockQueueRepository{
+				enqueueFunc: func(item *domain.QueueItem) error {
+					captured = item
+					return nil
+				},
+			}
+			svc := NewQueueServiceWithPath(repo, nil, zap.NewNop(), t.TempDir())
+			item := &domain.QueueItem{Sender: "sender@example.com", DSNRecipients: dsn}
+
+			if err := tt.report(svc, item); err != nil {
+				t.Fatalf("report error = %v", err)
+			}
+			if tt.want == nil {
+				if captured != nil {
+					t.Error("expected no report")
+				}
+				return
+			}
+			if captured == nil {
+				t.Fatal("expected a report")
+			}
+			data, _ := os.ReadFile(captured.MessagePath)
+			if got := strings.Count(string(data), "Final-Recipient:"); got != len(tt.want) {
+				t.Errorf("report has %d recipients, want %d:\n%s", got, len(tt.want), data)
+			}
+			for _, rcpt := range tt.want {
+				if !strings.Contains(string(data), "Final-Recipient: rfc822; "+rcpt) {
+					t.Errorf("report missing %s", rcpt)
+				}
+			}
+		})
+	}
+}
+
+func TestQueueService_BuildDeliveryReport(t *testing.T) {
+	original := []byte("From: sender@example.com\r\nSubject: hello\r\n\r\nbody text\r\n")
+	svc := NewQueueService(&mockQueueRepository{}, nil, zap.NewNop())
+
+	t.Run("RET=HDRS returns only the header", func(t *testing.T) {
+		item := &domain.QueueItem{Sender: "sender@example.com", DSNReturn: "HDRS"}
+		failed := []domain.RecipientStatus{{Recipient: "a@remote.test", Status: domain.RecipientFailed, Code: 550}}
+
+		data, _ := svc.buildDeliveryReport(item, original, domain.RecipientFailed, failed, time.Now())
+		if !strings.Contains(string(data), "text/rfc822-headers") || strings.Contains(string(data), "body text") {
+			t.Errorf("expected only the original header:\n%s", data)
+		}
+	})
+
+	t.Run("success reports mark relayed recipients", func(t *testing.T) {
+		item := &domain.QueueItem{Sender: "sender@example.com"}
+		delivered := []domain.RecipientStatus{
+			{Recipient: "local@example.com", Status: domain.RecipientDelivered, Code: 250},
+			{Recipient: "far@remote.test", Status: domain.RecipientDelivered, Code: 250, Relayed: true},
+		}
+
+		data, _ := svc.buildDeliveryReport(item, original, domain.RecipientDelivered, delivered, time.Now())
+		report := string(data)
+		for _, want := range []string{"Action: delivered", "Action: relayed", "Status: 2.0.0", "text/rfc822-headers"} {
+			if !strings.Contains(report, want) {
+				t.Errorf("report missing %q:\n%s", want, report)
+			}
+		}
+		if strings.Contains(report, "body text") {
+			t.Error("success reports must not return the message body")
+		}
+	})
+}
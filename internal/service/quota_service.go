@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/repository"
+)
+
+// DefaultQuotaReconcileInterval is how often stored usage is recomputed from the messages
+const DefaultQuotaReconcileInterval = 6 * time.Hour
+
+// ErrOverQuota is returned when storing messages would exceed a user's quota
+var ErrOverQuota = errors.New("over quota")
+
+// Quota is a user's usage and limits for the RFC 9208 STORAGE and MESSAGE resources
+// A limit of 0 is unlimited.
+type Quota struct {
+	Storage      int64 // bytes
+	StorageLimit int64
+	Messages     int64
+	MessageLimit int64
+}
+
+// QuotaService reports and enforces per-user storage quotas
+// User.UsedQuota is kept current by the message repository in the same
+// transaction as each stored or deleted message; Reconcile repairs any drift.
+type QuotaService struct {
+	userRepo    repository.UserRepository
+	messageRepo repository.MessageRepository
+	logger      *zap.Logger
+}
+
+// NewQuotaService creates a new quota service
+func NewQuotaService(userRepo repository.UserRepository, messageRepo repository.MessageRepository, logger *zap.Logger) *QuotaService {
+	return &QuotaService{
+		userRepo:    userRepo,
+		messageRepo: messageRepo,
+		logger:      logger,
+	}
+}
+
+// Get returns a user's current usage and limits
+func (s *QuotaService) Get(userID int64) (*Quota, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	messages, _, err := s.messageRepo.Usage(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Quota{
+		Storage:      user.UsedQuota,
+		StorageLimit: user.Quota,
+		Messages:     messages,
+		MessageLimit: user.MessageQuota,
+	}, nil
+}
+
+// Check returns ErrOverQuota if adding messages totalling size bytes would
+// exceed the user's storage or message limit
+func (s *QuotaService) Check(userID, size, messages int64) error {
+	quota, err := s.Get(userID)
+	if err != nil {
+		return err
+	}
+	if quota.StorageLimit > 0 && quota.Storage+size > quota.StorageLimit {
+		return fmt.Errorf("%w: storage %d of %d bytes", ErrOverQuota, quota.Storage, quota.StorageLimit)
+	}
+	if quota.MessageLimit > 0 && quota.Messages+messages > quota.MessageLimit {
+		return fmt.Errorf("%w: %d of %d messages", ErrOverQuota, quota.Messages, quota.MessageLimit)
+	}
+	return nil
+}
+
+// Reconcile recomputes every user's storage usage from their messages and
+// returns the number of users whose usage was corrected
+func (s *QuotaService) Reconcile() (int, error) {
+	corrected, err := s.userRepo.ReconcileQuota()
+	if err != nil {
+		return 0, err
+	}
+	for userID, size := range corrected {
+		s.logger.Warn("corrected used quota",
+			zap.Int64("user_id", userID),
+			zap.Int64("actual", size),
+		)
+	}
+	return len(corrected), nil
+}
+
+// RunReconciler reconciles usage at startup and then every interval until ctx is cancelled
+func (s *QuotaService) RunReconciler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if corrected, err := s.Reconcile(); err != nil {
+			s.logger.Error("quota reconciliation failed", zap.Error(err))
+		} else {
+			s.logger.Debug("quota reconciliation complete", zap.Int("corrected", corrected))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
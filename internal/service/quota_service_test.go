@@ -0,0 +1,75 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+// quotaUserRepository serves a fixed set of users and usage corrections
+type quotaUserRepository struct {
+	mockUserRepository
+	users      []*domain.User
+	reconciled map[int64]int64
+}
+
+func (m *quotaUserRepository) GetByID(id int64) (*domain.User, error) {
+	for _, u := range m.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+func (m *quotaUserRepository) ReconcileQuota() (map[int64]int64, error) {
+	return m.reconciled, nil
+}
+
+func TestQuotaService_Check(t *testing.T) {
+	userRepo := &quotaUserRepository{users: []*domain.User{
+		{ID: 1, Quota: 1000, UsedQuota: 900, MessageQuota: 10},
+		{ID: 2, UsedQuota: 5000},
+	}}
+	messageRepo := &mockMessageRepository{usageFunc: func(userID int64) (int64, int64, error) {
+		return 9, 900, nil
+	}}
+	svc := NewQuotaService(userRepo, messageRepo, zap.NewNop())
+
+	quota, err := svc.Get(1)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if *quota != (Quota{Storage: 900, StorageLimit: 1000, Messages: 9, MessageLimit: 10}) {
+		t.Errorf("unexpected quota %+v", quota)
+	}
+
+	if err := svc.Check(1, 100, 1); err != nil {
+		t.Errorf("expected message filling the quota to be allowed, got %v", err)
+	}
+	if err := svc.Check(1, 101, 1); !errors.Is(err, ErrOverQuota) {
+		t.Errorf("expected storage limit to be enforced, got %v", err)
+	}
+	if err := svc.Check(1, 10, 2); !errors.Is(err, ErrOverQuota) {
+		t.Errorf("expected message limit to be enforced, got %v", err)
+	}
+	if err := svc.Check(2, 1<<30, 1000); err != nil {
+		t.Errorf("expected unlimited user to be allowed, got %v", err)
+	}
+}
+
+func TestQuotaService_Reconcile(t *testing.T) {
+	userRepo := &quotaUserRepository{reconciled: map[int64]int64{2: 900}}
+	svc := NewQuotaService(userRepo, &mockMessageRepository{}, zap.NewNop())
+
+	corrected, err := svc.Reconcile()
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if corrected != 1 {
+		t.Errorf("expected one user to be corrected, got %d", corrected)
+	}
+}
@@ -0,0 +1,288 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/repository"
+)
+
+// searchBatchSize is how many messages are indexed per batch when backfilling
+const searchBatchSize = 100
+
+// ErrInvalidSearchQuery is returned for search queries that cannot be parsed
+var ErrInvalidSearchQuery = errors.New("invalid search query")
+
+// SearchService maintains and queries the full-text message index
+// Messages are indexed as they are stored and leave the index with their
+// row. Messages stored before the index existed are indexed in the
+// background; until that finishes Ready reports false and searches should
+// fall back to scanning messages.
+type SearchService struct {
+	repo     repository.SearchRepository
+	messages *MessageService
+	logger   *zap.Logger
+	ready    atomic.Bool
+}
+
+// NewSearchService creates a new search service
+func NewSearchService(repo repository.SearchRepository, messages *MessageService, logger *zap.Logger) *SearchService {
+	return &SearchService{
+		repo:     repo,
+		messages: messages,
+		logger:   logger,
+	}
+}
+
+// Ready reports whether every stored message has been indexed
+func (s *SearchService) Ready() bool {
+	return s.ready.Load()
+}
+
+// Index adds a stored message to the index
+// Failures are logged rather than returned: a message that could not be
+// indexed is still delivered, and the next rebuild indexes it.
+func (s *SearchService) Index(msg *domain.Message, data []byte) {
+	doc, err := extractSearchDocument(data)
+	if err != nil {
+		s.logger.Debug("failed to extract message text",
+			zap.Int64("message_id", msg.ID),
+			zap.Error(err),
+		)
+		// Index the stored header fields so the message is not retried on every backfill
+		doc = &domain.SearchDocument{
+			From:    msg.From,
+			To:      strings.Join([]string{msg.To, msg.CC, msg.BCC}, " "),
+			Subject: msg.Subject,
+		}
+	}
+	doc.MessageID = msg.ID
+
+	if err := s.repo.Index(doc); err != nil {
+		s.logger.Warn("failed to index message",
+			zap.Int64("message_id", msg.ID),
+			zap.Error(err),
+		)
+	}
+}
+
+// Copy indexes a copied message with the text of its original
+func (s *SearchService) Copy(fromID, toID int64) {
+	if err := s.repo.Copy(fromID, toID); err != nil {
+		s.logger.Warn("failed to index copied message",
+			zap.Int64("message_id", toID),
+			zap.Error(err),
+		)
+	}
+}
+
+// Search returns the IDs of the messages matching q, newest first
+func (s *SearchService) Search(q *domain.SearchQuery) ([]int64, error) {
+	return s.repo.Search(q)
+}
+
+// Query parses a search string and returns up to limit matching message IDs
+// A zero mailboxID searches all of the user's mailboxes.
+func (s *SearchService) Query(userID, mailboxID int64, query string, limit int) ([]int64, error) {
+	q, err := ParseSearchQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	q.UserID = userID
+	q.MailboxID = mailboxID
+	q.Limit = limit
+	return s.repo.Search(q)
+}
+
+// RunIndexer enables the FTS5 index and indexes the messages stored before it
+// It returns when the backfill is done or ctx is cancelled.
+func (s *SearchService) RunIndexer(ctx context.Context) {
+	if err := s.enable(); err != nil {
+		s.logger.Error("failed to enable search index", zap.Error(err))
+		return
+	}
+
+	indexed, err := s.backfill(ctx, s.repo.Unindexed)
+	if err != nil {
+		s.logger.Error("search index backfill failed", zap.Error(err))
+		return
+	}
+	s.ready.Store(true)
+	if indexed > 0 {
+		s.logger.Info("search index backfill complete", zap.Int("indexed", indexed))
+	}
+}
+
+// Rebuild indexes every message again
+// Entries are replaced in place, so a server sharing the database keeps
+// searching the existing entries while the rebuild runs.
+func (s *SearchService) Rebuild(ctx context.Context) (int, error) {
+	if err := s.enable(); err != nil {
+		return 0, err
+	}
+	reindexed, err := s.backfill(ctx, s.repo.Indexed)
+	if err != nil {
+		return reindexed, err
+	}
+	indexed, err := s.backfill(ctx, s.repo.Unindexed)
+	return reindexed + indexed, err
+}
+
+// enable switches the repository to FTS5 when SQLite supports it
+func (s *SearchService) enable() error {
+	fts, err := s.repo.EnableFTS()
+	if err != nil {
+		return err
+	}
+	if !fts {
+		s.logger.Warn("SQLite was built without FTS5, search scans the index table; build with -tags sqlite_fts5")
+	}
+	return nil
+}
+
+// backfill indexes the messages returned by list in batches
+func (s *SearchService) backfill(ctx context.Context, list func(afterID int64, limit int) ([]int64, error)) (int, error) {
+	var indexed int
+	var after int64
+	for {
+		ids, err := list(after, searchBatchSize)
+		if err != nil {
+			return indexed, err
+		}
+		if len(ids) == 0 {
+			return indexed, nil
+		}
+
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return indexed, err
+			}
+			after = id
+			msg, err := s.messages.GetByID(id)
+			if err != nil {
+				// Deleted since it was listed, or its file is gone
+				s.logger.Debug("failed to load message for indexing",
+					zap.Int64("message_id", id),
+					zap.Error(err),
+				)
+				continue
+			}
+			s.Index(msg, msg.Content)
+			indexed++
+		}
+	}
+}
+
+// searchDateLayouts are the accepted before: and after: date formats
+var searchDateLayouts = []string{"2006-01-02", "2006/01/02"}
+
+// ParseSearchQuery parses a search string into a query
+// Words and "quoted phrases" match anywhere in a message. The operators
+// from:, to:, subject: and body: limit a term to a field, has:attachment
+// matches messages with attachments, and after: and before: take a date
+// (YYYY-MM-DD, in UTC), with after: inclusive and before: exclusive.
+// Unknown operators are searched as text.
+func ParseSearchQuery(query string) (*domain.SearchQuery, error) {
+	q := &domain.SearchQuery{}
+	empty := true
+	for _, token := range splitSearchQuery(query) {
+		key, value, found := strings.Cut(token, ":")
+		if !found || strings.HasPrefix(key, `"`) {
+			if term := unquoteSearchTerm(token); term != "" {
+				q.Text = append(q.Text, term)
+				empty = false
+			}
+			continue
+		}
+		value = unquoteSearchTerm(value)
+
+		switch strings.ToLower(key) {
+		case "from":
+			q.From = append(q.From, value)
+		case "to":
+			q.To = append(q.To, value)
+		case "subject":
+			q.Subject = append(q.Subject, value)
+		case "body":
+			q.Body = append(q.Body, value)
+		case "has":
+			if v := strings.ToLower(value); v != "attachment" && v != "attachments" {
+				return nil, fmt.Errorf("%w: unknown has:%s", ErrInvalidSearchQuery, value)
+			}
+			q.HasAttachment = true
+		case "after", "before":
+			date, err := parseSearchDate(value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s:%s is not a date", ErrInvalidSearchQuery, key, value)
+			}
+			if strings.ToLower(key) == "after" {
+				q.After = date
+			} else {
+				q.Before = date
+			}
+		default:
+			q.Text = append(q.Text, unquoteSearchTerm(token))
+			empty = false
+			continue
+		}
+		if value == "" {
+			return nil, fmt.Errorf("%w: %s: needs a value", ErrInvalidSearchQuery, key)
+		}
+		empty = false
+	}
+
+	if empty {
+		return nil, fmt.Errorf("%w: empty query", ErrInvalidSearchQuery)
+	}
+	return q, nil
+}
+
+// splitSearchQuery splits a query on spaces outside double quotes
+func splitSearchQuery(query string) []string {
+	var tokens []string
+	var token strings.Builder
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			token.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if token.Len() > 0 {
+				tokens = append(tokens, token.String())
+				token.Reset()
+			}
+		default:
+			token.WriteRune(r)
+		}
+	}
+	if token.Len() > 0 {
+		tokens = append(tokens, token.String())
+	}
+	return tokens
+}
+
+// unquoteSearchTerm removes the double quotes around a phrase
+func unquoteSearchTerm(term string) string {
+	return strings.TrimSpace(strings.ReplaceAll(term, `"`, ""))
+}
+
+// parseSearchDate parses a date in one of searchDateLayouts as midnight UTC
+func parseSearchDate(value string) (time.Time, error) {
+	var err error
+	for _, layout := range searchDateLayouts {
+		var date time.Time
+		if date, err = time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, err
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	q, err := ParseSearchQuery(`invoice "due date" from:alice@example.com subject:"March report" to:bob has:attachment after:2024-01-01 before:2024/02/01 http://example.com`)
+	if err != nil {
+		t.Fatalf("ParseSearchQuery failed: %v", err)
+	}
+	if strings.Join(q.Text, "|") != "invoice|due date|http://example.com" {
+		t.Errorf("Text = %q", q.Text)
+	}
+	if len(q.From) != 1 || q.From[0] != "alice@example.com" {
+		t.Errorf("From = %q", q.From)
+	}
+	if len(q.Subject) != 1 || q.Subject[0] != "March report" {
+		t.Errorf("Subject = %q", q.Subject)
+	}
+	if len(q.To) != 1 || q.To[0] != "bob" {
+		t.Errorf("To = %q", q.To)
+	}
+	if !q.HasAttachment {
+		t.Error("expected has:attachment")
+	}
+	if !q.After.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) || !q.Before.Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("dates = %v, %v", q.After, q.Before)
+	}
+
+	for _, bad := range []string{"", `  ""  `, "has:pictures", "after:yesterday", "from:"} {
+		if _, err := ParseSearchQuery(bad); !errors.Is(err, ErrInvalidSearchQuery) {
+			t.Errorf("ParseSearchQuery(%q) = %v, want ErrInvalidSearchQuery", bad, err)
+		}
+	}
+}
+
+func TestExtractSearchDocument(t *testing.T) {
+	docx := zipFile(t, map[string]string{
+		"word/document.xml": `<w:document><w:body><w:p><w:r><w:t>Quar</w:t></w:r><w:r><w:t>terly</w:t></w:r></w:p><w:p><w:r><w:t>Forecast</w:t></w:r></w:p></w:body></w:document>`,
+	})
+
+	var pdf bytes.Buffer
+	zw := zlib.NewWriter(&pdf)
+	zw.Write([]byte("BT /F1 12 Tf 72 712 Td [(Signed) -250 (Cont) 10 (ract)] TJ ET"))
+	zw.Close()
+	pdfData := "%PDF-1.4\n1 0 obj << /Length 10 /Filter /FlateDecode >>\nstream\n" + pdf.String() + "\nendstream\nendobj\n"
+
+	raw := "From: Alice Example <alice@example.com>\r\n" +
+		"To: bob@example.com\r\n" +
+		"Cc: carol@example.com\r\n" +
+		"Subject: =?UTF-8?Q?R=C3=A9sum=C3=A9?=\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=outer\r\n" +
+		"\r\n" +
+		"--outer\r\n" +
+		"Content-Type: multipart/alternative; boundary=inner\r\n" +
+		"\r\n" +
+		"--inner\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"Plain body\r\n" +
+		"--inner\r\n" +
+		"Content-Type: text/html; charset=utf-8\r\n" +
+		"\r\n" +
+		"<html><head><style>p { color: red }</style></head><body><p>Caf&eacute; <b>menu</b></p><script>var hidden;</script></body></html>\r\n" +
+		"--inner--\r\n" +
+		"--outer\r\n" +
+		"Content-Type: application/octet-stream; name=plan.docx\r\n" +
+		"Content-Disposition: attachment; filename=plan.docx\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		base64.StdEncoding.EncodeToString(docx) + "\r\n" +
+		"--outer\r\n" +
+		"Content-Type: application/pdf\r\n" +
+		"Content-Disposition: attachment; filename=terms.pdf\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		base64.StdEncoding.EncodeToString([]byte(pdfData)) + "\r\n" +
+		"--outer--\r\n"
+
+	doc, err := extractSearchDocument([]byte(raw))
+	if err != nil {
+		t.Fatalf("extractSearchDocument failed: %v", err)
+	}
+
+	if doc.Subject != "Résumé" {
+		t.Errorf("Subject = %q", doc.Subject)
+	}
+	if !strings.Contains(doc.From, "Alice Example") || !strings.Contains(doc.From, "alice@example.com") {
+		t.Errorf("From = %q", doc.From)
+	}
+	if !strings.Contains(doc.To, "bob@example.com") || !strings.Contains(doc.To, "carol@example.com") {
+		t.Errorf("To = %q", doc.To)
+	}
+	if doc.Body != "Plain body Café menu" {
+		t.Errorf("Body = %q", doc.Body)
+	}
+	for _, want := range []string{"plan.docx", "Quarterly Forecast", "terms.pdf", "Signed Contract"} {
+		if !strings.Contains(doc.Attachments, want) {
+			t.Errorf("Attachments = %q, missing %q", doc.Attachments, want)
+		}
+	}
+	if !doc.HasAttachment {
+		t.Error("expected HasAttachment")
+	}
+}
+
+func zipFile(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
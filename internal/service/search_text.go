@@ -0,0 +1,447 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-message"
+	_ "github.com/emersion/go-message/charset"
+	"github.com/emersion/go-message/mail"
+	"golang.org/x/net/html"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+const (
+	// maxSearchPartSize is the most of one MIME part read for indexing
+	maxSearchPartSize = 16 * 1024 * 1024
+
+	// maxSearchText is the most text indexed per field of a message
+	maxSearchText = 1024 * 1024
+
+	// maxSearchDepth limits how deeply nested messages are indexed
+	maxSearchDepth = 4
+)
+
+// extensionTypes gives the media type of attachments sent as application/octet-stream
+var extensionTypes = map[string]string{
+	".txt":  "text/plain",
+	".csv":  "text/csv",
+	".ics":  "text/calendar",
+	".htm":  "text/html",
+	".html": "text/html",
+	".json": "application/json",
+	".xml":  "application/xml",
+	".eml":  "message/rfc822",
+	".pdf":  "application/pdf",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":  "application/vnd.oasis.opendocument.text",
+	".ods":  "application/vnd.oasis.opendocument.spreadsheet",
+	".odp":  "application/vnd.oasis.opendocument.presentation",
+}
+
+// searchText accumulates the text of a message for its SearchDocument
+type searchText struct {
+	body          strings.Builder
+	attachments   strings.Builder
+	hasAttachment bool
+}
+
+// extractSearchDocument extracts the searchable text of a raw message
+// Parts that cannot be decoded are skipped, so a damaged message still
+// indexes whatever text it has.
+func extractSearchDocument(data []byte) (*domain.SearchDocument, error) {
+	e, err := message.Read(bytes.NewReader(data))
+	if err != nil && !message.IsUnknownCharset(err) {
+		return nil, err
+	}
+
+	h := mail.Header{Header: e.Header}
+	subject, _ := h.Subject()
+	doc := &domain.SearchDocument{
+		From:    headerAddresses(h, "From", "Sender"),
+		To:      headerAddresses(h, "To", "Cc", "Bcc"),
+		Subject: subject,
+	}
+
+	var text searchText
+	text.walk(e, 0)
+	doc.Body = normalizeSearchText(text.body.String())
+	doc.Attachments = normalizeSearchText(text.attachments.String())
+	doc.HasAttachment = text.hasAttachment
+	return doc, nil
+}
+
+// headerAddresses returns the addresses of the given fields with their display names
+func headerAddresses(h mail.Header, keys ...string) string {
+	var out []string
+	for _, key := range keys {
+		addrs, err := h.AddressList(key)
+		if err != nil {
+			// Keep what the client wrote when the field does not parse
+			if text, err := h.Text(key); err == nil && text != "" {
+				out = append(out, text)
+			}
+			continue
+		}
+		for _, addr := range addrs {
+			out = append(out, addr.String())
+		}
+	}
+	return strings.Join(out, ", ")
+}
+
+// walk adds the text of an entity and its children
+func (t *searchText) walk(e *message.Entity, depth int) {
+	if mr := e.MultipartReader(); mr != nil {
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				return
+			}
+			t.walk(part, depth)
+		}
+	}
+
+	mediaType, params, _ := e.Header.ContentType()
+	disposition, dispParams, _ := e.Header.ContentDisposition()
+	filename := dispParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+
+	data, err := io.ReadAll(io.LimitReader(e.Body, maxSearchPartSize))
+	if err != nil && len(data) == 0 {
+		return
+	}
+
+	if disposition == "attachment" || filename != "" {
+		t.hasAttachment = true
+		if filename != "" {
+			t.attachments.WriteString(filename)
+			t.attachments.WriteByte(' ')
+		}
+		if mediaType == "" || mediaType == "application/octet-stream" {
+			if ext, ok := extensionTypes[strings.ToLower(path.Ext(filename))]; ok {
+				mediaType = ext
+			}
+		}
+		t.attachments.WriteString(t.partText(mediaType, data, depth))
+		t.attachments.WriteByte(' ')
+		return
+	}
+
+	switch {
+	case mediaType == "" || strings.HasPrefix(mediaType, "text/") || mediaType == "message/rfc822":
+		t.body.WriteString(t.partText(mediaType, data, depth))
+		t.body.WriteByte(' ')
+	}
+}
+
+// partText returns the text of a part's decoded content by media type
+func (t *searchText) partText(mediaType string, data []byte, depth int) string {
+	switch mediaType {
+	case "text/html":
+		return htmlText(data)
+	case "message/rfc822", "message/global":
+		if depth >= maxSearchDepth {
+			return ""
+		}
+		nested, err := message.Read(bytes.NewReader(data))
+		if err != nil && !message.IsUnknownCharset(err) {
+			return ""
+		}
+		h := mail.Header{Header: nested.Header}
+		subject, _ := h.Subject()
+		inner := &searchText{}
+		inner.walk(nested, depth+1)
+		if inner.hasAttachment {
+			t.hasAttachment = true
+		}
+		return strings.Join([]string{
+			headerAddresses(h, "From", "To", "Cc"), subject,
+			inner.body.String(), inner.attachments.String(),
+		}, " ")
+	case "application/pdf":
+		return pdfText(data)
+	case "application/vnd.openxmlformats-officedocument.wordprocessingml.document":
+		return zipXMLText(data, "word/document.xml", "word/header*.xml", "word/footer*.xml")
+	case "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":
+		return zipXMLText(data, "xl/sharedStrings.xml")
+	case "application/vnd.openxmlformats-officedocument.presentationml.presentation":
+		return zipXMLText(data, "ppt/slides/slide*.xml")
+	case "application/vnd.oasis.opendocument.text",
+		"application/vnd.oasis.opendocument.spreadsheet",
+		"application/vnd.oasis.opendocument.presentation":
+		return zipXMLText(data, "content.xml")
+	case "application/json", "application/xml", "application/ics", "":
+		return string(data)
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return string(data)
+	}
+	return ""
+}
+
+// normalizeSearchText collapses whitespace and caps the length of indexed text
+func normalizeSearchText(s string) string {
+	s = strings.Join(strings.Fields(strings.ToValidUTF8(s, " ")), " ")
+	if len(s) > maxSearchText {
+		s = strings.ToValidUTF8(s[:maxSearchText], "")
+	}
+	return s
+}
+
+// htmlInline are the elements that do not separate words
+var htmlInline = map[string]bool{
+	"a": true, "abbr": true, "b": true, "code": true, "em": true, "font": true, "i": true,
+	"mark": true, "s": true, "small": true, "span": true, "strong": true, "sub": true, "sup": true, "u": true,
+}
+
+// htmlText reduces HTML to its text, dropping scripts, styles and markup
+func htmlText(data []byte) string {
+	var b strings.Builder
+	z := html.NewTokenizer(bytes.NewReader(data))
+	skip := 0
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return b.String()
+		case html.TextToken:
+			if skip == 0 {
+				b.Write(z.Text())
+			}
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			switch tag := string(name); {
+			case tag == "script" || tag == "style" || tag == "head":
+				if tt == html.StartTagToken {
+					skip++
+				} else if tt == html.EndTagToken && skip > 0 {
+					skip--
+				}
+			case !htmlInline[tag]:
+				b.WriteByte(' ')
+			}
+		}
+	}
+}
+
+// zipXMLText returns the text of the XML files in a zip archive matching patterns
+// Office Open XML and OpenDocument files are zip archives of XML.
+func zipXMLText(data []byte, patterns ...string) string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return ""
+	}
+
+	var files []*zip.File
+	for _, f := range zr.File {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, f.Name); ok {
+				files = append(files, f)
+				break
+			}
+		}
+	}
+	// Slides and headers are numbered; keep them in order
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	var b strings.Builder
+	for _, f := range files {
+		rc, err := f.Open()
+		if err != nil {
+			continue
+		}
+		xmlText(io.LimitReader(rc, maxSearchPartSize), &b)
+		rc.Close()
+	}
+	return b.String()
+}
+
+// xmlBreaks are the document elements that end a word: paragraphs, cells,
+// tabs and line breaks in Office Open XML and OpenDocument
+var xmlBreaks = map[string]bool{
+	"p": true, "h": true, "br": true, "tab": true, "s": true, "si": true,
+	"tc": true, "line-break": true, "table-cell": true,
+}
+
+// xmlText writes the character data of an XML document to b
+func xmlText(r io.Reader, b *strings.Builder) {
+	d := xml.NewDecoder(r)
+	d.Strict = false
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return
+		}
+		switch tok := tok.(type) {
+		case xml.CharData:
+			b.Write(tok)
+		case xml.EndElement:
+			if xmlBreaks[tok.Name.Local] {
+				b.WriteByte(' ')
+			}
+		}
+	}
+}
+
+// pdfText returns the text shown by a PDF's content streams
+// Only literal strings in uncompressed or FlateDecode streams are read, which
+// covers text from most PDF writers using standard fonts; text in embedded
+// fonts with custom encodings is not recovered.
+func pdfText(data []byte) string {
+	var b strings.Builder
+	rest := data
+	for {
+		start := bytes.Index(rest, []byte("stream"))
+		if start < 0 {
+			break
+		}
+		dict := rest[:start]
+		if obj := bytes.LastIndex(dict, []byte("obj")); obj >= 0 {
+			dict = dict[obj:]
+		}
+		body := rest[start+len("stream"):]
+		body = bytes.TrimPrefix(body, []byte("\r"))
+		body = bytes.TrimPrefix(body, []byte("\n"))
+		end := bytes.Index(body, []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		content := body[:end]
+		rest = body[end+len("endstream"):]
+
+		switch {
+		case bytes.Contains(dict, []byte("/FlateDecode")):
+			zr, err := zlib.NewReader(bytes.NewReader(content))
+			if err != nil {
+				continue
+			}
+			// A truncated stream still yields the text before the damage
+			content, _ = io.ReadAll(io.LimitReader(zr, maxSearchPartSize))
+			zr.Close()
+		case bytes.Contains(dict, []byte("/Filter")):
+			// Images and other encodings carry no text we can read
+			continue
+		}
+		pdfContentText(content, &b)
+	}
+	return b.String()
+}
+
+// pdfContentText writes the strings shown between BT and ET operators to b
+// Large negative adjustments inside TJ arrays are word gaps.
+func pdfContentText(content []byte, b *strings.Builder) {
+	inText, inArray := false, false
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c == '(':
+			s, n := pdfLiteral(content[i:])
+			if inText {
+				b.WriteString(s)
+			}
+			i += n - 1
+		case c == '[':
+			inArray = true
+		case c == ']':
+			inArray = false
+		case inArray && (c == '-' || c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(content) && (content[j] == '.' || content[j] >= '0' && content[j] <= '9') {
+				j++
+			}
+			if c == '-' && j-i > 3 && content[i+1] != '.' {
+				// At least -100 in thousandths of an em
+				b.WriteByte(' ')
+			}
+			i = j - 1
+		case pdfOperator(content, i, "BT"):
+			inText = true
+		case pdfOperator(content, i, "ET"):
+			inText = false
+			b.WriteByte(' ')
+		case pdfOperator(content, i, "Td"), pdfOperator(content, i, "TD"), pdfOperator(content, i, "T*"),
+			pdfOperator(content, i, "Tj"), pdfOperator(content, i, "TJ"), c == '\'' || c == '"':
+			if inText {
+				b.WriteByte(' ')
+			}
+		}
+	}
+}
+
+// pdfOperator reports whether the operator op starts at content[i]
+func pdfOperator(content []byte, i int, op string) bool {
+	if !bytes.HasPrefix(content[i:], []byte(op)) {
+		return false
+	}
+	before := i == 0 || pdfDelimiter(content[i-1])
+	after := i+len(op) == len(content) || pdfDelimiter(content[i+len(op)])
+	return before && after
+}
+
+// pdfDelimiter reports whether c is PDF whitespace or a delimiter
+func pdfDelimiter(c byte) bool {
+	return strings.IndexByte(" \t\r\n\f\x00()<>[]{}/%", c) >= 0
+}
+
+// pdfLiteral decodes the literal string at the start of s and returns it
+// with the number of bytes it spans
+// Bytes are read as Latin-1, which matches the standard encodings for ASCII
+// and most accented letters.
+func pdfLiteral(s []byte) (string, int) {
+	var out []rune
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '(':
+			if depth > 0 {
+				out = append(out, '(')
+			}
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return string(out), i + 1
+			}
+			out = append(out, ')')
+		case '\\':
+			i++
+			if i == len(s) {
+				return string(out), i
+			}
+			switch e := s[i]; e {
+			case 'n', 'r', 't', 'f', 'b':
+				out = append(out, ' ')
+			case '\r', '\n':
+				// Line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					v := 0
+					j := i
+					for ; j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7'; j++ {
+						v = v*8 + int(s[j]-'0')
+					}
+					out = append(out, rune(v&0xff))
+					i = j - 1
+				} else {
+					out = append(out, rune(e))
+				}
+			}
+		default:
+			out = append(out, rune(c))
+		}
+	}
+	return string(out), len(s)
+}
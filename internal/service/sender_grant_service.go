@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/repository"
+)
+
+// ErrInvalidSenderGrant is returned when a grant has an unusable address or type
+var ErrInvalidSenderGrant = errors.New("invalid sender grant")
+
+// SenderGrantService provides business logic for send-as and send-on-behalf grants
+type SenderGrantService struct {
+	repo     repository.SenderGrantRepository
+	userRepo repository.UserRepository
+}
+
+// NewSenderGrantService creates a new sender grant service
+func NewSenderGrantService(repo repository.SenderGrantRepository, userRepo repository.UserRepository) *SenderGrantService {
+	return &SenderGrantService{
+		repo:     repo,
+		userRepo: userRepo,
+	}
+}
+
+// Create grants a user the right to send from an address or "@domain"
+func (s *SenderGrantService) Create(ctx context.Context, grant *domain.SenderGrant) error {
+	if grant.GrantType == "" {
+		grant.GrantType = domain.SenderGrantSendAs
+	}
+	if grant.GrantType != domain.SenderGrantSendAs && grant.GrantType != domain.SenderGrantSendOnBehalf {
+		return fmt.Errorf("%w: unknown grant type %q", ErrInvalidSenderGrant, grant.GrantType)
+	}
+
+	address := strings.TrimSpace(grant.Address)
+	switch at := strings.LastIndex(address, "@"); {
+	case at < 0 || at == len(address)-1:
+		return fmt.Errorf("%w: address must be user@domain or @domain", ErrInvalidSenderGrant)
+	case at == 0:
+		grant.Address = "@" + NormalizeDomain(address[1:])
+	default:
+		grant.Address = NormalizeAddress(address)
+	}
+
+	if _, err := s.userRepo.GetByID(grant.UserID); err != nil {
+		return fmt.Errorf("failed to load user: %w", err)
+	}
+
+	return s.repo.Create(grant)
+}
+
+// ListByUser retrieves all grants held by a user
+func (s *SenderGrantService) ListByUser(ctx context.Context, userID int64) ([]*domain.SenderGrant, error) {
+	return s.repo.ListByUser(userID)
+}
+
+// Delete revokes a grant held by a user
+func (s *SenderGrantService) Delete(ctx context.Context, userID, id int64) error {
+	grant, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if grant.UserID != userID {
+		return fmt.Errorf("sender grant %d does not belong to user %d", id, userID)
+	}
+	return s.repo.Delete(id)
+}
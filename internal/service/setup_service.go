@@ -161,18 +161,19 @@ func (s *SetupService) CreateAdminUser(ctx context.Context, req *AdminUserReques
 
 	// Create admin user
 	user := &domain.User{
-		Email:        req.Email,
-		DomainID:     domainID,
-		PasswordHash: string(passwordHash),
-		FullName:     req.FullName,
-		DisplayName:  req.FullName,
-		Role:         "admin",
-		Quota:        1073741824, // 1GB default
-		UsedQuota:    0,
-		Status:       "active",
-		AuthMethod:   "password",
-		TOTPEnabled:  false,
-		Language:     "en",
+		Email:           req.Email,
+		DomainID:        domainID,
+		PasswordHash:    string(passwordHash),
+		FullName:        req.FullName,
+		DisplayName:     req.FullName,
+		Role:            "admin",
+		Quota:           1073741824, // 1GB default
+		UsedQuota:       0,
+		Status:          "active",
+		AuthMethod:      "password",
+		TOTPEnabled:     false,
+		Language:        "en",
+		ForwardKeepCopy: true,
 	}
 
 	if err := s.userRepo.Create(user); err != nil {
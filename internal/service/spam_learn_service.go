@@ -0,0 +1,107 @@
+package service
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/repository"
+)
+
+// SpamTrainer is a spam filter that learns from messages users classify
+type SpamTrainer interface {
+	Learn(message []byte, isSpam bool) error
+}
+
+// SpamLearnService trains the spam filter when users move messages into or
+// out of their Junk mailbox
+type SpamLearnService struct {
+	trainer    SpamTrainer
+	userRepo   repository.UserRepository
+	domainRepo repository.DomainRepository
+	logger     *zap.Logger
+}
+
+// NewSpamLearnService creates a new spam learning service
+func NewSpamLearnService(
+	trainer SpamTrainer,
+	userRepo repository.UserRepository,
+	domainRepo repository.DomainRepository,
+	logger *zap.Logger,
+) *SpamLearnService {
+	return &SpamLearnService{
+		trainer:    trainer,
+		userRepo:   userRepo,
+		domainRepo: domainRepo,
+		logger:     logger,
+	}
+}
+
+// learnVerdict reports whether moving a message from source to target
+// classifies it, and as what
+// Moving into Junk marks spam; moving out of Junk marks ham unless the
+// message is being thrown away.
+func learnVerdict(source, target *domain.Mailbox) (isSpam, ok bool) {
+	fromJunk := source.SpecialUse == "\\Junk"
+	toJunk := target.SpecialUse == "\\Junk"
+	switch {
+	case toJunk && !fromJunk:
+		return true, true
+	case fromJunk && !toJunk && target.SpecialUse != "\\Trash":
+		return false, true
+	}
+	return false, false
+}
+
+// Learn trains the filter with a message its owner classified, in the
+// background so mailbox operations don't wait on the filter
+func (s *SpamLearnService) Learn(userID int64, content []byte, isSpam bool) {
+	go func() {
+		if err := s.learn(userID, content, isSpam); err != nil {
+			s.logger.Warn("spam learning failed",
+				zap.Int64("user_id", userID),
+				zap.Bool("spam", isSpam),
+				zap.Error(err),
+			)
+		}
+	}()
+}
+
+// learn trains the filter if the user's domain has learning enabled for rspamd
+func (s *SpamLearnService) learn(userID int64, content []byte, isSpam bool) error {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	dom, err := s.domainRepo.GetByID(user.DomainID)
+	if err != nil {
+		return fmt.Errorf("failed to get domain: %w", err)
+	}
+	if dom == nil || !dom.SpamEnabled || !dom.SpamLearningEnabled || dom.SpamBackend != domain.SpamBackendRspamd {
+		return nil
+	}
+	return s.trainer.Learn(content, isSpam)
+}
+
+// learnSpam trains the spam filter when msg, now in target, was moved or
+// copied there from the mailbox with ID source
+func (s *MessageService) learnSpam(msg *domain.Message, source int64, target *domain.Mailbox) {
+	if s.spamLearn == nil || s.mailboxService == nil {
+		return
+	}
+	from, err := s.mailboxService.GetByID(source)
+	if err != nil {
+		return
+	}
+	isSpam, ok := learnVerdict(from, target)
+	if !ok {
+		return
+	}
+	loaded, err := s.GetByID(msg.ID)
+	if err != nil {
+		s.logger.Warn("failed to load message for spam learning", zap.Int64("message_id", msg.ID), zap.Error(err))
+		return
+	}
+	s.spamLearn.Learn(msg.UserID, loaded.Content, isSpam)
+}
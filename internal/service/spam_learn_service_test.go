@@ -0,0 +1,123 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+// fakeSpamTrainer records the messages it is trained with
+type fakeSpamTrainer struct {
+	learned chan bool
+}
+
+func (f *fakeSpamTrainer) Learn(message []byte, isSpam bool) error {
+	f.learned <- isSpam
+	return nil
+}
+
+// spamDomainRepository serves a single domain for every lookup
+type spamDomainRepository struct {
+	mockDomainRepository
+	domain *domain.Domain
+}
+
+func (m *spamDomainRepository) GetByID(id int64) (*domain.Domain, error) { return m.domain, nil }
+
+func newSpamLearnService(trainer SpamTrainer, dom *domain.Domain) *SpamLearnService {
+	users := &mockUserRepository{
+		getByIDFunc: func(id int64) (*domain.User, error) {
+			return &domain.User{ID: id, DomainID: 1}, nil
+		},
+	}
+	return NewSpamLearnService(trainer, users, &spamDomainRepository{domain: dom}, zap.NewNop())
+}
+
+func TestMessageService_Move_LearnsSpam(t *testing.T) {
+	mailboxes := &mockMailboxRepository{mailboxes: []*domain.Mailbox{
+		{ID: 1, UserID: 1, Name: "INBOX"},
+		{ID: 2, UserID: 1, Name: "Junk", SpecialUse: "\\Junk"},
+		{ID: 3, UserID: 1, Name: "Trash", SpecialUse: "\\Trash"},
+		{ID: 4, UserID: 1, Name: "Archive", SpecialUse: "\\Archive"},
+	}}
+
+	tests := []struct {
+		name   string
+		from   int64
+		to     int64
+		learn  bool
+		isSpam bool
+	}{
+		{"into junk learns spam", 1, 2, true, true},
+		{"out of junk learns ham", 2, 1, true, false},
+		{"junk to trash is not learned", 2, 3, false, false},
+		{"between other mailboxes is not learned", 1, 4, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mockMessageRepository{
+				getByIDFunc: func(id int64) (*domain.Message, error) {
+					return &domain.Message{ID: id, UserID: 1, MailboxID: tt.from, StorageType: "blob", Content: []byte("message")}, nil
+				},
+			}
+			trainer := &fakeSpamTrainer{learned: make(chan bool, 1)}
+			svc := NewMessageService(repo, t.TempDir(), zap.NewNop())
+			svc.SetMailboxService(NewMailboxService(mailboxes, zap.NewNop()))
+			svc.SetSpamLearnService(newSpamLearnService(trainer, &domain.Domain{
+				SpamEnabled:         true,
+				SpamLearningEnabled: true,
+				SpamBackend:         domain.SpamBackendRspamd,
+			}))
+
+			target, _ := mailboxes.GetByID(tt.to)
+			if err := svc.Move(1, target, 1); err != nil {
+				t.Fatalf("Move failed: %v", err)
+			}
+
+			if !tt.learn {
+				select {
+				case isSpam := <-trainer.learned:
+					t.Fatalf("unexpected learn, spam=%v", isSpam)
+				default:
+				}
+				return
+			}
+			select {
+			case isSpam := <-trainer.learned:
+				if isSpam != tt.isSpam {
+					t.Errorf("learned spam=%v, want %v", isSpam, tt.isSpam)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("message was not learned")
+			}
+		})
+	}
+}
+
+func TestSpamLearnService_LearnRequiresRspamdLearning(t *testing.T) {
+	tests := []struct {
+		name   string
+		domain *domain.Domain
+		learn  bool
+	}{
+		{"rspamd with learning", &domain.Domain{SpamEnabled: true, SpamLearningEnabled: true, SpamBackend: domain.SpamBackendRspamd}, true},
+		{"learning disabled", &domain.Domain{SpamEnabled: true, SpamBackend: domain.SpamBackendRspamd}, false},
+		{"spam filtering disabled", &domain.Domain{SpamLearningEnabled: true, SpamBackend: domain.SpamBackendRspamd}, false},
+		{"spamassassin backend", &domain.Domain{SpamEnabled: true, SpamLearningEnabled: true, SpamBackend: domain.SpamBackendSpamAssassin}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trainer := &fakeSpamTrainer{learned: make(chan bool, 1)}
+			svc := newSpamLearnService(trainer, tt.domain)
+			if err := svc.learn(1, []byte("message"), true); err != nil {
+				t.Fatalf("learn failed: %v", err)
+			}
+			if got := len(trainer.learned) == 1; got != tt.learn {
+				t.Errorf("learned = %v, want %v", got, tt.learn)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+)
+
+// ErrMessageTooLarge is returned when a message exceeds the configured size limit
+var ErrMessageTooLarge = errors.New("message exceeds maximum size")
+
+// Spool holds an incoming message on disk while it is checked and queued
+// Scanners read it through independent readers so the message is never held in memory.
+type Spool struct {
+	file     *os.File
+	size     int64
+	detached bool
+}
+
+// NewSpool copies r into a temporary file in dir
+// A limit greater than zero rejects messages larger than limit bytes.
+func NewSpool(dir string, r io.Reader, limit int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	f, err := os.CreateTemp(dir, "data-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %w", err)
+	}
+	sp := &Spool{file: f}
+
+	src := r
+	if limit > 0 {
+		src = io.LimitReader(r, limit+1)
+	}
+
+	n, err := io.Copy(f, src)
+	if err != nil {
+		sp.Close()
+		return nil, fmt.Errorf("failed to spool message: %w", err)
+	}
+	if limit > 0 && n > limit {
+		sp.Close()
+		return nil, ErrMessageTooLarge
+	}
+
+	if err := f.Sync(); err != nil {
+		sp.Close()
+		return nil, fmt.Errorf("failed to sync spool file: %w", err)
+	}
+
+	sp.size = n
+	return sp, nil
+}
+
+// Reader returns a new reader positioned at the start of the message
+func (s *Spool) Reader() io.Reader {
+	return io.NewSectionReader(s.file, 0, s.size)
+}
+
+// Size returns the message size in bytes
+func (s *Spool) Size() int64 {
+	return s.size
+}
+
+// Header parses the message header fields
+func (s *Spool) Header() (textproto.MIMEHeader, error) {
+	header, err := textproto.NewReader(bufio.NewReader(s.Reader())).ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return header, nil
+}
+
+// Prepend rewrites the spool with header placed before the message
+func (s *Spool) Prepend(header string) error {
+	return s.Rewrite(func(w io.Writer) error {
+		if _, err := io.WriteString(w, header); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, s.Reader())
+		return err
+	})
+}
+
+// Rewrite replaces the spool contents with the output of write
+// write may read the current contents through Reader while it runs.
+func (s *Spool) Rewrite(write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(s.file.Name()), "data-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create spool file: %w", err)
+	}
+
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	s.Close()
+	s.file = f
+	s.size = info.Size()
+	return nil
+}
+
+// Detach closes the spool file and hands ownership of it to the caller
+func (s *Spool) Detach() string {
+	s.detached = true
+	s.file.Close()
+	return s.file.Name()
+}
+
+// Close releases the spool file, removing it unless it was detached
+func (s *Spool) Close() error {
+	err := s.file.Close()
+	if !s.detached {
+		os.Remove(s.file.Name())
+	}
+	return err
+}
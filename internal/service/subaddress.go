@@ -0,0 +1,79 @@
+package service
+
+import (
+	"errors"
+	"strings"
+)
+
+// DefaultSubaddressSeparator separates a local part from its tag (user+tag@domain)
+const DefaultSubaddressSeparator = "+"
+
+// subaddressSeparatorChars are the characters a domain may use as subaddress separators
+const subaddressSeparatorChars = "+-_=#~"
+
+// ErrInvalidSubaddressSeparator is returned for separators outside subaddressSeparatorChars
+var ErrInvalidSubaddressSeparator = errors.New("invalid subaddress separator")
+
+// ValidateSubaddressSeparator checks a domain's subaddress separator setting
+// Each character of separators is a separator on its own; an empty setting
+// disables subaddressing.
+func ValidateSubaddressSeparator(separators string) error {
+	for _, r := range separators {
+		if !strings.ContainsRune(subaddressSeparatorChars, r) {
+			return ErrInvalidSubaddressSeparator
+		}
+	}
+	return nil
+}
+
+// SplitSubaddress splits user+tag@domain into user@domain and tag
+// The local part is cut at the first of the separator characters; ok is
+// false if the address has no subaddress.
+func SplitSubaddress(addr, separators string) (base, tag string, ok bool) {
+	if separators == "" {
+		return "", "", false
+	}
+	at := strings.LastIndex(addr, "@")
+	if at < 0 {
+		return "", "", false
+	}
+	local, dom := addr[:at], addr[at:]
+
+	i := strings.IndexAny(local, separators)
+	if i <= 0 {
+		return "", "", false
+	}
+	return local[:i] + dom, local[i+1:], true
+}
+
+// maxSubaddressMailboxes is the number of mailboxes past which delivery stops
+// creating folders for new tags
+const maxSubaddressMailboxes = 100
+
+// systemMailboxNames are the folders a tag may not file mail into: the
+// default special-use mailboxes and the names common clients use for them
+var systemMailboxNames = []string{
+	"INBOX", "Archive", "Drafts", "Junk", "Outbox", "Sent", "Spam", "Trash",
+	"Deleted Items", "Deleted Messages", "Junk E-mail", "Sent Items", "Sent Messages",
+}
+
+// subaddressFolder returns the mailbox name for a subaddress tag
+// Tags that would address INBOX or a system mailbox, nest under another
+// mailbox or contain IMAP wildcards yield "" so the message is delivered to
+// INBOX.
+func subaddressFolder(tag string) string {
+	if tag == "" || strings.ContainsAny(tag, "/%*\\\"") {
+		return ""
+	}
+	for _, name := range systemMailboxNames {
+		if strings.EqualFold(tag, name) {
+			return ""
+		}
+	}
+	for _, r := range tag {
+		if r < 0x20 || r == 0x7f {
+			return ""
+		}
+	}
+	return tag
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+func TestSplitSubaddress(t *testing.T) {
+	tests := []struct {
+		addr, separators string
+		base, tag        string
+		ok               bool
+	}{
+		{"user+invoices@example.com", "+", "user@example.com", "invoices", true},
+		{"user-lists-go@example.com", "+-", "user@example.com", "lists-go", true},
+		{"user+@example.com", "+", "user@example.com", "", true},
+		{"user+invoices@example.com", "", "", "", false},
+		{"user@example.com", "+", "", "", false},
+		{"+tag@example.com", "+", "", "", false},
+	}
+	for _, tt := range tests {
+		base, tag, ok := SplitSubaddress(tt.addr, tt.separators)
+		if base != tt.base || tag != tt.tag || ok != tt.ok {
+			t.Errorf("SplitSubaddress(%q, %q) = %q, %q, %v; want %q, %q, %v",
+				tt.addr, tt.separators, base, tag, ok, tt.base, tt.tag, tt.ok)
+		}
+	}
+
+	if err := ValidateSubaddressSeparator("+-"); err != nil {
+		t.Errorf("expected +- to be valid, got %v", err)
+	}
+	if err := ValidateSubaddressSeparator("@"); !errors.Is(err, ErrInvalidSubaddressSeparator) {
+		t.Errorf("expected @ to be rejected, got %v", err)
+	}
+}
+
+// routingDomainRepository hosts example.com with subaddressing and a catch-all
+type routingDomainRepository struct {
+	mockDomainRepository
+}
+
+func (m *routingDomainRepository) GetByName(name string) (*domain.Domain, error) {
+	if name != "example.com" {
+		return nil, errors.New("not found")
+	}
+	catchall := "Postmaster@Example.com"
+	return &domain.Domain{
+		ID:                  1,
+		Name:                name,
+		Status:              "active",
+		CatchallEmail:       &catchall,
+		SubaddressSeparator: "+",
+		SubaddressFolders:   true,
+	}, nil
+}
+
+func TestLocalDeliveryService_Resolve(t *testing.T) {
+	users := map[string]*domain.User{
+		"user@example.com":       {ID: 1, Email: "user@example.com"},
+		"postmaster@example.com": {ID: 2, Email: "postmaster@example.com"},
+	}
+	userRepo := &mockUserRepository{getByEmailFunc: func(email string) (*domain.User, error) {
+		if u, ok := users[email]; ok {
+			return u, nil
+		}
+		return nil, errors.New("not found")
+	}}
+	aliasRepo := &mockAliasRepository{aliases: map[string]*domain.Alias{
+		"sales@example.com": {AliasEmail: "sales@example.com", Status: "active"},
+	}}
+	svc := NewLocalDeliveryService(userRepo, aliasRepo, &routingDomainRepository{}, nil, nil, nil, zap.NewNop())
+
+	tests := []struct {
+		addr   string
+		user   string
+		alias  string
+		folder string
+	}{
+		{"user@example.com", "user@example.com", "", ""},
+		{"user+invoices@example.com", "user@example.com", "", "invoices"},
+		{"user+INBOX@example.com", "user@example.com", "", ""},
+		{"user+trash@example.com", "user@example.com", "", ""},
+		{"user+a/b@example.com", "user@example.com", "", ""},
+		{"sales+eu@example.com", "", "sales@example.com", ""},
+		{"nobody@example.com", "postmaster@example.com", "", ""},
+	}
+	for _, tt := range tests {
+		user, alias, folder, err := svc.resolve(tt.addr)
+		if err != nil {
+			t.Errorf("resolve(%q) failed: %v", tt.addr, err)
+			continue
+		}
+		if (user == nil) != (tt.user == "") || (user != nil && user.Email != tt.user) ||
+			(alias == nil) != (tt.alias == "") || (alias != nil && alias.AliasEmail != tt.alias) || folder != tt.folder {
+			t.Errorf("resolve(%q) = %v, %v, %q; want user %q, alias %q, folder %q",
+				tt.addr, user, alias, folder, tt.user, tt.alias, tt.folder)
+		}
+	}
+
+	if _, _, _, err := svc.resolve("user@example.org"); !errors.Is(err, ErrRecipientNotFound) {
+		t.Errorf("expected unhosted domain to be not found, got %v", err)
+	}
+}
+
+// folderMailboxRepository stores the mailboxes created through it
+type folderMailboxRepository struct {
+	mockMailboxRepository
+}
+
+func (m *folderMailboxRepository) Create(mailbox *domain.Mailbox) error {
+	mailbox.ID = int64(len(m.mailboxes) + 1)
+	m.mailboxes = append(m.mailboxes, mailbox)
+	return nil
+}
+
+func (m *folderMailboxRepository) GetByUser(userID int64) ([]*domain.Mailbox, error) {
+	return m.mailboxes, nil
+}
+
+// folderDomainRepository returns a domain that may or may not create subaddress folders
+type folderDomainRepository struct {
+	mockDomainRepository
+	create bool
+}
+
+func (m *folderDomainRepository) GetByID(id int64) (*domain.Domain, error) {
+	return &domain.Domain{ID: id, Status: "active", SubaddressCreateFolders: m.create}, nil
+}
+
+func TestLocalDeliveryService_SubaddressMailbox(t *testing.T) {
+	user := &domain.User{ID: 1, DomainID: 1, Email: "user@example.com"}
+	newService := func(create bool, mailboxes ...*domain.Mailbox) (*LocalDeliveryService, *folderMailboxRepository) {
+		repo := &folderMailboxRepository{mockMailboxRepository{mailboxes: mailboxes}}
+		svc := NewLocalDeliveryService(&mockUserRepository{}, &mockAliasRepository{}, &folderDomainRepository{create: create},
+			NewMailboxService(repo, zap.NewNop()), nil, nil, zap.NewNop())
+		return svc, repo
+	}
+
+	svc, _ := newService(false,
+		&domain.Mailbox{ID: 1, UserID: 1, Name: "invoices"},
+		&domain.Mailbox{ID: 2, UserID: 1, Name: "Papierkorb", SpecialUse: "\\Trash"},
+	)
+	if mb := svc.subaddressMailbox(user, "invoices"); mb == nil || mb.ID != 1 {
+		t.Errorf("expected existing folder, got %+v", mb)
+	}
+	if mb := svc.subaddressMailbox(user, "Papierkorb"); mb != nil {
+		t.Errorf("expected special-use mailbox to be refused, got %+v", mb)
+	}
+	if mb := svc.subaddressMailbox(user, "receipts"); mb != nil {
+		t.Errorf("expected missing folder not to be created, got %+v", mb)
+	}
+
+	svc, repo := newService(true)
+	if mb := svc.subaddressMailbox(user, "receipts"); mb == nil || mb.Name != "receipts" || !mb.Subscribed {
+		t.Errorf("expected folder to be created and subscribed, got %+v", mb)
+	}
+
+	for len(repo.mailboxes) < maxSubaddressMailboxes {
+		repo.Create(&domain.Mailbox{UserID: 1, Name: fmt.Sprintf("folder%d", len(repo.mailboxes))})
+	}
+	if mb := svc.subaddressMailbox(user, "one-too-many"); mb != nil {
+		t.Errorf("expected no folder past the mailbox limit, got %+v", mb)
+	}
+}
+
+func TestLocalDeliveryService_CheckRecipient(t *testing.T) {
+	users := map[string]*domain.User{
+		"user@example.com":     {ID: 1, Email: "user@example.com", Status: "active", Quota: 100, UsedQuota: 90},
+		"disabled@example.com": {ID: 2, Email: "disabled@example.com", Status: "disabled"},
+	}
+	userRepo := &mockUserRepository{getByEmailFunc: func(email string) (*domain.User, error) {
+		if u, ok := users[email]; ok {
+			return u, nil
+		}
+		return nil, errors.New("not found")
+	}}
+	svc := NewLocalDeliveryService(userRepo, &mockAliasRepository{}, &mockDomainRepository{}, nil, nil, nil, zap.NewNop())
+
+	if err := svc.CheckRecipient("User@Example.com"); err != nil {
+		t.Errorf("expected active user to be accepted, got %v", err)
+	}
+	if err := svc.CheckRecipient("disabled@example.com"); !errors.Is(err, ErrUserDisabled) {
+		t.Errorf("expected disabled user to be refused, got %v", err)
+	}
+	if err := svc.CheckRecipient("nobody@example.org"); !errors.Is(err, ErrRecipientNotFound) {
+		t.Errorf("expected unknown recipient to be refused, got %v", err)
+	}
+
+	quotaUsers := &quotaUserRepository{users: []*domain.User{users["user@example.com"]}}
+	svc.SetQuotaService(NewQuotaService(quotaUsers, &mockMessageRepository{}, zap.NewNop()))
+	if err := svc.deliverToUser(users["user@example.com"], "", make([]byte, 20)); !errors.Is(err, ErrOverQuota) {
+		t.Errorf("expected delivery past the quota to be refused, got %v", err)
+	}
+}
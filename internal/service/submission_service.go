@@ -0,0 +1,474 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/repository"
+	repService "github.com/btafoya/gomailserver/internal/reputation/service"
+	"github.com/btafoya/gomailserver/internal/security/dkim"
+	"github.com/btafoya/gomailserver/internal/security/ratelimit"
+)
+
+// SubmissionOrigin identifies the channel a message was submitted through
+type SubmissionOrigin string
+
+const (
+	// OriginSMTP is an authenticated SMTP submission (AUTH on submission/submissions listeners)
+	OriginSMTP SubmissionOrigin = "smtp"
+	// OriginWebmail is a message composed in the webmail client
+	OriginWebmail SubmissionOrigin = "webmail"
+	// OriginAPI is a message sent through the PostmarkApp-compatible HTTP API
+	OriginAPI SubmissionOrigin = "api"
+	// OriginJMAP is an EmailSubmission created through the JMAP endpoint
+	OriginJMAP SubmissionOrigin = "jmap"
+)
+
+// Submitter identifies who is submitting a message
+type Submitter struct {
+	Origin   SubmissionOrigin
+	User     *domain.User // authenticated user; nil for API submissions
+	RemoteIP string
+}
+
+// SubmissionError is a policy rejection carrying the SMTP reply to give the client
+type SubmissionError struct {
+	Code         int
+	EnhancedCode [3]int
+	Message      string
+}
+
+// Error implements the error interface
+func (e *SubmissionError) Error() string {
+	return fmt.Sprintf("%d %d.%d.%d %s", e.Code, e.EnhancedCode[0], e.EnhancedCode[1], e.EnhancedCode[2], e.Message)
+}
+
+var (
+	errSenderNotAuthorized = &SubmissionError{Code: 553, EnhancedCode: [3]int{5, 7, 1}, Message: "Sender address not authorized"}
+	errRateLimited         = &SubmissionError{Code: 421, EnhancedCode: [3]int{4, 7, 1}, Message: "Rate limit exceeded"}
+	errCircuitBreaker      = &SubmissionError{Code: 421, EnhancedCode: [3]int{4, 7, 1}, Message: "Sending paused for this domain due to reputation issues"}
+	errWarmUpLimit         = &SubmissionError{Code: 421, EnhancedCode: [3]int{4, 7, 1}, Message: "Daily sending limit reached during warm-up period"}
+	errDomainRateLimited   = &SubmissionError{Code: 421, EnhancedCode: [3]int{4, 7, 1}, Message: "Domain sending rate limit exceeded"}
+	errNoRecipients        = &SubmissionError{Code: 554, EnhancedCode: [3]int{5, 5, 1}, Message: "No valid recipients"}
+)
+
+// SubmissionService is the single path for outbound mail from every origin
+// SMTP AUTH, webmail and the HTTP API all get the same sender authorization,
+// rate limiting, header fix-ups, DKIM signing and warm-up accounting.
+type SubmissionService struct {
+	userRepo        repository.UserRepository
+	aliasRepo       repository.AliasRepository
+	grantRepo       repository.SenderGrantRepository
+	domainRepo      repository.DomainRepository
+	queueService    QueueServiceInterface
+	webhookService  *WebhookService
+	dkimSigner      *dkim.Signer
+	rateLimiter     *ratelimit.Limiter
+	adaptiveLimiter *repService.AdaptiveLimiter
+	logger          *zap.Logger
+}
+
+// senderRight is how a submitter may use an address
+type senderRight int
+
+const (
+	senderDenied   senderRight = iota
+	senderOnBehalf             // header From only; the Sender field must name the user
+	senderAllowed              // envelope sender and any originator field
+)
+
+// NewSubmissionService creates a new submission service
+func NewSubmissionService(
+	userRepo repository.UserRepository,
+	aliasRepo repository.AliasRepository,
+	grantRepo repository.SenderGrantRepository,
+	domainRepo repository.DomainRepository,
+	queueService QueueServiceInterface,
+	dkimSigner *dkim.Signer,
+	rateLimiter *ratelimit.Limiter,
+	adaptiveLimiter *repService.AdaptiveLimiter,
+	logger *zap.Logger,
+) *SubmissionService {
+	return &SubmissionService{
+		userRepo:        userRepo,
+		aliasRepo:       aliasRepo,
+		grantRepo:       grantRepo,
+		domainRepo:      domainRepo,
+		queueService:    queueService,
+		dkimSigner:      dkimSigner,
+		rateLimiter:     rateLimiter,
+		adaptiveLimiter: adaptiveLimiter,
+		logger:          logger,
+	}
+}
+
+// SetWebhookService sets the webhook service notified of rejected senders (optional)
+func (s *SubmissionService) SetWebhookService(webhookService *WebhookService) {
+	s.webhookService = webhookService
+}
+
+// UserSubmitter loads the submitter for an authenticated user
+func (s *SubmissionService) UserSubmitter(origin SubmissionOrigin, userID int64, remoteIP string) (*Submitter, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+	return &Submitter{Origin: origin, User: user, RemoteIP: remoteIP}, nil
+}
+
+// SpoolDir returns the directory submissions are spooled to before queueing
+func (s *SubmissionService) SpoolDir() string {
+	return s.queueService.SpoolDir()
+}
+
+// Check authorizes the envelope sender and applies per-user and reputation limits
+// SMTP calls it at MAIL FROM so rejected senders never transmit a body.
+func (s *SubmissionService) Check(ctx context.Context, sub *Submitter, from string) error {
+	if err := s.authorizeSender(ctx, sub, from); err != nil {
+		return err
+	}
+
+	senderDomain := domainPart(from)
+	domainConfig, err := s.domainRepo.GetByName(senderDomain)
+	if err != nil {
+		domainConfig = nil
+	}
+
+	// Per-user rate limit
+	if domainConfig != nil && domainConfig.RateLimitEnabled && s.rateLimiter != nil && sub.User != nil {
+		allowed, err := s.rateLimiter.CheckUser(sub.User.Email)
+		if err != nil {
+			s.logger.Error("user rate limit check failed", zap.Error(err))
+		} else if !allowed {
+			s.logger.Warn("submission rate limited by user",
+				zap.String("origin", string(sub.Origin)),
+				zap.String("user", sub.User.Email),
+				zap.String("from", from),
+			)
+			return errRateLimited
+		}
+	}
+
+	// Adaptive rate limiting (reputation-aware, circuit breaker, warm-up)
+	if s.adaptiveLimiter != nil {
+		allowed, err := s.adaptiveLimiter.CheckDomain(ctx, senderDomain)
+		switch {
+		case errors.Is(err, repService.ErrCircuitBreakerActive):
+			s.logger.Warn("domain paused by circuit breaker", zap.String("domain", senderDomain))
+			return errCircuitBreaker
+		case errors.Is(err, repService.ErrWarmUpLimitExceeded):
+			s.logger.Warn("warm-up volume limit exceeded", zap.String("domain", senderDomain))
+			return errWarmUpLimit
+		case err != nil:
+			// Other errors - log but allow
+			s.logger.Error("adaptive limiter check failed",
+				zap.String("domain", senderDomain),
+				zap.Error(err),
+			)
+		case !allowed:
+			s.logger.Warn("domain rate limited by reputation", zap.String("domain", senderDomain))
+			return errDomainRateLimited
+		}
+	}
+
+	return nil
+}
+
+// SubmitMessage checks and submits an in-memory message
+// Used by origins without an SMTP transaction (webmail, HTTP API).
+func (s *SubmissionService) SubmitMessage(ctx context.Context, sub *Submitter, from string, to []string, message []byte, opts *EnqueueOptions) (string, error) {
+	if err := s.Check(ctx, sub, from); err != nil {
+		return "", err
+	}
+
+	sp, err := NewSpool(s.queueService.SpoolDir(), bytes.NewReader(message), 0)
+	if err != nil {
+		return "", err
+	}
+	defer sp.Close()
+
+	return s.Submit(ctx, sub, from, to, sp, opts)
+}
+
+// Submit finalizes a spooled message and queues it for delivery
+// The header From is authorized, missing Date and Message-ID fields are added,
+// and the message is DKIM signed for the sender domain. The spool is consumed.
+func (s *SubmissionService) Submit(ctx context.Context, sub *Submitter, from string, to []string, sp *Spool, opts *EnqueueOptions) (string, error) {
+	if len(to) == 0 {
+		return "", errNoRecipients
+	}
+	if err := s.authorizeSender(ctx, sub, from); err != nil {
+		return "", err
+	}
+
+	header, err := sp.Header()
+	if err != nil {
+		return "", fmt.Errorf("failed to parse message header: %w", err)
+	}
+
+	// Every From address must be usable by the submitter; send-on-behalf
+	// identities additionally require a Sender field naming the user
+	onBehalf := false
+	if headerFrom := header.Get("From"); headerFrom != "" {
+		addrs, err := mail.ParseAddressList(headerFrom)
+		if err != nil {
+			return "", &SubmissionError{Code: 550, EnhancedCode: [3]int{5, 6, 0}, Message: "Invalid From header"}
+		}
+		for _, addr := range addrs {
+			switch s.senderRight(sub, addr.Address) {
+			case senderAllowed:
+			case senderOnBehalf:
+				onBehalf = true
+			default:
+				return "", s.rejectSender(ctx, sub, addr.Address)
+			}
+		}
+	}
+	if headerSender := header.Get("Sender"); headerSender != "" {
+		addr, err := mail.ParseAddress(headerSender)
+		if err != nil {
+			return "", &SubmissionError{Code: 550, EnhancedCode: [3]int{5, 6, 0}, Message: "Invalid Sender header"}
+		}
+		if err := s.authorizeSender(ctx, sub, addr.Address); err != nil {
+			return "", err
+		}
+	}
+
+	senderDomain := domainPart(from)
+
+	// Message submission agents add fields the client omitted (RFC 6409 section 8)
+	var missing strings.Builder
+	if onBehalf && header.Get("Sender") == "" {
+		missing.WriteString("Sender: " + sub.User.Email + "\r\n")
+	}
+	if header.Get("Date") == "" {
+		missing.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	}
+	if header.Get("Message-Id") == "" {
+		missing.WriteString("Message-ID: " + NewMessageID(senderDomain) + "\r\n")
+	}
+	if missing.Len() > 0 {
+		if err := sp.Prepend(missing.String()); err != nil {
+			return "", fmt.Errorf("failed to add missing headers: %w", err)
+		}
+	}
+
+	// DKIM signing for the sender domain
+	domainConfig, err := s.domainRepo.GetByName(senderDomain)
+	if err == nil && domainConfig != nil && domainConfig.DKIMSigningEnabled && s.dkimSigner != nil {
+		signature, err := s.dkimSigner.Sign(senderDomain, sp.Reader())
+		if err == nil && signature != "" {
+			err = sp.Prepend(signature)
+		}
+		if err != nil {
+			// Continue without signing on error
+			s.logger.Error("DKIM signing failed",
+				zap.Error(err),
+				zap.String("from", from),
+			)
+		}
+	}
+
+	size := sp.Size()
+	spoolPath := sp.Detach()
+	queueID, err := s.queueService.EnqueueFile(from, to, spoolPath, opts)
+	if err != nil {
+		os.Remove(spoolPath)
+		return "", fmt.Errorf("failed to queue message: %w", err)
+	}
+
+	// Record send for warm-up tracking
+	if s.adaptiveLimiter != nil {
+		if err := s.adaptiveLimiter.RecordSend(ctx, senderDomain); err != nil {
+			s.logger.Warn("failed to record warm-up send",
+				zap.Error(err),
+				zap.String("domain", senderDomain),
+			)
+		}
+	}
+
+	s.logger.Info("message submitted",
+		zap.String("origin", string(sub.Origin)),
+		zap.String("queue_id", queueID),
+		zap.String("from", from),
+		zap.Strings("to", to),
+		zap.Int64("size", size),
+	)
+
+	return queueID, nil
+}
+
+// authorizeSender checks that the submitter may use an address as envelope sender
+// or Sender field. Violations are rejected with 553 5.7.1 and reported to webhooks.
+func (s *SubmissionService) authorizeSender(ctx context.Context, sub *Submitter, addr string) error {
+	if s.senderRight(sub, addr) != senderAllowed {
+		return s.rejectSender(ctx, sub, addr)
+	}
+	return nil
+}
+
+// senderRight resolves how the submitter may use an address
+// Users may send as their own address, an alias that delivers to them, or an
+// address granted to them; API submissions may send from any active hosted domain.
+func (s *SubmissionService) senderRight(sub *Submitter, addr string) senderRight {
+	addr = NormalizeAddress(addr)
+
+	if sub.User == nil {
+		if sub.Origin == OriginAPI && s.isHostedDomain(domainPart(addr)) {
+			return senderAllowed
+		}
+		return senderDenied
+	}
+
+	userEmail := NormalizeAddress(sub.User.Email)
+	if addr == userEmail {
+		return senderAllowed
+	}
+
+	if s.aliasRepo != nil {
+		if alias, err := s.aliasRepo.GetByEmail(addr); err == nil && alias.Status == "active" {
+			destinations, err := GetDestinations(alias.DestinationEmails)
+			if err == nil {
+				for _, dest := range destinations {
+					if NormalizeAddress(dest) == userEmail {
+						return senderAllowed
+					}
+				}
+			}
+		}
+	}
+
+	if s.grantRepo != nil {
+		if grant, err := s.grantRepo.FindForAddress(sub.User.ID, addr); err == nil {
+			if grant.GrantType == domain.SenderGrantSendOnBehalf {
+				return senderOnBehalf
+			}
+			return senderAllowed
+		}
+	}
+
+	return senderDenied
+}
+
+// SenderAddresses lists the addresses a user may send as: their own, the active
+// aliases of their domain that deliver to them, and their send_as grants.
+// A domain-wide grant is returned as "*@domain".
+func (s *SubmissionService) SenderAddresses(user *domain.User) ([]string, error) {
+	userEmail := NormalizeAddress(user.Email)
+	addresses := []string{userEmail}
+	seen := map[string]bool{userEmail: true}
+	add := func(addr string) {
+		if !seen[addr] {
+			seen[addr] = true
+			addresses = append(addresses, addr)
+		}
+	}
+
+	if s.aliasRepo != nil {
+		aliases, err := s.aliasRepo.ListByDomain(user.DomainID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list aliases: %w", err)
+		}
+		for _, alias := range aliases {
+			if alias.Status != "active" {
+				continue
+			}
+			destinations, err := GetDestinations(alias.DestinationEmails)
+			if err != nil {
+				continue
+			}
+			for _, dest := range destinations {
+				if NormalizeAddress(dest) == userEmail {
+					add(NormalizeAddress(alias.AliasEmail))
+				}
+			}
+		}
+	}
+
+	if s.grantRepo != nil {
+		grants, err := s.grantRepo.ListByUser(user.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list sender grants: %w", err)
+		}
+		for _, grant := range grants {
+			if grant.GrantType != domain.SenderGrantSendAs {
+				continue
+			}
+			if strings.HasPrefix(grant.Address, "@") {
+				add("*" + strings.ToLower(grant.Address))
+			} else {
+				add(NormalizeAddress(grant.Address))
+			}
+		}
+	}
+
+	return addresses, nil
+}
+
+// rejectSender logs an unauthorized sender and raises a security webhook event
+func (s *SubmissionService) rejectSender(ctx context.Context, sub *Submitter, addr string) error {
+	userEmail := ""
+	if sub.User != nil {
+		userEmail = sub.User.Email
+	}
+
+	s.logger.Warn("sender address not authorized",
+		zap.String("origin", string(sub.Origin)),
+		zap.String("user", userEmail),
+		zap.String("sender", addr),
+		zap.String("remote_ip", sub.RemoteIP),
+	)
+
+	if s.webhookService != nil {
+		err := s.webhookService.TriggerEvent(ctx, domain.WebhookEventSecuritySenderRejected, map[string]interface{}{
+			"origin":    string(sub.Origin),
+			"user":      userEmail,
+			"sender":    addr,
+			"remote_ip": sub.RemoteIP,
+		})
+		if err != nil {
+			s.logger.Error("failed to trigger sender rejected webhook", zap.Error(err))
+		}
+	}
+
+	return errSenderNotAuthorized
+}
+
+// isHostedDomain reports whether a domain is active on this server
+func (s *SubmissionService) isHostedDomain(name string) bool {
+	if name == "" || name == DefaultTemplateDomainName {
+		return false
+	}
+	d, err := s.domainRepo.GetByName(name)
+	return err == nil && d != nil && d.Status == "active"
+}
+
+// NewMessageID generates a Message-ID field value for a sender domain
+func NewMessageID(senderDomain string) string {
+	if senderDomain == "" {
+		senderDomain = "localhost"
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), senderDomain)
+}
+
+// domainPart returns the normalized domain of an address
+func domainPart(addr string) string {
+	at := strings.LastIndex(addr, "@")
+	if at < 0 {
+		return ""
+	}
+	return NormalizeDomain(addr[at+1:])
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+)
+
+// mockAliasRepository is a test double for AliasRepository
+type mockAliasRepository struct {
+	aliases map[string]*domain.Alias
+}
+
+func (m *mockAliasRepository) Create(alias *domain.Alias) error               { return nil }
+func (m *mockAliasRepository) GetByID(id int64) (*domain.Alias, error)        { return nil, nil }
+func (m *mockAliasRepository) Update(alias *domain.Alias) error               { return nil }
+func (m *mockAliasRepository) Delete(id int64) error                          { return nil }
+func (m *mockAliasRepository) ListAll() ([]*domain.Alias, error)              { return nil, nil }
+func (m *mockAliasRepository) ListByDomain(id int64) ([]*domain.Alias, error) { return nil, nil }
+
+func (m *mockAliasRepository) GetByEmail(email string) (*domain.Alias, error) {
+	if alias, ok := m.aliases[email]; ok {
+		return alias, nil
+	}
+	return nil, errors.New("not found")
+}
+
+// mockSenderGrantRepository is a test double for SenderGrantRepository
+type mockSenderGrantRepository struct {
+	grants []*domain.SenderGrant
+}
+
+func (m *mockSenderGrantRepository) Create(grant *domain.SenderGrant) error { return nil }
+func (m *mockSenderGrantRepository) GetByID(id int64) (*domain.SenderGrant, error) {
+	return nil, errors.New("not found")
+}
+func (m *mockSenderGrantRepository) ListByUser(userID int64) ([]*domain.SenderGrant, error) {
+	return m.grants, nil
+}
+func (m *mockSenderGrantRepository) Delete(id int64) error { return nil }
+
+func (m *mockSenderGrantRepository) FindForAddress(userID int64, address string) (*domain.SenderGrant, error) {
+	for _, g := range m.grants {
+		if g.UserID == userID && (g.Address == address || strings.HasSuffix(address, g.Address) && strings.HasPrefix(g.Address, "@")) {
+			return g, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+func newTestSubmissionService(t *testing.T, queued *[]*domain.QueueItem) *SubmissionService {
+	queueRepo := &mockQueueRepository{
+		enqueueFunc: func(item *domain.QueueItem) error {
+			*queued = append(*queued, item)
+			return nil
+		},
+	}
+	queueSvc := NewQueueServiceWithPath(queueRepo, nil, zap.NewNop(), t.TempDir())
+	aliasRepo := &mockAliasRepository{aliases: map[string]*domain.Alias{
+		"sales@example.com": {AliasEmail: "sales@example.com", DestinationEmails: `["user@example.com"]`, Status: "active"},
+		"other@example.com": {AliasEmail: "other@example.com", DestinationEmails: `["someone@example.com"]`, Status: "active"},
+	}}
+	grantRepo := &mockSenderGrantRepository{grants: []*domain.SenderGrant{
+		{UserID: 1, Address: "ceo@example.com", GrantType: domain.SenderGrantSendOnBehalf},
+		{UserID: 1, Address: "@shared.example.com", GrantType: domain.SenderGrantSendAs},
+	}}
+	return NewSubmissionService(nil, aliasRepo, grantRepo, &mockDomainRepository{}, queueSvc, nil, nil, nil, zap.NewNop())
+}
+
+func TestSubmissionService_AuthorizeSender(t *testing.T) {
+	var queued []*domain.QueueItem
+	svc := newTestSubmissionService(t, &queued)
+	sub := &Submitter{Origin: OriginWebmail, User: &domain.User{ID: 1, Email: "user@example.com"}}
+
+	tests := []struct {
+		name    string
+		from    string
+		allowed bool
+	}{
+		{"own address", "user@example.com", true},
+		{"own address different case", "User@Example.COM", true},
+		{"alias delivering to user", "sales@example.com", true},
+		{"alias delivering elsewhere", "other@example.com", false},
+		{"unrelated address", "cfo@example.com", false},
+		{"send-as domain grant", "team@shared.example.com", true},
+		{"send-on-behalf grant as envelope sender", "ceo@example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := svc.Check(context.Background(), sub, tt.from)
+			if tt.allowed && err != nil {
+				t.Fatalf("expected sender to be allowed, got %v", err)
+			}
+			if !tt.allowed && !errors.Is(err, errSenderNotAuthorized) {
+				t.Fatalf("expected sender to be rejected, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSubmissionService_SubmitMessage(t *testing.T) {
+	t.Run("adds missing Date and Message-ID", func(t *testing.T) {
+		var queued []*domain.QueueItem
+		svc := newTestSubmissionService(t, &queued)
+		sub := &Submitter{Origin: OriginSMTP, User: &domain.User{ID: 1, Email: "user@example.com"}}
+
+		message := []byte("From: user@example.com\r\nSubject: Hi\r\n\r\nBody\r\n")
+		if _, err := svc.SubmitMessage(context.Background(), sub, "user@example.com", []string{"rcpt@example.net"}, message, nil); err != nil {
+			t.Fatalf("SubmitMessage failed: %v", err)
+		}
+
+		if len(queued) != 1 {
+			t.Fatalf("expected 1 queued message, got %d", len(queued))
+		}
+		data, err := os.ReadFile(queued[0].MessagePath)
+		if err != nil {
+			t.Fatalf("failed to read queued message: %v", err)
+		}
+		for _, field := range []string{"Date: ", "Message-ID: <"} {
+			if !strings.Contains(string(data), field) {
+				t.Errorf("expected queued message to contain %q", field)
+			}
+		}
+		if !strings.HasSuffix(string(data), "From: user@example.com\r\nSubject: Hi\r\n\r\nBody\r\n") {
+			t.Error("expected original message to follow the added fields")
+		}
+	})
+
+	t.Run("rejects header From the user may not use", func(t *testing.T) {
+		var queued []*domain.QueueItem
+		svc := newTestSubmissionService(t, &queued)
+		sub := &Submitter{Origin: OriginSMTP, User: &domain.User{ID: 1, Email: "user@example.com"}}
+
+		message := []byte("From: cfo@example.com\r\nSubject: Hi\r\n\r\nBody\r\n")
+		_, err := svc.SubmitMessage(context.Background(), sub, "user@example.com", []string{"rcpt@example.net"}, message, nil)
+		if !errors.Is(err, errSenderNotAuthorized) {
+			t.Fatalf("expected sender rejection, got %v", err)
+		}
+		if len(queued) != 0 {
+			t.Error("expected rejected message not to be queued")
+		}
+	})
+
+	t.Run("adds Sender for send-on-behalf From", func(t *testing.T) {
+		var queued []*domain.QueueItem
+		svc := newTestSubmissionService(t, &queued)
+		sub := &Submitter{Origin: OriginWebmail, User: &domain.User{ID: 1, Email: "user@example.com"}}
+
+		message := []byte("From: ceo@example.com\r\nSubject: Hi\r\n\r\nBody\r\n")
+		if _, err := svc.SubmitMessage(context.Background(), sub, "user@example.com", []string{"rcpt@example.net"}, message, nil); err != nil {
+			t.Fatalf("SubmitMessage failed: %v", err)
+		}
+
+		data, err := os.ReadFile(queued[0].MessagePath)
+		if err != nil {
+			t.Fatalf("failed to read queued message: %v", err)
+		}
+		if !strings.Contains(string(data), "Sender: user@example.com\r\n") {
+			t.Error("expected Sender field naming the user")
+		}
+	})
+
+	t.Run("rejects API sender outside hosted domains", func(t *testing.T) {
+		var queued []*domain.QueueItem
+		svc := newTestSubmissionService(t, &queued)
+
+		message := []byte("From: app@example.org\r\nSubject: Hi\r\n\r\nBody\r\n")
+		_, err := svc.SubmitMessage(context.Background(), &Submitter{Origin: OriginAPI}, "app@example.org", []string{"rcpt@example.net"}, message, nil)
+		if !errors.Is(err, errSenderNotAuthorized) {
+			t.Fatalf("expected sender rejection, got %v", err)
+		}
+	})
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/btafoya/gomailserver/internal/domain"
 	"github.com/btafoya/gomailserver/internal/repository"
+	"github.com/btafoya/gomailserver/internal/security/saslauth"
 )
 
 const bcryptCost = 12
@@ -22,9 +23,11 @@ var (
 
 // UserService handles user operations
 type UserService struct {
-	repo       repository.UserRepository
-	domainRepo repository.DomainRepository
-	logger     *zap.Logger
+	repo         repository.UserRepository
+	domainRepo   repository.DomainRepository
+	appPasswords *AppPasswordService
+	audit        *AuditService
+	logger       *zap.Logger
 }
 
 // NewUserService creates a new user service
@@ -36,8 +39,30 @@ func NewUserService(repo repository.UserRepository, domainRepo repository.Domain
 	}
 }
 
+// SetAppPasswordService enables app passwords for mail and DAV client logins
+func (s *UserService) SetAppPasswordService(appPasswords *AppPasswordService) {
+	s.appPasswords = appPasswords
+}
+
 // Authenticate verifies user credentials
 func (s *UserService) Authenticate(email, password string) (*domain.User, error) {
+	return s.authenticate(email, password, "")
+}
+
+// AuthenticateClient verifies credentials presented by an IMAP, SMTP or DAV client
+// App passwords valid for scope are accepted; accounts that require them accept nothing else.
+func (s *UserService) AuthenticateClient(email, password, scope string) (*domain.User, error) {
+	return s.authenticate(email, password, scope)
+}
+
+// RequiresAppPassword reports whether mail and DAV clients must use an app password
+func (s *UserService) RequiresAppPassword(user *domain.User) bool {
+	return s.appPasswords != nil && s.appPasswords.Required(user)
+}
+
+// authenticate verifies a password, or an app password when scope is set
+func (s *UserService) authenticate(email, password, scope string) (*domain.User, error) {
+	email = NormalizeAddress(email)
 	user, err := s.repo.GetByEmail(email)
 	if err != nil {
 		s.logger.Debug("authentication failed - user not found",
@@ -55,11 +80,26 @@ func (s *UserService) Authenticate(email, password string) (*domain.User, error)
 		return nil, ErrUserDisabled
 	}
 
-	if !s.VerifyPassword(user.PasswordHash, password) {
+	switch {
+	case scope != "" && s.appPasswords != nil && s.appPasswords.Verify(user, password, scope):
+		s.logger.Debug("authenticated with app password",
+			zap.String("email", email),
+			zap.String("scope", scope),
+		)
+	case scope != "" && s.RequiresAppPassword(user):
+		s.logger.Warn("authentication failed - app password required",
+			zap.String("email", email),
+			zap.String("scope", scope),
+		)
+		return nil, ErrInvalidCredentials
+	case !s.VerifyPassword(user.PasswordHash, password):
 		s.logger.Warn("authentication failed - invalid password",
 			zap.String("email", email),
 		)
 		return nil, ErrInvalidCredentials
+	case user.SCRAMSHA256 == "":
+		// Backfill SCRAM credentials for accounts created before SCRAM support
+		s.storeSCRAMCredentials(user, password)
 	}
 
 	// Update last login
@@ -101,6 +141,13 @@ func (s *UserService) Create(user *domain.User, password string) error {
 		return err
 	}
 	user.PasswordHash = hash
+	user.Email = NormalizeAddress(user.Email)
+
+	creds, err := saslauth.NewSCRAMCredentials(password)
+	if err != nil {
+		return err
+	}
+	user.SCRAMSHA256 = creds.String()
 
 	if err := s.repo.Create(user); err != nil {
 		s.logger.Error("failed to create user",
@@ -125,7 +172,7 @@ func (s *UserService) GetByID(id int64) (*domain.User, error) {
 
 // GetByEmail retrieves a user by email (implements UserServiceInterface)
 func (s *UserService) GetByEmail(email string) (*domain.User, error) {
-	return s.repo.GetByEmail(email)
+	return s.repo.GetByEmail(NormalizeAddress(email))
 }
 
 // ListAll retrieves all users
@@ -163,6 +210,8 @@ func (s *UserService) UpdatePassword(userID int64, newPassword string) error {
 		return err
 	}
 
+	s.storeSCRAMCredentials(&domain.User{ID: userID}, newPassword)
+
 	s.logger.Info("password updated",
 		zap.Int64("user_id", userID),
 	)
@@ -170,6 +219,23 @@ func (s *UserService) UpdatePassword(userID int64, newPassword string) error {
 	return nil
 }
 
+// storeSCRAMCredentials derives and saves SCRAM-SHA-256 credentials for a password
+// Failures are logged only; SCRAM is unavailable for the user until the next attempt.
+func (s *UserService) storeSCRAMCredentials(user *domain.User, password string) {
+	creds, err := saslauth.NewSCRAMCredentials(password)
+	if err == nil {
+		err = s.repo.UpdateSCRAMCredentials(user.ID, creds.String())
+	}
+	if err != nil {
+		s.logger.Error("failed to store SCRAM credentials",
+			zap.Error(err),
+			zap.Int64("user_id", user.ID),
+		)
+		return
+	}
+	user.SCRAMSHA256 = creds.String()
+}
+
 // GetDomainByID retrieves a domain by ID (helper for user operations that need domain info)
 func (s *UserService) GetDomainByID(ctx context.Context, domainID int64) (*domain.Domain, error) {
 	return s.domainRepo.GetByID(domainID)
@@ -82,6 +82,14 @@ func (m *mockUserRepository) UpdatePassword(userID int64, passwordHash string) e
 	return nil
 }
 
+func (m *mockUserRepository) UpdateSCRAMCredentials(userID int64, credentials string) error {
+	return nil
+}
+
+func (m *mockUserRepository) ReconcileQuota() (map[int64]int64, error) {
+	return nil, nil
+}
+
 func (m *mockUserRepository) ListAll() ([]*domain.User, error) {
 	return nil, nil
 }
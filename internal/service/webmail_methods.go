@@ -102,21 +102,33 @@ func (s *MessageService) GetMessage(ctx context.Context, messageID, userID int)
 	return msg, nil
 }
 
-// SendMessage sends a new message via the queue
+// SendMessage sends a new message through the submission pipeline
 func (s *MessageService) SendMessage(ctx context.Context, userID int, req *SendMessageRequest) (int, error) {
+	var sub *Submitter
+	from := req.From
+	if s.submissionService != nil {
+		var err error
+		sub, err = s.submissionService.UserSubmitter(OriginWebmail, int64(userID), "")
+		if err != nil {
+			return 0, err
+		}
+		if from == "" {
+			from = sub.User.Email
+		}
+	}
+
 	// Build MIME message
 	var buf strings.Builder
 
 	// Write headers
-	buf.WriteString(fmt.Sprintf("From: %s\r\n", req.From))
+	buf.WriteString(fmt.Sprintf("From: %s\r\n", from))
 	buf.WriteString(fmt.Sprintf("To: %s\r\n", req.To))
 	if req.Cc != "" {
 		buf.WriteString(fmt.Sprintf("Cc: %s\r\n", req.Cc))
 	}
-	if req.Bcc != "" {
-		buf.WriteString(fmt.Sprintf("Bcc: %s\r\n", req.Bcc))
-	}
 	buf.WriteString(fmt.Sprintf("Subject: %s\r\n", req.Subject))
+	buf.WriteString(fmt.Sprintf("Date: %s\r\n", time.Now().Format(time.RFC1123Z)))
+	buf.WriteString(fmt.Sprintf("Message-ID: %s\r\n", NewMessageID(domainPart(from))))
 	buf.WriteString("MIME-Version: 1.0\r\n")
 
 	// Simple message format (text/plain or text/html)
@@ -132,23 +144,16 @@ func (s *MessageService) SendMessage(ctx context.Context, userID int, req *SendM
 
 	messageData := []byte(buf.String())
 
-	// Build recipient list for SMTP
-	var recipients []string
-	if req.To != "" {
-		recipients = append(recipients, req.To)
-	}
-	if req.Cc != "" {
-		recipients = append(recipients, strings.Split(req.Cc, ",")...)
-	}
-	if req.Bcc != "" {
-		recipients = append(recipients, strings.Split(req.Bcc, ",")...)
+	// Build recipient list for SMTP; Bcc recipients only appear in the envelope
+	recipients, err := envelopeRecipients(req.To, req.Cc, req.Bcc)
+	if err != nil {
+		return 0, err
 	}
 
-	// Queue message for SMTP delivery if QueueService is available
-	if s.queueService != nil {
-		_, err := s.queueService.Enqueue(req.From, recipients, messageData)
-		if err != nil {
-			return 0, fmt.Errorf("failed to queue message for delivery: %w", err)
+	// Submit message for delivery if SubmissionService is available
+	if s.submissionService != nil {
+		if _, err := s.submissionService.SubmitMessage(ctx, sub, from, recipients, messageData, nil); err != nil {
+			return 0, fmt.Errorf("failed to submit message for delivery: %w", err)
 		}
 	}
 
@@ -178,6 +183,24 @@ func (s *MessageService) SendMessage(ctx context.Context, userID int, req *SendM
 	return int(sentMessageID), nil
 }
 
+// envelopeRecipients parses comma-separated address fields into bare addresses
+func envelopeRecipients(fields ...string) ([]string, error) {
+	var recipients []string
+	for _, field := range fields {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+		addrs, err := mail.ParseAddressList(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient list %q: %w", field, err)
+		}
+		for _, addr := range addrs {
+			recipients = append(recipients, addr.Address)
+		}
+	}
+	return recipients, nil
+}
+
 // DeleteMessage moves a message to trash or deletes permanently
 func (s *MessageService) DeleteMessage(ctx context.Context, messageID, userID int) error {
 	msg, err := s.GetByID(int64(messageID))
@@ -201,10 +224,14 @@ func (s *MessageService) DeleteMessage(ctx context.Context, messageID, userID in
 			)
 		} else {
 			// Move message to Trash folder
+			source := msg.MailboxID
 			msg.MailboxID = trashMailbox.ID
 			if err := s.repo.Update(msg); err != nil {
 				return fmt.Errorf("failed to move message to Trash: %w", err)
 			}
+			if s.changes != nil {
+				s.changes.MessageUpdated(msg, source, msg.MailboxID)
+			}
 			return nil
 		}
 	}
@@ -226,10 +253,22 @@ func (s *MessageService) MoveMessage(ctx context.Context, messageID, targetMailb
 	}
 
 	// Update mailbox ID
+	source := msg.MailboxID
 	msg.MailboxID = int64(targetMailboxID)
 
 	// Update in repository
-	return s.repo.Update(msg)
+	if err := s.repo.Update(msg); err != nil {
+		return err
+	}
+	if s.changes != nil {
+		s.changes.MessageUpdated(msg, source, msg.MailboxID)
+	}
+	if s.spamLearn != nil && s.mailboxService != nil {
+		if target, err := s.mailboxService.GetByID(msg.MailboxID); err == nil {
+			s.learnSpam(msg, source, target)
+		}
+	}
+	return nil
 }
 
 // UpdateFlags updates message flags (read, starred, etc)
@@ -272,11 +311,39 @@ func (s *MessageService) UpdateFlags(ctx context.Context, messageID, userID int,
 	msg.Flags = strings.Join(newFlags, " ")
 
 	// Update in repository
-	return s.repo.Update(msg)
+	if err := s.repo.Update(msg); err != nil {
+		return err
+	}
+	if s.changes != nil {
+		s.changes.MessageUpdated(msg, msg.MailboxID)
+	}
+	return nil
 }
 
+// webmailSearchLimit is the most messages a webmail search returns
+const webmailSearchLimit = 200
+
 // SearchMessages searches messages for a user
+// With the search index ready, query takes the ParseSearchQuery syntax and
+// matches message text; otherwise recent messages are matched on their headers.
 func (s *MessageService) SearchMessages(ctx context.Context, userID int, query string) ([]*domain.Message, error) {
+	if s.search != nil && s.search.Ready() {
+		ids, err := s.search.Query(int64(userID), 0, query, webmailSearchLimit)
+		if err != nil {
+			return nil, err
+		}
+		results := make([]*domain.Message, 0, len(ids))
+		for _, id := range ids {
+			msg, err := s.repo.GetByID(id)
+			if err != nil {
+				// Expunged since the search
+				continue
+			}
+			results = append(results, msg)
+		}
+		return results, nil
+	}
+
 	if s.mailboxService == nil {
 		return nil, fmt.Errorf("MailboxService not available for search")
 	}
@@ -464,19 +531,19 @@ func (s *MessageService) SaveDraft(ctx context.Context, userID int, draftID *int
 			return nil, fmt.Errorf("access denied: draft does not belong to user")
 		}
 
-		// Delete old message file if it exists
-		if msg.StorageType == "file" && msg.ContentPath != "" {
-			_ = os.Remove(msg.ContentPath)
-		}
-
 		// Store new version
 		newMsg, err := s.Store(int64(userID), draftsMailbox.ID, int64(msg.UID), messageData)
 		if err != nil {
 			return nil, fmt.Errorf("failed to update draft: %w", err)
 		}
 
-		// Delete old message record
-		_ = s.repo.Delete(msg.ID)
+		// Delete the old version; its body is shared and collected with it
+		if err := s.Delete(msg.ID); err != nil {
+			s.logger.Warn("failed to delete old draft",
+				zap.Int64("message_id", msg.ID),
+				zap.Error(err),
+			)
+		}
 
 		msg = newMsg
 	} else {
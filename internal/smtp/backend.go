@@ -2,9 +2,11 @@ package smtp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"os"
 	"strings"
 
 	"github.com/emersion/go-sasl"
@@ -20,7 +22,9 @@ import (
 	"github.com/btafoya/gomailserver/internal/security/dkim"
 	"github.com/btafoya/gomailserver/internal/security/dmarc"
 	"github.com/btafoya/gomailserver/internal/security/greylist"
+	"github.com/btafoya/gomailserver/internal/security/milter"
 	"github.com/btafoya/gomailserver/internal/security/ratelimit"
+	"github.com/btafoya/gomailserver/internal/security/saslauth"
 	"github.com/btafoya/gomailserver/internal/security/spf"
 	mailService "github.com/btafoya/gomailserver/internal/service"
 )
@@ -30,21 +34,28 @@ type Backend struct {
 	userService      mailService.UserServiceInterface
 	messageService   mailService.MessageServiceInterface
 	queueService     mailService.QueueServiceInterface
+	submission       *mailService.SubmissionService
+	auth             *saslauth.Authenticator
 	domainRepo       repository.DomainRepository
 	telemetryService *repService.TelemetryService
 	logger           *zap.Logger
 
 	// Security services
-	dkimSigner      *dkim.Signer
-	dkimVerifier    *dkim.Verifier
-	spfValidator    *spf.Validator
-	dmarcEnforcer   *dmarc.Enforcer
-	greylister      *greylist.Greylister
-	rateLimiter     *ratelimit.Limiter
-	adaptiveLimiter *repService.AdaptiveLimiter
-	bruteForce      *bruteforce.Protection
-	clamav          *antivirus.ClamAV
-	spamAssassin    *antispam.SpamAssassin
+	dkimVerifier  *dkim.Verifier
+	spfValidator  *spf.Validator
+	dmarcEnforcer *dmarc.Enforcer
+	greylister    *greylist.Greylister
+	rateLimiter   *ratelimit.Limiter
+	bruteForce    *bruteforce.Protection
+	clamav        *antivirus.ClamAV
+	spamAssassin  *antispam.SpamAssassin
+	rspamd        *antispam.Rspamd
+
+	// Local delivery for LMTP listeners
+	localDelivery mailService.LocalDeliveryInterface
+
+	// Milters by name; listeners attach them in the order they list them
+	milters map[string]*milter.Client
 }
 
 // NewBackend creates a new SMTP backend with all dependencies
@@ -52,15 +63,15 @@ func NewBackend(
 	userService mailService.UserServiceInterface,
 	messageService mailService.MessageServiceInterface,
 	queueService mailService.QueueServiceInterface,
+	submission *mailService.SubmissionService,
+	auth *saslauth.Authenticator,
 	domainRepo repository.DomainRepository,
 	telemetryService *repService.TelemetryService,
-	dkimSigner *dkim.Signer,
 	dkimVerifier *dkim.Verifier,
 	spfValidator *spf.Validator,
 	dmarcEnforcer *dmarc.Enforcer,
 	greylister *greylist.Greylister,
 	rateLimiter *ratelimit.Limiter,
-	adaptiveLimiter *repService.AdaptiveLimiter,
 	bruteForce *bruteforce.Protection,
 	clamav *antivirus.ClamAV,
 	spamAssassin *antispam.SpamAssassin,
@@ -70,16 +81,16 @@ func NewBackend(
 		userService:      userService,
 		messageService:   messageService,
 		queueService:     queueService,
+		submission:       submission,
+		auth:             auth,
 		domainRepo:       domainRepo,
 		telemetryService: telemetryService,
 		logger:           logger,
-		dkimSigner:       dkimSigner,
 		dkimVerifier:     dkimVerifier,
 		spfValidator:     spfValidator,
 		dmarcEnforcer:    dmarcEnforcer,
 		greylister:       greylister,
 		rateLimiter:      rateLimiter,
-		adaptiveLimiter:  adaptiveLimiter,
 		bruteForce:       bruteForce,
 		clamav:           clamav,
 		spamAssassin:     spamAssassin,
@@ -87,182 +98,156 @@ func NewBackend(
 }
 
 // NewSession creates a new SMTP session
+// Servers created by Server bind sessions to their listener; a bare Backend
+// applies submission policy so mail is never accepted without authentication.
 func (b *Backend) NewSession(c *smtp.Conn) (smtp.Session, error) {
+	return b.newSession(c, &Listener{
+		Name:          "default",
+		Role:          RoleSubmission,
+		RequireAuth:   true,
+		MaxRecipients: defaultMaxRecipients,
+	}), nil
+}
+
+// newSession creates a session governed by a listener's policy
+func (b *Backend) newSession(c *smtp.Conn, l *Listener) *Session {
+	// Unix socket peers have no address
+	remoteAddr := "unix"
+	if addr := c.Conn().RemoteAddr(); addr != nil && addr.String() != "" {
+		remoteAddr = addr.String()
+	}
 	return &Session{
-		conn:           c,
-		backend:        b,
-		logger:         b.logger,
-		remoteAddr:     c.Conn().RemoteAddr().String(),
-		authenticated:  false,
-	}, nil
+		conn:          c,
+		backend:       b,
+		listener:      l,
+		logger:        b.logger.With(zap.String("listener", l.Name), zap.String("role", string(l.Role))),
+		remoteAddr:    remoteAddr,
+		authenticated: false,
+	}
 }
 
 // Session represents an SMTP session
 type Session struct {
 	conn           *smtp.Conn
 	backend        *Backend
+	listener       *Listener
 	logger         *zap.Logger
 	remoteAddr     string
 	authenticated  bool
 	username       string
+	user           *domain.User
 	from           string
 	to             []string
+	bodyType       smtp.BodyType
+
+	// ESMTP envelope parameters persisted with the queued message
+	utf8          bool
+	requireTLS    bool
+	dsnReturn     smtp.DSNReturn
+	dsnEnvelopeID string
+	dsnRcpts      []domain.RecipientDSN
+
+	// Milters attached by the listener; discard drops the current message
+	milters []*milterConn
+	discard bool
 }
 
 // AuthPlain implements PLAIN authentication
 func (s *Session) AuthPlain(username, password string) error {
-	s.logger.Info("SMTP authentication attempt",
-		zap.String("username", username),
-		zap.String("remote_addr", s.remoteAddr),
-		zap.String("method", "PLAIN"),
-	)
+	return s.login(saslauth.Plain, username, func() (*domain.User, error) {
+		return s.backend.userService.AuthenticateClient(username, password, domain.AppPasswordScopeSMTP)
+	})
+}
 
-	// Extract domain from username
-	domain := extractDomain(username)
-	if domain == "" {
-		return &smtp.SMTPError{
-			Code:         535,
-			EnhancedCode: smtp.EnhancedCode{5, 7, 8},
-			Message:      "Invalid username format",
-		}
+// login delegates a credential check to saslauth.LoginPolicy
+func (s *Session) login(mech, username string, verify func() (*domain.User, error)) error {
+	policy := saslauth.LoginPolicy{
+		Protocol:   "SMTP",
+		Domains:    s.backend.domainRepo,
+		BruteForce: s.backend.bruteForce,
+		RateLimit:  saslauth.IPRateLimit(s.backend.rateLimiter),
+		Logger:     s.logger,
 	}
-
-	// Load domain configuration
-	domainConfig, err := s.backend.domainRepo.GetByName(domain)
+	user, err := policy.Login(s.remoteAddr, mech, username, verify)
 	if err != nil {
-		s.logger.Error("failed to load domain config",
-			zap.String("domain", domain),
-			zap.Error(err),
-		)
-		// Continue with authentication even if domain config fails
-		domainConfig = nil
-	}
-
-	// Extract IP address
-	remoteIP := extractIP(s.remoteAddr)
-
-	// Check brute force protection if enabled
-	if domainConfig != nil && s.backend.bruteForce != nil && domainConfig.AuthBruteForceEnabled {
-		blocked, err := s.backend.bruteForce.IsBlocked(remoteIP)
-		if err != nil {
-			s.logger.Error("brute force check failed", zap.Error(err))
-		} else if blocked {
-			s.logger.Warn("SMTP authentication blocked - brute force protection",
-				zap.String("username", username),
-				zap.String("remote_ip", remoteIP),
-			)
-			return &smtp.SMTPError{
-				Code:         421,
-				EnhancedCode: smtp.EnhancedCode{4, 7, 1},
-				Message:      "Too many failed login attempts",
-			}
-		}
+		return loginError(err)
 	}
 
-	// Check auth rate limiting if enabled
-	if domainConfig != nil && s.backend.rateLimiter != nil && domainConfig.RateLimitEnabled {
-		allowed, err := s.backend.rateLimiter.CheckAuth(remoteIP)
-		if err != nil {
-			s.logger.Error("rate limit check failed", zap.Error(err))
-		} else if !allowed {
-			s.logger.Warn("SMTP authentication rate limited",
-				zap.String("username", username),
-				zap.String("remote_ip", remoteIP),
-				zap.String("domain", domain),
-			)
-			return &smtp.SMTPError{
-				Code:         421,
-				EnhancedCode: smtp.EnhancedCode{4, 7, 1},
-				Message:      "Rate limit exceeded",
-			}
-		}
-	}
-
-	user, err := s.backend.userService.Authenticate(username, password)
-	if err != nil {
-		// Record failed login attempt for brute force protection
-		if domainConfig != nil && s.backend.bruteForce != nil && domainConfig.AuthBruteForceEnabled {
-			if err := s.backend.bruteForce.RecordFailure(remoteIP, username); err != nil {
-				s.logger.Error("failed to record login failure", zap.Error(err))
-			}
-		}
+	s.authenticated = true
+	s.username = username
+	s.user = user
+	return nil
+}
 
-		s.logger.Warn("SMTP authentication failed",
-			zap.String("username", username),
-			zap.String("remote_addr", s.remoteAddr),
-			zap.Error(err),
-		)
+// loginError returns the SMTP reply for a refused login
+func loginError(err error) *smtp.SMTPError {
+	switch {
+	case errors.Is(err, saslauth.ErrInvalidUsername):
 		return &smtp.SMTPError{
 			Code:         535,
 			EnhancedCode: smtp.EnhancedCode{5, 7, 8},
-			Message:      "Authentication failed",
+			Message:      "Invalid username format",
 		}
-	}
-
-	if user.Status != "active" {
-		s.logger.Warn("SMTP authentication failed - user disabled",
-			zap.String("username", username),
-			zap.String("status", user.Status),
-		)
+	case errors.Is(err, saslauth.ErrLoginBlocked):
+		return &smtp.SMTPError{
+			Code:         421,
+			EnhancedCode: smtp.EnhancedCode{4, 7, 1},
+			Message:      "Too many failed login attempts",
+		}
+	case errors.Is(err, saslauth.ErrRateLimited):
+		return &smtp.SMTPError{
+			Code:         421,
+			EnhancedCode: smtp.EnhancedCode{4, 7, 1},
+			Message:      "Rate limit exceeded",
+		}
+	case errors.Is(err, saslauth.ErrAccountDisabled):
 		return &smtp.SMTPError{
 			Code:         535,
 			EnhancedCode: smtp.EnhancedCode{5, 7, 8},
 			Message:      "Account disabled",
 		}
 	}
-
-	// Record successful login for brute force protection
-	if domainConfig != nil && s.backend.bruteForce != nil && domainConfig.AuthBruteForceEnabled {
-		if err := s.backend.bruteForce.RecordSuccess(remoteIP, username); err != nil {
-			s.logger.Error("failed to record successful login", zap.Error(err))
-		}
+	return &smtp.SMTPError{
+		Code:         535,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 8},
+		Message:      "Authentication failed",
 	}
-
-	s.authenticated = true
-	s.username = username
-
-	s.logger.Info("SMTP authentication successful",
-		zap.String("username", username),
-		zap.String("remote_addr", s.remoteAddr),
-	)
-
-	return nil
 }
 
 // AuthMechanisms returns the list of supported authentication mechanisms
 // This method implements the AuthSession interface to enable AUTH advertisement
 func (s *Session) AuthMechanisms() []string {
-	return []string{sasl.Plain}
+	return s.backend.auth.Mechanisms()
 }
 
 // Auth creates a SASL server for the specified mechanism
 // This method implements the AuthSession interface to enable AUTH advertisement
 func (s *Session) Auth(mech string) (sasl.Server, error) {
-	if mech != sasl.Plain {
+	server, err := s.backend.auth.NewServer(mech, domain.AppPasswordScopeSMTP, func(username string, verify func() (*domain.User, error)) error {
+		return s.login(mech, username, verify)
+	})
+	if err != nil {
 		return nil, &smtp.SMTPError{
 			Code:         504,
 			EnhancedCode: smtp.EnhancedCode{5, 7, 4},
 			Message:      "Unsupported authentication mechanism",
 		}
 	}
-
-	return sasl.NewPlainServer(func(identity, username, password string) error {
-		// The identity parameter is typically empty for PLAIN auth
-		// Use username if provided, otherwise fall back to identity
-		authUser := username
-		if authUser == "" {
-			authUser = identity
-		}
-
-		return s.AuthPlain(authUser, password)
-	}), nil
+	return server, nil
 }
 
 // Mail is called when the client sends MAIL FROM
 func (s *Session) Mail(from string, opts *smtp.MailOptions) error {
-	// For relay (port 25), no authentication required
-	// For submission (port 587, 465), authentication required
-	if !s.authenticated && s.conn.Server().Addr != fmt.Sprintf(":%d", 25) {
+	if s.listener.RequireTLS && !s.isTLS() {
+		return &smtp.SMTPError{
+			Code:         530,
+			EnhancedCode: smtp.EnhancedCode{5, 7, 0},
+			Message:      "Must issue a STARTTLS command first",
+		}
+	}
+
+	// Submission listeners only accept mail from authenticated clients
+	if !s.authenticated && s.listener.RequireAuth {
 		return &smtp.SMTPError{
 			Code:         530,
 			EnhancedCode: smtp.EnhancedCode{5, 7, 0},
@@ -270,6 +255,26 @@ func (s *Session) Mail(from string, opts *smtp.MailOptions) error {
 		}
 	}
 
+	// Internationalized addresses are only allowed with SMTPUTF8 (RFC 6531)
+	if !mailService.IsASCIIAddress(from) && (opts == nil || !opts.UTF8) {
+		return &smtp.SMTPError{
+			Code:         553,
+			EnhancedCode: smtp.EnhancedCode{5, 6, 7},
+			Message:      "Non-ASCII address requires SMTPUTF8",
+		}
+	}
+
+	// REQUIRETLS may only be requested over an encrypted connection (RFC 8689)
+	if opts != nil && opts.RequireTLS {
+		if !s.isTLS() {
+			return &smtp.SMTPError{
+				Code:         530,
+				EnhancedCode: smtp.EnhancedCode{5, 7, 10},
+				Message:      "REQUIRETLS needs an encrypted connection",
+			}
+		}
+	}
+
 	// Extract domain for rate limiting
 	domain := extractDomain(from)
 	if domain == "" {
@@ -291,8 +296,9 @@ func (s *Session) Mail(from string, opts *smtp.MailOptions) error {
 		domainConfig = nil
 	}
 
-	// Check SMTP rate limiting if enabled
-	if domainConfig != nil && s.backend.rateLimiter != nil && domainConfig.RateLimitEnabled {
+	// Check SMTP rate limiting if enabled; LMTP clients are the upstream MTA
+	// relaying everyone's mail, so per-IP limits do not apply to them
+	if domainConfig != nil && s.backend.rateLimiter != nil && domainConfig.RateLimitEnabled && s.listener.Role != RoleLMTP {
 		remoteIP := extractIP(s.remoteAddr)
 
 		// Check per-IP rate limit
@@ -311,77 +317,28 @@ func (s *Session) Mail(from string, opts *smtp.MailOptions) error {
 				Message:      "Rate limit exceeded",
 			}
 		}
+	}
 
-		// Check per-user rate limit if authenticated
-		if s.authenticated {
-			allowed, err := s.backend.rateLimiter.CheckUser(s.username)
-			if err != nil {
-				s.logger.Error("user rate limit check failed", zap.Error(err))
-			} else if !allowed {
-				s.logger.Warn("SMTP rate limited by user",
-					zap.String("from", from),
-					zap.String("username", s.username),
-					zap.String("domain", domain),
-				)
-				return &smtp.SMTPError{
-					Code:         421,
-					EnhancedCode: smtp.EnhancedCode{4, 7, 1},
-					Message:      "Rate limit exceeded",
-				}
-			}
+	// Authenticated clients get the same sender authorization and outbound
+	// limits as webmail and API submissions
+	if s.authenticated {
+		if err := s.backend.submission.Check(context.Background(), s.submitter(), from); err != nil {
+			return submissionReply(err)
 		}
 	}
 
-	// Check adaptive rate limiting (reputation-aware, circuit breaker, warm-up)
-	if s.backend.adaptiveLimiter != nil {
-		ctx := context.Background()
-		allowed, err := s.backend.adaptiveLimiter.CheckDomain(ctx, domain)
-		if err != nil {
-			// Check if it's a circuit breaker error
-			if err == repService.ErrCircuitBreakerActive {
-				s.logger.Warn("Domain paused by circuit breaker",
-					zap.String("domain", domain),
-					zap.String("from", from),
-				)
-				return &smtp.SMTPError{
-					Code:         421,
-					EnhancedCode: smtp.EnhancedCode{4, 7, 1},
-					Message:      "Sending paused for this domain due to reputation issues",
-				}
-			}
-
-			// Check if it's a warm-up limit error
-			if err == repService.ErrWarmUpLimitExceeded {
-				s.logger.Warn("Warm-up volume limit exceeded",
-					zap.String("domain", domain),
-					zap.String("from", from),
-				)
-				return &smtp.SMTPError{
-					Code:         421,
-					EnhancedCode: smtp.EnhancedCode{4, 7, 1},
-					Message:      "Daily sending limit reached during warm-up period",
-				}
-			}
-
-			// Other errors - log but allow
-			s.logger.Error("adaptive limiter check failed",
-				zap.String("domain", domain),
-				zap.Error(err),
-			)
-		} else if !allowed {
-			s.logger.Warn("Domain rate limited by reputation",
-				zap.String("domain", domain),
-				zap.String("from", from),
-			)
-			return &smtp.SMTPError{
-				Code:         421,
-				EnhancedCode: smtp.EnhancedCode{4, 7, 1},
-				Message:      "Domain sending rate limit exceeded",
-			}
-		}
+	if err := s.milterMail(from, opts); err != nil {
+		return err
 	}
 
 	s.from = from
+	if opts != nil {
+		s.bodyType = opts.Body
+		s.utf8 = opts.UTF8
+		s.requireTLS = opts.RequireTLS
+		s.dsnReturn = opts.Return
+		s.dsnEnvelopeID = opts.EnvelopeID
+	}
 	s.logger.Debug("MAIL FROM",
 		zap.String("from", from),
 		zap.String("remote_addr", s.remoteAddr),
@@ -398,6 +355,40 @@ func (s *Session) Rcpt(to string, opts *smtp.RcptOptions) error {
 	// TODO: Check greylisting
 	// TODO: Check rate limiting
 
+	if !mailService.IsASCIIAddress(to) && !s.utf8 {
+		return &smtp.SMTPError{
+			Code:         553,
+			EnhancedCode: smtp.EnhancedCode{5, 6, 7},
+			Message:      "Non-ASCII address requires SMTPUTF8",
+		}
+	}
+
+	var dsn *domain.RecipientDSN
+	if opts != nil && (len(opts.Notify) > 0 || opts.OriginalRecipient != "") {
+		dsn = &domain.RecipientDSN{Recipient: to}
+		for _, n := range opts.Notify {
+			dsn.Notify = append(dsn.Notify, string(n))
+		}
+		if opts.OriginalRecipient != "" {
+			dsn.ORCPT = string(opts.OriginalRecipientType) + ";" + opts.OriginalRecipient
+		}
+	}
+
+	if err := s.checkRelay(to); err != nil {
+		return err
+	}
+
+	if err := s.checkLMTPRecipient(to); err != nil {
+		return err
+	}
+
+	if err := s.milterRcpt(to); err != nil {
+		return err
+	}
+
+	if dsn != nil {
+		s.dsnRcpts = append(s.dsnRcpts, *dsn)
+	}
 	s.to = append(s.to, to)
 	s.logger.Debug("RCPT TO",
 		zap.String("to", to),
@@ -408,6 +399,30 @@ func (s *Session) Rcpt(to string, opts *smtp.RcptOptions) error {
 	return nil
 }
 
+// mayRelay reports whether the session may send mail to other servers:
+// only clients authenticated on a submission listener may
+func (s *Session) mayRelay() bool {
+	return s.authenticated && (s.listener.Role == RoleSubmission || s.listener.Role == RoleSubmissions)
+}
+
+// checkRelay refuses recipients outside the hosted domains unless the
+// session may relay
+func (s *Session) checkRelay(to string) error {
+	if s.mayRelay() || mailService.IsHostedDomain(s.backend.domainRepo, extractDomain(to)) {
+		return nil
+	}
+	s.logger.Warn("relay denied",
+		zap.String("to", to),
+		zap.String("from", s.from),
+		zap.String("remote_addr", s.remoteAddr),
+	)
+	return &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+		Message:      "Relay access denied",
+	}
+}
+
 // Data is called when the client sends DATA
 func (s *Session) Data(r io.Reader) error {
 	s.logger.Info("receiving message",
@@ -416,19 +431,15 @@ func (s *Session) Data(r io.Reader) error {
 		zap.String("remote_addr", s.remoteAddr),
 	)
 
-	// Read entire message
-	data, err := io.ReadAll(r)
+	sp, err := s.spool(r)
 	if err != nil {
-		s.logger.Error("failed to read message data", zap.Error(err))
-		return &smtp.SMTPError{
-			Code:         451,
-			EnhancedCode: smtp.EnhancedCode{4, 0, 0},
-			Message:      "Failed to read message",
-		}
+		return err
 	}
+	defer sp.Close()
 
-	// Determine if this is inbound relay or authenticated submission
-	isInboundRelay := !s.authenticated
+	// Only clients authenticated on a submission listener submit mail;
+	// everything else is inbound and delivered to hosted domains only
+	isInboundRelay := !s.mayRelay()
 
 	// Get domain configuration for first recipient
 	var domainConfig *domain.Domain
@@ -448,9 +459,11 @@ func (s *Session) Data(r io.Reader) error {
 	}
 
 	remoteIP := extractIP(s.remoteAddr)
+	var holdReason string
+	var spam *domain.SpamVerdict
 
-	// For inbound relay, apply security checks
-	if isInboundRelay && domainConfig != nil {
+	// For inbound relay, apply security checks where the listener enables them
+	if isInboundRelay && s.listener.SecurityChecks && domainConfig != nil {
 		// 1. Greylisting
 		if s.backend.greylister != nil && domainConfig.GreylistEnabled {
 			result, err := s.backend.greylister.Check(remoteIP, s.from, s.to[0])
@@ -500,7 +513,7 @@ func (s *Session) Data(r io.Reader) error {
 
 		// 3. DKIM Verification
 		if s.backend.dkimVerifier != nil && domainConfig.DKIMVerifyEnabled {
-			verifications, err := s.backend.dkimVerifier.Verify(data)
+			verifications, err := s.backend.dkimVerifier.Verify(sp.Reader())
 			if err != nil {
 				s.logger.Warn("DKIM verification failed",
 					zap.Error(err),
@@ -527,7 +540,7 @@ func (s *Session) Data(r io.Reader) error {
 
 		// 5. Virus Scanning (ClamAV)
 		if s.backend.clamav != nil && domainConfig.ClamAVEnabled {
-			scanResult, err := s.backend.clamav.Scan(data)
+			scanResult, err := s.backend.clamav.Scan(sp.Reader())
 			if err != nil {
 				s.logger.Error("virus scan failed", zap.Error(err))
 				// Apply fail action
@@ -555,9 +568,14 @@ func (s *Session) Data(r io.Reader) error {
 			}
 		}
 
-		// 6. Spam Filtering (SpamAssassin)
-		if s.backend.spamAssassin != nil && domainConfig.SpamEnabled {
-			spamResult, err := s.backend.spamAssassin.Check(data)
+		// 6. Spam Filtering (rspamd or SpamAssassin, per domain)
+		if s.backend.rspamd != nil && domainConfig.SpamEnabled && domainConfig.SpamBackend == domain.SpamBackendRspamd {
+			spam, holdReason, err = s.checkRspamd(sp, domainConfig)
+			if err != nil {
+				return err
+			}
+		} else if s.backend.spamAssassin != nil && domainConfig.SpamEnabled {
+			spamResult, err := s.backend.spamAssassin.Check(sp.Reader())
 			if err != nil {
 				s.logger.Error("spam check failed", zap.Error(err))
 			} else {
@@ -583,27 +601,59 @@ func (s *Session) Data(r io.Reader) error {
 		}
 	}
 
-	// For outbound authenticated mail, apply DKIM signing
-	if !isInboundRelay && s.backend.dkimSigner != nil && domainConfig != nil && domainConfig.DKIMSigningEnabled {
-		senderDomain := extractDomain(s.from)
-		signedData, err := s.backend.dkimSigner.Sign(senderDomain, data)
+	// Milters run after the built-in checks and may modify, hold or discard the message
+	milterHold, err := s.filterMessage(sp)
+	if err != nil {
+		return err
+	}
+	if milterHold != "" {
+		holdReason = milterHold
+	}
+	if s.discard {
+		s.logger.Info("message discarded by milter",
+			zap.String("from", s.from),
+			zap.Strings("to", s.to),
+		)
+		return nil
+	}
+	if len(s.to) == 0 {
+		// Milters removed every recipient; like a discard, the message is accepted and dropped
+		s.logger.Info("message dropped, milters removed every recipient",
+			zap.String("from", s.from),
+		)
+		return nil
+	}
+
+	opts := &mailService.EnqueueOptions{
+		BodyType:      string(s.bodyType),
+		SMTPUTF8:      s.utf8,
+		RequireTLS:    s.requireTLS,
+		DSNReturn:     string(s.dsnReturn),
+		DSNEnvelopeID: s.dsnEnvelopeID,
+		DSNRecipients: s.dsnRcpts,
+		HoldReason:    holdReason,
+		Spam:          spam,
+		LocalOnly:     isInboundRelay,
+	}
+
+	// Queue message for delivery; authenticated submissions are finalized
+	// (header fix-ups, DKIM signing) by the submission service
+	var messageID string
+	size := sp.Size()
+	if isInboundRelay {
+		spoolPath := sp.Detach()
+		messageID, err = s.backend.queueService.EnqueueFile(s.from, s.to, spoolPath, opts)
 		if err != nil {
-			s.logger.Error("DKIM signing failed",
-				zap.Error(err),
-				zap.String("from", s.from),
-			)
-			// Continue without signing on error
-		} else {
-			data = signedData
-			s.logger.Debug("DKIM signature added",
-				zap.String("from", s.from),
-			)
+			os.Remove(spoolPath)
 		}
+	} else {
+		messageID, err = s.backend.submission.Submit(context.Background(), s.submitter(), s.from, s.to, sp, opts)
 	}
-
-	// Queue message for delivery
-	messageID, err := s.backend.queueService.Enqueue(s.from, s.to, data)
 	if err != nil {
+		var subErr *mailService.SubmissionError
+		if errors.As(err, &subErr) {
+			return submissionReply(subErr)
+		}
 		s.logger.Error("failed to queue message",
 			zap.Error(err),
 			zap.String("from", s.from),
@@ -620,52 +670,55 @@ func (s *Session) Data(r io.Reader) error {
 		zap.String("message_id", messageID),
 		zap.String("from", s.from),
 		zap.Strings("to", s.to),
-		zap.Int("size", len(data)),
+		zap.Int64("size", size),
 	)
 
-	// Record telemetry for outbound authenticated mail
-	if !isInboundRelay && s.backend.telemetryService != nil {
-		senderDomain := extractDomain(s.from)
-		for _, recipient := range s.to {
-			recipientDomain := extractDomain(recipient)
-			if recipientDomain != "" {
-				// Record as queued for delivery (will be updated when actually delivered)
-				// Note: This is optimistic - actual delivery telemetry should be recorded
-				// by the delivery worker when processing the queue
-				ctx := context.Background()
-				if err := s.backend.telemetryService.RecordDelivery(ctx, senderDomain, recipientDomain, remoteIP); err != nil {
-					s.logger.Warn("failed to record telemetry",
-						zap.Error(err),
-						zap.String("domain", senderDomain),
-					)
-				}
-			}
-		}
-	}
+	return nil
+}
 
-	// Record send for warm-up tracking (outbound only)
-	if !isInboundRelay && s.backend.adaptiveLimiter != nil {
-		senderDomain := extractDomain(s.from)
-		ctx := context.Background()
-		if err := s.backend.adaptiveLimiter.RecordSend(ctx, senderDomain); err != nil {
-			s.logger.Warn("failed to record warm-up send",
-				zap.Error(err),
-				zap.String("domain", senderDomain),
-			)
+// spool writes the message to disk; scanners stream from the spool rather than memory
+func (s *Session) spool(r io.Reader) (*mailService.Spool, error) {
+	sp, err := mailService.NewSpool(s.backend.queueService.SpoolDir(), r, s.listener.MaxMessageBytes)
+	if err == nil {
+		return sp, nil
+	}
+	if errors.Is(err, mailService.ErrMessageTooLarge) || errors.Is(err, smtp.ErrDataTooLarge) {
+		s.logger.Warn("message exceeds size limit",
+			zap.String("from", s.from),
+			zap.Int64("limit", s.listener.MaxMessageBytes),
+		)
+		return nil, &smtp.SMTPError{
+			Code:         552,
+			EnhancedCode: smtp.EnhancedCode{5, 3, 4},
+			Message:      "Maximum message size exceeded",
 		}
 	}
-
-	return nil
+	s.logger.Error("failed to spool message data", zap.Error(err))
+	return nil, &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 0, 0},
+		Message:      "Failed to read message",
+	}
 }
 
 // Reset is called when the client sends RSET
 func (s *Session) Reset() {
 	s.from = ""
 	s.to = nil
+	s.bodyType = ""
+	s.utf8 = false
+	s.requireTLS = false
+	s.dsnReturn = ""
+	s.dsnEnvelopeID = ""
+	s.dsnRcpts = nil
+	s.abortMilters()
 }
 
 // Logout is called when the session ends
 func (s *Session) Logout() error {
+	s.abortMilters()
+	s.closeMilters()
+
 	s.logger.Debug("SMTP session ended",
 		zap.String("username", s.username),
 		zap.String("remote_addr", s.remoteAddr),
@@ -673,6 +726,37 @@ func (s *Session) Logout() error {
 	return nil
 }
 
+// submitter identifies the authenticated client to the submission service
+func (s *Session) submitter() *mailService.Submitter {
+	return &mailService.Submitter{
+		Origin:   mailService.OriginSMTP,
+		User:     s.user,
+		RemoteIP: extractIP(s.remoteAddr),
+	}
+}
+
+// submissionReply converts a submission policy rejection to an SMTP reply
+func submissionReply(err error) error {
+	var subErr *mailService.SubmissionError
+	if !errors.As(err, &subErr) {
+		return err
+	}
+	return &smtp.SMTPError{
+		Code:         subErr.Code,
+		EnhancedCode: smtp.EnhancedCode(subErr.EnhancedCode),
+		Message:      subErr.Message,
+	}
+}
+
+// isTLS reports whether the session's connection is encrypted
+func (s *Session) isTLS() bool {
+	if s.conn == nil {
+		return false
+	}
+	_, ok := s.conn.TLSConnectionState()
+	return ok
+}
+
 // extractDomain extracts domain from email address
 func extractDomain(email string) string {
 	parts := strings.Split(email, "@")
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -11,7 +12,9 @@ import (
 	"github.com/emersion/go-smtp"
 	"go.uber.org/zap"
 
+	"github.com/btafoya/gomailserver/internal/config"
 	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/service"
 )
 
 // mockUserService for SMTP backend tests
@@ -30,6 +33,10 @@ func (m *mockUserService) Authenticate(email, password string) (*domain.User, er
 	return nil, errors.New("authentication failed")
 }
 
+func (m *mockUserService) AuthenticateClient(email, password, scope string) (*domain.User, error) {
+	return m.Authenticate(email, password)
+}
+
 func (m *mockUserService) GetByEmail(email string) (*domain.User, error) {
 	return nil, nil
 }
@@ -50,12 +57,17 @@ func (m *mockUserService) Delete(id int64) error {
 	return nil
 }
 
-// mockDomainRepository for SMTP backend tests
+// mockDomainRepository for SMTP backend tests; example.com is the only hosted domain
 type mockDomainRepository struct{}
 
 func (m *mockDomainRepository) Create(domain *domain.Domain) error          { return nil }
 func (m *mockDomainRepository) GetByID(id int64) (*domain.Domain, error)    { return nil, nil }
-func (m *mockDomainRepository) GetByName(name string) (*domain.Domain, error) { return nil, nil }
+func (m *mockDomainRepository) GetByName(name string) (*domain.Domain, error) {
+	if name == "example.com" {
+		return &domain.Domain{Name: name, Status: "active"}, nil
+	}
+	return nil, nil
+}
 func (m *mockDomainRepository) Update(domain *domain.Domain) error          { return nil }
 func (m *mockDomainRepository) Delete(id int64) error                       { return nil }
 func (m *mockDomainRepository) List(offset, limit int) ([]*domain.Domain, error) { return nil, nil }
@@ -74,6 +86,10 @@ func (m *mockMessageService) Store(userID, mailboxID, uid int64, messageData []b
 	return &domain.Message{ID: 1}, nil
 }
 
+func (m *mockMessageService) Append(userID, mailboxID, uid int64, messageData []byte, flags []string, date time.Time) (*domain.Message, error) {
+	return m.Store(userID, mailboxID, uid, messageData)
+}
+
 func (m *mockMessageService) GetByID(id int64) (*domain.Message, error) {
 	return nil, nil
 }
@@ -82,6 +98,26 @@ func (m *mockMessageService) GetByMailbox(mailboxID int64, offset, limit int) ([
 	return nil, nil
 }
 
+func (m *mockMessageService) List(mailboxID int64) ([]*domain.Message, error) {
+	return nil, nil
+}
+
+func (m *mockMessageService) Query(q *domain.MessageQuery) (*domain.MessageQueryResult, error) {
+	return &domain.MessageQueryResult{}, nil
+}
+
+func (m *mockMessageService) SetFlags(id int64, flags []string) error {
+	return nil
+}
+
+func (m *mockMessageService) Copy(id int64, target *domain.Mailbox, uid int64) (*domain.Message, error) {
+	return nil, nil
+}
+
+func (m *mockMessageService) Move(id int64, target *domain.Mailbox, uid int64) error {
+	return nil
+}
+
 func (m *mockMessageService) Delete(id int64) error {
 	return nil
 }
@@ -98,6 +134,20 @@ func (m *mockQueueService) Enqueue(sender string, recipients []string, message [
 	return "message-id", nil
 }
 
+// EnqueueFile reads the spooled message and hands it to enqueueFunc like Enqueue
+func (m *mockQueueService) EnqueueFile(sender string, recipients []string, spoolPath string, opts *service.EnqueueOptions) (string, error) {
+	message, err := os.ReadFile(spoolPath)
+	if err != nil {
+		return "", err
+	}
+	os.Remove(spoolPath)
+	return m.Enqueue(sender, recipients, message)
+}
+
+func (m *mockQueueService) SpoolDir() string {
+	return os.TempDir()
+}
+
 func (m *mockQueueService) GetPending() ([]*domain.QueueItem, error) {
 	return nil, nil
 }
@@ -145,6 +195,7 @@ func TestSession_AuthPlain(t *testing.T) {
 			userService:    userSvc,
 			messageService: &mockMessageService{},
 			queueService:   &mockQueueService{},
+			submission:     testSubmission(&mockQueueService{}),
 			domainRepo:     &mockDomainRepository{},
 			logger:         logger,
 		}
@@ -176,6 +227,7 @@ func TestSession_AuthPlain(t *testing.T) {
 			userService:    userSvc,
 			messageService: &mockMessageService{},
 			queueService:   &mockQueueService{},
+			submission:     testSubmission(&mockQueueService{}),
 			domainRepo:     &mockDomainRepository{},
 			logger:         logger,
 		}
@@ -199,12 +251,13 @@ func TestSession_Mail(t *testing.T) {
 		userService:    &mockUserService{},
 		messageService: &mockMessageService{},
 		queueService:   &mockQueueService{},
+		submission:     testSubmission(&mockQueueService{}),
 		domainRepo:     &mockDomainRepository{},
 		logger:         logger,
 	}
 
 	t.Run("accepts valid sender", func(t *testing.T) {
-		session := &Session{backend: backend, logger: logger, authenticated: true}
+		session := &Session{backend: backend, listener: testListener(RoleSubmission), logger: logger, authenticated: true, user: testUser}
 
 		err := session.Mail("sender@example.com", &smtp.MailOptions{})
 		if err != nil {
@@ -216,10 +269,44 @@ func TestSession_Mail(t *testing.T) {
 		}
 	})
 
+	t.Run("rejects sender the user may not send as", func(t *testing.T) {
+		session := &Session{backend: backend, listener: testListener(RoleSubmission), logger: logger, authenticated: true, user: testUser}
+
+		err := session.Mail("someone-else@example.com", &smtp.MailOptions{})
+		var smtpErr *smtp.SMTPError
+		if !errors.As(err, &smtpErr) || smtpErr.Code != 553 {
+			t.Fatalf("expected 553 error, got %v", err)
+		}
+	})
+
 	t.Run("rejects mail without authentication for submission", func(t *testing.T) {
-		// Skip: This test requires smtp.Conn to check server port
-		// Can't mock smtp.Conn easily in unit tests
-		t.Skip("Skipping port-based authentication check - requires smtp.Conn")
+		session := &Session{backend: backend, listener: testListener(RoleSubmission), logger: logger}
+
+		err := session.Mail("sender@example.com", &smtp.MailOptions{})
+		var smtpErr *smtp.SMTPError
+		if !errors.As(err, &smtpErr) || smtpErr.Code != 530 {
+			t.Fatalf("expected 530 error, got %v", err)
+		}
+	})
+
+	t.Run("accepts unauthenticated mail on mx listener", func(t *testing.T) {
+		session := &Session{backend: backend, listener: testListener(RoleMX), logger: logger}
+
+		if err := session.Mail("sender@example.com", &smtp.MailOptions{}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	})
+
+	t.Run("requires STARTTLS when listener demands TLS", func(t *testing.T) {
+		listener := testListener(RoleSubmission)
+		listener.RequireTLS = true
+		session := &Session{backend: backend, listener: listener, logger: logger, authenticated: true, user: testUser}
+
+		err := session.Mail("sender@example.com", &smtp.MailOptions{})
+		var smtpErr *smtp.SMTPError
+		if !errors.As(err, &smtpErr) || smtpErr.Code != 530 {
+			t.Fatalf("expected 530 error, got %v", err)
+		}
 	})
 }
 
@@ -229,15 +316,18 @@ func TestSession_Rcpt(t *testing.T) {
 		userService:    &mockUserService{},
 		messageService: &mockMessageService{},
 		queueService:   &mockQueueService{},
+		submission:     testSubmission(&mockQueueService{}),
 		domainRepo:     &mockDomainRepository{},
 		logger:         logger,
 	}
 
 	t.Run("accepts valid recipient", func(t *testing.T) {
 		session := &Session{
+			listener:      testListener(RoleSubmission),
 			backend:       backend,
 			logger:        logger,
 			authenticated: true,
+			user:          testUser,
 			from:          "sender@example.com",
 		}
 
@@ -257,9 +347,11 @@ func TestSession_Rcpt(t *testing.T) {
 
 	t.Run("accepts multiple recipients", func(t *testing.T) {
 		session := &Session{
+			listener:      testListener(RoleSubmission),
 			backend:       backend,
 			logger:        logger,
 			authenticated: true,
+			user:          testUser,
 			from:          "sender@example.com",
 		}
 
@@ -275,6 +367,40 @@ func TestSession_Rcpt(t *testing.T) {
 			t.Errorf("expected %d recipients, got %d", len(recipients), len(session.to))
 		}
 	})
+
+	t.Run("refuses to relay for unauthenticated clients", func(t *testing.T) {
+		session := &Session{
+			listener: testListener(RoleMX),
+			backend:  backend,
+			logger:   logger,
+			from:     "sender@remote.example",
+		}
+
+		err := session.Rcpt("victim@elsewhere.example", &smtp.RcptOptions{})
+		var smtpErr *smtp.SMTPError
+		if !errors.As(err, &smtpErr) || smtpErr.Code != 550 || smtpErr.EnhancedCode != (smtp.EnhancedCode{5, 7, 1}) {
+			t.Fatalf("expected 550 5.7.1, got %v", err)
+		}
+		if len(session.to) != 0 {
+			t.Errorf("expected no recipients, got %v", session.to)
+		}
+
+		if err := session.Rcpt("user@example.com", &smtp.RcptOptions{}); err != nil {
+			t.Errorf("expected hosted recipient to be accepted, got %v", err)
+		}
+	})
+
+	t.Run("relays only for submission listeners", func(t *testing.T) {
+		submission := &Session{listener: testListener(RoleSubmission), backend: backend, logger: logger, authenticated: true, user: testUser}
+		if err := submission.Rcpt("friend@elsewhere.example", &smtp.RcptOptions{}); err != nil {
+			t.Errorf("expected authenticated submission to relay, got %v", err)
+		}
+
+		mx := &Session{listener: testListener(RoleMX), backend: backend, logger: logger, authenticated: true, user: testUser}
+		if err := mx.Rcpt("friend@elsewhere.example", &smtp.RcptOptions{}); err == nil {
+			t.Error("expected authenticated mx session to be refused relaying")
+		}
+	})
 }
 
 func TestSession_Data(t *testing.T) {
@@ -299,14 +425,17 @@ func TestSession_Data(t *testing.T) {
 			userService:    &mockUserService{},
 			messageService: &mockMessageService{},
 			queueService:   queueSvc,
+			submission:     testSubmission(queueSvc),
 			domainRepo:     &mockDomainRepository{},
 			logger:         logger,
 		}
 
 		session := &Session{
+			listener:      testListener(RoleSubmission),
 			backend:       backend,
 			logger:        logger,
 			authenticated: true,
+			user:          testUser,
 			from:          "sender@example.com",
 			to:            []string{"user1@example.com", "user2@example.com"},
 		}
@@ -342,14 +471,17 @@ This is a test message.`
 			userService:    &mockUserService{},
 			messageService: &mockMessageService{},
 			queueService:   queueSvc,
+			submission:     testSubmission(queueSvc),
 			domainRepo:     &mockDomainRepository{},
 			logger:         logger,
 		}
 
 		session := &Session{
+			listener:      testListener(RoleSubmission),
 			backend:       backend,
 			logger:        logger,
 			authenticated: true,
+			user:          testUser,
 			from:          "sender@example.com",
 			to:            []string{"recipient@example.com"},
 		}
@@ -372,14 +504,17 @@ This is a test message.`
 			userService:    &mockUserService{},
 			messageService: &mockMessageService{},
 			queueService:   &mockQueueService{},
+			submission:     testSubmission(&mockQueueService{}),
 			domainRepo:     &mockDomainRepository{},
 			logger:         logger,
 		}
 
 		session := &Session{
+			listener:      testListener(RoleSubmission),
 			backend:       backend,
 			logger:        logger,
 			authenticated: true,
+			user:          testUser,
 			from:          "sender@example.com",
 			to:            []string{"recipient@example.com"},
 		}
@@ -392,6 +527,130 @@ This is a test message.`
 			t.Errorf("unexpected error for empty message: %v", err)
 		}
 	})
+	t.Run("rejects message over size limit", func(t *testing.T) {
+		queueCalled := false
+		queueSvc := &mockQueueService{
+			enqueueFunc: func(sender string, recipients []string, message []byte) (string, error) {
+				queueCalled = true
+				return "test-message-id", nil
+			},
+		}
+
+		backend := &Backend{
+			userService:    &mockUserService{},
+			messageService: &mockMessageService{},
+			queueService:   queueSvc,
+			submission:     testSubmission(queueSvc),
+			domainRepo:     &mockDomainRepository{},
+			logger:         logger,
+		}
+
+		sizeLimited := testListener(RoleSubmission)
+		sizeLimited.MaxMessageBytes = 16
+
+		session := &Session{
+			listener:      sizeLimited,
+			backend:       backend,
+			logger:        logger,
+			authenticated: true,
+			user:          testUser,
+			from:          "sender@example.com",
+			to:            []string{"recipient@example.com"},
+		}
+
+		err := session.Data(strings.NewReader("Subject: Test\r\n\r\nThis body is too long"))
+
+		var smtpErr *smtp.SMTPError
+		if !errors.As(err, &smtpErr) || smtpErr.Code != 552 {
+			t.Fatalf("expected 552 error, got %v", err)
+		}
+		if queueCalled {
+			t.Error("expected oversized message not to be queued")
+		}
+	})
+
+	t.Run("records BINARYMIME body type", func(t *testing.T) {
+		var opts *service.EnqueueOptions
+		queueSvc := &recordingQueueService{onEnqueueFile: func(o *service.EnqueueOptions) { opts = o }}
+
+		backend := &Backend{
+			userService:    &mockUserService{},
+			messageService: &mockMessageService{},
+			queueService:   queueSvc,
+			submission:     testSubmission(queueSvc),
+			domainRepo:     &mockDomainRepository{},
+			logger:         logger,
+		}
+
+		session := &Session{
+			listener:      testListener(RoleSubmission),
+			backend:       backend,
+			logger:        logger,
+			authenticated: true,
+			user:          testUser,
+			to:            []string{"recipient@example.com"},
+		}
+		session.Mail("sender@example.com", &smtp.MailOptions{Body: smtp.BodyBinaryMIME})
+
+		if err := session.Data(strings.NewReader("Subject: Test\r\n\r\n\x00\x01")); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if opts == nil || opts.BodyType != "BINARYMIME" {
+			t.Errorf("expected BINARYMIME body type, got %+v", opts)
+		}
+		if opts != nil && opts.LocalOnly {
+			t.Error("expected submitted mail to be relayable")
+		}
+	})
+
+	t.Run("queues inbound mail for local delivery only", func(t *testing.T) {
+		var opts *service.EnqueueOptions
+		queueSvc := &recordingQueueService{onEnqueueFile: func(o *service.EnqueueOptions) { opts = o }}
+
+		backend := &Backend{
+			userService:    &mockUserService{},
+			messageService: &mockMessageService{},
+			queueService:   queueSvc,
+			submission:     testSubmission(queueSvc),
+			domainRepo:     &mockDomainRepository{},
+			logger:         logger,
+		}
+
+		session := &Session{listener: testListener(RoleMX), backend: backend, logger: logger}
+		session.Mail("sender@remote.example", &smtp.MailOptions{})
+		session.Rcpt("user@example.com", &smtp.RcptOptions{})
+
+		if err := session.Data(strings.NewReader("Subject: Test\r\n\r\nBody")); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if opts == nil || !opts.LocalOnly {
+			t.Errorf("expected inbound mail to be queued local only, got %+v", opts)
+		}
+	})
+}
+
+// testListener returns a listener with the default policy for a role
+func testListener(role Role) *Listener {
+	return newListener(config.SMTPListenerConfig{Name: "test", Role: string(role)}, &config.SMTPConfig{})
+}
+
+// testUser is the authenticated client in submission tests
+var testUser = &domain.User{ID: 1, Email: "sender@example.com", Status: "active"}
+
+// testSubmission returns a submission service that queues through queueSvc
+func testSubmission(queueSvc service.QueueServiceInterface) *service.SubmissionService {
+	return service.NewSubmissionService(nil, nil, nil, &mockDomainRepository{}, queueSvc, nil, nil, nil, zap.NewNop())
+}
+
+// recordingQueueService captures the options passed to EnqueueFile
+type recordingQueueService struct {
+	mockQueueService
+	onEnqueueFile func(*service.EnqueueOptions)
+}
+
+func (m *recordingQueueService) EnqueueFile(sender string, recipients []string, spoolPath string, opts *service.EnqueueOptions) (string, error) {
+	m.onEnqueueFile(opts)
+	return m.mockQueueService.EnqueueFile(sender, recipients, spoolPath, opts)
 }
 
 func TestSession_Reset(t *testing.T) {
@@ -400,14 +659,17 @@ func TestSession_Reset(t *testing.T) {
 		userService:    &mockUserService{},
 		messageService: &mockMessageService{},
 		queueService:   &mockQueueService{},
+		submission:     testSubmission(&mockQueueService{}),
 		domainRepo:     &mockDomainRepository{},
 		logger:         logger,
 	}
 
 	session := &Session{
+		listener:      testListener(RoleSubmission),
 		backend:       backend,
 		logger:        logger,
 		authenticated: true,
+		user:          testUser,
 		from:          "sender@example.com",
 		to:            []string{"recipient@example.com"},
 	}
@@ -434,14 +696,17 @@ func TestSession_Logout(t *testing.T) {
 		userService:    &mockUserService{},
 		messageService: &mockMessageService{},
 		queueService:   &mockQueueService{},
+		submission:     testSubmission(&mockQueueService{}),
 		domainRepo:     &mockDomainRepository{},
 		logger:         logger,
 	}
 
 	session := &Session{
+		listener:      testListener(RoleSubmission),
 		backend:       backend,
 		logger:        logger,
 		authenticated: true,
+		user:          testUser,
 		username:      "test@example.com",
 	}
 
@@ -452,3 +717,78 @@ func TestSession_Logout(t *testing.T) {
 
 	// Session should still be usable after logout (for next command)
 }
+
+func TestSession_EnvelopeParameters(t *testing.T) {
+	logger := zap.NewNop()
+
+	t.Run("rejects internationalized address without SMTPUTF8", func(t *testing.T) {
+		session := &Session{
+			listener:      testListener(RoleSubmission),
+			backend:       &Backend{domainRepo: &mockDomainRepository{}, logger: logger},
+			logger:        logger,
+			authenticated: true,
+			user:          testUser,
+			from:          "sender@example.com",
+		}
+
+		err := session.Rcpt("用户@example.com", &smtp.RcptOptions{})
+		var smtpErr *smtp.SMTPError
+		if !errors.As(err, &smtpErr) || smtpErr.Code != 553 {
+			t.Fatalf("expected 553 error, got %v", err)
+		}
+
+		session.utf8 = true
+		if err := session.Rcpt("用户@example.com", &smtp.RcptOptions{}); err != nil {
+			t.Fatalf("expected SMTPUTF8 recipient to be accepted, got %v", err)
+		}
+	})
+
+	t.Run("persists DSN and SMTPUTF8 parameters", func(t *testing.T) {
+		var opts *service.EnqueueOptions
+		queueSvc := &recordingQueueService{onEnqueueFile: func(o *service.EnqueueOptions) { opts = o }}
+
+		session := &Session{
+			listener: testListener(RoleSubmission),
+			backend: &Backend{
+				userService:    &mockUserService{},
+				messageService: &mockMessageService{},
+				queueService:   queueSvc,
+				submission:     testSubmission(queueSvc),
+				domainRepo:     &mockDomainRepository{},
+				logger:         logger,
+			},
+			logger:        logger,
+			authenticated: true,
+			user:          testUser,
+		}
+
+		if err := session.Mail("sender@example.com", &smtp.MailOptions{
+			UTF8:       true,
+			Return:     smtp.DSNReturnHeaders,
+			EnvelopeID: "env-1",
+		}); err != nil {
+			t.Fatalf("MAIL failed: %v", err)
+		}
+		if err := session.Rcpt("rcpt@example.com", &smtp.RcptOptions{
+			Notify:                []smtp.DSNNotify{smtp.DSNNotifyFailure, smtp.DSNNotifyDelayed},
+			OriginalRecipientType: smtp.DSNAddressTypeRFC822,
+			OriginalRecipient:     "orig@example.com",
+		}); err != nil {
+			t.Fatalf("RCPT failed: %v", err)
+		}
+		if err := session.Data(strings.NewReader("Subject: Test\r\n\r\nBody")); err != nil {
+			t.Fatalf("DATA failed: %v", err)
+		}
+
+		if opts == nil || !opts.SMTPUTF8 || opts.DSNReturn != "HDRS" || opts.DSNEnvelopeID != "env-1" {
+			t.Fatalf("unexpected envelope options: %+v", opts)
+		}
+		if len(opts.DSNRecipients) != 1 {
+			t.Fatalf("expected 1 recipient DSN entry, got %d", len(opts.DSNRecipients))
+		}
+		rcpt := opts.DSNRecipients[0]
+		if rcpt.Recipient != "rcpt@example.com" || strings.Join(rcpt.Notify, ",") != "FAILURE,DELAY" || rcpt.ORCPT != "RFC822;orig@example.com" {
+			t.Errorf("unexpected recipient DSN: %+v", rcpt)
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package smtp
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/emersion/go-smtp"
+
+	"github.com/btafoya/gomailserver/internal/config"
+)
+
+// Role identifies what an SMTP listener is used for
+type Role string
+
+const (
+	// RoleMX accepts inbound mail from other servers (port 25)
+	RoleMX Role = "mx"
+	// RoleSubmission accepts authenticated client submission with STARTTLS (port 587)
+	RoleSubmission Role = "submission"
+	// RoleSubmissions accepts authenticated client submission over implicit TLS (port 465)
+	RoleSubmissions Role = "submissions"
+	// RoleLMTP accepts final delivery from a trusted local MTA
+	RoleLMTP Role = "lmtp"
+)
+
+// defaultMaxRecipients is used when a listener does not set max_recipients
+const defaultMaxRecipients = 100
+
+// defaultSocketMode lets the MTA's group connect to an LMTP socket
+const defaultSocketMode os.FileMode = 0660
+
+// Listener is one SMTP listener and the policy applied to its sessions
+type Listener struct {
+	Name            string
+	Role            Role
+	Addresses       []string
+	RequireTLS      bool
+	RequireAuth     bool
+	SecurityChecks  bool
+	MaxMessageBytes int64
+	MaxRecipients   int
+	ProxyProtocol   bool
+	Milters         []string
+	SocketMode      os.FileMode
+}
+
+// ImplicitTLS reports whether connections are wrapped in TLS before the greeting
+func (l *Listener) ImplicitTLS() bool {
+	return l.Role == RoleSubmissions
+}
+
+// newListener applies role defaults and configured overrides
+func newListener(lc config.SMTPListenerConfig, cfg *config.SMTPConfig) *Listener {
+	l := &Listener{
+		Name:            lc.Name,
+		Role:            Role(lc.Role),
+		Addresses:       lc.Addresses,
+		MaxMessageBytes: lc.MaxMessageSize,
+		MaxRecipients:   lc.MaxRecipients,
+		ProxyProtocol:   lc.ProxyProtocol,
+		Milters:         lc.Milters,
+		SocketMode:      defaultSocketMode,
+	}
+
+	switch l.Role {
+	case RoleMX:
+		l.SecurityChecks = true
+	case RoleSubmission, RoleSubmissions:
+		l.RequireAuth = true
+	}
+
+	if lc.RequireTLS != nil {
+		l.RequireTLS = *lc.RequireTLS
+	}
+	if lc.RequireAuth != nil {
+		l.RequireAuth = *lc.RequireAuth
+	}
+	if lc.SecurityChecks != nil {
+		l.SecurityChecks = *lc.SecurityChecks
+	}
+	if mode, err := strconv.ParseUint(lc.SocketMode, 8, 32); err == nil {
+		l.SocketMode = os.FileMode(mode)
+	}
+	if l.MaxMessageBytes == 0 {
+		l.MaxMessageBytes = cfg.MaxMessageSize
+	}
+	if l.MaxRecipients == 0 {
+		l.MaxRecipients = defaultMaxRecipients
+	}
+
+	return l
+}
+
+// listenersFromConfig builds the configured listeners
+// Without explicit listeners, the legacy port settings map to mx, submission and submissions.
+func listenersFromConfig(cfg *config.SMTPConfig) []*Listener {
+	configs := cfg.Listeners
+	if len(configs) == 0 {
+		configs = []config.SMTPListenerConfig{
+			{Name: "submission", Role: string(RoleSubmission), Addresses: []string{fmt.Sprintf(":%d", cfg.SubmissionPort)}, ProxyProtocol: cfg.ProxyProtocol, Milters: cfg.Milters},
+			{Name: "mx", Role: string(RoleMX), Addresses: []string{fmt.Sprintf(":%d", cfg.RelayPort)}, ProxyProtocol: cfg.ProxyProtocol, Milters: cfg.Milters},
+			{Name: "submissions", Role: string(RoleSubmissions), Addresses: []string{fmt.Sprintf(":%d", cfg.SMTPSPort)}, ProxyProtocol: cfg.ProxyProtocol, Milters: cfg.Milters},
+		}
+	}
+
+	listeners := make([]*Listener, 0, len(configs))
+	for _, lc := range configs {
+		listeners = append(listeners, newListener(lc, cfg))
+	}
+	return listeners
+}
+
+// listenerBackend binds sessions created by one go-smtp server to its listener policy
+type listenerBackend struct {
+	backend  *Backend
+	listener *Listener
+}
+
+// NewSession creates a new SMTP session governed by the listener's policy
+// Milters see the connection here, once the client has sent HELO or EHLO.
+func (lb *listenerBackend) NewSession(c *smtp.Conn) (smtp.Session, error) {
+	session := lb.backend.newSession(c, lb.listener)
+	if err := session.openMilters(); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
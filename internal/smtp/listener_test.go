@@ -0,0 +1,73 @@
+package smtp
+
+import (
+	"testing"
+
+	"github.com/btafoya/gomailserver/internal/config"
+)
+
+func TestListenersFromConfig_LegacyPorts(t *testing.T) {
+	cfg := &config.SMTPConfig{SubmissionPort: 2587, RelayPort: 2525, SMTPSPort: 2465, MaxMessageSize: 1024}
+
+	byRole := make(map[Role]*Listener)
+	for _, l := range listenersFromConfig(cfg) {
+		byRole[l.Role] = l
+	}
+
+	mx := byRole[RoleMX]
+	if mx == nil || mx.Addresses[0] != ":2525" || mx.RequireAuth || !mx.SecurityChecks {
+		t.Errorf("unexpected mx listener: %+v", mx)
+	}
+	sub := byRole[RoleSubmission]
+	if sub == nil || sub.Addresses[0] != ":2587" || !sub.RequireAuth || sub.SecurityChecks {
+		t.Errorf("unexpected submission listener: %+v", sub)
+	}
+	subs := byRole[RoleSubmissions]
+	if subs == nil || subs.Addresses[0] != ":2465" || !subs.ImplicitTLS() {
+		t.Errorf("unexpected submissions listener: %+v", subs)
+	}
+	if mx.SocketMode != defaultSocketMode {
+		t.Errorf("expected the default socket mode, got %v", mx.SocketMode)
+	}
+	if mx.MaxMessageBytes != 1024 || mx.MaxRecipients != defaultMaxRecipients {
+		t.Errorf("expected global limits, got size %d recipients %d", mx.MaxMessageBytes, mx.MaxRecipients)
+	}
+}
+
+func TestListenersFromConfig_Overrides(t *testing.T) {
+	yes, no := true, false
+	cfg := &config.SMTPConfig{
+		MaxMessageSize: 1024,
+		Listeners: []config.SMTPListenerConfig{
+			{Name: "mx-v6", Role: "mx", Addresses: []string{"[2001:db8::1]:25"}, RequireTLS: &yes, SecurityChecks: &no, MaxMessageSize: 2048},
+			{Name: "local", Role: "lmtp", Addresses: []string{"127.0.0.1:24", "unix:/run/gomailserver/lmtp.sock"}, MaxRecipients: 10, SocketMode: "0600"},
+		},
+	}
+
+	listeners := listenersFromConfig(cfg)
+	if len(listeners) != 2 {
+		t.Fatalf("expected 2 listeners, got %d", len(listeners))
+	}
+
+	mx := listeners[0]
+	if !mx.RequireTLS || mx.SecurityChecks || mx.MaxMessageBytes != 2048 {
+		t.Errorf("expected overrides applied, got %+v", mx)
+	}
+	lmtp := listeners[1]
+	if lmtp.RequireAuth || lmtp.SecurityChecks || lmtp.MaxRecipients != 10 || lmtp.SocketMode != 0600 {
+		t.Errorf("unexpected lmtp listener: %+v", lmtp)
+	}
+}
+
+func TestCreateServer_DSN(t *testing.T) {
+	s := &Server{cfg: &config.SMTPConfig{Hostname: "mx.example.com"}}
+
+	for _, role := range []Role{RoleMX, RoleSubmission, RoleSubmissions} {
+		if srv := s.createServer(&Listener{Role: role}); !srv.EnableDSN {
+			t.Errorf("expected DSN advertised on %s listeners", role)
+		}
+	}
+	if srv := s.createServer(&Listener{Role: RoleLMTP}); srv.EnableDSN {
+		t.Error("expected DSN not advertised over LMTP")
+	}
+}
@@ -0,0 +1,217 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/emersion/go-smtp"
+	"go.uber.org/zap"
+
+	mailService "github.com/btafoya/gomailserver/internal/service"
+)
+
+// SetLocalDelivery registers the service LMTP listeners deliver mail with
+func (b *Backend) SetLocalDelivery(localDelivery mailService.LocalDeliveryInterface) {
+	b.localDelivery = localDelivery
+}
+
+// checkLMTPRecipient refuses recipients that have no local mailbox
+// LMTP is final delivery, so unknown users are rejected at RCPT rather than bounced later.
+func (s *Session) checkLMTPRecipient(to string) error {
+	if s.listener.Role != RoleLMTP || s.backend.localDelivery == nil {
+		return nil
+	}
+	if err := s.backend.localDelivery.CheckRecipient(to); err != nil {
+		s.logger.Info("LMTP recipient refused",
+			zap.String("to", to),
+			zap.Error(err),
+		)
+		return deliveryReply(err)
+	}
+	return nil
+}
+
+// LMTPData delivers the message to each recipient and reports a status per recipient
+// The upstream MTA has already run the edge checks (greylisting, SPF, DKIM,
+// antivirus and spam), so only the listener's milters run before delivery.
+// Aliases, forwarding, vacation replies and quotas apply as for mail delivered
+// from the queue.
+func (s *Session) LMTPData(r io.Reader, status smtp.StatusCollector) error {
+	if s.backend.localDelivery == nil {
+		return s.Data(r)
+	}
+
+	s.logger.Info("receiving message",
+		zap.String("from", s.from),
+		zap.Strings("to", s.to),
+		zap.String("remote_addr", s.remoteAddr),
+	)
+
+	// Statuses go to the recipients given at RCPT; go-smtp rejects any other
+	// address, so recipients a milter adds are delivered without a status
+	rcpts := append([]string(nil), s.to...)
+
+	sp, err := s.spool(r)
+	if err != nil {
+		return err
+	}
+	defer sp.Close()
+
+	holdReason, err := s.filterMessage(sp)
+	if err != nil {
+		return err
+	}
+	if s.discard {
+		s.logger.Info("message discarded by milter",
+			zap.String("from", s.from),
+			zap.Strings("to", s.to),
+		)
+		// A discard is accepted and dropped; refusing it would bounce to the sender
+		for _, rcpt := range rcpts {
+			status.SetStatus(rcpt, lmtpDiscarded)
+		}
+		return nil
+	}
+
+	// Quarantined mail waits in the queue for an administrator, as it does on MX listeners
+	if holdReason != "" {
+		spoolPath := sp.Detach()
+		messageID, err := s.backend.queueService.EnqueueFile(s.from, s.to, spoolPath, &mailService.EnqueueOptions{
+			BodyType:   string(s.bodyType),
+			SMTPUTF8:   s.utf8,
+			HoldReason: holdReason,
+		})
+		if err != nil {
+			os.Remove(spoolPath)
+			s.logger.Error("failed to queue quarantined message", zap.Error(err))
+			return &smtp.SMTPError{
+				Code:         451,
+				EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+				Message:      "Failed to queue message",
+			}
+		}
+		s.logger.Info("message quarantined",
+			zap.String("message_id", messageID),
+			zap.String("reason", holdReason),
+		)
+		for _, rcpt := range rcpts {
+			if s.hasRecipient(rcpt) {
+				status.SetStatus(rcpt, lmtpHeld)
+			} else {
+				status.SetStatus(rcpt, lmtpRemoved)
+			}
+		}
+		return nil
+	}
+
+	data, err := io.ReadAll(sp.Reader())
+	if err != nil {
+		s.logger.Error("failed to read spooled message", zap.Error(err))
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+			Message:      "Failed to read message",
+		}
+	}
+
+	// A recipient given twice gets one copy and the same status for each RCPT
+	results := make(map[string]error, len(s.to))
+	for _, rcpt := range s.to {
+		if _, done := results[rcpt]; done {
+			continue
+		}
+		result := s.backend.localDelivery.Deliver(context.Background(), s.from, rcpt, data)
+		results[rcpt] = result
+		if result != nil {
+			s.logger.Warn("LMTP delivery failed",
+				zap.String("from", s.from),
+				zap.String("to", rcpt),
+				zap.Error(result),
+			)
+		} else {
+			s.logger.Info("message delivered",
+				zap.String("from", s.from),
+				zap.String("to", rcpt),
+				zap.Int64("size", sp.Size()),
+			)
+		}
+	}
+
+	for _, rcpt := range rcpts {
+		result, delivered := results[rcpt]
+		if !delivered {
+			status.SetStatus(rcpt, lmtpRemoved)
+			continue
+		}
+		status.SetStatus(rcpt, deliveryReply(result))
+	}
+
+	return nil
+}
+
+// Statuses for recipients a milter kept from delivery
+var (
+	lmtpDiscarded = &smtp.SMTPError{
+		Code:         250,
+		EnhancedCode: smtp.EnhancedCode{2, 0, 0},
+		Message:      "Message discarded by content filter",
+	}
+	lmtpHeld = &smtp.SMTPError{
+		Code:         250,
+		EnhancedCode: smtp.EnhancedCode{2, 0, 0},
+		Message:      "Message held for review",
+	}
+	lmtpRemoved = &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+		Message:      "Recipient removed by content filter",
+	}
+)
+
+// hasRecipient reports whether rcpt is still an envelope recipient
+func (s *Session) hasRecipient(rcpt string) bool {
+	for _, to := range s.to {
+		if to == rcpt {
+			return true
+		}
+	}
+	return false
+}
+
+// deliveryReply converts a local delivery error to an LMTP reply
+func deliveryReply(err error) error {
+	var smtpErr *smtp.SMTPError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &smtpErr):
+		return smtpErr
+	case errors.Is(err, mailService.ErrRecipientNotFound):
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+			Message:      "No such user here",
+		}
+	case errors.Is(err, mailService.ErrUserDisabled):
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 2, 1},
+			Message:      "Mailbox disabled",
+		}
+	case errors.Is(err, mailService.ErrOverQuota):
+		// Temporary, so the message is retried once the user frees space
+		return &smtp.SMTPError{
+			Code:         452,
+			EnhancedCode: smtp.EnhancedCode{4, 2, 2},
+			Message:      "Mailbox full",
+		}
+	default:
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+			Message:      "Failed to deliver message",
+		}
+	}
+}
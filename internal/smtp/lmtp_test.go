@@ -0,0 +1,201 @@
+package smtp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/service"
+)
+
+// mockLocalDelivery fails delivery for the recipients in errs and records the rest
+type mockLocalDelivery struct {
+	errs      map[string]error
+	delivered []string
+}
+
+func (m *mockLocalDelivery) CheckRecipient(recipient string) error {
+	if err := m.errs[recipient]; err == service.ErrRecipientNotFound {
+		return err
+	}
+	return nil
+}
+
+func (m *mockLocalDelivery) Deliver(ctx context.Context, sender, recipient string, data []byte) error {
+	if err := m.errs[recipient]; err != nil {
+		return err
+	}
+	m.delivered = append(m.delivered, recipient)
+	return nil
+}
+
+// statusRecorder collects per-recipient LMTP statuses
+type statusRecorder struct {
+	statuses []string
+}
+
+func (r *statusRecorder) SetStatus(rcpt string, err error) {
+	code := 250
+	if smtpErr, ok := err.(*smtp.SMTPError); ok {
+		code = smtpErr.Code
+	}
+	r.statuses = append(r.statuses, fmt.Sprintf("%s %d", rcpt, code))
+}
+
+func newLMTPSession(local *mockLocalDelivery) *Session {
+	backend := &Backend{
+		userService:    &mockUserService{},
+		messageService: &mockMessageService{},
+		queueService:   &mockQueueService{},
+		domainRepo:     &mockDomainRepository{},
+		localDelivery:  local,
+		logger:         zap.NewNop(),
+	}
+	return &Session{
+		listener: testListener(RoleLMTP),
+		backend:  backend,
+		logger:   zap.NewNop(),
+	}
+}
+
+func TestSession_LMTPRcpt(t *testing.T) {
+	session := newLMTPSession(&mockLocalDelivery{errs: map[string]error{
+		"nobody@example.com": service.ErrRecipientNotFound,
+	}})
+
+	if err := session.Mail("sender@example.net", nil); err != nil {
+		t.Fatalf("expected unauthenticated MAIL to be accepted, got %v", err)
+	}
+	if err := session.Rcpt("user@example.com", nil); err != nil {
+		t.Errorf("expected local recipient to be accepted, got %v", err)
+	}
+	err := session.Rcpt("nobody@example.com", nil)
+	if smtpErr, ok := err.(*smtp.SMTPError); !ok || smtpErr.Code != 550 {
+		t.Errorf("expected 550 for an unknown recipient, got %v", err)
+	}
+	if len(session.to) != 1 {
+		t.Errorf("expected only the accepted recipient, got %v", session.to)
+	}
+}
+
+func TestSession_LMTPData(t *testing.T) {
+	local := &mockLocalDelivery{errs: map[string]error{
+		"full@example.com":     fmt.Errorf("%w: storage 10 of 10 bytes", service.ErrOverQuota),
+		"disabled@example.com": service.ErrUserDisabled,
+		"broken@example.com":   os.ErrClosed,
+	}}
+	session := newLMTPSession(local)
+	session.from = "sender@example.net"
+	session.to = []string{"user@example.com", "full@example.com", "disabled@example.com", "broken@example.com", "user@example.com"}
+
+	status := &statusRecorder{}
+	if err := session.LMTPData(strings.NewReader("Subject: hi\r\n\r\nHello\r\n"), status); err != nil {
+		t.Fatalf("LMTPData failed: %v", err)
+	}
+
+	want := []string{
+		"user@example.com 250",
+		"full@example.com 452",
+		"disabled@example.com 550",
+		"broken@example.com 451",
+		"user@example.com 250",
+	}
+	if strings.Join(status.statuses, ", ") != strings.Join(want, ", ") {
+		t.Errorf("statuses = %v, want %v", status.statuses, want)
+	}
+	if len(local.delivered) != 1 {
+		t.Errorf("expected one copy for a repeated recipient, got %v", local.delivered)
+	}
+}
+
+func TestSession_LMTPDataMilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		eom       [][]byte
+		statuses  []string
+		delivered []string
+	}{
+		{
+			name:      "added recipient",
+			eom:       [][]byte{[]byte("+<copy@example.com>\x00"), {'a'}},
+			statuses:  []string{"user@example.com 250", "other@example.com 250"},
+			delivered: []string{"user@example.com", "other@example.com", "copy@example.com"},
+		},
+		{
+			name:      "removed recipient",
+			eom:       [][]byte{[]byte("-<other@example.com>\x00"), {'a'}},
+			statuses:  []string{"user@example.com 250", "other@example.com 550"},
+			delivered: []string{"user@example.com"},
+		},
+		{
+			name:     "discarded",
+			eom:      [][]byte{{'d'}},
+			statuses: []string{"user@example.com 250", "other@example.com 250"},
+		},
+		{
+			name:     "quarantined",
+			eom:      [][]byte{[]byte("-<other@example.com>\x00"), []byte("qvirus found\x00"), {'c'}},
+			statuses: []string{"user@example.com 250", "other@example.com 550"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local := &mockLocalDelivery{}
+			session := newLMTPSession(local)
+			client := startFakeMilter(t, "", tt.eom...)
+			ms, err := client.Open()
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			session.milters = []*milterConn{{client: client, session: ms}}
+			session.backend.queueService = &recordingQueueService{onEnqueueFile: func(*service.EnqueueOptions) {}}
+			t.Cleanup(func() { session.Logout() })
+
+			session.Mail("sender@example.net", nil)
+			session.Rcpt("user@example.com", nil)
+			session.Rcpt("other@example.com", nil)
+
+			// go-smtp panics on a status for a recipient not given at RCPT
+			status := &statusRecorder{}
+			if err := session.LMTPData(strings.NewReader("Subject: hi\r\n\r\nHello\r\n"), status); err != nil {
+				t.Fatalf("LMTPData failed: %v", err)
+			}
+			if strings.Join(status.statuses, ", ") != strings.Join(tt.statuses, ", ") {
+				t.Errorf("statuses = %v, want %v", status.statuses, tt.statuses)
+			}
+			if strings.Join(local.delivered, ",") != strings.Join(tt.delivered, ",") {
+				t.Errorf("delivered = %v, want %v", local.delivered, tt.delivered)
+			}
+		})
+	}
+}
+
+func TestListen_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lmtp.sock")
+
+	// A stale socket from a previous run is replaced
+	for i := 0; i < 2; i++ {
+		ln, err := listen("unix:"+path, 0600)
+		if err != nil {
+			t.Fatalf("listen failed: %v", err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0600 {
+			t.Errorf("socket mode = %v", fi.Mode().Perm())
+		}
+		if i == 0 {
+			// Leave the socket file behind as a crash would
+			ln.(interface{ SetUnlinkOnClose(bool) }).SetUnlinkOnClose(false)
+		}
+		ln.Close()
+	}
+}
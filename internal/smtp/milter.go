@@ -0,0 +1,534 @@
+package smtp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-smtp"
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/security/milter"
+	mailService "github.com/btafoya/gomailserver/internal/service"
+)
+
+// milterConn is one milter attached to an SMTP session
+type milterConn struct {
+	client    *milter.Client
+	session   *milter.Session
+	done      bool // accepted the connection or failed open; closed and sees no further stages
+	skip      bool // accepted the current message
+	inMessage bool // a transaction is open on the milter and must be ended or aborted
+}
+
+// SetMilters registers the milters that listeners attach by name
+func (b *Backend) SetMilters(milters []*milter.Client) {
+	b.milters = make(map[string]*milter.Client, len(milters))
+	for _, m := range milters {
+		b.milters[m.Name()] = m
+	}
+}
+
+// openMilters connects the listener's milters and runs the connect and HELO stages
+func (s *Session) openMilters() error {
+	for _, name := range s.listener.Milters {
+		client := s.backend.milters[name]
+		if client == nil {
+			continue
+		}
+		session, err := client.Open()
+		if err != nil {
+			if err := s.milterFailed(client, err); err != nil {
+				s.closeMilters()
+				return err
+			}
+			continue
+		}
+		s.milters = append(s.milters, &milterConn{client: client, session: session})
+	}
+	if len(s.milters) == 0 {
+		return nil
+	}
+
+	addr := s.conn.Conn().RemoteAddr()
+	macros := milter.Macros{
+		"j":             s.conn.Server().Domain,
+		"{daemon_name}": s.listener.Name,
+		"{client_addr}": extractIP(s.remoteAddr),
+	}
+	err := s.milterStage("connect", func(m *milter.Session) (*milter.Response, error) {
+		resp, err := m.Connect("["+extractIP(s.remoteAddr)+"]", addr, macros)
+		if err != nil || resp.Action != milter.ActionContinue {
+			return resp, err
+		}
+		return m.Helo(s.conn.Hostname())
+	})
+	if err != nil {
+		s.closeMilters()
+	}
+	return err
+}
+
+// milterMail opens a transaction on each milter with the envelope sender
+func (s *Session) milterMail(from string, opts *smtp.MailOptions) error {
+	s.abortMilters()
+
+	macros := milter.Macros{"{mail_addr}": from}
+	if s.authenticated {
+		macros["{auth_authen}"] = s.username
+	}
+	args := mailArgs(opts)
+	for _, mc := range s.milters {
+		mc.inMessage = !mc.done
+	}
+	return s.milterStage("MAIL", func(m *milter.Session) (*milter.Response, error) {
+		return m.Mail(from, args, macros)
+	})
+}
+
+// milterRcpt passes one envelope recipient to each milter
+func (s *Session) milterRcpt(to string) error {
+	return s.milterStage("RCPT", func(m *milter.Session) (*milter.Response, error) {
+		return m.Rcpt(to, nil, milter.Macros{"{rcpt_addr}": to})
+	})
+}
+
+// filterMessage passes the spooled message through each milter in turn and
+// applies the modifications they request, so later milters see earlier changes.
+// It returns a hold reason when a milter quarantined the message.
+func (s *Session) filterMessage(sp *mailService.Spool) (string, error) {
+	var holdReason string
+	for _, mc := range s.milters {
+		if mc.done || mc.skip || s.discard {
+			continue
+		}
+
+		resp, ended, err := sendMessage(mc.session, sp)
+		if err != nil {
+			if err := s.milterFailed(mc.client, err); err != nil {
+				return "", err
+			}
+			mc.session.Close()
+			mc.done = true
+			continue
+		}
+		if ended {
+			mc.inMessage = false
+		}
+		if err := s.milterVerdict(mc, "DATA", resp); err != nil {
+			return "", err
+		}
+		if s.discard {
+			break
+		}
+
+		reason, err := s.applyModifications(sp, resp.Modifications)
+		if err != nil {
+			s.logger.Error("failed to apply milter modifications",
+				zap.String("milter", mc.client.Name()),
+				zap.Error(err),
+			)
+			return "", &smtp.SMTPError{
+				Code:         451,
+				EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+				Message:      "Failed to process message",
+			}
+		}
+		if reason != "" {
+			holdReason = fmt.Sprintf("quarantined by milter %s: %s", mc.client.Name(), reason)
+			s.logger.Info("message quarantined by milter",
+				zap.String("milter", mc.client.Name()),
+				zap.String("reason", reason),
+				zap.String("from", s.from),
+			)
+		}
+	}
+	return holdReason, nil
+}
+
+// sendMessage streams the header and body of a message and returns the
+// end-of-message reply, or the first reply that ends filtering early.
+// ended reports whether the end-of-message reply closed the transaction.
+func sendMessage(m *milter.Session, sp *mailService.Spool) (resp *milter.Response, ended bool, err error) {
+	fields, bodyOffset, err := readHeaderFields(sp.Reader())
+	if err != nil {
+		return nil, false, err
+	}
+
+	resp, err = m.Data()
+	if err != nil || resp.Action != milter.ActionContinue {
+		return resp, false, err
+	}
+	for _, f := range fields {
+		resp, err = m.Header(f.name, f.value())
+		if err != nil || resp.Action != milter.ActionContinue {
+			return resp, false, err
+		}
+	}
+	resp, err = m.EndOfHeaders()
+	if err != nil || resp.Action != milter.ActionContinue {
+		return resp, false, err
+	}
+
+	body := sp.Reader()
+	if _, err := io.CopyN(io.Discard, body, bodyOffset); err != nil {
+		return nil, false, err
+	}
+	resp, err = m.Body(body)
+	if err != nil || resp.Action != milter.ActionContinue {
+		return resp, false, err
+	}
+	resp, err = m.End()
+	return resp, err == nil, err
+}
+
+// milterStage runs one envelope stage on every active milter
+func (s *Session) milterStage(stage string, fn func(*milter.Session) (*milter.Response, error)) error {
+	for _, mc := range s.milters {
+		if mc.done || mc.skip {
+			continue
+		}
+		resp, err := fn(mc.session)
+		if err != nil {
+			if err := s.milterFailed(mc.client, err); err != nil {
+				return err
+			}
+			mc.session.Close()
+			mc.done = true
+			continue
+		}
+		if err := s.milterVerdict(mc, stage, resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// milterVerdict applies a milter's action for a stage
+func (s *Session) milterVerdict(mc *milterConn, stage string, resp *milter.Response) error {
+	switch resp.Action {
+	case milter.ActionAccept:
+		if stage == "connect" {
+			// The milter sees nothing more of this connection
+			mc.session.Close()
+			mc.done = true
+		} else {
+			mc.skip = true
+		}
+	case milter.ActionDiscard:
+		s.discard = true
+		mc.skip = true
+	case milter.ActionReject, milter.ActionTempFail:
+		s.logger.Info("command rejected by milter",
+			zap.String("milter", mc.client.Name()),
+			zap.String("stage", stage),
+			zap.Int("code", resp.Code),
+			zap.String("text", resp.Text),
+			zap.String("remote_addr", s.remoteAddr),
+		)
+		if resp.Action == milter.ActionReject {
+			return milterReply(resp, 550, smtp.EnhancedCode{5, 7, 1}, "Rejected by content filter")
+		}
+		return milterReply(resp, 451, smtp.EnhancedCode{4, 7, 1}, "Temporarily rejected by content filter")
+	}
+	return nil
+}
+
+// milterFailed handles an unavailable milter according to its fail action
+func (s *Session) milterFailed(client *milter.Client, err error) error {
+	s.logger.Warn("milter unavailable",
+		zap.String("milter", client.Name()),
+		zap.Bool("fail_open", client.FailOpen()),
+		zap.Error(err),
+	)
+	if client.FailOpen() {
+		return nil
+	}
+	return &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 7, 1},
+		Message:      "Content filter unavailable, try again later",
+	}
+}
+
+// abortMilters ends any open milter transaction and clears per-message state
+func (s *Session) abortMilters() {
+	for _, mc := range s.milters {
+		if mc.inMessage && !mc.done {
+			if err := mc.session.Abort(); err != nil {
+				s.logger.Warn("failed to abort milter transaction",
+					zap.String("milter", mc.client.Name()),
+					zap.Error(err),
+				)
+			}
+		}
+		mc.inMessage = false
+		mc.skip = false
+	}
+	s.discard = false
+}
+
+// closeMilters ends every milter session
+// Sessions marked done were closed when they were marked.
+func (s *Session) closeMilters() {
+	for _, mc := range s.milters {
+		if !mc.done {
+			mc.session.Close()
+		}
+	}
+	s.milters = nil
+}
+
+// milterReply converts a reject or tempfail verdict to an SMTP reply,
+// keeping a custom reply code and text when the milter supplied one
+func milterReply(resp *milter.Response, code int, enhanced smtp.EnhancedCode, message string) error {
+	if resp.Code != 0 {
+		code = resp.Code
+		text := resp.Text
+		if first, rest, ok := strings.Cut(text, " "); ok {
+			if ec, ok := parseEnhancedCode(first); ok {
+				enhanced, text = ec, rest
+			}
+		}
+		if text != "" {
+			message = strings.SplitN(text, "\n", 2)[0]
+			message = strings.TrimRight(message, "\r")
+		}
+	}
+	return &smtp.SMTPError{Code: code, EnhancedCode: enhanced, Message: message}
+}
+
+// parseEnhancedCode parses an RFC 3463 status code such as "5.7.1"
+func parseEnhancedCode(s string) (smtp.EnhancedCode, bool) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return smtp.EnhancedCode{}, false
+	}
+	var code smtp.EnhancedCode
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return smtp.EnhancedCode{}, false
+		}
+		code[i] = n
+	}
+	return code, true
+}
+
+// mailArgs rebuilds the ESMTP parameters given with MAIL FROM
+func mailArgs(opts *smtp.MailOptions) []string {
+	if opts == nil {
+		return nil
+	}
+	var args []string
+	if opts.Size > 0 {
+		args = append(args, "SIZE="+strconv.FormatInt(opts.Size, 10))
+	}
+	if opts.Body != "" {
+		args = append(args, "BODY="+string(opts.Body))
+	}
+	if opts.UTF8 {
+		args = append(args, "SMTPUTF8")
+	}
+	if opts.RequireTLS {
+		args = append(args, "REQUIRETLS")
+	}
+	if opts.Return != "" {
+		args = append(args, "RET="+string(opts.Return))
+	}
+	if opts.EnvelopeID != "" {
+		args = append(args, "ENVID="+opts.EnvelopeID)
+	}
+	return args
+}
+
+// applyModifications applies end-of-message changes to the envelope and spool
+// It returns the quarantine reason if one was requested.
+func (s *Session) applyModifications(sp *mailService.Spool, mods []milter.Modification) (string, error) {
+	var (
+		reason        string
+		rewrite       bool
+		replaceBody   bool
+		body          []byte
+		headerChanges []milter.Modification
+	)
+	for _, mod := range mods {
+		switch mod.Type {
+		case milter.ModAddHeader, milter.ModInsertHeader, milter.ModChangeHeader:
+			headerChanges = append(headerChanges, mod)
+			rewrite = true
+		case milter.ModReplaceBody:
+			body = append(body, mod.Body...)
+			replaceBody, rewrite = true, true
+		case milter.ModAddRcpt:
+			s.addRecipient(trimAngle(mod.Value))
+		case milter.ModDelRcpt:
+			s.removeRecipient(trimAngle(mod.Value))
+		case milter.ModChangeFrom:
+			s.from = trimAngle(mod.Value)
+		case milter.ModQuarantine:
+			reason = mod.Value
+		}
+	}
+	if !rewrite {
+		return reason, nil
+	}
+
+	fields, bodyOffset, err := readHeaderFields(sp.Reader())
+	if err != nil {
+		return "", err
+	}
+	for _, mod := range headerChanges {
+		fields = applyHeaderChange(fields, mod)
+	}
+
+	err = sp.Rewrite(func(w io.Writer) error {
+		for _, f := range fields {
+			if _, err := io.WriteString(w, f.raw); err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(w, "\r\n"); err != nil {
+			return err
+		}
+		if replaceBody {
+			_, err := w.Write(body)
+			return err
+		}
+		original := sp.Reader()
+		if _, err := io.CopyN(io.Discard, original, bodyOffset); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, original)
+		return err
+	})
+	return reason, err
+}
+
+// addRecipient adds an envelope recipient unless already present
+func (s *Session) addRecipient(rcpt string) {
+	for _, to := range s.to {
+		if strings.EqualFold(to, rcpt) {
+			return
+		}
+	}
+	s.to = append(s.to, rcpt)
+}
+
+// removeRecipient removes an envelope recipient and its DSN parameters
+func (s *Session) removeRecipient(rcpt string) {
+	to := s.to[:0]
+	for _, addr := range s.to {
+		if !strings.EqualFold(addr, rcpt) {
+			to = append(to, addr)
+		}
+	}
+	s.to = to
+
+	dsn := s.dsnRcpts[:0]
+	for _, p := range s.dsnRcpts {
+		if !strings.EqualFold(p.Recipient, rcpt) {
+			dsn = append(dsn, p)
+		}
+	}
+	s.dsnRcpts = dsn
+}
+
+func trimAngle(addr string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(addr), "<"), ">")
+}
+
+// headerField is one header field as it appears in the message
+type headerField struct {
+	name string
+	raw  string // the complete field including folded lines and the final line break
+}
+
+// newHeaderField formats a field from a milter-supplied value
+func newHeaderField(name, value string) headerField {
+	value = strings.ReplaceAll(strings.ReplaceAll(value, "\r\n", "\n"), "\n", "\r\n")
+	return headerField{name: name, raw: name + ": " + value + "\r\n"}
+}
+
+// value returns the field value in the form milters expect: without the
+// leading space, with folded lines joined by a bare newline
+func (f headerField) value() string {
+	v := strings.TrimPrefix(f.raw, f.name)
+	v = strings.TrimPrefix(v, ":")
+	v = strings.TrimLeft(v, " \t")
+	v = strings.TrimRight(v, "\r\n")
+	return strings.ReplaceAll(v, "\r\n", "\n")
+}
+
+// readHeaderFields reads the header fields of a message and returns the offset of its body
+func readHeaderFields(r io.Reader) ([]headerField, int64, error) {
+	br := bufio.NewReader(r)
+	var (
+		fields []headerField
+		offset int64
+	)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, 0, err
+		}
+		if line == "" {
+			return fields, offset, nil
+		}
+
+		switch {
+		case line == "\r\n" || line == "\n":
+			return fields, offset + int64(len(line)), nil
+		case (line[0] == ' ' || line[0] == '\t') && len(fields) > 0:
+			fields[len(fields)-1].raw += line
+		default:
+			name, _, ok := strings.Cut(line, ":")
+			if !ok || name == "" || strings.ContainsAny(name, " \t") {
+				// Not a header field: the body starts here without a separator
+				return fields, offset, nil
+			}
+			fields = append(fields, headerField{name: name, raw: line})
+		}
+		offset += int64(len(line))
+
+		if errors.Is(err, io.EOF) {
+			return fields, offset, nil
+		}
+	}
+}
+
+// applyHeaderChange applies one header modification
+func applyHeaderChange(fields []headerField, mod milter.Modification) []headerField {
+	switch mod.Type {
+	case milter.ModAddHeader:
+		return append(fields, newHeaderField(mod.Name, mod.Value))
+	case milter.ModInsertHeader:
+		i := min(max(mod.Index, 0), len(fields))
+		fields = append(fields, headerField{})
+		copy(fields[i+1:], fields[i:])
+		fields[i] = newHeaderField(mod.Name, mod.Value)
+		return fields
+	case milter.ModChangeHeader:
+		occurrence := max(mod.Index, 1)
+		for i, f := range fields {
+			if !strings.EqualFold(f.name, mod.Name) {
+				continue
+			}
+			if occurrence--; occurrence > 0 {
+				continue
+			}
+			if mod.Value == "" {
+				return append(fields[:i], fields[i+1:]...)
+			}
+			fields[i] = newHeaderField(f.name, mod.Value)
+			return fields
+		}
+		if mod.Value != "" {
+			return append(fields, newHeaderField(mod.Name, mod.Value))
+		}
+	}
+	return fields
+}
@@ -0,0 +1,243 @@
+package smtp
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/security/milter"
+	"github.com/btafoya/gomailserver/internal/service"
+)
+
+// startFakeMilter serves the milter protocol on a unix socket, answering
+// end of message with eom and every other command with continue
+func startFakeMilter(t *testing.T, rcptReject string, eom ...[]byte) *milter.Client {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "milter.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	write := func(conn net.Conn, p []byte) {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(p)))
+		conn.Write(append(size[:], p...))
+	}
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var hdr [4]byte
+			if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+				return
+			}
+			buf := make([]byte, binary.BigEndian.Uint32(hdr[:]))
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				return
+			}
+			switch buf[0] {
+			case 'O':
+				opt := make([]byte, 12)
+				binary.BigEndian.PutUint32(opt[0:4], 6)
+				binary.BigEndian.PutUint32(opt[4:8], 0x7f)
+				write(conn, append([]byte{'O'}, opt...))
+			case 'D', 'A':
+			case 'Q':
+				return
+			case 'R':
+				if rcptReject != "" && strings.Contains(string(buf[1:]), rcptReject) {
+					write(conn, []byte("y550 5.1.1 Unknown recipient\x00"))
+				} else {
+					write(conn, []byte{'c'})
+				}
+			case 'E':
+				for _, p := range eom {
+					write(conn, p)
+				}
+			default:
+				write(conn, []byte{'c'})
+			}
+		}
+	}()
+
+	client, err := milter.NewClient(milter.Config{Name: "fake", Address: "unix:" + path, Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	return client
+}
+
+// milterSession returns an inbound session attached to a milter
+func milterSession(t *testing.T, client *milter.Client, queueSvc service.QueueServiceInterface) *Session {
+	t.Helper()
+	ms, err := client.Open()
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	logger := zap.NewNop()
+	s := &Session{
+		listener: testListener(RoleMX),
+		backend: &Backend{
+			userService:    &mockUserService{},
+			messageService: &mockMessageService{},
+			queueService:   queueSvc,
+			submission:     testSubmission(queueSvc),
+			domainRepo:     &mockDomainRepository{},
+			logger:         logger,
+		},
+		logger:     logger,
+		remoteAddr: "192.0.2.1:4000",
+		milters:    []*milterConn{{client: client, session: ms}},
+	}
+	t.Cleanup(func() { s.Logout() })
+	return s
+}
+
+func TestSession_Milter(t *testing.T) {
+	t.Run("applies modifications", func(t *testing.T) {
+		client := startFakeMilter(t, "",
+			[]byte("hX-Spam-Score\x003.2\x00"),
+			[]byte("m\x00\x00\x00\x01Subject\x00[SPAM] Hello\x00"),
+			[]byte("m\x00\x00\x00\x01X-Remove\x00\x00"),
+			[]byte("bReplaced body\r\n"),
+			[]byte("+<copy@example.com>\x00"),
+			[]byte{'a'},
+		)
+
+		var queued []byte
+		var recipients []string
+		queueSvc := &mockQueueService{enqueueFunc: func(sender string, to []string, message []byte) (string, error) {
+			queued, recipients = message, to
+			return "id", nil
+		}}
+		s := milterSession(t, client, queueSvc)
+
+		if err := s.Mail("sender@example.net", nil); err != nil {
+			t.Fatalf("MAIL failed: %v", err)
+		}
+		if err := s.Rcpt("user@example.com", nil); err != nil {
+			t.Fatalf("RCPT failed: %v", err)
+		}
+		msg := "Subject: Hello\r\nX-Remove: yes\r\nReceived: from a\r\n\tby b\r\n\r\nOriginal body\r\n"
+		if err := s.Data(strings.NewReader(msg)); err != nil {
+			t.Fatalf("DATA failed: %v", err)
+		}
+
+		want := "Subject: [SPAM] Hello\r\nReceived: from a\r\n\tby b\r\nX-Spam-Score: 3.2\r\n\r\nReplaced body\r\n"
+		if string(queued) != want {
+			t.Errorf("unexpected message:\n%q\nwant:\n%q", queued, want)
+		}
+		if strings.Join(recipients, ",") != "user@example.com,copy@example.com" {
+			t.Errorf("expected added recipient, got %v", recipients)
+		}
+	})
+
+	t.Run("rejects recipient with milter reply", func(t *testing.T) {
+		s := milterSession(t, startFakeMilter(t, "unknown@"), &mockQueueService{})
+
+		if err := s.Mail("sender@example.net", nil); err != nil {
+			t.Fatalf("MAIL failed: %v", err)
+		}
+		err := s.Rcpt("unknown@example.com", nil)
+		var smtpErr *smtp.SMTPError
+		if !errors.As(err, &smtpErr) || smtpErr.Code != 550 || smtpErr.EnhancedCode != (smtp.EnhancedCode{5, 1, 1}) {
+			t.Fatalf("expected 550 5.1.1, got %v", err)
+		}
+		if err := s.Rcpt("user@example.com", nil); err != nil {
+			t.Fatalf("expected other recipients to be accepted, got %v", err)
+		}
+		if len(s.to) != 1 {
+			t.Errorf("expected only the accepted recipient, got %v", s.to)
+		}
+	})
+
+	t.Run("holds quarantined message", func(t *testing.T) {
+		var opts *service.EnqueueOptions
+		queueSvc := &recordingQueueService{onEnqueueFile: func(o *service.EnqueueOptions) { opts = o }}
+		s := milterSession(t, startFakeMilter(t, "", []byte("qvirus found\x00"), []byte{'c'}), queueSvc)
+
+		s.Mail("sender@example.net", nil)
+		s.Rcpt("user@example.com", nil)
+		if err := s.Data(strings.NewReader("Subject: Hi\r\n\r\nBody\r\n")); err != nil {
+			t.Fatalf("DATA failed: %v", err)
+		}
+		if opts == nil || !strings.Contains(opts.HoldReason, "virus found") {
+			t.Fatalf("expected message to be held, got %+v", opts)
+		}
+	})
+
+	t.Run("discards message", func(t *testing.T) {
+		queued := false
+		queueSvc := &mockQueueService{enqueueFunc: func(string, []string, []byte) (string, error) {
+			queued = true
+			return "id", nil
+		}}
+		s := milterSession(t, startFakeMilter(t, "", []byte{'d'}), queueSvc)
+
+		s.Mail("sender@example.net", nil)
+		s.Rcpt("user@example.com", nil)
+		if err := s.Data(strings.NewReader("Subject: Hi\r\n\r\nBody\r\n")); err != nil {
+			t.Fatalf("expected discarded message to be accepted, got %v", err)
+		}
+		if queued {
+			t.Error("expected discarded message not to be queued")
+		}
+	})
+
+	t.Run("drops message without recipients", func(t *testing.T) {
+		queued := false
+		queueSvc := &mockQueueService{enqueueFunc: func(string, []string, []byte) (string, error) {
+			queued = true
+			return "id", nil
+		}}
+		s := milterSession(t, startFakeMilter(t, "", []byte("-<user@example.com>\x00"), []byte{'a'}), queueSvc)
+
+		s.Mail("sender@example.net", nil)
+		s.Rcpt("user@example.com", &smtp.RcptOptions{Notify: []smtp.DSNNotify{smtp.DSNNotifyFailure}})
+		if err := s.Data(strings.NewReader("Subject: Hi\r\n\r\nBody\r\n")); err != nil {
+			t.Fatalf("expected message to be accepted, got %v", err)
+		}
+		if queued {
+			t.Error("expected message without recipients not to be queued")
+		}
+		if len(s.dsnRcpts) != 0 {
+			t.Errorf("expected DSN parameters of the removed recipient to be dropped, got %v", s.dsnRcpts)
+		}
+	})
+
+	t.Run("unavailable milter", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "missing.sock")
+		for _, failOpen := range []bool{false, true} {
+			client, _ := milter.NewClient(milter.Config{Name: "down", Address: "unix:" + path, FailOpen: failOpen})
+			s := &Session{
+				listener: &Listener{Name: "mx", Role: RoleMX, Milters: []string{"down"}},
+				backend:  &Backend{logger: zap.NewNop()},
+				logger:   zap.NewNop(),
+			}
+			s.backend.SetMilters([]*milter.Client{client})
+
+			err := s.openMilters()
+			if failOpen && err != nil {
+				t.Errorf("expected fail-open milter to be skipped, got %v", err)
+			}
+			var smtpErr *smtp.SMTPError
+			if !failOpen && (!errors.As(err, &smtpErr) || smtpErr.Code != 451) {
+				t.Errorf("expected 451 when milter is unavailable, got %v", err)
+			}
+		}
+	})
+}
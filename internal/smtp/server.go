@@ -5,6 +5,8 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net"
+	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,156 +14,145 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/btafoya/gomailserver/internal/config"
+	"github.com/btafoya/gomailserver/internal/proxyproto"
 )
 
-// Server manages SMTP server instances
+// Server manages SMTP server instances, one per configured listener
 type Server struct {
-	submission *smtp.Server // Port 587
-	relay      *smtp.Server // Port 25
-	smtps      *smtp.Server // Port 465
-	backend    *Backend
-	cfg        *config.SMTPConfig
-	tlsCfg     *tls.Config
-	logger     *zap.Logger
-	wg         sync.WaitGroup
-	cancel     context.CancelFunc
+	listeners []*listenerServer
+	backend   *Backend
+	cfg       *config.SMTPConfig
+	tlsCfg    *tls.Config
+	proxy     *proxyproto.Policy
+	logger    *zap.Logger
+	wg        sync.WaitGroup
+	cancel    context.CancelFunc
+}
+
+// listenerServer pairs a listener with the go-smtp server that enforces its policy
+type listenerServer struct {
+	listener *Listener
+	srv      *smtp.Server
 }
 
 // NewServer creates a new SMTP server manager
-func NewServer(cfg *config.SMTPConfig, tlsCfg *tls.Config, backend *Backend, logger *zap.Logger) *Server {
+// proxy is used by listeners with proxy_protocol enabled and may be nil otherwise.
+func NewServer(cfg *config.SMTPConfig, tlsCfg *tls.Config, proxy *proxyproto.Policy, backend *Backend, logger *zap.Logger) *Server {
 	s := &Server{
 		backend: backend,
 		cfg:     cfg,
 		tlsCfg:  tlsCfg,
+		proxy:   proxy,
 		logger:  logger,
 	}
 
-	// Initialize SMTP servers
-	s.submission = s.createSubmissionServer()
-	s.relay = s.createRelayServer()
-	s.smtps = s.createSMTPSServer()
-
-	return s
-}
-
-// createSubmissionServer creates port 587 submission server
-func (s *Server) createSubmissionServer() *smtp.Server {
-	srv := smtp.NewServer(s.backend)
-	srv.Addr = fmt.Sprintf(":%d", s.cfg.SubmissionPort)
-	srv.Domain = s.cfg.Hostname
-	srv.ReadTimeout = 30 * time.Second
-	srv.WriteTimeout = 30 * time.Second
-	srv.MaxMessageBytes = int64(s.cfg.MaxMessageSize)
-	srv.MaxRecipients = 100
-	srv.AllowInsecureAuth = true // Allow AUTH before STARTTLS for testing
-	srv.EnableSMTPUTF8 = true
-	srv.EnableREQUIRETLS = false // Don't require TLS for testing
-
-	// STARTTLS configuration
-	if s.tlsCfg != nil {
-		srv.TLSConfig = s.tlsCfg
+	for _, l := range listenersFromConfig(cfg) {
+		s.listeners = append(s.listeners, &listenerServer{
+			listener: l,
+			srv:      s.createServer(l),
+		})
 	}
 
-	return srv
+	return s
 }
 
-// createRelayServer creates port 25 MX relay server
-func (s *Server) createRelayServer() *smtp.Server {
-	srv := smtp.NewServer(s.backend)
-	srv.Addr = fmt.Sprintf(":%d", s.cfg.RelayPort)
+// createServer creates a go-smtp server configured for a listener's role and policy
+func (s *Server) createServer(l *Listener) *smtp.Server {
+	srv := smtp.NewServer(&listenerBackend{backend: s.backend, listener: l})
 	srv.Domain = s.cfg.Hostname
 	srv.ReadTimeout = 30 * time.Second
 	srv.WriteTimeout = 30 * time.Second
-	srv.MaxMessageBytes = int64(s.cfg.MaxMessageSize)
-	srv.MaxRecipients = 100
-	srv.AllowInsecureAuth = true // Allow for receiving mail
+	srv.MaxMessageBytes = l.MaxMessageBytes
+	srv.MaxRecipients = l.MaxRecipients
+	srv.AllowInsecureAuth = !l.RequireTLS
+	srv.LMTP = l.Role == RoleLMTP
 	srv.EnableSMTPUTF8 = true
+	srv.EnableBINARYMIME = true // CHUNKING/BDAT is always advertised by go-smtp
+	srv.EnableREQUIRETLS = true // Only advertised once the session is encrypted
+	// Delivery reports come from the queue, which LMTP bypasses
+	srv.EnableDSN = l.Role != RoleLMTP
 
-	// Optional TLS
-	if s.tlsCfg != nil {
+	// STARTTLS configuration; implicit TLS listeners wrap the socket instead
+	if s.tlsCfg != nil && !l.ImplicitTLS() {
 		srv.TLSConfig = s.tlsCfg
 	}
 
 	return srv
 }
 
-// createSMTPSServer creates port 465 SMTPS server (implicit TLS)
-func (s *Server) createSMTPSServer() *smtp.Server {
-	srv := smtp.NewServer(s.backend)
-	srv.Addr = fmt.Sprintf(":%d", s.cfg.SMTPSPort)
-	srv.Domain = s.cfg.Hostname
-	srv.ReadTimeout = 30 * time.Second
-	srv.WriteTimeout = 30 * time.Second
-	srv.MaxMessageBytes = int64(s.cfg.MaxMessageSize)
-	srv.MaxRecipients = 100
-	srv.AllowInsecureAuth = true // Allow AUTH for testing
-	srv.EnableSMTPUTF8 = true
-
-	return srv
-}
-
-// Start starts all SMTP servers
+// Start binds every listener address and starts serving
 func (s *Server) Start(ctx context.Context) error {
 	ctx, s.cancel = context.WithCancel(ctx)
 
-	// Start submission server (587)
-	s.wg.Add(1)
-	go func() {
-		defer s.wg.Done()
-		s.logger.Info("starting SMTP submission server",
-			zap.Int("port", s.cfg.SubmissionPort),
-			zap.String("hostname", s.cfg.Hostname),
-		)
-		if err := s.submission.ListenAndServe(); err != nil && ctx.Err() == nil {
-			s.logger.Error("submission server error", zap.Error(err))
+	for _, ls := range s.listeners {
+		l := ls.listener
+		if l.ImplicitTLS() && s.tlsCfg == nil {
+			s.logger.Warn("skipping SMTP listener - implicit TLS requires a certificate",
+				zap.String("listener", l.Name),
+			)
+			continue
 		}
-	}()
 
-	// Start relay server (25)
-	s.wg.Add(1)
-	go func() {
-		defer s.wg.Done()
-		s.logger.Info("starting SMTP relay server",
-			zap.Int("port", s.cfg.RelayPort),
-			zap.String("hostname", s.cfg.Hostname),
-		)
-		if err := s.relay.ListenAndServe(); err != nil && ctx.Err() == nil {
-			s.logger.Error("relay server error", zap.Error(err))
-		}
-	}()
+		for _, addr := range l.Addresses {
+			ln, err := listen(addr, l.SocketMode)
+			if err != nil {
+				return fmt.Errorf("failed to start SMTP listener %s on %s: %w", l.Name, addr, err)
+			}
+			// The PROXY header precedes the TLS handshake
+			if l.ProxyProtocol && s.proxy != nil {
+				ln = s.proxy.Listen(ln)
+			}
+			if l.ImplicitTLS() {
+				ln = tls.NewListener(ln, s.tlsCfg)
+			}
 
-	// Start SMTPS server (465) with implicit TLS
-	if s.tlsCfg != nil {
-		s.wg.Add(1)
-		go func() {
-			defer s.wg.Done()
-			s.logger.Info("starting SMTPS server",
-				zap.Int("port", s.cfg.SMTPSPort),
+			s.logger.Info("starting SMTP listener",
+				zap.String("listener", l.Name),
+				zap.String("role", string(l.Role)),
+				zap.String("address", addr),
 				zap.String("hostname", s.cfg.Hostname),
+				zap.Bool("proxy_protocol", l.ProxyProtocol),
 			)
 
-			ln, err := net.Listen("tcp", s.smtps.Addr)
-			if err != nil {
-				s.logger.Error("failed to start SMTPS listener", zap.Error(err))
-				return
-			}
-
-			tlsListener := tls.NewListener(ln, s.tlsCfg)
-			if err := s.smtps.Serve(tlsListener); err != nil && ctx.Err() == nil {
-				s.logger.Error("SMTPS server error", zap.Error(err))
-			}
-		}()
+			s.wg.Add(1)
+			go func(srv *smtp.Server, ln net.Listener, name string) {
+				defer s.wg.Done()
+				if err := srv.Serve(ln); err != nil && ctx.Err() == nil {
+					s.logger.Error("SMTP listener error", zap.String("listener", name), zap.Error(err))
+				}
+			}(ls.srv, ln, l.Name)
+		}
 	}
 
-	s.logger.Info("SMTP servers started",
-		zap.Int("submission_port", s.cfg.SubmissionPort),
-		zap.Int("relay_port", s.cfg.RelayPort),
-		zap.Int("smtps_port", s.cfg.SMTPSPort),
-	)
+	s.logger.Info("SMTP servers started", zap.Int("listeners", len(s.listeners)))
 
 	return nil
 }
 
+// listen binds a TCP address, or a Unix socket for addresses prefixed with "unix:"
+func listen(addr string, mode os.FileMode) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	// A socket left behind by an unclean shutdown would make the bind fail
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
 // Shutdown performs graceful shutdown
 func (s *Server) Shutdown(ctx context.Context) error {
 	s.logger.Info("shutting down SMTP servers")
@@ -180,19 +171,14 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	go func() {
 		defer close(shutdownDone)
 
-		if err := s.submission.Shutdown(shutdownCtx); err != nil {
-			s.logger.Warn("submission server shutdown error", zap.Error(err))
-			shutdownErr = err
-		}
-
-		if err := s.relay.Shutdown(shutdownCtx); err != nil {
-			s.logger.Warn("relay server shutdown error", zap.Error(err))
-			shutdownErr = err
-		}
-
-		if err := s.smtps.Shutdown(shutdownCtx); err != nil {
-			s.logger.Warn("SMTPS server shutdown error", zap.Error(err))
-			shutdownErr = err
+		for _, ls := range s.listeners {
+			if err := ls.srv.Shutdown(shutdownCtx); err != nil {
+				s.logger.Warn("SMTP listener shutdown error",
+					zap.String("listener", ls.listener.Name),
+					zap.Error(err),
+				)
+				shutdownErr = err
+			}
 		}
 	}()
 
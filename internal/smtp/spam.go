@@ -0,0 +1,146 @@
+package smtp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-smtp"
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/security/antispam"
+	"github.com/btafoya/gomailserver/internal/security/milter"
+	mailService "github.com/btafoya/gomailserver/internal/service"
+)
+
+// SetRspamd registers the rspamd client used by domains with the rspamd spam backend
+func (b *Backend) SetRspamd(rspamd *antispam.Rspamd) {
+	b.rspamd = rspamd
+}
+
+// checkRspamd scores a message with rspamd and applies the domain's spam policy
+// Score headers are added to the spooled message; the returned reason is
+// non-empty when the message should be quarantined. The verdict is nil when
+// rspamd could not be reached.
+func (s *Session) checkRspamd(sp *mailService.Spool, dom *domain.Domain) (*domain.SpamVerdict, string, error) {
+	meta := &antispam.Metadata{
+		IP:         extractIP(s.remoteAddr),
+		From:       s.from,
+		Recipients: s.to,
+		User:       s.username,
+	}
+	if s.conn != nil {
+		meta.Helo = s.conn.Hostname()
+	}
+
+	result, err := s.backend.rspamd.Check(sp.Reader(), meta)
+	if err != nil {
+		s.logger.Error("rspamd check failed", zap.Error(err))
+		return nil, "", nil
+	}
+	s.logger.Info("rspamd check result",
+		zap.Float64("score", result.Score),
+		zap.String("action", result.Action),
+		zap.String("symbols", spamSymbols(result.Rules, ", ")),
+		zap.String("from", s.from),
+	)
+
+	switch {
+	case result.Action == antispam.ActionReject || result.Score >= dom.SpamRejectScore:
+		s.logger.Info("message rejected as spam",
+			zap.Float64("score", result.Score),
+			zap.Float64("threshold", dom.SpamRejectScore),
+		)
+		return nil, "", &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+			Message:      "Message rejected as spam",
+		}
+	case result.Action == antispam.ActionSoftReject:
+		return nil, "", &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 7, 1},
+			Message:      "Message deferred, try again later",
+		}
+	case result.Action == antispam.ActionGreylist:
+		return nil, "", &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 7, 1},
+			Message:      "Greylisted - please try again later",
+		}
+	}
+
+	fields, _, err := readHeaderFields(sp.Reader())
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := s.applyModifications(sp, spamHeaders(result, fields)); err != nil {
+		return nil, "", err
+	}
+
+	verdict := spamVerdict(result)
+	if dom.SpamQuarantineScore > 0 && result.Score >= dom.SpamQuarantineScore {
+		return verdict, fmt.Sprintf("quarantined as spam (score %.2f): %s", result.Score, spamSymbols(result.Rules, ", ")), nil
+	}
+	return verdict, "", nil
+}
+
+// spamVerdict converts a spam check result to the verdict stored with the message
+func spamVerdict(result *antispam.SpamResult) *domain.SpamVerdict {
+	verdict := &domain.SpamVerdict{
+		Score:     result.Score,
+		Threshold: result.Threshold,
+		Action:    result.Action,
+	}
+	for _, r := range result.Rules {
+		verdict.Symbols = append(verdict.Symbols, domain.SpamSymbol{Name: r.Name, Score: r.Score, Description: r.Description})
+	}
+	return verdict
+}
+
+// spamHeaders returns the header changes recording a spam verdict
+// X-Spam headers among the message's fields are removed first, so a sender
+// cannot supply a verdict of their own, and the score headers go to the top
+// of the message.
+func spamHeaders(result *antispam.SpamResult, fields []headerField) []milter.Modification {
+	var mods []milter.Modification
+	for _, f := range fields {
+		if isSpamHeader(f.name) {
+			// Each change removes the first remaining field of that name
+			mods = append(mods, milter.Modification{Type: milter.ModChangeHeader, Index: 1, Name: f.name})
+		}
+	}
+
+	mods = append(mods,
+		milter.Modification{Type: milter.ModInsertHeader, Index: 0, Name: "X-Spam-Score", Value: fmt.Sprintf("%.2f / %.2f", result.Score, result.Threshold)},
+		milter.Modification{Type: milter.ModInsertHeader, Index: 1, Name: "X-Spam-Action", Value: result.Action},
+	)
+	if len(result.Rules) > 0 {
+		mods = append(mods, milter.Modification{Type: milter.ModInsertHeader, Index: 2, Name: "X-Spam-Symbols", Value: spamSymbols(result.Rules, ",\n\t")})
+	}
+
+	switch result.Action {
+	case antispam.ActionAddHeader:
+		mods = append(mods, milter.Modification{Type: milter.ModInsertHeader, Index: 0, Name: "X-Spam", Value: "Yes"})
+	case antispam.ActionRewriteSubject:
+		if result.Subject != "" {
+			mods = append(mods, milter.Modification{Type: milter.ModChangeHeader, Index: 1, Name: "Subject", Value: result.Subject})
+		}
+	}
+	return mods
+}
+
+// isSpamHeader reports whether a header field name is X-Spam or X-Spam-*
+func isSpamHeader(name string) bool {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return name == "x-spam" || strings.HasPrefix(name, "x-spam-")
+}
+
+// spamSymbols formats matched rules as NAME(score) joined by sep
+func spamSymbols(rules []antispam.SpamRule, sep string) string {
+	symbols := make([]string, len(rules))
+	for i, r := range rules {
+		symbols[i] = fmt.Sprintf("%s(%.2f)", r.Name, r.Score)
+	}
+	return strings.Join(symbols, sep)
+}
@@ -0,0 +1,143 @@
+package smtp
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"go.uber.org/zap"
+
+	"github.com/btafoya/gomailserver/internal/domain"
+	"github.com/btafoya/gomailserver/internal/security/antispam"
+	"github.com/btafoya/gomailserver/internal/service"
+)
+
+// rspamdDomainRepository serves a domain that scores mail with rspamd
+type rspamdDomainRepository struct {
+	mockDomainRepository
+}
+
+func (m *rspamdDomainRepository) GetByName(name string) (*domain.Domain, error) {
+	return &domain.Domain{
+		Name:                name,
+		Status:              "active",
+		SpamEnabled:         true,
+		SpamBackend:         domain.SpamBackendRspamd,
+		SpamRejectScore:     15,
+		SpamQuarantineScore: 6,
+	}, nil
+}
+
+// rspamdSession returns an inbound session scored by an rspamd replying with reply
+func rspamdSession(t *testing.T, reply string, queueSvc service.QueueServiceInterface) *Session {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, reply)
+	}))
+	t.Cleanup(srv.Close)
+
+	logger := zap.NewNop()
+	backend := &Backend{
+		userService:    &mockUserService{},
+		messageService: &mockMessageService{},
+		queueService:   queueSvc,
+		submission:     testSubmission(queueSvc),
+		domainRepo:     &rspamdDomainRepository{},
+		logger:         logger,
+	}
+	backend.SetRspamd(antispam.NewRspamd(srv.URL, "", "", time.Second))
+
+	s := &Session{
+		listener:   testListener(RoleMX),
+		backend:    backend,
+		logger:     logger,
+		remoteAddr: "192.0.2.1:4000",
+	}
+	s.Mail("sender@example.net", nil)
+	s.Rcpt("user@example.com", nil)
+	return s
+}
+
+func TestSession_Rspamd(t *testing.T) {
+	t.Run("adds headers and quarantines", func(t *testing.T) {
+		var opts *service.EnqueueOptions
+		queueSvc := &recordingQueueService{onEnqueueFile: func(o *service.EnqueueOptions) { opts = o }}
+		var queued []byte
+		queueSvc.enqueueFunc = func(sender string, to []string, message []byte) (string, error) {
+			queued = message
+			return "id", nil
+		}
+		s := rspamdSession(t, `{"score": 8.2, "required_score": 15, "action": "rewrite subject", "subject": "[SPAM] Hello",
+			"symbols": {"BAYES_SPAM": {"name": "BAYES_SPAM", "score": 5.1}, "R_SPF_FAIL": {"name": "R_SPF_FAIL", "score": 3.1}}}`, queueSvc)
+
+		msg := "X-Spam-Score: -10\r\nSubject: Hello\r\nX-Spam: No\r\nx-spam-status: No,\r\n\tscore=-10\r\n\r\nBody\r\n"
+		if err := s.Data(strings.NewReader(msg)); err != nil {
+			t.Fatalf("DATA failed: %v", err)
+		}
+
+		// Verdict headers supplied by the sender are replaced by rspamd's
+		want := "X-Spam-Score: 8.20 / 15.00\r\nX-Spam-Action: rewrite subject\r\n" +
+			"X-Spam-Symbols: BAYES_SPAM(5.10),\r\n\tR_SPF_FAIL(3.10)\r\nSubject: [SPAM] Hello\r\n\r\nBody\r\n"
+		if string(queued) != want {
+			t.Errorf("unexpected message:\n%q\nwant:\n%q", queued, want)
+		}
+		if opts == nil || !strings.Contains(opts.HoldReason, "BAYES_SPAM(5.10)") {
+			t.Errorf("expected message to be held with its symbols, got %+v", opts)
+		}
+		if opts == nil || opts.Spam == nil || opts.Spam.Score != 8.2 || len(opts.Spam.Symbols) != 2 || opts.Spam.Symbols[0].Name != "BAYES_SPAM" {
+			t.Errorf("expected the spam verdict to be kept with the message, got %+v", opts)
+		}
+	})
+
+	t.Run("adds spam header below quarantine score", func(t *testing.T) {
+		var opts *service.EnqueueOptions
+		queueSvc := &recordingQueueService{onEnqueueFile: func(o *service.EnqueueOptions) { opts = o }}
+		var queued []byte
+		queueSvc.enqueueFunc = func(sender string, to []string, message []byte) (string, error) {
+			queued = message
+			return "id", nil
+		}
+		s := rspamdSession(t, `{"score": 4, "required_score": 15, "action": "add header"}`, queueSvc)
+
+		if err := s.Data(strings.NewReader("Subject: Hello\r\n\r\nBody\r\n")); err != nil {
+			t.Fatalf("DATA failed: %v", err)
+		}
+		if !strings.HasPrefix(string(queued), "X-Spam: Yes\r\nX-Spam-Score: 4.00 / 15.00\r\n") {
+			t.Errorf("expected spam header, got %q", queued)
+		}
+		if opts == nil || opts.HoldReason != "" {
+			t.Errorf("expected message to be delivered, got %+v", opts)
+		}
+	})
+
+	tests := []struct {
+		name  string
+		reply string
+		code  int
+	}{
+		{"rejects by action", `{"score": 3, "action": "reject"}`, 550},
+		{"rejects by domain score", `{"score": 16, "action": "add header"}`, 550},
+		{"soft rejects", `{"score": 3, "action": "soft reject"}`, 451},
+		{"greylists", `{"score": 3, "action": "greylist"}`, 451},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queueSvc := &mockQueueService{enqueueFunc: func(string, []string, []byte) (string, error) {
+				t.Error("expected message not to be queued")
+				return "id", nil
+			}}
+			s := rspamdSession(t, tt.reply, queueSvc)
+
+			err := s.Data(strings.NewReader("Subject: Hello\r\n\r\nBody\r\n"))
+			var smtpErr *smtp.SMTPError
+			if !errors.As(err, &smtpErr) || smtpErr.Code != tt.code {
+				t.Errorf("expected %d, got %v", tt.code, err)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/btafoya/gomailserver/internal/domain"
 	"github.com/btafoya/gomailserver/internal/repository"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
@@ -15,8 +16,15 @@ type contextKey string
 
 const UserIDKey contextKey = "user_id"
 
+// AppPasswordVerifier checks app-specific passwords for DAV clients
+type AppPasswordVerifier interface {
+	Required(user *domain.User) bool
+	Verify(user *domain.User, password, scope string) bool
+}
+
 // BasicAuthMiddleware provides HTTP Basic Authentication for WebDAV endpoints
-func BasicAuthMiddleware(userRepo repository.UserRepository, logger *zap.Logger) func(http.Handler) http.Handler {
+// appPasswords may be nil, in which case only account passwords are accepted.
+func BasicAuthMiddleware(userRepo repository.UserRepository, appPasswords AppPasswordVerifier, logger *zap.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
@@ -74,8 +82,16 @@ func BasicAuthMiddleware(userRepo repository.UserRepository, logger *zap.Logger)
 				return
 			}
 
-			// Verify password
-			if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+			// Verify app password, then account password unless only app passwords are allowed
+			switch {
+			case appPasswords != nil && appPasswords.Verify(user, password, domain.AppPasswordScopeDAV):
+			case appPasswords != nil && appPasswords.Required(user):
+				logger.Warn("WebDAV authentication failed - app password required",
+					zap.String("username", username),
+				)
+				requestAuth(w)
+				return
+			case bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil:
 				logger.Warn("WebDAV authentication failed - invalid password",
 					zap.String("username", username),
 				)
@@ -36,9 +36,24 @@ func (m *mockUserRepository) List(domainID int64, offset, limit int) ([]*domain.
 	return nil, nil
 }
 func (m *mockUserRepository) UpdateQuota(userID, usedQuota int64) error      { return nil }
+func (m *mockUserRepository) ReconcileQuota() (map[int64]int64, error)        { return nil, nil }
 func (m *mockUserRepository) UpdatePassword(userID int64, passwordHash string) error { return nil }
+func (m *mockUserRepository) UpdateSCRAMCredentials(userID int64, credentials string) error {
+	return nil
+}
 func (m *mockUserRepository) ListAll() ([]*domain.User, error)                         { return nil, nil }
 
+// mockAppPasswords is a test double for AppPasswordVerifier
+type mockAppPasswords struct {
+	password string
+}
+
+func (m *mockAppPasswords) Required(user *domain.User) bool { return user.TOTPEnabled }
+
+func (m *mockAppPasswords) Verify(user *domain.User, password, scope string) bool {
+	return scope == domain.AppPasswordScopeDAV && password == m.password
+}
+
 func TestBasicAuthMiddleware(t *testing.T) {
 	logger := zap.NewNop()
 
@@ -60,7 +75,7 @@ func TestBasicAuthMiddleware(t *testing.T) {
 			},
 		}
 
-		middleware := BasicAuthMiddleware(repo, logger)
+		middleware := BasicAuthMiddleware(repo, nil, logger)
 		handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Verify user ID was added to context
 			userID, ok := GetUserID(r)
@@ -87,7 +102,7 @@ func TestBasicAuthMiddleware(t *testing.T) {
 
 	t.Run("missing authorization header", func(t *testing.T) {
 		repo := &mockUserRepository{}
-		middleware := BasicAuthMiddleware(repo, logger)
+		middleware := BasicAuthMiddleware(repo, nil, logger)
 		handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			t.Error("handler should not be called without auth")
 		}))
@@ -106,7 +121,7 @@ func TestBasicAuthMiddleware(t *testing.T) {
 
 	t.Run("non-Basic auth scheme", func(t *testing.T) {
 		repo := &mockUserRepository{}
-		middleware := BasicAuthMiddleware(repo, logger)
+		middleware := BasicAuthMiddleware(repo, nil, logger)
 		handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			t.Error("handler should not be called with wrong auth scheme")
 		}))
@@ -123,7 +138,7 @@ func TestBasicAuthMiddleware(t *testing.T) {
 
 	t.Run("invalid base64 encoding", func(t *testing.T) {
 		repo := &mockUserRepository{}
-		middleware := BasicAuthMiddleware(repo, logger)
+		middleware := BasicAuthMiddleware(repo, nil, logger)
 		handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			t.Error("handler should not be called with invalid encoding")
 		}))
@@ -140,7 +155,7 @@ func TestBasicAuthMiddleware(t *testing.T) {
 
 	t.Run("invalid credentials format", func(t *testing.T) {
 		repo := &mockUserRepository{}
-		middleware := BasicAuthMiddleware(repo, logger)
+		middleware := BasicAuthMiddleware(repo, nil, logger)
 		handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			t.Error("handler should not be called with invalid format")
 		}))
@@ -164,7 +179,7 @@ func TestBasicAuthMiddleware(t *testing.T) {
 			},
 		}
 
-		middleware := BasicAuthMiddleware(repo, logger)
+		middleware := BasicAuthMiddleware(repo, nil, logger)
 		handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			t.Error("handler should not be called when user not found")
 		}))
@@ -195,7 +210,7 @@ func TestBasicAuthMiddleware(t *testing.T) {
 			},
 		}
 
-		middleware := BasicAuthMiddleware(repo, logger)
+		middleware := BasicAuthMiddleware(repo, nil, logger)
 		handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			t.Error("handler should not be called with wrong password")
 		}))
@@ -226,7 +241,7 @@ func TestBasicAuthMiddleware(t *testing.T) {
 			},
 		}
 
-		middleware := BasicAuthMiddleware(repo, logger)
+		middleware := BasicAuthMiddleware(repo, nil, logger)
 		handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// For this test, the middleware doesn't check user status yet
 			// but we might add it in the future
@@ -242,6 +257,39 @@ func TestBasicAuthMiddleware(t *testing.T) {
 		// Currently the middleware doesn't check status, so this passes
 		// If we add status checking later, update this test
 	})
+
+	t.Run("app passwords", func(t *testing.T) {
+		repo := &mockUserRepository{
+			getByEmailFunc: func(email string) (*domain.User, error) {
+				return &domain.User{
+					ID:           1,
+					Email:        "user@example.com",
+					PasswordHash: string(validPasswordHash),
+					Status:       "active",
+					TOTPEnabled:  true,
+				}, nil
+			},
+		}
+		middleware := BasicAuthMiddleware(repo, &mockAppPasswords{password: "abcd-efgh-jkmn-pqrs"}, logger)
+		handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		for password, want := range map[string]int{
+			"abcd-efgh-jkmn-pqrs": http.StatusOK,
+			"correct-password":    http.StatusUnauthorized, // account password refused once TOTP is on
+		} {
+			req := httptest.NewRequest("GET", "/caldav/", nil)
+			credentials := base64.StdEncoding.EncodeToString([]byte("user@example.com:" + password))
+			req.Header.Set("Authorization", "Basic "+credentials)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != want {
+				t.Errorf("password %q: expected status %d, got %d", password, want, rr.Code)
+			}
+		}
+	})
 }
 
 func TestGetUserID(t *testing.T) {
@@ -3,9 +3,11 @@ package webdav
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"time"
 
+	"github.com/btafoya/gomailserver/internal/proxyproto"
 	"github.com/btafoya/gomailserver/internal/repository"
 	"go.uber.org/zap"
 )
@@ -24,14 +26,15 @@ type Config struct {
 	Port         int
 	ReadTimeout  int
 	WriteTimeout int
+	Proxy        *proxyproto.Policy // reads PROXY headers from trusted balancers when set
 }
 
 // NewServer creates a new WebDAV server with CalDAV and CardDAV handlers
-func NewServer(cfg *Config, caldavHandler, carddavHandler http.Handler, userRepo repository.UserRepository, logger *zap.Logger) *Server {
+func NewServer(cfg *Config, caldavHandler, carddavHandler http.Handler, userRepo repository.UserRepository, appPasswords AppPasswordVerifier, logger *zap.Logger) *Server {
 	mux := http.NewServeMux()
 
 	// Create authentication middleware
-	authMiddleware := BasicAuthMiddleware(userRepo, logger)
+	authMiddleware := BasicAuthMiddleware(userRepo, appPasswords, logger)
 
 	// CalDAV endpoints with authentication
 	mux.Handle("/caldav/", authMiddleware(caldavHandler))
@@ -68,11 +71,20 @@ func NewServer(cfg *Config, caldavHandler, carddavHandler http.Handler, userRepo
 func (s *Server) Start(ctx context.Context) error {
 	s.logger.Info("starting WebDAV server",
 		zap.Int("port", s.config.Port),
+		zap.Bool("proxy_protocol", s.config.Proxy != nil),
 	)
 
+	ln, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to start WebDAV listener: %w", err)
+	}
+	if s.config.Proxy != nil {
+		ln = s.config.Proxy.Listen(ln)
+	}
+
 	// Start server in goroutine
 	go func() {
-		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := s.httpServer.Serve(ln); err != nil && err != http.ErrServerClosed {
 			s.logger.Error("WebDAV server error", zap.Error(err))
 		}
 	}()